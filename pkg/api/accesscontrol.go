@@ -2,7 +2,9 @@ package api
 
 import (
 	"fmt"
+	"net/http"
 
+	"github.com/grafana/grafana/pkg/api/response"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/plugins"
 	ac "github.com/grafana/grafana/pkg/services/accesscontrol"
@@ -433,6 +435,54 @@ func (hs *HTTPServer) declareFixedRoles() error {
 	)
 }
 
+// swagger:route GET /access-control/user/permissions/trace access_control getUserPermissionsTrace
+//
+// Explain whether the signed in user is granted an action on a scope.
+//
+// Lists the permissions of the user on the action, the roles granting them and
+// which one grants access.
+//
+// Responses:
+// 200: getUserPermissionsTraceResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 500: internalServerError
+func (hs *HTTPServer) GetUserPermissionsTrace(c *models.ReqContext) response.Response {
+	action := c.Query("action")
+	if action == "" {
+		return response.Error(http.StatusBadRequest, "action is required", nil)
+	}
+
+	var evaluator ac.Evaluator
+	if scope := c.Query("scope"); scope != "" {
+		evaluator = ac.EvalPermission(action, scope)
+	} else {
+		evaluator = ac.EvalPermission(action)
+	}
+
+	trace, err := hs.AccessControl.EvaluateWithTrace(c.Req.Context(), c.SignedInUser, evaluator)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to trace permission evaluation", err)
+	}
+	return response.JSON(http.StatusOK, trace)
+}
+
+// swagger:parameters getUserPermissionsTrace
+type GetUserPermissionsTraceParams struct {
+	// in:query
+	// required:true
+	Action string `json:"action"`
+	// in:query
+	// required:false
+	Scope string `json:"scope"`
+}
+
+// swagger:response getUserPermissionsTraceResponse
+type GetUserPermissionsTraceResponse struct {
+	// in:body
+	Body *ac.EvalTrace `json:"body"`
+}
+
 // Metadata helpers
 // getAccessControlMetadata returns the accesscontrol metadata associated with a given resource
 func (hs *HTTPServer) getAccessControlMetadata(c *models.ReqContext,
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ac "github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/accesscontrol/actest"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+func TestAPIEndpoint_GetUserPermissionsTrace(t *testing.T) {
+	sc := setupHTTPServerWithCfg(t, true, setting.NewCfg())
+	setInitCtxSignedInViewer(sc.initCtx)
+
+	expected := &ac.EvalTrace{
+		Allowed: true,
+		Rules:   []ac.EvalTraceRule{{Role: "basic:viewer", Action: "dashboards:read", Scope: "dashboards:*", Matched: true}},
+	}
+	expected.Decisive = &expected.Rules[0]
+	sc.hs.AccessControl = actest.FakeAccessControl{ExpectedTrace: expected}
+
+	t.Run("returns the trace of the signed in user", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodGet, "/api/access-control/user/permissions/trace?action=dashboards:read&scope=dashboards:uid:1", nil, t)
+		require.Equal(t, http.StatusOK, response.Code)
+
+		var trace ac.EvalTrace
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &trace))
+		assert.Equal(t, *expected, trace)
+	})
+
+	t.Run("requires an action", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodGet, "/api/access-control/user/permissions/trace", nil, t)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
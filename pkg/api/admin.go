@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/grafana/grafana/pkg/api/response"
+	"github.com/grafana/grafana/pkg/cuectx"
 	"github.com/grafana/grafana/pkg/models"
 	ac "github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/user"
@@ -54,6 +55,13 @@ func (hs *HTTPServer) AdminGetStats(c *models.ReqContext) response.Response {
 	return response.JSON(http.StatusOK, statsQuery.Result)
 }
 
+// AdminGetCUEContextStats returns build diagnostics for Grafana's central CUE context.
+//
+// GET /api/debug/cue/stats
+func (hs *HTTPServer) AdminGetCUEContextStats(c *models.ReqContext) response.Response {
+	return response.JSON(http.StatusOK, cuectx.GatherCUEContextStats(cuectx.GrafanaCUEContext()))
+}
+
 func (hs *HTTPServer) getAuthorizedSettings(ctx context.Context, user *user.SignedInUser, bag setting.SettingsBag) (setting.SettingsBag, error) {
 	if hs.AccessControl.IsDisabled() {
 		return bag, nil
@@ -61,7 +61,7 @@ func (hs *HTTPServer) registerRoutes() {
 	reqCanAccessTeams := middleware.AdminOrEditorAndFeatureEnabled(hs.Cfg.EditorsCanAdmin)
 	reqSnapshotPublicModeOrSignedIn := middleware.SnapshotPublicModeOrSignedIn(hs.Cfg)
 	redirectFromLegacyPanelEditURL := middleware.RedirectFromLegacyPanelEditURL(hs.Cfg)
-	authorize := ac.Middleware(hs.AccessControl)
+	authorize := ac.MiddlewareWithTracer(hs.AccessControl, hs.tracer)
 	authorizeInOrg := ac.AuthorizeInOrgMiddleware(hs.AccessControl, hs.accesscontrolService, hs.userService)
 	quota := middleware.Quota(hs.QuotaService)
 
@@ -204,6 +204,9 @@ func (hs *HTTPServer) registerRoutes() {
 
 	// authed api
 	r.Group("/api", func(apiRoute routing.RouteRegister) {
+		// permission trace of the signed in user
+		apiRoute.Get("/access-control/user/permissions/trace", reqSignedIn, routing.Wrap(hs.GetUserPermissionsTrace))
+
 		// user (signed in)
 		apiRoute.Group("/user", func(userRoute routing.RouteRegister) {
 			userRoute.Get("/", routing.Wrap(hs.GetSignedInUser))
@@ -230,6 +233,8 @@ func (hs *HTTPServer) registerRoutes() {
 			userRoute.Get("/preferences", routing.Wrap(hs.GetUserPreferences))
 			userRoute.Put("/preferences", routing.Wrap(hs.UpdateUserPreferences))
 			userRoute.Patch("/preferences", routing.Wrap(hs.PatchUserPreferences))
+			userRoute.Get("/preferences/history", routing.Wrap(hs.GetUserPreferencesHistory))
+			userRoute.Post("/preferences/rollback", routing.Wrap(hs.RollbackUserPreferences))
 
 			userRoute.Get("/auth-tokens", routing.Wrap(hs.GetUserAuthTokens))
 			userRoute.Post("/revoke-auth-token", routing.Wrap(hs.RevokeUserAuthToken))
@@ -311,6 +316,9 @@ func (hs *HTTPServer) registerRoutes() {
 			orgRoute.Get("/preferences", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionOrgsPreferencesRead)), routing.Wrap(hs.GetOrgPreferences))
 			orgRoute.Put("/preferences", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionOrgsPreferencesWrite)), routing.Wrap(hs.UpdateOrgPreferences))
 			orgRoute.Patch("/preferences", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionOrgsPreferencesWrite)), routing.Wrap(hs.PatchOrgPreferences))
+			orgRoute.Post("/preferences/bulk", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionOrgsPreferencesWrite)), routing.Wrap(hs.BulkSetOrgPreferences))
+			orgRoute.Get("/preferences/export", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionOrgsPreferencesRead)), hs.ExportOrgPreferences)
+			orgRoute.Put("/theme", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionOrgsPreferencesWrite)), routing.Wrap(hs.SetOrgThemePolicy))
 		})
 
 		// current org without requirement of user to be org admin
@@ -348,6 +356,8 @@ func (hs *HTTPServer) registerRoutes() {
 			orgsRoute.Patch("/users/:userId", authorizeInOrg(reqGrafanaAdmin, ac.UseOrgFromContextParams, ac.EvalPermission(ac.ActionOrgUsersWrite, userIDScope)), routing.Wrap(hs.UpdateOrgUser))
 			orgsRoute.Delete("/users/:userId", authorizeInOrg(reqGrafanaAdmin, ac.UseOrgFromContextParams, ac.EvalPermission(ac.ActionOrgUsersRemove, userIDScope)), routing.Wrap(hs.RemoveOrgUser))
 			orgsRoute.Get("/quotas", authorizeInOrg(reqGrafanaAdmin, ac.UseOrgFromContextParams, ac.EvalPermission(ac.ActionOrgsQuotasRead)), routing.Wrap(hs.GetOrgQuotas))
+			orgsRoute.Get("/quotas/api-key", authorizeInOrg(reqGrafanaAdmin, ac.UseOrgFromContextParams, ac.EvalPermission(ac.ActionOrgsQuotasRead)), routing.Wrap(hs.GetOrgAPIKeyQuota))
+			orgsRoute.Put("/quotas/api-key", authorizeInOrg(reqGrafanaAdmin, ac.UseOrgFromContextParams, ac.EvalPermission(ac.ActionOrgsQuotasWrite)), routing.Wrap(hs.UpdateOrgAPIKeyQuota))
 			orgsRoute.Put("/quotas/:target", authorizeInOrg(reqGrafanaAdmin, ac.UseOrgFromContextParams, ac.EvalPermission(ac.ActionOrgsQuotasWrite)), routing.Wrap(hs.UpdateOrgQuota))
 		})
 
@@ -359,12 +369,31 @@ func (hs *HTTPServer) registerRoutes() {
 			apikeyIDScope := ac.Scope("apikeys", "id", ac.Parameter(":id"))
 			keysRoute.Get("/", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyRead)), routing.Wrap(hs.GetAPIKeys))
 			keysRoute.Post("/", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyCreate)), quota("api_key"), routing.Wrap(hs.AddAPIKey))
+			keysRoute.Post("/cleanup", reqGrafanaAdmin, routing.Wrap(hs.CleanupExpiredAPIKeys))
+			keysRoute.Post("/validate", reqGrafanaAdmin, routing.Wrap(hs.ValidateAPIKeys))
 			keysRoute.Delete("/:id", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyDelete, apikeyIDScope)), routing.Wrap(hs.DeleteAPIKey))
+			keysRoute.Post("/:id/confirm", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyCreate)), routing.Wrap(hs.ConfirmAPIKey))
+		})
+
+		// auth api key pools
+		apiRoute.Group("/auth/keypools", func(poolsRoute routing.RouteRegister) {
+			poolsRoute.Get("/", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyRead)), routing.Wrap(hs.GetAPIKeyPools))
+			poolsRoute.Post("/", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyCreate)), routing.Wrap(hs.AddAPIKeyPool))
+			poolsRoute.Get("/:id", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyRead)), routing.Wrap(hs.GetAPIKeyPool))
+			poolsRoute.Put("/:id", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyCreate)), routing.Wrap(hs.UpdateAPIKeyPool))
+			poolsRoute.Delete("/:id", authorize(reqOrgAdmin, ac.EvalPermission(ac.ActionAPIKeyDelete)), routing.Wrap(hs.DeleteAPIKeyPool))
 		})
 
 		// Preferences
 		apiRoute.Group("/preferences", func(prefRoute routing.RouteRegister) {
 			prefRoute.Post("/set-home-dash", routing.Wrap(hs.SetHomeDashboard))
+			prefRoute.Get("/presets", reqGrafanaAdmin, routing.Wrap(hs.ListPreferencesPresets))
+			prefRoute.Post("/presets", reqGrafanaAdmin, routing.Wrap(hs.CreatePreferencesPreset))
+			prefRoute.Get("/presets/:presetId", reqGrafanaAdmin, routing.Wrap(hs.GetPreferencesPreset))
+			prefRoute.Put("/presets/:presetId", reqGrafanaAdmin, routing.Wrap(hs.UpdatePreferencesPreset))
+			prefRoute.Delete("/presets/:presetId", reqGrafanaAdmin, routing.Wrap(hs.DeletePreferencesPreset))
+			prefRoute.Post("/presets/:presetId/apply", reqGrafanaAdmin, routing.Wrap(hs.ApplyPreferencesPreset))
+			prefRoute.Post("/import/file", reqGrafanaAdmin, routing.Wrap(hs.ImportPreferencesFromFile))
 		})
 
 		// Data sources
@@ -647,6 +676,9 @@ func (hs *HTTPServer) registerRoutes() {
 		adminRoute.Get("/ldap/status", authorize(reqGrafanaAdmin, ac.EvalPermission(ac.ActionLDAPStatusRead)), routing.Wrap(hs.GetLDAPStatus))
 	})
 
+	// debug api
+	r.Get("/api/debug/cue/stats", reqGrafanaAdmin, routing.Wrap(hs.AdminGetCUEContextStats))
+
 	// Administering users
 	r.Group("/api/admin/users", func(adminUserRoute routing.RouteRegister) {
 		userIDScope := ac.Scope("global.users", "id", ac.Parameter(":id"))
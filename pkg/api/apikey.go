@@ -2,13 +2,13 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/grafana/grafana/pkg/api/dtos"
 	"github.com/grafana/grafana/pkg/api/response"
-	"github.com/grafana/grafana/pkg/components/apikeygen"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/apikey"
 	"github.com/grafana/grafana/pkg/web"
@@ -127,16 +127,13 @@ func (hs *HTTPServer) AddAPIKey(c *models.ReqContext) response.Response {
 
 	cmd.OrgId = c.OrgID
 
-	newKeyInfo, err := apikeygen.New(cmd.OrgId, cmd.Name)
-	if err != nil {
-		return response.Error(500, "Generating API key failed", err)
-	}
-
-	cmd.Key = newKeyInfo.HashedKey
 	if err := hs.apiKeyService.AddAPIKey(c.Req.Context(), &cmd); err != nil {
 		if errors.Is(err, apikey.ErrInvalidExpiration) {
 			return response.Error(400, err.Error(), nil)
 		}
+		if errors.Is(err, apikey.ErrInvalidCIDR) || errors.Is(err, apikey.ErrInvalidScope) {
+			return response.Error(400, err.Error(), nil)
+		}
 		if errors.Is(err, apikey.ErrDuplicate) {
 			return response.Error(409, err.Error(), nil)
 		}
@@ -146,12 +143,255 @@ func (hs *HTTPServer) AddAPIKey(c *models.ReqContext) response.Response {
 	result := &dtos.NewApiKeyResult{
 		ID:   cmd.Result.Id,
 		Name: cmd.Result.Name,
-		Key:  newKeyInfo.ClientSecret,
+		Key:  string(cmd.Token),
 	}
 
 	return response.JSON(http.StatusOK, result)
 }
 
+// swagger:route POST /auth/keys/{id}/confirm api_keys confirmAPIkey
+//
+// Confirm the creation of an API key.
+//
+// Activates an API key created with a creation secret. The key cannot be used until its creation is confirmed, and is deleted if it is not confirmed within an hour.
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) ConfirmAPIKey(c *models.ReqContext) response.Response {
+	id, err := strconv.ParseInt(web.Params(c.Req)[":id"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "id is invalid", err)
+	}
+
+	cmd := apikey.ConfirmCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	cmd.KeyID, cmd.OrgID = id, c.OrgID
+
+	if err := hs.apiKeyService.ConfirmAPIKeyCreation(c.Req.Context(), &cmd); err != nil {
+		switch {
+		case errors.Is(err, apikey.ErrInvalid):
+			return response.Error(http.StatusNotFound, "API key not found", nil)
+		case errors.Is(err, apikey.ErrCreationSecretMismatch), errors.Is(err, apikey.ErrCreationConfirmationExpired), errors.Is(err, apikey.ErrTooManyConfirmationAttempts):
+			return response.Error(http.StatusForbidden, err.Error(), nil)
+		default:
+			return response.Error(http.StatusInternalServerError, "Failed to confirm API key", err)
+		}
+	}
+	return response.Success("API key confirmed")
+}
+
+// swagger:route POST /auth/keys/cleanup api_keys cleanupAPIkeys
+//
+// Clean up expired API keys.
+//
+// Deletes the API keys that expired more than olderThan ago, in one org or in all orgs. With dryRun set, the keys are only counted.
+//
+// Responses:
+// 200: cleanupAPIkeysResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) CleanupExpiredAPIKeys(c *models.ReqContext) response.Response {
+	form := dtos.CleanupAPIKeysForm{}
+	if err := web.Bind(c.Req, &form); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	cmd := &apikey.CleanupCommand{OrgID: form.OrgID, DryRun: form.DryRun}
+	if form.OlderThan != "" {
+		olderThan, err := time.ParseDuration(form.OlderThan)
+		if err != nil || olderThan < 0 {
+			return response.Error(http.StatusBadRequest, "olderThan must be a positive duration", err)
+		}
+		cmd.OlderThan = olderThan
+	}
+
+	result, err := hs.apiKeyService.CleanupExpiredAPIKeys(c.Req.Context(), cmd)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to clean up API keys", err)
+	}
+
+	dto := dtos.CleanupAPIKeysResult{Found: result.Found, Deleted: result.Deleted}
+	for _, err := range result.Errors {
+		dto.Errors = append(dto.Errors, err.Error())
+	}
+	return response.JSON(http.StatusOK, dto)
+}
+
+// swagger:route POST /auth/keys/validate api_keys validateAPIkeys
+//
+// Validate API keys.
+//
+// Checks up to 100 API key tokens in one call, returning a result for each token in the order given. Checking a token does not count as using the key.
+//
+// Responses:
+// 200: validateAPIkeysResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) ValidateAPIKeys(c *models.ReqContext) response.Response {
+	form := dtos.ValidateAPIKeysForm{}
+	if err := web.Bind(c.Req, &form); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	results, err := hs.apiKeyService.ValidateAPIKeys(c.Req.Context(), form.Tokens)
+	if err != nil {
+		if errors.Is(err, apikey.ErrTooManyTokens) {
+			return response.Error(http.StatusBadRequest, fmt.Sprintf("at most %d tokens can be validated at once", apikey.MaxValidateTokens), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to validate API keys", err)
+	}
+	return response.JSON(http.StatusOK, results)
+}
+
+// swagger:route GET /auth/keypools api_keys getAPIkeyPools
+//
+// Get API key pools.
+//
+// Responses:
+// 200: getAPIkeyPoolsResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) GetAPIKeyPools(c *models.ReqContext) response.Response {
+	pools, err := hs.apiKeyService.ListAPIKeyPools(c.Req.Context(), c.OrgID)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to list API key pools", err)
+	}
+	return response.JSON(http.StatusOK, pools)
+}
+
+// swagger:route GET /auth/keypools/{id} api_keys getAPIkeyPool
+//
+// Get API key pool.
+//
+// Responses:
+// 200: apiKeyPoolResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) GetAPIKeyPool(c *models.ReqContext) response.Response {
+	id, err := strconv.ParseInt(web.Params(c.Req)[":id"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "id is invalid", err)
+	}
+
+	pool, err := hs.apiKeyService.GetAPIKeyPool(c.Req.Context(), c.OrgID, id)
+	if err != nil {
+		return apiKeyPoolErrorResponse(err, "Failed to get API key pool")
+	}
+	return response.JSON(http.StatusOK, pool)
+}
+
+// swagger:route POST /auth/keypools api_keys addAPIkeyPool
+//
+// Creates an API key pool.
+//
+// A pool is a named set of interchangeable API keys of the org, with a quota shared by its keys.
+//
+// Responses:
+// 200: apiKeyPoolResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) AddAPIKeyPool(c *models.ReqContext) response.Response {
+	cmd := apikey.CreatePoolCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	cmd.OrgID = c.OrgID
+
+	pool, err := hs.apiKeyService.CreateAPIKeyPool(c.Req.Context(), &cmd)
+	if err != nil {
+		return apiKeyPoolErrorResponse(err, "Failed to add API key pool")
+	}
+	return response.JSON(http.StatusOK, pool)
+}
+
+// swagger:route PUT /auth/keypools/{id} api_keys updateAPIkeyPool
+//
+// Updates an API key pool.
+//
+// Replaces the name, keys and quota of the pool. Keys that stay in the pool keep their usage.
+//
+// Responses:
+// 200: apiKeyPoolResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) UpdateAPIKeyPool(c *models.ReqContext) response.Response {
+	id, err := strconv.ParseInt(web.Params(c.Req)[":id"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "id is invalid", err)
+	}
+
+	cmd := apikey.UpdatePoolCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	cmd.ID = id
+	cmd.OrgID = c.OrgID
+
+	pool, err := hs.apiKeyService.UpdateAPIKeyPool(c.Req.Context(), &cmd)
+	if err != nil {
+		return apiKeyPoolErrorResponse(err, "Failed to update API key pool")
+	}
+	return response.JSON(http.StatusOK, pool)
+}
+
+// swagger:route DELETE /auth/keypools/{id} api_keys deleteAPIkeyPool
+//
+// Delete API key pool.
+//
+// Deletes the pool. Its keys are not deleted.
+//
+// Responses:
+// 200: okResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) DeleteAPIKeyPool(c *models.ReqContext) response.Response {
+	id, err := strconv.ParseInt(web.Params(c.Req)[":id"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "id is invalid", err)
+	}
+
+	if err := hs.apiKeyService.DeleteAPIKeyPool(c.Req.Context(), c.OrgID, id); err != nil {
+		return apiKeyPoolErrorResponse(err, "Failed to delete API key pool")
+	}
+	return response.Success("API key pool deleted")
+}
+
+func apiKeyPoolErrorResponse(err error, message string) response.Response {
+	switch {
+	case errors.Is(err, apikey.ErrPoolNotFound):
+		return response.Error(http.StatusNotFound, err.Error(), nil)
+	case errors.Is(err, apikey.ErrInvalidPoolMember):
+		return response.Error(http.StatusBadRequest, err.Error(), nil)
+	case errors.Is(err, apikey.ErrDuplicate):
+		return response.Error(http.StatusConflict, "API key pool, organization ID and name must be unique", nil)
+	default:
+		return response.Error(http.StatusInternalServerError, message, err)
+	}
+}
+
 // swagger:parameters getAPIkeys
 type GetAPIkeysParams struct {
 	// Show expired keys
@@ -175,6 +415,16 @@ type DeleteAPIkeyParams struct {
 	ID int64 `json:"id"`
 }
 
+// swagger:parameters confirmAPIkey
+type ConfirmAPIkeyParams struct {
+	// in:path
+	// required:true
+	ID int64 `json:"id"`
+	// in:body
+	// required:true
+	Body apikey.ConfirmCommand
+}
+
 // swagger:response getAPIkeyResponse
 type GetAPIkeyResponse struct {
 	// The response message
@@ -188,3 +438,65 @@ type PostAPIkeyResponse struct {
 	// in: body
 	Body dtos.NewApiKeyResult `json:"body"`
 }
+
+// swagger:parameters cleanupAPIkeys
+type CleanupAPIkeysParams struct {
+	// in:body
+	// required:true
+	Body dtos.CleanupAPIKeysForm
+}
+
+// swagger:response cleanupAPIkeysResponse
+type CleanupAPIkeysResponse struct {
+	// in: body
+	Body dtos.CleanupAPIKeysResult `json:"body"`
+}
+
+// swagger:parameters validateAPIkeys
+type ValidateAPIkeysParams struct {
+	// in:body
+	// required:true
+	Body dtos.ValidateAPIKeysForm
+}
+
+// swagger:response validateAPIkeysResponse
+type ValidateAPIkeysResponse struct {
+	// in: body
+	Body []apikey.ValidationResult `json:"body"`
+}
+
+// swagger:parameters getAPIkeyPool deleteAPIkeyPool
+type APIkeyPoolIDParams struct {
+	// in:path
+	// required:true
+	ID int64 `json:"id"`
+}
+
+// swagger:parameters addAPIkeyPool
+type AddAPIkeyPoolParams struct {
+	// in:body
+	// required:true
+	Body apikey.CreatePoolCommand
+}
+
+// swagger:parameters updateAPIkeyPool
+type UpdateAPIkeyPoolParams struct {
+	// in:path
+	// required:true
+	ID int64 `json:"id"`
+	// in:body
+	// required:true
+	Body apikey.UpdatePoolCommand
+}
+
+// swagger:response getAPIkeyPoolsResponse
+type GetAPIkeyPoolsResponse struct {
+	// in: body
+	Body []*apikey.Pool `json:"body"`
+}
+
+// swagger:response apiKeyPoolResponse
+type APIkeyPoolResponse struct {
+	// in: body
+	Body *apikey.Pool `json:"body"`
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/web"
+)
+
+// introspectionResponse is the response of the token introspection endpoint,
+// see RFC 7662 section 2.2.
+type introspectionResponse struct {
+	Active bool   `json:"active"`
+	Sub    string `json:"sub,omitempty"`
+	Exp    int64  `json:"exp,omitempty"`
+	Scope  string `json:"scope,omitempty"`
+}
+
+// apiKeyIntrospectionEndpoint lets resource servers introspect API keys as
+// OAuth2 tokens, following RFC 7662. It runs before the context handler, as
+// callers authenticate with the introspection client credentials rather than
+// as a Grafana user.
+func (hs *HTTPServer) apiKeyIntrospectionEndpoint(ctx *web.Context) {
+	if ctx.Req.Method != http.MethodPost || ctx.Req.URL.Path != "/oauth2/introspect" {
+		return
+	}
+	if hs.Cfg.ApiKeyIntrospectionClientID == "" || hs.Cfg.ApiKeyIntrospectionClientSecret == "" {
+		return
+	}
+
+	if !BasicAuthenticatedRequest(ctx.Req, hs.Cfg.ApiKeyIntrospectionClientID, hs.Cfg.ApiKeyIntrospectionClientSecret) {
+		ctx.Resp.Header().Set("WWW-Authenticate", `Basic realm="grafana"`)
+		hs.writeIntrospectionJSON(ctx, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	token := ctx.Req.PostFormValue("token")
+	if token == "" {
+		hs.writeIntrospectionJSON(ctx, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	res, err := hs.introspectAPIKey(ctx.Req, token)
+	if err != nil {
+		hs.log.Error("Failed to introspect API key", "error", err)
+		ctx.Resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	hs.writeIntrospectionJSON(ctx, http.StatusOK, res)
+}
+
+// introspectAPIKey returns an inactive response for tokens that are not
+// valid API keys, without telling why.
+func (hs *HTTPServer) introspectAPIKey(req *http.Request, token string) (*introspectionResponse, error) {
+	results, err := hs.apiKeyService.ValidateAPIKeys(req.Context(), []string{token})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != 1 || !results[0].Valid {
+		return &introspectionResponse{Active: false}, nil
+	}
+
+	query := apikey.GetByIDQuery{ApiKeyId: results[0].TokenID}
+	if err := hs.apiKeyService.GetApiKeyById(req.Context(), &query); err != nil {
+		return nil, err
+	}
+	key := query.Result
+
+	res := &introspectionResponse{
+		Active: true,
+		Sub:    key.Name,
+		Scope:  strings.Join(key.Scopes, " "),
+	}
+	if key.Expires != nil {
+		res.Exp = *key.Expires
+	}
+	if key.ServiceAccountId != nil {
+		sa, err := hs.userService.GetByID(req.Context(), &user.GetUserByIDQuery{ID: *key.ServiceAccountId})
+		if err != nil {
+			return nil, err
+		}
+		res.Sub = sa.Login
+	}
+	return res, nil
+}
+
+func (hs *HTTPServer) writeIntrospectionJSON(ctx *web.Context, status int, body interface{}) {
+	ctx.Resp.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	// responses carry token information and must not be cached, see RFC 7662 section 4
+	ctx.Resp.Header().Set("Cache-Control", "no-store")
+	ctx.Resp.WriteHeader(status)
+	if err := json.NewEncoder(ctx.Resp).Encode(body); err != nil {
+		hs.log.Error("Failed to write to response", "err", err)
+	}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/apikey/apikeytest"
+	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/services/user/usertest"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/grafana/grafana/pkg/web"
+)
+
+func TestAPIKeyIntrospection(t *testing.T) {
+	expires := int64(1700000000)
+	saID := int64(7)
+
+	testCases := []struct {
+		desc         string
+		username     string
+		password     string
+		validation   apikey.ValidationResult
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			desc:         "active key",
+			username:     "resource-server",
+			password:     "secret",
+			validation:   apikey.ValidationResult{TokenID: 1, Valid: true},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"active": true, "sub": "sa-reader", "exp": 1700000000, "scope": "grafana:read:dashboards grafana:read:folders"}`,
+		},
+		{
+			desc:         "expired key",
+			username:     "resource-server",
+			password:     "secret",
+			validation:   apikey.ValidationResult{TokenID: 1, Expired: true, Reason: apikey.ValidationExpired},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"active": false}`,
+		},
+		{
+			desc:         "revoked key",
+			username:     "resource-server",
+			password:     "secret",
+			validation:   apikey.ValidationResult{TokenID: 1, Reason: apikey.ValidationRevoked},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"active": false}`,
+		},
+		{
+			desc:         "unknown key",
+			username:     "resource-server",
+			password:     "secret",
+			validation:   apikey.ValidationResult{Reason: apikey.ValidationNotFound},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"active": false}`,
+		},
+		{
+			desc:         "unauthenticated caller",
+			username:     "resource-server",
+			password:     "wrong",
+			validation:   apikey.ValidationResult{TokenID: 1, Valid: true},
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error": "invalid_client"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			m, _ := setupAPIKeyIntrospectionTestEnvironment(t, &apikeytest.Service{
+				ExpectedValidation: []apikey.ValidationResult{tc.validation},
+				ExpectedAPIKey: &apikey.APIKey{
+					Id:               1,
+					Name:             "reader",
+					Expires:          &expires,
+					ServiceAccountId: &saID,
+					Scopes:           apikey.ScopeList{"grafana:read:dashboards", "grafana:read:folders"},
+				},
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/oauth2/introspect", strings.NewReader(url.Values{"token": {"glsa_token"}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetBasicAuth(tc.username, tc.password)
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.expectedCode, rec.Code)
+			require.JSONEq(t, tc.expectedBody, rec.Body.String())
+			require.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+		})
+	}
+
+	t.Run("missing token", func(t *testing.T) {
+		m, _ := setupAPIKeyIntrospectionTestEnvironment(t, &apikeytest.Service{})
+
+		req := httptest.NewRequest(http.MethodPost, "/oauth2/introspect", nil)
+		req.SetBasicAuth("resource-server", "secret")
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("disabled without client credentials", func(t *testing.T) {
+		m, hs := setupAPIKeyIntrospectionTestEnvironment(t, &apikeytest.Service{})
+		hs.Cfg.ApiKeyIntrospectionClientSecret = ""
+
+		req := httptest.NewRequest(http.MethodPost, "/oauth2/introspect", nil)
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func setupAPIKeyIntrospectionTestEnvironment(t *testing.T, apiKeyService apikey.Service) (*web.Mux, *HTTPServer) {
+	t.Helper()
+
+	cfg := setting.NewCfg()
+	cfg.ApiKeyIntrospectionClientID = "resource-server"
+	cfg.ApiKeyIntrospectionClientSecret = "secret"
+	hs := &HTTPServer{
+		Cfg:           cfg,
+		log:           log.New("test"),
+		apiKeyService: apiKeyService,
+		userService:   &usertest.FakeUserService{ExpectedUser: &user.User{ID: 7, Login: "sa-reader"}},
+	}
+
+	m := web.New()
+	m.Use(hs.apiKeyIntrospectionEndpoint)
+	return m, hs
+}
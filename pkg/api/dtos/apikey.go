@@ -24,3 +24,24 @@ type ApiKeyDTO struct {
 	Expiration    *time.Time             `json:"expiration,omitempty"`
 	AccessControl accesscontrol.Metadata `json:"accessControl,omitempty"`
 }
+
+// CleanupAPIKeysForm selects the expired API keys to clean up. OlderThan is a
+// duration such as "720h"; an omitted OrgID selects keys of all orgs.
+type CleanupAPIKeysForm struct {
+	OrgID     *int64 `json:"orgId,omitempty"`
+	OlderThan string `json:"olderThan"`
+	DryRun    bool   `json:"dryRun"`
+}
+
+// swagger:model
+type CleanupAPIKeysResult struct {
+	Found   int64    `json:"found"`
+	Deleted int64    `json:"deleted"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// ValidateAPIKeysForm lists the API key tokens to validate, as presented for
+// authentication.
+type ValidateAPIKeysForm struct {
+	Tokens []string `json:"tokens"`
+}
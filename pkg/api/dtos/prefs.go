@@ -5,14 +5,18 @@ import (
 )
 
 type Prefs struct {
-	Theme            string                      `json:"theme"`
-	HomeDashboardID  int64                       `json:"homeDashboardId"`
-	HomeDashboardUID string                      `json:"homeDashboardUID,omitempty"`
-	Timezone         string                      `json:"timezone"`
-	WeekStart        string                      `json:"weekStart"`
-	Locale           string                      `json:"locale"`
-	Navbar           pref.NavbarPreference       `json:"navbar,omitempty"`
-	QueryHistory     pref.QueryHistoryPreference `json:"queryHistory,omitempty"`
+	Theme            string `json:"theme"`
+	HomeDashboardID  int64  `json:"homeDashboardId"`
+	HomeDashboardUID string `json:"homeDashboardUID,omitempty"`
+	Timezone         string `json:"timezone"`
+	WeekStart        string `json:"weekStart"`
+	Locale           string `json:"locale"`
+	// DefaultDateFormat and DefaultTimeFormat are the formats of the locale.
+	DefaultDateFormat string                      `json:"defaultDateFormat,omitempty"`
+	DefaultTimeFormat string                      `json:"defaultTimeFormat,omitempty"`
+	Navbar            pref.NavbarPreference       `json:"navbar,omitempty"`
+	QueryHistory      pref.QueryHistoryPreference `json:"queryHistory,omitempty"`
+	Version           int64                       `json:"version"`
 }
 
 // swagger:model
@@ -29,6 +33,9 @@ type UpdatePrefsCmd struct {
 	Navbar       *pref.NavbarPreference       `json:"navbar,omitempty"`
 	QueryHistory *pref.QueryHistoryPreference `json:"queryHistory,omitempty"`
 	Locale       string                       `json:"locale"`
+	// The version of the preferences the update is based on. When set, the
+	// update is rejected with a 409 if the preferences were modified since.
+	Version *int64 `json:"version,omitempty"`
 }
 
 // swagger:model
@@ -46,3 +53,18 @@ type PatchPrefsCmd struct {
 	QueryHistory     *pref.QueryHistoryPreference `json:"queryHistory,omitempty"`
 	HomeDashboardUID *string                      `json:"homeDashboardUID,omitempty"`
 }
+
+// swagger:model
+type RollbackPrefsCmd struct {
+	// The saved version to restore
+	Version int64 `json:"version"`
+}
+
+// swagger:model
+type SetOrgThemePolicyCmd struct {
+	// Enum: enforce,suggest
+	// An empty policy lets users choose their theme.
+	Policy string `json:"policy"`
+	// Enum: light,dark
+	Theme string `json:"theme"`
+}
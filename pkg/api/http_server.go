@@ -607,6 +607,10 @@ func (hs *HTTPServer) addMiddlewaresAndStaticRoutes() {
 	m.Use(hs.pluginMetricsEndpoint)
 	m.Use(hs.frontendLogEndpoints())
 
+	// Callers of the introspection endpoint authenticate as a client rather
+	// than as a user, so it must run before the context handler.
+	m.Use(hs.apiKeyIntrospectionEndpoint)
+
 	m.UseMiddleware(hs.ContextHandler.Middleware)
 	m.Use(middleware.OrgRedirect(hs.Cfg, hs.userService))
 	m.Use(accesscontrol.LoadPermissionsMiddleware(hs.accesscontrolService))
@@ -2,12 +2,18 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/grafana/grafana/pkg/api/dtos"
 	"github.com/grafana/grafana/pkg/api/response"
 	"github.com/grafana/grafana/pkg/models"
 	pref "github.com/grafana/grafana/pkg/services/preference"
+	"github.com/grafana/grafana/pkg/util"
 	"github.com/grafana/grafana/pkg/web"
 )
 
@@ -71,13 +77,17 @@ func (hs *HTTPServer) getPreferencesFor(ctx context.Context, orgID, userID, team
 		return response.Error(500, "Failed to get preferences", err)
 	}
 
+	dto := hs.preferenceToDTO(ctx, orgID, preference)
+	return response.JSON(http.StatusOK, &dto)
+}
+
+func (hs *HTTPServer) preferenceToDTO(ctx context.Context, orgID int64, preference *pref.Preference) dtos.Prefs {
 	var dashboardUID string
 
 	// when homedashboardID is 0, that means it is the default home dashboard, no UID would be returned in the response
 	if preference.HomeDashboardID != 0 {
 		query := models.GetDashboardQuery{Id: preference.HomeDashboardID, OrgId: orgID}
-		err = hs.DashboardService.GetDashboard(ctx, &query)
-		if err == nil {
+		if err := hs.DashboardService.GetDashboard(ctx, &query); err == nil {
 			dashboardUID = query.Result.Uid
 		}
 	}
@@ -88,15 +98,21 @@ func (hs *HTTPServer) getPreferencesFor(ctx context.Context, orgID, userID, team
 		HomeDashboardUID: dashboardUID,
 		Timezone:         preference.Timezone,
 		WeekStart:        preference.WeekStart,
+		Version:          preference.Version,
 	}
 
 	if preference.JSONData != nil {
 		dto.Locale = preference.JSONData.Locale
+		if dto.Locale != "" {
+			formats, _ := pref.LocaleFormats.Lookup(dto.Locale)
+			dto.DefaultDateFormat = formats.DateFormat
+			dto.DefaultTimeFormat = formats.TimeFormat
+		}
 		dto.Navbar = preference.JSONData.Navbar
 		dto.QueryHistory = preference.JSONData.QueryHistory
 	}
 
-	return response.JSON(http.StatusOK, &dto)
+	return dto
 }
 
 // swagger:route PUT /user/preferences user_preferences updateUserPreferences
@@ -109,6 +125,7 @@ func (hs *HTTPServer) getPreferencesFor(ctx context.Context, orgID, userID, team
 // 200: okResponse
 // 400: badRequestError
 // 401: unauthorisedError
+// 409: conflictError
 // 500: internalServerError
 func (hs *HTTPServer) UpdateUserPreferences(c *models.ReqContext) response.Response {
 	dtoCmd := dtos.UpdatePrefsCmd{}
@@ -150,9 +167,20 @@ func (hs *HTTPServer) updatePreferencesFor(ctx context.Context, orgID, userID, t
 		HomeDashboardID: dtoCmd.HomeDashboardID,
 		QueryHistory:    dtoCmd.QueryHistory,
 		Navbar:          dtoCmd.Navbar,
+		ExpectedVersion: dtoCmd.Version,
 	}
 
 	if err := hs.preferenceService.Save(ctx, &saveCmd); err != nil {
+		if errors.Is(err, pref.ErrPreferenceConflict) {
+			current, getErr := hs.preferenceService.Get(ctx, &pref.GetPreferenceQuery{UserID: userID, OrgID: orgID, TeamID: teamId})
+			if getErr != nil {
+				return response.Error(500, "Failed to get preferences", getErr)
+			}
+			return response.JSON(http.StatusConflict, util.DynMap{
+				"message": "Preferences have been modified since they were loaded",
+				"version": current.Version,
+			})
+		}
 		return response.Error(500, "Failed to save preferences", err)
 	}
 
@@ -219,6 +247,67 @@ func (hs *HTTPServer) patchPreferencesFor(ctx context.Context, orgID, userID, te
 	return response.Success("Preferences updated")
 }
 
+// swagger:route GET /user/preferences/history user_preferences getUserPreferencesHistory
+//
+// Get the saved versions of the user preferences, newest first.
+//
+// Responses:
+// 200: getPreferencesHistoryResponse
+// 401: unauthorisedError
+// 500: internalServerError
+func (hs *HTTPServer) GetUserPreferencesHistory(c *models.ReqContext) response.Response {
+	history, err := hs.preferenceService.GetPreferencesHistory(c.Req.Context(), &pref.PreferencesHistoryQuery{
+		OrgID:  c.OrgID,
+		UserID: c.UserID,
+	})
+	if err != nil {
+		return response.Error(500, "Failed to get preferences history", err)
+	}
+
+	result := make([]dtos.Prefs, 0, len(history))
+	for _, p := range history {
+		result = append(result, hs.preferenceToDTO(c.Req.Context(), c.OrgID, p))
+	}
+	return response.JSON(http.StatusOK, result)
+}
+
+// swagger:route POST /user/preferences/rollback user_preferences rollbackUserPreferences
+//
+// Restore a saved version of the user preferences.
+//
+// The restored preferences are saved as a new version.
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 404: notFoundError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) RollbackUserPreferences(c *models.ReqContext) response.Response {
+	dtoCmd := dtos.RollbackPrefsCmd{}
+	if err := web.Bind(c.Req, &dtoCmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	cmd := pref.RollbackPreferencesCommand{
+		OrgID:   c.OrgID,
+		UserID:  c.UserID,
+		Version: dtoCmd.Version,
+	}
+	if err := hs.preferenceService.RollbackPreferences(c.Req.Context(), &cmd); err != nil {
+		switch {
+		case errors.Is(err, pref.ErrPrefNotFound), errors.Is(err, pref.ErrPreferenceVersionNotFound):
+			return response.Error(http.StatusNotFound, "Preferences version not found", err)
+		case errors.Is(err, pref.ErrPreferenceConflict):
+			return response.Error(http.StatusConflict, "Preferences have been modified since they were loaded", err)
+		}
+		return response.Error(500, "Failed to roll back preferences", err)
+	}
+
+	return response.Success("Preferences rolled back")
+}
+
 // swagger:route GET /org/preferences org_preferences getOrgPreferences
 //
 // Get Current Org Prefs.
@@ -241,6 +330,7 @@ func (hs *HTTPServer) GetOrgPreferences(c *models.ReqContext) response.Response
 // 400: badRequestError
 // 401: unauthorisedError
 // 403: forbiddenError
+// 409: conflictError
 // 500: internalServerError
 func (hs *HTTPServer) UpdateOrgPreferences(c *models.ReqContext) response.Response {
 	dtoCmd := dtos.UpdatePrefsCmd{}
@@ -269,6 +359,346 @@ func (hs *HTTPServer) PatchOrgPreferences(c *models.ReqContext) response.Respons
 	return hs.patchPreferencesFor(c.Req.Context(), c.OrgID, 0, 0, &dtoCmd)
 }
 
+// swagger:route POST /org/preferences/bulk org_preferences bulkSetOrgPreferences
+//
+// Set preferences of all users of the current org.
+//
+// Sets the fields listed in the mask in the stored preferences of the users of
+// the org selected by the scope filter, in a single transaction.
+//
+// Responses:
+// 200: bulkSetPreferencesResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) BulkSetOrgPreferences(c *models.ReqContext) response.Response {
+	cmd := pref.BulkSetPreferencesCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	cmd.OrgID = c.OrgID
+
+	result, err := hs.preferenceService.BulkSetPreferences(c.Req.Context(), &cmd)
+	if err != nil {
+		if errors.Is(err, pref.ErrInvalidBulkMask) || errors.Is(err, pref.ErrInvalidBulkScope) {
+			return response.Error(http.StatusBadRequest, err.Error(), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to set preferences", err)
+	}
+	return response.JSON(http.StatusOK, result)
+}
+
+// swagger:route GET /org/preferences/export org_preferences exportOrgPreferences
+//
+// Export the preferences of the current org.
+//
+// Streams the stored preferences of the org, its teams and its users as
+// newline-delimited JSON, one preference per line. The scope parameter limits
+// the export to the given scopes.
+//
+// Produces:
+// - application/x-ndjson
+//
+// Responses:
+// 200: exportOrgPreferencesResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) ExportOrgPreferences(c *models.ReqContext) {
+	scopes := map[pref.PreferencesScope]bool{}
+	for _, param := range c.QueryStrings("scope") {
+		for _, s := range strings.Split(param, ",") {
+			scope := pref.PreferencesScope(strings.TrimSpace(s))
+			if scope != pref.PreferencesScopeOrg && scope != pref.PreferencesScopeTeam && scope != pref.PreferencesScopeUser {
+				c.JsonApiErr(http.StatusBadRequest, fmt.Sprintf("invalid preferences scope %q", s), nil)
+				return
+			}
+			scopes[scope] = true
+		}
+	}
+
+	var opts []pref.ExportOption
+	if len(scopes) > 0 {
+		opts = append(opts, pref.WithExportFilter(func(scope pref.PreferencesScope) bool {
+			return scopes[scope]
+		}))
+	}
+
+	c.Resp.Header().Set("Content-Type", "application/x-ndjson")
+	c.Resp.Header().Set("Cache-Control", "no-cache")
+
+	err := hs.preferenceService.StreamExportPreferences(c.Req.Context(), &pref.ExportPreferencesCommand{OrgID: c.OrgID}, c.Resp, opts...)
+	if err != nil {
+		if !c.Resp.Written() {
+			c.JsonApiErr(http.StatusInternalServerError, "Failed to export preferences", err)
+			return
+		}
+		// The status is already sent, the truncated stream is all the client gets.
+		hs.log.Error("Failed to export preferences", "orgId", c.OrgID, "error", err)
+	}
+}
+
+// swagger:route PUT /org/theme org_preferences setOrgThemePolicy
+//
+// Set the theme policy of the current org.
+//
+// With the enforce policy, users of the org always get the given theme. With
+// the suggest policy, the theme is used for users who did not choose one.
+// An empty policy lets users choose their theme.
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) SetOrgThemePolicy(c *models.ReqContext) response.Response {
+	dtoCmd := dtos.SetOrgThemePolicyCmd{}
+	if err := web.Bind(c.Req, &dtoCmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	if err := hs.preferenceService.SetOrgThemePolicy(c.Req.Context(), c.OrgID, dtoCmd.Policy, dtoCmd.Theme); err != nil {
+		if errors.Is(err, pref.ErrInvalidOrgThemePolicy) {
+			return response.Error(http.StatusBadRequest, err.Error(), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to set org theme policy", err)
+	}
+	return response.Success("Org theme policy updated")
+}
+
+// swagger:route GET /preferences/presets preferences_presets listPreferencesPresets
+//
+// List every version of every preferences preset.
+//
+// Responses:
+// 200: listPreferencesPresetsResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) ListPreferencesPresets(c *models.ReqContext) response.Response {
+	presets, err := hs.preferenceService.ListPreferencesPresets(c.Req.Context())
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to list preferences presets", err)
+	}
+	return response.JSON(http.StatusOK, presets)
+}
+
+// swagger:route POST /preferences/presets preferences_presets createPreferencesPreset
+//
+// Publish a preferences preset.
+//
+// If a preset with the same name exists, the preferences are published as its next version.
+//
+// Responses:
+// 200: preferencesPresetResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) CreatePreferencesPreset(c *models.ReqContext) response.Response {
+	cmd := pref.CreatePresetCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	return hs.createPreferencesPreset(c.Req.Context(), &cmd)
+}
+
+// swagger:route GET /preferences/presets/{preset_id} preferences_presets getPreferencesPreset
+//
+// Get a version of a preferences preset.
+//
+// Responses:
+// 200: preferencesPresetResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) GetPreferencesPreset(c *models.ReqContext) response.Response {
+	presetID, err := strconv.ParseInt(web.Params(c.Req)[":presetId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "presetId is invalid", err)
+	}
+
+	preset, err := hs.preferenceService.GetPreferencesPreset(c.Req.Context(), presetID)
+	if err != nil {
+		return presetErrorResponse(err, "Failed to get preferences preset")
+	}
+	return response.JSON(http.StatusOK, preset)
+}
+
+// swagger:route PUT /preferences/presets/{preset_id} preferences_presets updatePreferencesPreset
+//
+// Publish new preferences as the next version of a preferences preset.
+//
+// Versions are immutable, the updated preset is returned with a new ID.
+//
+// Responses:
+// 200: preferencesPresetResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) UpdatePreferencesPreset(c *models.ReqContext) response.Response {
+	presetID, err := strconv.ParseInt(web.Params(c.Req)[":presetId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "presetId is invalid", err)
+	}
+	values := pref.PresetPreferences{}
+	if err := web.Bind(c.Req, &values); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	preset, err := hs.preferenceService.GetPreferencesPreset(c.Req.Context(), presetID)
+	if err != nil {
+		return presetErrorResponse(err, "Failed to get preferences preset")
+	}
+	return hs.createPreferencesPreset(c.Req.Context(), &pref.CreatePresetCommand{Name: preset.Name, Preferences: values})
+}
+
+func (hs *HTTPServer) createPreferencesPreset(ctx context.Context, cmd *pref.CreatePresetCommand) response.Response {
+	if theme := cmd.Preferences.Theme; theme != lightTheme && theme != darkTheme && theme != defaultTheme {
+		return response.Error(http.StatusBadRequest, "Invalid theme", nil)
+	}
+
+	preset, err := hs.preferenceService.CreatePreferencesPreset(ctx, cmd)
+	if err != nil {
+		if errors.Is(err, pref.ErrPresetNameRequired) {
+			return response.Error(http.StatusBadRequest, "Preset name is required", err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to create preferences preset", err)
+	}
+	return response.JSON(http.StatusOK, preset)
+}
+
+// swagger:route DELETE /preferences/presets/{preset_id} preferences_presets deletePreferencesPreset
+//
+// Delete a version of a preferences preset.
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) DeletePreferencesPreset(c *models.ReqContext) response.Response {
+	presetID, err := strconv.ParseInt(web.Params(c.Req)[":presetId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "presetId is invalid", err)
+	}
+
+	if err := hs.preferenceService.DeletePreferencesPreset(c.Req.Context(), presetID); err != nil {
+		return presetErrorResponse(err, "Failed to delete preferences preset")
+	}
+	return response.Success("Preferences preset deleted")
+}
+
+// swagger:route POST /preferences/presets/{preset_id}/apply preferences_presets applyPreferencesPreset
+//
+// Overwrite the preferences of an org with a preferences preset.
+//
+// The preset is applied to the current org unless an org ID is given.
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) ApplyPreferencesPreset(c *models.ReqContext) response.Response {
+	presetID, err := strconv.ParseInt(web.Params(c.Req)[":presetId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "presetId is invalid", err)
+	}
+	cmd := pref.ApplyPresetCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	cmd.PresetID = presetID
+	if cmd.OrgID == 0 {
+		cmd.OrgID = c.OrgID
+	}
+
+	if err := hs.preferenceService.ApplyPreferencesPreset(c.Req.Context(), &cmd); err != nil {
+		return presetErrorResponse(err, "Failed to apply preferences preset")
+	}
+	return response.Success("Preferences preset applied")
+}
+
+// maxPreferencesFileSize bounds the size of the files accepted by
+// ImportPreferencesFromFile.
+const maxPreferencesFileSize = 1 << 20
+
+// swagger:route POST /preferences/import/file preferences importPreferencesFromFile
+//
+// Import preferences from a YAML or INI file.
+//
+// The file is uploaded as the file field of a multipart form. Its preferences replace those of the org given by the orgId field, the current org by default, or of a team or user of the org if the teamId or userId field is set. With the dryRun field set to true, the file is only validated. Keys that are not preferences are reported as warnings.
+//
+// Consumes:
+// - multipart/form-data
+//
+// Responses:
+// 200: importPreferencesResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) ImportPreferencesFromFile(c *models.ReqContext) response.Response {
+	c.Req.Body = http.MaxBytesReader(c.Resp, c.Req.Body, maxPreferencesFileSize+1<<10)
+	if err := c.Req.ParseMultipartForm(maxPreferencesFileSize); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	file, header, err := c.Req.FormFile("file")
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "file is required", err)
+	}
+	defer func() { _ = file.Close() }()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "failed to read file", err)
+	}
+
+	cmd := pref.ImportFromFileCommand{OrgID: c.OrgID, Filename: header.Filename, Data: data}
+	for _, id := range []struct {
+		field  string
+		target *int64
+	}{{"orgId", &cmd.OrgID}, {"teamId", &cmd.TeamID}, {"userId", &cmd.UserID}} {
+		if value := c.Req.FormValue(id.field); value != "" {
+			if *id.target, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return response.Error(http.StatusBadRequest, id.field+" is invalid", err)
+			}
+		}
+	}
+	if value := c.Req.FormValue("dryRun"); value != "" {
+		if cmd.DryRun, err = strconv.ParseBool(value); err != nil {
+			return response.Error(http.StatusBadRequest, "dryRun is invalid", err)
+		}
+	}
+
+	result, err := hs.preferenceService.ImportPreferencesFromFile(c.Req.Context(), &cmd)
+	if err != nil {
+		if errors.Is(err, pref.ErrUnsupportedImportFormat) || errors.Is(err, pref.ErrInvalidImportFile) {
+			return response.Error(http.StatusBadRequest, err.Error(), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to import preferences", err)
+	}
+	return response.JSON(http.StatusOK, result)
+}
+
+func presetErrorResponse(err error, message string) response.Response {
+	if errors.Is(err, pref.ErrPresetNotFound) {
+		return response.Error(http.StatusNotFound, "Preferences preset not found", err)
+	}
+	return response.Error(http.StatusInternalServerError, message, err)
+}
+
 // swagger:parameters  updateUserPreferences
 type UpdateUserPreferencesParams struct {
 	// in:body
@@ -289,6 +719,19 @@ type GetPreferencesResponse struct {
 	Body dtos.Prefs `json:"body"`
 }
 
+// swagger:response getPreferencesHistoryResponse
+type GetPreferencesHistoryResponse struct {
+	// in:body
+	Body []dtos.Prefs `json:"body"`
+}
+
+// swagger:parameters rollbackUserPreferences
+type RollbackUserPreferencesParams struct {
+	// in:body
+	// required:true
+	Body dtos.RollbackPrefsCmd `json:"body"`
+}
+
 // swagger:parameters patchUserPreferences
 type PatchUserPreferencesParams struct {
 	// in:body
@@ -302,3 +745,90 @@ type PatchOrgPreferencesParams struct {
 	// required:true
 	Body dtos.PatchPrefsCmd `json:"body"`
 }
+
+// swagger:parameters bulkSetOrgPreferences
+type BulkSetOrgPreferencesParams struct {
+	// in:body
+	// required:true
+	Body pref.BulkSetPreferencesCommand `json:"body"`
+}
+
+// swagger:parameters exportOrgPreferences
+type ExportOrgPreferencesParams struct {
+	// Comma-separated scopes to export: org, team or user. Defaults to all.
+	// in:query
+	// required:false
+	Scope []string `json:"scope"`
+}
+
+// swagger:response exportOrgPreferencesResponse
+type ExportOrgPreferencesResponse struct {
+	// One exported preference per line.
+	// in:body
+	Body []pref.ExportedPreference `json:"body"`
+}
+
+// swagger:parameters setOrgThemePolicy
+type SetOrgThemePolicyParams struct {
+	// in:body
+	// required:true
+	Body dtos.SetOrgThemePolicyCmd `json:"body"`
+}
+
+// swagger:response bulkSetPreferencesResponse
+type BulkSetPreferencesResponse struct {
+	// in:body
+	Body pref.BulkSetResult `json:"body"`
+}
+
+// swagger:parameters getPreferencesPreset deletePreferencesPreset
+type PreferencesPresetParams struct {
+	// in:path
+	// required:true
+	PresetID int64 `json:"preset_id"`
+}
+
+// swagger:parameters createPreferencesPreset
+type CreatePreferencesPresetParams struct {
+	// in:body
+	// required:true
+	Body pref.CreatePresetCommand `json:"body"`
+}
+
+// swagger:parameters updatePreferencesPreset
+type UpdatePreferencesPresetParams struct {
+	// in:path
+	// required:true
+	PresetID int64 `json:"preset_id"`
+	// in:body
+	// required:true
+	Body pref.PresetPreferences `json:"body"`
+}
+
+// swagger:parameters applyPreferencesPreset
+type ApplyPreferencesPresetParams struct {
+	// in:path
+	// required:true
+	PresetID int64 `json:"preset_id"`
+	// in:body
+	// required:true
+	Body pref.ApplyPresetCommand `json:"body"`
+}
+
+// swagger:response preferencesPresetResponse
+type PreferencesPresetResponse struct {
+	// in:body
+	Body pref.Preset `json:"body"`
+}
+
+// swagger:response listPreferencesPresetsResponse
+type ListPreferencesPresetsResponse struct {
+	// in:body
+	Body []pref.Preset `json:"body"`
+}
+
+// swagger:response importPreferencesResponse
+type ImportPreferencesResponse struct {
+	// in:body
+	Body pref.ImportResult `json:"body"`
+}
@@ -1,9 +1,14 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
@@ -205,3 +210,266 @@ func TestAPIEndpoint_PatchOrgPreferences(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, response.Code)
 	})
 }
+
+func TestAPIEndpoint_UserPreferencesHistory(t *testing.T) {
+	cfg := setting.NewCfg()
+	cfg.RBACEnabled = false
+	sc := setupHTTPServerWithCfg(t, true, cfg)
+
+	prefService := preftest.NewPreferenceServiceFake()
+	prefService.ExpectedPreferencesHistory = []*pref.Preference{{Theme: "light", Version: 1}, {Theme: "dark", Version: 0}}
+	sc.hs.preferenceService = prefService
+
+	_, err := sc.db.CreateOrgWithMember("TestOrg", testUserID)
+	require.NoError(t, err)
+
+	setInitCtxSignedInViewer(sc.initCtx)
+	t.Run("Returns the saved versions", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodGet, "/api/user/preferences/history", nil, t)
+		require.Equal(t, http.StatusOK, response.Code)
+		var resp []map[string]interface{}
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &resp))
+		require.Len(t, resp, 2)
+		assert.Equal(t, "light", resp[0]["theme"])
+		assert.EqualValues(t, 0, resp[1]["version"])
+	})
+
+	t.Run("Returns 200 on rollback", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodPost, "/api/user/preferences/rollback", strings.NewReader(`{"version": 0}`), t)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("Returns 404 when rolling back to an unknown version", func(t *testing.T) {
+		prefService.ExpectedError = pref.ErrPreferenceVersionNotFound
+		response := callAPI(sc.server, http.MethodPost, "/api/user/preferences/rollback", strings.NewReader(`{"version": 7}`), t)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
+
+func TestAPIEndpoint_PreferencesPresets(t *testing.T) {
+	cfg := setting.NewCfg()
+	cfg.RBACEnabled = false
+	sc := setupHTTPServerWithCfg(t, true, cfg)
+
+	prefService := preftest.NewPreferenceServiceFake()
+	prefService.ExpectedPreset = &pref.Preset{ID: 1, Name: "corporate", Version: 2, Preferences: &pref.PresetPreferences{Theme: "dark"}}
+	sc.hs.preferenceService = prefService
+
+	setInitCtxSignedInOrgAdmin(sc.initCtx)
+	t.Run("Org Admin cannot manage presets", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodGet, "/api/preferences/presets", nil, t)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+		response = callAPI(sc.server, http.MethodPost, "/api/preferences/presets/1/apply", strings.NewReader(`{"orgId": 2}`), t)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	sc.initCtx.SignedInUser.IsGrafanaAdmin = true
+	t.Run("Grafana Admin can publish a preset", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodPost, "/api/preferences/presets", strings.NewReader(`{"name": "corporate", "preferences": {"theme": "dark"}}`), t)
+		require.Equal(t, http.StatusOK, response.Code)
+		var resp map[string]interface{}
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &resp))
+		assert.Equal(t, "corporate", resp["name"])
+		assert.EqualValues(t, 2, resp["version"])
+	})
+
+	t.Run("Returns 400 on an invalid theme", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodPost, "/api/preferences/presets", strings.NewReader(`{"name": "corporate", "preferences": {"theme": "pink"}}`), t)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Grafana Admin can apply a preset", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodPost, "/api/preferences/presets/1/apply", strings.NewReader(`{"orgId": 2}`), t)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("Returns 404 when applying an unknown preset", func(t *testing.T) {
+		prefService.ExpectedError = pref.ErrPresetNotFound
+		response := callAPI(sc.server, http.MethodPost, "/api/preferences/presets/7/apply", strings.NewReader(`{"orgId": 2}`), t)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
+
+func TestAPIEndpoint_BulkSetOrgPreferences(t *testing.T) {
+	cfg := setting.NewCfg()
+	cfg.RBACEnabled = false
+	sc := setupHTTPServerWithCfg(t, true, cfg)
+
+	prefService := preftest.NewPreferenceServiceFake()
+	prefService.ExpectedBulkSetResult = &pref.BulkSetResult{Updated: 3}
+	sc.hs.preferenceService = prefService
+
+	body := `{"fields": {"theme": "dark"}, "mask": ["theme"], "scopeFilter": "all_users"}`
+	setInitCtxSignedInViewer(sc.initCtx)
+	t.Run("Viewer cannot bulk set preferences", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodPost, "/api/org/preferences/bulk", strings.NewReader(body), t)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	setInitCtxSignedInOrgAdmin(sc.initCtx)
+	t.Run("Org Admin can bulk set preferences", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodPost, "/api/org/preferences/bulk", strings.NewReader(body), t)
+		require.Equal(t, http.StatusOK, response.Code)
+		var resp map[string]interface{}
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &resp))
+		assert.EqualValues(t, 3, resp["updated"])
+	})
+
+	t.Run("Returns 400 on an invalid mask", func(t *testing.T) {
+		prefService.ExpectedError = pref.ErrInvalidBulkMask
+		response := callAPI(sc.server, http.MethodPost, "/api/org/preferences/bulk", strings.NewReader(`{"mask": ["navbar"]}`), t)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestAPIEndpoint_ExportOrgPreferences(t *testing.T) {
+	cfg := setting.NewCfg()
+	cfg.RBACEnabled = false
+	sc := setupHTTPServerWithCfg(t, true, cfg)
+
+	prefService := preftest.NewPreferenceServiceFake()
+	prefService.ExpectedExport = []*pref.ExportedPreference{
+		{Scope: pref.PreferencesScopeOrg, OrgID: 1, Theme: "dark"},
+		{Scope: pref.PreferencesScopeUser, OrgID: 1, UserID: 2, Theme: "light"},
+	}
+	sc.hs.preferenceService = prefService
+
+	setInitCtxSignedInViewer(sc.initCtx)
+	t.Run("Viewer cannot export preferences", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodGet, "/api/org/preferences/export", nil, t)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	setInitCtxSignedInOrgAdmin(sc.initCtx)
+	t.Run("Org Admin can export preferences", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodGet, "/api/org/preferences/export", nil, t)
+		require.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "application/x-ndjson", response.Header().Get("Content-Type"))
+
+		lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+		require.Len(t, lines, 2)
+		var exported pref.ExportedPreference
+		require.NoError(t, json.Unmarshal([]byte(lines[1]), &exported))
+		assert.Equal(t, pref.PreferencesScopeUser, exported.Scope)
+		assert.EqualValues(t, 2, exported.UserID)
+	})
+
+	t.Run("Returns 400 on an unknown scope", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodGet, "/api/org/preferences/export?scope=user,dashboard", nil, t)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Returns 500 when the export fails before streaming", func(t *testing.T) {
+		prefService.ExpectedError = errors.New("boom")
+		response := callAPI(sc.server, http.MethodGet, "/api/org/preferences/export?scope=user", nil, t)
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+	})
+}
+
+func TestAPIEndpoint_SetOrgThemePolicy(t *testing.T) {
+	cfg := setting.NewCfg()
+	cfg.RBACEnabled = false
+	sc := setupHTTPServerWithCfg(t, true, cfg)
+
+	prefService := preftest.NewPreferenceServiceFake()
+	sc.hs.preferenceService = prefService
+
+	body := `{"policy": "enforce", "theme": "dark"}`
+	setInitCtxSignedInViewer(sc.initCtx)
+	t.Run("Viewer cannot set the org theme policy", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodPut, "/api/org/theme", strings.NewReader(body), t)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	setInitCtxSignedInOrgAdmin(sc.initCtx)
+	t.Run("Org Admin can set the org theme policy", func(t *testing.T) {
+		response := callAPI(sc.server, http.MethodPut, "/api/org/theme", strings.NewReader(body), t)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("Returns 400 on an invalid policy", func(t *testing.T) {
+		prefService.ExpectedError = pref.ErrInvalidOrgThemePolicy
+		response := callAPI(sc.server, http.MethodPut, "/api/org/theme", strings.NewReader(`{"policy": "always"}`), t)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+type importPreferencesServiceFake struct {
+	*preftest.FakePreferenceService
+	cmd *pref.ImportFromFileCommand
+}
+
+func (f *importPreferencesServiceFake) ImportPreferencesFromFile(ctx context.Context, cmd *pref.ImportFromFileCommand) (*pref.ImportResult, error) {
+	f.cmd = cmd
+	return f.FakePreferenceService.ImportPreferencesFromFile(ctx, cmd)
+}
+
+func TestAPIEndpoint_ImportPreferencesFromFile(t *testing.T) {
+	cfg := setting.NewCfg()
+	cfg.RBACEnabled = false
+	sc := setupHTTPServerWithCfg(t, true, cfg)
+
+	prefService := &importPreferencesServiceFake{FakePreferenceService: preftest.NewPreferenceServiceFake()}
+	prefService.ExpectedImportResult = &pref.ImportResult{Imported: []string{"theme"}, Warnings: []string{`unknown key "colour" was ignored`}, DryRun: true}
+	sc.hs.preferenceService = prefService
+
+	upload := func(t *testing.T, fields map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		part, err := w.CreateFormFile("file", "preferences.yaml")
+		require.NoError(t, err)
+		_, err = part.Write([]byte("theme: dark\ncolour: blue\n"))
+		require.NoError(t, err)
+		for field, value := range fields {
+			require.NoError(t, w.WriteField(field, value))
+		}
+		require.NoError(t, w.Close())
+
+		req, err := http.NewRequest(http.MethodPost, "/api/preferences/import/file", &body)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		recorder := httptest.NewRecorder()
+		sc.server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	setInitCtxSignedInOrgAdmin(sc.initCtx)
+	t.Run("Org Admin cannot import preferences", func(t *testing.T) {
+		response := upload(t, nil)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	sc.initCtx.SignedInUser.IsGrafanaAdmin = true
+	t.Run("Grafana Admin can import preferences", func(t *testing.T) {
+		response := upload(t, map[string]string{"orgId": "2", "teamId": "3", "dryRun": "true"})
+		require.Equal(t, http.StatusOK, response.Code)
+		var resp pref.ImportResult
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &resp))
+		assert.Equal(t, *prefService.ExpectedImportResult, resp)
+
+		assert.Equal(t, "preferences.yaml", prefService.cmd.Filename)
+		assert.Equal(t, "theme: dark\ncolour: blue\n", string(prefService.cmd.Data))
+		assert.Equal(t, int64(2), prefService.cmd.OrgID)
+		assert.Equal(t, int64(3), prefService.cmd.TeamID)
+		assert.True(t, prefService.cmd.DryRun)
+	})
+
+	t.Run("Defaults to the current org", func(t *testing.T) {
+		response := upload(t, nil)
+		require.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, sc.initCtx.OrgID, prefService.cmd.OrgID)
+		assert.False(t, prefService.cmd.DryRun)
+	})
+
+	t.Run("Returns 400 on an invalid field", func(t *testing.T) {
+		response := upload(t, map[string]string{"dryRun": "maybe"})
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Returns 400 on an invalid file", func(t *testing.T) {
+		prefService.ExpectedError = pref.ErrInvalidImportFile
+		response := upload(t, nil)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
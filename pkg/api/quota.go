@@ -6,6 +6,7 @@ import (
 
 	"github.com/grafana/grafana/pkg/api/response"
 	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/apikey"
 	"github.com/grafana/grafana/pkg/setting"
 	"github.com/grafana/grafana/pkg/web"
 )
@@ -87,6 +88,65 @@ func (hs *HTTPServer) UpdateOrgQuota(c *models.ReqContext) response.Response {
 	return response.Success("Organization quota updated")
 }
 
+// swagger:route GET /orgs/{org_id}/quotas/api-key orgs getOrgAPIKeyQuota
+//
+// Fetch the API key quota of an organization.
+//
+// Returns the override of the organization if one is set, otherwise the org default from the configuration.
+//
+// Responses:
+// 200: getOrgAPIKeyQuotaResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) GetOrgAPIKeyQuota(c *models.ReqContext) response.Response {
+	if !hs.Cfg.Quota.Enabled {
+		return response.Error(404, "Quotas not enabled", nil)
+	}
+	orgID, err := strconv.ParseInt(web.Params(c.Req)[":orgId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "orgId is invalid", err)
+	}
+
+	limit, err := hs.apiKeyService.GetOrgAPIKeyQuota(c.Req.Context(), orgID)
+	if err != nil {
+		return response.Error(500, "Failed to get API key quota", err)
+	}
+	return response.JSON(http.StatusOK, apikey.OrgQuotaDTO{OrgID: orgID, Limit: limit})
+}
+
+// swagger:route PUT /orgs/{org_id}/quotas/api-key orgs updateOrgAPIKeyQuota
+//
+// Override the API key quota of an organization.
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) UpdateOrgAPIKeyQuota(c *models.ReqContext) response.Response {
+	cmd := apikey.OrgQuotaDTO{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	if !hs.Cfg.Quota.Enabled {
+		return response.Error(404, "Quotas not enabled", nil)
+	}
+	orgID, err := strconv.ParseInt(web.Params(c.Req)[":orgId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "orgId is invalid", err)
+	}
+
+	if err := hs.apiKeyService.SetOrgAPIKeyQuota(c.Req.Context(), orgID, cmd.Limit); err != nil {
+		return response.Error(500, "Failed to update API key quota", err)
+	}
+	return response.Success("Organization API key quota updated")
+}
+
 // swagger:route GET /admin/users/{user_id}/quotas admin_users getUserQuota
 //
 // Fetch user quota.
@@ -212,6 +272,29 @@ type UpdateOrgQuotaParam struct {
 	OrgID int64 `json:"org_id"`
 }
 
+// swagger:parameters getOrgAPIKeyQuota
+type GetOrgAPIKeyQuotaParams struct {
+	// in:path
+	// required:true
+	OrgID int64 `json:"org_id"`
+}
+
+// swagger:parameters updateOrgAPIKeyQuota
+type UpdateOrgAPIKeyQuotaParams struct {
+	// in:body
+	// required:true
+	Body apikey.OrgQuotaDTO `json:"body"`
+	// in:path
+	// required:true
+	OrgID int64 `json:"org_id"`
+}
+
+// swagger:response getOrgAPIKeyQuotaResponse
+type GetOrgAPIKeyQuotaResponse struct {
+	// in:body
+	Body apikey.OrgQuotaDTO `json:"body"`
+}
+
 // swagger:response getQuotaResponse
 type GetQuotaResponseResponse struct {
 	// in:body
@@ -279,6 +279,7 @@ func (hs *HTTPServer) GetTeamPreferences(c *models.ReqContext) response.Response
 // 200: okResponse
 // 400: badRequestError
 // 401: unauthorisedError
+// 409: conflictError
 // 500: internalServerError
 func (hs *HTTPServer) UpdateTeamPreferences(c *models.ReqContext) response.Response {
 	dtoCmd := dtos.UpdatePrefsCmd{}
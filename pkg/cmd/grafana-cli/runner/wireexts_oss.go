@@ -52,8 +52,9 @@ var wireExtsSet = wire.NewSet(
 	wire.Bind(new(models.UserTokenService), new(*auth.UserAuthTokenService)),
 	wire.Bind(new(models.UserTokenBackgroundService), new(*auth.UserAuthTokenService)),
 	acimpl.ProvideService,
-	wire.Bind(new(accesscontrol.Service), new(*acimpl.Service)),
+	acimpl.ProvideBatchingService,
 	wire.Bind(new(accesscontrol.RoleRegistry), new(*acimpl.Service)),
+	wire.Bind(new(acimpl.DenyRuleLister), new(*acimpl.Service)),
 	thumbs.ProvideCrawlerAuthSetupService,
 	wire.Bind(new(thumbs.CrawlerAuthSetupService), new(*thumbs.OSSCrawlerAuthSetupService)),
 	validations.ProvideValidator,
@@ -0,0 +1,101 @@
+package cuectx
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"cuelang.org/go/cue"
+	"github.com/grafana/thema/load"
+)
+
+// ErrInputTooLarge is returned by [BuildGrafanaInstanceOpts] when the overlay
+// is larger than the limit set with [WithInputLimit].
+var ErrInputTooLarge = errors.New("cue build input exceeds its size limit")
+
+type buildConfig struct {
+	inputLimit int64
+}
+
+// BuildOption configures [BuildGrafanaInstanceOpts].
+type BuildOption func(*buildConfig)
+
+// WithInputLimit fails the build with [ErrInputTooLarge], before anything is
+// loaded, if the files in the overlay add up to more than bytes. A CUE build
+// cannot be interrupted, so the size of its input is what can be bounded; it
+// does not bound what a small input evaluates to.
+func WithInputLimit(bytes int64) BuildOption {
+	return func(c *buildConfig) {
+		c.inputLimit = bytes
+	}
+}
+
+// BuildGrafanaInstance loads the CUE package pkg from overlay, mounted at path
+// relative to the grafana root, and builds it with ctx. If ctx is nil, the
+// context returned from [GrafanaCUEContext] is used. An empty pkg loads the
+// only package in the directory.
+func BuildGrafanaInstance(path, pkg string, ctx *cue.Context, overlay fs.FS) (cue.Value, error) {
+	return BuildGrafanaInstanceOpts(path, pkg, ctx, overlay)
+}
+
+// BuildGrafanaInstanceOpts is [BuildGrafanaInstance] with options.
+func BuildGrafanaInstanceOpts(path, pkg string, ctx *cue.Context, overlay fs.FS, opts ...BuildOption) (cue.Value, error) {
+	var cfg buildConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if ctx == nil {
+		ctx = GrafanaCUEContext()
+	}
+	if cfg.inputLimit > 0 {
+		if err := checkInputSize(overlay, cfg.inputLimit); err != nil {
+			return cue.Value{}, err
+		}
+	}
+
+	prefix := filepath.FromSlash(path)
+	mfs, err := PrefixWithGrafanaCUE(prefix, overlay)
+	if err != nil {
+		return cue.Value{}, err
+	}
+	var loadOpts []load.Option
+	if pkg != "" {
+		loadOpts = append(loadOpts, load.Package(pkg))
+	}
+	cueMu.Lock()
+	defer cueMu.Unlock()
+	inst, err := load.InstancesWithThema(mfs, prefix, loadOpts...)
+	if err != nil {
+		return cue.Value{}, err
+	}
+
+	start := time.Now()
+	v := ctx.BuildInstance(inst)
+	recordBuild(ctx, start)
+	if err := v.Err(); err != nil {
+		return cue.Value{}, err
+	}
+	return v, nil
+}
+
+// checkInputSize returns ErrInputTooLarge if the regular files in overlay add up
+// to more than limit bytes.
+func checkInputSize(overlay fs.FS, limit int64) error {
+	var total int64
+	return fs.WalkDir(overlay, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		if total > limit {
+			return fmt.Errorf("%w: more than %d bytes", ErrInputTooLarge, limit)
+		}
+		return nil
+	})
+}
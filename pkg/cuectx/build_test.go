@@ -0,0 +1,55 @@
+package cuectx
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"cuelang.org/go/cue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildGrafanaInstance(t *testing.T) {
+	input := fstest.MapFS{
+		"a.cue": &fstest.MapFile{Data: []byte("package a\n\nfoo: 1 + 1\n")},
+	}
+
+	ctx := GrafanaCUEContext()
+	builds := GatherCUEContextStats(ctx).BuildCount
+	v, err := BuildGrafanaInstance("pkg/cuectx/a", "a", ctx, input)
+	require.NoError(t, err)
+	i, err := v.LookupPath(cue.ParsePath("foo")).Int64()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), i)
+	assert.Equal(t, builds+1, GatherCUEContextStats(ctx).BuildCount)
+
+	t.Run("within the input limit", func(t *testing.T) {
+		v, err := BuildGrafanaInstanceOpts("pkg/cuectx/a", "a", ctx, input, WithInputLimit(1<<10))
+		require.NoError(t, err)
+		require.True(t, v.Exists())
+		assert.Same(t, ctx, v.Context())
+		assert.Equal(t, builds+2, GatherCUEContextStats(ctx).BuildCount)
+	})
+
+	t.Run("invalid instance", func(t *testing.T) {
+		_, err := BuildGrafanaInstance("pkg/cuectx/b", "b", ctx, fstest.MapFS{
+			"b.cue": &fstest.MapFile{Data: []byte("package b\n\nfoo: 1 & 2\n")},
+		})
+		require.Error(t, err)
+	})
+}
+
+func TestBuildGrafanaInstanceInputLimit(t *testing.T) {
+	input := fstest.MapFS{
+		"a.cue": &fstest.MapFile{Data: []byte("package big\n\na: 1\n")},
+		"b.cue": &fstest.MapFile{Data: []byte("package big\n\nb: \"" + strings.Repeat("x", 1<<10) + "\"\n")},
+	}
+
+	ctx := GrafanaCUEContext()
+	builds := GatherCUEContextStats(ctx).BuildCount
+	_, err := BuildGrafanaInstanceOpts("pkg/cuectx/big", "big", ctx, input, WithInputLimit(1<<10))
+	require.ErrorIs(t, err, ErrInputTooLarge)
+	// The input is refused before anything is built.
+	assert.Equal(t, builds, GatherCUEContextStats(ctx).BuildCount)
+}
@@ -0,0 +1,214 @@
+package cuectx
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"sort"
+	"sync"
+
+	"cuelang.org/go/cue"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// defaultCacheMaxEntries and defaultCacheMaxBytes bound the cache used by
+	// [LoadGrafanaInstancesWithThema].
+	defaultCacheMaxEntries = 256
+	defaultCacheMaxBytes   = 64 << 20
+)
+
+var (
+	cacheSizeBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "grafana",
+		Name:      "cue_cache_size_bytes",
+		Help:      "Total size of the CUE sources of the instances in the CUE instance cache.",
+	})
+	cacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "grafana",
+		Name:      "cue_cache_evictions_total",
+		Help:      "Number of instances evicted from the CUE instance cache to respect its bounds.",
+	})
+)
+
+// instanceCache caches the instances built by LoadGrafanaInstancesWithThema.
+var instanceCache = newMeteredInstanceCache(defaultCacheMaxEntries, defaultCacheMaxBytes)
+
+// InstanceCache is a least recently used cache of built CUE instances, bounded
+// both by number of entries and by the total size of the sources the instances
+// were built from. It is safe for concurrent use.
+type InstanceCache struct {
+	maxEntries int
+	maxBytes   int64
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+	bytes int64
+
+	// sizeBytes and evictions are only set for the package cache.
+	sizeBytes prometheus.Gauge
+	evictions prometheus.Counter
+}
+
+type cacheEntry struct {
+	key   string
+	value cue.Value
+	size  int64
+}
+
+// NewInstanceCache returns an empty cache holding at most maxEntries
+// instances built from at most maxBytes of CUE sources in total. A limit of
+// zero or less disables that bound.
+func NewInstanceCache(maxEntries int, maxBytes int64) *InstanceCache {
+	return &InstanceCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+func newMeteredInstanceCache(maxEntries int, maxBytes int64) *InstanceCache {
+	c := NewInstanceCache(maxEntries, maxBytes)
+	c.sizeBytes, c.evictions = cacheSizeBytes, cacheEvictions
+	return c
+}
+
+// Get returns the instance cached under key and marks it as most recently used.
+func (c *InstanceCache) Get(key string) (cue.Value, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return cue.Value{}, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry).value, true
+}
+
+// Put caches v, built from size bytes of CUE sources, under key, replacing any
+// instance already cached under it. Least recently used instances are evicted
+// until the cache is within its bounds. An instance larger than the byte bound
+// on its own is not cached.
+func (c *InstanceCache) Put(key string, v cue.Value, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+
+	c.items[key] = c.lru.PushFront(&cacheEntry{key: key, value: v, size: size})
+	c.bytes += size
+	for c.overflows() {
+		c.remove(c.lru.Back())
+		if c.evictions != nil {
+			c.evictions.Inc()
+		}
+	}
+	c.updateSize()
+}
+
+// Evict removes the instance cached under key, and returns whether there was one.
+func (c *InstanceCache) Evict(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	c.remove(el)
+	c.updateSize()
+	return true
+}
+
+// Len returns the number of cached instances.
+func (c *InstanceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// ByteSize returns the total size of the CUE sources of the cached instances.
+func (c *InstanceCache) ByteSize() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+// evictContext removes all instances built against ctx.
+func (c *InstanceCache) evictContext(ctx *cue.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*cacheEntry).value.Context() == ctx {
+			c.remove(el)
+		}
+		el = next
+	}
+	c.updateSize()
+}
+
+func (c *InstanceCache) overflows() bool {
+	return (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes)
+}
+
+func (c *InstanceCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+}
+
+func (c *InstanceCache) updateSize() {
+	if c.sizeBytes != nil {
+		c.sizeBytes.Set(float64(c.bytes))
+	}
+}
+
+// instanceCacheKey returns the key an instance built against ctx from the CUE
+// sources in fsys is cached under, and the total size of the sources. The key
+// is derived from the paths and contents of all files, so that any change to
+// the sources yields a different key.
+func instanceCacheKey(ctx *cue.Context, fsys fs.FS) (string, int64, error) {
+	var paths []string
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	var size int64
+	for _, path := range paths {
+		b, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return "", 0, err
+		}
+		// length-prefix both fields so that no two file sets hash the same input
+		_, _ = fmt.Fprintf(h, "%d:%s%d:", len(path), path, len(b))
+		_, _ = h.Write(b)
+		size += int64(len(b))
+	}
+
+	// values built against different contexts cannot be used together
+	return fmt.Sprintf("%p/%s", ctx, hex.EncodeToString(h.Sum(nil))), size, nil
+}
@@ -0,0 +1,104 @@
+package cuectx
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceCache(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`a: 1`)
+
+	t.Run("evicts the least recently used instance", func(t *testing.T) {
+		c := NewInstanceCache(2, 0)
+		c.Put("a", v, 1)
+		c.Put("b", v, 1)
+
+		// using a makes b the least recently used
+		_, ok := c.Get("a")
+		require.True(t, ok)
+
+		c.Put("c", v, 1)
+		assert.Equal(t, 2, c.Len())
+		_, ok = c.Get("b")
+		assert.False(t, ok)
+		_, ok = c.Get("a")
+		assert.True(t, ok)
+		_, ok = c.Get("c")
+		assert.True(t, ok)
+	})
+
+	t.Run("respects the byte limit", func(t *testing.T) {
+		c := NewInstanceCache(0, 10)
+		c.Put("a", v, 4)
+		c.Put("b", v, 4)
+		assert.Equal(t, int64(8), c.ByteSize())
+
+		c.Put("c", v, 4)
+		assert.Equal(t, 2, c.Len())
+		assert.Equal(t, int64(8), c.ByteSize())
+		_, ok := c.Get("a")
+		assert.False(t, ok)
+
+		// an instance larger than the limit is not cached, and evicts nothing
+		c.Put("d", v, 11)
+		assert.Equal(t, 2, c.Len())
+		_, ok = c.Get("d")
+		assert.False(t, ok)
+	})
+
+	t.Run("replacing an instance updates its size", func(t *testing.T) {
+		c := NewInstanceCache(0, 0)
+		c.Put("a", v, 4)
+		c.Put("a", v, 6)
+		assert.Equal(t, 1, c.Len())
+		assert.Equal(t, int64(6), c.ByteSize())
+	})
+
+	t.Run("evicts on demand", func(t *testing.T) {
+		c := NewInstanceCache(0, 0)
+		c.Put("a", v, 4)
+		assert.True(t, c.Evict("a"))
+		assert.False(t, c.Evict("a"))
+		assert.Equal(t, 0, c.Len())
+		assert.Equal(t, int64(0), c.ByteSize())
+	})
+}
+
+func TestInstanceCacheKey(t *testing.T) {
+	ctx := cuecontext.New()
+	fsys := fstest.MapFS{"a.cue": {Data: []byte(`a: 1`)}}
+
+	key, size, err := instanceCacheKey(ctx, fsys)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), size)
+
+	same, _, err := instanceCacheKey(ctx, fstest.MapFS{"a.cue": {Data: []byte(`a: 1`)}})
+	require.NoError(t, err)
+	assert.Equal(t, key, same)
+
+	changed, _, err := instanceCacheKey(ctx, fstest.MapFS{"a.cue": {Data: []byte(`a: 2`)}})
+	require.NoError(t, err)
+	assert.NotEqual(t, key, changed)
+
+	other, _, err := instanceCacheKey(cuecontext.New(), fsys)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+}
+
+func TestLoadGrafanaInstancesWithThemaCache(t *testing.T) {
+	rt := GrafanaThemaRuntime()
+
+	_, err := LoadGrafanaInstancesWithThema("pkg/cuectx/cache/testlin", testLineageFS, rt)
+	require.NoError(t, err)
+	builds := GatherCUEContextStats(rt.Context()).BuildCount
+
+	lin, err := LoadGrafanaInstancesWithThema("pkg/cuectx/cache/testlin", testLineageFS, rt)
+	require.NoError(t, err)
+	assert.Equal(t, "testlin", lin.Name())
+	assert.Equal(t, builds, GatherCUEContextStats(rt.Context()).BuildCount)
+}
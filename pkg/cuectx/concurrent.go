@@ -0,0 +1,74 @@
+package cuectx
+
+import (
+	"io/fs"
+	"runtime"
+	"sync"
+
+	"github.com/grafana/thema"
+	"golang.org/x/sync/errgroup"
+)
+
+// cueMu serializes every use of cue/load, and of a cue.Context that may be
+// shared with other callers, as neither are safe for concurrent use.
+var cueMu sync.Mutex
+
+// WithConcurrency bounds the number of lineages
+// [LoadGrafanaInstancesWithThemaAllOpts] loads at the same time. It defaults
+// to runtime.NumCPU().
+func WithConcurrency(workers int) LoadOption {
+	return func(c *loadConfig) {
+		c.workers = workers
+	}
+}
+
+// LoadGrafanaInstancesWithThemaAll is [LoadGrafanaInstancesWithThemaAllOpts]
+// with the default options.
+func LoadGrafanaInstancesWithThemaAll(paths []string, cueFS fs.FS, rt *thema.Runtime, opts ...thema.BindOption) ([]thema.Lineage, []error) {
+	return LoadGrafanaInstancesWithThemaAllOpts(paths, cueFS, rt, WithBindOptions(opts...))
+}
+
+// LoadGrafanaInstancesWithThemaAllOpts loads the lineages in each of paths
+// concurrently. cueFS is rooted at the grafana root, and each path is the
+// directory within it containing a lineage.cue, as passed to
+// [LoadGrafanaInstancesWithThema] along with fs.Sub(cueFS, path).
+//
+// The returned slices have the same length and order as paths. A path that
+// fails to load has a nil lineage and a non-nil error, and does not prevent
+// the other paths from loading.
+//
+// Reading and hashing the CUE files, including retries configured with
+// [WithRetry], and hits in the instance cache are done concurrently. Loading,
+// building and binding are serialized, as all lineages are bound to the
+// context of rt, which is not safe for concurrent use.
+func LoadGrafanaInstancesWithThemaAllOpts(paths []string, cueFS fs.FS, rt *thema.Runtime, opts ...LoadOption) ([]thema.Lineage, []error) {
+	cfg := &loadConfig{workers: runtime.NumCPU()}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	lins := make([]thema.Lineage, len(paths))
+	errs := make([]error, len(paths))
+
+	var g errgroup.Group
+	if cfg.workers > 0 {
+		g.SetLimit(cfg.workers)
+	}
+	for i, path := range paths {
+		i, path := i, path
+		g.Go(func() error {
+			sub, err := fs.Sub(cueFS, path)
+			if err != nil {
+				errs[i] = err
+				return nil
+			}
+			// Errors are per path, so that one failure does not cancel the
+			// other loads.
+			lins[i], errs[i] = LoadGrafanaInstancesWithThemaOpts(path, sub, rt, opts...)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	return lins, errs
+}
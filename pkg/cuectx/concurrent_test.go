@@ -0,0 +1,111 @@
+package cuectx
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/grafana/thema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLineagesFS(n int) (fstest.MapFS, []string) {
+	fsys := fstest.MapFS{}
+	paths := make([]string, n)
+	for i := range paths {
+		name := fmt.Sprintf("lin%d", i)
+		paths[i] = "pkg/cuectx/all/" + name
+		fsys[paths[i]+"/lineage.cue"] = &fstest.MapFile{Data: []byte(fmt.Sprintf(`package %[1]s
+
+import "github.com/grafana/thema"
+
+thema.#Lineage
+name: "%[1]s"
+seqs: [
+	{
+		schemas: [
+			{
+				field%[2]d: string
+			},
+		]
+	},
+]
+`, name, i))}
+	}
+	return fsys, paths
+}
+
+func TestLoadGrafanaInstancesWithThemaAll(t *testing.T) {
+	t.Run("matches sequential loading", func(t *testing.T) {
+		fsys, paths := testLineagesFS(10)
+
+		seqRt := thema.NewRuntime(cuecontext.New())
+		var want []string
+		for _, path := range paths {
+			lin, err := LoadGrafanaInstancesWithThemaAllOpts([]string{path}, fsys, seqRt, WithConcurrency(1))
+			require.NoError(t, err[0])
+			want = append(want, fmt.Sprintf("%s %v", lin[0].Name(), thema.SchemaP(lin[0], thema.SV(0, 0)).UnwrapCUE()))
+		}
+
+		lins, errs := LoadGrafanaInstancesWithThemaAll(paths, fsys, thema.NewRuntime(cuecontext.New()))
+		require.Len(t, lins, len(paths))
+		require.Len(t, errs, len(paths))
+		var got []string
+		for i, lin := range lins {
+			require.NoError(t, errs[i])
+			got = append(got, fmt.Sprintf("%s %v", lin.Name(), thema.SchemaP(lin, thema.SV(0, 0)).UnwrapCUE()))
+		}
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("an invalid path does not prevent loading the others", func(t *testing.T) {
+		fsys, paths := testLineagesFS(3)
+		fsys["pkg/cuectx/all/broken/lineage.cue"] = &fstest.MapFile{Data: []byte("package broken\n\nfoo: 1 & 2\n")}
+		paths = []string{paths[0], "pkg/cuectx/all/broken", paths[1], "pkg/cuectx/all/missing", paths[2]}
+
+		lins, errs := LoadGrafanaInstancesWithThemaAllOpts(paths, fsys, thema.NewRuntime(cuecontext.New()), WithConcurrency(2))
+		for i, want := range []string{"lin0", "", "lin1", "", "lin2"} {
+			if want == "" {
+				assert.Error(t, errs[i], paths[i])
+				assert.Nil(t, lins[i], paths[i])
+				continue
+			}
+			require.NoError(t, errs[i], paths[i])
+			assert.Equal(t, want, lins[i].Name())
+		}
+	})
+}
+
+func TestGrafanaCUEContextConcurrentUse(t *testing.T) {
+	schema := testSchema(t)
+	fsys, paths := testLineagesFS(4)
+	input := fstest.MapFS{
+		"a.cue": &fstest.MapFile{Data: []byte("package a\n\nfoo: 1 + 1\n")},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			_, err := JSONtoCUEWithSchema("panel.json", []byte(`{"title": "CPU", "gridPos": {"w": 12, "h": 8}}`), schema)
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := BuildGrafanaInstance("pkg/cuectx/a", "a", nil, input)
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			_, errs := LoadGrafanaInstancesWithThemaAll(paths, fsys, GrafanaThemaRuntime())
+			for _, err := range errs {
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -7,7 +7,10 @@ package cuectx
 import (
 	"io/fs"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing/fstest"
+	"time"
 
 	"cuelang.org/go/cue"
 	"cuelang.org/go/cue/cuecontext"
@@ -16,15 +19,49 @@ import (
 	"github.com/grafana/thema/vmux"
 )
 
-var ctx = cuecontext.New()
-var rt = thema.NewRuntime(ctx)
+// grafanaRuntime pairs the singleton cue.Context with the thema.Runtime built
+// on top of it, so that both are always swapped together.
+type grafanaRuntime struct {
+	ctx *cue.Context
+	rt  *thema.Runtime
+	// stats are the statistics of the builds against ctx
+	stats buildStats
+}
+
+var (
+	// current holds the *grafanaRuntime in use, or a nil *grafanaRuntime after
+	// ShutdownCUEContext.
+	current atomic.Value
+	// mu guards initialization of current and shutdownHooks.
+	mu            sync.Mutex
+	shutdownHooks []func()
+	// generation is incremented by each ShutdownCUEContext.
+	generation uint64
+)
+
+func loadRuntime() *grafanaRuntime {
+	if r, _ := current.Load().(*grafanaRuntime); r != nil {
+		return r
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if r, _ := current.Load().(*grafanaRuntime); r != nil {
+		return r
+	}
+
+	ctx := cuecontext.New()
+	r := &grafanaRuntime{ctx: ctx, rt: thema.NewRuntime(ctx)}
+	current.Store(r)
+	return r
+}
 
 // GrafanaCUEContext returns Grafana's singleton instance of [cue.Context].
 //
 // All code within grafana/grafana that needs a *cue.Context should get it
 // from this function, when one was not otherwise provided.
 func GrafanaCUEContext() *cue.Context {
-	return ctx
+	return loadRuntime().ctx
 }
 
 // GrafanaThemaRuntime returns Grafana's singleton instance of [thema.Runtime].
@@ -32,7 +69,50 @@ func GrafanaCUEContext() *cue.Context {
 // All code within grafana/grafana that needs a *thema.Runtime should get it
 // from this function, when one was not otherwise provided.
 func GrafanaThemaRuntime() *thema.Runtime {
-	return rt
+	return loadRuntime().rt
+}
+
+// RegisterCUEContextShutdown registers fn to be called by the next call to
+// [ShutdownCUEContext]. It is meant for releasing resources derived from the
+// singleton [cue.Context], such as cached lineages.
+func RegisterCUEContextShutdown(fn func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	shutdownHooks = append(shutdownHooks, fn)
+}
+
+// ShutdownCUEContext calls all hooks registered with
+// [RegisterCUEContextShutdown], in reverse order of registration, and then
+// releases the singleton [cue.Context] and [thema.Runtime]. Subsequent calls to
+// [GrafanaCUEContext] and [GrafanaThemaRuntime] lazily create new instances.
+//
+// Values obtained from the released instances must not be used together with
+// values from the new ones.
+func ShutdownCUEContext() {
+	mu.Lock()
+	hooks := shutdownHooks
+	shutdownHooks = nil
+	mu.Unlock()
+
+	// Hooks run without holding mu, as they may still use the singletons.
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if r, _ := current.Load().(*grafanaRuntime); r != nil {
+		instanceCache.evictContext(r.ctx)
+	}
+	current.Store((*grafanaRuntime)(nil))
+	atomic.AddUint64(&generation, 1)
+}
+
+// Generation returns the number of calls to [ShutdownCUEContext] so far.
+// Caches of values derived from a [cue.Context] can record it to detect that
+// they outlived a shutdown.
+func Generation() uint64 {
+	return atomic.LoadUint64(&generation)
 }
 
 // JSONtoCUE attempts to decode the given []byte into a cue.Value, relying on
@@ -46,7 +126,9 @@ func GrafanaThemaRuntime() *thema.Runtime {
 // call it repeatedly. Most use cases should probably prefer making
 // their own Thema/CUE decoders.
 func JSONtoCUE(path string, b []byte) (cue.Value, error) {
-	return vmux.NewJSONEndec(path).Decode(ctx, b)
+	cueMu.Lock()
+	defer cueMu.Unlock()
+	return vmux.NewJSONEndec(path).Decode(GrafanaCUEContext(), b)
 }
 
 // LoadGrafanaInstancesWithThema loads CUE files containing a lineage
@@ -59,61 +141,169 @@ func JSONtoCUE(path string, b []byte) (cue.Value, error) {
 //
 // More details on underlying behavior can be found in the docs for github.com/grafana/thema/load.InstancesWithThema.
 //
+// Built instances are cached, so loading the same files again against the same
+// runtime only binds the lineage.
+//
 // TODO this approach is complicated and confusing, refactor to something understandable
 func LoadGrafanaInstancesWithThema(path string, cueFS fs.FS, rt *thema.Runtime, opts ...thema.BindOption) (thema.Lineage, error) {
-	prefix := filepath.FromSlash(path)
-	fs, err := prefixWithGrafanaCUE(prefix, cueFS)
+	return LoadGrafanaInstancesWithThemaOpts(path, cueFS, rt, WithBindOptions(opts...))
+}
+
+type loadConfig struct {
+	bindOpts     []thema.BindOption
+	maxAttempts  int
+	initialDelay time.Duration
+	// workers bounds the number of concurrent loads of
+	// LoadGrafanaInstancesWithThemaAllOpts.
+	workers int
+}
+
+// LoadOption configures [LoadGrafanaInstancesWithThemaOpts].
+type LoadOption func(*loadConfig)
+
+// WithBindOptions passes opts to thema.BindLineage when binding the loaded
+// lineage.
+func WithBindOptions(opts ...thema.BindOption) LoadOption {
+	return func(c *loadConfig) {
+		c.bindOpts = append(c.bindOpts, opts...)
+	}
+}
+
+// WithRetry makes loading try up to maxAttempts times when reading the input
+// fs.FS fails with a transient error, as happens on slow network-mounted
+// filesystems. Attempts are spaced by an exponential backoff with jitter,
+// starting at initialDelay. See [IsTransientLoadError] for the errors that are
+// retried.
+func WithRetry(maxAttempts int, initialDelay time.Duration) LoadOption {
+	return func(c *loadConfig) {
+		c.maxAttempts = maxAttempts
+		c.initialDelay = initialDelay
+	}
+}
+
+// LoadGrafanaInstancesWithThemaOpts is [LoadGrafanaInstancesWithThema] with
+// options.
+func LoadGrafanaInstancesWithThemaOpts(path string, cueFS fs.FS, rt *thema.Runtime, opts ...LoadOption) (thema.Lineage, error) {
+	cfg := &loadConfig{maxAttempts: 1}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	var val cue.Value
+	err := retryTransient(cfg.maxAttempts, cfg.initialDelay, func() error {
+		var err error
+		val, err = loadGrafanaInstance(path, cueFS, rt)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+
+	cueMu.Lock()
+	lin, err := thema.BindLineage(val, rt, cfg.bindOpts...)
+	cueMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return lin, nil
+}
+
+// loadGrafanaInstance builds the instance of the lineage in cueFS, or returns
+// it from the cache.
+func loadGrafanaInstance(path string, cueFS fs.FS, rt *thema.Runtime) (cue.Value, error) {
+	prefix := filepath.FromSlash(path)
+	fs, err := PrefixWithGrafanaCUE(prefix, cueFS)
+	if err != nil {
+		return cue.Value{}, err
+	}
+
+	key, size, err := instanceCacheKey(rt.Context(), fs)
+	if err != nil {
+		return cue.Value{}, err
+	}
+	if val, ok := instanceCache.Get(key); ok {
+		return val, nil
+	}
+
+	cueMu.Lock()
+	defer cueMu.Unlock()
 	inst, err := load.InstancesWithThema(fs, prefix)
 
 	// Need to trick loading by creating the embedded file and
 	// making it look like a module in the root dir.
 	if err != nil {
-		return nil, err
+		return cue.Value{}, err
 	}
 
+	start := time.Now()
 	val := rt.Context().BuildInstance(inst)
+	recordBuild(rt.Context(), start)
+	if val.Err() == nil {
+		instanceCache.Put(key, val, size)
+	}
+	return val, nil
+}
 
-	lin, err := thema.BindLineage(val, rt, opts...)
-	if err != nil {
-		return nil, err
+type prefixConfig struct {
+	progress func(path string, bytesRead int64)
+	strict   bool
+}
+
+// PrefixOption configures the fs.FS returned from [PrefixWithGrafanaCUEOpts].
+type PrefixOption func(*prefixConfig)
+
+// WithProgressCallback registers fn to be called after each file of the input
+// fs.FS has been read, with the file's path within the input fs.FS and the
+// number of bytes read from it. It is intended for reporting progress when
+// mounting large fs.FS trees.
+func WithProgressCallback(fn func(path string, bytesRead int64)) PrefixOption {
+	return func(c *prefixConfig) {
+		c.progress = fn
 	}
+}
 
-	return lin, nil
+// WithStrictInputValidation rejects input fs.FS trees that contain a cue.mod
+// directory or CUE test files, or that import CUE packages other than those in
+// [grafana.CueSchemaFS], in the input fs.FS itself, in the CUE standard library
+// or in thema. Violations are returned as a [*StrictValidationError].
+func WithStrictInputValidation() PrefixOption {
+	return func(c *prefixConfig) {
+		c.strict = true
+	}
+}
+
+// PrefixWithGrafanaCUE is [PrefixWithGrafanaCUEOpts] with the default options.
+func PrefixWithGrafanaCUE(prefix string, inputfs fs.FS) (fs.FS, error) {
+	return PrefixWithGrafanaCUEOpts(prefix, inputfs)
 }
 
-// prefixWithGrafanaCUE constructs an fs.FS that merges the provided fs.FS with one
-// containing grafana's cue.mod at the root. The provided prefix should be the
+// PrefixWithGrafanaCUEOpts constructs an fs.FS that merges the provided fs.FS
+// with one containing grafana's cue.mod at the root. The provided prefix should
+// be the path from the grafana root to the directory the contents of inputfs
+// are mounted at.
 //
 // The returned fs.FS is suitable for passing to a CUE loader, such as
 // cuelang.org/cue/load.Instances or
 // github.com/grafana/thema/load.InstancesWithThema.
-func prefixWithGrafanaCUE(prefix string, inputfs fs.FS) (fs.FS, error) {
-	m := fstest.MapFS{
-		// fstest can recognize only forward slashes.
-		filepath.ToSlash(filepath.Join("cue.mod", "module.cue")): &fstest.MapFile{Data: []byte(`module: "github.com/grafana/grafana"`)},
+func PrefixWithGrafanaCUEOpts(prefix string, inputfs fs.FS, opts ...PrefixOption) (fs.FS, error) {
+	cfg := &prefixConfig{}
+	for _, opt := range opts {
+		opt(cfg)
 	}
 
-	prefix = filepath.FromSlash(prefix)
-	err := fs.WalkDir(inputfs, ".", func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if d.IsDir() {
-			return nil
+	if cfg.strict {
+		if err := validateStrictInput(prefix, inputfs); err != nil {
+			return nil, err
 		}
+	}
 
-		b, err := fs.ReadFile(inputfs, path)
-		if err != nil {
-			return err
-		}
-		// fstest can recognize only forward slashes.
-		m[filepath.ToSlash(filepath.Join(prefix, path))] = &fstest.MapFile{Data: b}
-		return nil
-	})
+	m, err := mountFS(prefix, inputfs, cfg.progress)
+	if err != nil {
+		return nil, err
+	}
 
-	return m, err
+	// fstest can recognize only forward slashes.
+	m[filepath.ToSlash(filepath.Join("cue.mod", "module.cue"))] = &fstest.MapFile{Data: []byte(`module: "github.com/grafana/grafana"`)}
+	return m, nil
 }
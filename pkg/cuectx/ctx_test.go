@@ -0,0 +1,135 @@
+package cuectx
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"cuelang.org/go/cue"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	ShutdownCUEContext()
+	os.Exit(code)
+}
+
+func TestShutdownCUEContext(t *testing.T) {
+	ctx, rt := GrafanaCUEContext(), GrafanaThemaRuntime()
+	require.Same(t, ctx, rt.Context())
+
+	var calls []string
+	RegisterCUEContextShutdown(func() { calls = append(calls, "first") })
+	RegisterCUEContextShutdown(func() {
+		// Hooks still see the instances being shut down.
+		require.Same(t, ctx, GrafanaCUEContext())
+		calls = append(calls, "second")
+	})
+
+	ShutdownCUEContext()
+	require.Equal(t, []string{"second", "first"}, calls)
+
+	newCtx, newRt := GrafanaCUEContext(), GrafanaThemaRuntime()
+	require.NotSame(t, ctx, newCtx)
+	require.Same(t, newCtx, newRt.Context())
+	require.Same(t, newCtx, GrafanaCUEContext())
+
+	v := newCtx.CompileString(`a: 1`)
+	require.NoError(t, v.Err())
+	i, err := v.LookupPath(cue.ParsePath("a")).Int64()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), i)
+
+	_, err = LoadGrafanaInstancesWithThema("pkg/cuectx/testlin", testLineageFS, newRt)
+	require.NoError(t, err)
+
+	// Hooks only run once.
+	ShutdownCUEContext()
+	require.Len(t, calls, 2)
+}
+
+func TestPrefixWithGrafanaCUEOpts(t *testing.T) {
+	input := fstest.MapFS{
+		"a.cue":        &fstest.MapFile{Data: []byte("package a")},
+		"sub/b.cue":    &fstest.MapFile{Data: []byte("package b\n\nfoo: string")},
+		"sub/deep/c":   &fstest.MapFile{Data: []byte("c")},
+		"sub/empty.go": &fstest.MapFile{},
+	}
+
+	t.Run("progress callback", func(t *testing.T) {
+		calls := map[string]int{}
+		var total int64
+		merged, err := PrefixWithGrafanaCUEOpts("pkg/prefix", input, WithProgressCallback(func(path string, bytesRead int64) {
+			calls[path]++
+			total += bytesRead
+		}))
+		require.NoError(t, err)
+
+		var want int64
+		for path, f := range input {
+			require.Equal(t, 1, calls[path], path)
+			want += int64(len(f.Data))
+		}
+		require.Len(t, calls, len(input))
+		require.Equal(t, want, total)
+
+		b, err := fs.ReadFile(merged, "pkg/prefix/sub/b.cue")
+		require.NoError(t, err)
+		require.Equal(t, input["sub/b.cue"].Data, b)
+	})
+
+	t.Run("no options", func(t *testing.T) {
+		merged, err := PrefixWithGrafanaCUE("pkg/prefix", input)
+		require.NoError(t, err)
+
+		b, err := fs.ReadFile(merged, "cue.mod/module.cue")
+		require.NoError(t, err)
+		require.Contains(t, string(b), `module: "github.com/grafana/grafana"`)
+	})
+}
+
+func TestPrefixWithGrafanaCUEOptsStrict(t *testing.T) {
+	clean := fstest.MapFS{
+		"panel.cue": &fstest.MapFile{Data: []byte(`package panel
+
+import (
+	"strings"
+	"github.com/grafana/thema"
+	ui "github.com/grafana/grafana/packages/grafana-schema/src/schema"
+	"github.com/grafana/grafana/pkg/prefix/sub"
+)
+`)},
+		"sub/sub.cue": &fstest.MapFile{Data: []byte("package sub")},
+	}
+
+	t.Run("clean input passes", func(t *testing.T) {
+		_, err := PrefixWithGrafanaCUEOpts("pkg/prefix", clean, WithStrictInputValidation())
+		require.NoError(t, err)
+	})
+
+	t.Run("violations are reported", func(t *testing.T) {
+		input := fstest.MapFS{
+			"cue.mod/module.cue": &fstest.MapFile{Data: []byte(`module: "example.com/plugin"`)},
+			"panel_test.cue":     &fstest.MapFile{Data: []byte("package panel")},
+			"panel.cue":          &fstest.MapFile{Data: []byte("package panel\n\nimport \"example.com/other\"")},
+		}
+		_, err := PrefixWithGrafanaCUEOpts("pkg/prefix", input, WithStrictInputValidation())
+
+		var serr *StrictValidationError
+		require.ErrorAs(t, err, &serr)
+		require.Len(t, serr.Violations, 3)
+		require.Contains(t, serr.Violations[0], "cue.mod")
+		require.Contains(t, serr.Violations[1], "example.com/other")
+		require.Contains(t, serr.Violations[2], "panel_test.cue")
+	})
+
+	t.Run("non-strict mode accepts a cue.mod", func(t *testing.T) {
+		input := fstest.MapFS{
+			"cue.mod/module.cue": &fstest.MapFile{Data: []byte(`module: "example.com/plugin"`)},
+		}
+		_, err := PrefixWithGrafanaCUE("pkg/prefix", input)
+		require.NoError(t, err)
+	})
+}
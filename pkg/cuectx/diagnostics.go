@@ -0,0 +1,54 @@
+package cuectx
+
+import (
+	"sync/atomic"
+	"time"
+
+	"cuelang.org/go/cue"
+)
+
+// CUEContextStats reports diagnostic information about the CUE instances that
+// have been built against the context returned from [GrafanaCUEContext] through
+// the loaders in this package.
+type CUEContextStats struct {
+	// BuildCount is the number of CUE instances built against the context.
+	BuildCount int64 `json:"buildCount"`
+	// LastBuildDuration is the wall time taken by the most recent build.
+	LastBuildDuration time.Duration `json:"lastBuildDuration"`
+}
+
+// buildStats holds the counters backing CUEContextStats. All fields must be
+// accessed atomically.
+type buildStats struct {
+	count        int64
+	lastDuration int64
+}
+
+// recordBuild records a completed build against ctx that began at start. Only
+// builds against the context of the current Grafana runtime are recorded, the
+// statistics are released with the runtime by ShutdownCUEContext.
+func recordBuild(ctx *cue.Context, start time.Time) {
+	r, _ := current.Load().(*grafanaRuntime)
+	if r == nil || r.ctx != ctx {
+		return
+	}
+	atomic.AddInt64(&r.stats.count, 1)
+	atomic.StoreInt64(&r.stats.lastDuration, int64(time.Since(start)))
+}
+
+// GatherCUEContextStats returns a snapshot of the build statistics recorded for
+// the provided [cue.Context]. If ctx is nil, statistics for the context returned
+// from [GrafanaCUEContext] are returned. Builds against other contexts are not
+// recorded, their statistics are always empty.
+func GatherCUEContextStats(ctx *cue.Context) CUEContextStats {
+	r := loadRuntime()
+	if ctx != nil && ctx != r.ctx {
+		return CUEContextStats{}
+	}
+
+	s := &r.stats
+	return CUEContextStats{
+		BuildCount:        atomic.LoadInt64(&s.count),
+		LastBuildDuration: time.Duration(atomic.LoadInt64(&s.lastDuration)),
+	}
+}
@@ -0,0 +1,50 @@
+package cuectx
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/grafana/thema"
+	"github.com/stretchr/testify/require"
+)
+
+var testLineageFS = fstest.MapFS{
+	"lineage.cue": &fstest.MapFile{Data: []byte(`package testlin
+
+import "github.com/grafana/thema"
+
+thema.#Lineage
+name: "testlin"
+seqs: [
+	{
+		schemas: [
+			{
+				foo: string
+			},
+		]
+	},
+]
+`)},
+}
+
+func TestGatherCUEContextStats(t *testing.T) {
+	rt := GrafanaThemaRuntime()
+	before := GatherCUEContextStats(rt.Context())
+	require.Equal(t, before, GatherCUEContextStats(nil))
+
+	_, err := LoadGrafanaInstancesWithThema("pkg/cuectx/diagnostics/testlin", testLineageFS, rt)
+	require.NoError(t, err)
+
+	stats := GatherCUEContextStats(rt.Context())
+	require.Equal(t, before.BuildCount+1, stats.BuildCount)
+	require.Greater(t, stats.LastBuildDuration, time.Duration(0))
+
+	// Builds against other contexts are not recorded.
+	custom := thema.NewRuntime(cuecontext.New())
+	_, err = LoadGrafanaInstancesWithThema("pkg/cuectx/diagnostics/testlin", testLineageFS, custom)
+	require.NoError(t, err)
+	require.Equal(t, CUEContextStats{}, GatherCUEContextStats(custom.Context()))
+	require.Equal(t, stats.BuildCount, GatherCUEContextStats(rt.Context()).BuildCount)
+}
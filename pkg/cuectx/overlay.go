@@ -0,0 +1,138 @@
+package cuectx
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing/fstest"
+
+	"github.com/yalue/merged_fs"
+
+	"github.com/grafana/grafana"
+)
+
+type overlayConfig struct {
+	base   fs.FS
+	strict bool
+}
+
+// OverlayOption configures the fs.FS returned from [PrefixWithGrafanaCUELayeredOpts].
+type OverlayOption func(*overlayConfig)
+
+// WithBaseFS replaces the base fs.FS that layers are merged on top of. It
+// defaults to [grafana.CueSchemaFS]. The base is used as-is, and must contain
+// a cue.mod at its root for the result to be loadable by CUE.
+func WithBaseFS(base fs.FS) OverlayOption {
+	return func(c *overlayConfig) {
+		c.base = base
+	}
+}
+
+// WithStrictValidation makes layering fail if any layer contains a cue.mod
+// directory, or contains a file that would shadow a file in the base fs.FS.
+// Layers may still shadow each other.
+func WithStrictValidation() OverlayOption {
+	return func(c *overlayConfig) {
+		c.strict = true
+	}
+}
+
+// PrefixWithGrafanaCUELayered is [PrefixWithGrafanaCUELayeredOpts] with the
+// default options.
+func PrefixWithGrafanaCUELayered(prefix string, layers ...fs.FS) (fs.FS, error) {
+	return PrefixWithGrafanaCUELayeredOpts(prefix, layers)
+}
+
+// PrefixWithGrafanaCUELayeredOpts constructs an fs.FS that mounts each of the
+// provided layers at prefix on top of a base fs.FS containing grafana's CUE
+// files, including its cue.mod.
+//
+// Layers are merged left-to-right: when a path exists in more than one layer,
+// the rightmost layer containing it wins, and any layer wins over the base.
+// Directories present in several layers are merged rather than replaced, so a
+// layer only needs to contain the files it adds or overrides. If a path is a
+// file in one layer and a directory in a layer to its right, the directory
+// hides the file, and vice versa.
+//
+// The returned fs.FS is suitable for passing to a CUE loader, such as
+// cuelang.org/cue/load.Instances or
+// github.com/grafana/thema/load.InstancesWithThema.
+func PrefixWithGrafanaCUELayeredOpts(prefix string, layers []fs.FS, opts ...OverlayOption) (fs.FS, error) {
+	cfg := &overlayConfig{
+		base: grafana.CueSchemaFS,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.base == nil {
+		return nil, errors.New("base fs.FS must not be nil")
+	}
+
+	var merged fs.FS = cfg.base
+	for i, layer := range layers {
+		if layer == nil {
+			return nil, fmt.Errorf("overlay layer %d is nil", i)
+		}
+
+		mounted, err := mountFS(prefix, layer, nil)
+		if err != nil {
+			return nil, fmt.Errorf("overlay layer %d: %w", i, err)
+		}
+
+		if cfg.strict {
+			if err := validateLayer(mounted, cfg.base); err != nil {
+				return nil, fmt.Errorf("overlay layer %d: %w", i, err)
+			}
+		}
+
+		merged = merged_fs.NewMergedFS(mounted, merged)
+	}
+
+	return merged, nil
+}
+
+// validateLayer checks that a mounted layer neither declares its own CUE
+// module nor shadows files in base.
+func validateLayer(layer fstest.MapFS, base fs.FS) error {
+	for path := range layer {
+		if strings.HasPrefix(path, "cue.mod/") {
+			return fmt.Errorf("layer must not contain cue.mod, found %s", path)
+		}
+		if _, err := fs.Stat(base, path); err == nil {
+			return fmt.Errorf("layer shadows %s in the base fs.FS", path)
+		}
+	}
+	return nil
+}
+
+// mountFS copies all files of inputfs into an in-memory fs.FS below prefix. If
+// progress is non-nil, it is called after each file has been read.
+func mountFS(prefix string, inputfs fs.FS, progress func(path string, bytesRead int64)) (fstest.MapFS, error) {
+	m := fstest.MapFS{}
+
+	prefix = filepath.FromSlash(prefix)
+	err := fs.WalkDir(inputfs, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		b, err := fs.ReadFile(inputfs, path)
+		if err != nil {
+			return err
+		}
+		// fstest can recognize only forward slashes.
+		m[filepath.ToSlash(filepath.Join(prefix, path))] = &fstest.MapFile{Data: b}
+		if progress != nil {
+			progress(path, int64(len(b)))
+		}
+		return nil
+	})
+
+	return m, err
+}
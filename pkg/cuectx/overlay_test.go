@@ -0,0 +1,78 @@
+package cuectx
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixWithGrafanaCUELayered(t *testing.T) {
+	layer0 := fstest.MapFS{
+		"shared.cue": &fstest.MapFile{Data: []byte("layer0")},
+		"only0.cue":  &fstest.MapFile{Data: []byte("only0")},
+	}
+	layer1 := fstest.MapFS{
+		"shared.cue": &fstest.MapFile{Data: []byte("layer1")},
+	}
+
+	t.Run("rightmost layer wins", func(t *testing.T) {
+		merged, err := PrefixWithGrafanaCUELayered("pkg/overlay", layer0, layer1)
+		require.NoError(t, err)
+
+		b, err := fs.ReadFile(merged, "pkg/overlay/shared.cue")
+		require.NoError(t, err)
+		require.Equal(t, "layer1", string(b))
+
+		b, err = fs.ReadFile(merged, "pkg/overlay/only0.cue")
+		require.NoError(t, err)
+		require.Equal(t, "only0", string(b))
+	})
+
+	t.Run("files only in the base fs are accessible", func(t *testing.T) {
+		merged, err := PrefixWithGrafanaCUELayered("pkg/overlay", layer0, layer1)
+		require.NoError(t, err)
+
+		b, err := fs.ReadFile(merged, "cue.mod/module.cue")
+		require.NoError(t, err)
+		require.Contains(t, string(b), `module: "github.com/grafana/grafana"`)
+
+		_, err = fs.Stat(merged, "pkg/framework/coremodel/slots.cue")
+		require.NoError(t, err)
+	})
+
+	t.Run("custom base fs", func(t *testing.T) {
+		base := fstest.MapFS{
+			"cue.mod/module.cue":   &fstest.MapFile{Data: []byte(`module: "example.com/custom"`)},
+			"pkg/overlay/base.cue": &fstest.MapFile{Data: []byte("base")},
+		}
+		merged, err := PrefixWithGrafanaCUELayeredOpts("pkg/overlay", []fs.FS{layer0}, WithBaseFS(base))
+		require.NoError(t, err)
+
+		b, err := fs.ReadFile(merged, "pkg/overlay/base.cue")
+		require.NoError(t, err)
+		require.Equal(t, "base", string(b))
+
+		_, err = fs.Stat(merged, "pkg/framework/coremodel/slots.cue")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("strict validation", func(t *testing.T) {
+		_, err := PrefixWithGrafanaCUELayeredOpts("pkg/overlay", []fs.FS{layer0, layer1}, WithStrictValidation())
+		require.NoError(t, err)
+
+		shadowing := fstest.MapFS{"slots.cue": &fstest.MapFile{Data: []byte("shadow")}}
+		_, err = PrefixWithGrafanaCUELayeredOpts("pkg/framework/coremodel", []fs.FS{shadowing}, WithStrictValidation())
+		require.Error(t, err)
+
+		module := fstest.MapFS{"cue.mod/module.cue": &fstest.MapFile{Data: []byte(`module: "example.com/other"`)}}
+		_, err = PrefixWithGrafanaCUELayeredOpts("", []fs.FS{module}, WithStrictValidation())
+		require.Error(t, err)
+	})
+
+	t.Run("nil layer", func(t *testing.T) {
+		_, err := PrefixWithGrafanaCUELayered("pkg/overlay", layer0, nil)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,44 @@
+package cuectx
+
+import (
+	"errors"
+	"io/fs"
+	"math/rand"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var loadRetries = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "grafana",
+	Name:      "cue_load_retry_total",
+	Help:      "Number of times loading a CUE lineage was retried after a transient filesystem error.",
+})
+
+// IsTransientLoadError reports whether err is an error reading CUE files that
+// may go away on its own, such as a file of a network-mounted filesystem
+// that is briefly missing or timing out.
+func IsTransientLoadError(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ETIMEDOUT)
+}
+
+// retryTransient calls fn until it succeeds, fails with an error that is not
+// transient, or has been called maxAttempts times. The n-th retry waits a
+// random duration between half and all of initialDelay*2^(n-1).
+func retryTransient(maxAttempts int, initialDelay time.Duration, fn func() error) error {
+	delay := initialDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= maxAttempts || !IsTransientLoadError(err) {
+			return err
+		}
+
+		loadRetries.Inc()
+		if delay > 0 {
+			time.Sleep(delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)))
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,73 @@
+package cuectx
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+	"testing"
+	"time"
+
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/grafana/thema"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyFS fails the first failures calls to Open with err.
+type flakyFS struct {
+	fs.FS
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *flakyFS) Open(name string) (fs.File, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: f.err}
+	}
+	return f.FS.Open(name)
+}
+
+func TestLoadGrafanaInstancesWithThemaRetry(t *testing.T) {
+	t.Run("transient errors are retried", func(t *testing.T) {
+		rt := thema.NewRuntime(cuecontext.New())
+		fsys := &flakyFS{FS: testLineageFS, failures: 2, err: syscall.ETIMEDOUT}
+		retries := testutil.ToFloat64(loadRetries)
+
+		lin, err := LoadGrafanaInstancesWithThemaOpts("pkg/cuectx/retry/testlin", fsys, rt, WithRetry(3, time.Millisecond))
+		require.NoError(t, err)
+		assert.Equal(t, "testlin", lin.Name())
+		_, err = lin.Schema(thema.SV(0, 0))
+		require.NoError(t, err)
+		assert.Equal(t, float64(2), testutil.ToFloat64(loadRetries)-retries)
+	})
+
+	t.Run("missing files are retried", func(t *testing.T) {
+		rt := thema.NewRuntime(cuecontext.New())
+		fsys := &flakyFS{FS: testLineageFS, failures: 2, err: fs.ErrNotExist}
+
+		lin, err := LoadGrafanaInstancesWithThemaOpts("pkg/cuectx/retry/testlin", fsys, rt, WithRetry(3, time.Millisecond))
+		require.NoError(t, err)
+		assert.Equal(t, "testlin", lin.Name())
+	})
+
+	t.Run("attempts are bounded", func(t *testing.T) {
+		rt := thema.NewRuntime(cuecontext.New())
+		fsys := &flakyFS{FS: testLineageFS, failures: 2, err: syscall.ETIMEDOUT}
+
+		_, err := LoadGrafanaInstancesWithThemaOpts("pkg/cuectx/retry/testlin", fsys, rt, WithRetry(2, time.Millisecond))
+		require.ErrorIs(t, err, syscall.ETIMEDOUT)
+		assert.Equal(t, 2, fsys.calls)
+	})
+
+	t.Run("other errors fail immediately", func(t *testing.T) {
+		rt := thema.NewRuntime(cuecontext.New())
+		fsys := &flakyFS{FS: testLineageFS, failures: 2, err: fs.ErrPermission}
+
+		_, err := LoadGrafanaInstancesWithThemaOpts("pkg/cuectx/retry/testlin", fsys, rt, WithRetry(3, time.Millisecond))
+		require.True(t, errors.Is(err, fs.ErrPermission))
+		assert.Equal(t, 1, fsys.calls)
+	})
+}
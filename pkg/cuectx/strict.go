@@ -0,0 +1,115 @@
+package cuectx
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue/parser"
+
+	"github.com/grafana/grafana"
+)
+
+const (
+	grafanaModule = "github.com/grafana/grafana"
+	themaModule   = "github.com/grafana/thema"
+)
+
+// StrictValidationError is returned from [PrefixWithGrafanaCUEOpts] with
+// [WithStrictInputValidation] when the input fs.FS breaks any of the rules of
+// strict mode. It holds one violation per offending file or import.
+type StrictValidationError struct {
+	Violations []string
+}
+
+func (e *StrictValidationError) Error() string {
+	return "input fs.FS failed strict validation: " + strings.Join(e.Violations, "; ")
+}
+
+// validateStrictInput checks that inputfs, to be mounted at prefix, declares
+// no CUE module of its own, contains no CUE test files and only imports CUE
+// packages that are in [grafana.CueSchemaFS], in inputfs itself, in the
+// standard library or in thema.
+func validateStrictInput(prefix string, inputfs fs.FS) error {
+	var violations []string
+	err := fs.WalkDir(inputfs, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == "cue.mod" {
+				violations = append(violations, fmt.Sprintf("%s: input must not contain a cue.mod directory", p))
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(p, ".cue") {
+			return nil
+		}
+		if strings.HasSuffix(p, "_test.cue") {
+			violations = append(violations, fmt.Sprintf("%s: input must not contain CUE test files", p))
+			return nil
+		}
+
+		b, err := fs.ReadFile(inputfs, p)
+		if err != nil {
+			return err
+		}
+		f, err := parser.ParseFile(p, b, parser.ImportsOnly)
+		if err != nil {
+			return err
+		}
+		for _, spec := range f.Imports {
+			importPath, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				return err
+			}
+			if !strictImportAllowed(prefix, inputfs, importPath) {
+				violations = append(violations, fmt.Sprintf("%s: import %q is not in the Grafana CUE schemas", p, importPath))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return &StrictValidationError{Violations: violations}
+	}
+	return nil
+}
+
+func strictImportAllowed(prefix string, inputfs fs.FS, importPath string) bool {
+	// Drop the package qualifier, as in "github.com/grafana/grafana/x:y".
+	if i := strings.LastIndex(importPath, ":"); i >= 0 {
+		importPath = importPath[:i]
+	}
+
+	// Standard library packages have no domain in their first element.
+	if !strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".") {
+		return true
+	}
+	if importPath == themaModule {
+		return true
+	}
+
+	dir := strings.TrimPrefix(importPath, grafanaModule+"/")
+	if dir == importPath {
+		return false
+	}
+	if isDir(grafana.CueSchemaFS, dir) {
+		return true
+	}
+	prefix = path.Clean(strings.ReplaceAll(prefix, "\\", "/"))
+	if rel := strings.TrimPrefix(dir, prefix+"/"); rel != dir {
+		return isDir(inputfs, rel)
+	}
+	return false
+}
+
+func isDir(fsys fs.FS, name string) bool {
+	info, err := fs.Stat(fsys, name)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,125 @@
+package cuectx
+
+import (
+	"fmt"
+	"strings"
+
+	"cuelang.org/go/cue"
+	cueerrors "cuelang.org/go/cue/errors"
+)
+
+// FieldError is a single failure reported when validating a value against a
+// schema.
+type FieldError struct {
+	// Path is the path of the invalid field in the value, e.g. "panels.0.type".
+	// Fields that the schema does not allow are reported at the path of their
+	// parent, with the name of the field in Message.
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) String() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// ValidationError is returned from [JSONtoCUEWithSchema] when the decoded JSON
+// does not satisfy the schema. It holds one [FieldError] per failure.
+type ValidationError struct {
+	// Path is the name given to the input bytes.
+	Path   string
+	Fields []FieldError
+
+	err error
+}
+
+// newValidationError converts the errors of validating against schema. Paths
+// are made relative to schema, so that they are paths in the input JSON.
+func newValidationError(path string, schema cue.Value, err error) *ValidationError {
+	var prefix []string
+	for _, sel := range schema.Path().Selectors() {
+		prefix = append(prefix, sel.String())
+	}
+
+	verr := &ValidationError{Path: path, err: err}
+	for _, e := range cueerrors.Errors(err) {
+		fieldPath := e.Path()
+		if hasPathPrefix(fieldPath, prefix) {
+			fieldPath = fieldPath[len(prefix):]
+		}
+		format, args := e.Msg()
+		verr.Fields = append(verr.Fields, FieldError{
+			Path:    strings.Join(fieldPath, "."),
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+	return verr
+}
+
+func hasPathPrefix(path, prefix []string) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.String())
+	}
+	return fmt.Sprintf("%s does not match schema: %s", e.Path, strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the underlying CUE error.
+func (e *ValidationError) Unwrap() error {
+	return e.err
+}
+
+// JSONtoCUEWithSchema is [JSONtoCUE], additionally requiring the decoded value
+// to be concrete and to satisfy schema. The schema must have been built with
+// the context returned from [GrafanaCUEContext]. If schema is a closed
+// definition, fields of the JSON that the schema does not declare are errors.
+//
+// Validation failures are returned as a [*ValidationError].
+func JSONtoCUEWithSchema(path string, b []byte, schema cue.Value) (cue.Value, error) {
+	v, err := JSONtoCUE(path, b)
+	if err != nil {
+		return cue.Value{}, err
+	}
+
+	cueMu.Lock()
+	defer cueMu.Unlock()
+	v = schema.Unify(v)
+	if err := v.Validate(cue.Concrete(true)); err != nil {
+		return cue.Value{}, newValidationError(path, schema, err)
+	}
+	return v, nil
+}
+
+// JSONtoCUESchemaAll calls [JSONtoCUEWithSchema] on each of blobs. The i-th
+// returned value and error are the result for blobs[i]; the errors slice is
+// nil when all blobs are valid.
+func JSONtoCUESchemaAll(path string, blobs [][]byte, schema cue.Value) ([]cue.Value, []error) {
+	vals := make([]cue.Value, len(blobs))
+	var errs []error
+	for i, b := range blobs {
+		v, err := JSONtoCUEWithSchema(fmt.Sprintf("%s[%d]", path, i), b, schema)
+		if err != nil {
+			if errs == nil {
+				errs = make([]error, len(blobs))
+			}
+			errs[i] = err
+			continue
+		}
+		vals[i] = v
+	}
+	return vals, errs
+}
@@ -0,0 +1,100 @@
+package cuectx
+
+import (
+	"errors"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSchema(t *testing.T) cue.Value {
+	t.Helper()
+	v := GrafanaCUEContext().CompileString(`
+#Panel: {
+	title: string
+	gridPos: {
+		w: int
+		h: int
+	}
+}`)
+	require.NoError(t, v.Err())
+	return v.LookupPath(cue.ParsePath("#Panel"))
+}
+
+func TestJSONtoCUEWithSchema(t *testing.T) {
+	schema := testSchema(t)
+
+	t.Run("valid JSON", func(t *testing.T) {
+		v, err := JSONtoCUEWithSchema("panel.json", []byte(`{"title": "CPU", "gridPos": {"w": 12, "h": 8}}`), schema)
+		require.NoError(t, err)
+
+		w, err := v.LookupPath(cue.ParsePath("gridPos.w")).Int64()
+		require.NoError(t, err)
+		assert.EqualValues(t, 12, w)
+	})
+
+	t.Run("wrong field type", func(t *testing.T) {
+		_, err := JSONtoCUEWithSchema("panel.json", []byte(`{"title": "CPU", "gridPos": {"w": "wide", "h": 8}}`), schema)
+
+		var verr *ValidationError
+		require.True(t, errors.As(err, &verr), err)
+		assert.Equal(t, "panel.json", verr.Path)
+		require.NotEmpty(t, verr.Fields)
+		assert.Equal(t, "gridPos.w", verr.Fields[0].Path)
+	})
+
+	t.Run("field missing from closed schema", func(t *testing.T) {
+		_, err := JSONtoCUEWithSchema("panel.json", []byte(`{"title": "CPU", "gridPos": {"w": 12, "h": 8}, "extra": true}`), schema)
+
+		var verr *ValidationError
+		require.True(t, errors.As(err, &verr), err)
+		require.NotEmpty(t, verr.Fields)
+		assert.Empty(t, verr.Fields[0].Path)
+		assert.Contains(t, verr.Fields[0].Message, "extra")
+	})
+
+	t.Run("missing required field", func(t *testing.T) {
+		_, err := JSONtoCUEWithSchema("panel.json", []byte(`{"gridPos": {"w": 12, "h": 8}}`), schema)
+
+		var verr *ValidationError
+		require.True(t, errors.As(err, &verr), err)
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		_, err := JSONtoCUEWithSchema("panel.json", []byte(`{`), schema)
+		require.Error(t, err)
+
+		var verr *ValidationError
+		require.False(t, errors.As(err, &verr))
+	})
+}
+
+func TestJSONtoCUESchemaAll(t *testing.T) {
+	schema := testSchema(t)
+
+	t.Run("all valid", func(t *testing.T) {
+		vals, errs := JSONtoCUESchemaAll("panels", [][]byte{
+			[]byte(`{"title": "a", "gridPos": {"w": 1, "h": 1}}`),
+			[]byte(`{"title": "b", "gridPos": {"w": 2, "h": 2}}`),
+		}, schema)
+		require.Nil(t, errs)
+		require.Len(t, vals, 2)
+	})
+
+	t.Run("errors are reported per blob", func(t *testing.T) {
+		vals, errs := JSONtoCUESchemaAll("panels", [][]byte{
+			[]byte(`{"title": "a", "gridPos": {"w": 1, "h": 1}}`),
+			[]byte(`{"title": 1, "gridPos": {"w": 2, "h": 2}}`),
+		}, schema)
+		require.Len(t, errs, 2)
+		assert.NoError(t, errs[0])
+		assert.True(t, vals[0].Exists())
+
+		var verr *ValidationError
+		require.True(t, errors.As(errs[1], &verr), errs[1])
+		assert.Equal(t, "panels[1]", verr.Path)
+		assert.False(t, vals[1].Exists())
+	})
+}
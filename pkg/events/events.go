@@ -19,6 +19,14 @@ type OrgUpdated struct {
 	Name      string    `json:"name"`
 }
 
+type OrgDeleted struct {
+	Timestamp time.Time `json:"timestamp"`
+	Id        int64     `json:"id"`
+	// PreferencesDeletedForOrg is set by the preferences service to the
+	// number of preferences it deleted for the org.
+	PreferencesDeletedForOrg int `json:"preferences_deleted_for_org"`
+}
+
 type UserCreated struct {
 	Timestamp time.Time `json:"timestamp"`
 	Id        int64     `json:"id"`
@@ -47,6 +55,17 @@ type UserUpdated struct {
 	Email     string    `json:"email"`
 }
 
+type UserDeleted struct {
+	Timestamp time.Time `json:"timestamp"`
+	Id        int64     `json:"id"`
+}
+
+type TeamDeleted struct {
+	Timestamp time.Time `json:"timestamp"`
+	Id        int64     `json:"id"`
+	OrgId     int64     `json:"org_id"`
+}
+
 type DataSourceDeleted struct {
 	Timestamp time.Time `json:"timestamp"`
 	Name      string    `json:"name"`
@@ -71,6 +90,25 @@ type DataSourceCreated struct {
 	OrgID     int64     `json:"org_id"`
 }
 
+type APIKeyRenewed struct {
+	Timestamp time.Time `json:"timestamp"`
+	ID        int64     `json:"id"`
+	OrgID     int64     `json:"org_id"`
+	Expires   int64     `json:"expires"`
+}
+
+// TemporaryPermissionExpired is published when an expired temporary
+// permission is deleted.
+type TemporaryPermissionExpired struct {
+	Timestamp time.Time `json:"timestamp"`
+	ID        int64     `json:"id"`
+	OrgID     int64     `json:"org_id"`
+	UserID    int64     `json:"user_id"`
+	Action    string    `json:"action"`
+	Scope     string    `json:"scope"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
 type FolderTitleUpdated struct {
 	Timestamp time.Time `json:"timestamp"`
 	Title     string    `json:"name"`
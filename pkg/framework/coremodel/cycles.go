@@ -0,0 +1,141 @@
+package coremodel
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue/parser"
+)
+
+// ErrCUECyclicImport is returned when the CUE packages the framework is loaded
+// from import each other in a cycle. The CUE loader does not terminate on such
+// sources, so they must be rejected before loading.
+var ErrCUECyclicImport = errors.New("cyclic import between CUE packages")
+
+// DetectCUEImportCycles returns the import cycles between the CUE packages in
+// fsys, each as the import paths of the packages in the cycle. The packages are
+// the directories of fsys containing .cue files, the root directory having the
+// import path importPath and every other directory importPath followed by its
+// path. Imports of packages outside of fsys are ignored.
+//
+// The graph is walked depth-first in import path order, and a cycle is
+// reported for each import leading back to a package still being walked, so
+// the result is stable for given sources.
+func DetectCUEImportCycles(fsys fs.FS, importPath string) ([][]string, error) {
+	graph := make(map[string]map[string]bool)
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(p) != ".cue" {
+			return nil
+		}
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		f, err := parser.ParseFile(p, b, parser.ImportsOnly)
+		if err != nil {
+			return err
+		}
+
+		pkg := packageImportPath(importPath, path.Dir(p))
+		if graph[pkg] == nil {
+			graph[pkg] = make(map[string]bool)
+		}
+		for _, spec := range f.Imports {
+			imp, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				return fmt.Errorf("%s: invalid import path %s", p, spec.Path.Value)
+			}
+			// drop the package qualifier of imports such as "example.com/a:b"
+			if i := strings.LastIndex(imp, ":"); i > strings.LastIndex(imp, "/") {
+				imp = imp[:i]
+			}
+			graph[pkg][imp] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	const (
+		unvisited = iota
+		walking
+		done
+	)
+	state := make(map[string]int, len(graph))
+	var (
+		stack  []string
+		cycles [][]string
+		walk   func(pkg string)
+	)
+	walk = func(pkg string) {
+		state[pkg] = walking
+		stack = append(stack, pkg)
+		for _, imp := range sortedKeys(graph[pkg]) {
+			if _, ok := graph[imp]; !ok {
+				continue
+			}
+			switch state[imp] {
+			case unvisited:
+				walk(imp)
+			case walking:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == imp {
+						cycles = append(cycles, append([]string(nil), stack[i:]...))
+						break
+					}
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[pkg] = done
+	}
+	for _, pkg := range sortedKeys(graph) {
+		if state[pkg] == unvisited {
+			walk(pkg)
+		}
+	}
+	return cycles, nil
+}
+
+// checkCUEImportCycles returns ErrCUECyclicImport, detailing the cycles, if
+// the CUE packages in fsys import each other in a cycle.
+func checkCUEImportCycles(fsys fs.FS, importPath string) error {
+	cycles, err := DetectCUEImportCycles(fsys, importPath)
+	if err != nil {
+		return err
+	}
+	if len(cycles) == 0 {
+		return nil
+	}
+
+	details := make([]string, 0, len(cycles))
+	for _, cycle := range cycles {
+		details = append(details, strings.Join(append(cycle, cycle[0]), " -> "))
+	}
+	return fmt.Errorf("%w: %s", ErrCUECyclicImport, strings.Join(details, "; "))
+}
+
+func packageImportPath(root, dir string) string {
+	if dir == "." {
+		return root
+	}
+	return root + "/" + dir
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,62 @@
+package coremodel
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cueFile(src string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(src)}
+}
+
+func TestDetectCUEImportCycles(t *testing.T) {
+	t.Run("packages importing each other", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"a/a.cue": cueFile("package a\n\nimport \"example.com/b\"\n\nA: b.B\n"),
+			"b/b.cue": cueFile("package b\n\nimport \"example.com/a\"\n\nB: 1\nC: a.A\n"),
+		}
+		cycles, err := DetectCUEImportCycles(fsys, "example.com")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"example.com/a", "example.com/b"}}, cycles)
+
+		err = checkCUEImportCycles(fsys, "example.com")
+		assert.ErrorIs(t, err, ErrCUECyclicImport)
+		assert.Contains(t, err.Error(), "example.com/a -> example.com/b -> example.com/a")
+	})
+
+	t.Run("acyclic and external imports", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"root.cue": cueFile("package root\n\nimport (\n\t\"example.com/a\"\n\t\"strings\"\n)\n\nR: a.A + strings.ToUpper(\"x\")\n"),
+			"a/a.cue":  cueFile("package a\n\nimport \"example.com/b:bee\"\n\nA: bee.B\n"),
+			"b/b.cue":  cueFile("package bee\n\nimport \"github.com/grafana/thema\"\n\nB: thema.#Lineage\n"),
+		}
+		cycles, err := DetectCUEImportCycles(fsys, "example.com")
+		require.NoError(t, err)
+		assert.Empty(t, cycles)
+	})
+
+	t.Run("longer cycle", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"a/a.cue": cueFile("package a\n\nimport \"example.com/b\"\n\nA: b.B\n"),
+			"b/b.cue": cueFile("package b\n\nimport \"example.com/c\"\n\nB: c.C\n"),
+			"c/c.cue": cueFile("package c\n\nimport \"example.com/a\"\n\nC: a.A\n"),
+		}
+		cycles, err := DetectCUEImportCycles(fsys, "example.com")
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"example.com/a", "example.com/b", "example.com/c"}}, cycles)
+	})
+
+	t.Run("invalid source", func(t *testing.T) {
+		_, err := DetectCUEImportCycles(fstest.MapFS{"a/a.cue": cueFile("package a\n\nimport (\n")}, "example.com")
+		assert.Error(t, err)
+	})
+
+	t.Run("framework sources", func(t *testing.T) {
+		cycles, err := DetectCUEImportCycles(cueFS, frameworkImportPath)
+		require.NoError(t, err)
+		assert.Empty(t, cycles)
+	})
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/fs"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing/fstest"
 
 	"cuelang.org/go/cue"
@@ -21,6 +23,17 @@ var cueFS embed.FS
 
 var defaultFramework cue.Value
 
+var (
+	// frameworks maps the *cue.Context passed to CUEFrameworkWithContext to
+	// the framework value built against it.
+	frameworks sync.Map
+	// frameworksGen is the cuectx generation the entries of frameworks were
+	// built in.
+	frameworksGen uint64
+	// buildFramework builds the framework value, replaceable for tests.
+	buildFramework = doLoadFrameworkCUE
+)
+
 func init() {
 	var err error
 	defaultFramework, err = doLoadFrameworkCUE(cuectx.GrafanaCUEContext())
@@ -31,6 +44,9 @@ func init() {
 
 var prefix = filepath.Join("/pkg", "framework", "coremodel")
 
+// frameworkImportPath is the CUE import path of the framework package.
+const frameworkImportPath = "github.com/grafana/grafana/pkg/framework/coremodel"
+
 //nolint:nakedret
 func doLoadFrameworkCUE(ctx *cue.Context) (v cue.Value, err error) {
 	m := make(fstest.MapFS)
@@ -53,6 +69,11 @@ func doLoadFrameworkCUE(ctx *cue.Context) (v cue.Value, err error) {
 		return
 	}
 
+	// the CUE loader does not terminate on cyclic imports, so catch them first
+	if err = checkCUEImportCycles(m, frameworkImportPath); err != nil {
+		return
+	}
+
 	over := make(map[string]load.Source)
 
 	absolutePath := prefix
@@ -100,8 +121,28 @@ func CUEFramework() cue.Value {
 //
 // Prefer CUEFramework unless you understand cue.Context, and absolutely need
 // this control.
+//
+// The value is built once per cue.Context. Values built before a call to
+// ["github.com/grafana/grafana/pkg/cuectx".ShutdownCUEContext] are discarded.
 func CUEFrameworkWithContext(ctx *cue.Context) cue.Value {
+	if gen := cuectx.Generation(); atomic.LoadUint64(&frameworksGen) != gen {
+		clearFrameworks()
+		atomic.StoreUint64(&frameworksGen, gen)
+	}
+
+	if v, ok := frameworks.Load(ctx); ok {
+		return v.(cue.Value)
+	}
 	// Error guaranteed to be nil here because erroring would have caused init() to panic
-	v, _ := doLoadFrameworkCUE(ctx) // nolint:errcheck
-	return v
+	v, _ := buildFramework(ctx) // nolint:errcheck
+	actual, _ := frameworks.LoadOrStore(ctx, v)
+	return actual.(cue.Value)
+}
+
+// clearFrameworks drops all framework values built by CUEFrameworkWithContext.
+func clearFrameworks() {
+	frameworks.Range(func(key, _ interface{}) bool {
+		frameworks.Delete(key)
+		return true
+	})
 }
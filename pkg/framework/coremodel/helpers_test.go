@@ -0,0 +1,56 @@
+package coremodel
+
+import (
+	"reflect"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/cuectx"
+)
+
+func countFrameworkBuilds(t *testing.T) *int {
+	t.Helper()
+	builds := 0
+	orig := buildFramework
+	buildFramework = func(ctx *cue.Context) (cue.Value, error) {
+		builds++
+		return orig(ctx)
+	}
+	t.Cleanup(func() {
+		buildFramework = orig
+		clearFrameworks()
+	})
+	return &builds
+}
+
+func TestCUEFrameworkWithContext(t *testing.T) {
+	builds := countFrameworkBuilds(t)
+	ctx := cuecontext.New()
+
+	first := CUEFrameworkWithContext(ctx)
+	require.NoError(t, first.Err())
+	second := CUEFrameworkWithContext(ctx)
+	assert.Equal(t, 1, *builds)
+	assert.True(t, reflect.DeepEqual(first, second), "expected the cached value")
+
+	CUEFrameworkWithContext(cuecontext.New())
+	assert.Equal(t, 2, *builds, "expected a build per context")
+
+	t.Run("clearing the cache causes a rebuild", func(t *testing.T) {
+		clearFrameworks()
+		CUEFrameworkWithContext(ctx)
+		assert.Equal(t, 3, *builds)
+	})
+
+	t.Run("shutting down the CUE context causes a rebuild", func(t *testing.T) {
+		cuectx.ShutdownCUEContext()
+		CUEFrameworkWithContext(ctx)
+		assert.Equal(t, 4, *builds)
+		CUEFrameworkWithContext(ctx)
+		assert.Equal(t, 4, *builds)
+	})
+}
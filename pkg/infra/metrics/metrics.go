@@ -103,6 +103,12 @@ var (
 	// MAccessEvaluationCount is a metric gauge for total number of evaluation requests
 	MAccessEvaluationCount prometheus.Counter
 
+	// MAccessCircuitBreakerState is a metric gauge for the state of the access control permissions circuit breaker
+	MAccessCircuitBreakerState prometheus.Gauge
+
+	// MAccessCoalescedCount is a metric counter for permission requests served by coalescing with a concurrent request
+	MAccessCoalescedCount prometheus.Counter
+
 	// MPublicDashboardRequestCount is a metric counter for public dashboards requests
 	MPublicDashboardRequestCount prometheus.Counter
 
@@ -555,6 +561,20 @@ func init() {
 		Namespace: ExporterName,
 	})
 
+	MAccessCircuitBreakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:      "circuit_breaker_state",
+		Help:      "state of the permissions circuit breaker (0 closed, 1 half-open, 2 open)",
+		Namespace: ExporterName,
+		Subsystem: "accesscontrol",
+	})
+
+	MAccessCoalescedCount = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "coalesced_total",
+		Help:      "number of permission requests served by coalescing with a concurrent request",
+		Namespace: ExporterName,
+		Subsystem: "accesscontrol",
+	})
+
 	StatsTotalLibraryPanels = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name:      "stat_totals_library_panels",
 		Help:      "total amount of library panels in the database",
@@ -669,6 +689,8 @@ func initMetricVars() {
 		StatsTotalDashboardVersions,
 		StatsTotalAnnotations,
 		MAccessEvaluationCount,
+		MAccessCircuitBreakerState,
+		MAccessCoalescedCount,
 		StatsTotalLibraryPanels,
 		StatsTotalLibraryVariables,
 		StatsTotalDataKeys,
@@ -3,6 +3,8 @@ package metrics
 import (
 	"context"
 
+	"github.com/prometheus/client_golang/prometheus"
+
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/infra/metrics/graphitebridge"
 	"github.com/grafana/grafana/pkg/setting"
@@ -30,6 +32,11 @@ func ProvideService(cfg *setting.Cfg) (*InternalMetricsService, error) {
 	return s, s.readSettings()
 }
 
+// ProvideRegisterer provides the prometheus.Registerer services register their metrics with.
+func ProvideRegisterer() prometheus.Registerer {
+	return prometheus.DefaultRegisterer
+}
+
 type InternalMetricsService struct {
 	Cfg *setting.Cfg
 
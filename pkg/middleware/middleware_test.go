@@ -18,6 +18,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/grafana/grafana/pkg/api/dtos"
+	apikeygenprefix "github.com/grafana/grafana/pkg/components/apikeygenprefixed"
 	"github.com/grafana/grafana/pkg/infra/db/dbtest"
 	"github.com/grafana/grafana/pkg/infra/fs"
 	"github.com/grafana/grafana/pkg/infra/log"
@@ -215,6 +216,140 @@ func TestMiddlewareContext(t *testing.T) {
 		assert.Equal(t, "Expired API key", sc.respJson["message"])
 	})
 
+	middlewareScenario(t, "Valid API key, expired but within grace period", func(t *testing.T, sc *scenarioContext) {
+		sc.contextHandler.GetTime = fakeGetTime()
+
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		expires := sc.contextHandler.GetTime().Add(-1 * time.Second).Unix()
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{OrgId: 12, Role: org.RoleEditor, Key: keyhash, Expires: &expires, GracePeriodSeconds: 60}
+
+		sc.fakeReq("GET", "/").withValidApiKey().exec()
+
+		require.Equal(t, 200, sc.resp.Code)
+		assert.True(t, sc.context.IsSignedIn)
+		assert.Equal(t, "true", sc.resp.Header().Get("X-Grafana-Key-Expired"))
+		assert.Equal(t, "58", sc.resp.Header().Get("Retry-After"))
+	})
+
+	middlewareScenario(t, "Valid API key, expired and past grace period", func(t *testing.T, sc *scenarioContext) {
+		sc.contextHandler.GetTime = fakeGetTime()
+
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		expires := sc.contextHandler.GetTime().Add(-1 * time.Second).Unix()
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{OrgId: 12, Role: org.RoleEditor, Key: keyhash, Expires: &expires, GracePeriodSeconds: 1}
+
+		sc.fakeReq("GET", "/").withValidApiKey().exec()
+
+		assert.Equal(t, 401, sc.resp.Code)
+		assert.Equal(t, "Expired API key", sc.respJson["message"])
+		assert.Empty(t, sc.resp.Header().Get("X-Grafana-Key-Expired"))
+	})
+
+	middlewareScenario(t, "Valid API key, from an allowed IP", func(t *testing.T, sc *scenarioContext) {
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{OrgId: 12, Role: org.RoleEditor, Key: keyhash, AllowedCIDRs: apikey.CIDRList{"192.168.1.0/24"}}
+
+		sc.fakeReq("GET", "/").withValidApiKey()
+		sc.req.RemoteAddr = "192.168.1.10:12345"
+		sc.exec()
+
+		require.Equal(t, 200, sc.resp.Code)
+		assert.True(t, sc.context.IsSignedIn)
+	})
+
+	middlewareScenario(t, "Valid API key with scopes", func(t *testing.T, sc *scenarioContext) {
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{OrgId: 12, Role: org.RoleEditor, Key: keyhash, Scopes: apikey.ScopeList{"grafana:read:dashboards"}}
+
+		sc.fakeReq("GET", "/").withValidApiKey().exec()
+
+		require.Equal(t, 200, sc.resp.Code)
+		assert.True(t, sc.context.IsSignedIn)
+		assert.Equal(t, []string{"grafana:read:dashboards"}, sc.context.OAuthScopes)
+	})
+
+	middlewareScenario(t, "Valid API key, from an IP not in its allowlist", func(t *testing.T, sc *scenarioContext) {
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{OrgId: 12, Role: org.RoleEditor, Key: keyhash, AllowedCIDRs: apikey.CIDRList{"192.168.1.0/24"}}
+
+		sc.fakeReq("GET", "/").withValidApiKey()
+		sc.req.RemoteAddr = "10.0.0.1:12345"
+		sc.exec()
+
+		assert.Equal(t, 403, sc.resp.Code)
+		assert.Equal(t, apikey.ErrAPIKeyIPNotAllowed.Error(), sc.respJson["message"])
+	})
+
+	middlewareScenario(t, "Valid API key, from an IP not in its allowlist with a forwarded allowed IP", func(t *testing.T, sc *scenarioContext) {
+		keyhash, err := util.EncodePassword("v5nAwpMafFP6znaS4urhdWDLS5511M42", "asd")
+		require.NoError(t, err)
+
+		sc.apiKeyService.ExpectedAPIKey = &apikey.APIKey{OrgId: 12, Role: org.RoleEditor, Key: keyhash, AllowedCIDRs: apikey.CIDRList{"192.168.1.0/24"}}
+
+		sc.fakeReq("GET", "/").withValidApiKey()
+		sc.req.RemoteAddr = "10.0.0.1:12345"
+		sc.req.Header.Set("X-Forwarded-For", "192.168.1.10")
+		sc.req.Header.Set("X-Real-IP", "192.168.1.10")
+		sc.exec()
+
+		assert.Equal(t, 403, sc.resp.Code)
+	})
+
+	middlewareScenario(t, "Valid service token", func(t *testing.T, sc *scenarioContext) {
+		const orgID, serviceAccountID int64 = 12, 5
+		gen, err := apikeygenprefix.New(apikey.ServiceTokenServiceID)
+		require.NoError(t, err)
+
+		sc.apiKeyService.ExpectedServiceToken = &apikey.ServiceToken{OrgID: orgID, ServiceAccountID: serviceAccountID, Key: gen.HashedKey}
+		sc.userService.ExpectedSignedInUser = &user.SignedInUser{OrgID: orgID, UserID: serviceAccountID}
+		sc.apiKey = gen.ClientSecret
+
+		sc.fakeReq("GET", "/").exec()
+
+		require.Equal(t, 200, sc.resp.Code)
+		assert.True(t, sc.context.IsSignedIn)
+		assert.Equal(t, serviceAccountID, sc.context.UserID)
+		assert.Zero(t, sc.context.ApiKeyID)
+	})
+
+	middlewareScenario(t, "Service token not found", func(t *testing.T, sc *scenarioContext) {
+		gen, err := apikeygenprefix.New(apikey.ServiceTokenServiceID)
+		require.NoError(t, err)
+
+		sc.apiKeyService.ExpectedError = apikey.ErrInvalid
+		sc.apiKey = gen.ClientSecret
+
+		sc.fakeReq("GET", "/").exec()
+
+		assert.Equal(t, 401, sc.resp.Code)
+		assert.Equal(t, contexthandler.InvalidAPIKey, sc.respJson["message"])
+	})
+
+	middlewareScenario(t, "Valid service token, but expired", func(t *testing.T, sc *scenarioContext) {
+		sc.contextHandler.GetTime = fakeGetTime()
+		gen, err := apikeygenprefix.New(apikey.ServiceTokenServiceID)
+		require.NoError(t, err)
+
+		expires := sc.contextHandler.GetTime().Add(-1 * time.Second).Unix()
+		sc.apiKeyService.ExpectedServiceToken = &apikey.ServiceToken{OrgID: 12, ServiceAccountID: 5, Key: gen.HashedKey, Expires: &expires}
+		sc.apiKey = gen.ClientSecret
+
+		sc.fakeReq("GET", "/").exec()
+
+		assert.Equal(t, 401, sc.resp.Code)
+		assert.Equal(t, "Expired service token", sc.respJson["message"])
+	})
+
 	middlewareScenario(t, "Non-expired auth token in cookie which is not being rotated", func(
 		t *testing.T, sc *scenarioContext) {
 		const userID int64 = 12
@@ -13,6 +13,12 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+func TestMain(m *testing.M) {
+	code := m.Run()
+	cuectx.ShutdownCUEContext()
+	os.Exit(code)
+}
+
 func TestParseTreeTestdata(t *testing.T) {
 	type tt struct {
 		tfs fs.FS
@@ -263,6 +263,7 @@ var wireBasicSet = wire.NewSet(
 	notifications.ProvideSmtpService,
 	tracing.ProvideService,
 	metrics.ProvideService,
+	metrics.ProvideRegisterer,
 	testdatasource.ProvideService,
 	opentsdb.ProvideService,
 	social.ProvideService,
@@ -343,6 +344,7 @@ var wireBasicSet = wire.NewSet(
 	starimpl.ProvideService,
 	playlistimpl.ProvideService,
 	apikeyimpl.ProvideService,
+	apikeyimpl.ProvideTokenGenerator,
 	dashverimpl.ProvideService,
 	publicdashboardsService.ProvideService,
 	wire.Bind(new(publicdashboards.Service), new(*publicdashboardsService.PublicDashboardServiceImpl)),
@@ -12,9 +12,14 @@ import (
 	"github.com/grafana/grafana/pkg/setting"
 )
 
+//go:generate mockery --name AccessControl --output mocks --outpkg acmocks --filename access_control_mock.go
 type AccessControl interface {
-	// Evaluate evaluates access to the given resources.
-	Evaluate(ctx context.Context, user *user.SignedInUser, evaluator Evaluator) (bool, error)
+	// Evaluate evaluates access to the given resources, in the current org of the
+	// user unless WithOrgScope is passed.
+	Evaluate(ctx context.Context, user *user.SignedInUser, evaluator Evaluator, opts ...EvalOption) (bool, error)
+	// EvaluateWithTrace evaluates access like Evaluate and returns which permissions
+	// of the user, and through which roles, were checked and granted access.
+	EvaluateWithTrace(ctx context.Context, user *user.SignedInUser, evaluator Evaluator) (*EvalTrace, error)
 	// RegisterScopeAttributeResolver allows the caller to register a scope resolver for a
 	// specific scope prefix (ex: datasources:name:)
 	RegisterScopeAttributeResolver(prefix string, resolver ScopeAttributeResolver)
@@ -22,6 +27,7 @@ type AccessControl interface {
 	IsDisabled() bool
 }
 
+//go:generate mockery --name Service --output mocks --outpkg acmocks --filename service_mock.go
 type Service interface {
 	registry.ProvidesUsageStats
 	// GetUserPermissions returns user permissions with only action and scope fields set.
@@ -29,6 +35,56 @@ type Service interface {
 	// DeleteUserPermissions removes all permissions user has in org and all permission to that user
 	// If orgID is set to 0 remove permissions from all orgs
 	DeleteUserPermissions(ctx context.Context, orgID, userID int64) error
+	// RevokeAllUserRoles removes every role assigned to the user in the org on
+	// behalf of the actor and returns the number of revoked assignments
+	RevokeAllUserRoles(ctx context.Context, orgID, userID, actorID int64) (int, error)
+	// CopyUserPermissions assigns the roles of the source user to the target user
+	CopyUserPermissions(ctx context.Context, cmd *CopyPermissionsCommand) error
+	// DenyPermission denies the user an action on a scope, whatever roles grant it.
+	DenyPermission(ctx context.Context, cmd *DenyCommand) error
+	// ListDeniedPermissions returns the deny rules of the user in the org.
+	ListDeniedPermissions(ctx context.Context, orgID, userID int64) ([]DenyRule, error)
+	// RevokeDenyRule deletes a deny rule of the org.
+	RevokeDenyRule(ctx context.Context, orgID, ruleID int64) error
+	// GrantTemporaryPermission grants the user a permission until cmd.ExpiresAt.
+	GrantTemporaryPermission(ctx context.Context, cmd *TemporaryPermissionCommand) error
+	// GetServiceAccountPermissions returns the permissions of the roles assigned to
+	// the service account of the org.
+	GetServiceAccountPermissions(ctx context.Context, orgID, serviceAccountID int64) ([]Permission, error)
+	// GrantServiceAccountRole assigns a role to a service account of the org. The
+	// granter needs every permission of the role.
+	GrantServiceAccountRole(ctx context.Context, granter *user.SignedInUser, cmd *ServiceAccountRoleCommand) error
+	// RevokeServiceAccountRole revokes a role from a service account of the org.
+	RevokeServiceAccountRole(ctx context.Context, cmd *ServiceAccountRoleCommand) error
+	// SnapshotPermissions returns the permissions held by every user of the org at this point in time.
+	SnapshotPermissions(ctx context.Context, orgID int64) (*PermissionSnapshot, error)
+	// StoreSnapshot persists a permission snapshot and sets its ID.
+	StoreSnapshot(ctx context.Context, snap *PermissionSnapshot) error
+	// GetSnapshot returns a stored permission snapshot of the org.
+	GetSnapshot(ctx context.Context, orgID, snapshotID int64) (*PermissionSnapshot, error)
+	// ListSnapshots returns the metadata of all stored permission snapshots of the org.
+	ListSnapshots(ctx context.Context, orgID int64) ([]*SnapshotMeta, error)
+	// GetSimplifiedUsersPermissionsPaged returns a page of the actions starting with actionPrefix
+	// held by the users of the org that the requester can read, ordered by user ID.
+	GetSimplifiedUsersPermissionsPaged(ctx context.Context, requester *user.SignedInUser, orgID int64, actionPrefix, cursor string, limit int) (*PagedPermissions, error)
+	// ImpersonateUser returns the target user of the admin's org signed in on behalf of
+	// the admin. The admin needs the ActionImpersonateUsers permission on the target.
+	ImpersonateUser(ctx context.Context, admin *user.SignedInUser, targetUserID int64) (*user.SignedInUser, error)
+	// RegisterPermissionTemplate makes a permission template available to
+	// ApplyPermissionTemplate. Template names are unique.
+	RegisterPermissionTemplate(tmpl PermissionTemplate) error
+	// GetPermissionTemplates returns the registered permission templates ordered by name.
+	GetPermissionTemplates() []PermissionTemplate
+	// ApplyPermissionTemplate adds the permissions rendered from a registered
+	// template to a role of the org and returns them.
+	ApplyPermissionTemplate(ctx context.Context, cmd *ApplyTemplateCommand) ([]Permission, error)
+	// GetRoleVersionHistory returns the last RoleVersionHistoryLimit versions
+	// of the permissions of a role of the org, newest first.
+	GetRoleVersionHistory(ctx context.Context, orgID int64, roleUID string) ([]*RoleVersion, error)
+	// ListPermissionsForResource returns who has access to the resource of the
+	// org with the given type and UID: the users, teams and built-in roles
+	// holding permissions scoped to the resource or to a wildcard covering it.
+	ListPermissionsForResource(ctx context.Context, orgID int64, resourceType, resourceUID string) ([]ResourcePermission, error)
 	// DeclareFixedRoles allows the caller to declare, to the service, fixed roles and their
 	// assignments to organization roles ("Viewer", "Editor", "Admin") or "Grafana Admin"
 	DeclareFixedRoles(registrations ...RoleRegistration) error
@@ -43,6 +99,28 @@ type RoleRegistry interface {
 
 type Options struct {
 	ReloadCache bool
+	// Filter restricts the returned permissions. Filtered requests bypass the permission cache.
+	Filter PermissionFilter
+}
+
+// PermissionFilter restricts permissions to those with an action starting with
+// ActionPrefix and a scope equal to Scope. Empty fields match all permissions.
+type PermissionFilter struct {
+	ActionPrefix string
+	Scope        string
+}
+
+// IsEmpty returns true if the filter matches all permissions.
+func (f PermissionFilter) IsEmpty() bool {
+	return f.ActionPrefix == "" && f.Scope == ""
+}
+
+// Matches returns true if the permission satisfies the filter.
+func (f PermissionFilter) Matches(p Permission) bool {
+	if f.ActionPrefix != "" && !strings.HasPrefix(p.Action, f.ActionPrefix) {
+		return false
+	}
+	return f.Scope == "" || p.Scope == f.Scope
 }
 
 type TeamPermissionsService interface {
@@ -102,7 +180,7 @@ func HasGlobalAccess(ac AccessControl, service Service, c *models.ReqContext) fu
 			if err != nil {
 				c.Logger.Error("failed fetching permissions for user", "userID", userCopy.UserID, "error", err)
 			}
-			userCopy.Permissions[GlobalOrgID] = GroupScopesByAction(permissions)
+			SetUserPermissions(&userCopy, GlobalOrgID, permissions)
 		}
 
 		hasAccess, err := ac.Evaluate(c.Req.Context(), &userCopy, evaluator)
@@ -113,6 +191,7 @@ func HasGlobalAccess(ac AccessControl, service Service, c *models.ReqContext) fu
 
 		// set on user so we don't fetch global permissions every time this is called
 		c.SignedInUser.Permissions[GlobalOrgID] = userCopy.Permissions[GlobalOrgID]
+		c.SignedInUser.PermissionConditions = userCopy.PermissionConditions
 
 		return hasAccess
 	}
@@ -170,15 +249,53 @@ func BuildPermissionsMap(permissions []Permission) map[string]bool {
 	return permissionsMap
 }
 
-// GroupScopesByAction will group scopes on action
+// GroupScopesByAction will group scopes on action. Permissions with conditions are
+// left out, see GroupConditionalScopesByAction.
 func GroupScopesByAction(permissions []Permission) map[string][]string {
 	m := make(map[string][]string)
 	for i := range permissions {
+		if len(permissions[i].Conditions) > 0 {
+			continue
+		}
 		m[permissions[i].Action] = append(m[permissions[i].Action], permissions[i].Scope)
 	}
 	return m
 }
 
+// GroupConditionalScopesByAction will group scopes of permissions with conditions on action
+func GroupConditionalScopesByAction(permissions []Permission) map[string][]user.ConditionalScope {
+	m := make(map[string][]user.ConditionalScope)
+	for i := range permissions {
+		if len(permissions[i].Conditions) == 0 {
+			continue
+		}
+		m[permissions[i].Action] = append(m[permissions[i].Action], user.ConditionalScope{
+			Scope:      permissions[i].Scope,
+			Conditions: permissions[i].Conditions,
+		})
+	}
+	return m
+}
+
+// SetUserPermissions stores permissions on the user for orgID, keeping permissions
+// with conditions apart so that they are only granted while their conditions hold.
+func SetUserPermissions(usr *user.SignedInUser, orgID int64, permissions []Permission) {
+	if usr.Permissions == nil {
+		usr.Permissions = make(map[int64]map[string][]string)
+	}
+	usr.Permissions[orgID] = GroupScopesByAction(permissions)
+
+	conditional := GroupConditionalScopesByAction(permissions)
+	if len(conditional) == 0 {
+		delete(usr.PermissionConditions, orgID)
+		return
+	}
+	if usr.PermissionConditions == nil {
+		usr.PermissionConditions = make(map[int64]map[string][]user.ConditionalScope)
+	}
+	usr.PermissionConditions[orgID] = conditional
+}
+
 func ValidateScope(scope string) bool {
 	prefix, last := scope[:len(scope)-1], scope[len(scope)-1]
 	// verify that last char is either ':' or '/' if last character of scope is '*'
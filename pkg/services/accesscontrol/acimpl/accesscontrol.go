@@ -3,6 +3,7 @@ package acimpl
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 
@@ -15,34 +16,65 @@ import (
 
 var _ accesscontrol.AccessControl = new(AccessControl)
 
-func ProvideAccessControl(cfg *setting.Cfg) *AccessControl {
+// DenyRuleLister returns the deny rules of a user.
+type DenyRuleLister interface {
+	ListDeniedPermissions(ctx context.Context, orgID, userID int64) ([]accesscontrol.DenyRule, error)
+}
+
+// PermissionSource provides the deny rules of users, the roles their
+// permissions come from and their memberships in other orgs.
+type PermissionSource interface {
+	DenyRuleLister
+	GetUserRolePermissions(ctx context.Context, user *user.SignedInUser) ([]accesscontrol.RolePermission, error)
+	UserInOrg(ctx context.Context, user *user.SignedInUser, orgID int64) (*user.SignedInUser, error)
+}
+
+// ProvideAccessControl returns the access control evaluator. Deny rules are only
+// checked when source is set.
+func ProvideAccessControl(cfg *setting.Cfg, source PermissionSource) *AccessControl {
 	logger := log.New("accesscontrol")
 	return &AccessControl{
-		cfg, logger, accesscontrol.NewResolvers(logger),
+		cfg, logger, accesscontrol.NewResolvers(logger), accesscontrol.NewConditionEvaluator(time.Now), source,
 	}
 }
 
 type AccessControl struct {
-	cfg       *setting.Cfg
-	log       log.Logger
-	resolvers accesscontrol.Resolvers
+	cfg        *setting.Cfg
+	log        log.Logger
+	resolvers  accesscontrol.Resolvers
+	conditions accesscontrol.ConditionEvaluator
+	source     PermissionSource
 }
 
-func (a *AccessControl) Evaluate(ctx context.Context, user *user.SignedInUser, evaluator accesscontrol.Evaluator) (bool, error) {
+func (a *AccessControl) Evaluate(ctx context.Context, user *user.SignedInUser, evaluator accesscontrol.Evaluator, opts ...accesscontrol.EvalOption) (bool, error) {
 	timer := prometheus.NewTimer(metrics.MAccessEvaluationsSummary)
 	defer timer.ObserveDuration()
 	metrics.MAccessEvaluationCount.Inc()
 
+	if options := accesscontrol.NewEvalOptions(opts...); options.OrgID != 0 && options.OrgID != user.OrgID {
+		scoped, err := a.userInOrg(ctx, user, options.OrgID)
+		if err != nil {
+			return false, err
+		}
+		user = scoped
+	}
+
 	if !verifyPermissions(user) {
 		a.log.Warn("no permissions set for user", "userID", user.UserID, "orgID", user.OrgID, "login", user.Login)
 		return false, nil
 	}
-	// Test evaluation without scope resolver first, this will prevent 403 for wildcard scopes when resource does not exist
-	if evaluator.Evaluate(user.Permissions[user.OrgID]) {
-		return true, nil
+	permissions := a.grantedPermissions(ctx, user)
+
+	// Test evaluation without scope resolver first, this will prevent 403 for wildcard scopes when resource does not exist.
+	// A negation could pass only because its scopes are not resolved yet, so it is always resolved first.
+	mutate := a.resolvers.GetScopeAttributeMutator(user.OrgID)
+	if accesscontrol.ContainsNegation(evaluator) {
+		mutate = keepUnresolvable(mutate)
+	} else if evaluator.Evaluate(permissions) {
+		return a.checkDenyRules(ctx, user, evaluator, permissions)
 	}
 
-	resolvedEvaluator, err := evaluator.MutateScopes(ctx, a.resolvers.GetScopeAttributeMutator(user.OrgID))
+	resolvedEvaluator, err := evaluator.MutateScopes(ctx, mutate)
 	if err != nil {
 		if errors.Is(err, accesscontrol.ErrResolverNotFound) {
 			return false, nil
@@ -50,7 +82,130 @@ func (a *AccessControl) Evaluate(ctx context.Context, user *user.SignedInUser, e
 		return false, err
 	}
 
-	return resolvedEvaluator.Evaluate(user.Permissions[user.OrgID]), nil
+	if !resolvedEvaluator.Evaluate(permissions) {
+		return false, nil
+	}
+	return a.checkDenyRules(ctx, user, resolvedEvaluator, permissions)
+}
+
+// EvaluateWithTrace evaluates access like Evaluate and explains the result with
+// the permissions of the user on the actions of the evaluator and the roles
+// granting them.
+func (a *AccessControl) EvaluateWithTrace(ctx context.Context, user *user.SignedInUser, evaluator accesscontrol.Evaluator) (*accesscontrol.EvalTrace, error) {
+	allowed, err := a.Evaluate(ctx, user, evaluator)
+	if err != nil {
+		return nil, err
+	}
+
+	// match the permissions against the scopes the evaluation resolved to
+	if accesscontrol.ContainsNegation(evaluator) {
+		if resolved, err := evaluator.MutateScopes(ctx, keepUnresolvable(a.resolvers.GetScopeAttributeMutator(user.OrgID))); err == nil {
+			evaluator = resolved
+		}
+	} else if !evaluator.Evaluate(a.grantedPermissions(ctx, user)) {
+		if resolved, err := evaluator.MutateScopes(ctx, a.resolvers.GetScopeAttributeMutator(user.OrgID)); err == nil {
+			evaluator = resolved
+		}
+	}
+
+	permissions, err := a.rolePermissions(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return accesscontrol.TraceEvaluation(evaluator, permissions, allowed), nil
+}
+
+// keepUnresolvable returns a mutator that keeps the scopes no resolver is
+// registered for as they are, since they have no other form to resolve to.
+func keepUnresolvable(mutate accesscontrol.ScopeAttributeMutator) accesscontrol.ScopeAttributeMutator {
+	return func(ctx context.Context, scope string) ([]string, error) {
+		scopes, err := mutate(ctx, scope)
+		if errors.Is(err, accesscontrol.ErrResolverNotFound) {
+			return []string{scope}, nil
+		}
+		return scopes, err
+	}
+}
+
+// userInOrg returns a copy of the user switched to the org. Unless the user
+// carries its permissions in the org, they are loaded from the source along
+// with the role and teams of the user in the org.
+func (a *AccessControl) userInOrg(ctx context.Context, u *user.SignedInUser, orgID int64) (*user.SignedInUser, error) {
+	if u.Permissions[orgID] != nil || a.source == nil {
+		return accesscontrol.UserInOrg(u, orgID), nil
+	}
+
+	scoped, err := a.source.UserInOrg(ctx, u, orgID)
+	if err != nil {
+		return nil, err
+	}
+	rolePermissions, err := a.source.GetUserRolePermissions(ctx, scoped)
+	if err != nil {
+		return nil, err
+	}
+	permissions := make([]accesscontrol.Permission, 0, len(rolePermissions))
+	for _, p := range rolePermissions {
+		permissions = append(permissions, accesscontrol.Permission{Action: p.Action, Scope: p.Scope})
+	}
+	accesscontrol.SetUserPermissions(scoped, orgID, permissions)
+	return scoped, nil
+}
+
+// rolePermissions returns the permissions of the user along with the roles
+// granting them. Without a source, the roles are unknown and left empty.
+func (a *AccessControl) rolePermissions(ctx context.Context, user *user.SignedInUser) ([]accesscontrol.RolePermission, error) {
+	if a.source != nil {
+		return a.source.GetUserRolePermissions(ctx, user)
+	}
+
+	var permissions []accesscontrol.RolePermission
+	for action, scopes := range user.Permissions[user.OrgID] {
+		for _, scope := range scopes {
+			permissions = append(permissions, accesscontrol.RolePermission{Action: action, Scope: scope})
+		}
+	}
+	return permissions, nil
+}
+
+// checkDenyRules is run once the permissions allow the evaluation and returns
+// false if a deny rule of the user blocks it.
+func (a *AccessControl) checkDenyRules(ctx context.Context, user *user.SignedInUser, evaluator accesscontrol.Evaluator, permissions map[string][]string) (bool, error) {
+	if a.source == nil || !user.IsRealUser() {
+		return true, nil
+	}
+
+	rules, err := a.source.ListDeniedPermissions(ctx, user.OrgID, user.UserID)
+	if err != nil {
+		return false, err
+	}
+	if accesscontrol.IsDenied(evaluator, permissions, rules) {
+		a.log.Debug("access denied by deny rule", "userID", user.UserID, "orgID", user.OrgID, "evaluator", evaluator.String())
+		return false, nil
+	}
+	return true, nil
+}
+
+// grantedPermissions returns the permissions of the user in its current org, including
+// the scopes of permissions with conditions that hold for the request in ctx.
+func (a *AccessControl) grantedPermissions(ctx context.Context, user *user.SignedInUser) map[string][]string {
+	permissions := user.Permissions[user.OrgID]
+	conditional := user.PermissionConditions[user.OrgID]
+	if len(conditional) == 0 {
+		return permissions
+	}
+
+	granted := make(map[string][]string, len(permissions)+len(conditional))
+	for action, scopes := range permissions {
+		granted[action] = append([]string{}, scopes...)
+	}
+	for action, scopes := range conditional {
+		for _, s := range scopes {
+			if a.conditions.Evaluate(ctx, s.Conditions) {
+				granted[action] = append(granted[action], s.Scope)
+			}
+		}
+	}
+	return granted
 }
 
 func (a *AccessControl) RegisterScopeAttributeResolver(prefix string, resolver accesscontrol.ScopeAttributeResolver) {
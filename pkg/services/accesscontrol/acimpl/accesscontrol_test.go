@@ -8,6 +8,7 @@ import (
 	"github.com/grafana/grafana/pkg/services/user"
 	"github.com/grafana/grafana/pkg/setting"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAccessControl_Evaluate(t *testing.T) {
@@ -59,11 +60,26 @@ func TestAccessControl_Evaluate(t *testing.T) {
 			}),
 			expected: true,
 		},
+		{
+			desc: "expect negation to be evaluated on resolved scopes",
+			user: user.SignedInUser{
+				OrgID: 1,
+				Permissions: map[int64]map[string][]string{
+					1: {accesscontrol.ActionTeamsWrite: {"teams:uid:a"}},
+				},
+			},
+			evaluator:      accesscontrol.EvalNot(accesscontrol.EvalPermission(accesscontrol.ActionTeamsWrite, "teams:id:1")),
+			resolverPrefix: "teams:id:",
+			resolver: accesscontrol.ScopeAttributeResolverFunc(func(ctx context.Context, orgID int64, scope string) ([]string, error) {
+				return []string{"teams:uid:a"}, nil
+			}),
+			expected: false,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.desc, func(t *testing.T) {
-			ac := ProvideAccessControl(setting.NewCfg())
+			ac := ProvideAccessControl(setting.NewCfg(), nil)
 
 			if tt.resolver != nil {
 				ac.RegisterScopeAttributeResolver(tt.resolverPrefix, tt.resolver)
@@ -79,3 +95,248 @@ func TestAccessControl_Evaluate(t *testing.T) {
 		})
 	}
 }
+
+func TestAccessControl_EvaluateConditions(t *testing.T) {
+	usr := &user.SignedInUser{
+		OrgID: 1,
+		Permissions: map[int64]map[string][]string{
+			1: {accesscontrol.ActionTeamsRead: {"teams:*"}},
+		},
+		PermissionConditions: map[int64]map[string][]user.ConditionalScope{
+			1: {accesscontrol.ActionTeamsWrite: {{Scope: "teams:*", Conditions: map[string]string{"allowed": "true"}}}},
+		},
+	}
+
+	ac := ProvideAccessControl(setting.NewCfg(), nil)
+	ac.conditions = accesscontrol.ConditionEvaluatorFunc(func(ctx context.Context, conds map[string]string) bool {
+		return conds["allowed"] == "true" && ctx.Value(allowKey{}) != nil
+	})
+
+	hasAccess, err := ac.Evaluate(context.Background(), usr, accesscontrol.EvalPermission(accesscontrol.ActionTeamsRead, "teams:id:1"))
+	require.NoError(t, err)
+	assert.True(t, hasAccess, "permissions without conditions are always granted")
+
+	hasAccess, err = ac.Evaluate(context.Background(), usr, accesscontrol.EvalPermission(accesscontrol.ActionTeamsWrite, "teams:id:1"))
+	require.NoError(t, err)
+	assert.False(t, hasAccess)
+
+	ctx := context.WithValue(context.Background(), allowKey{}, true)
+	hasAccess, err = ac.Evaluate(ctx, usr, accesscontrol.EvalPermission(accesscontrol.ActionTeamsWrite, "teams:id:1"))
+	require.NoError(t, err)
+	assert.True(t, hasAccess)
+	assert.Empty(t, usr.Permissions[1][accesscontrol.ActionTeamsWrite], "granted scopes must not be stored on the user")
+}
+
+type allowKey struct{}
+
+func TestAccessControl_EvaluateDenyRules(t *testing.T) {
+	usr := &user.SignedInUser{
+		OrgID:  1,
+		UserID: 2,
+		Permissions: map[int64]map[string][]string{
+			1: {accesscontrol.ActionTeamsWrite: {"teams:id:1", "teams:id:2"}},
+		},
+	}
+
+	tests := []struct {
+		desc      string
+		evaluator accesscontrol.Evaluator
+		rules     []accesscontrol.DenyRule
+		expected  bool
+	}{
+		{
+			desc:      "should permit when allowed and no rule denies",
+			evaluator: accesscontrol.EvalPermission(accesscontrol.ActionTeamsWrite, "teams:id:1"),
+			expected:  true,
+		},
+		{
+			desc:      "should deny when allowed and a rule matches",
+			evaluator: accesscontrol.EvalPermission(accesscontrol.ActionTeamsWrite, "teams:id:1"),
+			rules:     []accesscontrol.DenyRule{{OrgID: 1, UserID: 2, Action: accesscontrol.ActionTeamsWrite, Scope: "teams:id:1"}},
+			expected:  false,
+		},
+		{
+			desc:      "should deny when a rule without scope matches the action",
+			evaluator: accesscontrol.EvalPermission(accesscontrol.ActionTeamsWrite, "teams:id:1"),
+			rules:     []accesscontrol.DenyRule{{OrgID: 1, UserID: 2, Action: accesscontrol.ActionTeamsWrite}},
+			expected:  false,
+		},
+		{
+			desc:      "should deny when a rule covers one of the required permissions",
+			evaluator: accesscontrol.EvalAll(accesscontrol.EvalPermission(accesscontrol.ActionTeamsWrite, "teams:id:1"), accesscontrol.EvalPermission(accesscontrol.ActionTeamsWrite, "teams:id:2")),
+			rules:     []accesscontrol.DenyRule{{OrgID: 1, UserID: 2, Action: accesscontrol.ActionTeamsWrite, Scope: "teams:*"}},
+			expected:  false,
+		},
+		{
+			desc:      "should permit when allowed and the rule does not match",
+			evaluator: accesscontrol.EvalPermission(accesscontrol.ActionTeamsWrite, "teams:id:1"),
+			rules:     []accesscontrol.DenyRule{{OrgID: 1, UserID: 2, Action: accesscontrol.ActionTeamsWrite, Scope: "teams:id:2"}},
+			expected:  true,
+		},
+		{
+			desc:      "should deny when not allowed, whatever the rules",
+			evaluator: accesscontrol.EvalPermission(accesscontrol.ActionOrgUsersWrite, "users:id:1"),
+			rules:     []accesscontrol.DenyRule{{OrgID: 1, UserID: 2, Action: accesscontrol.ActionOrgUsersWrite, Scope: "users:id:1"}},
+			expected:  false,
+		},
+		{
+			desc:      "should permit a negation when an unrelated rule exists",
+			evaluator: accesscontrol.EvalNot(accesscontrol.EvalPermission(accesscontrol.ActionOrgUsersWrite, "users:id:1")),
+			rules:     []accesscontrol.DenyRule{{OrgID: 1, UserID: 2, Action: accesscontrol.ActionTeamsWrite, Scope: "teams:id:3"}},
+			expected:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			ac := ProvideAccessControl(setting.NewCfg(), fakeDenyRules(tt.rules))
+
+			hasAccess, err := ac.Evaluate(context.Background(), usr, tt.evaluator)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, hasAccess)
+		})
+	}
+}
+
+type fakeDenyRules []accesscontrol.DenyRule
+
+func (f fakeDenyRules) ListDeniedPermissions(ctx context.Context, orgID, userID int64) ([]accesscontrol.DenyRule, error) {
+	return f, nil
+}
+
+func (f fakeDenyRules) GetUserRolePermissions(ctx context.Context, user *user.SignedInUser) ([]accesscontrol.RolePermission, error) {
+	return nil, nil
+}
+
+func (f fakeDenyRules) UserInOrg(ctx context.Context, user *user.SignedInUser, orgID int64) (*user.SignedInUser, error) {
+	return accesscontrol.UserInOrg(user, orgID), nil
+}
+
+func TestAccessControl_EvaluateWithTrace(t *testing.T) {
+	usr := &user.SignedInUser{
+		OrgID:  1,
+		UserID: 2,
+		Permissions: map[int64]map[string][]string{
+			1: {
+				accesscontrol.ActionTeamsRead:  {"teams:id:1", "teams:*"},
+				accesscontrol.ActionTeamsWrite: {"teams:id:1"},
+			},
+		},
+	}
+	source := fakeRoleSource{
+		{RoleName: "managed:users:2:permissions", Action: accesscontrol.ActionTeamsRead, Scope: "teams:id:1"},
+		{RoleName: "basic:viewer", Action: accesscontrol.ActionTeamsRead, Scope: "teams:*"},
+		{RoleName: "managed:users:2:permissions", Action: accesscontrol.ActionTeamsWrite, Scope: "teams:id:1"},
+	}
+	ac := ProvideAccessControl(setting.NewCfg(), source)
+
+	t.Run("identifies the role granting access", func(t *testing.T) {
+		trace, err := ac.EvaluateWithTrace(context.Background(), usr, accesscontrol.EvalPermission(accesscontrol.ActionTeamsRead, "teams:id:3"))
+		require.NoError(t, err)
+
+		assert.True(t, trace.Allowed)
+		assert.Equal(t, []accesscontrol.EvalTraceRule{
+			{Role: "managed:users:2:permissions", Action: accesscontrol.ActionTeamsRead, Scope: "teams:id:1", Matched: false},
+			{Role: "basic:viewer", Action: accesscontrol.ActionTeamsRead, Scope: "teams:*", Matched: true},
+		}, trace.Rules)
+		require.NotNil(t, trace.Decisive)
+		assert.Equal(t, "basic:viewer", trace.Decisive.Role)
+	})
+
+	t.Run("lists the rules not matching when access is denied", func(t *testing.T) {
+		trace, err := ac.EvaluateWithTrace(context.Background(), usr, accesscontrol.EvalPermission(accesscontrol.ActionTeamsWrite, "teams:id:3"))
+		require.NoError(t, err)
+
+		assert.False(t, trace.Allowed)
+		assert.Equal(t, []accesscontrol.EvalTraceRule{
+			{Role: "managed:users:2:permissions", Action: accesscontrol.ActionTeamsWrite, Scope: "teams:id:1", Matched: false},
+		}, trace.Rules)
+		assert.Nil(t, trace.Decisive)
+	})
+}
+
+type fakeRoleSource []accesscontrol.RolePermission
+
+func (f fakeRoleSource) ListDeniedPermissions(ctx context.Context, orgID, userID int64) ([]accesscontrol.DenyRule, error) {
+	return nil, nil
+}
+
+func (f fakeRoleSource) GetUserRolePermissions(ctx context.Context, user *user.SignedInUser) ([]accesscontrol.RolePermission, error) {
+	return f, nil
+}
+
+func (f fakeRoleSource) UserInOrg(ctx context.Context, user *user.SignedInUser, orgID int64) (*user.SignedInUser, error) {
+	return accesscontrol.UserInOrg(user, orgID), nil
+}
+
+func TestAccessControl_EvaluateWithOrgScope(t *testing.T) {
+	evaluator := accesscontrol.EvalPermission(accesscontrol.ActionTeamsRead, "teams:id:1")
+	source := fakeOrgRoleSource{
+		2: {{RoleName: "managed:users:2:permissions", Action: accesscontrol.ActionTeamsRead, Scope: "teams:*"}},
+	}
+	ac := ProvideAccessControl(setting.NewCfg(), source)
+
+	t.Run("evaluates the permissions the user carries in the org", func(t *testing.T) {
+		usr := &user.SignedInUser{
+			OrgID:  1,
+			UserID: 2,
+			Permissions: map[int64]map[string][]string{
+				1: {},
+				2: {accesscontrol.ActionTeamsRead: {"teams:*"}},
+			},
+		}
+
+		allowed, err := ac.Evaluate(context.Background(), usr, evaluator)
+		require.NoError(t, err)
+		assert.False(t, allowed)
+
+		allowed, err = ac.Evaluate(context.Background(), usr, evaluator, accesscontrol.WithOrgScope(2))
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		assert.EqualValues(t, 1, usr.OrgID)
+	})
+
+	t.Run("loads the permissions of the user in the org", func(t *testing.T) {
+		usr := &user.SignedInUser{
+			OrgID:       1,
+			UserID:      2,
+			Permissions: map[int64]map[string][]string{1: {}},
+		}
+
+		allowed, err := ac.Evaluate(context.Background(), usr, evaluator)
+		require.NoError(t, err)
+		assert.False(t, allowed)
+
+		allowed, err = ac.Evaluate(context.Background(), usr, evaluator, accesscontrol.WithOrgScope(2))
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		assert.NotContains(t, usr.Permissions, int64(2))
+	})
+
+	t.Run("the current org is evaluated as without the option", func(t *testing.T) {
+		usr := &user.SignedInUser{
+			OrgID:       1,
+			UserID:      2,
+			Permissions: map[int64]map[string][]string{1: {accesscontrol.ActionTeamsRead: {"teams:id:1"}}},
+		}
+
+		allowed, err := ac.Evaluate(context.Background(), usr, evaluator, accesscontrol.WithOrgScope(1))
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	})
+}
+
+// fakeOrgRoleSource holds the role permissions of a user by org.
+type fakeOrgRoleSource map[int64][]accesscontrol.RolePermission
+
+func (f fakeOrgRoleSource) ListDeniedPermissions(ctx context.Context, orgID, userID int64) ([]accesscontrol.DenyRule, error) {
+	return nil, nil
+}
+
+func (f fakeOrgRoleSource) GetUserRolePermissions(ctx context.Context, user *user.SignedInUser) ([]accesscontrol.RolePermission, error) {
+	return f[user.OrgID], nil
+}
+
+func (f fakeOrgRoleSource) UserInOrg(ctx context.Context, user *user.SignedInUser, orgID int64) (*user.SignedInUser, error) {
+	return accesscontrol.UserInOrg(user, orgID), nil
+}
@@ -2,33 +2,49 @@ package acimpl
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/grafana/grafana/pkg/api/routing"
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/infra/db"
 	"github.com/grafana/grafana/pkg/infra/localcache"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/infra/metrics"
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	"github.com/grafana/grafana/pkg/infra/tracing"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/accesscontrol/api"
 	"github.com/grafana/grafana/pkg/services/accesscontrol/database"
 	"github.com/grafana/grafana/pkg/services/accesscontrol/ossaccesscontrol"
+	"github.com/grafana/grafana/pkg/services/featuremgmt"
 	"github.com/grafana/grafana/pkg/services/user"
 	"github.com/grafana/grafana/pkg/setting"
 )
 
 const (
 	cacheTTL = 10 * time.Second
+	// temporaryPermissionSweepInterval is how often expired temporary
+	// permissions are deleted. Expired permissions are never granted, even
+	// before they are deleted.
+	temporaryPermissionSweepInterval = time.Minute
 )
 
-func ProvideService(cfg *setting.Cfg, store db.DB, routeRegister routing.RouteRegister, cache *localcache.CacheService) (*Service, error) {
-	service := ProvideOSSService(cfg, database.ProvideService(store), cache)
+func ProvideService(cfg *setting.Cfg, store db.DB, routeRegister routing.RouteRegister, cache *localcache.CacheService, features *featuremgmt.FeatureManager, tracer tracing.Tracer, bus bus.Bus, serverLock *serverlock.ServerLockService) (*Service, error) {
+	service := ProvideOSSService(cfg, database.ProvideService(store), cache, features, tracer)
+	service.bus = bus
+	service.serverLock = serverLock
 
 	if !accesscontrol.IsDisabled(cfg) {
-		api.NewAccessControlAPI(routeRegister, service).RegisterAPIEndpoints()
+		api.NewAccessControlAPI(routeRegister, service, tracer).RegisterAPIEndpoints()
 		if err := accesscontrol.DeclareFixedRoles(service); err != nil {
 			return nil, err
 		}
@@ -37,13 +53,34 @@ func ProvideService(cfg *setting.Cfg, store db.DB, routeRegister routing.RouteRe
 	return service, nil
 }
 
-func ProvideOSSService(cfg *setting.Cfg, store store, cache *localcache.CacheService) *Service {
+// ProvideBatchingService returns service wrapped to coalesce concurrent
+// permission requests for the same user, unless coalescing is disabled.
+func ProvideBatchingService(cfg *setting.Cfg, service *Service) accesscontrol.Service {
+	if cfg.ACPermissionsCoalescingWindow <= 0 {
+		return service
+	}
+	return accesscontrol.NewBatchingPermissionService(service, cfg.ACPermissionsCoalescingWindow, metrics.MAccessCoalescedCount)
+}
+
+func ProvideOSSService(cfg *setting.Cfg, store store, cache *localcache.CacheService, features *featuremgmt.FeatureManager, tracer tracing.Tracer) *Service {
+	if cfg.ACCircuitBreakerMaxFailures > 0 {
+		store = &circuitBreakerStore{
+			store:       store,
+			permissions: accesscontrol.NewCircuitBreakerStore(store, cfg.ACCircuitBreakerMaxFailures, cfg.ACCircuitBreakerTimeout, cfg.ACCircuitBreakerFallbackMaxAge, metrics.MAccessCircuitBreakerState),
+		}
+	}
+
 	s := &Service{
-		cfg:   cfg,
-		store: store,
-		log:   log.New("accesscontrol.service"),
-		cache: cache,
-		roles: accesscontrol.BuildBasicRoleDefinitions(),
+		cfg:      cfg,
+		store:    store,
+		log:      log.New("accesscontrol.service"),
+		cache:    cache,
+		features: features,
+		tracer:   tracer,
+		roles:    accesscontrol.BuildBasicRoleDefinitions(),
+
+		flaggedPermissions: map[string][]flaggedPermission{},
+		templates:          map[string]accesscontrol.PermissionTemplate{},
 	}
 
 	return s
@@ -51,17 +88,62 @@ func ProvideOSSService(cfg *setting.Cfg, store store, cache *localcache.CacheSer
 
 type store interface {
 	GetUserPermissions(ctx context.Context, query accesscontrol.GetUserPermissionsQuery) ([]accesscontrol.Permission, error)
+	GetUserRolePermissions(ctx context.Context, query accesscontrol.GetUserPermissionsQuery) ([]accesscontrol.RolePermission, error)
 	DeleteUserPermissions(ctx context.Context, orgID, userID int64) error
+	RevokeAllUserRoles(ctx context.Context, orgID, userID, actorID int64) (int, error)
+	CopyUserRoles(ctx context.Context, cmd *accesscontrol.CopyPermissionsCommand) error
+	GetOrgUsers(ctx context.Context, orgID int64) ([]*user.SignedInUser, error)
+	GetOrgUser(ctx context.Context, orgID, userID int64) (*user.SignedInUser, error)
+	StoreSnapshot(ctx context.Context, snap *accesscontrol.PermissionSnapshot) error
+	GetSnapshot(ctx context.Context, orgID, snapshotID int64) (*accesscontrol.PermissionSnapshot, error)
+	ListSnapshots(ctx context.Context, orgID int64) ([]*accesscontrol.SnapshotMeta, error)
+	AddRolePermissions(ctx context.Context, orgID int64, roleUID string, permissions []accesscontrol.Permission, updatedBy int64) error
+	GetRoleVersionHistory(ctx context.Context, orgID int64, roleUID string) ([]*accesscontrol.RoleVersion, error)
+	ListResourcePermissions(ctx context.Context, orgID int64, scopes []string) ([]accesscontrol.ResourcePermission, error)
+	AddDenyRule(ctx context.Context, cmd *accesscontrol.DenyCommand) error
+	ListDenyRules(ctx context.Context, orgID, userID int64) ([]accesscontrol.DenyRule, error)
+	DeleteDenyRule(ctx context.Context, orgID, ruleID int64) (*accesscontrol.DenyRule, error)
+	AddTemporaryPermission(ctx context.Context, cmd *accesscontrol.TemporaryPermissionCommand) error
+	DeleteExpiredTemporaryPermissions(ctx context.Context, now time.Time) ([]accesscontrol.TemporaryPermission, error)
+	GetServiceAccountPermissions(ctx context.Context, orgID, serviceAccountID int64) ([]accesscontrol.Permission, error)
+	GetAssignableRolePermissions(ctx context.Context, cmd *accesscontrol.ServiceAccountRoleCommand) ([]accesscontrol.Permission, error)
+	AddServiceAccountRole(ctx context.Context, cmd *accesscontrol.ServiceAccountRoleCommand) error
+	RemoveServiceAccountRole(ctx context.Context, cmd *accesscontrol.ServiceAccountRoleCommand) error
+}
+
+// circuitBreakerStore loads user permissions through a circuit breaker and
+// passes everything else to the wrapped store.
+type circuitBreakerStore struct {
+	store
+	permissions *accesscontrol.CircuitBreakerStore
+}
+
+func (s *circuitBreakerStore) GetUserPermissions(ctx context.Context, query accesscontrol.GetUserPermissionsQuery) ([]accesscontrol.Permission, error) {
+	return s.permissions.GetUserPermissions(ctx, query)
 }
 
 // Service is the service implementing role based access control.
 type Service struct {
-	log           log.Logger
-	cfg           *setting.Cfg
-	store         store
-	cache         *localcache.CacheService
+	log      log.Logger
+	cfg      *setting.Cfg
+	store    store
+	cache    *localcache.CacheService
+	features *featuremgmt.FeatureManager
+	tracer   tracing.Tracer
+	bus      bus.Bus
+	// serverLock makes a single Grafana instance sweep expired temporary
+	// permissions at a time. Without it, every instance sweeps.
+	serverLock    *serverlock.ServerLockService
 	registrations accesscontrol.RegistrationList
 	roles         map[string]*accesscontrol.RoleDTO
+	// flaggedPermissions are the permissions basic roles are granted by
+	// registrations behind a feature toggle, by basic role. They are kept apart
+	// from the permissions of the roles so that toggling the feature takes
+	// effect without registering the roles again.
+	flaggedPermissions map[string][]flaggedPermission
+
+	templatesMu sync.RWMutex
+	templates   map[string]accesscontrol.PermissionTemplate
 }
 
 func (s *Service) GetUsageStats(_ context.Context) map[string]interface{} {
@@ -79,27 +161,95 @@ var actionsToFetch = append(
 	ossaccesscontrol.TeamAdminActions, append(ossaccesscontrol.DashboardAdminActions, append(ossaccesscontrol.FolderAdminActions, ossaccesscontrol.ServiceAccountAdminActions...)...)...,
 )
 
-// GetUserPermissions returns user permissions based on built-in roles
+// GetUserPermissions returns user permissions based on built-in roles. The
+// resolution is traced in an accesscontrol.GetUserPermissions span, with child
+// spans for the cache lookup and the database query.
 func (s *Service) GetUserPermissions(ctx context.Context, user *user.SignedInUser, options accesscontrol.Options) ([]accesscontrol.Permission, error) {
 	timer := prometheus.NewTimer(metrics.MAccessPermissionsSummary)
 	defer timer.ObserveDuration()
 
-	if !s.cfg.RBACPermissionCache || !user.HasUniqueId() {
-		return s.getUserPermissions(ctx, user, options)
+	ctx, span := s.tracer.Start(ctx, "accesscontrol.GetUserPermissions")
+	defer span.End()
+	span.SetAttributes("user.id", user.UserID, attribute.Key("user.id").Int64(user.UserID))
+	span.SetAttributes("org.id", user.OrgID, attribute.Key("org.id").Int64(user.OrgID))
+
+	var (
+		permissions []accesscontrol.Permission
+		hit         bool
+		err         error
+	)
+	if !s.cfg.RBACPermissionCache || !user.HasUniqueId() || !options.Filter.IsEmpty() {
+		permissions, err = s.getUserPermissions(ctx, user, options)
+	} else {
+		var cached *cachedPermissions
+		if cached, hit, err = s.getCachedPermissions(ctx, user, options); err == nil {
+			permissions = cached.permissions
+		}
 	}
-
-	return s.getCachedUserPermissions(ctx, user, options)
+	span.SetAttributes("cache.hit", hit, attribute.Key("cache.hit").Bool(hit))
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return permissions, nil
 }
 
 func (s *Service) getUserPermissions(ctx context.Context, user *user.SignedInUser, options accesscontrol.Options) ([]accesscontrol.Permission, error) {
 	permissions := make([]accesscontrol.Permission, 0)
 	for _, builtin := range accesscontrol.GetOrgRoles(user) {
 		if basicRole, ok := s.roles[builtin]; ok {
-			permissions = append(permissions, basicRole.Permissions...)
+			for _, p := range basicRole.Permissions {
+				if options.Filter.Matches(p) {
+					permissions = append(permissions, p)
+				}
+			}
 		}
+		for _, fp := range s.flaggedPermissions[builtin] {
+			if s.features.IsEnabled(fp.featureFlag) && options.Filter.Matches(fp.permission) {
+				permissions = append(permissions, fp.permission)
+			}
+		}
+	}
+
+	storeCtx, span := s.tracer.Start(ctx, "accesscontrol.GetUserPermissions.store")
+	dbPermissions, err := s.store.GetUserPermissions(storeCtx, accesscontrol.GetUserPermissionsQuery{
+		OrgID:        user.OrgID,
+		UserID:       user.UserID,
+		Roles:        accesscontrol.GetOrgRoles(user),
+		TeamIDs:      user.Teams,
+		Actions:      actionsToFetch,
+		ActionPrefix: options.Filter.ActionPrefix,
+		Scope:        options.Filter.Scope,
+	})
+	span.End()
+	if err != nil {
+		return nil, err
 	}
 
-	dbPermissions, err := s.store.GetUserPermissions(ctx, accesscontrol.GetUserPermissionsQuery{
+	// basic roles and the roles in the database can grant the same permission
+	return accesscontrol.Union(permissions, dbPermissions), nil
+}
+
+// GetUserRolePermissions returns the permissions of the user along with the
+// names of the roles granting them, bypassing the cache.
+func (s *Service) GetUserRolePermissions(ctx context.Context, user *user.SignedInUser) ([]accesscontrol.RolePermission, error) {
+	permissions := make([]accesscontrol.RolePermission, 0)
+	for _, builtin := range accesscontrol.GetOrgRoles(user) {
+		roleName := builtin
+		if basicRole, ok := s.roles[builtin]; ok {
+			roleName = basicRole.Name
+			for _, p := range basicRole.Permissions {
+				permissions = append(permissions, accesscontrol.RolePermission{RoleName: roleName, Action: p.Action, Scope: p.Scope})
+			}
+		}
+		for _, fp := range s.flaggedPermissions[builtin] {
+			if s.features.IsEnabled(fp.featureFlag) {
+				permissions = append(permissions, accesscontrol.RolePermission{RoleName: roleName, Action: fp.permission.Action, Scope: fp.permission.Scope})
+			}
+		}
+	}
+
+	dbPermissions, err := s.store.GetUserRolePermissions(ctx, accesscontrol.GetUserPermissionsQuery{
 		OrgID:   user.OrgID,
 		UserID:  user.UserID,
 		Roles:   accesscontrol.GetOrgRoles(user),
@@ -109,42 +259,560 @@ func (s *Service) getUserPermissions(ctx context.Context, user *user.SignedInUse
 	if err != nil {
 		return nil, err
 	}
-
 	return append(permissions, dbPermissions...), nil
 }
 
-func (s *Service) getCachedUserPermissions(ctx context.Context, user *user.SignedInUser, options accesscontrol.Options) ([]accesscontrol.Permission, error) {
+// cachedPermissions is what is cached for a user: the permissions and the
+// index built from them.
+type cachedPermissions struct {
+	permissions []accesscontrol.Permission
+	index       *accesscontrol.PermissionIndex
+}
+
+// getCachedPermissions returns the cached permissions of the user, loading and
+// caching them on a miss, and whether they were found in the cache.
+func (s *Service) getCachedPermissions(ctx context.Context, user *user.SignedInUser, options accesscontrol.Options) (*cachedPermissions, bool, error) {
 	key, err := permissionCacheKey(user)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	if !options.ReloadCache {
-		permissions, ok := s.cache.Get(key)
+		_, span := s.tracer.Start(ctx, "accesscontrol.GetUserPermissions.cache")
+		cached, ok := s.cache.Get(key)
+		span.End()
 		if ok {
 			s.log.Debug("using cached permissions", "key", key)
-			return permissions.([]accesscontrol.Permission), nil
+			return cached.(*cachedPermissions), true, nil
 		}
 	}
 
 	s.log.Debug("fetch permissions from store", "key", key)
 	permissions, err := s.getUserPermissions(ctx, user, options)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	s.log.Debug("cache permissions", "key", key)
-	s.cache.Set(key, permissions, cacheTTL)
+	cached := &cachedPermissions{permissions: permissions, index: accesscontrol.NewPermissionIndex(permissions)}
+	s.cache.Set(key, cached, cacheTTL)
 
-	return permissions, nil
+	return cached, false, nil
+}
+
+// GetUserPermissionIndex returns an index of the permissions of the user. The
+// index is cached together with the permissions when the permission cache is
+// enabled.
+func (s *Service) GetUserPermissionIndex(ctx context.Context, user *user.SignedInUser, options accesscontrol.Options) (*accesscontrol.PermissionIndex, error) {
+	if !s.cfg.RBACPermissionCache || !user.HasUniqueId() || !options.Filter.IsEmpty() {
+		permissions, err := s.getUserPermissions(ctx, user, options)
+		if err != nil {
+			return nil, err
+		}
+		return accesscontrol.NewPermissionIndex(permissions), nil
+	}
+
+	cached, _, err := s.getCachedPermissions(ctx, user, options)
+	if err != nil {
+		return nil, err
+	}
+	return cached.index, nil
 }
 
 func (s *Service) DeleteUserPermissions(ctx context.Context, orgID int64, userID int64) error {
 	return s.store.DeleteUserPermissions(ctx, orgID, userID)
 }
 
+// RevokeAllUserRoles removes all role assignments of the user in the org and drops
+// the user's cached permissions so the revocation takes effect immediately.
+func (s *Service) RevokeAllUserRoles(ctx context.Context, orgID, userID, actorID int64) (int, error) {
+	revoked, err := s.store.RevokeAllUserRoles(ctx, orgID, userID, actorID)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := permissionCacheKey(&user.SignedInUser{OrgID: orgID, UserID: userID})
+	if err != nil {
+		return 0, err
+	}
+	s.cache.Delete(key)
+
+	return revoked, nil
+}
+
+// CopyUserPermissions assigns the roles of the source user to the target user. Role
+// assignments are copied rather than permissions, so later changes to the roles
+// apply to both users.
+func (s *Service) CopyUserPermissions(ctx context.Context, cmd *accesscontrol.CopyPermissionsCommand) error {
+	if err := s.store.CopyUserRoles(ctx, cmd); err != nil {
+		return err
+	}
+
+	key, err := permissionCacheKey(&user.SignedInUser{OrgID: cmd.OrgID, UserID: cmd.TargetUserID})
+	if err != nil {
+		return err
+	}
+	s.cache.Delete(key)
+
+	return nil
+}
+
+// DenyPermission stores a deny rule for the user and drops the user's cached
+// deny rules so the rule takes effect immediately.
+func (s *Service) DenyPermission(ctx context.Context, cmd *accesscontrol.DenyCommand) error {
+	if cmd.Scope != "" && !accesscontrol.ValidateScope(cmd.Scope) {
+		return accesscontrol.ErrInvalidScope
+	}
+	if err := s.store.AddDenyRule(ctx, cmd); err != nil {
+		return err
+	}
+	s.cache.Delete(denyRulesCacheKey(cmd.OrgID, cmd.UserID))
+	return nil
+}
+
+// ListDeniedPermissions returns the deny rules of the user. They are checked on
+// every evaluation, so they are cached like permissions.
+func (s *Service) ListDeniedPermissions(ctx context.Context, orgID, userID int64) ([]accesscontrol.DenyRule, error) {
+	key := denyRulesCacheKey(orgID, userID)
+	if cached, ok := s.cache.Get(key); ok {
+		return cached.([]accesscontrol.DenyRule), nil
+	}
+
+	rules, err := s.store.ListDenyRules(ctx, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.Set(key, rules, cacheTTL)
+	return rules, nil
+}
+
+func (s *Service) RevokeDenyRule(ctx context.Context, orgID, ruleID int64) error {
+	rule, err := s.store.DeleteDenyRule(ctx, orgID, ruleID)
+	if err != nil {
+		return err
+	}
+	s.cache.Delete(denyRulesCacheKey(rule.OrgID, rule.UserID))
+	return nil
+}
+
+// GrantTemporaryPermission grants the permission to the user until
+// cmd.ExpiresAt and drops the user's cached permissions so the grant takes
+// effect immediately.
+func (s *Service) GrantTemporaryPermission(ctx context.Context, cmd *accesscontrol.TemporaryPermissionCommand) error {
+	if !cmd.ExpiresAt.After(time.Now()) {
+		return accesscontrol.ErrInvalidExpiry
+	}
+	if cmd.Permission.Scope != "" && !accesscontrol.ValidateScope(cmd.Permission.Scope) {
+		return accesscontrol.ErrInvalidScope
+	}
+	if err := s.store.AddTemporaryPermission(ctx, cmd); err != nil {
+		return err
+	}
+
+	key, err := permissionCacheKey(&user.SignedInUser{OrgID: cmd.OrgID, UserID: cmd.UserID})
+	if err != nil {
+		return err
+	}
+	s.cache.Delete(key)
+
+	return nil
+}
+
+func (s *Service) GetServiceAccountPermissions(ctx context.Context, orgID, serviceAccountID int64) ([]accesscontrol.Permission, error) {
+	return s.store.GetServiceAccountPermissions(ctx, orgID, serviceAccountID)
+}
+
+// GrantServiceAccountRole assigns the role to the service account and drops
+// the service account's cached permissions. The role is only assigned if the
+// granter holds every permission of it, otherwise ErrPermissionEscalation is
+// returned.
+func (s *Service) GrantServiceAccountRole(ctx context.Context, granter *user.SignedInUser, cmd *accesscontrol.ServiceAccountRoleCommand) error {
+	rolePermissions, err := s.store.GetAssignableRolePermissions(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	granterPermissions, err := s.GetUserPermissions(ctx, granter, accesscontrol.Options{})
+	if err != nil {
+		return err
+	}
+	held := accesscontrol.GroupScopesByAction(granterPermissions)
+	for _, p := range rolePermissions {
+		evaluator := accesscontrol.EvalPermission(p.Action)
+		if p.Scope != "" {
+			evaluator = accesscontrol.EvalPermission(p.Action, p.Scope)
+		}
+		if !evaluator.Evaluate(held) {
+			return fmt.Errorf("%w: %s on %q", accesscontrol.ErrPermissionEscalation, p.Action, p.Scope)
+		}
+	}
+
+	if err := s.store.AddServiceAccountRole(ctx, cmd); err != nil {
+		return err
+	}
+	return s.clearServiceAccountCache(cmd)
+}
+
+// RevokeServiceAccountRole revokes the role from the service account and drops
+// the service account's cached permissions.
+func (s *Service) RevokeServiceAccountRole(ctx context.Context, cmd *accesscontrol.ServiceAccountRoleCommand) error {
+	if err := s.store.RemoveServiceAccountRole(ctx, cmd); err != nil {
+		return err
+	}
+	return s.clearServiceAccountCache(cmd)
+}
+
+func (s *Service) clearServiceAccountCache(cmd *accesscontrol.ServiceAccountRoleCommand) error {
+	key, err := permissionCacheKey(&user.SignedInUser{OrgID: cmd.OrgID, UserID: cmd.ServiceAccountID})
+	if err != nil {
+		return err
+	}
+	s.cache.Delete(key)
+	return nil
+}
+
+// Run deletes expired temporary permissions until ctx is done.
+func (s *Service) Run(ctx context.Context) error {
+	ticker := time.NewTicker(temporaryPermissionSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.lockAndSweep(ctx)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// lockAndSweep sweeps the expired temporary permissions, unless another
+// instance did within the sweep interval, so that their expiry events are
+// published once.
+func (s *Service) lockAndSweep(ctx context.Context) {
+	sweep := func(ctx context.Context) {
+		if err := s.sweepExpiredTemporaryPermissions(ctx); err != nil {
+			s.log.Error("failed to delete expired temporary permissions", "error", err)
+		}
+	}
+	if s.serverLock == nil {
+		sweep(ctx)
+		return
+	}
+	// the interval is shortened so that ticks slightly early are not skipped
+	if err := s.serverLock.LockAndExecute(ctx, "delete expired temporary permissions", temporaryPermissionSweepInterval/2, sweep); err != nil {
+		s.log.Error("failed to lock and delete expired temporary permissions", "error", err)
+	}
+}
+
+// sweepExpiredTemporaryPermissions deletes the temporary permissions that
+// have expired, drops the cached permissions of their users and publishes a
+// TemporaryPermissionExpired event for each of them.
+func (s *Service) sweepExpiredTemporaryPermissions(ctx context.Context) error {
+	expired, err := s.store.DeleteExpiredTemporaryPermissions(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, p := range expired {
+		key, err := permissionCacheKey(&user.SignedInUser{OrgID: p.OrgID, UserID: p.UserID})
+		if err != nil {
+			return err
+		}
+		s.cache.Delete(key)
+
+		if s.bus == nil {
+			continue
+		}
+		if err := s.bus.Publish(ctx, &events.TemporaryPermissionExpired{
+			Timestamp: time.Now(),
+			ID:        p.ID,
+			OrgID:     p.OrgID,
+			UserID:    p.UserID,
+			Action:    p.Action,
+			Scope:     p.Scope,
+			ExpiresAt: p.ExpiresAt,
+		}); err != nil {
+			s.log.Warn("failed to publish temporary permission expiry", "id", p.ID, "error", err)
+		}
+	}
+
+	if len(expired) > 0 {
+		s.log.Debug("deleted expired temporary permissions", "count", len(expired))
+	}
+	return nil
+}
+
+// ImpersonateUser returns the target user signed in to the admin's org on behalf of the admin.
+// The returned user carries the target's own permissions, never the admin's. Impersonated users
+// cannot impersonate, and only Grafana admins can impersonate other Grafana admins. Changes
+// made with a context returned by WithImpersonator for the user are attributed to the admin.
+func (s *Service) ImpersonateUser(ctx context.Context, admin *user.SignedInUser, targetUserID int64) (*user.SignedInUser, error) {
+	if admin.IsImpersonated || !admin.IsRealUser() || admin.UserID == targetUserID {
+		return nil, accesscontrol.ErrImpersonationDenied
+	}
+
+	adminPermissions, err := s.GetUserPermissions(ctx, admin, accesscontrol.Options{})
+	if err != nil {
+		return nil, err
+	}
+	evaluator := accesscontrol.EvalPermission(accesscontrol.ActionImpersonateUsers, accesscontrol.ImpersonateScope(targetUserID))
+	if !evaluator.Evaluate(accesscontrol.GroupScopesByAction(adminPermissions)) {
+		return nil, accesscontrol.ErrImpersonationDenied
+	}
+
+	target, err := s.store.GetOrgUser(ctx, admin.OrgID, targetUserID)
+	if errors.Is(err, accesscontrol.ErrOrgUserNotFound) {
+		return nil, accesscontrol.ErrImpersonationTarget
+	}
+	if err != nil {
+		return nil, err
+	}
+	if target.IsGrafanaAdmin && !admin.IsGrafanaAdmin {
+		return nil, accesscontrol.ErrImpersonationDenied
+	}
+
+	target.OrgName = admin.OrgName
+	target.IsImpersonated = true
+	target.ImpersonatedBy = admin.UserID
+
+	permissions, err := s.GetUserPermissions(ctx, target, accesscontrol.Options{})
+	if err != nil {
+		return nil, err
+	}
+	target.Permissions = map[int64]map[string][]string{
+		target.OrgID: accesscontrol.GroupScopesByAction(permissions),
+	}
+
+	s.log.Info("User impersonation started", "orgId", admin.OrgID, "adminId", admin.UserID, "targetUserId", targetUserID)
+	return target, nil
+}
+
+// UserInOrg returns a copy of the user switched to the org, with the role and
+// teams of the user in the org. A user that is not a member of the org has
+// neither, only its own and its server admin permissions apply there.
+func (s *Service) UserInOrg(ctx context.Context, u *user.SignedInUser, orgID int64) (*user.SignedInUser, error) {
+	scoped := accesscontrol.UserInOrg(u, orgID)
+	member, err := s.store.GetOrgUser(ctx, orgID, u.UserID)
+	if errors.Is(err, accesscontrol.ErrOrgUserNotFound) {
+		return scoped, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	scoped.OrgRole = member.OrgRole
+	scoped.Teams = member.Teams
+	return scoped, nil
+}
+
+// SnapshotPermissions resolves the permissions of every user in the org, bypassing the permission cache.
+func (s *Service) SnapshotPermissions(ctx context.Context, orgID int64) (*accesscontrol.PermissionSnapshot, error) {
+	users, err := s.store.GetOrgUsers(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &accesscontrol.PermissionSnapshot{
+		OrgID:       orgID,
+		Timestamp:   time.Now(),
+		Permissions: make(map[int64][]accesscontrol.Permission, len(users)),
+	}
+
+	for _, u := range users {
+		permissions, err := s.getUserPermissions(ctx, u, accesscontrol.Options{})
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Permissions[u.UserID] = normalizePermissions(permissions)
+	}
+
+	return snapshot, nil
+}
+
+func (s *Service) StoreSnapshot(ctx context.Context, snap *accesscontrol.PermissionSnapshot) error {
+	return s.store.StoreSnapshot(ctx, snap)
+}
+
+func (s *Service) GetSnapshot(ctx context.Context, orgID, snapshotID int64) (*accesscontrol.PermissionSnapshot, error) {
+	return s.store.GetSnapshot(ctx, orgID, snapshotID)
+}
+
+func (s *Service) ListSnapshots(ctx context.Context, orgID int64) ([]*accesscontrol.SnapshotMeta, error) {
+	return s.store.ListSnapshots(ctx, orgID)
+}
+
+func (s *Service) GetSimplifiedUsersPermissionsPaged(ctx context.Context, requester *user.SignedInUser, orgID int64,
+	actionPrefix, cursor string, limit int) (*accesscontrol.PagedPermissions, error) {
+	if limit <= 0 {
+		limit = accesscontrol.DefaultPermissionsPageLimit
+	}
+	if limit > accesscontrol.MaxPermissionsPageLimit {
+		limit = accesscontrol.MaxPermissionsPageLimit
+	}
+
+	now := time.Now()
+	var from accesscontrol.PermissionsCursor
+	if cursor != "" {
+		var err error
+		if from, err = accesscontrol.DecodePermissionsCursor(cursor, now); err != nil {
+			return nil, err
+		}
+	}
+
+	requesterPermissions, err := s.GetUserPermissions(ctx, requester, accesscontrol.Options{})
+	if err != nil {
+		return nil, err
+	}
+	readable := accesscontrol.GroupScopesByAction(requesterPermissions)
+
+	orgUsers, err := s.store.GetOrgUsers(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*user.SignedInUser, 0, len(orgUsers))
+	for _, u := range orgUsers {
+		scope := accesscontrol.Scope("users", "id", strconv.FormatInt(u.UserID, 10))
+		if accesscontrol.EvalPermission(accesscontrol.ActionOrgUsersRead, scope).Evaluate(readable) {
+			users = append(users, u)
+		}
+	}
+
+	// Users are ordered by ID, so the page boundaries are found by search.
+	start, end := 0, len(users)
+	if cursor != "" {
+		if from.Backward {
+			end = sort.Search(len(users), func(i int) bool { return users[i].UserID >= from.UserID })
+			start = end - limit
+			if start < 0 {
+				start = 0
+			}
+		} else {
+			start = sort.Search(len(users), func(i int) bool { return users[i].UserID > from.UserID })
+		}
+	}
+	if end-start > limit {
+		end = start + limit
+	}
+
+	page := &accesscontrol.PagedPermissions{Data: make(map[int64][]string, end-start)}
+	for _, u := range users[start:end] {
+		permissions, err := s.getUserPermissions(ctx, u, accesscontrol.Options{
+			Filter: accesscontrol.PermissionFilter{ActionPrefix: actionPrefix},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if actions := simplifyPermissions(permissions); len(actions) > 0 {
+			page.Data[u.UserID] = actions
+		}
+	}
+
+	if start < end && end < len(users) {
+		page.NextCursor = accesscontrol.PermissionsCursor{UserID: users[end-1].UserID, Timestamp: now}.Encode()
+	}
+	if start < end && start > 0 {
+		page.PrevCursor = accesscontrol.PermissionsCursor{UserID: users[start].UserID, Timestamp: now, Backward: true}.Encode()
+	}
+	return page, nil
+}
+
+// simplifyPermissions returns the sorted, distinct actions of the permissions.
+func simplifyPermissions(permissions []accesscontrol.Permission) []string {
+	seen := make(map[string]struct{}, len(permissions))
+	actions := make([]string, 0, len(permissions))
+	for _, p := range permissions {
+		if _, ok := seen[p.Action]; ok {
+			continue
+		}
+		seen[p.Action] = struct{}{}
+		actions = append(actions, p.Action)
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+// normalizePermissions strips timestamps, removes duplicates and sorts permissions
+// so that snapshots of identical permissions are identical.
+func normalizePermissions(permissions []accesscontrol.Permission) []accesscontrol.Permission {
+	seen := make(map[[2]string]struct{}, len(permissions))
+	result := make([]accesscontrol.Permission, 0, len(permissions))
+	for _, p := range permissions {
+		p = p.OSSPermission()
+		key := [2]string{p.Action, p.Scope}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		result = append(result, p)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Action == result[j].Action {
+			return result[i].Scope < result[j].Scope
+		}
+		return result[i].Action < result[j].Action
+	})
+	return result
+}
+
 // DeclareFixedRoles allow the caller to declare, to the service, fixed roles and their assignments
 // to organization roles ("Viewer", "Editor", "Admin") or "Grafana Admin"
+// RegisterPermissionTemplate validates tmpl and registers it under its name.
+func (s *Service) RegisterPermissionTemplate(tmpl accesscontrol.PermissionTemplate) error {
+	if err := tmpl.Validate(); err != nil {
+		return err
+	}
+
+	s.templatesMu.Lock()
+	defer s.templatesMu.Unlock()
+	if _, ok := s.templates[tmpl.Name]; ok {
+		return fmt.Errorf("%w: %s", accesscontrol.ErrTemplateExists, tmpl.Name)
+	}
+	tmpl.Actions = append([]string(nil), tmpl.Actions...)
+	s.templates[tmpl.Name] = tmpl
+	return nil
+}
+
+func (s *Service) GetPermissionTemplates() []accesscontrol.PermissionTemplate {
+	s.templatesMu.RLock()
+	defer s.templatesMu.RUnlock()
+
+	templates := make([]accesscontrol.PermissionTemplate, 0, len(s.templates))
+	for _, tmpl := range s.templates {
+		templates = append(templates, tmpl)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+func (s *Service) ApplyPermissionTemplate(ctx context.Context, cmd *accesscontrol.ApplyTemplateCommand) ([]accesscontrol.Permission, error) {
+	s.templatesMu.RLock()
+	tmpl, ok := s.templates[cmd.Template]
+	s.templatesMu.RUnlock()
+	if !ok {
+		return nil, accesscontrol.ErrTemplateNotFound
+	}
+
+	permissions, err := accesscontrol.RenderTemplate(tmpl, cmd.Vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.AddRolePermissions(ctx, cmd.OrgID, cmd.RoleUID, permissions, cmd.UpdatedBy); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+func (s *Service) GetRoleVersionHistory(ctx context.Context, orgID int64, roleUID string) ([]*accesscontrol.RoleVersion, error) {
+	return s.store.GetRoleVersionHistory(ctx, orgID, roleUID)
+}
+
+func (s *Service) ListPermissionsForResource(ctx context.Context, orgID int64, resourceType, resourceUID string) ([]accesscontrol.ResourcePermission, error) {
+	prefix := accesscontrol.Scope(resourceType, "uid", "")
+	scopes := append(accesscontrol.WildcardsFromPrefix(prefix), prefix+resourceUID)
+	return s.store.ListResourcePermissions(ctx, orgID, scopes)
+}
+
 func (s *Service) DeclareFixedRoles(registrations ...accesscontrol.RoleRegistration) error {
 	// If accesscontrol is disabled no need to register roles
 	if accesscontrol.IsDisabled(s.cfg) {
@@ -176,17 +844,31 @@ func (s *Service) RegisterFixedRoles(ctx context.Context) error {
 	}
 	s.registrations.Range(func(registration accesscontrol.RoleRegistration) bool {
 		for br := range accesscontrol.BuiltInRolesWithParents(registration.Grants) {
-			if basicRole, ok := s.roles[br]; ok {
-				basicRole.Permissions = append(basicRole.Permissions, registration.Role.Permissions...)
-			} else {
+			basicRole, ok := s.roles[br]
+			if !ok {
 				s.log.Error("Unknown builtin role", "builtInRole", br)
+				continue
+			}
+			if registration.FeatureFlag != "" {
+				for _, p := range registration.Role.Permissions {
+					s.flaggedPermissions[br] = append(s.flaggedPermissions[br], flaggedPermission{featureFlag: registration.FeatureFlag, permission: p})
+				}
+				continue
 			}
+			basicRole.Permissions = append(basicRole.Permissions, registration.Role.Permissions...)
 		}
 		return true
 	})
 	return nil
 }
 
+// flaggedPermission is a permission only granted while its feature toggle is
+// enabled.
+type flaggedPermission struct {
+	featureFlag string
+	permission  accesscontrol.Permission
+}
+
 func (s *Service) IsDisabled() bool {
 	return accesscontrol.IsDisabled(s.cfg)
 }
@@ -198,3 +880,7 @@ func permissionCacheKey(user *user.SignedInUser) (string, error) {
 	}
 	return fmt.Sprintf("rbac-permissions-%s", key), nil
 }
+
+func denyRulesCacheKey(orgID, userID int64) string {
+	return fmt.Sprintf("rbac-denied-%d-%d", orgID, userID)
+}
@@ -2,18 +2,33 @@ package acimpl
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/grafana/grafana/pkg/api/routing"
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/infra/db"
 	"github.com/grafana/grafana/pkg/infra/localcache"
 	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	"github.com/grafana/grafana/pkg/infra/tracing"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/accesscontrol/database"
+	rs "github.com/grafana/grafana/pkg/services/accesscontrol/resourcepermissions"
+	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/services/team/teamimpl"
+	"github.com/grafana/grafana/pkg/services/user"
 	"github.com/grafana/grafana/pkg/setting"
 )
 
@@ -27,7 +42,12 @@ func setupTestEnv(t testing.TB) *Service {
 		log:           log.New("accesscontrol"),
 		registrations: accesscontrol.RegistrationList{},
 		store:         database.ProvideService(db.InitTestDB(t)),
+		features:      featuremgmt.WithFeatures(),
+		tracer:        tracing.InitializeTracerForTest(),
 		roles:         accesscontrol.BuildBasicRoleDefinitions(),
+
+		flaggedPermissions: map[string][]flaggedPermission{},
+		templates:          map[string]accesscontrol.PermissionTemplate{},
 	}
 	require.NoError(t, ac.RegisterFixedRoles(context.Background()))
 	return ac
@@ -61,6 +81,10 @@ func TestUsageMetrics(t *testing.T) {
 				db.InitTestDB(t),
 				routing.NewRouteRegister(),
 				localcache.ProvideService(),
+				featuremgmt.WithFeatures(),
+				tracing.InitializeTracerForTest(),
+				nil,
+				nil,
 			)
 			require.NoError(t, errInitAc)
 			assert.Equal(t, tt.expectedValue, s.GetUsageStats(context.Background())["stats.oss.accesscontrol.enabled.count"])
@@ -239,3 +263,822 @@ func TestService_RegisterFixedRoles(t *testing.T) {
 		})
 	}
 }
+
+func TestService_GetUserPermissions(t *testing.T) {
+	ac := setupTestEnv(t)
+	ac.registrations.Append(accesscontrol.RoleRegistration{
+		Role: accesscontrol.RoleDTO{
+			Name: "fixed:test:test",
+			Permissions: []accesscontrol.Permission{
+				{Action: "dashboards:read", Scope: "dashboards:*"},
+				{Action: "dashboards:write", Scope: "dashboards:*"},
+				{Action: "folders:read", Scope: "folders:*"},
+			},
+		},
+		Grants: []string{"Viewer"},
+	})
+	require.NoError(t, ac.RegisterFixedRoles(context.Background()))
+
+	usr := &user.SignedInUser{OrgID: 1, UserID: 1, OrgRole: org.RoleViewer}
+
+	tests := []struct {
+		desc     string
+		filter   accesscontrol.PermissionFilter
+		expected []accesscontrol.Permission
+	}{
+		{
+			desc: "should return all permissions without filter",
+			expected: []accesscontrol.Permission{
+				{Action: "dashboards:read", Scope: "dashboards:*"},
+				{Action: "dashboards:write", Scope: "dashboards:*"},
+				{Action: "folders:read", Scope: "folders:*"},
+			},
+		},
+		{
+			desc:   "should filter on action prefix",
+			filter: accesscontrol.PermissionFilter{ActionPrefix: "dashboards:"},
+			expected: []accesscontrol.Permission{
+				{Action: "dashboards:read", Scope: "dashboards:*"},
+				{Action: "dashboards:write", Scope: "dashboards:*"},
+			},
+		},
+		{
+			desc:   "should filter on scope",
+			filter: accesscontrol.PermissionFilter{Scope: "folders:*"},
+			expected: []accesscontrol.Permission{
+				{Action: "folders:read", Scope: "folders:*"},
+			},
+		},
+		{
+			desc:   "should filter on action prefix and scope",
+			filter: accesscontrol.PermissionFilter{ActionPrefix: "folders:", Scope: "dashboards:*"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			permissions, err := ac.GetUserPermissions(context.Background(), usr, accesscontrol.Options{Filter: tt.filter})
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tt.expected, permissions)
+		})
+	}
+}
+
+func TestService_GetUserPermissions_FeatureFlag(t *testing.T) {
+	ac := setupTestEnv(t)
+	ac.registrations.Append(
+		accesscontrol.RoleRegistration{
+			Role: accesscontrol.RoleDTO{
+				Name:        "fixed:test:flagged",
+				Permissions: []accesscontrol.Permission{{Action: "test:flagged", Scope: "test:*"}},
+			},
+			Grants:      []string{"Viewer"},
+			FeatureFlag: "testFlag",
+		},
+		accesscontrol.RoleRegistration{
+			Role: accesscontrol.RoleDTO{
+				Name:        "fixed:test:unflagged",
+				Permissions: []accesscontrol.Permission{{Action: "test:unflagged", Scope: "test:*"}},
+			},
+			Grants: []string{"Viewer"},
+		},
+	)
+	require.NoError(t, ac.RegisterFixedRoles(context.Background()))
+
+	usr := &user.SignedInUser{OrgID: 1, UserID: 1, OrgRole: org.RoleEditor}
+	actions := func() map[string]bool {
+		permissions, err := ac.GetUserPermissions(context.Background(), usr, accesscontrol.Options{})
+		require.NoError(t, err)
+		set := map[string]bool{}
+		for _, p := range permissions {
+			set[p.Action] = true
+		}
+		return set
+	}
+
+	// the flag is disabled in setupTestEnv
+	assert.False(t, actions()["test:flagged"])
+	assert.True(t, actions()["test:unflagged"])
+
+	ac.features = featuremgmt.WithFeatures("testFlag")
+	assert.True(t, actions()["test:flagged"], "expected the permission once the flag is enabled")
+	assert.True(t, actions()["test:unflagged"])
+
+	ac.features = featuremgmt.WithFeatures("testFlag", false)
+	assert.False(t, actions()["test:flagged"], "expected the permission to be removed once the flag is disabled")
+}
+
+func TestService_SnapshotPermissions(t *testing.T) {
+	ctx := context.Background()
+	sql := db.InitTestDB(t)
+	ac := setupTestEnv(t)
+	ac.store = database.ProvideService(sql)
+
+	usr, err := sql.CreateUser(ctx, user.CreateUserCommand{Login: "user", OrgID: 1, DefaultOrgRole: "Viewer"})
+	require.NoError(t, err)
+
+	before, err := ac.SnapshotPermissions(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), before.OrgID)
+	require.Contains(t, before.Permissions, usr.ID)
+	require.NoError(t, ac.StoreSnapshot(ctx, before))
+
+	_, err = rs.NewStore(sql).SetUserResourcePermission(ctx, 1, accesscontrol.User{ID: usr.ID}, rs.SetResourcePermissionCommand{
+		Actions:           []string{"dashboards:write"},
+		Resource:          "dashboards",
+		ResourceAttribute: "uid",
+		ResourceID:        "1",
+	}, nil)
+	require.NoError(t, err)
+
+	after, err := ac.SnapshotPermissions(ctx, 1)
+	require.NoError(t, err)
+	require.NoError(t, ac.StoreSnapshot(ctx, after))
+
+	metas, err := ac.ListSnapshots(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, metas, 2)
+
+	from, err := ac.GetSnapshot(ctx, 1, before.ID)
+	require.NoError(t, err)
+	to, err := ac.GetSnapshot(ctx, 1, after.ID)
+	require.NoError(t, err)
+
+	expected := []accesscontrol.Permission{{Action: "dashboards:write", Scope: "dashboards:uid:1"}}
+
+	diff := accesscontrol.DiffPermissionSnapshots(from, to)
+	assert.Equal(t, map[int64][]accesscontrol.Permission{usr.ID: expected}, diff.Added)
+	assert.Empty(t, diff.Removed)
+
+	diff = accesscontrol.DiffPermissionSnapshots(to, from)
+	assert.Empty(t, diff.Added)
+	assert.Equal(t, map[int64][]accesscontrol.Permission{usr.ID: expected}, diff.Removed)
+}
+
+func TestService_RevokeAllUserRoles(t *testing.T) {
+	ctx := context.Background()
+	sql := db.InitTestDB(t)
+	ac := setupTestEnv(t)
+	ac.store = database.ProvideService(sql)
+	ac.cache = localcache.ProvideService()
+	ac.cfg.RBACPermissionCache = true
+
+	usr, err := sql.CreateUser(ctx, user.CreateUserCommand{Login: "user", OrgID: 1})
+	require.NoError(t, err)
+
+	_, err = rs.NewStore(sql).SetUserResourcePermission(ctx, 1, accesscontrol.User{ID: usr.ID}, rs.SetResourcePermissionCommand{
+		Actions:           []string{"dashboards:write"},
+		Resource:          "dashboards",
+		ResourceAttribute: "uid",
+		ResourceID:        "1",
+	}, nil)
+	require.NoError(t, err)
+
+	signedInUser := &user.SignedInUser{OrgID: 1, UserID: usr.ID}
+	permissions, err := ac.GetUserPermissions(ctx, signedInUser, accesscontrol.Options{})
+	require.NoError(t, err)
+	require.Len(t, permissions, 1)
+
+	revoked, err := ac.RevokeAllUserRoles(ctx, 1, usr.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+
+	// cached permissions must be invalidated
+	permissions, err = ac.GetUserPermissions(ctx, signedInUser, accesscontrol.Options{})
+	require.NoError(t, err)
+	assert.Empty(t, permissions)
+
+	records, err := database.ProvideService(sql).GetRoleAssignmentAudit(ctx, 1, usr.ID)
+	require.NoError(t, err)
+	assert.Len(t, records, revoked)
+}
+
+func TestService_GrantServiceAccountRole(t *testing.T) {
+	ctx := context.Background()
+	sql := db.InitTestDB(t)
+	ac := setupTestEnv(t)
+	ac.store = database.ProvideService(sql)
+	ac.cache = localcache.ProvideService()
+
+	admin, err := sql.CreateUser(ctx, user.CreateUserCommand{Login: "admin"})
+	require.NoError(t, err)
+	// the service account is created in the org of the admin
+	autoAssignOrg := sql.Cfg.AutoAssignOrg
+	sql.Cfg.AutoAssignOrg = true
+	t.Cleanup(func() { sql.Cfg.AutoAssignOrg = autoAssignOrg })
+	sa, err := sql.CreateUser(ctx, user.CreateUserCommand{Login: "sa-1-admin", OrgID: admin.OrgID, IsServiceAccount: true})
+	require.NoError(t, err)
+	orgID := admin.OrgID
+
+	store := rs.NewStore(sql)
+	_, err = store.SetUserResourcePermission(ctx, orgID, accesscontrol.User{ID: admin.ID}, rs.SetResourcePermissionCommand{
+		Actions:           []string{"dashboards:read"},
+		Resource:          "dashboards",
+		ResourceAttribute: "uid",
+		ResourceID:        "1",
+	}, nil)
+	require.NoError(t, err)
+
+	addRole := func(uid, name string, permissions ...accesscontrol.Permission) {
+		err := sql.WithDbSession(ctx, func(sess *db.Session) error {
+			role := &accesscontrol.Role{OrgID: orgID, UID: uid, Name: name, Created: time.Now(), Updated: time.Now()}
+			if _, err := sess.Insert(role); err != nil {
+				return err
+			}
+			for _, p := range permissions {
+				p.RoleID, p.Created, p.Updated = role.ID, time.Now(), time.Now()
+				if _, err := sess.Insert(&p); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	addRole("reader", "custom:reader", accesscontrol.Permission{Action: "dashboards:read", Scope: "dashboards:uid:1"})
+	addRole("all-reader", "custom:all-reader", accesscontrol.Permission{Action: "dashboards:read", Scope: "dashboards:*"})
+	addRole("fixed-reader", "fixed:dashboards:reader", accesscontrol.Permission{Action: "dashboards:read", Scope: "dashboards:uid:1"})
+
+	granter := &user.SignedInUser{OrgID: orgID, UserID: admin.ID, OrgRole: org.RoleAdmin}
+
+	t.Run("should grant a role whose permissions the granter holds", func(t *testing.T) {
+		require.NoError(t, ac.GrantServiceAccountRole(ctx, granter, &accesscontrol.ServiceAccountRoleCommand{OrgID: orgID, ServiceAccountID: sa.ID, RoleUID: "reader"}))
+	})
+
+	t.Run("should not grant permissions the granter does not hold", func(t *testing.T) {
+		err := ac.GrantServiceAccountRole(ctx, granter, &accesscontrol.ServiceAccountRoleCommand{OrgID: orgID, ServiceAccountID: sa.ID, RoleUID: "all-reader"})
+		assert.ErrorIs(t, err, accesscontrol.ErrPermissionEscalation)
+
+		permissions, err := ac.GetServiceAccountPermissions(ctx, orgID, sa.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []accesscontrol.Permission{{Action: "dashboards:read", Scope: "dashboards:uid:1"}}, permissions)
+	})
+
+	t.Run("should not grant fixed roles", func(t *testing.T) {
+		err := ac.GrantServiceAccountRole(ctx, granter, &accesscontrol.ServiceAccountRoleCommand{OrgID: orgID, ServiceAccountID: sa.ID, RoleUID: "fixed-reader"})
+		assert.ErrorIs(t, err, accesscontrol.ErrRoleNotAssignable)
+	})
+}
+
+func TestService_DenyRules(t *testing.T) {
+	ctx := context.Background()
+	ac := setupTestEnv(t)
+	ac.cache = localcache.ProvideService()
+
+	rules, err := ac.ListDeniedPermissions(ctx, 1, 2)
+	require.NoError(t, err)
+	require.Empty(t, rules)
+
+	err = ac.DenyPermission(ctx, &accesscontrol.DenyCommand{OrgID: 1, UserID: 2, Action: "dashboards:read", Scope: "dashboards:*:1"})
+	assert.ErrorIs(t, err, accesscontrol.ErrInvalidScope)
+
+	require.NoError(t, ac.DenyPermission(ctx, &accesscontrol.DenyCommand{OrgID: 1, UserID: 2, Action: "dashboards:read", Scope: "dashboards:uid:1"}))
+
+	// cached deny rules must be invalidated
+	rules, err = ac.ListDeniedPermissions(ctx, 1, 2)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+
+	require.NoError(t, ac.RevokeDenyRule(ctx, 1, rules[0].ID))
+	rules, err = ac.ListDeniedPermissions(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+
+	assert.ErrorIs(t, ac.RevokeDenyRule(ctx, 1, 42), accesscontrol.ErrDenyRuleNotFound)
+}
+
+func TestService_TemporaryPermissions(t *testing.T) {
+	ctx := context.Background()
+	sql := db.InitTestDB(t)
+	ac := setupTestEnv(t)
+	ac.store = database.ProvideService(sql)
+	ac.cache = localcache.ProvideService()
+	ac.cfg.RBACPermissionCache = true
+
+	tracer := tracing.InitializeTracerForTest()
+	inProcBus := bus.ProvideBus(tracer)
+	var expiredEvents []*events.TemporaryPermissionExpired
+	inProcBus.AddEventListener(func(_ context.Context, e *events.TemporaryPermissionExpired) error {
+		expiredEvents = append(expiredEvents, e)
+		return nil
+	})
+	ac.bus = inProcBus
+
+	signedInUser := &user.SignedInUser{OrgID: 1, UserID: 2}
+	permissions, err := ac.GetUserPermissions(ctx, signedInUser, accesscontrol.Options{})
+	require.NoError(t, err)
+	require.Empty(t, permissions)
+
+	permission := accesscontrol.Permission{Action: "dashboards:write", Scope: "dashboards:uid:1"}
+	err = ac.GrantTemporaryPermission(ctx, &accesscontrol.TemporaryPermissionCommand{OrgID: 1, UserID: 2, Permission: permission, ExpiresAt: time.Now().Add(-time.Minute)})
+	assert.ErrorIs(t, err, accesscontrol.ErrInvalidExpiry)
+	err = ac.GrantTemporaryPermission(ctx, &accesscontrol.TemporaryPermissionCommand{OrgID: 1, UserID: 2, Permission: accesscontrol.Permission{Action: "dashboards:write", Scope: "dashboards:*:1"}, ExpiresAt: time.Now().Add(time.Hour)})
+	assert.ErrorIs(t, err, accesscontrol.ErrInvalidScope)
+
+	require.NoError(t, ac.GrantTemporaryPermission(ctx, &accesscontrol.TemporaryPermissionCommand{OrgID: 1, UserID: 2, Permission: permission, ExpiresAt: time.Now().Add(time.Hour)}))
+
+	// cached permissions must be invalidated
+	permissions, err = ac.GetUserPermissions(ctx, signedInUser, accesscontrol.Options{})
+	require.NoError(t, err)
+	require.Len(t, permissions, 1)
+	assert.Equal(t, permission.Scope, permissions[0].Scope)
+
+	// the sweep only deletes expired permissions
+	require.NoError(t, ac.sweepExpiredTemporaryPermissions(ctx))
+	assert.Empty(t, expiredEvents)
+
+	expired := accesscontrol.Permission{Action: "dashboards:write", Scope: "dashboards:uid:2"}
+	require.NoError(t, ac.store.AddTemporaryPermission(ctx, &accesscontrol.TemporaryPermissionCommand{OrgID: 1, UserID: 2, Permission: expired, ExpiresAt: time.Now().Add(-time.Second)}))
+
+	// expired permissions are not granted before they are swept
+	permissions, err = ac.GetUserPermissions(ctx, signedInUser, accesscontrol.Options{ReloadCache: true})
+	require.NoError(t, err)
+	require.Len(t, permissions, 1)
+	assert.Equal(t, permission.Scope, permissions[0].Scope)
+
+	require.NoError(t, ac.sweepExpiredTemporaryPermissions(ctx))
+	require.Len(t, expiredEvents, 1)
+	assert.Equal(t, int64(2), expiredEvents[0].UserID)
+	assert.Equal(t, expired.Scope, expiredEvents[0].Scope)
+
+	deleted, err := ac.store.DeleteExpiredTemporaryPermissions(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, deleted, "the sweep should have deleted the expired permission")
+
+	t.Run("a single instance sweeps per interval", func(t *testing.T) {
+		ac.serverLock = serverlock.ProvideService(sql, tracer)
+		for i, scope := range []string{"dashboards:uid:3", "dashboards:uid:4"} {
+			cmd := &accesscontrol.TemporaryPermissionCommand{OrgID: 1, UserID: 2, Permission: accesscontrol.Permission{Action: "dashboards:write", Scope: scope}, ExpiresAt: time.Now().Add(-time.Second)}
+			require.NoError(t, ac.store.AddTemporaryPermission(ctx, cmd), i)
+			ac.lockAndSweep(ctx)
+		}
+		require.Len(t, expiredEvents, 2)
+		assert.Equal(t, "dashboards:uid:3", expiredEvents[1].Scope)
+	})
+}
+
+func TestService_UserInOrg(t *testing.T) {
+	ctx := context.Background()
+	sql := db.InitTestDB(t)
+	ac := setupTestEnv(t)
+	ac.store = database.ProvideService(sql)
+	ac.cache = localcache.ProvideService()
+	ac.roles[string(org.RoleEditor)].Permissions = append(ac.roles[string(org.RoleEditor)].Permissions,
+		accesscontrol.Permission{Action: accesscontrol.ActionTeamsCreate})
+
+	usr, err := sql.CreateUser(ctx, user.CreateUserCommand{Login: "user", OrgID: 1, DefaultOrgRole: string(org.RoleViewer)})
+	require.NoError(t, err)
+	owner, err := sql.CreateUser(ctx, user.CreateUserCommand{Login: "owner", OrgName: "other", DefaultOrgRole: string(org.RoleAdmin)})
+	require.NoError(t, err)
+	otherOrgID := owner.OrgID
+	require.NoError(t, sql.AddOrgUser(ctx, &models.AddOrgUserCommand{OrgId: otherOrgID, UserId: usr.ID, Role: org.RoleEditor}))
+
+	teamSvc := teamimpl.ProvideService(sql, sql.Cfg)
+	team, err := teamSvc.CreateTeam("team", "", otherOrgID)
+	require.NoError(t, err)
+	require.NoError(t, teamSvc.AddTeamMember(usr.ID, otherOrgID, team.Id, false, models.PERMISSION_VIEW))
+	_, err = rs.NewStore(sql).SetTeamResourcePermission(ctx, otherOrgID, team.Id, rs.SetResourcePermissionCommand{
+		Actions:           []string{"dashboards:write"},
+		Resource:          "dashboards",
+		ResourceAttribute: "uid",
+		ResourceID:        "1",
+	}, nil)
+	require.NoError(t, err)
+
+	signedInUser := &user.SignedInUser{
+		OrgID:       1,
+		UserID:      usr.ID,
+		OrgRole:     org.RoleViewer,
+		Teams:       []int64{42},
+		Permissions: map[int64]map[string][]string{1: {}},
+	}
+
+	t.Run("loads the role and teams of the user in the org", func(t *testing.T) {
+		scoped, err := ac.UserInOrg(ctx, signedInUser, otherOrgID)
+		require.NoError(t, err)
+		assert.Equal(t, otherOrgID, scoped.OrgID)
+		assert.Equal(t, org.RoleEditor, scoped.OrgRole)
+		assert.Equal(t, []int64{team.Id}, scoped.Teams)
+		assert.Equal(t, org.RoleViewer, signedInUser.OrgRole)
+		assert.Equal(t, []int64{42}, signedInUser.Teams)
+	})
+
+	t.Run("users have no role or teams in orgs they are not a member of", func(t *testing.T) {
+		scoped, err := ac.UserInOrg(ctx, signedInUser, otherOrgID+1)
+		require.NoError(t, err)
+		assert.Empty(t, scoped.OrgRole)
+		assert.Empty(t, scoped.Teams)
+	})
+
+	t.Run("basic role and team grants in the org are evaluated", func(t *testing.T) {
+		evaluator := ProvideAccessControl(ac.cfg, ac)
+		for _, permission := range []accesscontrol.Evaluator{
+			accesscontrol.EvalPermission(accesscontrol.ActionTeamsCreate),
+			accesscontrol.EvalPermission("dashboards:write", "dashboards:uid:1"),
+		} {
+			allowed, err := evaluator.Evaluate(ctx, signedInUser, permission)
+			require.NoError(t, err)
+			assert.False(t, allowed)
+
+			allowed, err = evaluator.Evaluate(ctx, signedInUser, permission, accesscontrol.WithOrgScope(otherOrgID))
+			require.NoError(t, err)
+			assert.True(t, allowed, permission.String())
+		}
+	})
+}
+
+func TestService_ImpersonateUser(t *testing.T) {
+	ctx := context.Background()
+	sql := db.InitTestDB(t)
+	ac := setupTestEnv(t)
+	ac.store = database.ProvideService(sql)
+	require.NoError(t, accesscontrol.DeclareFixedRoles(ac))
+	require.NoError(t, ac.RegisterFixedRoles(ctx))
+	// org admins are not granted impersonation by default
+	ac.roles[string(org.RoleAdmin)].Permissions = append(ac.roles[string(org.RoleAdmin)].Permissions,
+		accesscontrol.Permission{Action: accesscontrol.ActionImpersonateUsers, Scope: accesscontrol.ScopeGlobalUsersAll})
+
+	// add all users to the main org
+	sql.Cfg.AutoAssignOrg, sql.Cfg.AutoAssignOrgId = true, 1
+	t.Cleanup(func() { sql.Cfg.AutoAssignOrg = false })
+	createUser := func(login string, role org.RoleType, isAdmin bool) *user.SignedInUser {
+		t.Helper()
+		u, err := sql.CreateUser(ctx, user.CreateUserCommand{Login: login, DefaultOrgRole: string(role), IsAdmin: isAdmin})
+		require.NoError(t, err)
+		return &user.SignedInUser{OrgID: 1, UserID: u.ID, OrgRole: role, IsGrafanaAdmin: isAdmin}
+	}
+
+	serverAdmin := createUser("server-admin", org.RoleViewer, true)
+	otherServerAdmin := createUser("other-server-admin", org.RoleViewer, true)
+	orgAdmin := createUser("org-admin", org.RoleAdmin, false)
+	viewer := createUser("viewer", org.RoleViewer, false)
+
+	_, err := rs.NewStore(sql).SetUserResourcePermission(ctx, 1, accesscontrol.User{ID: viewer.UserID}, rs.SetResourcePermissionCommand{
+		Actions:           []string{"dashboards:write"},
+		Resource:          "dashboards",
+		ResourceAttribute: "uid",
+		ResourceID:        "1",
+	}, nil)
+	require.NoError(t, err)
+
+	t.Run("should sign in as the target with the target's permissions only", func(t *testing.T) {
+		impersonated, err := ac.ImpersonateUser(ctx, serverAdmin, viewer.UserID)
+		require.NoError(t, err)
+		assert.Equal(t, viewer.UserID, impersonated.UserID)
+		assert.Equal(t, org.RoleViewer, impersonated.OrgRole)
+		assert.False(t, impersonated.IsGrafanaAdmin)
+		assert.True(t, impersonated.IsImpersonated)
+		assert.Equal(t, serverAdmin.UserID, impersonated.ImpersonatedBy)
+
+		expected, err := ac.GetUserPermissions(ctx, viewer, accesscontrol.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, accesscontrol.GroupScopesByAction(expected), impersonated.Permissions[1])
+
+		permissions, err := ac.GetUserPermissions(ctx, impersonated, accesscontrol.Options{})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, expected, permissions)
+
+		// the admin's permissions must not leak into the impersonated session
+		grouped := accesscontrol.GroupScopesByAction(permissions)
+		assert.True(t, accesscontrol.EvalPermission("dashboards:write", "dashboards:uid:1").Evaluate(grouped))
+		assert.False(t, accesscontrol.EvalPermission(accesscontrol.ActionUsersWrite, accesscontrol.ScopeGlobalUsersAll).Evaluate(grouped))
+		assert.False(t, accesscontrol.EvalPermission(accesscontrol.ActionImpersonateUsers, accesscontrol.ImpersonateScope(orgAdmin.UserID)).Evaluate(grouped))
+	})
+
+	t.Run("should not allow impersonated users to impersonate", func(t *testing.T) {
+		impersonated, err := ac.ImpersonateUser(ctx, serverAdmin, otherServerAdmin.UserID)
+		require.NoError(t, err)
+		assert.True(t, impersonated.IsGrafanaAdmin)
+
+		// the target is allowed to impersonate, but not while being impersonated
+		_, err = ac.ImpersonateUser(ctx, impersonated, viewer.UserID)
+		assert.ErrorIs(t, err, accesscontrol.ErrImpersonationDenied)
+	})
+
+	t.Run("should deny users without the permission", func(t *testing.T) {
+		_, err := ac.ImpersonateUser(ctx, viewer, orgAdmin.UserID)
+		assert.ErrorIs(t, err, accesscontrol.ErrImpersonationDenied)
+	})
+
+	t.Run("should deny impersonating oneself", func(t *testing.T) {
+		_, err := ac.ImpersonateUser(ctx, serverAdmin, serverAdmin.UserID)
+		assert.ErrorIs(t, err, accesscontrol.ErrImpersonationDenied)
+	})
+
+	t.Run("should deny impersonating a Grafana admin unless Grafana admin", func(t *testing.T) {
+		_, err := ac.ImpersonateUser(ctx, orgAdmin, viewer.UserID)
+		require.NoError(t, err)
+
+		_, err = ac.ImpersonateUser(ctx, orgAdmin, serverAdmin.UserID)
+		assert.ErrorIs(t, err, accesscontrol.ErrImpersonationDenied)
+	})
+
+	t.Run("should fail if the target is not in the org", func(t *testing.T) {
+		_, err := ac.ImpersonateUser(ctx, serverAdmin, 999)
+		assert.ErrorIs(t, err, accesscontrol.ErrImpersonationTarget)
+	})
+
+	t.Run("should attribute audit entries to the admin", func(t *testing.T) {
+		impersonated, err := ac.ImpersonateUser(ctx, serverAdmin, orgAdmin.UserID)
+		require.NoError(t, err)
+		ac.cache = localcache.ProvideService()
+
+		revoked, err := ac.RevokeAllUserRoles(accesscontrol.WithImpersonator(ctx, impersonated), 1, viewer.UserID, impersonated.UserID)
+		require.NoError(t, err)
+		require.Equal(t, 1, revoked)
+
+		records, err := database.ProvideService(sql).GetRoleAssignmentAudit(ctx, 1, viewer.UserID)
+		require.NoError(t, err)
+		require.Len(t, records, revoked)
+		assert.Equal(t, orgAdmin.UserID, records[0].ActorID)
+		assert.Equal(t, serverAdmin.UserID, records[0].ImpersonatedBy)
+	})
+}
+
+func TestService_PermissionTemplates(t *testing.T) {
+	ctx := context.Background()
+	ac := setupTestEnv(t)
+
+	editor := accesscontrol.PermissionTemplate{
+		Name:          "folder-editor",
+		Actions:       []string{"folders:read", "folders:write"},
+		ScopeTemplate: "folders:uid:{{.ResourceUID}}",
+	}
+	viewer := accesscontrol.PermissionTemplate{
+		Name:          "dashboard-viewer",
+		Actions:       []string{"dashboards:read"},
+		ScopeTemplate: "dashboards:uid:{{.ResourceUID}}",
+	}
+
+	t.Run("registered templates are listed by name", func(t *testing.T) {
+		require.NoError(t, ac.RegisterPermissionTemplate(editor))
+		require.NoError(t, ac.RegisterPermissionTemplate(viewer))
+		assert.Equal(t, []accesscontrol.PermissionTemplate{viewer, editor}, ac.GetPermissionTemplates())
+	})
+
+	t.Run("names are unique", func(t *testing.T) {
+		err := ac.RegisterPermissionTemplate(editor)
+		assert.ErrorIs(t, err, accesscontrol.ErrTemplateExists)
+	})
+
+	t.Run("invalid templates are rejected", func(t *testing.T) {
+		err := ac.RegisterPermissionTemplate(accesscontrol.PermissionTemplate{Name: "empty"})
+		assert.ErrorIs(t, err, accesscontrol.ErrInvalidTemplate)
+		assert.Len(t, ac.GetPermissionTemplates(), 2)
+	})
+
+	err := db.InitTestDB(t).WithDbSession(ctx, func(sess *db.Session) error {
+		_, err := sess.Insert(&accesscontrol.Role{OrgID: 1, UID: "templated", Name: "custom:templated", Created: time.Now(), Updated: time.Now()})
+		return err
+	})
+	require.NoError(t, err)
+
+	t.Run("applying a template adds its permissions to the role", func(t *testing.T) {
+		permissions, err := ac.ApplyPermissionTemplate(ctx, &accesscontrol.ApplyTemplateCommand{
+			OrgID: 1, RoleUID: "templated", Template: "folder-editor", Vars: map[string]string{"ResourceUID": "general"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []accesscontrol.Permission{
+			{Action: "folders:read", Scope: "folders:uid:general"},
+			{Action: "folders:write", Scope: "folders:uid:general"},
+		}, permissions)
+	})
+
+	t.Run("applying a template fails on a missing variable", func(t *testing.T) {
+		_, err := ac.ApplyPermissionTemplate(ctx, &accesscontrol.ApplyTemplateCommand{OrgID: 1, RoleUID: "templated", Template: "folder-editor"})
+		assert.ErrorIs(t, err, accesscontrol.ErrTemplateVariableMissing)
+	})
+
+	t.Run("applying an unknown template fails", func(t *testing.T) {
+		_, err := ac.ApplyPermissionTemplate(ctx, &accesscontrol.ApplyTemplateCommand{OrgID: 1, RoleUID: "templated", Template: "unknown"})
+		assert.ErrorIs(t, err, accesscontrol.ErrTemplateNotFound)
+	})
+
+	t.Run("applying a template to a role of another org fails", func(t *testing.T) {
+		_, err := ac.ApplyPermissionTemplate(ctx, &accesscontrol.ApplyTemplateCommand{
+			OrgID: 2, RoleUID: "templated", Template: "folder-editor", Vars: map[string]string{"ResourceUID": "general"},
+		})
+		assert.ErrorIs(t, err, accesscontrol.ErrRoleNotFound)
+	})
+}
+
+func TestService_GetUserPermissionIndex(t *testing.T) {
+	ctx := context.Background()
+	sql := db.InitTestDB(t)
+	ac := setupTestEnv(t)
+	ac.store = database.ProvideService(sql)
+	ac.cache = localcache.ProvideService()
+	ac.cfg.RBACPermissionCache = true
+
+	usr, err := sql.CreateUser(ctx, user.CreateUserCommand{Login: "user", OrgID: 1})
+	require.NoError(t, err)
+
+	_, err = rs.NewStore(sql).SetUserResourcePermission(ctx, 1, accesscontrol.User{ID: usr.ID}, rs.SetResourcePermissionCommand{
+		Actions:           []string{"dashboards:write"},
+		Resource:          "dashboards",
+		ResourceAttribute: "uid",
+		ResourceID:        "1",
+	}, nil)
+	require.NoError(t, err)
+
+	signedInUser := &user.SignedInUser{OrgID: 1, UserID: usr.ID}
+	idx, err := ac.GetUserPermissionIndex(ctx, signedInUser, accesscontrol.Options{})
+	require.NoError(t, err)
+	assert.True(t, idx.HasPermission("dashboards:write", "dashboards:uid:1"))
+	assert.False(t, idx.HasPermission("dashboards:write", "dashboards:uid:2"))
+	assert.Equal(t, []string{"dashboards:uid:1"}, idx.AllForAction("dashboards:write"))
+
+	// the index is cached alongside the permissions
+	cached, err := ac.GetUserPermissionIndex(ctx, signedInUser, accesscontrol.Options{})
+	require.NoError(t, err)
+	assert.Same(t, idx, cached)
+}
+
+func TestService_GetSimplifiedUsersPermissionsPaged(t *testing.T) {
+	ctx := context.Background()
+	sql := db.InitTestDB(t)
+	ac := setupTestEnv(t)
+	ac.store = database.ProvideService(sql)
+	require.NoError(t, accesscontrol.DeclareFixedRoles(ac))
+	require.NoError(t, ac.RegisterFixedRoles(ctx))
+
+	admin, err := sql.CreateUser(ctx, user.CreateUserCommand{Login: "admin", OrgName: "paged", DefaultOrgRole: string(org.RoleAdmin)})
+	require.NoError(t, err)
+	orgID := admin.OrgID
+	requester := &user.SignedInUser{OrgID: orgID, UserID: admin.ID, OrgRole: org.RoleAdmin}
+
+	for i := 0; i < 5; i++ {
+		usr, err := sql.CreateUser(ctx, user.CreateUserCommand{Login: fmt.Sprintf("user%d", i)})
+		require.NoError(t, err)
+		require.NoError(t, sql.AddOrgUser(ctx, &models.AddOrgUserCommand{OrgId: orgID, UserId: usr.ID, Role: org.RoleViewer}))
+		_, err = rs.NewStore(sql).SetUserResourcePermission(ctx, orgID, accesscontrol.User{ID: usr.ID}, rs.SetResourcePermissionCommand{
+			Actions:           []string{"dashboards:write"},
+			Resource:          "dashboards",
+			ResourceAttribute: "uid",
+			ResourceID:        fmt.Sprint(i),
+		}, nil)
+		require.NoError(t, err)
+	}
+
+	full, err := ac.GetSimplifiedUsersPermissionsPaged(ctx, requester, orgID, "dashboards:", "", 0)
+	require.NoError(t, err)
+	require.Len(t, full.Data, 5)
+	require.Empty(t, full.NextCursor)
+	require.Empty(t, full.PrevCursor)
+	var maxUserID int64
+	for userID, actions := range full.Data {
+		assert.Equal(t, []string{"dashboards:write"}, actions)
+		if userID > maxUserID {
+			maxUserID = userID
+		}
+	}
+
+	t.Run("forward and backward pagination", func(t *testing.T) {
+		var pages []*accesscontrol.PagedPermissions
+		all := map[int64][]string{}
+		total := 0
+		cursor := ""
+		for {
+			page, err := ac.GetSimplifiedUsersPermissionsPaged(ctx, requester, orgID, "dashboards:", cursor, 2)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page.Data), 2)
+			pages = append(pages, page)
+			total += len(page.Data)
+			for userID, actions := range page.Data {
+				all[userID] = actions
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		require.Len(t, pages, 3)
+		assert.Empty(t, pages[0].PrevCursor)
+		assert.Equal(t, len(full.Data), total)
+		assert.Equal(t, full.Data, all)
+
+		cursor = pages[len(pages)-1].PrevCursor
+		for i := len(pages) - 2; i >= 0; i-- {
+			require.NotEmpty(t, cursor)
+			page, err := ac.GetSimplifiedUsersPermissionsPaged(ctx, requester, orgID, "dashboards:", cursor, 2)
+			require.NoError(t, err)
+			assert.Equal(t, pages[i].Data, page.Data)
+			cursor = page.PrevCursor
+		}
+		assert.Empty(t, cursor)
+	})
+
+	t.Run("empty page after the last user", func(t *testing.T) {
+		cursor := accesscontrol.PermissionsCursor{UserID: maxUserID, Timestamp: time.Now()}.Encode()
+		page, err := ac.GetSimplifiedUsersPermissionsPaged(ctx, requester, orgID, "dashboards:", cursor, 2)
+		require.NoError(t, err)
+		assert.Empty(t, page.Data)
+		assert.Empty(t, page.NextCursor)
+		assert.Empty(t, page.PrevCursor)
+	})
+
+	t.Run("invalid and expired cursors are rejected", func(t *testing.T) {
+		expired := accesscontrol.PermissionsCursor{UserID: 1, Timestamp: time.Now().Add(-2 * accesscontrol.PermissionsCursorTTL)}.Encode()
+		for _, cursor := range []string{"not a cursor", expired} {
+			_, err := ac.GetSimplifiedUsersPermissionsPaged(ctx, requester, orgID, "dashboards:", cursor, 2)
+			assert.ErrorIs(t, err, accesscontrol.ErrInvalidCursor)
+		}
+	})
+
+	t.Run("requester without org users read gets no users", func(t *testing.T) {
+		viewer := &user.SignedInUser{OrgID: orgID, UserID: maxUserID, OrgRole: org.RoleViewer}
+		page, err := ac.GetSimplifiedUsersPermissionsPaged(ctx, viewer, orgID, "dashboards:", "", 0)
+		require.NoError(t, err)
+		assert.Empty(t, page.Data)
+	})
+}
+
+type recordedSpan struct {
+	name       string
+	parent     string
+	attributes map[string]interface{}
+	ended      bool
+}
+
+func (s *recordedSpan) End() { s.ended = true }
+func (s *recordedSpan) SetAttributes(key string, value interface{}, _ attribute.KeyValue) {
+	s.attributes[key] = value
+}
+func (s *recordedSpan) SetName(name string)                                  { s.name = name }
+func (s *recordedSpan) SetStatus(codes.Code, string)                         {}
+func (s *recordedSpan) RecordError(error, ...trace.EventOption)              {}
+func (s *recordedSpan) AddEvents(keys []string, values []tracing.EventValue) {}
+
+type recordedSpanKey struct{}
+
+// recordingTracer records the spans started through it and their parents.
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Run(context.Context) error                         { return nil }
+func (t *recordingTracer) Inject(context.Context, http.Header, tracing.Span) {}
+func (t *recordingTracer) Start(ctx context.Context, spanName string, _ ...trace.SpanStartOption) (context.Context, tracing.Span) {
+	span := &recordedSpan{name: spanName, attributes: map[string]interface{}{}}
+	if parent, ok := ctx.Value(recordedSpanKey{}).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+// children returns the names of the spans started under the span with the given name.
+func (t *recordingTracer) children(name string) []string {
+	var children []string
+	for _, span := range t.spans {
+		if span.parent == name {
+			children = append(children, span.name)
+		}
+	}
+	return children
+}
+
+func TestService_GetUserPermissions_Tracing(t *testing.T) {
+	ac := setupTestEnv(t)
+	ac.cache = localcache.ProvideService()
+	usr := &user.SignedInUser{OrgID: 1, UserID: 2, OrgRole: org.RoleViewer}
+
+	getPermissions := func(t *testing.T) *recordingTracer {
+		t.Helper()
+		tracer := &recordingTracer{}
+		ac.tracer = tracer
+		_, err := ac.GetUserPermissions(context.Background(), usr, accesscontrol.Options{})
+		require.NoError(t, err)
+
+		require.NotEmpty(t, tracer.spans)
+		root := tracer.spans[0]
+		assert.Equal(t, "accesscontrol.GetUserPermissions", root.name)
+		assert.Empty(t, root.parent)
+		assert.Equal(t, int64(2), root.attributes["user.id"])
+		assert.Equal(t, int64(1), root.attributes["org.id"])
+		for _, span := range tracer.spans {
+			assert.True(t, span.ended, "span %s was not ended", span.name)
+		}
+		return tracer
+	}
+
+	t.Run("without the permission cache only the store is traced", func(t *testing.T) {
+		ac.cfg.RBACPermissionCache = false
+		tracer := getPermissions(t)
+		assert.Equal(t, false, tracer.spans[0].attributes["cache.hit"])
+		assert.Equal(t, []string{"accesscontrol.GetUserPermissions.store"}, tracer.children("accesscontrol.GetUserPermissions"))
+	})
+
+	ac.cfg.RBACPermissionCache = true
+	t.Run("cache miss traces the cache lookup and the store", func(t *testing.T) {
+		tracer := getPermissions(t)
+		assert.Equal(t, false, tracer.spans[0].attributes["cache.hit"])
+		assert.Equal(t, []string{"accesscontrol.GetUserPermissions.cache", "accesscontrol.GetUserPermissions.store"}, tracer.children("accesscontrol.GetUserPermissions"))
+	})
+
+	t.Run("cache hit does not trace the store", func(t *testing.T) {
+		tracer := getPermissions(t)
+		assert.Equal(t, true, tracer.spans[0].attributes["cache.hit"])
+		assert.Equal(t, []string{"accesscontrol.GetUserPermissions.cache"}, tracer.children("accesscontrol.GetUserPermissions"))
+	})
+}
@@ -14,6 +14,16 @@ type FakeService struct {
 	ExpectedErr         error
 	ExpectedDisabled    bool
 	ExpectedPermissions []accesscontrol.Permission
+	ExpectedSnapshot    *accesscontrol.PermissionSnapshot
+	ExpectedSnapshots   []*accesscontrol.SnapshotMeta
+	ExpectedRevoked     int
+	ExpectedPage        *accesscontrol.PagedPermissions
+	ExpectedUser        *user.SignedInUser
+	ExpectedTemplates   []accesscontrol.PermissionTemplate
+	ExpectedDenyRules   []accesscontrol.DenyRule
+	ExpectedVersions    []*accesscontrol.RoleVersion
+
+	ExpectedResourcePermissions []accesscontrol.ResourcePermission
 }
 
 func (f FakeService) GetUsageStats(ctx context.Context) map[string]interface{} {
@@ -28,6 +38,78 @@ func (f FakeService) DeleteUserPermissions(ctx context.Context, orgID, userID in
 	return f.ExpectedErr
 }
 
+func (f FakeService) RevokeAllUserRoles(ctx context.Context, orgID, userID, actorID int64) (int, error) {
+	return f.ExpectedRevoked, f.ExpectedErr
+}
+
+func (f FakeService) CopyUserPermissions(ctx context.Context, cmd *accesscontrol.CopyPermissionsCommand) error {
+	return f.ExpectedErr
+}
+
+func (f FakeService) DenyPermission(ctx context.Context, cmd *accesscontrol.DenyCommand) error {
+	return f.ExpectedErr
+}
+
+func (f FakeService) ListDeniedPermissions(ctx context.Context, orgID, userID int64) ([]accesscontrol.DenyRule, error) {
+	return f.ExpectedDenyRules, f.ExpectedErr
+}
+
+func (f FakeService) RevokeDenyRule(ctx context.Context, orgID, ruleID int64) error {
+	return f.ExpectedErr
+}
+
+func (f FakeService) GrantTemporaryPermission(ctx context.Context, cmd *accesscontrol.TemporaryPermissionCommand) error {
+	return f.ExpectedErr
+}
+
+func (f FakeService) GetServiceAccountPermissions(ctx context.Context, orgID, serviceAccountID int64) ([]accesscontrol.Permission, error) {
+	return f.ExpectedPermissions, f.ExpectedErr
+}
+
+func (f FakeService) GrantServiceAccountRole(ctx context.Context, granter *user.SignedInUser, cmd *accesscontrol.ServiceAccountRoleCommand) error {
+	return f.ExpectedErr
+}
+
+func (f FakeService) RevokeServiceAccountRole(ctx context.Context, cmd *accesscontrol.ServiceAccountRoleCommand) error {
+	return f.ExpectedErr
+}
+
+func (f FakeService) GetSimplifiedUsersPermissionsPaged(ctx context.Context, requester *user.SignedInUser, orgID int64, actionPrefix, cursor string, limit int) (*accesscontrol.PagedPermissions, error) {
+	return f.ExpectedPage, f.ExpectedErr
+}
+
+func (f FakeService) SnapshotPermissions(ctx context.Context, orgID int64) (*accesscontrol.PermissionSnapshot, error) {
+	return f.ExpectedSnapshot, f.ExpectedErr
+}
+
+func (f FakeService) StoreSnapshot(ctx context.Context, snap *accesscontrol.PermissionSnapshot) error {
+	return f.ExpectedErr
+}
+
+func (f FakeService) GetSnapshot(ctx context.Context, orgID, snapshotID int64) (*accesscontrol.PermissionSnapshot, error) {
+	return f.ExpectedSnapshot, f.ExpectedErr
+}
+
+func (f FakeService) ListSnapshots(ctx context.Context, orgID int64) ([]*accesscontrol.SnapshotMeta, error) {
+	return f.ExpectedSnapshots, f.ExpectedErr
+}
+
+func (f FakeService) ImpersonateUser(ctx context.Context, admin *user.SignedInUser, targetUserID int64) (*user.SignedInUser, error) {
+	return f.ExpectedUser, f.ExpectedErr
+}
+
+func (f FakeService) RegisterPermissionTemplate(tmpl accesscontrol.PermissionTemplate) error {
+	return f.ExpectedErr
+}
+
+func (f FakeService) GetPermissionTemplates() []accesscontrol.PermissionTemplate {
+	return f.ExpectedTemplates
+}
+
+func (f FakeService) ApplyPermissionTemplate(ctx context.Context, cmd *accesscontrol.ApplyTemplateCommand) ([]accesscontrol.Permission, error) {
+	return f.ExpectedPermissions, f.ExpectedErr
+}
+
 func (f FakeService) DeclareFixedRoles(registrations ...accesscontrol.RoleRegistration) error {
 	return f.ExpectedErr
 }
@@ -46,15 +128,28 @@ type FakeAccessControl struct {
 	ExpectedErr      error
 	ExpectedDisabled bool
 	ExpectedEvaluate bool
+	ExpectedTrace    *accesscontrol.EvalTrace
 }
 
-func (f FakeAccessControl) Evaluate(ctx context.Context, user *user.SignedInUser, evaluator accesscontrol.Evaluator) (bool, error) {
+func (f FakeAccessControl) Evaluate(ctx context.Context, user *user.SignedInUser, evaluator accesscontrol.Evaluator, opts ...accesscontrol.EvalOption) (bool, error) {
 	return f.ExpectedEvaluate, f.ExpectedErr
 }
 
+func (f FakeAccessControl) EvaluateWithTrace(ctx context.Context, user *user.SignedInUser, evaluator accesscontrol.Evaluator) (*accesscontrol.EvalTrace, error) {
+	return f.ExpectedTrace, f.ExpectedErr
+}
+
 func (f FakeAccessControl) RegisterScopeAttributeResolver(prefix string, resolver accesscontrol.ScopeAttributeResolver) {
 }
 
 func (f FakeAccessControl) IsDisabled() bool {
 	return f.ExpectedDisabled
 }
+
+func (f FakeService) GetRoleVersionHistory(ctx context.Context, orgID int64, roleUID string) ([]*accesscontrol.RoleVersion, error) {
+	return f.ExpectedVersions, f.ExpectedErr
+}
+
+func (f FakeService) ListPermissionsForResource(ctx context.Context, orgID int64, resourceType, resourceUID string) ([]accesscontrol.ResourcePermission, error) {
+	return f.ExpectedResourcePermissions, f.ExpectedErr
+}
@@ -1,41 +1,345 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/grafana/grafana/pkg/api/response"
 	"github.com/grafana/grafana/pkg/api/routing"
+	"github.com/grafana/grafana/pkg/infra/tracing"
 	"github.com/grafana/grafana/pkg/middleware"
 	"github.com/grafana/grafana/pkg/models"
 	ac "github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts"
+	"github.com/grafana/grafana/pkg/util"
+	"github.com/grafana/grafana/pkg/web"
 )
 
-func NewAccessControlAPI(router routing.RouteRegister, service ac.Service) *AccessControlAPI {
+func NewAccessControlAPI(router routing.RouteRegister, service ac.Service, tracer tracing.Tracer) *AccessControlAPI {
 	return &AccessControlAPI{
 		RouteRegister: router,
 		Service:       service,
+		Tracer:        tracer,
 	}
 }
 
 type AccessControlAPI struct {
 	Service       ac.Service
 	RouteRegister routing.RouteRegister
+	// Tracer traces the permission checks of the endpoints
+	Tracer tracing.Tracer
 }
 
 func (api *AccessControlAPI) RegisterAPIEndpoints() {
 	// Users
 	api.RouteRegister.Get("/api/access-control/user/permissions",
 		middleware.ReqSignedIn, routing.Wrap(api.getUsersPermissions))
+	api.RouteRegister.Get("/api/access-control/users/permissions",
+		middleware.ReqOrgAdmin, routing.Wrap(api.getSimplifiedUsersPermissions))
+
+	// Role assignments
+	api.RouteRegister.Delete("/api/access-control/orgs/:orgID/users/:userID/roles",
+		middleware.ReqGrafanaAdmin, routing.Wrap(api.revokeAllUserRoles))
+	api.RouteRegister.Post("/api/access-control/users/permissions/copy",
+		middleware.ReqOrgAdmin, routing.Wrap(api.copyUserPermissions))
+
+	// Service accounts
+	api.RouteRegister.Get("/api/access-control/serviceaccounts/:serviceAccountId/permissions",
+		api.requirePermission(ac.EvalPermission(serviceaccounts.ActionPermissionsRead, serviceaccounts.ScopeID)), routing.Wrap(api.getServiceAccountPermissions))
+	api.RouteRegister.Post("/api/access-control/serviceaccounts/:serviceAccountId/roles",
+		api.requirePermission(ac.EvalPermission(serviceaccounts.ActionPermissionsWrite, serviceaccounts.ScopeID)), routing.Wrap(api.grantServiceAccountRole))
+	api.RouteRegister.Delete("/api/access-control/serviceaccounts/:serviceAccountId/roles/:roleUID",
+		api.requirePermission(ac.EvalPermission(serviceaccounts.ActionPermissionsWrite, serviceaccounts.ScopeID)), routing.Wrap(api.revokeServiceAccountRole))
+
+	// Permission templates
+	api.RouteRegister.Get("/api/access-control/templates",
+		middleware.ReqOrgAdmin, routing.Wrap(api.getPermissionTemplates))
+	api.RouteRegister.Post("/api/access-control/roles/:roleUID/from-template",
+		middleware.ReqGrafanaAdmin, routing.Wrap(api.applyPermissionTemplate))
+
+	// Role history
+	api.RouteRegister.Get("/api/access-control/roles/:roleUID/history",
+		middleware.ReqOrgAdmin, routing.Wrap(api.getRoleVersionHistory))
+
+	// Resource permissions
+	api.RouteRegister.Get("/api/access-control/resources/:resourceType/:resourceUID/permissions",
+		api.requirePermission(ac.EvalPermission(ac.ActionUsersPermissionsRead)), routing.Wrap(api.listResourcePermissions))
+
+	// Org permission snapshots
+	api.RouteRegister.Post("/api/access-control/org/snapshot",
+		middleware.ReqOrgAdmin, routing.Wrap(api.createPermissionSnapshot))
+	api.RouteRegister.Get("/api/access-control/org/snapshots",
+		middleware.ReqOrgAdmin, routing.Wrap(api.listPermissionSnapshots))
+	api.RouteRegister.Get("/api/access-control/org/snapshot/diff",
+		middleware.ReqOrgAdmin, routing.Wrap(api.diffPermissionSnapshots))
 }
 
 // GET /api/access-control/user/permissions
 func (api *AccessControlAPI) getUsersPermissions(c *models.ReqContext) response.Response {
 	reloadCache := c.QueryBool("reloadcache")
+	filter := ac.PermissionFilter{
+		ActionPrefix: c.Query("actionPrefix"),
+		Scope:        c.Query("scope"),
+	}
 	permissions, err := api.Service.GetUserPermissions(c.Req.Context(),
-		c.SignedInUser, ac.Options{ReloadCache: reloadCache})
+		c.SignedInUser, ac.Options{ReloadCache: reloadCache, Filter: filter})
 	if err != nil {
-		response.JSON(http.StatusInternalServerError, err)
+		return response.Error(http.StatusInternalServerError, "Failed to get user permissions", err)
 	}
 
 	return response.JSON(http.StatusOK, ac.BuildPermissionsMap(permissions))
 }
+
+// GET /api/access-control/users/permissions?actionPrefix=X&cursor=Y&limit=Z
+func (api *AccessControlAPI) getSimplifiedUsersPermissions(c *models.ReqContext) response.Response {
+	page, err := api.Service.GetSimplifiedUsersPermissionsPaged(c.Req.Context(), c.SignedInUser, c.OrgID,
+		c.Query("actionPrefix"), c.Query("cursor"), c.QueryInt("limit"))
+	if err != nil {
+		if errors.Is(err, ac.ErrInvalidCursor) {
+			return response.Error(http.StatusBadRequest, "Invalid or expired cursor", err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to get users permissions", err)
+	}
+
+	return response.JSON(http.StatusOK, page)
+}
+
+// DELETE /api/access-control/orgs/:orgID/users/:userID/roles
+func (api *AccessControlAPI) revokeAllUserRoles(c *models.ReqContext) response.Response {
+	orgID, err := strconv.ParseInt(web.Params(c.Req)[":orgID"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "orgID is invalid", err)
+	}
+	userID, err := strconv.ParseInt(web.Params(c.Req)[":userID"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "userID is invalid", err)
+	}
+
+	revoked, err := api.Service.RevokeAllUserRoles(c.Req.Context(), orgID, userID, c.SignedInUser.UserID)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to revoke user roles", err)
+	}
+
+	return response.JSON(http.StatusOK, util.DynMap{"message": "User roles revoked", "revoked": revoked})
+}
+
+// POST /api/access-control/users/permissions/copy
+func (api *AccessControlAPI) copyUserPermissions(c *models.ReqContext) response.Response {
+	cmd := ac.CopyPermissionsCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	if cmd.SourceUserID <= 0 || cmd.TargetUserID <= 0 {
+		return response.Error(http.StatusBadRequest, "Both sourceUserId and targetUserId are required", nil)
+	}
+	if cmd.SourceUserID == cmd.TargetUserID {
+		return response.Error(http.StatusBadRequest, "Source and target user must be different", nil)
+	}
+	cmd.OrgID = c.OrgID
+
+	if err := api.Service.CopyUserPermissions(c.Req.Context(), &cmd); err != nil {
+		if errors.Is(err, ac.ErrPermissionConflict) {
+			return response.Error(http.StatusConflict, "Target user has conflicting role assignments", err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to copy user permissions", err)
+	}
+
+	return response.Success("User permissions copied")
+}
+
+// GET /api/access-control/serviceaccounts/:serviceAccountId/permissions
+func (api *AccessControlAPI) getServiceAccountPermissions(c *models.ReqContext) response.Response {
+	serviceAccountID, err := strconv.ParseInt(web.Params(c.Req)[":serviceAccountId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "serviceAccountID is invalid", err)
+	}
+
+	permissions, err := api.Service.GetServiceAccountPermissions(c.Req.Context(), c.OrgID, serviceAccountID)
+	if err != nil {
+		return serviceAccountRoleError(err, "Failed to get service account permissions")
+	}
+
+	return response.JSON(http.StatusOK, permissions)
+}
+
+// POST /api/access-control/serviceaccounts/:serviceAccountId/roles
+func (api *AccessControlAPI) grantServiceAccountRole(c *models.ReqContext) response.Response {
+	cmd := ac.ServiceAccountRoleCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	if cmd.RoleUID == "" {
+		return response.Error(http.StatusBadRequest, "roleUid is required", nil)
+	}
+	serviceAccountID, err := strconv.ParseInt(web.Params(c.Req)[":serviceAccountId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "serviceAccountID is invalid", err)
+	}
+	cmd.OrgID = c.OrgID
+	cmd.ServiceAccountID = serviceAccountID
+
+	if err := api.Service.GrantServiceAccountRole(c.Req.Context(), c.SignedInUser, &cmd); err != nil {
+		return serviceAccountRoleError(err, "Failed to grant service account role")
+	}
+
+	return response.Success("Role granted to service account")
+}
+
+// DELETE /api/access-control/serviceaccounts/:serviceAccountId/roles/:roleUID
+func (api *AccessControlAPI) revokeServiceAccountRole(c *models.ReqContext) response.Response {
+	serviceAccountID, err := strconv.ParseInt(web.Params(c.Req)[":serviceAccountId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "serviceAccountID is invalid", err)
+	}
+	cmd := ac.ServiceAccountRoleCommand{OrgID: c.OrgID, ServiceAccountID: serviceAccountID, RoleUID: web.Params(c.Req)[":roleUID"]}
+
+	if err := api.Service.RevokeServiceAccountRole(c.Req.Context(), &cmd); err != nil {
+		return serviceAccountRoleError(err, "Failed to revoke service account role")
+	}
+
+	return response.Success("Role revoked from service account")
+}
+
+func serviceAccountRoleError(err error, message string) response.Response {
+	switch {
+	case errors.Is(err, ac.ErrServiceAccountNotFound):
+		return response.Error(http.StatusNotFound, "Service account not found", err)
+	case errors.Is(err, ac.ErrRoleNotFound):
+		return response.Error(http.StatusNotFound, "Role not found", err)
+	case errors.Is(err, ac.ErrRoleNotAssignable):
+		return response.Error(http.StatusBadRequest, "Role cannot be assigned to service accounts", err)
+	case errors.Is(err, ac.ErrPermissionEscalation):
+		return response.Error(http.StatusForbidden, "Cannot grant a role with permissions you do not have", err)
+	}
+	return response.Error(http.StatusInternalServerError, message, err)
+}
+
+// GET /api/access-control/templates
+func (api *AccessControlAPI) getPermissionTemplates(c *models.ReqContext) response.Response {
+	return response.JSON(http.StatusOK, api.Service.GetPermissionTemplates())
+}
+
+// POST /api/access-control/roles/:roleUID/from-template
+func (api *AccessControlAPI) applyPermissionTemplate(c *models.ReqContext) response.Response {
+	cmd := ac.ApplyTemplateCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	if cmd.Template == "" {
+		return response.Error(http.StatusBadRequest, "template is required", nil)
+	}
+	cmd.OrgID = c.OrgID
+	cmd.RoleUID = web.Params(c.Req)[":roleUID"]
+	cmd.UpdatedBy = c.UserID
+
+	permissions, err := api.Service.ApplyPermissionTemplate(c.Req.Context(), &cmd)
+	if err != nil {
+		switch {
+		case errors.Is(err, ac.ErrTemplateNotFound):
+			return response.Error(http.StatusNotFound, "Permission template not found", err)
+		case errors.Is(err, ac.ErrRoleNotFound):
+			return response.Error(http.StatusNotFound, "Role not found", err)
+		case errors.Is(err, ac.ErrTemplateVariableMissing):
+			return response.Error(http.StatusBadRequest, err.Error(), err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to apply permission template", err)
+	}
+
+	return response.JSON(http.StatusOK, permissions)
+}
+
+// GET /api/access-control/roles/:roleUID/history
+func (api *AccessControlAPI) getRoleVersionHistory(c *models.ReqContext) response.Response {
+	versions, err := api.Service.GetRoleVersionHistory(c.Req.Context(), c.OrgID, web.Params(c.Req)[":roleUID"])
+	if err != nil {
+		if errors.Is(err, ac.ErrRoleNotFound) {
+			return response.Error(http.StatusNotFound, "Role not found", err)
+		}
+		return response.Error(http.StatusInternalServerError, "Failed to get role history", err)
+	}
+
+	return response.JSON(http.StatusOK, versions)
+}
+
+// GET /api/access-control/resources/:resourceType/:resourceUID/permissions
+func (api *AccessControlAPI) listResourcePermissions(c *models.ReqContext) response.Response {
+	params := web.Params(c.Req)
+	permissions, err := api.Service.ListPermissionsForResource(c.Req.Context(), c.OrgID, params[":resourceType"], params[":resourceUID"])
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to list resource permissions", err)
+	}
+
+	return response.JSON(http.StatusOK, permissions)
+}
+
+// requirePermission denies the request unless the permissions of the signed in
+// user in the current org, loaded by ac.LoadPermissionsMiddleware, satisfy
+// evaluator with the URL params of the request injected into its scopes.
+func (api *AccessControlAPI) requirePermission(evaluator ac.Evaluator) web.Handler {
+	return func(c *models.ReqContext) {
+		injected, err := evaluator.MutateScopes(c.Req.Context(), ac.ScopeInjector(c.OrgID, web.Params(c.Req)))
+		if err != nil {
+			c.JsonApiErr(http.StatusInternalServerError, "Internal server error", err)
+			return
+		}
+		hasAccess, _ := ac.EvaluateWithSpan(c.Req.Context(), api.Tracer, c.SignedInUser, injected, func(context.Context) (bool, error) {
+			return c.IsSignedIn && injected.Evaluate(c.SignedInUser.Permissions[c.OrgID]), nil
+		})
+		if !hasAccess {
+			c.JsonApiErr(http.StatusForbidden, "You'll need additional permissions to perform this action. Permissions needed: "+injected.String(), nil)
+		}
+	}
+}
+
+// POST /api/access-control/org/snapshot
+func (api *AccessControlAPI) createPermissionSnapshot(c *models.ReqContext) response.Response {
+	snapshot, err := api.Service.SnapshotPermissions(c.Req.Context(), c.OrgID)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to snapshot permissions", err)
+	}
+
+	if err := api.Service.StoreSnapshot(c.Req.Context(), snapshot); err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to store permission snapshot", err)
+	}
+
+	return response.JSON(http.StatusOK, snapshot)
+}
+
+// GET /api/access-control/org/snapshots
+func (api *AccessControlAPI) listPermissionSnapshots(c *models.ReqContext) response.Response {
+	snapshots, err := api.Service.ListSnapshots(c.Req.Context(), c.OrgID)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to list permission snapshots", err)
+	}
+
+	return response.JSON(http.StatusOK, snapshots)
+}
+
+// GET /api/access-control/org/snapshot/diff?from=X&to=Y
+func (api *AccessControlAPI) diffPermissionSnapshots(c *models.ReqContext) response.Response {
+	fromID, toID := c.QueryInt64("from"), c.QueryInt64("to")
+	if fromID <= 0 || toID <= 0 {
+		return response.Error(http.StatusBadRequest, "Both from and to snapshot ids are required", nil)
+	}
+
+	from, err := api.Service.GetSnapshot(c.Req.Context(), c.OrgID, fromID)
+	if err != nil {
+		return snapshotError(err)
+	}
+
+	to, err := api.Service.GetSnapshot(c.Req.Context(), c.OrgID, toID)
+	if err != nil {
+		return snapshotError(err)
+	}
+
+	return response.JSON(http.StatusOK, ac.DiffPermissionSnapshots(from, to))
+}
+
+func snapshotError(err error) response.Response {
+	if errors.Is(err, ac.ErrSnapshotNotFound) {
+		return response.Error(http.StatusNotFound, "Permission snapshot not found", err)
+	}
+	return response.Error(http.StatusInternalServerError, "Failed to get permission snapshot", err)
+}
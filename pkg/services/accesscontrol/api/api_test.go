@@ -0,0 +1,270 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/api/routing"
+	"github.com/grafana/grafana/pkg/infra/tracing"
+	ac "github.com/grafana/grafana/pkg/services/accesscontrol"
+	acmocks "github.com/grafana/grafana/pkg/services/accesscontrol/mocks"
+	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/services/serviceaccounts"
+	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/web/webtest"
+)
+
+func setupTestServer(t *testing.T, service ac.Service) *webtest.Server {
+	t.Helper()
+	routeRegister := routing.NewRouteRegister()
+	NewAccessControlAPI(routeRegister, service, tracing.InitializeTracerForTest()).RegisterAPIEndpoints()
+	return webtest.NewServer(t, routeRegister)
+}
+
+func TestAccessControlAPI_getUsersPermissions(t *testing.T) {
+	signedInUser := &user.SignedInUser{OrgID: 1, UserID: 2}
+
+	t.Run("returns 500 when permissions cannot be loaded", func(t *testing.T) {
+		service := acmocks.NewService(t)
+		service.On("GetUserPermissions", mock.Anything, signedInUser, mock.Anything).
+			Return(nil, errors.New("database is down"))
+		s := setupTestServer(t, service)
+
+		req := webtest.RequestWithSignedInUser(s.NewGetRequest("/api/access-control/user/permissions"), signedInUser)
+		resp, err := s.Send(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	})
+
+	t.Run("returns the permissions of the signed in user", func(t *testing.T) {
+		service := acmocks.NewService(t)
+		service.On("GetUserPermissions", mock.Anything, signedInUser, ac.Options{Filter: ac.PermissionFilter{ActionPrefix: "dashboards:"}}).
+			Return([]ac.Permission{{Action: "dashboards:read", Scope: "dashboards:*"}}, nil)
+		s := setupTestServer(t, service)
+
+		req := webtest.RequestWithSignedInUser(s.NewGetRequest("/api/access-control/user/permissions?actionPrefix=dashboards:"), signedInUser)
+		resp, err := s.Send(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var permissions map[string]bool
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&permissions))
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, map[string]bool{"dashboards:read": true}, permissions)
+	})
+}
+
+func TestAccessControlAPI_permissionTemplates(t *testing.T) {
+	signedInUser := &user.SignedInUser{OrgID: 1, UserID: 2, OrgRole: org.RoleAdmin, IsGrafanaAdmin: true}
+
+	t.Run("lists the registered templates", func(t *testing.T) {
+		templates := []ac.PermissionTemplate{{Name: "folder-editor", Actions: []string{"folders:write"}, ScopeTemplate: "folders:uid:{{.ResourceUID}}"}}
+		service := acmocks.NewService(t)
+		service.On("GetPermissionTemplates").Return(templates)
+		s := setupTestServer(t, service)
+
+		req := webtest.RequestWithSignedInUser(s.NewGetRequest("/api/access-control/templates"), signedInUser)
+		resp, err := s.Send(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result []ac.PermissionTemplate
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, templates, result)
+	})
+
+	t.Run("applies a template to the role", func(t *testing.T) {
+		expected := &ac.ApplyTemplateCommand{OrgID: 1, RoleUID: "custom", UpdatedBy: 2, Template: "folder-editor", Vars: map[string]string{"ResourceUID": "general"}}
+		service := acmocks.NewService(t)
+		service.On("ApplyPermissionTemplate", mock.Anything, expected).
+			Return([]ac.Permission{{Action: "folders:write", Scope: "folders:uid:general"}}, nil)
+		s := setupTestServer(t, service)
+
+		body := `{"template": "folder-editor", "vars": {"ResourceUID": "general"}}`
+		req := webtest.RequestWithSignedInUser(s.NewPostRequest("/api/access-control/roles/custom/from-template", strings.NewReader(body)), signedInUser)
+		resp, err := s.SendJSON(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("returns 400 on a missing variable", func(t *testing.T) {
+		service := acmocks.NewService(t)
+		service.On("ApplyPermissionTemplate", mock.Anything, mock.Anything).Return(nil, ac.ErrTemplateVariableMissing)
+		s := setupTestServer(t, service)
+
+		body := `{"template": "folder-editor"}`
+		req := webtest.RequestWithSignedInUser(s.NewPostRequest("/api/access-control/roles/custom/from-template", strings.NewReader(body)), signedInUser)
+		resp, err := s.SendJSON(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("returns 404 for an unknown role", func(t *testing.T) {
+		service := acmocks.NewService(t)
+		service.On("ApplyPermissionTemplate", mock.Anything, mock.Anything).Return(nil, ac.ErrRoleNotFound)
+		s := setupTestServer(t, service)
+
+		body := `{"template": "folder-editor", "vars": {"ResourceUID": "general"}}`
+		req := webtest.RequestWithSignedInUser(s.NewPostRequest("/api/access-control/roles/unknown/from-template", strings.NewReader(body)), signedInUser)
+		resp, err := s.SendJSON(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestAccessControlAPI_roleVersionHistory(t *testing.T) {
+	signedInUser := &user.SignedInUser{OrgID: 1, UserID: 2, OrgRole: org.RoleAdmin}
+
+	t.Run("returns the versions of the role", func(t *testing.T) {
+		versions := []*ac.RoleVersion{{Version: 2, UpdatedBy: 2, Permissions: []ac.Permission{{Action: "folders:read", Scope: "folders:*"}}}}
+		service := acmocks.NewService(t)
+		service.On("GetRoleVersionHistory", mock.Anything, int64(1), "custom").Return(versions, nil)
+		s := setupTestServer(t, service)
+
+		req := webtest.RequestWithSignedInUser(s.NewGetRequest("/api/access-control/roles/custom/history"), signedInUser)
+		resp, err := s.Send(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result []*ac.RoleVersion
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		require.NoError(t, resp.Body.Close())
+		require.Len(t, result, 1)
+		require.EqualValues(t, 2, result[0].Version)
+		require.Equal(t, "folders:read", result[0].Permissions[0].Action)
+	})
+
+	t.Run("returns 404 for an unknown role", func(t *testing.T) {
+		service := acmocks.NewService(t)
+		service.On("GetRoleVersionHistory", mock.Anything, int64(1), "unknown").Return(nil, ac.ErrRoleNotFound)
+		s := setupTestServer(t, service)
+
+		req := webtest.RequestWithSignedInUser(s.NewGetRequest("/api/access-control/roles/unknown/history"), signedInUser)
+		resp, err := s.Send(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestAccessControlAPI_listResourcePermissions(t *testing.T) {
+	t.Run("lists who has access to the resource", func(t *testing.T) {
+		permissions := []ac.ResourcePermission{{UserId: 3, RoleName: "custom:role", Scope: "dashboards:*", Actions: []string{"dashboards:read"}}}
+		service := acmocks.NewService(t)
+		service.On("ListPermissionsForResource", mock.Anything, int64(1), "dashboards", "abc").Return(permissions, nil)
+		s := setupTestServer(t, service)
+
+		signedInUser := &user.SignedInUser{OrgID: 1, UserID: 2, Permissions: map[int64]map[string][]string{
+			1: {ac.ActionUsersPermissionsRead: {ac.ScopeGlobalUsersAll}},
+		}}
+		req := webtest.RequestWithSignedInUser(s.NewGetRequest("/api/access-control/resources/dashboards/abc/permissions"), signedInUser)
+		resp, err := s.Send(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result []ac.ResourcePermission
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, permissions, result)
+	})
+
+	t.Run("requires permission to read user permissions", func(t *testing.T) {
+		s := setupTestServer(t, acmocks.NewService(t))
+
+		signedInUser := &user.SignedInUser{OrgID: 1, UserID: 2, OrgRole: org.RoleAdmin}
+		req := webtest.RequestWithSignedInUser(s.NewGetRequest("/api/access-control/resources/dashboards/abc/permissions"), signedInUser)
+		resp, err := s.Send(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
+
+func TestAccessControlAPI_serviceAccountRoles(t *testing.T) {
+	signedInUser := &user.SignedInUser{OrgID: 1, UserID: 2, OrgRole: org.RoleAdmin, Permissions: map[int64]map[string][]string{
+		1: {
+			serviceaccounts.ActionPermissionsRead:  {serviceaccounts.ScopeAll},
+			serviceaccounts.ActionPermissionsWrite: {serviceaccounts.ScopeAll},
+		},
+	}}
+
+	t.Run("returns the permissions of the service account", func(t *testing.T) {
+		service := acmocks.NewService(t)
+		service.On("GetServiceAccountPermissions", mock.Anything, int64(1), int64(5)).
+			Return([]ac.Permission{{Action: "dashboards:read", Scope: "dashboards:*"}}, nil)
+		s := setupTestServer(t, service)
+
+		req := webtest.RequestWithSignedInUser(s.NewGetRequest("/api/access-control/serviceaccounts/5/permissions"), signedInUser)
+		resp, err := s.Send(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result []ac.Permission
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		require.NoError(t, resp.Body.Close())
+		require.Len(t, result, 1)
+		require.Equal(t, "dashboards:read", result[0].Action)
+	})
+
+	t.Run("grants a role to the service account", func(t *testing.T) {
+		service := acmocks.NewService(t)
+		service.On("GrantServiceAccountRole", mock.Anything, signedInUser, &ac.ServiceAccountRoleCommand{OrgID: 1, ServiceAccountID: 5, RoleUID: "custom"}).Return(nil)
+		s := setupTestServer(t, service)
+
+		req := webtest.RequestWithSignedInUser(s.NewPostRequest("/api/access-control/serviceaccounts/5/roles", strings.NewReader(`{"roleUid": "custom"}`)), signedInUser)
+		resp, err := s.SendJSON(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("returns 404 for an unknown service account", func(t *testing.T) {
+		service := acmocks.NewService(t)
+		service.On("RevokeServiceAccountRole", mock.Anything, &ac.ServiceAccountRoleCommand{OrgID: 1, ServiceAccountID: 7, RoleUID: "custom"}).
+			Return(ac.ErrServiceAccountNotFound)
+		s := setupTestServer(t, service)
+
+		req := webtest.RequestWithSignedInUser(s.NewRequest(http.MethodDelete, "/api/access-control/serviceaccounts/7/roles/custom", nil), signedInUser)
+		resp, err := s.Send(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("returns 403 when the role has permissions the granter does not have", func(t *testing.T) {
+		service := acmocks.NewService(t)
+		service.On("GrantServiceAccountRole", mock.Anything, signedInUser, &ac.ServiceAccountRoleCommand{OrgID: 1, ServiceAccountID: 5, RoleUID: "fixed:admin"}).
+			Return(ac.ErrPermissionEscalation)
+		s := setupTestServer(t, service)
+
+		req := webtest.RequestWithSignedInUser(s.NewPostRequest("/api/access-control/serviceaccounts/5/roles", strings.NewReader(`{"roleUid": "fixed:admin"}`)), signedInUser)
+		resp, err := s.SendJSON(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("requires the permission to write the permissions of the service account", func(t *testing.T) {
+		s := setupTestServer(t, acmocks.NewService(t))
+
+		admin := &user.SignedInUser{OrgID: 1, UserID: 3, OrgRole: org.RoleAdmin, Permissions: map[int64]map[string][]string{
+			1: {serviceaccounts.ActionPermissionsWrite: {"serviceaccounts:id:6"}},
+		}}
+		req := webtest.RequestWithSignedInUser(s.NewPostRequest("/api/access-control/serviceaccounts/5/roles", strings.NewReader(`{"roleUid": "custom"}`)), admin)
+		resp, err := s.SendJSON(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
@@ -0,0 +1,105 @@
+package accesscontrol
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/grafana/grafana/pkg/services/user"
+)
+
+var _ Service = new(BatchingPermissionService)
+
+// BatchingPermissionService wraps a Service and coalesces concurrent
+// GetUserPermissions calls for the same user and options. Every call made
+// while the permissions are loaded receives the same result. If another call
+// for the same user and options was made within window, more are likely to
+// follow and the load waits for window first so they can join. Calls served
+// that way increment the coalesced counter.
+type BatchingPermissionService struct {
+	Service
+	window    time.Duration
+	group     singleflight.Group
+	coalesced prometheus.Counter
+
+	mu sync.Mutex
+	// recent counts the calls made within window, by batching key.
+	recent map[string]int
+}
+
+func NewBatchingPermissionService(service Service, window time.Duration, coalesced prometheus.Counter) *BatchingPermissionService {
+	return &BatchingPermissionService{Service: service, window: window, coalesced: coalesced, recent: map[string]int{}}
+}
+
+func (s *BatchingPermissionService) GetUserPermissions(ctx context.Context, user *user.SignedInUser, options Options) ([]Permission, error) {
+	// A reload must reach the wrapped service.
+	if options.ReloadCache {
+		return s.Service.GetUserPermissions(ctx, user, options)
+	}
+
+	key := batchingKey(user, options)
+	contended := s.enter(key)
+	defer s.leave(key)
+
+	loaded := false
+	ch := s.group.DoChan(key, func() (interface{}, error) {
+		loaded = true
+		if contended {
+			time.Sleep(s.window)
+		}
+		// the load is shared by every caller, so it must not be canceled with
+		// the context of the caller that started it
+		return s.Service.GetUserPermissions(detachedContext{ctx}, user, options)
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if !loaded {
+			s.coalesced.Inc()
+		}
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]Permission), nil
+	}
+}
+
+// enter records a call for key, and reports whether another one was made
+// within the window.
+func (s *BatchingPermissionService) enter(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recent[key]++
+	return s.recent[key] > 1
+}
+
+// leave forgets a call for key once the window has passed.
+func (s *BatchingPermissionService) leave(key string) {
+	time.AfterFunc(s.window, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.recent[key]--; s.recent[key] <= 0 {
+			delete(s.recent, key)
+		}
+	})
+}
+
+func batchingKey(user *user.SignedInUser, options Options) string {
+	return fmt.Sprintf("%d-%d-%s-%v-%v-%s-%s", user.OrgID, user.UserID, user.OrgRole, user.IsGrafanaAdmin, user.Teams, options.Filter.ActionPrefix, options.Filter.Scope)
+}
+
+// detachedContext carries the values of a context without its deadline and
+// cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
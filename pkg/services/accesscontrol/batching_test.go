@@ -0,0 +1,127 @@
+package accesscontrol_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/accesscontrol/actest"
+	"github.com/grafana/grafana/pkg/services/user"
+)
+
+type contextService struct {
+	actest.FakeService
+	loaded chan error
+}
+
+func (s *contextService) GetUserPermissions(ctx context.Context, user *user.SignedInUser, options accesscontrol.Options) ([]accesscontrol.Permission, error) {
+	s.loaded <- ctx.Err()
+	return s.FakeService.GetUserPermissions(ctx, user, options)
+}
+
+type countingService struct {
+	actest.FakeService
+	calls int64
+}
+
+func (s *countingService) GetUserPermissions(ctx context.Context, user *user.SignedInUser, options accesscontrol.Options) ([]accesscontrol.Permission, error) {
+	atomic.AddInt64(&s.calls, 1)
+	return s.FakeService.GetUserPermissions(ctx, user, options)
+}
+
+func TestBatchingPermissionService(t *testing.T) {
+	permissions := []accesscontrol.Permission{{Action: "dashboards:read", Scope: "dashboards:*"}}
+	signedInUser := &user.SignedInUser{OrgID: 1, UserID: 1}
+
+	t.Run("coalesces concurrent calls for the same user", func(t *testing.T) {
+		fake := &countingService{FakeService: actest.FakeService{ExpectedPermissions: permissions}}
+		coalesced := prometheus.NewCounter(prometheus.CounterOpts{Name: "coalesced"})
+		s := accesscontrol.NewBatchingPermissionService(fake, 5*time.Millisecond, coalesced)
+
+		const callers = 20
+		start := make(chan struct{})
+		results := make([][]accesscontrol.Permission, callers)
+		var wg sync.WaitGroup
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				res, err := s.GetUserPermissions(context.Background(), signedInUser, accesscontrol.Options{})
+				assert.NoError(t, err)
+				results[i] = res
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+
+		calls := atomic.LoadInt64(&fake.calls)
+		assert.LessOrEqual(t, calls, int64(2))
+		assert.Equal(t, float64(callers-calls), testutil.ToFloat64(coalesced))
+		for _, res := range results {
+			assert.Equal(t, permissions, res)
+		}
+	})
+
+	t.Run("does not coalesce calls for different users", func(t *testing.T) {
+		fake := &countingService{FakeService: actest.FakeService{ExpectedPermissions: permissions}}
+		s := accesscontrol.NewBatchingPermissionService(fake, 50*time.Millisecond, prometheus.NewCounter(prometheus.CounterOpts{Name: "coalesced"}))
+
+		var wg sync.WaitGroup
+		for _, id := range []int64{1, 2} {
+			wg.Add(1)
+			go func(id int64) {
+				defer wg.Done()
+				_, err := s.GetUserPermissions(context.Background(), &user.SignedInUser{OrgID: 1, UserID: id}, accesscontrol.Options{})
+				assert.NoError(t, err)
+			}(id)
+		}
+		wg.Wait()
+		require.Equal(t, int64(2), atomic.LoadInt64(&fake.calls))
+	})
+
+	t.Run("does not wait for the window without concurrent calls", func(t *testing.T) {
+		fake := &countingService{FakeService: actest.FakeService{ExpectedPermissions: permissions}}
+		s := accesscontrol.NewBatchingPermissionService(fake, time.Hour, prometheus.NewCounter(prometheus.CounterOpts{Name: "coalesced"}))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			res, err := s.GetUserPermissions(context.Background(), signedInUser, accesscontrol.Options{})
+			assert.NoError(t, err)
+			assert.Equal(t, permissions, res)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("uncontended call waited for the window")
+		}
+	})
+
+	t.Run("loads with a context that is not canceled with the caller's", func(t *testing.T) {
+		fake := &contextService{FakeService: actest.FakeService{ExpectedPermissions: permissions}, loaded: make(chan error, 1)}
+		s := accesscontrol.NewBatchingPermissionService(fake, time.Millisecond, prometheus.NewCounter(prometheus.CounterOpts{Name: "coalesced"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, _ = s.GetUserPermissions(ctx, signedInUser, accesscontrol.Options{})
+		assert.NoError(t, <-fake.loaded)
+	})
+
+	t.Run("reloads bypass coalescing", func(t *testing.T) {
+		fake := &countingService{FakeService: actest.FakeService{ExpectedPermissions: permissions}}
+		s := accesscontrol.NewBatchingPermissionService(fake, time.Hour, prometheus.NewCounter(prometheus.CounterOpts{Name: "coalesced"}))
+
+		_, err := s.GetUserPermissions(context.Background(), signedInUser, accesscontrol.Options{ReloadCache: true})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), atomic.LoadInt64(&fake.calls))
+	})
+}
@@ -0,0 +1,95 @@
+package accesscontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker"
+
+	"github.com/grafana/grafana/pkg/infra/localcache"
+	"github.com/grafana/grafana/pkg/infra/log"
+)
+
+// PermissionsStore loads the permissions of a user from storage.
+type PermissionsStore interface {
+	GetUserPermissions(ctx context.Context, query GetUserPermissionsQuery) ([]Permission, error)
+}
+
+// CircuitBreakerStore wraps a PermissionsStore with a circuit breaker. After
+// maxFailures consecutive failures the breaker opens and, for the duration of
+// timeout, queries are answered from the last successful result for the same
+// query instead of reaching the store. Results are only kept for maxAge, so
+// queries without a result loaded within maxAge fail while the breaker is
+// open. The state of the breaker is reported to the state gauge.
+type CircuitBreakerStore struct {
+	store   PermissionsStore
+	breaker *gobreaker.CircuitBreaker
+	log     log.Logger
+	// last holds the last successful result of each query for maxAge, it is
+	// nil if results are not kept.
+	last *localcache.CacheService
+}
+
+func NewCircuitBreakerStore(store PermissionsStore, maxFailures uint32, timeout, maxAge time.Duration, state prometheus.Gauge) *CircuitBreakerStore {
+	s := &CircuitBreakerStore{
+		store: store,
+		log:   log.New("accesscontrol.circuitbreaker"),
+	}
+	if maxAge > 0 {
+		s.last = localcache.New(maxAge, maxAge)
+	}
+	s.breaker = gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:    "accesscontrol.permissions",
+		Timeout: timeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= maxFailures
+		},
+		// A canceled request says nothing about the health of the store.
+		IsSuccessful: func(err error) bool {
+			return err == nil || errors.Is(err, context.Canceled)
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			s.log.Warn("Permissions circuit breaker changed state", "from", from.String(), "to", to.String())
+			state.Set(float64(to))
+		},
+	})
+	state.Set(float64(gobreaker.StateClosed))
+	return s
+}
+
+func (s *CircuitBreakerStore) GetUserPermissions(ctx context.Context, query GetUserPermissionsQuery) ([]Permission, error) {
+	key := circuitBreakerKey(query)
+	res, err := s.breaker.Execute(func() (interface{}, error) {
+		return s.store.GetUserPermissions(ctx, query)
+	})
+
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		if s.last != nil {
+			if permissions, ok := s.last.Get(key); ok {
+				return permissions.([]Permission), nil
+			}
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := res.([]Permission)
+	if s.last != nil {
+		s.last.SetDefault(key, permissions)
+	}
+	return permissions, nil
+}
+
+// State returns the current state of the circuit breaker.
+func (s *CircuitBreakerStore) State() gobreaker.State {
+	return s.breaker.State()
+}
+
+func circuitBreakerKey(query GetUserPermissionsQuery) string {
+	return fmt.Sprintf("%d-%d-%v-%v-%s-%s-%v", query.OrgID, query.UserID, query.Roles, query.Actions, query.ActionPrefix, query.Scope, query.TeamIDs)
+}
@@ -0,0 +1,92 @@
+package accesscontrol
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePermissionsStore struct {
+	permissions []Permission
+	err         error
+	calls       int
+}
+
+func (f *fakePermissionsStore) GetUserPermissions(ctx context.Context, query GetUserPermissionsQuery) ([]Permission, error) {
+	f.calls++
+	return f.permissions, f.err
+}
+
+func TestCircuitBreakerStore(t *testing.T) {
+	query := GetUserPermissionsQuery{OrgID: 1, UserID: 1}
+	permissions := []Permission{{Action: "dashboards:read", Scope: "dashboards:*"}}
+
+	t.Run("serves last result while open", func(t *testing.T) {
+		fake := &fakePermissionsStore{permissions: permissions}
+		state := prometheus.NewGauge(prometheus.GaugeOpts{Name: "state"})
+		s := NewCircuitBreakerStore(fake, 3, time.Hour, time.Hour, state)
+
+		res, err := s.GetUserPermissions(context.Background(), query)
+		require.NoError(t, err)
+		assert.Equal(t, permissions, res)
+
+		fake.err = errors.New("database is down")
+		for i := 0; i < 3; i++ {
+			_, err := s.GetUserPermissions(context.Background(), query)
+			require.Error(t, err)
+		}
+		require.Equal(t, gobreaker.StateOpen, s.State())
+		assert.Equal(t, float64(gobreaker.StateOpen), testutil.ToFloat64(state))
+
+		res, err = s.GetUserPermissions(context.Background(), query)
+		require.NoError(t, err)
+		assert.Equal(t, permissions, res)
+		assert.Equal(t, 4, fake.calls)
+
+		_, err = s.GetUserPermissions(context.Background(), GetUserPermissionsQuery{OrgID: 1, UserID: 2})
+		assert.ErrorIs(t, err, gobreaker.ErrOpenState)
+		assert.Equal(t, 4, fake.calls)
+	})
+
+	t.Run("fails once the last result is older than the max age", func(t *testing.T) {
+		fake := &fakePermissionsStore{permissions: permissions}
+		s := NewCircuitBreakerStore(fake, 1, time.Hour, time.Millisecond, prometheus.NewGauge(prometheus.GaugeOpts{Name: "state"}))
+
+		_, err := s.GetUserPermissions(context.Background(), query)
+		require.NoError(t, err)
+		fake.err = errors.New("database is down")
+		_, err = s.GetUserPermissions(context.Background(), query)
+		require.Error(t, err)
+		require.Equal(t, gobreaker.StateOpen, s.State())
+
+		time.Sleep(5 * time.Millisecond)
+		_, err = s.GetUserPermissions(context.Background(), query)
+		assert.ErrorIs(t, err, gobreaker.ErrOpenState)
+	})
+
+	t.Run("closes again once the store recovers", func(t *testing.T) {
+		fake := &fakePermissionsStore{err: errors.New("database is down")}
+		state := prometheus.NewGauge(prometheus.GaugeOpts{Name: "state"})
+		s := NewCircuitBreakerStore(fake, 1, time.Millisecond, time.Hour, state)
+
+		_, err := s.GetUserPermissions(context.Background(), query)
+		require.Error(t, err)
+		require.Equal(t, gobreaker.StateOpen, s.State())
+
+		time.Sleep(5 * time.Millisecond)
+		fake.err = nil
+		fake.permissions = permissions
+		res, err := s.GetUserPermissions(context.Background(), query)
+		require.NoError(t, err)
+		assert.Equal(t, permissions, res)
+		assert.Equal(t, gobreaker.StateClosed, s.State())
+		assert.Equal(t, float64(gobreaker.StateClosed), testutil.ToFloat64(state))
+	})
+}
@@ -0,0 +1,111 @@
+package accesscontrol
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/contexthandler/ctxkey"
+)
+
+const (
+	// ConditionIPCIDR restricts a permission to requests coming from one of a comma
+	// separated list of CIDR ranges, e.g. "10.0.0.0/8,192.168.1.0/24".
+	ConditionIPCIDR = "ip_cidr"
+	// ConditionTimeRange restricts a permission to a daily time window in UTC, e.g.
+	// "09:00-17:00". A window ending before it starts wraps around midnight.
+	ConditionTimeRange = "time_range"
+)
+
+// ConditionEvaluator decides whether the conditions of a permission hold for the
+// request in ctx.
+type ConditionEvaluator interface {
+	Evaluate(ctx context.Context, conds map[string]string) bool
+}
+
+// ConditionEvaluatorFunc is an adapter to allow functions to implement ConditionEvaluator interface
+type ConditionEvaluatorFunc func(ctx context.Context, conds map[string]string) bool
+
+func (f ConditionEvaluatorFunc) Evaluate(ctx context.Context, conds map[string]string) bool {
+	return f(ctx, conds)
+}
+
+// NewConditionEvaluator returns a ConditionEvaluator supporting ConditionIPCIDR and
+// ConditionTimeRange. All conditions must hold; unknown or malformed conditions never do.
+func NewConditionEvaluator(now func() time.Time) ConditionEvaluator {
+	return &conditionEvaluator{now: now}
+}
+
+type conditionEvaluator struct {
+	now func() time.Time
+}
+
+func (e *conditionEvaluator) Evaluate(ctx context.Context, conds map[string]string) bool {
+	for key, value := range conds {
+		var ok bool
+		switch key {
+		case ConditionIPCIDR:
+			ok = ipInRanges(requestIP(ctx), value)
+		case ConditionTimeRange:
+			ok = timeInRange(e.now().UTC(), value)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIP returns the remote IP of the HTTP request in ctx, or nil if there is none.
+func requestIP(ctx context.Context) net.IP {
+	c, ok := ctxkey.Get(ctx).(*models.ReqContext)
+	if !ok || c.Context == nil || c.Req == nil {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(c.Req.RemoteAddr)
+	if err != nil {
+		host = c.Req.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func ipInRanges(ip net.IP, ranges string) bool {
+	if ip == nil {
+		return false
+	}
+	for _, r := range strings.Split(ranges, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(r))
+		if err != nil {
+			return false
+		}
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func timeInRange(t time.Time, window string) bool {
+	parts := strings.Split(window, "-")
+	if len(parts) != 2 {
+		return false
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(parts[0]))
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(parts[1]))
+	if err != nil {
+		return false
+	}
+
+	minute := func(t time.Time) int { return t.Hour()*60 + t.Minute() }
+	now, from, to := minute(t), minute(start), minute(end)
+	if from <= to {
+		return now >= from && now < to
+	}
+	return now >= from || now < to
+}
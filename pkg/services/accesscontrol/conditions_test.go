@@ -0,0 +1,91 @@
+package accesscontrol
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/contexthandler/ctxkey"
+	"github.com/grafana/grafana/pkg/web"
+)
+
+func TestConditionEvaluator(t *testing.T) {
+	noon := func() time.Time { return time.Date(2022, 10, 3, 12, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		desc       string
+		remoteAddr string
+		conds      map[string]string
+		expected   bool
+	}{
+		{
+			desc:     "no conditions always match",
+			conds:    nil,
+			expected: true,
+		},
+		{
+			desc:       "ip in range",
+			remoteAddr: "10.1.2.3:4567",
+			conds:      map[string]string{ConditionIPCIDR: "192.168.0.0/16, 10.0.0.0/8"},
+			expected:   true,
+		},
+		{
+			desc:       "ip out of range",
+			remoteAddr: "172.16.0.1:4567",
+			conds:      map[string]string{ConditionIPCIDR: "192.168.0.0/16,10.0.0.0/8"},
+			expected:   false,
+		},
+		{
+			desc:     "ip condition without request",
+			conds:    map[string]string{ConditionIPCIDR: "0.0.0.0/0"},
+			expected: false,
+		},
+		{
+			desc:     "time inside window",
+			conds:    map[string]string{ConditionTimeRange: "09:00-17:00"},
+			expected: true,
+		},
+		{
+			desc:     "time outside window",
+			conds:    map[string]string{ConditionTimeRange: "13:00-17:00"},
+			expected: false,
+		},
+		{
+			desc:     "time inside window wrapping around midnight",
+			conds:    map[string]string{ConditionTimeRange: "22:00-12:30"},
+			expected: true,
+		},
+		{
+			desc:       "all conditions must hold",
+			remoteAddr: "10.1.2.3:4567",
+			conds:      map[string]string{ConditionIPCIDR: "10.0.0.0/8", ConditionTimeRange: "13:00-17:00"},
+			expected:   false,
+		},
+		{
+			desc:     "malformed time window",
+			conds:    map[string]string{ConditionTimeRange: "9-5"},
+			expected: false,
+		},
+		{
+			desc:     "unknown condition",
+			conds:    map[string]string{"weekday": "monday"},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.remoteAddr != "" {
+				req := &http.Request{RemoteAddr: tt.remoteAddr}
+				ctx = ctxkey.Set(ctx, &models.ReqContext{Context: &web.Context{Req: req}})
+			}
+
+			assert.Equal(t, tt.expected, NewConditionEvaluator(noon).Evaluate(ctx, tt.conds))
+		})
+	}
+}
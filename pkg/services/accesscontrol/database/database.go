@@ -2,8 +2,10 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/grafana/grafana/pkg/infra/db"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
@@ -30,31 +32,128 @@ func (s *AccessControlStore) GetUserPermissions(ctx context.Context, query acces
 		q := `
 		SELECT
 			permission.action,
-			permission.scope
+			permission.scope,
+			permission.conditions
 			FROM permission
 			INNER JOIN role ON role.id = permission.role_id
 		` + filter
 
+		var where []string
+		var whereParams []interface{}
 		if len(query.Actions) > 0 {
-			q += " WHERE permission.action IN("
-			if len(query.Actions) > 0 {
-				q += "?" + strings.Repeat(",?", len(query.Actions)-1)
-			}
-			q += ")"
+			where = append(where, "permission.action IN(?"+strings.Repeat(",?", len(query.Actions)-1)+")")
 			for _, a := range query.Actions {
-				params = append(params, a)
+				whereParams = append(whereParams, a)
 			}
 		}
-		if err := sess.SQL(q, params...).Find(&result); err != nil {
+		if query.ActionPrefix != "" {
+			where = append(where, "permission.action LIKE ? ESCAPE '"+likeEscape+"'")
+			whereParams = append(whereParams, likePrefix(query.ActionPrefix))
+		}
+		if query.Scope != "" {
+			where = append(where, "permission.scope = ?")
+			whereParams = append(whereParams, query.Scope)
+		}
+		if len(where) > 0 {
+			q += " WHERE " + strings.Join(where, " AND ")
+			params = append(params, whereParams...)
+		}
+
+		// temporary permissions granted to the user that have not expired yet
+		if query.UserID > 0 {
+			q += `
+		UNION ALL
+		SELECT
+			permission.action,
+			permission.scope,
+			'' AS conditions
+			FROM access_control_temporary_permissions permission
+			WHERE permission.org_id = ? AND permission.user_id = ? AND permission.expires > ?
+		`
+			params = append(params, query.OrgID, query.UserID, time.Now().Unix())
+			if len(where) > 0 {
+				q += " AND " + strings.Join(where, " AND ")
+				params = append(params, whereParams...)
+			}
+		}
+
+		var rows []permissionRow
+		if err := sess.SQL(q, params...).Find(&rows); err != nil {
 			return err
 		}
 
+		for _, row := range rows {
+			p := accesscontrol.Permission{Action: row.Action, Scope: row.Scope}
+			if row.Conditions != "" {
+				if err := json.Unmarshal([]byte(row.Conditions), &p.Conditions); err != nil {
+					return err
+				}
+			}
+			result = append(result, p)
+		}
+
 		return nil
 	})
 
 	return result, err
 }
 
+// GetUserRolePermissions returns the permissions without conditions of the
+// user along with the names of the roles granting them. Temporary permissions
+// are returned under accesscontrol.TemporaryPermissionRoleName.
+func (s *AccessControlStore) GetUserRolePermissions(ctx context.Context, query accesscontrol.GetUserPermissionsQuery) ([]accesscontrol.RolePermission, error) {
+	result := make([]accesscontrol.RolePermission, 0)
+	err := s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		if query.UserID == 0 && len(query.TeamIDs) == 0 && len(query.Roles) == 0 {
+			return nil
+		}
+
+		filter, params := accesscontrol.UserRolesFilter(query.OrgID, query.UserID, query.TeamIDs, query.Roles)
+		var actionFilter string
+		var actionParams []interface{}
+		if len(query.Actions) > 0 {
+			actionFilter = " AND permission.action IN(?" + strings.Repeat(",?", len(query.Actions)-1) + ")"
+			for _, a := range query.Actions {
+				actionParams = append(actionParams, a)
+			}
+		}
+
+		q := `
+		SELECT
+			role.name AS role_name,
+			permission.action,
+			permission.scope
+			FROM permission
+			INNER JOIN role ON role.id = permission.role_id
+		` + filter + `
+			WHERE (permission.conditions IS NULL OR permission.conditions = '')` + actionFilter
+		params = append(params, actionParams...)
+
+		if query.UserID > 0 {
+			q += `
+		UNION ALL
+		SELECT
+			'` + accesscontrol.TemporaryPermissionRoleName + `' AS role_name,
+			permission.action,
+			permission.scope
+			FROM access_control_temporary_permissions permission
+			WHERE permission.org_id = ? AND permission.user_id = ? AND permission.expires > ?` + actionFilter
+			params = append(params, query.OrgID, query.UserID, time.Now().Unix())
+			params = append(params, actionParams...)
+		}
+
+		return sess.SQL(q+" ORDER BY role_name, action, scope", params...).Find(&result)
+	})
+
+	return result, err
+}
+
+type permissionRow struct {
+	Action     string `xorm:"action"`
+	Scope      string `xorm:"scope"`
+	Conditions string `xorm:"conditions"`
+}
+
 func (s *AccessControlStore) DeleteUserPermissions(ctx context.Context, orgID, userID int64) error {
 	err := s.sql.WithDbSession(ctx, func(sess *db.Session) error {
 		roleDeleteQuery := "DELETE FROM user_role WHERE user_id = ?"
@@ -119,3 +218,192 @@ func (s *AccessControlStore) DeleteUserPermissions(ctx context.Context, orgID, u
 	})
 	return err
 }
+
+// RevokeAllUserRoles deletes all role assignments of the user in the org and
+// records an audit entry for each of them, attributed to the actor and to the
+// impersonating admin carried by ctx, if any.
+func (s *AccessControlStore) RevokeAllUserRoles(ctx context.Context, orgID, userID, actorID int64) (int, error) {
+	revoked := 0
+	err := s.sql.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		type assignment struct {
+			RoleID  int64  `xorm:"role_id"`
+			RoleUID string `xorm:"uid"`
+		}
+		var assignments []assignment
+		if err := sess.SQL(
+			"SELECT ur.role_id, role.uid FROM user_role AS ur INNER JOIN role ON role.id = ur.role_id WHERE ur.org_id = ? AND ur.user_id = ?",
+			orgID, userID,
+		).Find(&assignments); err != nil {
+			return err
+		}
+
+		if len(assignments) == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		impersonatedBy := accesscontrol.ImpersonatorFromContext(ctx)
+		records := make([]*accesscontrol.RoleAssignmentAudit, 0, len(assignments))
+		for _, a := range assignments {
+			records = append(records, &accesscontrol.RoleAssignmentAudit{
+				OrgID:          orgID,
+				UserID:         userID,
+				RoleID:         a.RoleID,
+				RoleUID:        a.RoleUID,
+				Action:         accesscontrol.RoleAssignmentAuditActionRevoke,
+				Created:        now,
+				ActorID:        actorID,
+				ImpersonatedBy: impersonatedBy,
+			})
+		}
+		if _, err := sess.InsertMulti(&records); err != nil {
+			return err
+		}
+
+		if _, err := sess.Exec("DELETE FROM user_role WHERE org_id = ? AND user_id = ?", orgID, userID); err != nil {
+			return err
+		}
+
+		revoked = len(assignments)
+		return nil
+	})
+	return revoked, err
+}
+
+// CopyUserRoles assigns every role of the source user in the org to the target user.
+// If the target has role assignments the source does not have, ErrPermissionConflict
+// is returned unless cmd.Overwrite is set, in which case those assignments are removed.
+func (s *AccessControlStore) CopyUserRoles(ctx context.Context, cmd *accesscontrol.CopyPermissionsCommand) error {
+	return s.sql.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		var sourceRoles []int64
+		if err := sess.Table("user_role").Where("org_id = ? AND user_id = ?", cmd.OrgID, cmd.SourceUserID).Cols("role_id").Find(&sourceRoles); err != nil {
+			return err
+		}
+		var targetRoles []int64
+		if err := sess.Table("user_role").Where("org_id = ? AND user_id = ?", cmd.OrgID, cmd.TargetUserID).Cols("role_id").Find(&targetRoles); err != nil {
+			return err
+		}
+
+		source := make(map[int64]bool, len(sourceRoles))
+		for _, id := range sourceRoles {
+			source[id] = true
+		}
+		target := make(map[int64]bool, len(targetRoles))
+		for _, id := range targetRoles {
+			target[id] = true
+		}
+
+		var conflicting []int64
+		for _, id := range targetRoles {
+			if !source[id] {
+				conflicting = append(conflicting, id)
+			}
+		}
+		if len(conflicting) > 0 {
+			if !cmd.Overwrite {
+				return accesscontrol.ErrPermissionConflict
+			}
+			if _, err := sess.Table("user_role").Where("org_id = ? AND user_id = ?", cmd.OrgID, cmd.TargetUserID).
+				In("role_id", conflicting).Delete(&accesscontrol.UserRole{}); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		assignments := make([]*accesscontrol.UserRole, 0, len(sourceRoles))
+		for _, id := range sourceRoles {
+			if target[id] {
+				continue
+			}
+			assignments = append(assignments, &accesscontrol.UserRole{
+				OrgID:   cmd.OrgID,
+				RoleID:  id,
+				UserID:  cmd.TargetUserID,
+				Created: now,
+			})
+		}
+		if len(assignments) == 0 {
+			return nil
+		}
+		_, err := sess.InsertMulti(&assignments)
+		return err
+	})
+}
+
+// AddRolePermissions adds the permissions the role of the org with the given
+// UID does not have yet. If any was added, the version of the role is bumped
+// and the resulting permissions are recorded in its version history.
+func (s *AccessControlStore) AddRolePermissions(ctx context.Context, orgID int64, roleUID string, permissions []accesscontrol.Permission, updatedBy int64) error {
+	return s.sql.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		var role accesscontrol.Role
+		has, err := sess.Where("org_id = ? AND uid = ?", orgID, roleUID).Get(&role)
+		if err != nil {
+			return err
+		} else if !has {
+			return accesscontrol.ErrRoleNotFound
+		}
+
+		var existing []permissionRow
+		if err := sess.Table("permission").Where("role_id = ?", role.ID).Cols("action", "scope").Find(&existing); err != nil {
+			return err
+		}
+		type actionScope struct{ action, scope string }
+		held := make(map[actionScope]bool, len(existing))
+		for _, p := range existing {
+			held[actionScope{p.Action, p.Scope}] = true
+		}
+
+		now := time.Now()
+		added := make([]*accesscontrol.Permission, 0, len(permissions))
+		for _, p := range permissions {
+			key := actionScope{p.Action, p.Scope}
+			if held[key] {
+				continue
+			}
+			held[key] = true
+			added = append(added, &accesscontrol.Permission{RoleID: role.ID, Action: p.Action, Scope: p.Scope, Created: now, Updated: now})
+		}
+		if len(added) == 0 {
+			return nil
+		}
+
+		if _, err := sess.InsertMulti(&added); err != nil {
+			return err
+		}
+		if _, err := sess.Exec("UPDATE role SET version = version + 1, updated = ?, updated_by = ? WHERE id = ?", now, updatedBy, role.ID); err != nil {
+			return err
+		}
+
+		snapshot := make([]accesscontrol.Permission, 0, len(existing)+len(added))
+		for _, p := range existing {
+			snapshot = append(snapshot, accesscontrol.Permission{Action: p.Action, Scope: p.Scope})
+		}
+		for _, p := range added {
+			snapshot = append(snapshot, accesscontrol.Permission{Action: p.Action, Scope: p.Scope})
+		}
+		var updated accesscontrol.Role
+		if _, err := sess.ID(role.ID).Get(&updated); err != nil {
+			return err
+		}
+		return recordRoleVersion(sess, &updated, snapshot, updatedBy, now)
+	})
+}
+
+// GetRoleAssignmentAudit returns the audit records of role assignment changes of the user in the org.
+func (s *AccessControlStore) GetRoleAssignmentAudit(ctx context.Context, orgID, userID int64) ([]*accesscontrol.RoleAssignmentAudit, error) {
+	result := make([]*accesscontrol.RoleAssignmentAudit, 0)
+	err := s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		return sess.Where("org_id = ? AND user_id = ?", orgID, userID).Asc("id").Find(&result)
+	})
+	return result, err
+}
+
+// likeEscape is the escape character of the LIKE patterns built by likePrefix.
+// It is not a backslash, since database engines disagree on how to quote one.
+const likeEscape = "!"
+
+// likePrefix returns a LIKE pattern matching the strings that start with
+// prefix, with the wildcards in prefix escaped.
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(likeEscape, likeEscape+likeEscape, "%", likeEscape+"%", "_", likeEscape+"_").Replace(prefix) + "%"
+}
@@ -2,7 +2,9 @@ package database
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -27,6 +29,8 @@ type getUserPermissionsTestCase struct {
 	teamPermissions    []string
 	builtinPermissions []string
 	actions            []string
+	actionPrefix       string
+	scope              string
 	expected           int
 }
 
@@ -69,6 +73,47 @@ func TestAccessControlStore_GetUserPermissions(t *testing.T) {
 			expected:           3,
 			actions:            []string{"dashboards:write"},
 		},
+		{
+			desc:               "Should filter on action prefix",
+			orgID:              1,
+			role:               "Admin",
+			userPermissions:    []string{"1", "2", "10"},
+			teamPermissions:    []string{"100", "2"},
+			builtinPermissions: []string{"5", "6"},
+			expected:           4,
+			actionPrefix:       "dashboards:re",
+		},
+		{
+			desc:               "Should match action prefix literally",
+			orgID:              1,
+			role:               "Admin",
+			userPermissions:    []string{"1", "2", "10"},
+			teamPermissions:    []string{"100", "2"},
+			builtinPermissions: []string{"5", "6"},
+			expected:           0,
+			actionPrefix:       "dashboards_%",
+		},
+		{
+			desc:               "Should filter on scope",
+			orgID:              1,
+			role:               "Admin",
+			userPermissions:    []string{"1", "2", "10"},
+			teamPermissions:    []string{"100", "2"},
+			builtinPermissions: []string{"5", "6"},
+			expected:           2,
+			scope:              accesscontrol.Scope("dashboards", "", "2"),
+		},
+		{
+			desc:               "Should filter on action prefix and scope",
+			orgID:              1,
+			role:               "Admin",
+			userPermissions:    []string{"1", "2", "10"},
+			teamPermissions:    []string{"100", "2"},
+			builtinPermissions: []string{"5", "6"},
+			expected:           1,
+			actionPrefix:       "dashboards:write",
+			scope:              accesscontrol.Scope("dashboards", "", "2"),
+		},
 		{
 			desc:               "should only get br permissions for anonymous user",
 			anonymousUser:      true,
@@ -130,11 +175,13 @@ func TestAccessControlStore_GetUserPermissions(t *testing.T) {
 				teamIDs = []int64{}
 			}
 			permissions, err := store.GetUserPermissions(context.Background(), accesscontrol.GetUserPermissionsQuery{
-				OrgID:   tt.orgID,
-				UserID:  userID,
-				Roles:   roles,
-				Actions: tt.actions,
-				TeamIDs: teamIDs,
+				OrgID:        tt.orgID,
+				UserID:       userID,
+				Roles:        roles,
+				Actions:      tt.actions,
+				ActionPrefix: tt.actionPrefix,
+				Scope:        tt.scope,
+				TeamIDs:      teamIDs,
 			})
 
 			require.NoError(t, err)
@@ -143,6 +190,37 @@ func TestAccessControlStore_GetUserPermissions(t *testing.T) {
 	}
 }
 
+func TestAccessControlStore_GetUserRolePermissions(t *testing.T) {
+	store, permissionStore, sql, teamSvc := setupTestEnv(t)
+	user, team := createUserAndTeam(t, sql, teamSvc, 1)
+
+	_, err := permissionStore.SetUserResourcePermission(context.Background(), 1, accesscontrol.User{ID: user.ID}, rs.SetResourcePermissionCommand{
+		Actions:           []string{"dashboards:write"},
+		Resource:          "dashboards",
+		ResourceID:        "1",
+		ResourceAttribute: "uid",
+	}, nil)
+	require.NoError(t, err)
+	_, err = permissionStore.SetTeamResourcePermission(context.Background(), 1, team.Id, rs.SetResourcePermissionCommand{
+		Actions:           []string{"dashboards:read"},
+		Resource:          "dashboards",
+		ResourceID:        "2",
+		ResourceAttribute: "uid",
+	}, nil)
+	require.NoError(t, err)
+
+	permissions, err := store.GetUserRolePermissions(context.Background(), accesscontrol.GetUserPermissionsQuery{
+		OrgID:   1,
+		UserID:  user.ID,
+		TeamIDs: []int64{team.Id},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []accesscontrol.RolePermission{
+		{RoleName: fmt.Sprintf("managed:teams:%d:permissions", team.Id), Action: "dashboards:read", Scope: "dashboards:uid:2"},
+		{RoleName: fmt.Sprintf("managed:users:%d:permissions", user.ID), Action: "dashboards:write", Scope: "dashboards:uid:1"},
+	}, permissions)
+}
+
 func TestAccessControlStore_DeleteUserPermissions(t *testing.T) {
 	t.Run("expect permissions in all orgs to be deleted", func(t *testing.T) {
 		store, permissionsStore, sql, teamSvc := setupTestEnv(t)
@@ -225,6 +303,409 @@ func TestAccessControlStore_DeleteUserPermissions(t *testing.T) {
 	})
 }
 
+func TestAccessControlStore_Snapshots(t *testing.T) {
+	store, _, sql, teamSvc := setupTestEnv(t)
+	usr, team := createUserAndTeam(t, sql, teamSvc, 1)
+
+	users, err := store.GetOrgUsers(context.Background(), 1)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, usr.ID, users[0].UserID)
+	assert.Equal(t, []int64{team.Id}, users[0].Teams)
+
+	snapshot := &accesscontrol.PermissionSnapshot{
+		OrgID:     1,
+		Timestamp: time.Now().Truncate(time.Second),
+		Permissions: map[int64][]accesscontrol.Permission{
+			usr.ID: {{Action: "dashboards:read", Scope: "dashboards:uid:1"}},
+		},
+	}
+	require.NoError(t, store.StoreSnapshot(context.Background(), snapshot))
+	require.NotZero(t, snapshot.ID)
+
+	stored, err := store.GetSnapshot(context.Background(), 1, snapshot.ID)
+	require.NoError(t, err)
+	assert.Equal(t, snapshot.Permissions, stored.Permissions)
+
+	_, err = store.GetSnapshot(context.Background(), 2, snapshot.ID)
+	assert.ErrorIs(t, err, accesscontrol.ErrSnapshotNotFound)
+
+	metas, err := store.ListSnapshots(context.Background(), 1)
+	require.NoError(t, err)
+	require.Len(t, metas, 1)
+	assert.Equal(t, snapshot.ID, metas[0].ID)
+}
+
+func TestAccessControlStore_DenyRules(t *testing.T) {
+	store, _, _, _ := setupTestEnv(t)
+	ctx := context.Background()
+
+	cmd := &accesscontrol.DenyCommand{OrgID: 1, UserID: 2, Action: "dashboards:read", Scope: "dashboards:uid:1"}
+	require.NoError(t, store.AddDenyRule(ctx, cmd))
+	require.NoError(t, store.AddDenyRule(ctx, cmd))
+	require.NoError(t, store.AddDenyRule(ctx, &accesscontrol.DenyCommand{OrgID: 2, UserID: 2, Action: "dashboards:read"}))
+
+	rules, err := store.ListDenyRules(ctx, 1, 2)
+	require.NoError(t, err)
+	require.Len(t, rules, 1, "denying the same permission twice should keep a single rule")
+	assert.Equal(t, "dashboards:read", rules[0].Action)
+	assert.Equal(t, "dashboards:uid:1", rules[0].Scope)
+
+	_, err = store.DeleteDenyRule(ctx, 2, rules[0].ID)
+	assert.ErrorIs(t, err, accesscontrol.ErrDenyRuleNotFound)
+
+	deleted, err := store.DeleteDenyRule(ctx, 1, rules[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted.UserID)
+
+	rules, err = store.ListDenyRules(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+}
+
+func TestAccessControlStore_TemporaryPermissions(t *testing.T) {
+	store, _, _, _ := setupTestEnv(t)
+	ctx := context.Background()
+
+	active := accesscontrol.Permission{Action: "dashboards:write", Scope: "dashboards:uid:1"}
+	expired := accesscontrol.Permission{Action: "dashboards:write", Scope: "dashboards:uid:2"}
+	require.NoError(t, store.AddTemporaryPermission(ctx, &accesscontrol.TemporaryPermissionCommand{OrgID: 1, UserID: 2, Permission: active, ExpiresAt: time.Now().Add(time.Hour)}))
+	require.NoError(t, store.AddTemporaryPermission(ctx, &accesscontrol.TemporaryPermissionCommand{OrgID: 1, UserID: 2, Permission: expired, ExpiresAt: time.Now().Add(-time.Hour)}))
+
+	permissions, err := store.GetUserPermissions(ctx, accesscontrol.GetUserPermissionsQuery{OrgID: 1, UserID: 2})
+	require.NoError(t, err)
+	require.Len(t, permissions, 1, "only permissions within their window should be granted")
+	assert.Equal(t, active.Scope, permissions[0].Scope)
+
+	permissions, err = store.GetUserPermissions(ctx, accesscontrol.GetUserPermissionsQuery{OrgID: 2, UserID: 2})
+	require.NoError(t, err)
+	assert.Empty(t, permissions)
+
+	permissions, err = store.GetUserPermissions(ctx, accesscontrol.GetUserPermissionsQuery{OrgID: 1, UserID: 2, Actions: []string{"dashboards:read"}})
+	require.NoError(t, err)
+	assert.Empty(t, permissions)
+
+	deleted, err := store.DeleteExpiredTemporaryPermissions(ctx, time.Now())
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, expired.Scope, deleted[0].Scope)
+	assert.Equal(t, int64(2), deleted[0].UserID)
+
+	deleted, err = store.DeleteExpiredTemporaryPermissions(ctx, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, active.Scope, deleted[0].Scope)
+}
+
+func TestAccessControlStore_RevokeAllUserRoles(t *testing.T) {
+	store, permissionsStore, sql, teamSvc := setupTestEnv(t)
+	user, _ := createUserAndTeam(t, sql, teamSvc, 1)
+
+	for _, orgID := range []int64{1, 2} {
+		_, err := permissionsStore.SetUserResourcePermission(context.Background(), orgID, accesscontrol.User{ID: user.ID}, rs.SetResourcePermissionCommand{
+			Actions:    []string{"dashboards:write"},
+			Resource:   "dashboards",
+			ResourceID: "1",
+		}, nil)
+		require.NoError(t, err)
+	}
+
+	// assign an additional custom role in org 1
+	err := sql.WithDbSession(context.Background(), func(sess *db.Session) error {
+		role := &accesscontrol.Role{OrgID: 1, UID: "custom", Name: "custom:role", Created: time.Now(), Updated: time.Now()}
+		if _, err := sess.Insert(role); err != nil {
+			return err
+		}
+		if _, err := sess.Insert(&accesscontrol.Permission{RoleID: role.ID, Action: "folders:read", Scope: "folders:*", Created: time.Now(), Updated: time.Now()}); err != nil {
+			return err
+		}
+		_, err := sess.Insert(&accesscontrol.UserRole{OrgID: 1, RoleID: role.ID, UserID: user.ID, Created: time.Now()})
+		return err
+	})
+	require.NoError(t, err)
+
+	const actorID = 42
+	revoked, err := store.RevokeAllUserRoles(context.Background(), 1, user.ID, actorID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, revoked)
+
+	permissions, err := store.GetUserPermissions(context.Background(), accesscontrol.GetUserPermissionsQuery{OrgID: 1, UserID: user.ID})
+	require.NoError(t, err)
+	assert.Empty(t, permissions)
+
+	permissions, err = store.GetUserPermissions(context.Background(), accesscontrol.GetUserPermissionsQuery{OrgID: 2, UserID: user.ID})
+	require.NoError(t, err)
+	assert.Len(t, permissions, 1)
+
+	records, err := store.GetRoleAssignmentAudit(context.Background(), 1, user.ID)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	for _, r := range records {
+		assert.Equal(t, accesscontrol.RoleAssignmentAuditActionRevoke, r.Action)
+		assert.NotEmpty(t, r.RoleUID)
+		assert.Equal(t, int64(actorID), r.ActorID)
+	}
+
+	revoked, err = store.RevokeAllUserRoles(context.Background(), 1, user.ID, actorID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, revoked)
+}
+
+func TestAccessControlStore_AddRolePermissions(t *testing.T) {
+	ctx := context.Background()
+	store, _, sql, _ := setupTestEnv(t)
+
+	role := &accesscontrol.Role{OrgID: 1, UID: "custom", Name: "custom:role", Created: time.Now(), Updated: time.Now()}
+	err := sql.WithDbSession(ctx, func(sess *db.Session) error {
+		if _, err := sess.Insert(role); err != nil {
+			return err
+		}
+		_, err := sess.Insert(&accesscontrol.Permission{RoleID: role.ID, Action: "folders:read", Scope: "folders:uid:a", Created: time.Now(), Updated: time.Now()})
+		return err
+	})
+	require.NoError(t, err)
+
+	getRole := func() (*accesscontrol.Role, []accesscontrol.Permission) {
+		var r accesscontrol.Role
+		var permissions []accesscontrol.Permission
+		err := sql.WithDbSession(ctx, func(sess *db.Session) error {
+			if _, err := sess.ID(role.ID).Get(&r); err != nil {
+				return err
+			}
+			return sess.Where("role_id = ?", role.ID).Asc("id").Find(&permissions)
+		})
+		require.NoError(t, err)
+		return &r, permissions
+	}
+
+	err = store.AddRolePermissions(ctx, 1, "custom", []accesscontrol.Permission{
+		{Action: "folders:read", Scope: "folders:uid:a"},
+		{Action: "folders:write", Scope: "folders:uid:a"},
+		{Action: "folders:write", Scope: "folders:uid:a"},
+	}, 1)
+	require.NoError(t, err)
+
+	updated, permissions := getRole()
+	assert.Equal(t, role.Version+1, updated.Version)
+	require.Len(t, permissions, 2)
+	assert.Equal(t, "folders:write", permissions[1].Action)
+
+	t.Run("adding held permissions is a no-op", func(t *testing.T) {
+		err := store.AddRolePermissions(ctx, 1, "custom", []accesscontrol.Permission{{Action: "folders:write", Scope: "folders:uid:a"}}, 1)
+		require.NoError(t, err)
+
+		r, permissions := getRole()
+		assert.Equal(t, updated.Version, r.Version)
+		assert.Len(t, permissions, 2)
+	})
+
+	t.Run("role of another org is not found", func(t *testing.T) {
+		err := store.AddRolePermissions(ctx, 2, "custom", []accesscontrol.Permission{{Action: "folders:read", Scope: "folders:*"}}, 1)
+		assert.ErrorIs(t, err, accesscontrol.ErrRoleNotFound)
+	})
+}
+
+func TestAccessControlStore_RoleVersionHistory(t *testing.T) {
+	ctx := context.Background()
+	store, _, sql, _ := setupTestEnv(t)
+
+	role := &accesscontrol.Role{OrgID: 1, UID: "custom", Name: "custom:role", Created: time.Now(), Updated: time.Now()}
+	err := sql.WithDbSession(ctx, func(sess *db.Session) error {
+		_, err := sess.Insert(role)
+		return err
+	})
+	require.NoError(t, err)
+
+	versions, err := store.GetRoleVersionHistory(ctx, 1, "custom")
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+
+	err = store.AddRolePermissions(ctx, 1, "custom", []accesscontrol.Permission{{Action: "folders:read", Scope: "folders:uid:a"}}, 2)
+	require.NoError(t, err)
+	err = store.AddRolePermissions(ctx, 1, "custom", []accesscontrol.Permission{{Action: "folders:write", Scope: "folders:uid:a"}}, 3)
+	require.NoError(t, err)
+
+	t.Run("versions increment and snapshot the permissions", func(t *testing.T) {
+		versions, err := store.GetRoleVersionHistory(ctx, 1, "custom")
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+
+		assert.EqualValues(t, 2, versions[0].Version)
+		assert.EqualValues(t, 3, versions[0].UpdatedBy)
+		assert.ElementsMatch(t, []accesscontrol.Permission{
+			{Action: "folders:read", Scope: "folders:uid:a"},
+			{Action: "folders:write", Scope: "folders:uid:a"},
+		}, versions[0].Permissions)
+
+		assert.EqualValues(t, 1, versions[1].Version)
+		assert.EqualValues(t, 2, versions[1].UpdatedBy)
+		assert.Equal(t, []accesscontrol.Permission{{Action: "folders:read", Scope: "folders:uid:a"}}, versions[1].Permissions)
+
+		var updated accesscontrol.Role
+		err = sql.WithDbSession(ctx, func(sess *db.Session) error {
+			_, err := sess.ID(role.ID).Get(&updated)
+			return err
+		})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, updated.Version)
+		assert.EqualValues(t, 3, updated.UpdatedBy)
+	})
+
+	t.Run("old versions are pruned", func(t *testing.T) {
+		for i := 0; i < accesscontrol.RoleVersionHistoryLimit; i++ {
+			err := store.AddRolePermissions(ctx, 1, "custom", []accesscontrol.Permission{{Action: "folders:read", Scope: fmt.Sprintf("folders:uid:%d", i)}}, 2)
+			require.NoError(t, err)
+		}
+
+		versions, err := store.GetRoleVersionHistory(ctx, 1, "custom")
+		require.NoError(t, err)
+		require.Len(t, versions, accesscontrol.RoleVersionHistoryLimit)
+		assert.EqualValues(t, accesscontrol.RoleVersionHistoryLimit+2, versions[0].Version)
+		assert.EqualValues(t, 3, versions[len(versions)-1].Version)
+		for i := 1; i < len(versions); i++ {
+			assert.Equal(t, versions[i-1].Version-1, versions[i].Version)
+		}
+	})
+
+	t.Run("role of another org is not found", func(t *testing.T) {
+		_, err := store.GetRoleVersionHistory(ctx, 2, "custom")
+		assert.ErrorIs(t, err, accesscontrol.ErrRoleNotFound)
+	})
+}
+
+func TestAccessControlStore_CopyUserRoles(t *testing.T) {
+	ctx := context.Background()
+	store, permissionsStore, sql, _ := setupTestEnv(t)
+
+	source, err := sql.CreateUser(ctx, user.CreateUserCommand{Login: "source", OrgID: 1})
+	require.NoError(t, err)
+	target, err := sql.CreateUser(ctx, user.CreateUserCommand{Login: "target", OrgID: 1})
+	require.NoError(t, err)
+
+	_, err = permissionsStore.SetUserResourcePermission(ctx, 1, accesscontrol.User{ID: source.ID}, rs.SetResourcePermissionCommand{
+		Actions:    []string{"dashboards:write"},
+		Resource:   "dashboards",
+		ResourceID: "1",
+	}, nil)
+	require.NoError(t, err)
+	_, err = permissionsStore.SetUserResourcePermission(ctx, 1, accesscontrol.User{ID: target.ID}, rs.SetResourcePermissionCommand{
+		Actions:    []string{"folders:read"},
+		Resource:   "folders",
+		ResourceID: "1",
+	}, nil)
+	require.NoError(t, err)
+
+	cmd := &accesscontrol.CopyPermissionsCommand{OrgID: 1, SourceUserID: source.ID, TargetUserID: target.ID}
+	err = store.CopyUserRoles(ctx, cmd)
+	require.ErrorIs(t, err, accesscontrol.ErrPermissionConflict)
+
+	permissions, err := store.GetUserPermissions(ctx, accesscontrol.GetUserPermissionsQuery{OrgID: 1, UserID: target.ID})
+	require.NoError(t, err)
+	require.Len(t, permissions, 1)
+	assert.Equal(t, "folders:read", permissions[0].Action)
+
+	cmd.Overwrite = true
+	require.NoError(t, store.CopyUserRoles(ctx, cmd))
+
+	permissions, err = store.GetUserPermissions(ctx, accesscontrol.GetUserPermissionsQuery{OrgID: 1, UserID: target.ID})
+	require.NoError(t, err)
+	require.Len(t, permissions, 1)
+	assert.Equal(t, "dashboards:write", permissions[0].Action)
+
+	// the target is bound to the source's roles, so later changes apply to both users
+	_, err = permissionsStore.SetUserResourcePermission(ctx, 1, accesscontrol.User{ID: source.ID}, rs.SetResourcePermissionCommand{
+		Actions:    []string{"dashboards:read"},
+		Resource:   "dashboards",
+		ResourceID: "2",
+	}, nil)
+	require.NoError(t, err)
+
+	permissions, err = store.GetUserPermissions(ctx, accesscontrol.GetUserPermissionsQuery{OrgID: 1, UserID: target.ID})
+	require.NoError(t, err)
+	assert.Len(t, permissions, 2)
+
+	// copying again is a no-op, the target has no conflicting assignments left
+	cmd.Overwrite = false
+	require.NoError(t, store.CopyUserRoles(ctx, cmd))
+}
+
+func TestAccessControlStore_GetUserPermissionsWithConditions(t *testing.T) {
+	ctx := context.Background()
+	store, permissionsStore, sql, teamSvc := setupTestEnv(t)
+	user, _ := createUserAndTeam(t, sql, teamSvc, 1)
+
+	_, err := permissionsStore.SetUserResourcePermission(ctx, 1, accesscontrol.User{ID: user.ID}, rs.SetResourcePermissionCommand{
+		Actions:    []string{"dashboards:write"},
+		Resource:   "dashboards",
+		ResourceID: "1",
+	}, nil)
+	require.NoError(t, err)
+
+	err = sql.WithDbSession(ctx, func(sess *db.Session) error {
+		_, err := sess.Exec("UPDATE permission SET conditions = ? WHERE action = ?", `{"ip_cidr":"10.0.0.0/8"}`, "dashboards:write")
+		return err
+	})
+	require.NoError(t, err)
+
+	permissions, err := store.GetUserPermissions(ctx, accesscontrol.GetUserPermissionsQuery{OrgID: 1, UserID: user.ID})
+	require.NoError(t, err)
+	require.Len(t, permissions, 1)
+	assert.Equal(t, map[string]string{accesscontrol.ConditionIPCIDR: "10.0.0.0/8"}, permissions[0].Conditions)
+	assert.Empty(t, accesscontrol.GroupScopesByAction(permissions))
+	assert.Len(t, accesscontrol.GroupConditionalScopesByAction(permissions)["dashboards:write"], 1)
+}
+
+func TestAccessControlStore_ListResourcePermissions(t *testing.T) {
+	ctx := context.Background()
+	store, _, sql, teamSvc := setupTestEnv(t)
+	usr, team := createUserAndTeam(t, sql, teamSvc, 1)
+
+	// assign roles with the given scope to a user and a team of org 1
+	assign := func(uid string, scope string, userID, teamID int64) {
+		err := sql.WithDbSession(ctx, func(sess *db.Session) error {
+			role := &accesscontrol.Role{OrgID: 1, UID: uid, Name: "custom:" + uid, Created: time.Now(), Updated: time.Now()}
+			if _, err := sess.Insert(role); err != nil {
+				return err
+			}
+			for _, action := range []string{"dashboards:write", "dashboards:read"} {
+				if _, err := sess.Insert(&accesscontrol.Permission{RoleID: role.ID, Action: action, Scope: scope, Created: time.Now(), Updated: time.Now()}); err != nil {
+					return err
+				}
+			}
+			if teamID != 0 {
+				_, err := sess.Insert(&accesscontrol.TeamRole{OrgID: 1, RoleID: role.ID, TeamID: teamID, Created: time.Now()})
+				return err
+			}
+			_, err := sess.Insert(&accesscontrol.UserRole{OrgID: 1, RoleID: role.ID, UserID: userID, Created: time.Now()})
+			return err
+		})
+		require.NoError(t, err)
+	}
+	assign("wildcard", "dashboards:*", usr.ID, 0)
+	assign("exact", "dashboards:uid:a", 0, team.Id)
+	assign("other", "dashboards:uid:b", usr.ID+1, 0)
+
+	permissions, err := store.ListResourcePermissions(ctx, 1, []string{"*", "dashboards:*", "dashboards:uid:*", "dashboards:uid:a"})
+	require.NoError(t, err)
+	require.Len(t, permissions, 2)
+
+	assert.Equal(t, team.Id, permissions[0].TeamId)
+	assert.Equal(t, "custom:exact", permissions[0].RoleName)
+
+	assert.Equal(t, usr.ID, permissions[1].UserId)
+	assert.Equal(t, "custom:wildcard", permissions[1].RoleName)
+	assert.Equal(t, "dashboards:*", permissions[1].Scope)
+	assert.Equal(t, []string{"dashboards:read", "dashboards:write"}, permissions[1].Actions)
+
+	for _, p := range permissions {
+		assert.NotEqual(t, usr.ID+1, p.UserId, "user with access to another resource is listed")
+	}
+
+	permissions, err = store.ListResourcePermissions(ctx, 2, []string{"*", "dashboards:*", "dashboards:uid:*", "dashboards:uid:a"})
+	require.NoError(t, err)
+	assert.Empty(t, permissions)
+}
+
 func createUserAndTeam(t *testing.T, sql *sqlstore.SQLStore, teamSvc team.Service, orgID int64) (*user.User, models.Team) {
 	t.Helper()
 
@@ -250,3 +731,81 @@ func setupTestEnv(t testing.TB) (*AccessControlStore, rs.Store, *sqlstore.SQLSto
 	teamService := teamimpl.ProvideService(sql, cfg)
 	return acstore, permissionStore, sql, teamService
 }
+
+func TestAccessControlStore_ServiceAccountRoles(t *testing.T) {
+	ctx := context.Background()
+	store, permissionsStore, sql, _ := setupTestEnv(t)
+
+	creator, err := sql.CreateUser(ctx, user.CreateUserCommand{Login: "creator"})
+	require.NoError(t, err)
+	// the service account is created in the org of the creator
+	sql.Cfg.AutoAssignOrg = true
+	sa, err := sql.CreateUser(ctx, user.CreateUserCommand{Login: "sa-1-creator", OrgID: creator.OrgID, IsServiceAccount: true})
+	require.NoError(t, err)
+	orgID := creator.OrgID
+	require.Equal(t, orgID, sa.OrgID)
+
+	role := &accesscontrol.Role{OrgID: orgID, UID: "sa-role", Name: "custom:sa", Created: time.Now(), Updated: time.Now()}
+	err = sql.WithDbSession(ctx, func(sess *db.Session) error {
+		if _, err := sess.Insert(role); err != nil {
+			return err
+		}
+		_, err := sess.Insert(&accesscontrol.Permission{RoleID: role.ID, Action: "dashboards:read", Scope: "dashboards:*", Created: time.Now(), Updated: time.Now()})
+		return err
+	})
+	require.NoError(t, err)
+
+	_, err = permissionsStore.SetUserResourcePermission(ctx, orgID, accesscontrol.User{ID: creator.ID}, rs.SetResourcePermissionCommand{
+		Actions:    []string{"folders:write"},
+		Resource:   "folders",
+		ResourceID: "1",
+	}, nil)
+	require.NoError(t, err)
+
+	cmd := &accesscontrol.ServiceAccountRoleCommand{OrgID: orgID, ServiceAccountID: sa.ID, RoleUID: "sa-role"}
+	require.NoError(t, store.AddServiceAccountRole(ctx, cmd))
+	// granting again is a no-op
+	require.NoError(t, store.AddServiceAccountRole(ctx, cmd))
+
+	t.Run("service account has the permissions of its roles only", func(t *testing.T) {
+		permissions, err := store.GetServiceAccountPermissions(ctx, orgID, sa.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []accesscontrol.Permission{{Action: "dashboards:read", Scope: "dashboards:*"}}, permissions)
+
+		permissions, err = store.GetUserPermissions(ctx, accesscontrol.GetUserPermissionsQuery{OrgID: orgID, UserID: sa.ID})
+		require.NoError(t, err)
+		require.Len(t, permissions, 1)
+		assert.Equal(t, "dashboards:read", permissions[0].Action)
+	})
+
+	t.Run("creator does not get the roles of the service account", func(t *testing.T) {
+		permissions, err := store.GetUserPermissions(ctx, accesscontrol.GetUserPermissionsQuery{OrgID: orgID, UserID: creator.ID})
+		require.NoError(t, err)
+		require.Len(t, permissions, 1)
+		assert.Equal(t, "folders:write", permissions[0].Action)
+	})
+
+	t.Run("users are not service accounts", func(t *testing.T) {
+		_, err := store.GetServiceAccountPermissions(ctx, orgID, creator.ID)
+		assert.ErrorIs(t, err, accesscontrol.ErrServiceAccountNotFound)
+
+		err = store.AddServiceAccountRole(ctx, &accesscontrol.ServiceAccountRoleCommand{OrgID: orgID, ServiceAccountID: creator.ID, RoleUID: "sa-role"})
+		assert.ErrorIs(t, err, accesscontrol.ErrServiceAccountNotFound)
+
+		_, err = store.GetServiceAccountPermissions(ctx, orgID+1, sa.ID)
+		assert.ErrorIs(t, err, accesscontrol.ErrServiceAccountNotFound)
+	})
+
+	t.Run("unknown role is not found", func(t *testing.T) {
+		err := store.AddServiceAccountRole(ctx, &accesscontrol.ServiceAccountRoleCommand{OrgID: orgID, ServiceAccountID: sa.ID, RoleUID: "unknown"})
+		assert.ErrorIs(t, err, accesscontrol.ErrRoleNotFound)
+	})
+
+	t.Run("revoked role no longer applies", func(t *testing.T) {
+		require.NoError(t, store.RemoveServiceAccountRole(ctx, cmd))
+
+		permissions, err := store.GetServiceAccountPermissions(ctx, orgID, sa.ID)
+		require.NoError(t, err)
+		assert.Empty(t, permissions)
+	})
+}
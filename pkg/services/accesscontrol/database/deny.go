@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/db"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+)
+
+type denyRule struct {
+	ID      int64     `xorm:"pk autoincr 'id'"`
+	OrgID   int64     `xorm:"org_id"`
+	UserID  int64     `xorm:"user_id"`
+	Action  string    `xorm:"action"`
+	Scope   string    `xorm:"scope"`
+	Created time.Time `xorm:"created"`
+}
+
+func (denyRule) TableName() string {
+	return "access_control_deny"
+}
+
+// AddDenyRule stores a deny rule for the user. Denying a user an action on a
+// scope twice keeps a single rule.
+func (s *AccessControlStore) AddDenyRule(ctx context.Context, cmd *accesscontrol.DenyCommand) error {
+	return s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		exists, err := sess.Where("org_id = ? AND user_id = ? AND action = ? AND scope = ?", cmd.OrgID, cmd.UserID, cmd.Action, cmd.Scope).Exist(&denyRule{})
+		if err != nil || exists {
+			return err
+		}
+		_, err = sess.Insert(&denyRule{
+			OrgID:   cmd.OrgID,
+			UserID:  cmd.UserID,
+			Action:  cmd.Action,
+			Scope:   cmd.Scope,
+			Created: time.Now(),
+		})
+		return err
+	})
+}
+
+func (s *AccessControlStore) ListDenyRules(ctx context.Context, orgID, userID int64) ([]accesscontrol.DenyRule, error) {
+	result := make([]accesscontrol.DenyRule, 0)
+	err := s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		var rows []denyRule
+		if err := sess.Where("org_id = ? AND user_id = ?", orgID, userID).Asc("id").Find(&rows); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			result = append(result, accesscontrol.DenyRule(row))
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+// DeleteDenyRule deletes a deny rule of the org and returns it.
+func (s *AccessControlStore) DeleteDenyRule(ctx context.Context, orgID, ruleID int64) (*accesscontrol.DenyRule, error) {
+	var row denyRule
+	err := s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		exists, err := sess.Where("org_id = ? AND id = ?", orgID, ruleID).Get(&row)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return accesscontrol.ErrDenyRuleNotFound
+		}
+		_, err = sess.Exec("DELETE FROM access_control_deny WHERE id = ?", ruleID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rule := accesscontrol.DenyRule(row)
+	return &rule, nil
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/infra/db"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+)
+
+type resourcePermissionRow struct {
+	RoleName    string `xorm:"role_name"`
+	Action      string `xorm:"action"`
+	Scope       string `xorm:"scope"`
+	UserID      int64  `xorm:"user_id"`
+	TeamID      int64  `xorm:"team_id"`
+	BuiltInRole string `xorm:"builtin_role"`
+}
+
+// ListResourcePermissions returns the permissions with one of scopes held
+// through roles assigned in the org to users, teams and built-in roles. There
+// is one ResourcePermission per assignee, role and scope.
+func (s *AccessControlStore) ListResourcePermissions(ctx context.Context, orgID int64, scopes []string) ([]accesscontrol.ResourcePermission, error) {
+	result := make([]accesscontrol.ResourcePermission, 0)
+	if len(scopes) == 0 {
+		return result, nil
+	}
+
+	err := s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		q := `
+		SELECT
+			role.name AS role_name,
+			permission.action,
+			permission.scope,
+			assignment.user_id,
+			assignment.team_id,
+			assignment.builtin_role
+			FROM permission
+			INNER JOIN role ON role.id = permission.role_id
+			INNER JOIN (
+				SELECT ur.role_id, ur.user_id, 0 AS team_id, '' AS builtin_role
+				FROM user_role AS ur WHERE ur.org_id = ? OR ur.org_id = ?
+				UNION ALL
+				SELECT tr.role_id, 0 AS user_id, tr.team_id, '' AS builtin_role
+				FROM team_role AS tr WHERE tr.org_id = ?
+				UNION ALL
+				SELECT br.role_id, 0 AS user_id, 0 AS team_id, br.role AS builtin_role
+				FROM builtin_role AS br WHERE br.org_id = ? OR br.org_id = ?
+			) AS assignment ON assignment.role_id = role.id
+			WHERE permission.scope IN (?` + strings.Repeat(", ?", len(scopes)-1) + `)
+			ORDER BY assignment.user_id, assignment.team_id, assignment.builtin_role, role.name, permission.scope, permission.action
+		`
+		params := []interface{}{orgID, accesscontrol.GlobalOrgID, orgID, orgID, accesscontrol.GlobalOrgID}
+		for _, scope := range scopes {
+			params = append(params, scope)
+		}
+
+		var rows []resourcePermissionRow
+		if err := sess.SQL(q, params...).Find(&rows); err != nil {
+			return err
+		}
+
+		type key struct {
+			userID, teamID    int64
+			builtInRole, role string
+			scope             string
+		}
+		index := make(map[key]int)
+		for _, row := range rows {
+			k := key{row.UserID, row.TeamID, row.BuiltInRole, row.RoleName, row.Scope}
+			i, ok := index[k]
+			if !ok {
+				i = len(result)
+				index[k] = i
+				result = append(result, accesscontrol.ResourcePermission{
+					RoleName:    row.RoleName,
+					Scope:       row.Scope,
+					UserId:      row.UserID,
+					TeamId:      row.TeamID,
+					BuiltInRole: row.BuiltInRole,
+					IsManaged:   strings.HasPrefix(row.RoleName, accesscontrol.ManagedRolePrefix),
+				})
+			}
+			p := &result[i]
+			if n := len(p.Actions); n == 0 || p.Actions[n-1] != row.Action {
+				p.Actions = append(p.Actions, row.Action)
+			}
+		}
+		for i := range result {
+			sort.Strings(result[i].Actions)
+		}
+		return nil
+	})
+
+	return result, err
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/db"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+)
+
+type roleVersion struct {
+	ID     int64 `xorm:"pk autoincr 'id'"`
+	OrgID  int64 `xorm:"org_id"`
+	RoleID int64 `xorm:"role_id"`
+	// quoted, as a bare version tag makes xorm manage the column itself
+	Version     int64     `xorm:"'version'"`
+	Permissions string    `xorm:"permissions"`
+	UpdatedBy   int64     `xorm:"updated_by"`
+	Updated     time.Time `xorm:"updated"`
+}
+
+func (roleVersion) TableName() string {
+	return "role_version"
+}
+
+// recordRoleVersion records the permissions of the role at its current
+// version, and prunes the versions beyond accesscontrol.RoleVersionHistoryLimit. It is
+// meant to be called in the transaction changing the permissions.
+func recordRoleVersion(sess *db.Session, role *accesscontrol.Role, permissions []accesscontrol.Permission, updatedBy int64, now time.Time) error {
+	data, err := json.Marshal(permissions)
+	if err != nil {
+		return err
+	}
+	if _, err := sess.Insert(&roleVersion{
+		OrgID:       role.OrgID,
+		RoleID:      role.ID,
+		Version:     role.Version,
+		Permissions: string(data),
+		UpdatedBy:   updatedBy,
+		Updated:     now,
+	}); err != nil {
+		return err
+	}
+
+	_, err = sess.Where("role_id = ? AND version <= ?", role.ID, role.Version-accesscontrol.RoleVersionHistoryLimit).Delete(&roleVersion{})
+	return err
+}
+
+// GetRoleVersionHistory returns the recorded versions of the role of the org
+// with the given UID, newest first.
+func (s *AccessControlStore) GetRoleVersionHistory(ctx context.Context, orgID int64, roleUID string) ([]*accesscontrol.RoleVersion, error) {
+	result := make([]*accesscontrol.RoleVersion, 0)
+	err := s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		var role accesscontrol.Role
+		has, err := sess.Where("org_id = ? AND uid = ?", orgID, roleUID).Get(&role)
+		if err != nil {
+			return err
+		} else if !has {
+			return accesscontrol.ErrRoleNotFound
+		}
+
+		var rows []roleVersion
+		if err := sess.Where("role_id = ?", role.ID).Desc("version").Find(&rows); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			v := &accesscontrol.RoleVersion{Version: row.Version, UpdatedBy: row.UpdatedBy, Updated: row.Updated}
+			if err := json.Unmarshal([]byte(row.Permissions), &v.Permissions); err != nil {
+				return err
+			}
+			result = append(result, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
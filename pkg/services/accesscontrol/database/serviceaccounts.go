@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/db"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/user"
+)
+
+// GetServiceAccountPermissions returns the permissions of the roles assigned
+// to the service account of the org.
+func (s *AccessControlStore) GetServiceAccountPermissions(ctx context.Context, orgID, serviceAccountID int64) ([]accesscontrol.Permission, error) {
+	result := make([]accesscontrol.Permission, 0)
+	err := s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		if err := s.requireServiceAccount(sess, orgID, serviceAccountID); err != nil {
+			return err
+		}
+
+		q := `
+		SELECT
+			permission.action,
+			permission.scope,
+			permission.conditions
+			FROM permission
+			INNER JOIN user_role ON user_role.role_id = permission.role_id
+			WHERE user_role.org_id = ? AND user_role.user_id = ? AND user_role.is_service_account = ?
+			ORDER BY permission.action, permission.scope
+		`
+		var rows []permissionRow
+		if err := sess.SQL(q, orgID, serviceAccountID, s.sql.GetDialect().BooleanStr(true)).Find(&rows); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			p := accesscontrol.Permission{Action: row.Action, Scope: row.Scope}
+			if row.Conditions != "" {
+				if err := json.Unmarshal([]byte(row.Conditions), &p.Conditions); err != nil {
+					return err
+				}
+			}
+			result = append(result, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetAssignableRolePermissions returns the permissions of the role of the
+// command, or ErrRoleNotAssignable if it cannot be assigned to service accounts.
+func (s *AccessControlStore) GetAssignableRolePermissions(ctx context.Context, cmd *accesscontrol.ServiceAccountRoleCommand) ([]accesscontrol.Permission, error) {
+	var permissions []accesscontrol.Permission
+	err := s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		role, err := getServiceAccountRole(sess, cmd)
+		if err != nil {
+			return err
+		}
+		if !assignable(role) {
+			return accesscontrol.ErrRoleNotAssignable
+		}
+		return sess.Where("role_id = ?", role.ID).Find(&permissions)
+	})
+	return permissions, err
+}
+
+// AddServiceAccountRole assigns the role to the service account. Assigning a
+// role the service account already has is a no-op. Roles that cannot be
+// assigned to service accounts are rejected with ErrRoleNotAssignable.
+func (s *AccessControlStore) AddServiceAccountRole(ctx context.Context, cmd *accesscontrol.ServiceAccountRoleCommand) error {
+	return s.sql.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		if err := s.requireServiceAccount(sess, cmd.OrgID, cmd.ServiceAccountID); err != nil {
+			return err
+		}
+		role, err := getServiceAccountRole(sess, cmd)
+		if err != nil {
+			return err
+		}
+		if !assignable(role) {
+			return accesscontrol.ErrRoleNotAssignable
+		}
+		roleID := role.ID
+
+		has, err := sess.Where("org_id = ? AND user_id = ? AND role_id = ?", cmd.OrgID, cmd.ServiceAccountID, roleID).Exist(&accesscontrol.UserRole{})
+		if err != nil || has {
+			return err
+		}
+		_, err = sess.Insert(&accesscontrol.UserRole{
+			OrgID:            cmd.OrgID,
+			RoleID:           roleID,
+			UserID:           cmd.ServiceAccountID,
+			IsServiceAccount: true,
+			Created:          time.Now(),
+		})
+		return err
+	})
+}
+
+// RemoveServiceAccountRole revokes the role from the service account.
+func (s *AccessControlStore) RemoveServiceAccountRole(ctx context.Context, cmd *accesscontrol.ServiceAccountRoleCommand) error {
+	return s.sql.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		if err := s.requireServiceAccount(sess, cmd.OrgID, cmd.ServiceAccountID); err != nil {
+			return err
+		}
+		role, err := getServiceAccountRole(sess, cmd)
+		if err != nil {
+			return err
+		}
+
+		_, err = sess.Where("org_id = ? AND user_id = ? AND role_id = ? AND is_service_account = ?", cmd.OrgID, cmd.ServiceAccountID, role.ID, s.sql.GetDialect().BooleanStr(true)).
+			Delete(&accesscontrol.UserRole{})
+		return err
+	})
+}
+
+// requireServiceAccount returns ErrServiceAccountNotFound unless the user of
+// the org with the given ID is a service account.
+func (s *AccessControlStore) requireServiceAccount(sess *db.Session, orgID, serviceAccountID int64) error {
+	has, err := sess.Where("org_id = ? AND id = ? AND is_service_account = ?",
+		orgID, serviceAccountID, s.sql.GetDialect().BooleanStr(true)).Exist(&user.User{})
+	if err != nil {
+		return err
+	}
+	if !has {
+		return accesscontrol.ErrServiceAccountNotFound
+	}
+	return nil
+}
+
+func getServiceAccountRole(sess *db.Session, cmd *accesscontrol.ServiceAccountRoleCommand) (*accesscontrol.Role, error) {
+	var role accesscontrol.Role
+	has, err := sess.Where("(org_id = ? OR org_id = ?) AND uid = ?", cmd.OrgID, accesscontrol.GlobalOrgID, cmd.RoleUID).Get(&role)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, accesscontrol.ErrRoleNotFound
+	}
+	return &role, nil
+}
+
+// assignable returns true for the custom roles, which are the only ones that
+// can be assigned to service accounts.
+func assignable(role *accesscontrol.Role) bool {
+	return !role.IsFixed() && !role.IsManaged() && !role.IsBasic()
+}
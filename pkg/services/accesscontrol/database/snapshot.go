@@ -0,0 +1,220 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/db"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/services/user"
+)
+
+// permissionSnapshot is the stored form of an accesscontrol.PermissionSnapshot,
+// with the permissions kept as gzip compressed JSON.
+type permissionSnapshot struct {
+	ID      int64 `xorm:"pk autoincr 'id'"`
+	OrgID   int64 `xorm:"org_id"`
+	Created time.Time
+	Data    []byte `xorm:"data"`
+}
+
+func (permissionSnapshot) TableName() string {
+	return "permission_snapshots"
+}
+
+// GetOrgUsers returns a signed in user for every member of the org, with org role,
+// server admin flag and team memberships set.
+func (s *AccessControlStore) GetOrgUsers(ctx context.Context, orgID int64) ([]*user.SignedInUser, error) {
+	result := make([]*user.SignedInUser, 0)
+	err := s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		type orgUser struct {
+			UserID  int64  `xorm:"user_id"`
+			Role    string `xorm:"role"`
+			IsAdmin bool   `xorm:"is_admin"`
+		}
+		var orgUsers []orgUser
+		q := `SELECT org_user.user_id, org_user.role, u.is_admin
+			FROM org_user
+			INNER JOIN ` + s.sql.GetDialect().Quote("user") + ` AS u ON u.id = org_user.user_id
+			WHERE org_user.org_id = ?
+			ORDER BY org_user.user_id`
+		if err := sess.SQL(q, orgID).Find(&orgUsers); err != nil {
+			return err
+		}
+
+		type teamMember struct {
+			UserID int64 `xorm:"user_id"`
+			TeamID int64 `xorm:"team_id"`
+		}
+		var members []teamMember
+		if err := sess.SQL("SELECT user_id, team_id FROM team_member WHERE org_id = ?", orgID).Find(&members); err != nil {
+			return err
+		}
+		teams := make(map[int64][]int64)
+		for _, m := range members {
+			teams[m.UserID] = append(teams[m.UserID], m.TeamID)
+		}
+
+		for _, u := range orgUsers {
+			result = append(result, &user.SignedInUser{
+				OrgID:          orgID,
+				UserID:         u.UserID,
+				OrgRole:        org.RoleType(u.Role),
+				IsGrafanaAdmin: u.IsAdmin,
+				Teams:          teams[u.UserID],
+			})
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+// GetOrgUser returns a signed in user for the member of the org, with org role,
+// server admin flag and team memberships set, or accesscontrol.ErrOrgUserNotFound
+// if the user is not a member of the org.
+func (s *AccessControlStore) GetOrgUser(ctx context.Context, orgID, userID int64) (*user.SignedInUser, error) {
+	var result *user.SignedInUser
+	err := s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		var orgUser struct {
+			Role    string `xorm:"role"`
+			IsAdmin bool   `xorm:"is_admin"`
+		}
+		q := `SELECT org_user.role, u.is_admin
+			FROM org_user
+			INNER JOIN ` + s.sql.GetDialect().Quote("user") + ` AS u ON u.id = org_user.user_id
+			WHERE org_user.org_id = ? AND org_user.user_id = ?`
+		has, err := sess.SQL(q, orgID, userID).Get(&orgUser)
+		if err != nil {
+			return err
+		}
+		if !has {
+			return accesscontrol.ErrOrgUserNotFound
+		}
+
+		teams := make([]int64, 0)
+		if err := sess.SQL("SELECT team_id FROM team_member WHERE org_id = ? AND user_id = ? ORDER BY team_id", orgID, userID).Find(&teams); err != nil {
+			return err
+		}
+
+		result = &user.SignedInUser{
+			OrgID:          orgID,
+			UserID:         userID,
+			OrgRole:        org.RoleType(orgUser.Role),
+			IsGrafanaAdmin: orgUser.IsAdmin,
+			Teams:          teams,
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (s *AccessControlStore) StoreSnapshot(ctx context.Context, snap *accesscontrol.PermissionSnapshot) error {
+	data, err := compressPermissions(snap.Permissions)
+	if err != nil {
+		return err
+	}
+
+	return s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		row := permissionSnapshot{
+			OrgID:   snap.OrgID,
+			Created: snap.Timestamp,
+			Data:    data,
+		}
+		if _, err := sess.Insert(&row); err != nil {
+			return err
+		}
+		snap.ID = row.ID
+		return nil
+	})
+}
+
+func (s *AccessControlStore) GetSnapshot(ctx context.Context, orgID, snapshotID int64) (*accesscontrol.PermissionSnapshot, error) {
+	var row permissionSnapshot
+	err := s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		exists, err := sess.Where("org_id = ? AND id = ?", orgID, snapshotID).Get(&row)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return accesscontrol.ErrSnapshotNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := decompressPermissions(row.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &accesscontrol.PermissionSnapshot{
+		ID:          row.ID,
+		OrgID:       row.OrgID,
+		Timestamp:   row.Created,
+		Permissions: permissions,
+	}, nil
+}
+
+func (s *AccessControlStore) ListSnapshots(ctx context.Context, orgID int64) ([]*accesscontrol.SnapshotMeta, error) {
+	result := make([]*accesscontrol.SnapshotMeta, 0)
+	err := s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		var rows []permissionSnapshot
+		if err := sess.Cols("id", "org_id", "created").Where("org_id = ?", orgID).Desc("created").Desc("id").Find(&rows); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			result = append(result, &accesscontrol.SnapshotMeta{
+				ID:        row.ID,
+				OrgID:     row.OrgID,
+				Timestamp: row.Created,
+			})
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+func compressPermissions(permissions map[int64][]accesscontrol.Permission) ([]byte, error) {
+	raw, err := json.Marshal(permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressPermissions(data []byte) (map[int64][]accesscontrol.Permission, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := make(map[int64][]accesscontrol.Permission)
+	if err := json.Unmarshal(raw, &permissions); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/db"
+	"github.com/grafana/grafana/pkg/services/accesscontrol"
+)
+
+type temporaryPermission struct {
+	ID      int64  `xorm:"pk autoincr 'id'"`
+	OrgID   int64  `xorm:"org_id"`
+	UserID  int64  `xorm:"user_id"`
+	Action  string `xorm:"action"`
+	Scope   string `xorm:"scope"`
+	Expires int64  `xorm:"expires"`
+	Created time.Time
+}
+
+func (temporaryPermission) TableName() string {
+	return "access_control_temporary_permissions"
+}
+
+func (p temporaryPermission) toModel() accesscontrol.TemporaryPermission {
+	return accesscontrol.TemporaryPermission{
+		ID:        p.ID,
+		OrgID:     p.OrgID,
+		UserID:    p.UserID,
+		Action:    p.Action,
+		Scope:     p.Scope,
+		ExpiresAt: time.Unix(p.Expires, 0),
+	}
+}
+
+// AddTemporaryPermission stores a grant of the permission to the user until
+// cmd.ExpiresAt. The expiry is stored with a precision of one second.
+func (s *AccessControlStore) AddTemporaryPermission(ctx context.Context, cmd *accesscontrol.TemporaryPermissionCommand) error {
+	return s.sql.WithDbSession(ctx, func(sess *db.Session) error {
+		_, err := sess.Insert(&temporaryPermission{
+			OrgID:   cmd.OrgID,
+			UserID:  cmd.UserID,
+			Action:  cmd.Permission.Action,
+			Scope:   cmd.Permission.Scope,
+			Expires: cmd.ExpiresAt.Unix(),
+			Created: time.Now(),
+		})
+		return err
+	})
+}
+
+// DeleteExpiredTemporaryPermissions deletes the temporary permissions that
+// expired at now and returns them.
+func (s *AccessControlStore) DeleteExpiredTemporaryPermissions(ctx context.Context, now time.Time) ([]accesscontrol.TemporaryPermission, error) {
+	result := make([]accesscontrol.TemporaryPermission, 0)
+	err := s.sql.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		var rows []temporaryPermission
+		if err := sess.Where("expires <= ?", now.Unix()).Asc("id").Find(&rows); err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, 0, len(rows))
+		for _, row := range rows {
+			ids = append(ids, row.ID)
+			result = append(result, row.toModel())
+		}
+		_, err := sess.In("id", ids).Delete(&temporaryPermission{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
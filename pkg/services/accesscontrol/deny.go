@@ -0,0 +1,80 @@
+package accesscontrol
+
+import (
+	"time"
+)
+
+// DenyCommand denies an action on a scope to a user of the org, regardless of
+// the roles the user is granted. An empty scope denies the action on every scope.
+type DenyCommand struct {
+	OrgID  int64  `json:"-"`
+	UserID int64  `json:"userId"`
+	Action string `json:"action"`
+	Scope  string `json:"scope"`
+}
+
+// DenyRule is a stored deny of an action on a scope to a user of the org.
+type DenyRule struct {
+	ID      int64     `json:"id"`
+	OrgID   int64     `json:"orgId"`
+	UserID  int64     `json:"userId"`
+	Action  string    `json:"action"`
+	Scope   string    `json:"scope"`
+	Created time.Time `json:"created"`
+}
+
+// GroupDenyRulesByAction returns the scopes of the rules by action, in the form
+// of permissions so that evaluators can be run against them.
+func GroupDenyRulesByAction(rules []DenyRule) map[string][]string {
+	denied := make(map[string][]string, len(rules))
+	for _, r := range rules {
+		scope := r.Scope
+		if scope == "" {
+			scope = "*"
+		}
+		denied[r.Action] = append(denied[r.Action], scope)
+	}
+	return denied
+}
+
+// IsDenied returns true if the deny rules block an evaluation the granted
+// permissions allow. That is the case when the rules match what the evaluator
+// requires, or when the evaluator no longer passes once the granted scopes the
+// rules cover are taken away. The parts of the evaluator negated with EvalNot
+// require no permission, so the rules never match them and they are evaluated
+// against the granted permissions.
+func IsDenied(evaluator Evaluator, granted map[string][]string, rules []DenyRule) bool {
+	if len(rules) == 0 {
+		return false
+	}
+
+	denied := GroupDenyRulesByAction(rules)
+	if evaluatePositive(evaluator, denied, func(Evaluator) bool { return false }) {
+		return true
+	}
+
+	remaining := make(map[string][]string, len(granted))
+	for action, scopes := range granted {
+		for _, scope := range scopes {
+			if !coversScope(denied[action], scope) {
+				remaining[action] = append(remaining[action], scope)
+			}
+		}
+		// actions granted without scopes are only denied by rules matching the evaluator
+		if len(scopes) == 0 {
+			remaining[action] = scopes
+		}
+	}
+	return !evaluatePositive(evaluator, remaining, func(negated Evaluator) bool {
+		return negated.Evaluate(granted)
+	})
+}
+
+func coversScope(deniedScopes []string, scope string) bool {
+	for _, d := range deniedScopes {
+		if match(d, scope) {
+			return true
+		}
+	}
+	return false
+}
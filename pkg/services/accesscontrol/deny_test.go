@@ -0,0 +1,87 @@
+package accesscontrol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDenied(t *testing.T) {
+	granted := map[string][]string{
+		"dashboards:read": {"dashboards:*"},
+		"folders:read":    {"folders:uid:1", "folders:uid:2"},
+	}
+
+	tests := []struct {
+		desc      string
+		evaluator Evaluator
+		rules     []DenyRule
+		expected  bool
+	}{
+		{
+			desc:      "no rules",
+			evaluator: EvalPermission("dashboards:read", "dashboards:uid:1"),
+			expected:  false,
+		},
+		{
+			desc:      "rule on the requested scope of a wildcard grant",
+			evaluator: EvalPermission("dashboards:read", "dashboards:uid:1"),
+			rules:     []DenyRule{{Action: "dashboards:read", Scope: "dashboards:uid:1"}},
+			expected:  true,
+		},
+		{
+			desc:      "rule on another scope",
+			evaluator: EvalPermission("dashboards:read", "dashboards:uid:1"),
+			rules:     []DenyRule{{Action: "dashboards:read", Scope: "dashboards:uid:2"}},
+			expected:  false,
+		},
+		{
+			desc:      "rule on another action",
+			evaluator: EvalPermission("dashboards:read", "dashboards:uid:1"),
+			rules:     []DenyRule{{Action: "dashboards:write", Scope: "dashboards:uid:1"}},
+			expected:  false,
+		},
+		{
+			desc:      "rule without scope",
+			evaluator: EvalPermission("folders:read"),
+			rules:     []DenyRule{{Action: "folders:read"}},
+			expected:  true,
+		},
+		{
+			desc:      "rule on one of all required scopes",
+			evaluator: EvalAll(EvalPermission("folders:read", "folders:uid:1"), EvalPermission("folders:read", "folders:uid:2")),
+			rules:     []DenyRule{{Action: "folders:read", Scope: "folders:uid:2"}},
+			expected:  true,
+		},
+		{
+			desc:      "wildcard rule covering the granted scopes",
+			evaluator: EvalPermission("folders:read"),
+			rules:     []DenyRule{{Action: "folders:read", Scope: "folders:*"}},
+			expected:  true,
+		},
+		{
+			desc:      "unrelated rule on a negation",
+			evaluator: EvalNot(EvalPermission("users:write")),
+			rules:     []DenyRule{{Action: "folders:read", Scope: "folders:uid:2"}},
+			expected:  false,
+		},
+		{
+			desc:      "rule on the negated permission",
+			evaluator: EvalAll(EvalPermission("folders:read", "folders:uid:1"), EvalNot(EvalPermission("folders:write", "folders:uid:1"))),
+			rules:     []DenyRule{{Action: "folders:write", Scope: "folders:uid:1"}},
+			expected:  false,
+		},
+		{
+			desc:      "rule covering the permission passed alongside a negation",
+			evaluator: EvalAny(EvalPermission("folders:read", "folders:uid:1"), EvalNot(EvalPermission("folders:read", "folders:uid:2"))),
+			rules:     []DenyRule{{Action: "folders:read", Scope: "folders:*"}},
+			expected:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			assert.Equal(t, tt.expected, IsDenied(tt.evaluator, granted, tt.rules))
+		})
+	}
+}
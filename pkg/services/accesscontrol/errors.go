@@ -3,8 +3,25 @@ package accesscontrol
 import "errors"
 
 var (
-	ErrFixedRolePrefixMissing = errors.New("fixed role should be prefixed with '" + FixedRolePrefix + "'")
-	ErrInvalidBuiltinRole     = errors.New("built-in role is not valid")
-	ErrInvalidScope           = errors.New("invalid scope")
-	ErrResolverNotFound       = errors.New("no resolver found")
+	ErrFixedRolePrefixMissing  = errors.New("fixed role should be prefixed with '" + FixedRolePrefix + "'")
+	ErrImpersonationDenied     = errors.New("user impersonation is not allowed")
+	ErrImpersonationTarget     = errors.New("impersonation target is not a member of the org")
+	ErrDenyRuleNotFound        = errors.New("deny rule not found")
+	ErrInvalidBuiltinRole      = errors.New("built-in role is not valid")
+	ErrInvalidCursor           = errors.New("invalid or expired cursor")
+	ErrInvalidExpiry           = errors.New("temporary permission must expire in the future")
+	ErrInvalidScope            = errors.New("invalid scope")
+	ErrInvalidScopeExpression  = errors.New("invalid scope expression")
+	ErrInvalidTemplate         = errors.New("invalid permission template")
+	ErrOrgUserNotFound         = errors.New("user is not a member of the org")
+	ErrPermissionConflict      = errors.New("target user has conflicting role assignments")
+	ErrPermissionEscalation    = errors.New("cannot grant permissions the granter does not have")
+	ErrResolverNotFound        = errors.New("no resolver found")
+	ErrRoleNotAssignable       = errors.New("role cannot be assigned to service accounts")
+	ErrRoleNotFound            = errors.New("role not found")
+	ErrServiceAccountNotFound  = errors.New("service account not found")
+	ErrSnapshotNotFound        = errors.New("permission snapshot not found")
+	ErrTemplateExists          = errors.New("permission template already registered")
+	ErrTemplateNotFound        = errors.New("permission template not found")
+	ErrTemplateVariableMissing = errors.New("permission template variable missing")
 )
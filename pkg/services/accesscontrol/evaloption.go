@@ -0,0 +1,55 @@
+package accesscontrol
+
+import (
+	"github.com/grafana/grafana/pkg/services/user"
+)
+
+// EvalOptions configure AccessControl.Evaluate.
+type EvalOptions struct {
+	// OrgID is the org the permissions of the user are evaluated in. Zero
+	// evaluates them in the current org of the user.
+	OrgID int64
+}
+
+// EvalOption configures AccessControl.Evaluate.
+type EvalOption func(*EvalOptions)
+
+// WithOrgScope evaluates the permissions of the user in the org instead of its
+// current org, e.g. for a server admin acting on another org.
+func WithOrgScope(orgID int64) EvalOption {
+	return func(o *EvalOptions) {
+		o.OrgID = orgID
+	}
+}
+
+// NewEvalOptions returns the options set by opts.
+func NewEvalOptions(opts ...EvalOption) EvalOptions {
+	var options EvalOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// UserInOrg returns a copy of the user switched to the org. The role, name and
+// teams of the current org of the user are cleared, as they do not apply to the
+// org. The permissions of the copy can be set without changing the user.
+func UserInOrg(u *user.SignedInUser, orgID int64) *user.SignedInUser {
+	scoped := *u
+	scoped.OrgID = orgID
+	scoped.OrgRole = ""
+	scoped.OrgName = ""
+	scoped.Teams = nil
+
+	scoped.Permissions = make(map[int64]map[string][]string, len(u.Permissions))
+	for id, permissions := range u.Permissions {
+		scoped.Permissions[id] = permissions
+	}
+	if u.PermissionConditions != nil {
+		scoped.PermissionConditions = make(map[int64]map[string][]user.ConditionalScope, len(u.PermissionConditions))
+		for id, conditional := range u.PermissionConditions {
+			scoped.PermissionConditions[id] = conditional
+		}
+	}
+	return &scoped
+}
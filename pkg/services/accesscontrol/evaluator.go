@@ -22,7 +22,8 @@ type Evaluator interface {
 
 var _ Evaluator = new(permissionEvaluator)
 
-// EvalPermission returns an evaluator that will require at least one of passed scopes to match
+// EvalPermission returns an evaluator that will require at least one of passed scopes to match.
+// Scopes are matched as they are, see EvalPermissionExpr for compound scope expressions.
 func EvalPermission(action string, scopes ...string) Evaluator {
 	return permissionEvaluator{Action: action, Scopes: scopes}
 }
@@ -202,3 +203,108 @@ func (a anyEvaluator) GoString() string {
 
 	return fmt.Sprintf("any(%s)", strings.Join(permissions, " "))
 }
+
+var _ Evaluator = new(notEvaluator)
+
+// EvalNot returns evaluator that requires the passed evaluator to evaluate to false
+func EvalNot(eval Evaluator) Evaluator {
+	return notEvaluator{eval: eval}
+}
+
+type notEvaluator struct {
+	eval Evaluator
+}
+
+func (n notEvaluator) Evaluate(permissions map[string][]string) bool {
+	return !n.eval.Evaluate(permissions)
+}
+
+func (n notEvaluator) MutateScopes(ctx context.Context, mutate ScopeAttributeMutator) (Evaluator, error) {
+	e, err := n.eval.MutateScopes(ctx, mutate)
+	if err != nil {
+		return nil, err
+	}
+	return EvalNot(e), nil
+}
+
+func (n notEvaluator) String() string {
+	return fmt.Sprintf("not %s", n.eval.String())
+}
+
+func (n notEvaluator) GoString() string {
+	return fmt.Sprintf("not(%s)", n.eval.GoString())
+}
+
+// ContainsNegation returns true if a part of the evaluator is negated with
+// EvalNot. Such an evaluator can pass on fewer permissions than it fails on,
+// so it must not be evaluated before its scopes are resolved.
+func ContainsNegation(evaluator Evaluator) bool {
+	switch e := evaluator.(type) {
+	case notEvaluator:
+		return true
+	case allEvaluator:
+		for _, child := range e.allOf {
+			if ContainsNegation(child) {
+				return true
+			}
+		}
+	case anyEvaluator:
+		for _, child := range e.anyOf {
+			if ContainsNegation(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// evaluatePositive evaluates the evaluator with the permissions it requires
+// checked against permissions, and the parts negated with EvalNot replaced by
+// the result of negated.
+func evaluatePositive(evaluator Evaluator, permissions map[string][]string, negated func(Evaluator) bool) bool {
+	switch e := evaluator.(type) {
+	case notEvaluator:
+		return negated(e)
+	case allEvaluator:
+		for _, child := range e.allOf {
+			if !evaluatePositive(child, permissions, negated) {
+				return false
+			}
+		}
+		return true
+	case anyEvaluator:
+		for _, child := range e.anyOf {
+			if evaluatePositive(child, permissions, negated) {
+				return true
+			}
+		}
+		return false
+	}
+	return evaluator.Evaluate(permissions)
+}
+
+// EvalQuery is a single permission check, see EvalPermission.
+type EvalQuery struct {
+	Action string
+	Scopes []string
+}
+
+// EvalAllPermissions returns evaluator that requires all passed permission checks to pass.
+// Checks are evaluated in order and evaluation stops at the first failing one.
+func EvalAllPermissions(checks ...EvalQuery) Evaluator {
+	return EvalAll(evalQueries(checks)...)
+}
+
+// EvalAnyPermission returns evaluator that requires at least one of passed permission checks to pass.
+// Checks are evaluated in order and evaluation stops at the first passing one.
+func EvalAnyPermission(checks ...EvalQuery) Evaluator {
+	return EvalAny(evalQueries(checks)...)
+}
+
+func evalQueries(checks []EvalQuery) []Evaluator {
+	evaluators := make([]Evaluator, 0, len(checks))
+	for _, c := range checks {
+		evaluators = append(evaluators, EvalPermission(c.Action, c.Scopes...))
+	}
+	return evaluators
+}
@@ -410,3 +410,149 @@ func TestEval(t *testing.T) {
 		})
 	}
 }
+
+// countingEvaluator records how many times it was evaluated.
+type countingEvaluator struct {
+	Evaluator
+	count *int
+}
+
+func (c countingEvaluator) Evaluate(permissions map[string][]string) bool {
+	*c.count++
+	return c.Evaluator.Evaluate(permissions)
+}
+
+func TestEval_shortCircuit(t *testing.T) {
+	permissions := map[string][]string{
+		"dashboards:read": {"dashboards:*"},
+	}
+
+	t.Run("all stops at the first failure", func(t *testing.T) {
+		var count int
+		eval := EvalAll(
+			EvalPermission("dashboards:write"),
+			countingEvaluator{EvalPermission("dashboards:read"), &count},
+		)
+		assert.False(t, eval.Evaluate(permissions))
+		assert.Zero(t, count)
+	})
+
+	t.Run("any stops at the first success", func(t *testing.T) {
+		var count int
+		eval := EvalAny(
+			EvalPermission("dashboards:read"),
+			countingEvaluator{EvalPermission("dashboards:write"), &count},
+		)
+		assert.True(t, eval.Evaluate(permissions))
+		assert.Zero(t, count)
+	})
+}
+
+func TestEvalPermissions_Evaluate(t *testing.T) {
+	tests := []evaluateTestCase{
+		{
+			desc: "all should return true when all checks pass",
+			evaluator: EvalAllPermissions(
+				EvalQuery{Action: "dashboards:read", Scopes: []string{"dashboards:uid:1"}},
+				EvalQuery{Action: "folders:read"},
+			),
+			permissions: map[string][]string{
+				"dashboards:read": {"dashboards:*"},
+				"folders:read":    {"folders:uid:2"},
+			},
+			expected: true,
+		},
+		{
+			desc: "all should return false when a check fails",
+			evaluator: EvalAllPermissions(
+				EvalQuery{Action: "dashboards:read", Scopes: []string{"dashboards:uid:1"}},
+				EvalQuery{Action: "folders:read"},
+			),
+			permissions: map[string][]string{
+				"dashboards:read": {"dashboards:*"},
+			},
+			expected: false,
+		},
+		{
+			desc: "any should return true when a check passes",
+			evaluator: EvalAnyPermission(
+				EvalQuery{Action: "dashboards:read", Scopes: []string{"dashboards:uid:1"}},
+				EvalQuery{Action: "folders:read"},
+			),
+			permissions: map[string][]string{
+				"folders:read": {"folders:uid:2"},
+			},
+			expected: true,
+		},
+		{
+			desc: "any should return false when no check passes",
+			evaluator: EvalAnyPermission(
+				EvalQuery{Action: "dashboards:read", Scopes: []string{"dashboards:uid:1"}},
+			),
+			permissions: map[string][]string{
+				"dashboards:read": {"dashboards:uid:2"},
+			},
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ok := test.evaluator.Evaluate(test.permissions)
+			assert.Equal(t, test.expected, ok)
+		})
+	}
+}
+
+func TestNot_Evaluate(t *testing.T) {
+	// all(any(a, b), not(c))
+	eval := EvalAll(
+		EvalAny(EvalPermission("a:read"), EvalPermission("b:read")),
+		EvalNot(EvalPermission("c:read")),
+	)
+
+	tests := []evaluateTestCase{
+		{
+			desc:        "should return true with a and without c",
+			evaluator:   eval,
+			permissions: map[string][]string{"a:read": {}},
+			expected:    true,
+		},
+		{
+			desc:        "should return true with b and without c",
+			evaluator:   eval,
+			permissions: map[string][]string{"b:read": {}},
+			expected:    true,
+		},
+		{
+			desc:        "should return false with c",
+			evaluator:   eval,
+			permissions: map[string][]string{"a:read": {}, "b:read": {}, "c:read": {}},
+			expected:    false,
+		},
+		{
+			desc:        "should return false without a or b",
+			evaluator:   eval,
+			permissions: map[string][]string{},
+			expected:    false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ok := test.evaluator.Evaluate(test.permissions)
+			assert.Equal(t, test.expected, ok)
+		})
+	}
+}
+
+func TestNot_Inject(t *testing.T) {
+	eval := EvalNot(EvalPermission("reports:read", Scope("reports", Parameter(":reportId"))))
+	injected, err := eval.MutateScopes(context.TODO(), scopeInjector(scopeParams{
+		URLParams: map[string]string{":reportId": "1"},
+	}))
+	assert.NoError(t, err)
+	assert.False(t, injected.Evaluate(map[string][]string{"reports:read": {"reports:1"}}))
+	assert.True(t, injected.Evaluate(map[string][]string{"reports:read": {"reports:2"}}))
+	assert.Equal(t, "not(action:reports:read scopes:reports:1)", injected.GoString())
+}
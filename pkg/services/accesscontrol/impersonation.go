@@ -0,0 +1,33 @@
+package accesscontrol
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/grafana/grafana/pkg/services/user"
+)
+
+type impersonatorKey struct{}
+
+// ImpersonateScope returns the scope a user needs ActionImpersonateUsers on to
+// impersonate the user with the given ID.
+func ImpersonateScope(userID int64) string {
+	return Scope("global.users", "id", strconv.FormatInt(userID, 10))
+}
+
+// WithImpersonator returns ctx carrying the ID of the admin impersonating u, so
+// that changes made with ctx can be attributed to the admin. ctx is returned
+// unchanged if u is not impersonated.
+func WithImpersonator(ctx context.Context, u *user.SignedInUser) context.Context {
+	if u == nil || !u.IsImpersonated {
+		return ctx
+	}
+	return context.WithValue(ctx, impersonatorKey{}, u.ImpersonatedBy)
+}
+
+// ImpersonatorFromContext returns the ID of the impersonating admin set with
+// WithImpersonator, or zero.
+func ImpersonatorFromContext(ctx context.Context) int64 {
+	id, _ := ctx.Value(impersonatorKey{}).(int64)
+	return id
+}
@@ -0,0 +1,102 @@
+package accesscontrol
+
+// PermissionIndex answers permission checks in time proportional to the length
+// of the scope, independent of the number of permissions. Scopes are stored per
+// action in a trie keyed on the bytes of the scope, so a scope ending in a
+// wildcard grants every scope that shares its prefix, as with EvalPermission.
+type PermissionIndex struct {
+	actions map[string]*actionIndex
+}
+
+type actionIndex struct {
+	scopes []string
+	root   *scopeNode
+}
+
+type scopeNode struct {
+	children map[byte]*scopeNode
+	// exact is set if a scope ends at this node.
+	exact bool
+	// wildcard is set if a scope ends with a wildcard right after this node.
+	wildcard bool
+}
+
+// NewPermissionIndex builds an index from permissions. Invalid scopes are
+// indexed for AllForAction but never match in HasPermission.
+func NewPermissionIndex(permissions []Permission) *PermissionIndex {
+	idx := &PermissionIndex{actions: make(map[string]*actionIndex)}
+	for _, p := range permissions {
+		a, ok := idx.actions[p.Action]
+		if !ok {
+			a = &actionIndex{root: &scopeNode{}}
+			idx.actions[p.Action] = a
+		}
+		a.scopes = append(a.scopes, p.Scope)
+		if p.Scope == "" || !ValidateScope(p.Scope) {
+			continue
+		}
+		a.root.insert(p.Scope)
+	}
+	return idx
+}
+
+// HasPermission returns true if the indexed permissions grant action on scope.
+// An empty scope only requires the action to be granted.
+func (idx *PermissionIndex) HasPermission(action, scope string) bool {
+	a, ok := idx.actions[action]
+	if !ok {
+		return false
+	}
+	if scope == "" {
+		return true
+	}
+	return a.root.match(scope)
+}
+
+// AllForAction returns the scopes granted for action.
+func (idx *PermissionIndex) AllForAction(action string) []string {
+	a, ok := idx.actions[action]
+	if !ok {
+		return nil
+	}
+	return a.scopes
+}
+
+func (n *scopeNode) insert(scope string) {
+	wildcard := scope[len(scope)-1] == '*'
+	if wildcard {
+		scope = scope[:len(scope)-1]
+	}
+
+	for i := 0; i < len(scope); i++ {
+		if n.children == nil {
+			n.children = make(map[byte]*scopeNode)
+		}
+		child, ok := n.children[scope[i]]
+		if !ok {
+			child = &scopeNode{}
+			n.children[scope[i]] = child
+		}
+		n = child
+	}
+
+	if wildcard {
+		n.wildcard = true
+	} else {
+		n.exact = true
+	}
+}
+
+func (n *scopeNode) match(target string) bool {
+	for i := 0; i < len(target); i++ {
+		if n.wildcard {
+			return true
+		}
+		child, ok := n.children[target[i]]
+		if !ok {
+			return false
+		}
+		n = child
+	}
+	return n.exact || n.wildcard
+}
@@ -0,0 +1,89 @@
+package accesscontrol
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermissionIndex(t *testing.T) {
+	idx := NewPermissionIndex([]Permission{
+		{Action: "dashboards:read", Scope: "dashboards:uid:1"},
+		{Action: "dashboards:read", Scope: "folders:uid:*"},
+		{Action: "dashboards:write", Scope: "dashboards:*"},
+		{Action: "users:read", Scope: "*"},
+		{Action: "teams:create"},
+		{Action: "datasources:read", Scope: "datasources:*:1"},
+	})
+
+	tests := []struct {
+		action, scope string
+		expected      bool
+	}{
+		{"dashboards:read", "dashboards:uid:1", true},
+		{"dashboards:read", "dashboards:uid:10", false},
+		{"dashboards:read", "dashboards:uid:", false},
+		{"dashboards:read", "folders:uid:abc", true},
+		{"dashboards:read", "folders:uid:", true},
+		{"dashboards:read", "folders:id:1", false},
+		{"dashboards:write", "dashboards:uid:1", true},
+		{"dashboards:write", "folders:uid:1", false},
+		{"users:read", "global.users:id:1", true},
+		{"teams:create", "", true},
+		{"teams:create", "teams:id:1", false},
+		{"dashboards:delete", "", false},
+		{"datasources:read", "datasources:uid:1", false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s on %q", tt.action, tt.scope), func(t *testing.T) {
+			assert.Equal(t, tt.expected, idx.HasPermission(tt.action, tt.scope))
+			if tt.scope != "" {
+				// The index must agree with the evaluator.
+				assert.Equal(t, tt.expected, EvalPermission(tt.action, tt.scope).Evaluate(GroupScopesByAction(idx.flatten())))
+			}
+		})
+	}
+
+	assert.ElementsMatch(t, []string{"dashboards:uid:1", "folders:uid:*"}, idx.AllForAction("dashboards:read"))
+	assert.Nil(t, idx.AllForAction("dashboards:delete"))
+}
+
+func (idx *PermissionIndex) flatten() []Permission {
+	var permissions []Permission
+	for action, a := range idx.actions {
+		for _, scope := range a.scopes {
+			permissions = append(permissions, Permission{Action: action, Scope: scope})
+		}
+	}
+	return permissions
+}
+
+func benchmarkPermissions(n int) []Permission {
+	permissions := make([]Permission, 0, n)
+	for i := 0; i < n; i++ {
+		permissions = append(permissions, Permission{Action: "dashboards:read", Scope: fmt.Sprintf("dashboards:uid:%d", i)})
+	}
+	return permissions
+}
+
+func BenchmarkPermissionIndex_HasPermission5000(b *testing.B) {
+	idx := NewPermissionIndex(benchmarkPermissions(5000))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !idx.HasPermission("dashboards:read", "dashboards:uid:4999") {
+			b.Fatal("expected permission")
+		}
+	}
+}
+
+func BenchmarkLinearScan_HasPermission5000(b *testing.B) {
+	grouped := GroupScopesByAction(benchmarkPermissions(5000))
+	evaluator := EvalPermission("dashboards:read", "dashboards:uid:4999")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !evaluator.Evaluate(grouped) {
+			b.Fatal("expected permission")
+		}
+	}
+}
@@ -3,6 +3,7 @@ package accesscontrol
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -13,8 +14,15 @@ import (
 	"text/template"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/grafana/grafana/pkg/middleware/cookies"
 
+	"github.com/grafana/grafana/pkg/infra/appcontext"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/tracing"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/user"
 	"github.com/grafana/grafana/pkg/setting"
@@ -23,6 +31,12 @@ import (
 )
 
 func Middleware(ac AccessControl) func(web.Handler, Evaluator) web.Handler {
+	return MiddlewareWithTracer(ac, nil)
+}
+
+// MiddlewareWithTracer is Middleware with every evaluation traced in an
+// accesscontrol.Evaluate span of tracer, see EvaluateWithSpan.
+func MiddlewareWithTracer(ac AccessControl, tracer tracing.Tracer) func(web.Handler, Evaluator) web.Handler {
 	return func(fallback web.Handler, evaluator Evaluator) web.Handler {
 		if ac.IsDisabled() {
 			return fallback
@@ -47,12 +61,67 @@ func Middleware(ac AccessControl) func(web.Handler, Evaluator) web.Handler {
 				return
 			}
 
-			authorize(c, ac, c.SignedInUser, evaluator)
+			authorize(c, ac, tracer, c.SignedInUser, evaluator)
 		}
 	}
 }
 
-func authorize(c *models.ReqContext, ac AccessControl, user *user.SignedInUser, evaluator Evaluator) {
+var middlewareLogger = log.New("accesscontrol.middleware")
+
+// RequirePermission returns a standard net/http middleware that only calls the
+// wrapped handler when the signed in user found in the request context is
+// granted evaluator. Anonymous requests are rejected with 401 and denied ones
+// with 403. Errors from the access control system are reported as a generic 500
+// without details.
+func RequirePermission(ac AccessControl, evaluator Evaluator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			usr, err := appcontext.User(r.Context())
+			if err != nil || usr.IsAnonymous {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Unauthorized"})
+				return
+			}
+
+			id := newID()
+			injected, err := evaluator.MutateScopes(r.Context(), scopeInjector(scopeParams{
+				OrgID:     usr.OrgID,
+				URLParams: web.Params(r),
+			}))
+			if err != nil {
+				middlewareLogger.Error("Failed to inject scopes", "error", err, "accessErrorID", id)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"message": "Internal server error", "accessErrorId": id})
+				return
+			}
+
+			hasAccess, err := ac.Evaluate(r.Context(), usr, injected)
+			if err != nil {
+				middlewareLogger.Error("Error from access control system", "error", err, "accessErrorID", id)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"message": "Internal server error", "accessErrorId": id})
+				return
+			}
+
+			if !hasAccess {
+				middlewareLogger.Info("Access denied", "userID", usr.UserID, "accessErrorID", id, "permissions", injected.GoString())
+				writeJSON(w, http.StatusForbidden, map[string]string{
+					"title":         "Access denied",
+					"message":       fmt.Sprintf("You'll need additional permissions to perform this action. Permissions needed: %s", injected.String()),
+					"accessErrorId": id,
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func authorize(c *models.ReqContext, ac AccessControl, tracer tracing.Tracer, user *user.SignedInUser, evaluator Evaluator) {
 	injected, err := evaluator.MutateScopes(c.Req.Context(), scopeInjector(scopeParams{
 		OrgID:     c.OrgID,
 		URLParams: web.Params(c.Req),
@@ -62,13 +131,50 @@ func authorize(c *models.ReqContext, ac AccessControl, user *user.SignedInUser,
 		return
 	}
 
-	hasAccess, err := ac.Evaluate(c.Req.Context(), user, injected)
+	hasAccess, err := EvaluateWithSpan(c.Req.Context(), tracer, user, injected, func(ctx context.Context) (bool, error) {
+		return ac.Evaluate(ctx, user, injected)
+	})
 	if !hasAccess || err != nil {
 		deny(c, injected, err)
 		return
 	}
 }
 
+// EvaluateWithSpan runs evaluate in an accesscontrol.Evaluate span of tracer
+// recording the permissions of evaluator, the user and the result. Evaluate is
+// run without a span if tracer is nil.
+func EvaluateWithSpan(ctx context.Context, tracer tracing.Tracer, user *user.SignedInUser, evaluator Evaluator, evaluate func(ctx context.Context) (bool, error)) (bool, error) {
+	if tracer == nil {
+		return evaluate(ctx)
+	}
+
+	var actions, scopes []string
+	walkPermissions(evaluator, func(action string, s []string) {
+		actions = append(actions, action)
+		scopes = append(scopes, s...)
+	})
+
+	ctx, span := tracer.Start(ctx, "accesscontrol.Evaluate", trace.WithAttributes(
+		attribute.String("permission.action", strings.Join(actions, ",")),
+		attribute.String("permission.scope", strings.Join(scopes, ",")),
+		attribute.Int64("user.id", user.UserID),
+		attribute.Int64("org.id", user.OrgID),
+	))
+	defer span.End()
+
+	hasAccess, err := evaluate(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	result := "deny"
+	if hasAccess && err == nil {
+		result = "allow"
+	}
+	span.SetAttributes("result", result, attribute.String("result", result))
+	return hasAccess, err
+}
+
 func deny(c *models.ReqContext, evaluator Evaluator, err error) {
 	id := newID()
 	if err != nil {
@@ -199,13 +305,14 @@ func AuthorizeInOrgMiddleware(ac AccessControl, service Service, cache userCache
 				if err != nil {
 					deny(c, nil, fmt.Errorf("failed to authenticate user in target org: %w", err))
 				}
-				userCopy.Permissions[userCopy.OrgID] = GroupScopesByAction(permissions)
+				SetUserPermissions(&userCopy, userCopy.OrgID, permissions)
 			}
 
-			authorize(c, ac, &userCopy, evaluator)
+			authorize(c, ac, nil, &userCopy, evaluator)
 
 			// Set the sign-ed in user permissions in that org
 			c.SignedInUser.Permissions[userCopy.OrgID] = userCopy.Permissions[userCopy.OrgID]
+			c.SignedInUser.PermissionConditions = userCopy.PermissionConditions
 		}
 	}
 }
@@ -238,10 +345,7 @@ func LoadPermissionsMiddleware(service Service) web.Handler {
 			return
 		}
 
-		if c.SignedInUser.Permissions == nil {
-			c.SignedInUser.Permissions = make(map[int64]map[string][]string)
-		}
-		c.SignedInUser.Permissions[c.OrgID] = GroupScopesByAction(permissions)
+		SetUserPermissions(c.SignedInUser, c.OrgID, permissions)
 	}
 }
 
@@ -251,6 +355,12 @@ type scopeParams struct {
 	URLParams map[string]string
 }
 
+// ScopeInjector returns a mutator injecting the org and the URL params of a
+// request into templated scopes, see scopeInjector.
+func ScopeInjector(orgID int64, urlParams map[string]string) ScopeAttributeMutator {
+	return scopeInjector(scopeParams{OrgID: orgID, URLParams: urlParams})
+}
+
 // scopeInjector inject request params into the templated scopes. e.g. "settings:" + eval.Parameters(":id")
 func scopeInjector(params scopeParams) ScopeAttributeMutator {
 	return func(_ context.Context, scope string) ([]string, error) {
@@ -1,12 +1,17 @@
 package accesscontrol_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/grafana/grafana/pkg/infra/appcontext"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
@@ -144,3 +149,79 @@ func contextProvider(modifiers ...func(c *models.ReqContext)) web.Handler {
 		c.Req = c.Req.WithContext(ctxkey.Set(c.Req.Context(), reqCtx))
 	}
 }
+
+func TestRequirePermission(t *testing.T) {
+	evaluator := accesscontrol.EvalPermission("users:read", "users:*")
+	signedIn := &user.SignedInUser{UserID: 1, OrgID: 1}
+
+	tests := []struct {
+		desc           string
+		ac             accesscontrol.AccessControl
+		user           *user.SignedInUser
+		expectedStatus int
+		expectEndpoint bool
+	}{
+		{
+			desc:           "should call handler for correct permissions",
+			ac:             mock.New().WithPermissions([]accesscontrol.Permission{{Action: "users:read", Scope: "users:*"}}),
+			user:           signedIn,
+			expectedStatus: http.StatusOK,
+			expectEndpoint: true,
+		},
+		{
+			desc:           "should return 403 when missing permissions",
+			ac:             mock.New().WithPermissions([]accesscontrol.Permission{{Action: "users:read", Scope: "users:1"}}),
+			user:           signedIn,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			desc:           "should return 401 without signed in user",
+			ac:             mock.New(),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			desc:           "should return 401 for anonymous user",
+			ac:             mock.New().WithPermissions([]accesscontrol.Permission{{Action: "users:read", Scope: "users:*"}}),
+			user:           &user.SignedInUser{OrgID: 1, IsAnonymous: true},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			desc: "should return 500 when evaluation fails",
+			ac: &mock.Mock{EvaluateFunc: func(context.Context, *user.SignedInUser, accesscontrol.Evaluator) (bool, error) {
+				return false, errors.New("database is on fire")
+			}},
+			user:           signedIn,
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			endpointCalled := false
+			handler := accesscontrol.RequirePermission(tt.ac, evaluator)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				endpointCalled = true
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.user != nil {
+				request = request.WithContext(appcontext.WithUser(request.Context(), tt.user))
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tt.expectedStatus, recorder.Code)
+			assert.Equal(t, tt.expectEndpoint, endpointCalled)
+
+			if tt.expectedStatus != http.StatusOK {
+				var body map[string]string
+				require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+				assert.NotEmpty(t, body["message"])
+				assert.NotContains(t, recorder.Body.String(), "database is on fire")
+			}
+			if tt.expectedStatus == http.StatusForbidden {
+				assert.Contains(t, recorder.Body.String(), "users:read")
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package accesscontrol
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/grafana/grafana/pkg/infra/tracing"
+	"github.com/grafana/grafana/pkg/services/user"
+)
+
+type fixedAccessControl struct {
+	allowed bool
+	err     error
+}
+
+func (f fixedAccessControl) Evaluate(ctx context.Context, user *user.SignedInUser, evaluator Evaluator, opts ...EvalOption) (bool, error) {
+	return f.allowed, f.err
+}
+
+func (f fixedAccessControl) EvaluateWithTrace(ctx context.Context, user *user.SignedInUser, evaluator Evaluator) (*EvalTrace, error) {
+	return &EvalTrace{Allowed: f.allowed}, f.err
+}
+
+func (f fixedAccessControl) RegisterScopeAttributeResolver(prefix string, resolver ScopeAttributeResolver) {
+}
+
+func (f fixedAccessControl) IsDisabled() bool { return false }
+
+// recordingTracer is a tracing.Tracer recording its spans with an OpenTelemetry
+// span recorder.
+type recordingTracer struct {
+	tracer trace.Tracer
+}
+
+func newRecordingTracer(recorder *tracetest.SpanRecorder) recordingTracer {
+	return recordingTracer{tracer: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")}
+}
+
+func (r recordingTracer) Run(context.Context) error { return nil }
+
+func (r recordingTracer) Start(ctx context.Context, spanName string, opts ...trace.SpanStartOption) (context.Context, tracing.Span) {
+	ctx, span := r.tracer.Start(ctx, spanName, opts...)
+	return ctx, recordingSpan{span: span}
+}
+
+func (r recordingTracer) Inject(context.Context, http.Header, tracing.Span) {}
+
+type recordingSpan struct {
+	span trace.Span
+}
+
+func (s recordingSpan) End() { s.span.End() }
+func (s recordingSpan) SetAttributes(key string, value interface{}, kv attribute.KeyValue) {
+	s.span.SetAttributes(kv)
+}
+func (s recordingSpan) SetName(name string) { s.span.SetName(name) }
+func (s recordingSpan) SetStatus(code codes.Code, description string) {
+	s.span.SetStatus(code, description)
+}
+func (s recordingSpan) RecordError(err error, options ...trace.EventOption) {
+	s.span.RecordError(err, options...)
+}
+func (s recordingSpan) AddEvents(keys []string, values []tracing.EventValue) {}
+
+func TestEvaluateWithSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := newRecordingTracer(recorder)
+
+	usr := &user.SignedInUser{UserID: 2, OrgID: 1}
+	evaluator := EvalPermission("users:read", "users:id:3")
+
+	tests := []struct {
+		desc     string
+		ac       fixedAccessControl
+		expected string
+	}{
+		{desc: "records an allowed evaluation", ac: fixedAccessControl{allowed: true}, expected: "allow"},
+		{desc: "records a denied evaluation", ac: fixedAccessControl{allowed: false}, expected: "deny"},
+		{desc: "records a failed evaluation as denied", ac: fixedAccessControl{allowed: true, err: errors.New("database is down")}, expected: "deny"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			before := len(recorder.Ended())
+			allowed, err := EvaluateWithSpan(context.Background(), tracer, usr, evaluator, func(ctx context.Context) (bool, error) {
+				return tt.ac.Evaluate(ctx, usr, evaluator)
+			})
+			assert.Equal(t, tt.ac.allowed, allowed)
+			assert.Equal(t, tt.ac.err, err)
+
+			spans := recorder.Ended()
+			require.Len(t, spans, before+1)
+			span := spans[len(spans)-1]
+			assert.Equal(t, "accesscontrol.Evaluate", span.Name())
+			assert.ElementsMatch(t, []attribute.KeyValue{
+				attribute.String("permission.action", "users:read"),
+				attribute.String("permission.scope", "users:id:3"),
+				attribute.Int64("user.id", 2),
+				attribute.Int64("org.id", 1),
+				attribute.String("result", tt.expected),
+			}, span.Attributes())
+		})
+	}
+
+	t.Run("evaluates without a span without a tracer", func(t *testing.T) {
+		before := len(recorder.Ended())
+		allowed, err := EvaluateWithSpan(context.Background(), nil, usr, evaluator, func(ctx context.Context) (bool, error) {
+			return true, nil
+		})
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		assert.Len(t, recorder.Ended(), before)
+	})
+}
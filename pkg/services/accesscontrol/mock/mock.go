@@ -16,14 +16,35 @@ type fullAccessControl interface {
 }
 
 type Calls struct {
-	Evaluate                       []interface{}
-	GetUserPermissions             []interface{}
-	IsDisabled                     []interface{}
-	DeclareFixedRoles              []interface{}
-	GetUserBuiltInRoles            []interface{}
-	RegisterFixedRoles             []interface{}
-	RegisterAttributeScopeResolver []interface{}
-	DeleteUserPermissions          []interface{}
+	Evaluate                           []interface{}
+	EvaluateWithTrace                  []interface{}
+	GetUserPermissions                 []interface{}
+	IsDisabled                         []interface{}
+	DeclareFixedRoles                  []interface{}
+	GetUserBuiltInRoles                []interface{}
+	RegisterFixedRoles                 []interface{}
+	RegisterAttributeScopeResolver     []interface{}
+	DeleteUserPermissions              []interface{}
+	RevokeAllUserRoles                 []interface{}
+	CopyUserPermissions                []interface{}
+	DenyPermission                     []interface{}
+	ListDeniedPermissions              []interface{}
+	RevokeDenyRule                     []interface{}
+	GrantTemporaryPermission           []interface{}
+	GetServiceAccountPermissions       []interface{}
+	GrantServiceAccountRole            []interface{}
+	RevokeServiceAccountRole           []interface{}
+	GetSimplifiedUsersPermissionsPaged []interface{}
+	SnapshotPermissions                []interface{}
+	StoreSnapshot                      []interface{}
+	GetSnapshot                        []interface{}
+	ListSnapshots                      []interface{}
+	ImpersonateUser                    []interface{}
+	RegisterPermissionTemplate         []interface{}
+	GetPermissionTemplates             []interface{}
+	ApplyPermissionTemplate            []interface{}
+	GetRoleVersionHistory              []interface{}
+	ListPermissionsForResource         []interface{}
 }
 
 type Mock struct {
@@ -38,14 +59,35 @@ type Mock struct {
 	Calls Calls
 
 	// Override functions
-	EvaluateFunc                       func(context.Context, *user.SignedInUser, accesscontrol.Evaluator) (bool, error)
-	GetUserPermissionsFunc             func(context.Context, *user.SignedInUser, accesscontrol.Options) ([]accesscontrol.Permission, error)
-	IsDisabledFunc                     func() bool
-	DeclareFixedRolesFunc              func(...accesscontrol.RoleRegistration) error
-	GetUserBuiltInRolesFunc            func(user *user.SignedInUser) []string
-	RegisterFixedRolesFunc             func() error
-	RegisterScopeAttributeResolverFunc func(string, accesscontrol.ScopeAttributeResolver)
-	DeleteUserPermissionsFunc          func(context.Context, int64) error
+	EvaluateFunc                           func(context.Context, *user.SignedInUser, accesscontrol.Evaluator) (bool, error)
+	EvaluateWithTraceFunc                  func(context.Context, *user.SignedInUser, accesscontrol.Evaluator) (*accesscontrol.EvalTrace, error)
+	GetUserPermissionsFunc                 func(context.Context, *user.SignedInUser, accesscontrol.Options) ([]accesscontrol.Permission, error)
+	IsDisabledFunc                         func() bool
+	DeclareFixedRolesFunc                  func(...accesscontrol.RoleRegistration) error
+	GetUserBuiltInRolesFunc                func(user *user.SignedInUser) []string
+	RegisterFixedRolesFunc                 func() error
+	RegisterScopeAttributeResolverFunc     func(string, accesscontrol.ScopeAttributeResolver)
+	DeleteUserPermissionsFunc              func(context.Context, int64) error
+	RevokeAllUserRolesFunc                 func(context.Context, int64, int64, int64) (int, error)
+	CopyUserPermissionsFunc                func(context.Context, *accesscontrol.CopyPermissionsCommand) error
+	DenyPermissionFunc                     func(context.Context, *accesscontrol.DenyCommand) error
+	ListDeniedPermissionsFunc              func(context.Context, int64, int64) ([]accesscontrol.DenyRule, error)
+	RevokeDenyRuleFunc                     func(context.Context, int64, int64) error
+	GrantTemporaryPermissionFunc           func(context.Context, *accesscontrol.TemporaryPermissionCommand) error
+	GetServiceAccountPermissionsFunc       func(context.Context, int64, int64) ([]accesscontrol.Permission, error)
+	GrantServiceAccountRoleFunc            func(context.Context, *user.SignedInUser, *accesscontrol.ServiceAccountRoleCommand) error
+	RevokeServiceAccountRoleFunc           func(context.Context, *accesscontrol.ServiceAccountRoleCommand) error
+	GetSimplifiedUsersPermissionsPagedFunc func(context.Context, *user.SignedInUser, int64, string, string, int) (*accesscontrol.PagedPermissions, error)
+	SnapshotPermissionsFunc                func(context.Context, int64) (*accesscontrol.PermissionSnapshot, error)
+	StoreSnapshotFunc                      func(context.Context, *accesscontrol.PermissionSnapshot) error
+	GetSnapshotFunc                        func(context.Context, int64, int64) (*accesscontrol.PermissionSnapshot, error)
+	ListSnapshotsFunc                      func(context.Context, int64) ([]*accesscontrol.SnapshotMeta, error)
+	ImpersonateUserFunc                    func(context.Context, *user.SignedInUser, int64) (*user.SignedInUser, error)
+	RegisterPermissionTemplateFunc         func(accesscontrol.PermissionTemplate) error
+	GetPermissionTemplatesFunc             func() []accesscontrol.PermissionTemplate
+	ApplyPermissionTemplateFunc            func(context.Context, *accesscontrol.ApplyTemplateCommand) ([]accesscontrol.Permission, error)
+	GetRoleVersionHistoryFunc              func(context.Context, int64, string) ([]*accesscontrol.RoleVersion, error)
+	ListPermissionsForResourceFunc         func(context.Context, int64, string, string) ([]accesscontrol.ResourcePermission, error)
 
 	scopeResolvers accesscontrol.Resolvers
 }
@@ -87,8 +129,12 @@ func (m *Mock) WithBuiltInRoles(builtInRoles []string) *Mock {
 
 // Evaluate evaluates access to the given resource.
 // This mock uses GetUserPermissions to then call the evaluator Evaluate function.
-func (m *Mock) Evaluate(ctx context.Context, usr *user.SignedInUser, evaluator accesscontrol.Evaluator) (bool, error) {
+// With WithOrgScope, the user is switched to the org before evaluating.
+func (m *Mock) Evaluate(ctx context.Context, usr *user.SignedInUser, evaluator accesscontrol.Evaluator, opts ...accesscontrol.EvalOption) (bool, error) {
 	m.Calls.Evaluate = append(m.Calls.Evaluate, []interface{}{ctx, usr, evaluator})
+	if options := accesscontrol.NewEvalOptions(opts...); options.OrgID != 0 && options.OrgID != usr.OrgID {
+		usr = accesscontrol.UserInOrg(usr, options.OrgID)
+	}
 	// Use override if provided
 	if m.EvaluateFunc != nil {
 		return m.EvaluateFunc(ctx, usr, evaluator)
@@ -107,7 +153,7 @@ func (m *Mock) Evaluate(ctx context.Context, usr *user.SignedInUser, evaluator a
 		permissions = accesscontrol.GroupScopesByAction(userPermissions)
 	}
 
-	if evaluator.Evaluate(permissions) {
+	if !accesscontrol.ContainsNegation(evaluator) && evaluator.Evaluate(permissions) {
 		return true, nil
 	}
 
@@ -122,6 +168,26 @@ func (m *Mock) Evaluate(ctx context.Context, usr *user.SignedInUser, evaluator a
 	return resolvedEvaluator.Evaluate(permissions), nil
 }
 
+// EvaluateWithTrace traces the evaluation against the mock permissions, without role names.
+// This mock uses Evaluate for the result unless an override is provided.
+func (m *Mock) EvaluateWithTrace(ctx context.Context, usr *user.SignedInUser, evaluator accesscontrol.Evaluator) (*accesscontrol.EvalTrace, error) {
+	m.Calls.EvaluateWithTrace = append(m.Calls.EvaluateWithTrace, []interface{}{ctx, usr, evaluator})
+	// Use override if provided
+	if m.EvaluateWithTraceFunc != nil {
+		return m.EvaluateWithTraceFunc(ctx, usr, evaluator)
+	}
+
+	allowed, err := m.Evaluate(ctx, usr, evaluator)
+	if err != nil {
+		return nil, err
+	}
+	permissions := make([]accesscontrol.RolePermission, 0, len(m.permissions))
+	for _, p := range m.permissions {
+		permissions = append(permissions, accesscontrol.RolePermission{Action: p.Action, Scope: p.Scope})
+	}
+	return accesscontrol.TraceEvaluation(evaluator, permissions, allowed), nil
+}
+
 // GetUserPermissions returns user permissions.
 // This mock return m.permissions unless an override is provided.
 func (m *Mock) GetUserPermissions(ctx context.Context, user *user.SignedInUser, opts accesscontrol.Options) ([]accesscontrol.Permission, error) {
@@ -186,3 +252,183 @@ func (m *Mock) DeleteUserPermissions(ctx context.Context, orgID, userID int64) e
 	}
 	return nil
 }
+
+func (m *Mock) RevokeAllUserRoles(ctx context.Context, orgID, userID, actorID int64) (int, error) {
+	m.Calls.RevokeAllUserRoles = append(m.Calls.RevokeAllUserRoles, []interface{}{ctx, orgID, userID, actorID})
+	// Use override if provided
+	if m.RevokeAllUserRolesFunc != nil {
+		return m.RevokeAllUserRolesFunc(ctx, orgID, userID, actorID)
+	}
+	return 0, nil
+}
+
+func (m *Mock) CopyUserPermissions(ctx context.Context, cmd *accesscontrol.CopyPermissionsCommand) error {
+	m.Calls.CopyUserPermissions = append(m.Calls.CopyUserPermissions, []interface{}{ctx, cmd})
+	// Use override if provided
+	if m.CopyUserPermissionsFunc != nil {
+		return m.CopyUserPermissionsFunc(ctx, cmd)
+	}
+	return nil
+}
+
+func (m *Mock) DenyPermission(ctx context.Context, cmd *accesscontrol.DenyCommand) error {
+	m.Calls.DenyPermission = append(m.Calls.DenyPermission, []interface{}{ctx, cmd})
+	// Use override if provided
+	if m.DenyPermissionFunc != nil {
+		return m.DenyPermissionFunc(ctx, cmd)
+	}
+	return nil
+}
+
+func (m *Mock) ListDeniedPermissions(ctx context.Context, orgID, userID int64) ([]accesscontrol.DenyRule, error) {
+	m.Calls.ListDeniedPermissions = append(m.Calls.ListDeniedPermissions, []interface{}{ctx, orgID, userID})
+	// Use override if provided
+	if m.ListDeniedPermissionsFunc != nil {
+		return m.ListDeniedPermissionsFunc(ctx, orgID, userID)
+	}
+	return []accesscontrol.DenyRule{}, nil
+}
+
+func (m *Mock) RevokeDenyRule(ctx context.Context, orgID, ruleID int64) error {
+	m.Calls.RevokeDenyRule = append(m.Calls.RevokeDenyRule, []interface{}{ctx, orgID, ruleID})
+	// Use override if provided
+	if m.RevokeDenyRuleFunc != nil {
+		return m.RevokeDenyRuleFunc(ctx, orgID, ruleID)
+	}
+	return nil
+}
+
+func (m *Mock) GrantTemporaryPermission(ctx context.Context, cmd *accesscontrol.TemporaryPermissionCommand) error {
+	m.Calls.GrantTemporaryPermission = append(m.Calls.GrantTemporaryPermission, []interface{}{ctx, cmd})
+	// Use override if provided
+	if m.GrantTemporaryPermissionFunc != nil {
+		return m.GrantTemporaryPermissionFunc(ctx, cmd)
+	}
+	return nil
+}
+
+func (m *Mock) GetServiceAccountPermissions(ctx context.Context, orgID, serviceAccountID int64) ([]accesscontrol.Permission, error) {
+	m.Calls.GetServiceAccountPermissions = append(m.Calls.GetServiceAccountPermissions, []interface{}{ctx, orgID, serviceAccountID})
+	// Use override if provided
+	if m.GetServiceAccountPermissionsFunc != nil {
+		return m.GetServiceAccountPermissionsFunc(ctx, orgID, serviceAccountID)
+	}
+	return m.permissions, nil
+}
+
+func (m *Mock) GrantServiceAccountRole(ctx context.Context, granter *user.SignedInUser, cmd *accesscontrol.ServiceAccountRoleCommand) error {
+	m.Calls.GrantServiceAccountRole = append(m.Calls.GrantServiceAccountRole, []interface{}{ctx, granter, cmd})
+	// Use override if provided
+	if m.GrantServiceAccountRoleFunc != nil {
+		return m.GrantServiceAccountRoleFunc(ctx, granter, cmd)
+	}
+	return nil
+}
+
+func (m *Mock) RevokeServiceAccountRole(ctx context.Context, cmd *accesscontrol.ServiceAccountRoleCommand) error {
+	m.Calls.RevokeServiceAccountRole = append(m.Calls.RevokeServiceAccountRole, []interface{}{ctx, cmd})
+	// Use override if provided
+	if m.RevokeServiceAccountRoleFunc != nil {
+		return m.RevokeServiceAccountRoleFunc(ctx, cmd)
+	}
+	return nil
+}
+
+func (m *Mock) GetSimplifiedUsersPermissionsPaged(ctx context.Context, requester *user.SignedInUser, orgID int64, actionPrefix, cursor string, limit int) (*accesscontrol.PagedPermissions, error) {
+	m.Calls.GetSimplifiedUsersPermissionsPaged = append(m.Calls.GetSimplifiedUsersPermissionsPaged, []interface{}{ctx, requester, orgID, actionPrefix, cursor, limit})
+	// Use override if provided
+	if m.GetSimplifiedUsersPermissionsPagedFunc != nil {
+		return m.GetSimplifiedUsersPermissionsPagedFunc(ctx, requester, orgID, actionPrefix, cursor, limit)
+	}
+	return &accesscontrol.PagedPermissions{Data: map[int64][]string{}}, nil
+}
+
+func (m *Mock) SnapshotPermissions(ctx context.Context, orgID int64) (*accesscontrol.PermissionSnapshot, error) {
+	m.Calls.SnapshotPermissions = append(m.Calls.SnapshotPermissions, []interface{}{ctx, orgID})
+	// Use override if provided
+	if m.SnapshotPermissionsFunc != nil {
+		return m.SnapshotPermissionsFunc(ctx, orgID)
+	}
+	return &accesscontrol.PermissionSnapshot{OrgID: orgID, Permissions: map[int64][]accesscontrol.Permission{}}, nil
+}
+
+func (m *Mock) StoreSnapshot(ctx context.Context, snap *accesscontrol.PermissionSnapshot) error {
+	m.Calls.StoreSnapshot = append(m.Calls.StoreSnapshot, []interface{}{ctx, snap})
+	// Use override if provided
+	if m.StoreSnapshotFunc != nil {
+		return m.StoreSnapshotFunc(ctx, snap)
+	}
+	return nil
+}
+
+func (m *Mock) GetSnapshot(ctx context.Context, orgID, snapshotID int64) (*accesscontrol.PermissionSnapshot, error) {
+	m.Calls.GetSnapshot = append(m.Calls.GetSnapshot, []interface{}{ctx, orgID, snapshotID})
+	// Use override if provided
+	if m.GetSnapshotFunc != nil {
+		return m.GetSnapshotFunc(ctx, orgID, snapshotID)
+	}
+	return nil, accesscontrol.ErrSnapshotNotFound
+}
+
+func (m *Mock) ListSnapshots(ctx context.Context, orgID int64) ([]*accesscontrol.SnapshotMeta, error) {
+	m.Calls.ListSnapshots = append(m.Calls.ListSnapshots, []interface{}{ctx, orgID})
+	// Use override if provided
+	if m.ListSnapshotsFunc != nil {
+		return m.ListSnapshotsFunc(ctx, orgID)
+	}
+	return []*accesscontrol.SnapshotMeta{}, nil
+}
+
+func (m *Mock) ImpersonateUser(ctx context.Context, admin *user.SignedInUser, targetUserID int64) (*user.SignedInUser, error) {
+	m.Calls.ImpersonateUser = append(m.Calls.ImpersonateUser, []interface{}{ctx, admin, targetUserID})
+	// Use override if provided
+	if m.ImpersonateUserFunc != nil {
+		return m.ImpersonateUserFunc(ctx, admin, targetUserID)
+	}
+	return nil, accesscontrol.ErrImpersonationDenied
+}
+
+func (m *Mock) RegisterPermissionTemplate(tmpl accesscontrol.PermissionTemplate) error {
+	m.Calls.RegisterPermissionTemplate = append(m.Calls.RegisterPermissionTemplate, []interface{}{tmpl})
+	// Use override if provided
+	if m.RegisterPermissionTemplateFunc != nil {
+		return m.RegisterPermissionTemplateFunc(tmpl)
+	}
+	return nil
+}
+
+func (m *Mock) GetPermissionTemplates() []accesscontrol.PermissionTemplate {
+	m.Calls.GetPermissionTemplates = append(m.Calls.GetPermissionTemplates, []interface{}{})
+	// Use override if provided
+	if m.GetPermissionTemplatesFunc != nil {
+		return m.GetPermissionTemplatesFunc()
+	}
+	return []accesscontrol.PermissionTemplate{}
+}
+
+func (m *Mock) ApplyPermissionTemplate(ctx context.Context, cmd *accesscontrol.ApplyTemplateCommand) ([]accesscontrol.Permission, error) {
+	m.Calls.ApplyPermissionTemplate = append(m.Calls.ApplyPermissionTemplate, []interface{}{ctx, cmd})
+	// Use override if provided
+	if m.ApplyPermissionTemplateFunc != nil {
+		return m.ApplyPermissionTemplateFunc(ctx, cmd)
+	}
+	return nil, accesscontrol.ErrTemplateNotFound
+}
+
+func (m *Mock) GetRoleVersionHistory(ctx context.Context, orgID int64, roleUID string) ([]*accesscontrol.RoleVersion, error) {
+	m.Calls.GetRoleVersionHistory = append(m.Calls.GetRoleVersionHistory, []interface{}{ctx, orgID, roleUID})
+	// Use override if provided
+	if m.GetRoleVersionHistoryFunc != nil {
+		return m.GetRoleVersionHistoryFunc(ctx, orgID, roleUID)
+	}
+	return nil, accesscontrol.ErrRoleNotFound
+}
+
+func (m *Mock) ListPermissionsForResource(ctx context.Context, orgID int64, resourceType, resourceUID string) ([]accesscontrol.ResourcePermission, error) {
+	m.Calls.ListPermissionsForResource = append(m.Calls.ListPermissionsForResource, []interface{}{ctx, orgID, resourceType, resourceUID})
+	// Use override if provided
+	if m.ListPermissionsForResourceFunc != nil {
+		return m.ListPermissionsForResourceFunc(ctx, orgID, resourceType, resourceUID)
+	}
+	return nil, nil
+}
@@ -15,6 +15,9 @@ import (
 type RoleRegistration struct {
 	Role   RoleDTO
 	Grants []string
+	// FeatureFlag, if set, is the feature toggle that must be enabled for the
+	// assignments to grant the permissions of the role.
+	FeatureFlag string
 }
 
 // Role is the model for Role in RBAC.
@@ -29,14 +32,31 @@ type Role struct {
 	Description string `json:"description"`
 	Hidden      bool   `json:"hidden"`
 
-	Updated time.Time `json:"updated"`
-	Created time.Time `json:"created"`
+	// UpdatedBy is the ID of the user who last changed the permissions of the role.
+	UpdatedBy int64     `xorm:"updated_by" json:"updatedBy"`
+	Updated   time.Time `json:"updated"`
+	Created   time.Time `json:"created"`
+}
+
+// RoleVersionHistoryLimit is the number of versions of a role that are kept.
+const RoleVersionHistoryLimit = 20
+
+// RoleVersion is the permissions of a role after a change.
+type RoleVersion struct {
+	Version     int64        `json:"version"`
+	Permissions []Permission `json:"permissions"`
+	UpdatedBy   int64        `json:"updatedBy"`
+	Updated     time.Time    `json:"updated"`
 }
 
 func (r *Role) Global() bool {
 	return r.OrgID == GlobalOrgID
 }
 
+func (r *Role) IsManaged() bool {
+	return strings.HasPrefix(r.Name, ManagedRolePrefix)
+}
+
 func (r *Role) IsFixed() bool {
 	return strings.HasPrefix(r.Name, FixedRolePrefix)
 }
@@ -178,6 +198,8 @@ type UserRole struct {
 	OrgID  int64 `json:"orgId" xorm:"org_id"`
 	RoleID int64 `json:"roleId" xorm:"role_id"`
 	UserID int64 `json:"userId" xorm:"user_id"`
+	// IsServiceAccount is set on the assignments of roles to service accounts.
+	IsServiceAccount bool `json:"isServiceAccount" xorm:"is_service_account"`
 
 	Created time.Time
 }
@@ -198,6 +220,8 @@ type Permission struct {
 	RoleID int64  `json:"-" xorm:"role_id"`
 	Action string `json:"action"`
 	Scope  string `json:"scope"`
+	// Conditions restrict when the permission applies, see ConditionEvaluator
+	Conditions map[string]string `json:"conditions,omitempty" xorm:"-"`
 
 	Updated time.Time `json:"updated"`
 	Created time.Time `json:"created"`
@@ -210,12 +234,114 @@ func (p Permission) OSSPermission() Permission {
 	}
 }
 
+// PermissionSnapshot is a point-in-time record of the permissions every user
+// of an organization holds.
+type PermissionSnapshot struct {
+	ID        int64     `json:"id"`
+	OrgID     int64     `json:"orgId"`
+	Timestamp time.Time `json:"timestamp"`
+	// Permissions maps user IDs to the permissions they held when the snapshot was taken.
+	Permissions map[int64][]Permission `json:"permissions"`
+}
+
+// SnapshotMeta describes a stored permission snapshot without its content.
+type SnapshotMeta struct {
+	ID        int64     `json:"id"`
+	OrgID     int64     `json:"orgId"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// RoleAssignmentAudit records a change to the role assignments of a user.
+type RoleAssignmentAudit struct {
+	ID      int64     `json:"id" xorm:"pk autoincr 'id'"`
+	OrgID   int64     `json:"orgId" xorm:"org_id"`
+	UserID  int64     `json:"userId" xorm:"user_id"`
+	RoleID  int64     `json:"roleId" xorm:"role_id"`
+	RoleUID string    `json:"roleUid" xorm:"role_uid"`
+	Action  string    `json:"action" xorm:"action"`
+	Created time.Time `json:"created"`
+	// ActorID is the ID of the user who made the change.
+	ActorID int64 `json:"actorId" xorm:"actor_id"`
+	// ImpersonatedBy is the ID of the admin who made the change while
+	// impersonating another user, or zero.
+	ImpersonatedBy int64 `json:"impersonatedBy,omitempty" xorm:"impersonated_by"`
+}
+
+func (RoleAssignmentAudit) TableName() string {
+	return "role_assignment_audit"
+}
+
+const (
+	RoleAssignmentAuditActionRevoke = "revoke"
+)
+
+// PermissionSnapshotDiff holds the permissions that were granted and revoked,
+// per user, between two permission snapshots.
+type PermissionSnapshotDiff struct {
+	From    int64                  `json:"from"`
+	To      int64                  `json:"to"`
+	Added   map[int64][]Permission `json:"added"`
+	Removed map[int64][]Permission `json:"removed"`
+}
+
+// DiffPermissionSnapshots computes the permissions added and removed when going
+// from one snapshot to the other. Only action and scope are compared.
+func DiffPermissionSnapshots(from, to *PermissionSnapshot) *PermissionSnapshotDiff {
+	diff := &PermissionSnapshotDiff{
+		From:    from.ID,
+		To:      to.ID,
+		Added:   map[int64][]Permission{},
+		Removed: map[int64][]Permission{},
+	}
+
+	for userID, permissions := range to.Permissions {
+		if added := subtractPermissions(permissions, from.Permissions[userID]); len(added) > 0 {
+			diff.Added[userID] = added
+		}
+	}
+	for userID, permissions := range from.Permissions {
+		if removed := subtractPermissions(permissions, to.Permissions[userID]); len(removed) > 0 {
+			diff.Removed[userID] = removed
+		}
+	}
+
+	return diff
+}
+
+// subtractPermissions returns the permissions in a that are not in b.
+func subtractPermissions(a, b []Permission) []Permission {
+	seen := make(map[[2]string]struct{}, len(b))
+	for _, p := range b {
+		seen[[2]string{p.Action, p.Scope}] = struct{}{}
+	}
+
+	var result []Permission
+	for _, p := range a {
+		if _, ok := seen[[2]string{p.Action, p.Scope}]; !ok {
+			result = append(result, p.OSSPermission())
+		}
+	}
+	return result
+}
+
+// CopyPermissionsCommand copies the role assignments of SourceUserID to
+// TargetUserID in OrgID. With Overwrite, role assignments of the target that
+// the source does not have are removed.
+type CopyPermissionsCommand struct {
+	OrgID        int64 `json:"-"`
+	SourceUserID int64 `json:"sourceUserId"`
+	TargetUserID int64 `json:"targetUserId"`
+	Overwrite    bool  `json:"overwrite"`
+}
+
 type GetUserPermissionsQuery struct {
-	OrgID   int64 `json:"-"`
-	UserID  int64 `json:"userId"`
-	Roles   []string
-	Actions []string
-	TeamIDs []int64
+	OrgID        int64 `json:"-"`
+	UserID       int64 `json:"userId"`
+	Roles        []string
+	Actions      []string
+	ActionPrefix string
+	Scope        string
+	TeamIDs      []int64
 }
 
 // ResourcePermission is structure that holds all actions that either a team / user / builtin-role
@@ -300,10 +426,12 @@ const (
 	ActionUsersCreate            = "users:create"
 	ActionUsersEnable            = "users:enable"
 	ActionUsersDisable           = "users:disable"
+	ActionUsersPermissionsRead   = "users.permissions:read"
 	ActionUsersPermissionsUpdate = "users.permissions:write"
 	ActionUsersLogout            = "users:logout"
 	ActionUsersQuotasList        = "users.quotas:read"
 	ActionUsersQuotasUpdate      = "users.quotas:write"
+	ActionImpersonateUsers       = "users:impersonate"
 
 	// Org actions
 	ActionOrgsRead             = "orgs:read"
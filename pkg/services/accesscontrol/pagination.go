@@ -0,0 +1,70 @@
+package accesscontrol
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+const (
+	// PermissionsCursorTTL is how long a permissions page cursor stays valid.
+	PermissionsCursorTTL = time.Hour
+
+	DefaultPermissionsPageLimit = 100
+	MaxPermissionsPageLimit     = 1000
+)
+
+// PagedPermissions is a page of the actions held by the users of an org,
+// keyed by user ID. The cursors are empty when there is no page in that
+// direction.
+type PagedPermissions struct {
+	Data       map[int64][]string `json:"data"`
+	NextCursor string             `json:"nextCursor,omitempty"`
+	PrevCursor string             `json:"prevCursor,omitempty"`
+}
+
+// PermissionsCursor points at a user ID in a paginated list of users
+// permissions. Forward cursors select the users after UserID, backward cursors
+// the users before it.
+type PermissionsCursor struct {
+	UserID    int64
+	Timestamp time.Time
+	Backward  bool
+}
+
+// Encode returns the opaque representation of the cursor.
+func (c PermissionsCursor) Encode() string {
+	direction := "next"
+	if c.Backward {
+		direction = "prev"
+	}
+	raw := fmt.Sprintf("%s:%d:%d", direction, c.UserID, c.Timestamp.Unix())
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodePermissionsCursor parses a cursor returned by Encode. It returns
+// ErrInvalidCursor if the cursor is malformed or older than PermissionsCursorTTL.
+func DecodePermissionsCursor(cursor string, now time.Time) (PermissionsCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return PermissionsCursor{}, ErrInvalidCursor
+	}
+
+	var (
+		direction string
+		userID    int64
+		timestamp int64
+	)
+	if _, err := fmt.Sscanf(string(raw), "%4s:%d:%d", &direction, &userID, &timestamp); err != nil {
+		return PermissionsCursor{}, ErrInvalidCursor
+	}
+	if direction != "next" && direction != "prev" {
+		return PermissionsCursor{}, ErrInvalidCursor
+	}
+
+	c := PermissionsCursor{UserID: userID, Timestamp: time.Unix(timestamp, 0), Backward: direction == "prev"}
+	if now.Sub(c.Timestamp) > PermissionsCursorTTL {
+		return PermissionsCursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,132 @@
+package accesscontrol
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PermissionSet is a list of permissions treated as a set, where permissions
+// with the same action, scope and conditions are the same element.
+type PermissionSet []Permission
+
+// permissionKey identifies the permissions that are the same element of a set.
+type permissionKey struct {
+	action     string
+	scope      string
+	conditions string
+}
+
+func keyOf(p Permission) permissionKey {
+	return permissionKey{action: p.Action, scope: p.Scope, conditions: conditionsKey(p.Conditions)}
+}
+
+// conditionsKey encodes the conditions in the order of their names, so that
+// equal conditions have the same key.
+func conditionsKey(conditions map[string]string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(conditions))
+	for name := range conditions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(strconv.Quote(name))
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(conditions[name]))
+		b.WriteByte(';')
+	}
+	return b.String()
+}
+
+func keysOf(permissions []Permission) map[permissionKey]struct{} {
+	keys := make(map[permissionKey]struct{}, len(permissions))
+	for _, p := range permissions {
+		keys[keyOf(p)] = struct{}{}
+	}
+	return keys
+}
+
+// Union returns the permissions that are in any of sets, in the order they
+// first appear.
+func Union(sets ...[]Permission) []Permission {
+	var size int
+	for _, set := range sets {
+		size += len(set)
+	}
+
+	res := make([]Permission, 0, size)
+	seen := make(map[permissionKey]struct{}, size)
+	for _, set := range sets {
+		for _, p := range set {
+			key := keyOf(p)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Intersect returns the permissions of the first set that are in all of the
+// other sets, in their order in the first set and without duplicates.
+func Intersect(sets ...[]Permission) []Permission {
+	if len(sets) == 0 {
+		return []Permission{}
+	}
+
+	others := make([]map[permissionKey]struct{}, 0, len(sets)-1)
+	for _, set := range sets[1:] {
+		others = append(others, keysOf(set))
+	}
+
+	res := make([]Permission, 0)
+	for _, p := range Union(sets[0]) {
+		key := keyOf(p)
+		inAll := true
+		for _, other := range others {
+			if _, ok := other[key]; !ok {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Subtract returns the permissions of base that are not in remove, in their
+// order in base and without duplicates.
+func Subtract(base, remove []Permission) []Permission {
+	removed := keysOf(remove)
+	res := make([]Permission, 0, len(base))
+	for _, p := range Union(base) {
+		if _, ok := removed[keyOf(p)]; !ok {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Union returns the union of s and others, see Union.
+func (s PermissionSet) Union(others ...[]Permission) PermissionSet {
+	return Union(append([][]Permission{s}, others...)...)
+}
+
+// Intersect returns the intersection of s and others, see Intersect.
+func (s PermissionSet) Intersect(others ...[]Permission) PermissionSet {
+	return Intersect(append([][]Permission{s}, others...)...)
+}
+
+// Subtract returns the permissions of s that are not in remove, see Subtract.
+func (s PermissionSet) Subtract(remove []Permission) PermissionSet {
+	return Subtract(s, remove)
+}
@@ -0,0 +1,117 @@
+package accesscontrol
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"pgregory.net/rapid"
+)
+
+func TestUnion(t *testing.T) {
+	a := []Permission{{Action: "teams:read", Scope: "teams:id:1"}, {Action: "teams:read", Scope: "teams:id:1"}}
+	b := []Permission{{Action: "teams:read", Scope: "teams:id:2"}, {Action: "teams:read", Scope: "teams:id:1"}}
+	assert.Equal(t, []Permission{{Action: "teams:read", Scope: "teams:id:1"}, {Action: "teams:read", Scope: "teams:id:2"}}, Union(a, b))
+	assert.Empty(t, Union())
+
+	t.Run("should keep permissions with different conditions", func(t *testing.T) {
+		conditional := []Permission{
+			{Action: "teams:read", Scope: "teams:id:1", Conditions: map[string]string{"ip": "10.0.0.0/8"}},
+			{Action: "teams:read", Scope: "teams:id:1", Conditions: map[string]string{"ip": "10.0.0.0/8"}},
+		}
+		assert.Equal(t, []Permission{conditional[0], a[0]}, Union(conditional, a))
+		assert.Equal(t, []Permission{a[0], conditional[0]}, Union(a, conditional))
+	})
+}
+
+func TestIntersect(t *testing.T) {
+	a := []Permission{{Action: "teams:read", Scope: "teams:id:1"}, {Action: "teams:read", Scope: "teams:id:2"}, {Action: "teams:read", Scope: "teams:id:1"}}
+	b := []Permission{{Action: "teams:read", Scope: "teams:id:1"}, {Action: "teams:write", Scope: "teams:id:2"}}
+	assert.Equal(t, []Permission{{Action: "teams:read", Scope: "teams:id:1"}}, Intersect(a, b))
+	assert.Equal(t, []Permission{{Action: "teams:read", Scope: "teams:id:1"}, {Action: "teams:read", Scope: "teams:id:2"}}, Intersect(a))
+	assert.Empty(t, Intersect())
+	assert.Empty(t, Intersect(a, nil))
+}
+
+func TestSubtract(t *testing.T) {
+	a := []Permission{{Action: "teams:read", Scope: "teams:id:1"}, {Action: "teams:read", Scope: "teams:id:2"}, {Action: "teams:read", Scope: "teams:id:2"}}
+	b := []Permission{{Action: "teams:read", Scope: "teams:id:1"}, {Action: "teams:write", Scope: "teams:id:2"}}
+	assert.Equal(t, []Permission{{Action: "teams:read", Scope: "teams:id:2"}}, Subtract(a, b))
+	assert.Equal(t, []Permission{{Action: "teams:read", Scope: "teams:id:1"}, {Action: "teams:read", Scope: "teams:id:2"}}, Subtract(a, nil))
+}
+
+func TestPermissionSet(t *testing.T) {
+	s := PermissionSet{{Action: "teams:read", Scope: "teams:id:1"}}
+	other := []Permission{{Action: "teams:read", Scope: "teams:id:2"}}
+	assert.Equal(t, PermissionSet(Union(s, other)), s.Union(other))
+	assert.Equal(t, PermissionSet(Intersect(s, other)), s.Intersect(other))
+	assert.Equal(t, PermissionSet(Subtract(s, other)), s.Subtract(other))
+}
+
+// permissionsGen draws permissions from a small pool of actions, scopes and
+// conditions, so that sets drawn independently overlap.
+var permissionsGen = rapid.SliceOf(rapid.Custom(func(t *rapid.T) Permission {
+	return Permission{
+		Action: rapid.SampledFrom([]string{"teams:read", "teams:write", "users:read"}).Draw(t, "action"),
+		Scope:  rapid.SampledFrom([]string{"teams:id:1", "teams:id:2", "users:*", ""}).Draw(t, "scope"),
+		Conditions: rapid.SampledFrom([]map[string]string{
+			nil,
+			{"ip": "10.0.0.0/8"},
+			{"ip": "10.0.0.0/8", "hours": "9-17"},
+		}).Draw(t, "conditions"),
+	}
+}))
+
+// asSet returns the sorted action, scope and conditions of permissions, without duplicates.
+func asSet(permissions []Permission) []string {
+	keys := make([]string, 0, len(permissions))
+	for key := range keysOf(permissions) {
+		keys = append(keys, key.action+" "+key.scope+" "+key.conditions)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestPermissionSetProperties(t *testing.T) {
+	t.Run("operations deduplicate", rapid.MakeCheck(func(t *rapid.T) {
+		a := permissionsGen.Draw(t, "a")
+		b := permissionsGen.Draw(t, "b")
+		for _, res := range [][]Permission{Union(a, b), Intersect(a, b), Subtract(a, b)} {
+			require.Len(t, res, len(asSet(res)))
+		}
+	}))
+
+	t.Run("union and intersection are idempotent", rapid.MakeCheck(func(t *rapid.T) {
+		a := permissionsGen.Draw(t, "a")
+		require.Equal(t, Union(a), Union(a, a))
+		require.Equal(t, Union(a), Intersect(a, a))
+		require.Equal(t, Union(a), Union(Union(a)))
+	}))
+
+	t.Run("intersection distributes over union", rapid.MakeCheck(func(t *rapid.T) {
+		a := permissionsGen.Draw(t, "a")
+		b := permissionsGen.Draw(t, "b")
+		c := permissionsGen.Draw(t, "c")
+		require.Equal(t, asSet(Union(Intersect(a, b), Intersect(a, c))), asSet(Intersect(a, Union(b, c))))
+		require.Equal(t, asSet(Intersect(Union(a, b), Union(a, c))), asSet(Union(a, Intersect(b, c))))
+	}))
+
+	t.Run("permissions with different conditions are different elements", rapid.MakeCheck(func(t *rapid.T) {
+		a := permissionsGen.Draw(t, "a")
+		conditional := make([]Permission, 0, len(a))
+		for _, p := range a {
+			conditional = append(conditional, Permission{Action: p.Action, Scope: p.Scope, Conditions: map[string]string{"org": "other"}})
+		}
+		require.Len(t, Union(a, conditional), len(Union(a))+len(Union(conditional)))
+		require.Empty(t, Intersect(a, conditional))
+		require.Equal(t, Union(a), Subtract(a, conditional))
+	}))
+
+	t.Run("subtraction removes the intersection", rapid.MakeCheck(func(t *rapid.T) {
+		a := permissionsGen.Draw(t, "a")
+		b := permissionsGen.Draw(t, "b")
+		require.Empty(t, Intersect(Subtract(a, b), b))
+		require.Equal(t, asSet(a), asSet(Union(Subtract(a, b), Intersect(a, b))))
+	}))
+}
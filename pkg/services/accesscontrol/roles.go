@@ -117,6 +117,10 @@ var (
 				Action: ActionUsersQuotasList,
 				Scope:  ScopeGlobalUsersAll,
 			},
+			{
+				Action: ActionUsersPermissionsRead,
+				Scope:  ScopeGlobalUsersAll,
+			},
 		},
 	}
 
@@ -167,6 +171,19 @@ var (
 			},
 		}),
 	}
+
+	usersImpersonatorRole = RoleDTO{
+		Name:        "fixed:users:impersonator",
+		DisplayName: "User impersonator",
+		Description: "Act as any other user of the organization, with that user's permissions.",
+		Group:       "User administration (global)",
+		Permissions: []Permission{
+			{
+				Action: ActionImpersonateUsers,
+				Scope:  ScopeGlobalUsersAll,
+			},
+		},
+	}
 )
 
 // Declare OSS roles to the accesscontrol service
@@ -203,9 +220,13 @@ func DeclareFixedRoles(service Service) error {
 		Role:   usersWriterRole,
 		Grants: []string{RoleGrafanaAdmin},
 	}
+	usersImpersonator := RoleRegistration{
+		Role:   usersImpersonatorRole,
+		Grants: []string{RoleGrafanaAdmin},
+	}
 
 	return service.DeclareFixedRoles(ldapReader, ldapWriter, orgUsersReader, orgUsersWriter,
-		settingsReader, statsReader, usersReader, usersWriter)
+		settingsReader, statsReader, usersReader, usersWriter, usersImpersonator)
 }
 
 func ConcatPermissions(permissions ...[]Permission) []Permission {
@@ -0,0 +1,316 @@
+package accesscontrol
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ScopeExpr is a compound scope expression, such as
+// `datasources:uid:A AND (teams:id:5 OR teams:id:6)`. AND binds tighter than
+// OR, and parentheses group sub-expressions.
+type ScopeExpr interface {
+	// String returns the expression in the syntax read by ParseScopeExpression
+	fmt.Stringer
+	evaluate(scopes []string) bool
+}
+
+// LeafScope is a single scope, matched like the scopes of EvalPermission.
+type LeafScope struct {
+	Scope string
+}
+
+// AndExpr requires all of its operands to match.
+type AndExpr struct {
+	Operands []ScopeExpr
+}
+
+// OrExpr requires at least one of its operands to match.
+type OrExpr struct {
+	Operands []ScopeExpr
+}
+
+func (l LeafScope) String() string {
+	return l.Scope
+}
+
+func (l LeafScope) evaluate(scopes []string) bool {
+	for _, scope := range scopes {
+		if match(scope, l.Scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a AndExpr) String() string {
+	return joinScopeExprs(a.Operands, " AND ")
+}
+
+func (a AndExpr) evaluate(scopes []string) bool {
+	for _, o := range a.Operands {
+		if !o.evaluate(scopes) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o OrExpr) String() string {
+	return joinScopeExprs(o.Operands, " OR ")
+}
+
+func (o OrExpr) evaluate(scopes []string) bool {
+	for _, op := range o.Operands {
+		if op.evaluate(scopes) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinScopeExprs(operands []ScopeExpr, sep string) string {
+	parts := make([]string, 0, len(operands))
+	for _, o := range operands {
+		if _, ok := o.(LeafScope); ok {
+			parts = append(parts, o.String())
+		} else {
+			parts = append(parts, "("+o.String()+")")
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+// EvaluateScopeExpr returns whether the scopes of perms satisfy expr. The
+// actions of perms are not checked, callers are expected to pass the
+// permissions of a single action.
+func EvaluateScopeExpr(expr ScopeExpr, perms []Permission) bool {
+	scopes := make([]string, 0, len(perms))
+	for _, p := range perms {
+		scopes = append(scopes, p.Scope)
+	}
+	return expr.evaluate(scopes)
+}
+
+var _ Evaluator = new(scopeExprEvaluator)
+
+// EvalPermissionExpr returns an evaluator that requires the action on the
+// scopes combined by expr, a compound scope expression parsed with
+// ParseScopeExpression, e.g. `datasources:uid:A AND teams:id:5`. The expression
+// is parsed once: scopes mutated or injected later are never read as
+// expression syntax.
+func EvalPermissionExpr(action, expr string) (Evaluator, error) {
+	e, err := ParseScopeExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	return scopeExprEvaluator{Action: action, Expr: e}, nil
+}
+
+type scopeExprEvaluator struct {
+	Action string
+	Expr   ScopeExpr
+}
+
+func (s scopeExprEvaluator) Evaluate(permissions map[string][]string) bool {
+	userScopes, ok := permissions[s.Action]
+	if !ok {
+		return false
+	}
+	return s.Expr.evaluate(userScopes)
+}
+
+func (s scopeExprEvaluator) MutateScopes(ctx context.Context, mutate ScopeAttributeMutator) (Evaluator, error) {
+	e, err := mutateScopeExpr(ctx, s.Expr, mutate)
+	if err != nil {
+		return nil, err
+	}
+	return scopeExprEvaluator{Action: s.Action, Expr: e}, nil
+}
+
+func (s scopeExprEvaluator) String() string {
+	return s.Action
+}
+
+func (s scopeExprEvaluator) GoString() string {
+	return fmt.Sprintf("action:%s scopes:%s", s.Action, s.Expr.String())
+}
+
+// leafScopes returns the scopes combined by the expression.
+func leafScopes(e ScopeExpr) []string {
+	var operands []ScopeExpr
+	switch e := e.(type) {
+	case LeafScope:
+		return []string{e.Scope}
+	case AndExpr:
+		operands = e.Operands
+	case OrExpr:
+		operands = e.Operands
+	}
+	var scopes []string
+	for _, o := range operands {
+		scopes = append(scopes, leafScopes(o)...)
+	}
+	return scopes
+}
+
+// ParseScopeExpression parses a compound scope expression. Operands are
+// scopes separated from the AND and OR operators by white space, and may be
+// injectable scopes built with Parameter or Field.
+func ParseScopeExpression(expr string) (ScopeExpr, error) {
+	p := &scopeExprParser{tokens: tokenizeScopeExpr(expr)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("%w: empty expression", ErrInvalidScopeExpression)
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidScopeExpression, p.tokens[p.pos])
+	}
+	return e, nil
+}
+
+// tokenizeScopeExpr splits expr into parentheses, operators and scopes. The
+// template parts of injectable scopes, e.g. Parameter(":id"), are kept whole.
+func tokenizeScopeExpr(expr string) []string {
+	var tokens []string
+	var scope strings.Builder
+	flush := func() {
+		if scope.Len() > 0 {
+			tokens = append(tokens, scope.String())
+			scope.Reset()
+		}
+	}
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case strings.HasPrefix(expr[i:], "{{"):
+			end := strings.Index(expr[i:], "}}")
+			if end < 0 {
+				end = len(expr) - i - 2
+			}
+			scope.WriteString(expr[i : i+end+2])
+			i += end + 1
+		case c == '(' || c == ')':
+			flush()
+			tokens = append(tokens, string(c))
+		case c == ' ' || c == '\t' || c == '\n':
+			flush()
+		default:
+			scope.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type scopeExprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *scopeExprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *scopeExprParser) parseOr() (ScopeExpr, error) {
+	operands, err := p.parseOperands("OR", p.parseAnd)
+	if err != nil {
+		return nil, err
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return OrExpr{Operands: operands}, nil
+}
+
+func (p *scopeExprParser) parseAnd() (ScopeExpr, error) {
+	operands, err := p.parseOperands("AND", p.parseOperand)
+	if err != nil {
+		return nil, err
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return AndExpr{Operands: operands}, nil
+}
+
+func (p *scopeExprParser) parseOperands(operator string, parse func() (ScopeExpr, error)) ([]ScopeExpr, error) {
+	var operands []ScopeExpr
+	for {
+		e, err := parse()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, e)
+		if p.peek() != operator {
+			return operands, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *scopeExprParser) parseOperand() (ScopeExpr, error) {
+	switch token := p.peek(); token {
+	case "":
+		return nil, fmt.Errorf("%w: unexpected end of expression", ErrInvalidScopeExpression)
+	case "AND", "OR", ")":
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidScopeExpression, token)
+	case "(":
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("%w: missing closing parenthesis", ErrInvalidScopeExpression)
+		}
+		p.pos++
+		return e, nil
+	default:
+		p.pos++
+		return LeafScope{Scope: token}, nil
+	}
+}
+
+// mutateScopeExpr applies mutate to every scope of the expression, replacing
+// scopes mutated into several scopes by their disjunction.
+func mutateScopeExpr(ctx context.Context, e ScopeExpr, mutate ScopeAttributeMutator) (ScopeExpr, error) {
+	var operands []ScopeExpr
+	switch e := e.(type) {
+	case LeafScope:
+		scopes, err := mutate(ctx, e.Scope)
+		if err != nil {
+			return nil, err
+		}
+		if len(scopes) == 1 {
+			return LeafScope{Scope: scopes[0]}, nil
+		}
+		or := OrExpr{}
+		for _, scope := range scopes {
+			or.Operands = append(or.Operands, LeafScope{Scope: scope})
+		}
+		return or, nil
+	case AndExpr:
+		operands = e.Operands
+	case OrExpr:
+		operands = e.Operands
+	}
+
+	mutated := make([]ScopeExpr, 0, len(operands))
+	for _, o := range operands {
+		m, err := mutateScopeExpr(ctx, o, mutate)
+		if err != nil {
+			return nil, err
+		}
+		mutated = append(mutated, m)
+	}
+	if _, ok := e.(AndExpr); ok {
+		return AndExpr{Operands: mutated}, nil
+	}
+	return OrExpr{Operands: mutated}, nil
+}
@@ -0,0 +1,148 @@
+package accesscontrol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScopeExpression(t *testing.T) {
+	tests := []struct {
+		desc     string
+		expr     string
+		expected ScopeExpr
+	}{
+		{
+			desc:     "should parse a single scope",
+			expr:     "datasources:uid:A",
+			expected: LeafScope{Scope: "datasources:uid:A"},
+		},
+		{
+			desc: "should parse AND",
+			expr: "datasources:uid:A AND teams:id:5",
+			expected: AndExpr{Operands: []ScopeExpr{
+				LeafScope{Scope: "datasources:uid:A"}, LeafScope{Scope: "teams:id:5"},
+			}},
+		},
+		{
+			desc: "should bind AND tighter than OR",
+			expr: "teams:id:1 OR datasources:uid:A AND teams:id:5",
+			expected: OrExpr{Operands: []ScopeExpr{
+				LeafScope{Scope: "teams:id:1"},
+				AndExpr{Operands: []ScopeExpr{LeafScope{Scope: "datasources:uid:A"}, LeafScope{Scope: "teams:id:5"}}},
+			}},
+		},
+		{
+			desc: "should group with parentheses",
+			expr: "(teams:id:1 OR teams:id:2) AND datasources:uid:A",
+			expected: AndExpr{Operands: []ScopeExpr{
+				OrExpr{Operands: []ScopeExpr{LeafScope{Scope: "teams:id:1"}, LeafScope{Scope: "teams:id:2"}}},
+				LeafScope{Scope: "datasources:uid:A"},
+			}},
+		},
+		{
+			desc: "should keep injectable scopes whole",
+			expr: Scope("reports", Parameter(":reportId")) + " AND " + Scope("orgs", Field("OrgID")),
+			expected: AndExpr{Operands: []ScopeExpr{
+				LeafScope{Scope: `reports:{{ index .URLParams ":reportId" }}`}, LeafScope{Scope: "orgs:{{ .OrgID }}"},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			expr, err := ParseScopeExpression(test.expr)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, expr)
+
+			reparsed, err := ParseScopeExpression(expr.String())
+			require.NoError(t, err)
+			assert.Equal(t, expr, reparsed)
+		})
+	}
+}
+
+func TestParseScopeExpression_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "AND", "teams:id:1 AND", "teams:id:1 OR OR teams:id:2", "(teams:id:1", "teams:id:1)", "teams:id:1 teams:id:2"} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := ParseScopeExpression(expr)
+			assert.ErrorIs(t, err, ErrInvalidScopeExpression)
+		})
+	}
+}
+
+func TestEvaluateScopeExpr(t *testing.T) {
+	tests := []struct {
+		desc     string
+		expr     string
+		scopes   []string
+		expected bool
+	}{
+		{desc: "leaf matches", expr: "datasources:uid:A", scopes: []string{"datasources:uid:A"}, expected: true},
+		{desc: "leaf matches wildcard", expr: "datasources:uid:A", scopes: []string{"datasources:*"}, expected: true},
+		{desc: "leaf does not match", expr: "datasources:uid:A", scopes: []string{"datasources:uid:B"}, expected: false},
+		{desc: "AND requires both", expr: "datasources:uid:A AND teams:id:5", scopes: []string{"datasources:uid:A", "teams:id:5"}, expected: true},
+		{desc: "AND with one missing", expr: "datasources:uid:A AND teams:id:5", scopes: []string{"datasources:uid:A"}, expected: false},
+		{desc: "OR with the first", expr: "datasources:uid:A OR teams:id:5", scopes: []string{"datasources:uid:A"}, expected: true},
+		{desc: "OR with the second", expr: "datasources:uid:A OR teams:id:5", scopes: []string{"teams:id:5"}, expected: true},
+		{desc: "OR with neither", expr: "datasources:uid:A OR teams:id:5", scopes: []string{"teams:id:6"}, expected: false},
+		{desc: "nested AND in OR", expr: "teams:id:1 OR (datasources:uid:A AND teams:id:5)", scopes: []string{"datasources:uid:A", "teams:id:5"}, expected: true},
+		{desc: "nested AND in OR with one missing", expr: "teams:id:1 OR (datasources:uid:A AND teams:id:5)", scopes: []string{"teams:id:5"}, expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			expr, err := ParseScopeExpression(test.expr)
+			require.NoError(t, err)
+			perms := make([]Permission, 0, len(test.scopes))
+			for _, scope := range test.scopes {
+				perms = append(perms, Permission{Action: "datasources:query", Scope: scope})
+			}
+			assert.Equal(t, test.expected, EvaluateScopeExpr(expr, perms))
+		})
+	}
+}
+
+func TestEvalPermissionExpr(t *testing.T) {
+	evaluator, err := EvalPermissionExpr("datasources:query", "datasources:uid:A AND teams:id:5")
+	require.NoError(t, err)
+	assert.True(t, evaluator.Evaluate(map[string][]string{"datasources:query": {"datasources:uid:A", "teams:id:5"}}))
+	assert.False(t, evaluator.Evaluate(map[string][]string{"datasources:query": {"datasources:uid:A"}}))
+	assert.False(t, evaluator.Evaluate(map[string][]string{"datasources:read": {"datasources:uid:A", "teams:id:5"}}))
+
+	t.Run("should reject an invalid expression", func(t *testing.T) {
+		_, err := EvalPermissionExpr("datasources:query", "datasources:uid:A AND")
+		assert.ErrorIs(t, err, ErrInvalidScopeExpression)
+	})
+
+	t.Run("should inject parameters in every scope", func(t *testing.T) {
+		evaluator, err := EvalPermissionExpr("reports:read", Scope("reports", Parameter(":reportId"))+" AND "+Scope("orgs", Field("OrgID")))
+		require.NoError(t, err)
+		injected, err := evaluator.MutateScopes(context.TODO(), scopeInjector(scopeParams{
+			OrgID:     3,
+			URLParams: map[string]string{":reportId": "1"},
+		}))
+		require.NoError(t, err)
+		assert.True(t, injected.Evaluate(map[string][]string{"reports:read": {"reports:1", "orgs:3"}}))
+		assert.False(t, injected.Evaluate(map[string][]string{"reports:read": {"reports:1"}}))
+	})
+
+	t.Run("should not read injected values as an expression", func(t *testing.T) {
+		evaluator, err := EvalPermissionExpr("reports:read", Scope("reports", Parameter(":reportId")))
+		require.NoError(t, err)
+		injected, err := evaluator.MutateScopes(context.TODO(), scopeInjector(scopeParams{
+			URLParams: map[string]string{":reportId": "1 OR reports:2"},
+		}))
+		require.NoError(t, err)
+		assert.False(t, injected.Evaluate(map[string][]string{"reports:read": {"reports:2"}}))
+		assert.True(t, injected.Evaluate(map[string][]string{"reports:read": {"reports:1 OR reports:2"}}))
+	})
+}
+
+func TestEvalPermission_ScopeIsNotAnExpression(t *testing.T) {
+	evaluator := EvalPermission("datasources:query", "datasources:uid:A OR datasources:uid:B")
+	assert.False(t, evaluator.Evaluate(map[string][]string{"datasources:query": {"datasources:uid:B"}}))
+	assert.True(t, evaluator.Evaluate(map[string][]string{"datasources:query": {"datasources:uid:A OR datasources:uid:B"}}))
+}
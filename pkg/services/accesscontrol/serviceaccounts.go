@@ -0,0 +1,10 @@
+package accesscontrol
+
+// ServiceAccountRoleCommand assigns a role to, or revokes it from, a service
+// account of the org. The role is either a role of the org or a global role.
+// Only custom roles can be assigned, not fixed, managed or basic ones.
+type ServiceAccountRoleCommand struct {
+	OrgID            int64  `json:"-"`
+	ServiceAccountID int64  `json:"-"`
+	RoleUID          string `json:"roleUid"`
+}
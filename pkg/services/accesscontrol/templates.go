@@ -0,0 +1,67 @@
+package accesscontrol
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// PermissionTemplate declares a set of permissions sharing a scope, such as
+// the permissions needed to edit a dashboard. ScopeTemplate is a text/template
+// whose variables, e.g. {{.ResourceUID}}, are set when the template is rendered.
+type PermissionTemplate struct {
+	Name          string   `json:"name"`
+	Actions       []string `json:"actions"`
+	ScopeTemplate string   `json:"scopeTemplate"`
+}
+
+// Validate returns ErrInvalidTemplate if the template has no name or actions,
+// or if its scope template cannot be parsed.
+func (t PermissionTemplate) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidTemplate)
+	}
+	if len(t.Actions) == 0 {
+		return fmt.Errorf("%w: template %q has no actions", ErrInvalidTemplate, t.Name)
+	}
+	if _, err := t.parse(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidTemplate, err)
+	}
+	return nil
+}
+
+func (t PermissionTemplate) parse() (*template.Template, error) {
+	return template.New(t.Name).Option("missingkey=error").Parse(t.ScopeTemplate)
+}
+
+// RenderTemplate returns a permission for each action of tmpl, scoped to its
+// scope template rendered with vars. A variable of the scope template missing
+// from vars is an error.
+func RenderTemplate(tmpl PermissionTemplate, vars map[string]string) ([]Permission, error) {
+	t, err := tmpl.parse()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTemplate, err)
+	}
+
+	var scope strings.Builder
+	if err := t.Execute(&scope, vars); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateVariableMissing, err)
+	}
+
+	permissions := make([]Permission, 0, len(tmpl.Actions))
+	for _, action := range tmpl.Actions {
+		permissions = append(permissions, Permission{Action: action, Scope: scope.String()})
+	}
+	return permissions, nil
+}
+
+// ApplyTemplateCommand adds the permissions of the template rendered with Vars
+// to the role of the org with the given UID.
+type ApplyTemplateCommand struct {
+	OrgID   int64  `json:"-"`
+	RoleUID string `json:"-"`
+	// UpdatedBy is the ID of the user applying the template.
+	UpdatedBy int64             `json:"-"`
+	Template  string            `json:"template"`
+	Vars      map[string]string `json:"vars"`
+}
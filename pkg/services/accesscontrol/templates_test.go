@@ -0,0 +1,51 @@
+package accesscontrol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	tmpl := PermissionTemplate{
+		Name:          "dashboard-editor",
+		Actions:       []string{"dashboards:read", "dashboards:write"},
+		ScopeTemplate: "dashboards:uid:{{.ResourceUID}}",
+	}
+
+	t.Run("substitutes variables in the scope", func(t *testing.T) {
+		permissions, err := RenderTemplate(tmpl, map[string]string{"ResourceUID": "abc"})
+		require.NoError(t, err)
+		assert.Equal(t, []Permission{
+			{Action: "dashboards:read", Scope: "dashboards:uid:abc"},
+			{Action: "dashboards:write", Scope: "dashboards:uid:abc"},
+		}, permissions)
+	})
+
+	t.Run("ignores unused variables", func(t *testing.T) {
+		permissions, err := RenderTemplate(tmpl, map[string]string{"ResourceUID": "abc", "FolderUID": "def"})
+		require.NoError(t, err)
+		assert.Len(t, permissions, 2)
+	})
+
+	t.Run("fails on a missing variable", func(t *testing.T) {
+		_, err := RenderTemplate(tmpl, map[string]string{"FolderUID": "def"})
+		assert.ErrorIs(t, err, ErrTemplateVariableMissing)
+
+		_, err = RenderTemplate(tmpl, nil)
+		assert.ErrorIs(t, err, ErrTemplateVariableMissing)
+	})
+
+	t.Run("fails on a malformed scope template", func(t *testing.T) {
+		_, err := RenderTemplate(PermissionTemplate{Name: "bad", Actions: []string{"dashboards:read"}, ScopeTemplate: "dashboards:uid:{{.ResourceUID"}, nil)
+		assert.ErrorIs(t, err, ErrInvalidTemplate)
+	})
+}
+
+func TestPermissionTemplate_Validate(t *testing.T) {
+	assert.NoError(t, PermissionTemplate{Name: "t", Actions: []string{"folders:read"}, ScopeTemplate: "folders:uid:{{.ResourceUID}}"}.Validate())
+	assert.ErrorIs(t, PermissionTemplate{Actions: []string{"folders:read"}}.Validate(), ErrInvalidTemplate)
+	assert.ErrorIs(t, PermissionTemplate{Name: "t"}.Validate(), ErrInvalidTemplate)
+	assert.ErrorIs(t, PermissionTemplate{Name: "t", Actions: []string{"folders:read"}, ScopeTemplate: "{{"}.Validate(), ErrInvalidTemplate)
+}
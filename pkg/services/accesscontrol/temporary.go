@@ -0,0 +1,23 @@
+package accesscontrol
+
+import "time"
+
+// TemporaryPermissionCommand grants a permission to a user of the org until
+// ExpiresAt, e.g. for break-glass access.
+type TemporaryPermissionCommand struct {
+	OrgID      int64      `json:"-"`
+	UserID     int64      `json:"userId"`
+	Permission Permission `json:"permission"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+}
+
+// TemporaryPermission is a stored grant of a permission to a user of the org,
+// which is deleted once it expires.
+type TemporaryPermission struct {
+	ID        int64     `json:"id"`
+	OrgID     int64     `json:"orgId"`
+	UserID    int64     `json:"userId"`
+	Action    string    `json:"action"`
+	Scope     string    `json:"scope"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
@@ -0,0 +1,107 @@
+package accesscontrol
+
+// TemporaryPermissionRoleName is the role name under which temporary
+// permissions are reported, since they are not granted through a role.
+const TemporaryPermissionRoleName = "temporary"
+
+// RolePermission is a permission of a user along with the name of the role
+// granting it.
+type RolePermission struct {
+	RoleName string `xorm:"role_name"`
+	Action   string `xorm:"action"`
+	Scope    string `xorm:"scope"`
+}
+
+// EvalTraceRule is a permission of the user checked by an evaluation.
+type EvalTraceRule struct {
+	Role   string `json:"role"`
+	Action string `json:"action"`
+	Scope  string `json:"scope"`
+	// Matched is true when the permission satisfies part of the evaluator.
+	Matched bool `json:"matched"`
+}
+
+// EvalTrace explains the result of an evaluation, see AccessControl.EvaluateWithTrace.
+type EvalTrace struct {
+	Allowed bool `json:"allowed"`
+	// Rules are the permissions of the user on the actions the evaluator requires.
+	Rules []EvalTraceRule `json:"rules"`
+	// Decisive is the first rule granting access, it is only set when access is allowed.
+	Decisive *EvalTraceRule `json:"decisive,omitempty"`
+}
+
+// TraceEvaluation builds the trace of the evaluation of evaluator against the
+// permissions the user holds through roles, allowed being its result.
+func TraceEvaluation(evaluator Evaluator, permissions []RolePermission, allowed bool) *EvalTrace {
+	actions := map[string]bool{}
+	walkPermissions(evaluator, func(action string, _ []string) {
+		actions[action] = true
+	})
+
+	trace := &EvalTrace{Allowed: allowed, Rules: []EvalTraceRule{}}
+	for _, p := range permissions {
+		if !actions[p.Action] {
+			continue
+		}
+		trace.Rules = append(trace.Rules, EvalTraceRule{
+			Role:    p.RoleName,
+			Action:  p.Action,
+			Scope:   p.Scope,
+			Matched: matchesEvaluator(evaluator, p.Action, p.Scope),
+		})
+	}
+
+	if allowed {
+		for i := range trace.Rules {
+			if trace.Rules[i].Matched {
+				trace.Decisive = &trace.Rules[i]
+				break
+			}
+		}
+	}
+	return trace
+}
+
+// walkPermissions calls fn with the action and scopes of every permission the
+// evaluator checks.
+func walkPermissions(evaluator Evaluator, fn func(action string, scopes []string)) {
+	switch e := evaluator.(type) {
+	case permissionEvaluator:
+		fn(e.Action, e.Scopes)
+	case scopeExprEvaluator:
+		fn(e.Action, leafScopes(e.Expr))
+	case allEvaluator:
+		for _, child := range e.allOf {
+			walkPermissions(child, fn)
+		}
+	case anyEvaluator:
+		for _, child := range e.anyOf {
+			walkPermissions(child, fn)
+		}
+	case notEvaluator:
+		walkPermissions(e.eval, fn)
+	}
+}
+
+// matchesEvaluator returns true when the permission alone satisfies one of the
+// permissions required by the evaluator. Permissions under EvalNot never
+// match since holding them does not grant access.
+func matchesEvaluator(evaluator Evaluator, action, scope string) bool {
+	switch e := evaluator.(type) {
+	case permissionEvaluator, scopeExprEvaluator:
+		return e.Evaluate(map[string][]string{action: {scope}})
+	case allEvaluator:
+		for _, child := range e.allOf {
+			if matchesEvaluator(child, action, scope) {
+				return true
+			}
+		}
+	case anyEvaluator:
+		for _, child := range e.anyOf {
+			if matchesEvaluator(child, action, scope) {
+				return true
+			}
+		}
+	}
+	return false
+}
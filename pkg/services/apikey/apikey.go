@@ -2,6 +2,7 @@ package apikey
 
 import (
 	"context"
+	"time"
 )
 
 type Service interface {
@@ -9,8 +10,66 @@ type Service interface {
 	GetAllAPIKeys(ctx context.Context, orgID int64) ([]*APIKey, error)
 	DeleteApiKey(ctx context.Context, cmd *DeleteCommand) error
 	AddAPIKey(ctx context.Context, cmd *AddCommand) error
+	// ConfirmAPIKeyCreation activates a key added with a creation secret if
+	// cmd carries that secret, and returns ErrCreationSecretMismatch otherwise.
+	ConfirmAPIKeyCreation(ctx context.Context, cmd *ConfirmCommand) error
+	// DeleteUnconfirmedAPIKeys deletes the keys added before olderThan whose
+	// creation has not been confirmed, and returns how many were deleted.
+	DeleteUnconfirmedAPIKeys(ctx context.Context, olderThan time.Time) (int64, error)
 	GetApiKeyById(ctx context.Context, query *GetByIDQuery) error
 	GetApiKeyByName(ctx context.Context, query *GetByNameQuery) error
 	GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	// VerifyAPIKey returns the key of the org with the given name if legacyHash
+	// is the legacy hash of its secret, and ErrHashMismatch otherwise.
+	VerifyAPIKey(ctx context.Context, orgID int64, name string, legacyHash string) (*APIKey, error)
+	GetAPIKeysByRole(ctx context.Context, query *GetByRoleQuery) ([]*APIKey, error)
+	// ListAPIKeysByStatus returns the keys of the org, including service
+	// account tokens, that are in status.
+	ListAPIKeysByStatus(ctx context.Context, orgID int64, status KeyStatus) ([]*APIKey, error)
+	// CountAPIKeysByStatus returns the number of keys of the org in each of
+	// KeyStatuses.
+	CountAPIKeysByStatus(ctx context.Context, orgID int64) (map[KeyStatus]int64, error)
 	UpdateAPIKeyLastUsedDate(ctx context.Context, tokenID int64) error
+	// UpdateAPIKeyGracePeriod sets how long a key is still accepted after it expires.
+	UpdateAPIKeyGracePeriod(ctx context.Context, cmd *GraceCommand) error
+	// UpdateAPIKeyAllowedCIDRs sets the source addresses a key can be used from.
+	UpdateAPIKeyAllowedCIDRs(ctx context.Context, cmd *UpdateCIDRCommand) error
+	// UpdateAPIKeyScopes sets the OAuth2-style scopes a key carries.
+	UpdateAPIKeyScopes(ctx context.Context, cmd *UpdateScopesCommand) error
+	// ValidateAPIKeyScopes returns ErrScopeNotGranted unless the key carries
+	// all of requiredScopes.
+	ValidateAPIKeyScopes(ctx context.Context, keyID int64, requiredScopes []string) error
+	// RenewAPIKeyExpiry moves the expiry of a key later without re-issuing it.
+	RenewAPIKeyExpiry(ctx context.Context, cmd *RenewCommand) error
+	// GenerateServiceToken creates a service token for a service account. The
+	// secret is only returned here, in the Token field of the result.
+	GenerateServiceToken(ctx context.Context, cmd *ServiceTokenCommand) (*ServiceToken, error)
+	// GetServiceTokenByHash looks up a service token by the hash of its secret.
+	GetServiceTokenByHash(ctx context.Context, hash string) (*ServiceToken, error)
+	ListServiceTokens(ctx context.Context, serviceAccountID int64) ([]*ServiceToken, error)
+	// GetOrgAPIKeyQuota returns the API key quota of the org: its override if
+	// one is set, otherwise the org default from the configuration.
+	GetOrgAPIKeyQuota(ctx context.Context, orgID int64) (int64, error)
+	// SetOrgAPIKeyQuota overrides the API key quota of the org.
+	SetOrgAPIKeyQuota(ctx context.Context, orgID int64, limit int64) error
+	// CleanupExpiredAPIKeys deletes the API keys selected by cmd in batches,
+	// or only counts them if cmd.DryRun is set.
+	CleanupExpiredAPIKeys(ctx context.Context, cmd *CleanupCommand) (*CleanupResult, error)
+	// ValidateAPIKeys checks the tokens, in the formats accepted for
+	// authentication, and returns a result for each one in the same order.
+	// Checking a token does not count as using the key.
+	ValidateAPIKeys(ctx context.Context, tokens []string) ([]ValidationResult, error)
+	// CreateAPIKeyPool creates a pool of interchangeable keys of the org.
+	CreateAPIKeyPool(ctx context.Context, cmd *CreatePoolCommand) (*Pool, error)
+	GetAPIKeyPool(ctx context.Context, orgID, poolID int64) (*Pool, error)
+	ListAPIKeyPools(ctx context.Context, orgID int64) ([]*Pool, error)
+	UpdateAPIKeyPool(ctx context.Context, cmd *UpdatePoolCommand) (*Pool, error)
+	DeleteAPIKeyPool(ctx context.Context, orgID, poolID int64) error
+	// SelectKeyFromPool returns the next active key of the pool in round-robin
+	// order, and counts the selection against the quota of the pool.
+	SelectKeyFromPool(ctx context.Context, poolID int64) (*APIKey, error)
+	// MigrateHashAlgorithm upgrades the stored hashes of all keys in the org to
+	// the given algorithm and returns the number of keys upgraded. Keys stored
+	// with a slow hash are skipped, they are upgraded once they are used.
+	MigrateHashAlgorithm(ctx context.Context, orgID int64, newAlgo string) (int, error)
 }
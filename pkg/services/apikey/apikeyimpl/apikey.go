@@ -2,27 +2,102 @@ package apikeyimpl
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/components/apikeygen"
+	apikeygenprefix "github.com/grafana/grafana/pkg/components/apikeygenprefixed"
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/infra/db"
+	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/services/apikey"
 	"github.com/grafana/grafana/pkg/services/featuremgmt"
 	"github.com/grafana/grafana/pkg/setting"
+	"github.com/grafana/grafana/pkg/util"
 )
 
+// validateConcurrency bounds the tokens ValidateAPIKeys hashes at once, as
+// the legacy hash of a token is a PBKDF2 digest.
+const validateConcurrency = 4
+
 type Service struct {
-	store store
+	store   store
+	cfg     *setting.Cfg
+	bus     bus.Bus
+	log     log.Logger
+	metrics *apikey.Metrics
+	now     func() time.Time
+	// preferredHashVersion is the version keys are hashed with when added, and
+	// that keys with an older version are upgraded to when used.
+	preferredHashVersion apikey.HashVersion
+	// minTokenEntropy is the minimum entropy of the tokens of added keys.
+	minTokenEntropy float64
+	// notifier is sent the lifecycle events of keys, if set.
+	notifier WebhookNotifier
+	// tokenGenerator generates the tokens of keys added without one.
+	tokenGenerator apikey.TokenGenerator
+	// poolCursors holds the number of selections from each pool, by pool ID,
+	// as a *uint64 that SelectKeyFromPool advances atomically.
+	poolCursors sync.Map
+}
+
+// ProvideTokenGenerator returns the generator of the tokens of added keys.
+func ProvideTokenGenerator() apikey.TokenGenerator {
+	return apikey.DefaultTokenGenerator{}
 }
 
-func ProvideService(db db.DB, cfg *setting.Cfg) apikey.Service {
+// ProvideService returns the API key service. Tokens are generated with
+// apikey.DefaultTokenGenerator if generator is nil.
+func ProvideService(db db.DB, cfg *setting.Cfg, reg prometheus.Registerer, bus bus.Bus, generator apikey.TokenGenerator) apikey.Service {
+	if generator == nil {
+		generator = apikey.DefaultTokenGenerator{}
+	}
+	s := &Service{
+		store:          &sqlStore{db: db, cfg: cfg},
+		cfg:            cfg,
+		bus:            bus,
+		log:            log.New("apikey"),
+		metrics:        apikey.NewMetrics(reg),
+		now:            time.Now,
+		tokenGenerator: generator,
+	}
 	if cfg.IsFeatureToggleEnabled(featuremgmt.FlagNewDBLibrary) {
-		return &Service{
-			store: &sqlxStore{
-				sess: db.GetSqlxSession(),
-				cfg:  cfg,
-			},
+		s.store = &sqlxStore{
+			sess:    db.GetSqlxSession(),
+			cfg:     cfg,
+			dialect: db.GetDialect(),
 		}
 	}
-	return &Service{store: &sqlStore{db: db, cfg: cfg}}
+
+	algo := cfg.ApiKeyHashAlgorithm
+	if algo == "" {
+		algo = apikey.DefaultHashAlgorithm
+	}
+	version, err := apikey.ParseHashAlgorithm(algo)
+	if err != nil {
+		s.log.Warn("Invalid API key hash algorithm, using default", "algorithm", algo, "default", apikey.DefaultHashAlgorithm)
+		version, _ = apikey.ParseHashAlgorithm(apikey.DefaultHashAlgorithm)
+	}
+	s.preferredHashVersion = version
+
+	s.minTokenEntropy = cfg.ApiKeyMinEntropy
+	if s.minTokenEntropy <= 0 {
+		s.minTokenEntropy = apikey.DefaultMinTokenEntropy
+	}
+
+	if cfg.ApiKeyWebhookURL != "" {
+		s.notifier = NewHTTPWebhookNotifier(cfg.ApiKeyWebhookURL, cfg.ApiKeyWebhookSecret)
+	}
+
+	return s
 }
 
 func (s *Service) GetAPIKeys(ctx context.Context, query *apikey.GetApiKeysQuery) error {
@@ -37,15 +112,735 @@ func (s *Service) GetApiKeyById(ctx context.Context, query *apikey.GetByIDQuery)
 func (s *Service) GetApiKeyByName(ctx context.Context, query *apikey.GetByNameQuery) error {
 	return s.store.GetApiKeyByName(ctx, query)
 }
+
+// GetAPIKeyByHash looks up a key by the legacy hash of its secret, whatever
+// version the key is stored with. Keys stored with a version older than the
+// preferred one are upgraded in place.
+//
+// The lookup is counted as an authentication attempt. An expired key past its
+// grace period is still returned, for the caller to reject, but counted as a
+// failure.
 func (s *Service) GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.APIKey, error) {
-	return s.store.GetAPIKeyByHash(ctx, hash)
+	key, err := s.getAPIKeyByLegacyHash(ctx, hash)
+	if err != nil {
+		if errors.Is(err, apikey.ErrInvalid) {
+			s.metrics.AuthFailure.WithLabelValues(apikey.UnknownOrgLabel, apikey.AuthFailureNotFound).Inc()
+		}
+		return nil, err
+	}
+	s.recordAuthentication(key)
+
+	if key.HashVersion < s.preferredHashVersion {
+		// the presented hash can be upgraded whatever the stored version is
+		if err := s.upgradeHash(ctx, key, hash, apikey.HashVersionPBKDF2, s.preferredHashVersion); err != nil {
+			// the key is valid, failing to upgrade it must not fail authentication
+			s.log.Warn("Failed to upgrade API key hash", "keyID", key.Id, "error", err)
+		}
+	}
+
+	return key, nil
+}
+
+// VerifyAPIKey looks up a key by org and name and checks that legacyHash is the
+// legacy hash of its secret. The lookup is counted as an authentication attempt
+// like in GetAPIKeyByHash.
+func (s *Service) VerifyAPIKey(ctx context.Context, orgID int64, name string, legacyHash string) (*apikey.APIKey, error) {
+	query := apikey.GetByNameQuery{KeyName: name, OrgId: orgID}
+	if err := s.store.GetApiKeyByName(ctx, &query); err != nil {
+		if errors.Is(err, apikey.ErrInvalid) {
+			s.metrics.AuthFailure.WithLabelValues(apikey.OrgLabel(orgID), apikey.AuthFailureNotFound).Inc()
+		}
+		return nil, err
+	}
+	if !query.Result.Active {
+		s.metrics.AuthFailure.WithLabelValues(apikey.OrgLabel(orgID), apikey.AuthFailureNotFound).Inc()
+		return nil, apikey.ErrInvalid
+	}
+
+	valid, err := query.Result.VerifyHash(legacyHash)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		s.metrics.AuthFailure.WithLabelValues(apikey.OrgLabel(orgID), apikey.AuthFailureHashMismatch).Inc()
+		return nil, apikey.ErrHashMismatch
+	}
+
+	s.recordAuthentication(query.Result)
+	return query.Result, nil
+}
+
+func (s *Service) recordAuthentication(key *apikey.APIKey) {
+	if expired, graceRemaining := key.GracePeriodRemaining(s.now()); expired && graceRemaining == 0 {
+		s.metrics.AuthFailure.WithLabelValues(apikey.OrgLabel(key.OrgId), apikey.AuthFailureExpired).Inc()
+		return
+	}
+	s.metrics.AuthSuccess.WithLabelValues(apikey.OrgLabel(key.OrgId)).Inc()
 }
+
+// getAPIKeyByLegacyHash looks the key up by the lookup hashes of hash in a
+// single query. Only the keys found are verified, so that presenting an
+// unknown secret costs no slow hash.
+func (s *Service) getAPIKeyByLegacyHash(ctx context.Context, hash string) (*apikey.APIKey, error) {
+	lookups := apikey.LookupHashes(hash)
+	hashes := make([]string, 0, len(lookups))
+	for _, lookup := range lookups {
+		hashes = append(hashes, lookup)
+	}
+	keys, err := s.store.GetAPIKeysByHashes(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		// keys whose creation is not confirmed cannot be used yet
+		if !key.Active {
+			continue
+		}
+		valid, err := key.VerifyHash(hash)
+		if err != nil {
+			return nil, err
+		}
+		if valid {
+			return key, nil
+		}
+	}
+
+	return nil, apikey.ErrInvalid
+}
+
+// ValidateAPIKeys computes the legacy hashes of the tokens concurrently, and
+// looks up the lookup hashes of every hash version keys can be stored with in
+// a single query. Only tokens whose key is found are verified, so unknown
+// tokens cost no slow hash.
+func (s *Service) ValidateAPIKeys(ctx context.Context, tokens []string) ([]apikey.ValidationResult, error) {
+	if len(tokens) > apikey.MaxValidateTokens {
+		return nil, apikey.ErrTooManyTokens
+	}
+
+	// legacyHashes[i] is the legacy hash of tokens[i], and ok[i] is false if
+	// the token is malformed
+	legacyHashes := make([]string, len(tokens))
+	ok := make([]bool, len(tokens))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(validateConcurrency)
+	for i, token := range tokens {
+		i, token := i, token
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
+			legacyHashes[i], ok[i] = tokenLegacyHash(token)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	for _, hash := range legacyHashes {
+		if hash == "" {
+			continue
+		}
+		for _, lookup := range apikey.LookupHashes(hash) {
+			hashes = append(hashes, lookup)
+		}
+	}
+	keys, err := s.store.GetAPIKeysByHashes(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+	byHash := make(map[string]*apikey.APIKey, len(keys))
+	for _, key := range keys {
+		byHash[key.Key] = key
+	}
+
+	// verified caches the verification of repeated tokens, by legacy hash
+	verified := make(map[string]*apikey.APIKey)
+	now := s.now()
+	results := make([]apikey.ValidationResult, len(tokens))
+	for i, hash := range legacyHashes {
+		if !ok[i] {
+			results[i].Reason = apikey.ValidationMalformed
+			continue
+		}
+
+		key, seen := verified[hash]
+		if !seen && hash != "" {
+			if key, err = verifyFoundKey(byHash, hash); err != nil {
+				return nil, err
+			}
+			verified[hash] = key
+		}
+		if key == nil {
+			results[i].Reason = apikey.ValidationNotFound
+			continue
+		}
+
+		results[i].TokenID = key.Id
+		expired, graceRemaining := key.GracePeriodRemaining(now)
+		results[i].Expired = expired
+		switch {
+		case key.IsRevoked != nil && *key.IsRevoked:
+			results[i].Reason = apikey.ValidationRevoked
+		case expired && graceRemaining == 0:
+			results[i].Reason = apikey.ValidationExpired
+		default:
+			results[i].Valid = true
+		}
+	}
+	return results, nil
+}
+
+// verifyFoundKey returns the key of byHash that legacyHash is the legacy hash
+// of the secret of, or nil if there is none.
+func verifyFoundKey(byHash map[string]*apikey.APIKey, legacyHash string) (*apikey.APIKey, error) {
+	for version, lookup := range apikey.LookupHashes(legacyHash) {
+		// a lookup hash only identifies the key if the key is stored with its
+		// version, and keys whose creation is not confirmed cannot be used
+		key, found := byHash[lookup]
+		if !found || key.HashVersion != version || !key.Active {
+			continue
+		}
+		valid, err := key.VerifyHash(legacyHash)
+		if err != nil {
+			return nil, err
+		}
+		if valid {
+			return key, nil
+		}
+	}
+	return nil, nil
+}
+
+// tokenLegacyHash returns the legacy hash of the secret of token, and false if
+// the token is malformed. The hash is empty for service tokens, which are
+// well formed but are not stored as API keys.
+func tokenLegacyHash(token string) (string, bool) {
+	if decoded, err := apikeygenprefix.Decode(token); err == nil {
+		if decoded.ServiceID == apikey.ServiceTokenServiceID {
+			return "", true
+		}
+		legacyHash, err := decoded.Hash()
+		return legacyHash, err == nil
+	}
+
+	decoded, err := apikeygen.Decode(token)
+	if err != nil {
+		return "", false
+	}
+	legacyHash, err := util.EncodePassword(decoded.Key, decoded.Name)
+	return legacyHash, err == nil
+}
+
+// upgradeHash stores the key with version, upgraded from hash, a hash of the
+// key's secret of version from.
+func (s *Service) upgradeHash(ctx context.Context, key *apikey.APIKey, hash string, from, version apikey.HashVersion) error {
+	hash, verifier, err := apikey.UpgradeHash(hash, from, version)
+	if err != nil {
+		return err
+	}
+	if err := s.store.UpdateAPIKeyHash(ctx, key.Id, hash, verifier, version); err != nil {
+		return err
+	}
+	key.Key, key.VerifierHash, key.HashVersion = hash, verifier, version
+	return nil
+}
+
+func (s *Service) MigrateHashAlgorithm(ctx context.Context, orgID int64, newAlgo string) (int, error) {
+	version, err := apikey.ParseHashAlgorithm(newAlgo)
+	if err != nil {
+		return 0, err
+	}
+
+	keys, err := s.store.GetAPIKeysWithHashVersionBelow(ctx, orgID, version)
+	if err != nil {
+		return 0, err
+	}
+
+	upgraded := 0
+	for _, key := range keys {
+		// the creation secret of an unconfirmed key is salted with its hash
+		if !key.Active {
+			continue
+		}
+		if err := s.upgradeHash(ctx, key, key.Key, key.HashVersion, version); err != nil {
+			// keys stored with a slow hash are upgraded once they are used
+			if errors.Is(err, apikey.ErrHashNotUpgradable) {
+				continue
+			}
+			return upgraded, err
+		}
+		upgraded++
+	}
+
+	return upgraded, nil
+}
+
+// GenerateServiceToken creates a "glst_" prefixed service token for the
+// service account in cmd. Only the hash of the secret is stored.
+func (s *Service) GenerateServiceToken(ctx context.Context, cmd *apikey.ServiceTokenCommand) (*apikey.ServiceToken, error) {
+	if cmd.SecondsToLive < 0 {
+		return nil, apikey.ErrInvalidExpiration
+	}
+
+	gen, err := apikeygenprefix.New(apikey.ServiceTokenServiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	token := &apikey.ServiceToken{
+		OrgID:            cmd.OrgID,
+		ServiceAccountID: cmd.ServiceAccountID,
+		Name:             cmd.Name,
+		Key:              gen.HashedKey,
+		Created:          now,
+	}
+	if cmd.SecondsToLive > 0 {
+		expires := now.Add(time.Duration(cmd.SecondsToLive) * time.Second).Unix()
+		token.Expires = &expires
+	}
+
+	if err := s.store.AddServiceToken(ctx, token); err != nil {
+		return nil, err
+	}
+	s.metrics.Created.WithLabelValues(apikey.OrgLabel(cmd.OrgID)).Inc()
+
+	token.Token = gen.ClientSecret
+	return token, nil
+}
+
+// GetServiceTokenByHash looks up a service token by the hash of its secret.
+// Like GetAPIKeyByHash, the lookup is counted as an authentication attempt and
+// an expired token is still returned, for the caller to reject.
+func (s *Service) GetServiceTokenByHash(ctx context.Context, hash string) (*apikey.ServiceToken, error) {
+	token, err := s.store.GetServiceTokenByHash(ctx, hash)
+	if err != nil {
+		if errors.Is(err, apikey.ErrInvalid) {
+			s.metrics.AuthFailure.WithLabelValues(apikey.UnknownOrgLabel, apikey.AuthFailureNotFound).Inc()
+		}
+		return nil, err
+	}
+
+	if token.Expired(s.now()) {
+		s.metrics.AuthFailure.WithLabelValues(apikey.OrgLabel(token.OrgID), apikey.AuthFailureExpired).Inc()
+	} else {
+		s.metrics.AuthSuccess.WithLabelValues(apikey.OrgLabel(token.OrgID)).Inc()
+	}
+	return token, nil
+}
+
+func (s *Service) ListServiceTokens(ctx context.Context, serviceAccountID int64) ([]*apikey.ServiceToken, error) {
+	return s.store.ListServiceTokens(ctx, serviceAccountID)
+}
+
+// CleanupExpiredAPIKeys deletes the expired keys selected by cmd, one batch per
+// transaction so that large cleanups do not hold long-running transactions. A
+// failed batch is recorded in the result and ends the cleanup.
+func (s *Service) CleanupExpiredAPIKeys(ctx context.Context, cmd *apikey.CleanupCommand) (*apikey.CleanupResult, error) {
+	expiredBefore := s.now().Add(-cmd.OlderThan).Unix()
+	found, err := s.store.CountExpiredAPIKeys(ctx, cmd.OrgID, expiredBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &apikey.CleanupResult{Found: found}
+	if cmd.DryRun || found == 0 {
+		return result, nil
+	}
+
+	batchSize := cmd.BatchSize
+	if batchSize <= 0 {
+		batchSize = apikey.DefaultCleanupBatchSize
+	}
+	for {
+		deleted, err := s.store.DeleteExpiredAPIKeys(ctx, cmd.OrgID, expiredBefore, batchSize)
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+			break
+		}
+		for _, key := range deleted {
+			s.notify(key.OrgId, key.Id, apikey.KeyEventExpired, key.Expires)
+		}
+		result.Deleted += int64(len(deleted))
+		if len(deleted) < batchSize {
+			break
+		}
+	}
+	s.log.Info("Cleaned up expired API keys", "found", result.Found, "deleted", result.Deleted, "errors", len(result.Errors))
+	return result, nil
+}
+
+func (s *Service) GetOrgAPIKeyQuota(ctx context.Context, orgID int64) (int64, error) {
+	limit, ok, err := s.store.GetOrgQuota(ctx, orgID)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return s.cfg.Quota.Org.ApiKey, nil
+	}
+	return limit, nil
+}
+
+func (s *Service) SetOrgAPIKeyQuota(ctx context.Context, orgID int64, limit int64) error {
+	return s.store.SetOrgQuota(ctx, orgID, limit)
+}
+
+// CreateAPIKeyPool creates a pool of keys of the org. Keys that do not exist
+// or belong to another org are rejected with apikey.ErrInvalidPoolMember.
+func (s *Service) CreateAPIKeyPool(ctx context.Context, cmd *apikey.CreatePoolCommand) (*apikey.Pool, error) {
+	keyIDs, err := s.poolKeyIDs(ctx, cmd.OrgID, cmd.KeyIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	pool := &apikey.Pool{
+		OrgID:   cmd.OrgID,
+		Name:    cmd.Name,
+		Quota:   cmd.Quota,
+		Created: now,
+		Updated: now,
+		KeyIDs:  keyIDs,
+	}
+	if err := s.store.AddPool(ctx, pool); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+func (s *Service) GetAPIKeyPool(ctx context.Context, orgID, poolID int64) (*apikey.Pool, error) {
+	pool, err := s.store.GetPool(ctx, poolID)
+	if err != nil {
+		return nil, err
+	}
+	if pool.OrgID != orgID {
+		return nil, apikey.ErrPoolNotFound
+	}
+	return pool, nil
+}
+
+func (s *Service) ListAPIKeyPools(ctx context.Context, orgID int64) ([]*apikey.Pool, error) {
+	return s.store.ListPools(ctx, orgID)
+}
+
+func (s *Service) UpdateAPIKeyPool(ctx context.Context, cmd *apikey.UpdatePoolCommand) (*apikey.Pool, error) {
+	if _, err := s.GetAPIKeyPool(ctx, cmd.OrgID, cmd.ID); err != nil {
+		return nil, err
+	}
+	keyIDs, err := s.poolKeyIDs(ctx, cmd.OrgID, cmd.KeyIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &apikey.Pool{
+		ID:      cmd.ID,
+		OrgID:   cmd.OrgID,
+		Name:    cmd.Name,
+		Quota:   cmd.Quota,
+		Updated: s.now(),
+		KeyIDs:  keyIDs,
+	}
+	if err := s.store.UpdatePool(ctx, pool); err != nil {
+		return nil, err
+	}
+	return s.store.GetPool(ctx, cmd.ID)
+}
+
+func (s *Service) DeleteAPIKeyPool(ctx context.Context, orgID, poolID int64) error {
+	if _, err := s.GetAPIKeyPool(ctx, orgID, poolID); err != nil {
+		return err
+	}
+	if err := s.store.DeletePool(ctx, poolID); err != nil {
+		return err
+	}
+	s.poolCursors.Delete(poolID)
+	return nil
+}
+
+// SelectKeyFromPool picks the active members of the pool in turn, in the order
+// of their IDs. Members that were deleted, are unconfirmed, are revoked or
+// expired past their grace period are skipped. The turn is kept in memory, so each Grafana
+// instance goes round the pool on its own, while the quota is shared.
+func (s *Service) SelectKeyFromPool(ctx context.Context, poolID int64) (*apikey.APIKey, error) {
+	pool, err := s.store.GetPool(ctx, poolID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	active := make([]*apikey.APIKey, 0, len(pool.KeyIDs))
+	for _, keyID := range pool.KeyIDs {
+		query := &apikey.GetByIDQuery{ApiKeyId: keyID}
+		if err := s.store.GetApiKeyById(ctx, query); err != nil {
+			if errors.Is(err, apikey.ErrInvalid) {
+				continue
+			}
+			return nil, err
+		}
+		key := query.Result
+		if !key.Active || (key.IsRevoked != nil && *key.IsRevoked) {
+			continue
+		}
+		if expired, graceRemaining := key.GracePeriodRemaining(now); expired && graceRemaining == 0 {
+			continue
+		}
+		active = append(active, key)
+	}
+	if len(active) == 0 {
+		return nil, apikey.ErrPoolNoActiveKeys
+	}
+
+	cursor, _ := s.poolCursors.LoadOrStore(poolID, new(uint64))
+	n := atomic.AddUint64(cursor.(*uint64), 1) - 1
+	key := active[n%uint64(len(active))]
+
+	if err := s.store.IncrementPoolUsage(ctx, poolID, key.Id, pool.Quota); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// poolKeyIDs returns the distinct keyIDs in ascending order, checking that
+// they are keys of the org.
+func (s *Service) poolKeyIDs(ctx context.Context, orgID int64, keyIDs []int64) ([]int64, error) {
+	seen := make(map[int64]bool, len(keyIDs))
+	result := make([]int64, 0, len(keyIDs))
+	for _, keyID := range keyIDs {
+		if seen[keyID] {
+			continue
+		}
+		seen[keyID] = true
+
+		query := &apikey.GetByIDQuery{ApiKeyId: keyID}
+		if err := s.store.GetApiKeyById(ctx, query); err != nil {
+			if errors.Is(err, apikey.ErrInvalid) {
+				return nil, fmt.Errorf("%w: key %d", apikey.ErrInvalidPoolMember, keyID)
+			}
+			return nil, err
+		}
+		if query.Result.OrgId != orgID {
+			return nil, fmt.Errorf("%w: key %d", apikey.ErrInvalidPoolMember, keyID)
+		}
+		result = append(result, keyID)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result, nil
+}
+
+func (s *Service) GetAPIKeysByRole(ctx context.Context, query *apikey.GetByRoleQuery) ([]*apikey.APIKey, error) {
+	return s.store.GetAPIKeysByRole(ctx, query)
+}
+
+func (s *Service) ListAPIKeysByStatus(ctx context.Context, orgID int64, status apikey.KeyStatus) ([]*apikey.APIKey, error) {
+	return s.store.ListAPIKeysByStatus(ctx, orgID, status, s.now())
+}
+
+// CountAPIKeysByStatus counts the keys in each status at the same instant, so
+// that a key moving between statuses is not counted twice.
+func (s *Service) CountAPIKeysByStatus(ctx context.Context, orgID int64) (map[apikey.KeyStatus]int64, error) {
+	now := s.now()
+	counts := make(map[apikey.KeyStatus]int64, len(apikey.KeyStatuses))
+	for _, status := range apikey.KeyStatuses {
+		count, err := s.store.CountAPIKeysByStatus(ctx, orgID, status, now)
+		if err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, nil
+}
+
 func (s *Service) DeleteApiKey(ctx context.Context, cmd *apikey.DeleteCommand) error {
-	return s.store.DeleteApiKey(ctx, cmd)
+	if err := s.store.DeleteApiKey(ctx, cmd); err != nil {
+		return err
+	}
+	s.metrics.Deleted.WithLabelValues(apikey.OrgLabel(cmd.OrgId)).Inc()
+	s.notify(cmd.OrgId, cmd.Id, apikey.KeyEventDeleted, nil)
+	return nil
 }
+
+// AddAPIKey stores a key whose Key holds the legacy hash of its secret,
+// hashed with the preferred hash version. If Key is empty, a token is
+// generated for the key and returned in cmd.Token, and nothing is stored if
+// the generation fails. Keys whose generated token or RawKey is shorter than
+// apikey.MinTokenLength or below the configured entropy are rejected with
+// apikey.ErrTokenEntropyTooLow, keys with a malformed CIDR in their
+// allowlist with apikey.ErrInvalidCIDR, and keys with a malformed scope with
+// apikey.ErrInvalidScope.
+//
+// Keys added with a creation secret are inactive until their creation is
+// confirmed with ConfirmAPIKeyCreation.
 func (s *Service) AddAPIKey(ctx context.Context, cmd *apikey.AddCommand) error {
-	return s.store.AddAPIKey(ctx, cmd)
+	raw := string(cmd.RawKey)
+	if cmd.Key == "" {
+		token, hash, err := s.tokenGenerator.GenerateToken(ctx, &apikey.TokenMeta{OrgID: cmd.OrgId, Name: cmd.Name})
+		if err != nil {
+			return fmt.Errorf("failed to generate API key token: %w", err)
+		}
+		cmd.Key, cmd.HashVersion, cmd.Token = hash, apikey.HashVersionPBKDF2, apikey.RedactedToken(token)
+		raw = token
+	}
+	// the entropy of Key, a hash, says nothing about the secret
+	if raw != "" {
+		if err := apikey.ValidateTokenEntropyMin(raw, s.minTokenEntropy); err != nil {
+			return err
+		}
+	}
+	if err := apikey.ValidateCIDRs(cmd.AllowedCIDRs); err != nil {
+		return err
+	}
+	if err := apikey.ValidateScopes(cmd.Scopes); err != nil {
+		return err
+	}
+
+	hash, verifier, err := apikey.UpgradeHash(cmd.Key, cmd.HashVersion, s.preferredHashVersion)
+	if err != nil {
+		return err
+	}
+	cmd.Key, cmd.VerifierHash, cmd.HashVersion = hash, verifier, s.preferredHashVersion
+	if cmd.CreationSecret != "" {
+		if cmd.CreationSecretHash, err = apikey.HashCreationSecret(cmd.CreationSecret, cmd.Key); err != nil {
+			return err
+		}
+	}
+	if err := s.store.AddAPIKey(ctx, cmd); err != nil {
+		return err
+	}
+	s.metrics.Created.WithLabelValues(apikey.OrgLabel(cmd.OrgId)).Inc()
+	s.notify(cmd.OrgId, cmd.Result.Id, apikey.KeyEventCreated, cmd.Result.Expires)
+	return nil
+}
+
+// ConfirmAPIKeyCreation activates the key if cmd carries the creation secret
+// it was added with. Confirming an active key has no effect. The creation can
+// be confirmed within apikey.UnconfirmedKeyLifetime of adding the key, with at
+// most apikey.MaxConfirmationAttempts attempts.
+func (s *Service) ConfirmAPIKeyCreation(ctx context.Context, cmd *apikey.ConfirmCommand) error {
+	query := &apikey.GetByIDQuery{ApiKeyId: cmd.KeyID}
+	if err := s.store.GetApiKeyById(ctx, query); err != nil {
+		return err
+	}
+	key := query.Result
+	if key.OrgId != cmd.OrgID {
+		return apikey.ErrInvalid
+	}
+	if key.Active {
+		return nil
+	}
+	if !s.now().Before(key.Created.Add(apikey.UnconfirmedKeyLifetime)) {
+		return apikey.ErrCreationConfirmationExpired
+	}
+	// The attempt is counted before the secret is checked, so that concurrent
+	// attempts cannot exceed the limit.
+	added, err := s.store.AddConfirmationAttempt(ctx, key.Id, apikey.MaxConfirmationAttempts)
+	if err != nil {
+		return err
+	}
+	if !added {
+		return apikey.ErrTooManyConfirmationAttempts
+	}
+
+	valid, err := key.VerifyCreationSecret(cmd.CreationSecret)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return apikey.ErrCreationSecretMismatch
+	}
+	return s.store.ActivateAPIKey(ctx, key.Id)
 }
+
+// DeleteUnconfirmedAPIKeys deletes the keys added before olderThan whose
+// creation has not been confirmed.
+func (s *Service) DeleteUnconfirmedAPIKeys(ctx context.Context, olderThan time.Time) (int64, error) {
+	deleted, err := s.store.DeleteUnconfirmedAPIKeys(ctx, olderThan)
+	if err != nil {
+		return 0, err
+	}
+	if deleted > 0 {
+		s.log.Debug("Deleted unconfirmed API keys", "count", deleted)
+	}
+	return deleted, nil
+}
+
+// notify sends the event to the webhook, if one is configured. Delivery happens
+// in the background so that a slow or failing webhook never fails or delays
+// the change to the key; failures are only logged.
+func (s *Service) notify(orgID, keyID int64, eventType apikey.KeyEventType, expires *int64) {
+	if s.notifier == nil {
+		return
+	}
+
+	event := &apikey.KeyEvent{
+		EventType: eventType,
+		KeyID:     keyID,
+		OrgID:     orgID,
+		Timestamp: s.now(),
+	}
+	if expires != nil {
+		expiresAt := time.Unix(*expires, 0)
+		event.ExpiresAt = &expiresAt
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+		defer cancel()
+		if err := s.notifier.Notify(ctx, event); err != nil {
+			s.log.Warn("Failed to notify API key webhook", "event", eventType, "keyID", keyID, "error", err)
+		}
+	}()
+}
+
+func (s *Service) UpdateAPIKeyGracePeriod(ctx context.Context, cmd *apikey.GraceCommand) error {
+	if cmd.GracePeriodSeconds < 0 {
+		return apikey.ErrInvalidGracePeriod
+	}
+	return s.store.UpdateAPIKeyGracePeriod(ctx, cmd)
+}
+
+func (s *Service) UpdateAPIKeyAllowedCIDRs(ctx context.Context, cmd *apikey.UpdateCIDRCommand) error {
+	if err := apikey.ValidateCIDRs(cmd.AllowedCIDRs); err != nil {
+		return err
+	}
+	return s.store.UpdateAPIKeyAllowedCIDRs(ctx, cmd)
+}
+
+func (s *Service) UpdateAPIKeyScopes(ctx context.Context, cmd *apikey.UpdateScopesCommand) error {
+	if err := apikey.ValidateScopes(cmd.Scopes); err != nil {
+		return err
+	}
+	return s.store.UpdateAPIKeyScopes(ctx, cmd)
+}
+
+// ValidateAPIKeyScopes returns apikey.ErrScopeNotGranted unless the key
+// carries all of requiredScopes. A key without scopes carries none.
+func (s *Service) ValidateAPIKeyScopes(ctx context.Context, keyID int64, requiredScopes []string) error {
+	query := &apikey.GetByIDQuery{ApiKeyId: keyID}
+	if err := s.store.GetApiKeyById(ctx, query); err != nil {
+		return err
+	}
+	return query.Result.Scopes.Contains(requiredScopes)
+}
+
+// RenewAPIKeyExpiry moves the expiry of a key to cmd.NewExpiresAt, which must
+// be later than its current expiry, and publishes an events.APIKeyRenewed.
+// Keys that never expire cannot be renewed.
+func (s *Service) RenewAPIKeyExpiry(ctx context.Context, cmd *apikey.RenewCommand) error {
+	if err := s.store.RenewAPIKeyExpiry(ctx, cmd); err != nil {
+		return err
+	}
+
+	return s.bus.Publish(ctx, &events.APIKeyRenewed{
+		Timestamp: s.now(),
+		ID:        cmd.KeyID,
+		OrgID:     cmd.OrgID,
+		Expires:   cmd.NewExpiresAt.Unix(),
+	})
+}
+
 func (s *Service) UpdateAPIKeyLastUsedDate(ctx context.Context, tokenID int64) error {
 	return s.store.UpdateAPIKeyLastUsedDate(ctx, tokenID)
 }
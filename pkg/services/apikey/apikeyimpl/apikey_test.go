@@ -0,0 +1,911 @@
+package apikeyimpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/components/apikeygen"
+	apikeygenprefix "github.com/grafana/grafana/pkg/components/apikeygenprefixed"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/infra/db"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/tracing"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/org"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/grafana/grafana/pkg/util"
+)
+
+func TestIntegrationHashVersions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	newService := func(version apikey.HashVersion) *Service {
+		return &Service{
+			store:                &sqlStore{db: testDB, cfg: testDB.Cfg},
+			log:                  log.NewNopLogger(),
+			metrics:              apikey.NewMetrics(nil),
+			now:                  time.Now,
+			preferredHashVersion: version,
+		}
+	}
+
+	legacyHash, err := util.EncodePassword("secret", "salt")
+	require.NoError(t, err)
+
+	legacy := newService(apikey.HashVersionPBKDF2)
+	cmd := &apikey.AddCommand{OrgId: 1, Name: "legacy", Key: legacyHash}
+	require.NoError(t, legacy.AddAPIKey(context.Background(), cmd))
+	require.Equal(t, apikey.HashVersionPBKDF2, cmd.Result.HashVersion)
+
+	t.Run("legacy key authenticates before migration", func(t *testing.T) {
+		key, err := legacy.GetAPIKeyByHash(context.Background(), legacyHash)
+		require.NoError(t, err)
+		assert.Equal(t, cmd.Result.Id, key.Id)
+		assert.Equal(t, apikey.HashVersionPBKDF2, key.HashVersion)
+	})
+
+	t.Run("legacy key is rehashed on use", func(t *testing.T) {
+		s := newService(apikey.HashVersionSHA256)
+		key, err := s.GetAPIKeyByHash(context.Background(), legacyHash)
+		require.NoError(t, err)
+		assert.Equal(t, apikey.HashVersionSHA256, key.HashVersion)
+
+		query := apikey.GetByIDQuery{ApiKeyId: cmd.Result.Id}
+		require.NoError(t, s.GetApiKeyById(context.Background(), &query))
+		assert.Equal(t, apikey.HashVersionSHA256, query.Result.HashVersion)
+		assert.NotEqual(t, legacyHash, query.Result.Key)
+
+		valid, err := query.Result.VerifyHash(legacyHash)
+		require.NoError(t, err)
+		assert.True(t, valid)
+	})
+
+	t.Run("key authenticates after bulk migration", func(t *testing.T) {
+		s := newService(apikey.HashVersionSHA256)
+		n, err := s.MigrateHashAlgorithm(context.Background(), 1, apikey.HashAlgorithmArgon2id)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		n, err = s.MigrateHashAlgorithm(context.Background(), 1, apikey.HashAlgorithmArgon2id)
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+
+		key, err := s.GetAPIKeyByHash(context.Background(), legacyHash)
+		require.NoError(t, err)
+		assert.Equal(t, apikey.HashVersionArgon2id, key.HashVersion)
+		assert.NotEmpty(t, key.VerifierHash)
+		assert.NotContains(t, legacyHash, key.Key)
+	})
+
+	t.Run("key authenticates after the preferred algorithm is lowered", func(t *testing.T) {
+		key, err := legacy.GetAPIKeyByHash(context.Background(), legacyHash)
+		require.NoError(t, err)
+		assert.Equal(t, apikey.HashVersionArgon2id, key.HashVersion)
+	})
+
+	t.Run("bcrypt key is skipped by bulk migration and upgraded on use", func(t *testing.T) {
+		bcryptHash, err := util.EncodePassword("bcrypt-secret", "salt")
+		require.NoError(t, err)
+		bcryptCmd := &apikey.AddCommand{OrgId: 1, Name: "bcrypt", Key: bcryptHash}
+		require.NoError(t, newService(apikey.HashVersionBcrypt).AddAPIKey(context.Background(), bcryptCmd))
+		require.Equal(t, apikey.HashVersionBcrypt, bcryptCmd.Result.HashVersion)
+
+		s := newService(apikey.HashVersionSHA256)
+		n, err := s.MigrateHashAlgorithm(context.Background(), 1, apikey.HashAlgorithmSHA256)
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+
+		key, err := s.GetAPIKeyByHash(context.Background(), bcryptHash)
+		require.NoError(t, err)
+		assert.Equal(t, bcryptCmd.Result.Id, key.Id)
+		assert.Equal(t, apikey.HashVersionSHA256, key.HashVersion)
+
+		key, err = s.GetAPIKeyByHash(context.Background(), bcryptHash)
+		require.NoError(t, err)
+		assert.Equal(t, apikey.HashVersionSHA256, key.HashVersion)
+	})
+
+	t.Run("key with a wrong verifier hash does not authenticate", func(t *testing.T) {
+		lookup := apikey.LookupHashes(legacyHash)[apikey.HashVersionBcrypt]
+		key := &apikey.APIKey{Key: lookup, HashVersion: apikey.HashVersionBcrypt}
+		valid, err := key.VerifyHash(legacyHash)
+		require.NoError(t, err)
+		assert.False(t, valid)
+	})
+
+	t.Run("unknown algorithm is rejected", func(t *testing.T) {
+		_, err := legacy.MigrateHashAlgorithm(context.Background(), 1, "md5")
+		assert.ErrorIs(t, err, apikey.ErrInvalidHashAlgorithm)
+	})
+
+	t.Run("wrong secret does not authenticate", func(t *testing.T) {
+		wrongHash, err := util.EncodePassword("wrong", "salt")
+		require.NoError(t, err)
+		_, err = legacy.GetAPIKeyByHash(context.Background(), wrongHash)
+		assert.ErrorIs(t, err, apikey.ErrInvalid)
+	})
+}
+
+func TestIntegrationMetrics(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	reg := prometheus.NewPedanticRegistry()
+	s := ProvideService(testDB, testDB.Cfg, reg, bus.ProvideBus(tracing.InitializeTracerForTest()), nil).(*Service)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	m := s.metrics
+
+	add := func(t *testing.T, name string, secondsToLive int64) (*apikey.APIKey, string) {
+		t.Helper()
+		hash, err := util.EncodePassword(name, "salt")
+		require.NoError(t, err)
+		cmd := &apikey.AddCommand{OrgId: 1, Name: name, Key: hash, SecondsToLive: secondsToLive}
+		require.NoError(t, s.AddAPIKey(context.Background(), cmd))
+		return cmd.Result, hash
+	}
+
+	valid, validHash := add(t, "valid", 0)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.Created.WithLabelValues("1")))
+
+	t.Run("successful authentication", func(t *testing.T) {
+		_, err := s.GetAPIKeyByHash(context.Background(), validHash)
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.AuthSuccess.WithLabelValues("1")))
+
+		_, err = s.VerifyAPIKey(context.Background(), 1, "valid", validHash)
+		require.NoError(t, err)
+		assert.Equal(t, 2.0, testutil.ToFloat64(m.AuthSuccess.WithLabelValues("1")))
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		_, err := s.GetAPIKeyByHash(context.Background(), "unknown")
+		require.ErrorIs(t, err, apikey.ErrInvalid)
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.AuthFailure.WithLabelValues(apikey.UnknownOrgLabel, apikey.AuthFailureNotFound)))
+
+		_, err = s.VerifyAPIKey(context.Background(), 1, "unknown", validHash)
+		require.ErrorIs(t, err, apikey.ErrInvalid)
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.AuthFailure.WithLabelValues("1", apikey.AuthFailureNotFound)))
+	})
+
+	t.Run("hash mismatch", func(t *testing.T) {
+		_, err := s.VerifyAPIKey(context.Background(), 1, "valid", "wrong")
+		require.ErrorIs(t, err, apikey.ErrHashMismatch)
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.AuthFailure.WithLabelValues("1", apikey.AuthFailureHashMismatch)))
+	})
+
+	t.Run("expired key", func(t *testing.T) {
+		_, expiredHash := add(t, "expired", 1)
+		s.now = func() time.Time { return now.Add(time.Hour) }
+		defer func() { s.now = func() time.Time { return now } }()
+
+		_, err := s.GetAPIKeyByHash(context.Background(), expiredHash)
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.AuthFailure.WithLabelValues("1", apikey.AuthFailureExpired)))
+		assert.Equal(t, 2.0, testutil.ToFloat64(m.AuthSuccess.WithLabelValues("1")))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, s.DeleteApiKey(context.Background(), &apikey.DeleteCommand{Id: valid.Id, OrgId: 1}))
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.Deleted.WithLabelValues("1")))
+	})
+
+	t.Run("metrics are registered", func(t *testing.T) {
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		names := make([]string, 0, len(families))
+		for _, f := range families {
+			names = append(names, f.GetName())
+		}
+		assert.ElementsMatch(t, []string{
+			"grafana_apikey_created_total",
+			"grafana_apikey_deleted_total",
+			"grafana_apikey_auth_success_total",
+			"grafana_apikey_auth_failure_total",
+		}, names)
+	})
+}
+
+func TestIntegrationRenewAPIKeyExpiry(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	b := bus.ProvideBus(tracing.InitializeTracerForTest())
+	s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), b, nil)
+
+	var published []*events.APIKeyRenewed
+	b.AddEventListener(func(ctx context.Context, e *events.APIKeyRenewed) error {
+		published = append(published, e)
+		return nil
+	})
+
+	hash, err := util.EncodePassword("renew", "salt")
+	require.NoError(t, err)
+	cmd := &apikey.AddCommand{OrgId: 1, Name: "renew", Key: hash, SecondsToLive: 3600}
+	require.NoError(t, s.AddAPIKey(context.Background(), cmd))
+
+	newExpiry := time.Unix(*cmd.Result.Expires, 0).Add(time.Hour)
+	err = s.RenewAPIKeyExpiry(context.Background(), &apikey.RenewCommand{KeyID: cmd.Result.Id, OrgID: 1, NewExpiresAt: newExpiry})
+	require.NoError(t, err)
+	require.Len(t, published, 1)
+	assert.Equal(t, cmd.Result.Id, published[0].ID)
+	assert.Equal(t, int64(1), published[0].OrgID)
+	assert.Equal(t, newExpiry.Unix(), published[0].Expires)
+
+	err = s.RenewAPIKeyExpiry(context.Background(), &apikey.RenewCommand{KeyID: cmd.Result.Id, OrgID: 1, NewExpiresAt: newExpiry})
+	require.ErrorIs(t, err, apikey.ErrInvalidRenewal)
+	require.Len(t, published, 1)
+}
+
+func TestIntegrationServiceTokens(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), nil)
+
+	token, err := s.GenerateServiceToken(context.Background(), &apikey.ServiceTokenCommand{OrgID: 1, ServiceAccountID: 10, Name: "service", SecondsToLive: 3600})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(token.Token, "glst_"), token.Token)
+	require.NotNil(t, token.Expires)
+
+	decoded, err := apikeygenprefix.Decode(token.Token)
+	require.NoError(t, err)
+	tokenHash, err := decoded.Hash()
+	require.NoError(t, err)
+
+	t.Run("service token authenticates", func(t *testing.T) {
+		found, err := s.GetServiceTokenByHash(context.Background(), tokenHash)
+		require.NoError(t, err)
+		assert.Equal(t, token.ID, found.ID)
+		assert.Equal(t, int64(10), found.ServiceAccountID)
+		assert.Empty(t, found.Token)
+
+		tokens, err := s.ListServiceTokens(context.Background(), 10)
+		require.NoError(t, err)
+		require.Len(t, tokens, 1)
+		assert.Equal(t, token.ID, tokens[0].ID)
+	})
+
+	t.Run("service token is not an API key", func(t *testing.T) {
+		_, err := s.GetAPIKeyByHash(context.Background(), tokenHash)
+		assert.ErrorIs(t, err, apikey.ErrInvalid)
+	})
+
+	t.Run("API key is not a service token", func(t *testing.T) {
+		gen, err := apikeygenprefix.New("sa")
+		require.NoError(t, err)
+		saID := int64(10)
+		cmd := &apikey.AddCommand{OrgId: 1, Name: "sa-token", Key: gen.HashedKey, ServiceAccountID: &saID, HashVersion: apikey.HashVersionPBKDF2}
+		require.NoError(t, s.AddAPIKey(context.Background(), cmd))
+
+		_, err = s.GetServiceTokenByHash(context.Background(), gen.HashedKey)
+		assert.ErrorIs(t, err, apikey.ErrInvalid)
+	})
+
+	t.Run("invalid commands are rejected", func(t *testing.T) {
+		_, err := s.GenerateServiceToken(context.Background(), &apikey.ServiceTokenCommand{OrgID: 1, ServiceAccountID: 10, Name: "service"})
+		assert.ErrorIs(t, err, apikey.ErrDuplicate)
+
+		_, err = s.GenerateServiceToken(context.Background(), &apikey.ServiceTokenCommand{OrgID: 1, ServiceAccountID: 10, Name: "negative", SecondsToLive: -1})
+		assert.ErrorIs(t, err, apikey.ErrInvalidExpiration)
+	})
+}
+
+func TestIntegrationOrgAPIKeyQuota(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	testDB.Cfg.Quota.Org = &setting.OrgQuota{ApiKey: 10}
+	s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), nil)
+
+	limit, err := s.GetOrgAPIKeyQuota(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), limit)
+
+	require.NoError(t, s.SetOrgAPIKeyQuota(context.Background(), 1, 3))
+
+	t.Run("override takes precedence over the configured default", func(t *testing.T) {
+		limit, err := s.GetOrgAPIKeyQuota(context.Background(), 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), limit)
+
+		limit, err = s.GetOrgAPIKeyQuota(context.Background(), 2)
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), limit)
+	})
+
+	t.Run("override is used by quota checks", func(t *testing.T) {
+		query := &models.GetOrgQuotaByTargetQuery{OrgId: 1, Target: apikey.QuotaTarget, Default: testDB.Cfg.Quota.Org.ApiKey}
+		require.NoError(t, testDB.GetOrgQuotaByTarget(context.Background(), query))
+		assert.Equal(t, int64(3), query.Result.Limit)
+	})
+}
+
+func TestIntegrationCountAPIKeysByStatus(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), nil)
+
+	for name, secondsToLive := range map[string]int64{"active": 0, "expiring": 3600} {
+		hash, err := util.EncodePassword(name, "salt")
+		require.NoError(t, err)
+		require.NoError(t, s.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: name, Key: hash, SecondsToLive: secondsToLive}))
+	}
+
+	counts, err := s.CountAPIKeysByStatus(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, map[apikey.KeyStatus]int64{
+		apikey.KeyStatusActive:       1,
+		apikey.KeyStatusExpiringSoon: 1,
+		apikey.KeyStatusExpired:      0,
+		apikey.KeyStatusDeleted:      0,
+	}, counts)
+}
+
+func TestIntegrationAddAPIKeyTokenEntropy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), nil)
+
+	add := func(name, secret string) error {
+		hash, err := util.EncodePassword(secret, name)
+		require.NoError(t, err)
+		return s.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: name, Key: hash, RawKey: apikey.RedactedToken(secret)})
+	}
+
+	assert.ErrorIs(t, add("weak", strings.Repeat("ab", 32)), apikey.ErrTokenEntropyTooLow)
+
+	token, err := apikey.GenerateSecureToken(64)
+	require.NoError(t, err)
+	require.NoError(t, add("strong", token))
+
+	t.Run("generated tokens are checked", func(t *testing.T) {
+		gen := &fakeTokenGenerator{token: strings.Repeat("a", 64), hash: token}
+		s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), gen)
+
+		err := s.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: "generated"})
+		assert.ErrorIs(t, err, apikey.ErrTokenEntropyTooLow)
+	})
+}
+
+func TestIntegrationAPIKeyAllowedCIDRs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), nil)
+
+	token, err := apikey.GenerateSecureToken(64)
+	require.NoError(t, err)
+	err = s.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: "malformed", Key: token, AllowedCIDRs: []string{"10.0.0.0/33"}})
+	assert.ErrorIs(t, err, apikey.ErrInvalidCIDR)
+
+	cmd := &apikey.AddCommand{OrgId: 1, Name: "restricted", Key: token, AllowedCIDRs: []string{"10.0.0.0/8"}}
+	require.NoError(t, s.AddAPIKey(context.Background(), cmd))
+
+	err = s.UpdateAPIKeyAllowedCIDRs(context.Background(), &apikey.UpdateCIDRCommand{ID: cmd.Result.Id, OrgID: 1, AllowedCIDRs: []string{"192.168.1.1"}})
+	assert.ErrorIs(t, err, apikey.ErrInvalidCIDR)
+}
+
+func TestIntegrationAPIKeyScopes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), nil)
+
+	token, err := apikey.GenerateSecureToken(64)
+	require.NoError(t, err)
+	err = s.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: "malformed", Key: token, Scopes: []string{"grafana:read dashboards"}})
+	assert.ErrorIs(t, err, apikey.ErrInvalidScope)
+
+	cmd := &apikey.AddCommand{OrgId: 1, Name: "reader", Key: token, Scopes: []string{"grafana:read:dashboards"}}
+	require.NoError(t, s.AddAPIKey(context.Background(), cmd))
+
+	require.NoError(t, s.ValidateAPIKeyScopes(context.Background(), cmd.Result.Id, []string{"grafana:read:dashboards"}))
+	err = s.ValidateAPIKeyScopes(context.Background(), cmd.Result.Id, []string{"grafana:write:dashboards"})
+	assert.ErrorIs(t, err, apikey.ErrScopeNotGranted)
+
+	err = s.UpdateAPIKeyScopes(context.Background(), &apikey.UpdateScopesCommand{ID: cmd.Result.Id, OrgID: 1, Scopes: []string{"grafana:read:dashboards", "grafana:write:dashboards"}})
+	require.NoError(t, err)
+	require.NoError(t, s.ValidateAPIKeyScopes(context.Background(), cmd.Result.Id, []string{"grafana:write:dashboards"}))
+
+	err = s.UpdateAPIKeyScopes(context.Background(), &apikey.UpdateScopesCommand{ID: cmd.Result.Id, OrgID: 1, Scopes: []string{""}})
+	assert.ErrorIs(t, err, apikey.ErrInvalidScope)
+}
+
+func TestIntegrationAPIKeyPools(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), nil)
+
+	keyIDs := make([]int64, 0, 3)
+	for i := 0; i < 3; i++ {
+		token, err := apikey.GenerateSecureToken(64)
+		require.NoError(t, err)
+		cmd := &apikey.AddCommand{OrgId: 1, Name: fmt.Sprintf("pooled-%d", i), Key: token}
+		require.NoError(t, s.AddAPIKey(context.Background(), cmd))
+		keyIDs = append(keyIDs, cmd.Result.Id)
+	}
+
+	selectN := func(t *testing.T, poolID int64, n int) []int64 {
+		t.Helper()
+		selected := make([]int64, 0, n)
+		for i := 0; i < n; i++ {
+			key, err := s.SelectKeyFromPool(context.Background(), poolID)
+			require.NoError(t, err)
+			selected = append(selected, key.Id)
+		}
+		return selected
+	}
+
+	t.Run("keys are selected in round-robin order", func(t *testing.T) {
+		pool, err := s.CreateAPIKeyPool(context.Background(), &apikey.CreatePoolCommand{OrgID: 1, Name: "round-robin", KeyIDs: []int64{keyIDs[2], keyIDs[0], keyIDs[1]}, Quota: -1})
+		require.NoError(t, err)
+		assert.Equal(t, keyIDs, pool.KeyIDs)
+
+		assert.Equal(t, []int64{keyIDs[0], keyIDs[1], keyIDs[2], keyIDs[0], keyIDs[1], keyIDs[2]}, selectN(t, pool.ID, 6))
+
+		pool, err = s.GetAPIKeyPool(context.Background(), 1, pool.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), pool.Usage)
+	})
+
+	t.Run("deleted member is skipped", func(t *testing.T) {
+		token, err := apikey.GenerateSecureToken(64)
+		require.NoError(t, err)
+		deleted := &apikey.AddCommand{OrgId: 1, Name: "deleted", Key: token}
+		require.NoError(t, s.AddAPIKey(context.Background(), deleted))
+
+		pool, err := s.CreateAPIKeyPool(context.Background(), &apikey.CreatePoolCommand{OrgID: 1, Name: "with-deleted", KeyIDs: []int64{keyIDs[0], deleted.Result.Id}, Quota: -1})
+		require.NoError(t, err)
+		require.NoError(t, s.DeleteApiKey(context.Background(), &apikey.DeleteCommand{Id: deleted.Result.Id, OrgId: 1}))
+
+		assert.Equal(t, []int64{keyIDs[0], keyIDs[0], keyIDs[0]}, selectN(t, pool.ID, 3))
+	})
+
+	t.Run("unconfirmed member is skipped", func(t *testing.T) {
+		token, err := apikey.GenerateSecureToken(64)
+		require.NoError(t, err)
+		unconfirmed := &apikey.AddCommand{OrgId: 1, Name: "unconfirmed", Key: token, CreationSecret: "creation secret"}
+		require.NoError(t, s.AddAPIKey(context.Background(), unconfirmed))
+
+		pool, err := s.CreateAPIKeyPool(context.Background(), &apikey.CreatePoolCommand{OrgID: 1, Name: "with-unconfirmed", KeyIDs: []int64{keyIDs[0], unconfirmed.Result.Id}, Quota: -1})
+		require.NoError(t, err)
+
+		assert.Equal(t, []int64{keyIDs[0], keyIDs[0], keyIDs[0]}, selectN(t, pool.ID, 3))
+	})
+
+	t.Run("quota is enforced per pool", func(t *testing.T) {
+		first, err := s.CreateAPIKeyPool(context.Background(), &apikey.CreatePoolCommand{OrgID: 1, Name: "first", KeyIDs: keyIDs[:2], Quota: 3})
+		require.NoError(t, err)
+		second, err := s.CreateAPIKeyPool(context.Background(), &apikey.CreatePoolCommand{OrgID: 1, Name: "second", KeyIDs: keyIDs[:2], Quota: 1})
+		require.NoError(t, err)
+
+		// the quota is shared by the keys, each of which was used less than 3 times
+		assert.Equal(t, []int64{keyIDs[0], keyIDs[1], keyIDs[0]}, selectN(t, first.ID, 3))
+		_, err = s.SelectKeyFromPool(context.Background(), first.ID)
+		assert.ErrorIs(t, err, apikey.ErrPoolQuotaExceeded)
+
+		// the same keys are still available from another pool
+		assert.Equal(t, []int64{keyIDs[0]}, selectN(t, second.ID, 1))
+		_, err = s.SelectKeyFromPool(context.Background(), second.ID)
+		assert.ErrorIs(t, err, apikey.ErrPoolQuotaExceeded)
+	})
+
+	t.Run("members are keys of the org", func(t *testing.T) {
+		_, err := s.CreateAPIKeyPool(context.Background(), &apikey.CreatePoolCommand{OrgID: 2, Name: "other-org", KeyIDs: keyIDs})
+		assert.ErrorIs(t, err, apikey.ErrInvalidPoolMember)
+
+		_, err = s.CreateAPIKeyPool(context.Background(), &apikey.CreatePoolCommand{OrgID: 1, Name: "unknown", KeyIDs: []int64{9999}})
+		assert.ErrorIs(t, err, apikey.ErrInvalidPoolMember)
+	})
+
+	t.Run("update keeps the usage of remaining members", func(t *testing.T) {
+		pool, err := s.CreateAPIKeyPool(context.Background(), &apikey.CreatePoolCommand{OrgID: 1, Name: "updated", KeyIDs: keyIDs[:2], Quota: -1})
+		require.NoError(t, err)
+		selectN(t, pool.ID, 2)
+
+		pool, err = s.UpdateAPIKeyPool(context.Background(), &apikey.UpdatePoolCommand{ID: pool.ID, OrgID: 1, Name: "renamed", KeyIDs: keyIDs[1:], Quota: 5})
+		require.NoError(t, err)
+		assert.Equal(t, "renamed", pool.Name)
+		assert.Equal(t, int64(5), pool.Quota)
+		assert.Equal(t, keyIDs[1:], pool.KeyIDs)
+		assert.Equal(t, int64(1), pool.Usage)
+
+		_, err = s.UpdateAPIKeyPool(context.Background(), &apikey.UpdatePoolCommand{ID: pool.ID, OrgID: 2, Name: "renamed"})
+		assert.ErrorIs(t, err, apikey.ErrPoolNotFound)
+	})
+
+	t.Run("deleted pool is not found", func(t *testing.T) {
+		pool, err := s.CreateAPIKeyPool(context.Background(), &apikey.CreatePoolCommand{OrgID: 1, Name: "deleted-pool", KeyIDs: keyIDs, Quota: -1})
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, s.DeleteAPIKeyPool(context.Background(), 2, pool.ID), apikey.ErrPoolNotFound)
+		require.NoError(t, s.DeleteAPIKeyPool(context.Background(), 1, pool.ID))
+
+		_, err = s.SelectKeyFromPool(context.Background(), pool.ID)
+		assert.ErrorIs(t, err, apikey.ErrPoolNotFound)
+	})
+}
+
+func TestIntegrationCleanupExpiredAPIKeys(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), nil).(*Service)
+
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return time.Now().Add(-48 * time.Hour) }
+	for i := 0; i < 5; i++ {
+		hash, err := util.EncodePassword(fmt.Sprintf("expired-%d", i), "salt")
+		require.NoError(t, err)
+		require.NoError(t, s.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: fmt.Sprintf("expired-%d", i), Key: hash, SecondsToLive: 60}))
+	}
+	timeNow = time.Now
+	hash, err := util.EncodePassword("valid", "salt")
+	require.NoError(t, err)
+	require.NoError(t, s.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: "valid", Key: hash, SecondsToLive: 3600}))
+
+	t.Run("keys expired too recently are not selected", func(t *testing.T) {
+		res, err := s.CleanupExpiredAPIKeys(context.Background(), &apikey.CleanupCommand{OlderThan: 72 * time.Hour})
+		require.NoError(t, err)
+		assert.Zero(t, res.Found)
+	})
+
+	t.Run("dry run does not delete", func(t *testing.T) {
+		res, err := s.CleanupExpiredAPIKeys(context.Background(), &apikey.CleanupCommand{OlderThan: 24 * time.Hour, DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, &apikey.CleanupResult{Found: 5}, res)
+
+		keys, err := s.GetAllAPIKeys(context.Background(), 1)
+		require.NoError(t, err)
+		assert.Len(t, keys, 6)
+	})
+
+	t.Run("live run deletes the expired keys in batches", func(t *testing.T) {
+		orgID := int64(1)
+		res, err := s.CleanupExpiredAPIKeys(context.Background(), &apikey.CleanupCommand{OrgID: &orgID, OlderThan: 24 * time.Hour, BatchSize: 2})
+		require.NoError(t, err)
+		assert.Equal(t, &apikey.CleanupResult{Found: 5, Deleted: 5}, res)
+
+		keys, err := s.GetAllAPIKeys(context.Background(), 1)
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.Equal(t, "valid", keys[0].Name)
+	})
+}
+
+func TestIntegrationValidateAPIKeys(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), nil).(*Service)
+
+	prefixed, err := apikeygenprefix.New("sa")
+	require.NoError(t, err)
+	prefixedCmd := &apikey.AddCommand{OrgId: 1, Name: "prefixed", Key: prefixed.HashedKey, SecondsToLive: 3600}
+	require.NoError(t, s.AddAPIKey(context.Background(), prefixedCmd))
+
+	legacy, err := apikeygen.New(1, "legacy")
+	require.NoError(t, err)
+	legacyCmd := &apikey.AddCommand{OrgId: 1, Name: "legacy", Key: legacy.HashedKey}
+	require.NoError(t, s.AddAPIKey(context.Background(), legacyCmd))
+
+	expired, err := apikeygenprefix.New("sa")
+	require.NoError(t, err)
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return time.Now().Add(-48 * time.Hour) }
+	expiredCmd := &apikey.AddCommand{OrgId: 1, Name: "expired", Key: expired.HashedKey, SecondsToLive: 60}
+	require.NoError(t, s.AddAPIKey(context.Background(), expiredCmd))
+	timeNow = time.Now
+
+	unknown, err := apikeygenprefix.New("sa")
+	require.NoError(t, err)
+	serviceToken, err := apikeygenprefix.New(apikey.ServiceTokenServiceID)
+	require.NoError(t, err)
+
+	results, err := s.ValidateAPIKeys(context.Background(), []string{
+		prefixed.ClientSecret,
+		expired.ClientSecret,
+		unknown.ClientSecret,
+		"not-a-token",
+		legacy.ClientSecret,
+		serviceToken.ClientSecret,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []apikey.ValidationResult{
+		{TokenID: prefixedCmd.Result.Id, Valid: true},
+		{TokenID: expiredCmd.Result.Id, Expired: true, Reason: apikey.ValidationExpired},
+		{Reason: apikey.ValidationNotFound},
+		{Reason: apikey.ValidationMalformed},
+		{TokenID: legacyCmd.Result.Id, Valid: true},
+		{Reason: apikey.ValidationNotFound},
+	}, results)
+
+	t.Run("keys hashed with bcrypt are verified once found", func(t *testing.T) {
+		_, err := s.MigrateHashAlgorithm(context.Background(), 1, apikey.HashAlgorithmBcrypt)
+		require.NoError(t, err)
+		// the lookup hash of the legacy key matches, but not its verifier hash
+		lookup := apikey.LookupHashes(legacyCmd.Key)[apikey.HashVersionBcrypt]
+		require.NoError(t, s.store.UpdateAPIKeyHash(context.Background(), legacyCmd.Result.Id, lookup, "$2a$10$invalid", apikey.HashVersionBcrypt))
+
+		results, err := s.ValidateAPIKeys(context.Background(), []string{
+			prefixed.ClientSecret,
+			prefixed.ClientSecret,
+			legacy.ClientSecret,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []apikey.ValidationResult{
+			{TokenID: prefixedCmd.Result.Id, Valid: true},
+			{TokenID: prefixedCmd.Result.Id, Valid: true},
+			{Reason: apikey.ValidationNotFound},
+		}, results)
+	})
+
+	t.Run("too many tokens are rejected", func(t *testing.T) {
+		_, err := s.ValidateAPIKeys(context.Background(), make([]string, apikey.MaxValidateTokens+1))
+		assert.ErrorIs(t, err, apikey.ErrTooManyTokens)
+	})
+}
+
+func TestIntegrationConfirmAPIKeyCreation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), nil).(*Service)
+
+	key, err := apikeygenprefix.New("sa")
+	require.NoError(t, err)
+	cmd := &apikey.AddCommand{OrgId: 1, Name: "confirmed", Key: key.HashedKey, CreationSecret: "creation secret"}
+	require.NoError(t, s.AddAPIKey(context.Background(), cmd))
+	legacyHash := key.HashedKey
+
+	t.Run("unconfirmed key fails authentication", func(t *testing.T) {
+		_, err := s.GetAPIKeyByHash(context.Background(), legacyHash)
+		assert.ErrorIs(t, err, apikey.ErrInvalid)
+
+		_, err = s.VerifyAPIKey(context.Background(), 1, "confirmed", legacyHash)
+		assert.ErrorIs(t, err, apikey.ErrInvalid)
+
+		results, err := s.ValidateAPIKeys(context.Background(), []string{key.ClientSecret})
+		require.NoError(t, err)
+		assert.Equal(t, []apikey.ValidationResult{{Reason: apikey.ValidationNotFound}}, results)
+	})
+
+	t.Run("wrong creation secret does not confirm", func(t *testing.T) {
+		err := s.ConfirmAPIKeyCreation(context.Background(), &apikey.ConfirmCommand{KeyID: cmd.Result.Id, OrgID: 1, CreationSecret: "wrong"})
+		assert.ErrorIs(t, err, apikey.ErrCreationSecretMismatch)
+
+		err = s.ConfirmAPIKeyCreation(context.Background(), &apikey.ConfirmCommand{KeyID: cmd.Result.Id, OrgID: 2, CreationSecret: "creation secret"})
+		assert.ErrorIs(t, err, apikey.ErrInvalid)
+
+		_, err = s.GetAPIKeyByHash(context.Background(), legacyHash)
+		assert.ErrorIs(t, err, apikey.ErrInvalid)
+	})
+
+	t.Run("confirmed key passes authentication", func(t *testing.T) {
+		require.NoError(t, s.ConfirmAPIKeyCreation(context.Background(), &apikey.ConfirmCommand{KeyID: cmd.Result.Id, OrgID: 1, CreationSecret: "creation secret"}))
+
+		found, err := s.GetAPIKeyByHash(context.Background(), legacyHash)
+		require.NoError(t, err)
+		assert.Equal(t, cmd.Result.Id, found.Id)
+
+		results, err := s.ValidateAPIKeys(context.Background(), []string{key.ClientSecret})
+		require.NoError(t, err)
+		assert.Equal(t, []apikey.ValidationResult{{TokenID: cmd.Result.Id, Valid: true}}, results)
+	})
+
+	t.Run("confirmation attempts are limited", func(t *testing.T) {
+		guessed, err := apikeygenprefix.New("sa")
+		require.NoError(t, err)
+		guessedCmd := &apikey.AddCommand{OrgId: 1, Name: "guessed", Key: guessed.HashedKey, CreationSecret: "creation secret"}
+		require.NoError(t, s.AddAPIKey(context.Background(), guessedCmd))
+
+		for i := 0; i < apikey.MaxConfirmationAttempts; i++ {
+			err := s.ConfirmAPIKeyCreation(context.Background(), &apikey.ConfirmCommand{KeyID: guessedCmd.Result.Id, OrgID: 1, CreationSecret: fmt.Sprintf("guess %d", i)})
+			require.ErrorIs(t, err, apikey.ErrCreationSecretMismatch)
+		}
+		err = s.ConfirmAPIKeyCreation(context.Background(), &apikey.ConfirmCommand{KeyID: guessedCmd.Result.Id, OrgID: 1, CreationSecret: "creation secret"})
+		assert.ErrorIs(t, err, apikey.ErrTooManyConfirmationAttempts)
+
+		_, err = s.GetAPIKeyByHash(context.Background(), guessed.HashedKey)
+		assert.ErrorIs(t, err, apikey.ErrInvalid)
+		require.NoError(t, s.DeleteApiKey(context.Background(), &apikey.DeleteCommand{Id: guessedCmd.Result.Id, OrgId: 1}))
+	})
+
+	t.Run("creation cannot be confirmed once expired", func(t *testing.T) {
+		late, err := apikeygenprefix.New("sa")
+		require.NoError(t, err)
+		lateCmd := &apikey.AddCommand{OrgId: 1, Name: "late", Key: late.HashedKey, CreationSecret: "creation secret"}
+		require.NoError(t, s.AddAPIKey(context.Background(), lateCmd))
+
+		s.now = func() time.Time { return time.Now().Add(apikey.UnconfirmedKeyLifetime) }
+		defer func() { s.now = time.Now }()
+		err = s.ConfirmAPIKeyCreation(context.Background(), &apikey.ConfirmCommand{KeyID: lateCmd.Result.Id, OrgID: 1, CreationSecret: "creation secret"})
+		assert.ErrorIs(t, err, apikey.ErrCreationConfirmationExpired)
+		require.NoError(t, s.DeleteApiKey(context.Background(), &apikey.DeleteCommand{Id: lateCmd.Result.Id, OrgId: 1}))
+	})
+
+	t.Run("stale unconfirmed keys are deleted", func(t *testing.T) {
+		stale, err := apikeygenprefix.New("sa")
+		require.NoError(t, err)
+		require.NoError(t, s.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: "stale", Key: stale.HashedKey, CreationSecret: "creation secret"}))
+
+		deleted, err := s.DeleteUnconfirmedAPIKeys(context.Background(), time.Now().Add(-apikey.UnconfirmedKeyLifetime))
+		require.NoError(t, err)
+		assert.Zero(t, deleted)
+
+		deleted, err = s.DeleteUnconfirmedAPIKeys(context.Background(), time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+
+		keys, err := s.GetAllAPIKeys(context.Background(), 1)
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.Equal(t, "confirmed", keys[0].Name)
+	})
+}
+
+type fakeWebhookNotifier struct {
+	events chan *apikey.KeyEvent
+}
+
+func (n *fakeWebhookNotifier) Notify(_ context.Context, event *apikey.KeyEvent) error {
+	n.events <- event
+	return nil
+}
+
+func (n *fakeWebhookNotifier) next(t *testing.T) *apikey.KeyEvent {
+	t.Helper()
+	select {
+	case event := <-n.events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook event")
+		return nil
+	}
+}
+
+func TestIntegrationAPIKeyWebhookEvents(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), nil).(*Service)
+	notifier := &fakeWebhookNotifier{events: make(chan *apikey.KeyEvent, 10)}
+	s.notifier = notifier
+
+	hash, err := util.EncodePassword("webhook", "salt")
+	require.NoError(t, err)
+	cmd := &apikey.AddCommand{OrgId: 1, Name: "webhook", Key: hash, SecondsToLive: 3600}
+	require.NoError(t, s.AddAPIKey(context.Background(), cmd))
+
+	event := notifier.next(t)
+	assert.Equal(t, apikey.KeyEventCreated, event.EventType)
+	assert.Equal(t, cmd.Result.Id, event.KeyID)
+	assert.Equal(t, int64(1), event.OrgID)
+	require.NotNil(t, event.ExpiresAt)
+	assert.Equal(t, *cmd.Result.Expires, event.ExpiresAt.Unix())
+
+	require.NoError(t, s.DeleteApiKey(context.Background(), &apikey.DeleteCommand{Id: cmd.Result.Id, OrgId: 1}))
+	event = notifier.next(t)
+	assert.Equal(t, apikey.KeyEventDeleted, event.EventType)
+	assert.Equal(t, cmd.Result.Id, event.KeyID)
+	assert.Nil(t, event.ExpiresAt)
+
+	defer func() { timeNow = time.Now }()
+	timeNow = func() time.Time { return time.Now().Add(-48 * time.Hour) }
+	expired := &apikey.AddCommand{OrgId: 1, Name: "expired", Key: hash, SecondsToLive: 60}
+	require.NoError(t, s.AddAPIKey(context.Background(), expired))
+	timeNow = time.Now
+	assert.Equal(t, apikey.KeyEventCreated, notifier.next(t).EventType)
+
+	_, err = s.CleanupExpiredAPIKeys(context.Background(), &apikey.CleanupCommand{OlderThan: time.Hour})
+	require.NoError(t, err)
+	event = notifier.next(t)
+	assert.Equal(t, apikey.KeyEventExpired, event.EventType)
+	assert.Equal(t, expired.Result.Id, event.KeyID)
+}
+
+type fakeTokenGenerator struct {
+	token string
+	hash  string
+	err   error
+	calls []apikey.TokenMeta
+}
+
+func (g *fakeTokenGenerator) GenerateToken(_ context.Context, meta *apikey.TokenMeta) (string, string, error) {
+	g.calls = append(g.calls, *meta)
+	return g.token, g.hash, g.err
+}
+
+func TestIntegrationTokenGenerator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testDB := db.InitTestDB(t)
+	randomHash := func() string {
+		hash, err := apikey.GenerateSecureToken(apikey.MinTokenLength)
+		require.NoError(t, err)
+		return hash
+	}
+
+	t.Run("generated token is returned and its hash is stored", func(t *testing.T) {
+		gen := &fakeTokenGenerator{token: "hsm-" + randomHash(), hash: randomHash()}
+		s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), gen)
+
+		cmd := &apikey.AddCommand{OrgId: 1, Name: "hsm", Role: org.RoleViewer}
+		require.NoError(t, s.AddAPIKey(context.Background(), cmd))
+		assert.Equal(t, apikey.RedactedToken(gen.token), cmd.Token)
+		assert.Equal(t, []apikey.TokenMeta{{OrgID: 1, Name: "hsm"}}, gen.calls)
+
+		found, err := s.GetAPIKeyByHash(context.Background(), gen.hash)
+		require.NoError(t, err)
+		assert.Equal(t, cmd.Result.Id, found.Id)
+	})
+
+	t.Run("explicit key skips the generator", func(t *testing.T) {
+		gen := &fakeTokenGenerator{token: "unused", hash: "unused"}
+		s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), gen)
+
+		cmd := &apikey.AddCommand{OrgId: 1, Name: "explicit", Role: org.RoleViewer, Key: randomHash()}
+		require.NoError(t, s.AddAPIKey(context.Background(), cmd))
+		assert.Empty(t, gen.calls)
+		assert.Empty(t, cmd.Token)
+	})
+
+	t.Run("generator failure does not persist the key", func(t *testing.T) {
+		gen := &fakeTokenGenerator{err: errors.New("hsm unavailable")}
+		s := ProvideService(testDB, testDB.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), gen)
+
+		cmd := &apikey.AddCommand{OrgId: 1, Name: "broken", Role: org.RoleViewer}
+		err := s.AddAPIKey(context.Background(), cmd)
+		require.ErrorContains(t, err, "hsm unavailable")
+		assert.Nil(t, cmd.Result)
+
+		query := &apikey.GetByNameQuery{OrgId: 1, KeyName: "broken"}
+		assert.ErrorIs(t, s.GetApiKeyByName(context.Background(), query), apikey.ErrInvalid)
+	})
+}
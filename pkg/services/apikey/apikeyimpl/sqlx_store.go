@@ -10,13 +10,15 @@ import (
 
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/sqlstore/migrator"
 	"github.com/grafana/grafana/pkg/services/sqlstore/session"
 	"github.com/grafana/grafana/pkg/setting"
 )
 
 type sqlxStore struct {
-	sess *session.SessionDB
-	cfg  *setting.Cfg
+	sess    *session.SessionDB
+	cfg     *setting.Cfg
+	dialect migrator.Dialect
 }
 
 func (ss *sqlxStore) GetAPIKeys(ctx context.Context, query *apikey.GetApiKeysQuery) error {
@@ -91,19 +93,25 @@ func (ss *sqlxStore) AddAPIKey(ctx context.Context, cmd *apikey.AddCommand) erro
 	}
 	isRevoked := false
 	t := apikey.APIKey{
-		OrgId:            cmd.OrgId,
-		Name:             cmd.Name,
-		Role:             cmd.Role,
-		Key:              cmd.Key,
-		Created:          updated,
-		Updated:          updated,
-		Expires:          expires,
-		ServiceAccountId: nil,
-		IsRevoked:        &isRevoked,
+		OrgId:              cmd.OrgId,
+		Name:               cmd.Name,
+		Role:               cmd.Role,
+		Key:                cmd.Key,
+		Created:            updated,
+		Updated:            updated,
+		Expires:            expires,
+		ServiceAccountId:   nil,
+		IsRevoked:          &isRevoked,
+		HashVersion:        hashVersion(cmd),
+		VerifierHash:       cmd.VerifierHash,
+		AllowedCIDRs:       cmd.AllowedCIDRs,
+		Scopes:             cmd.Scopes,
+		Active:             cmd.CreationSecretHash == "",
+		CreationSecretHash: cmd.CreationSecretHash,
 	}
 
 	t.Id, err = ss.sess.ExecWithReturningId(ctx,
-		`INSERT INTO api_key (org_id, name, role, "key", created, updated, expires, service_account_id, is_revoked) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, t.OrgId, t.Name, t.Role, t.Key, t.Created, t.Updated, t.Expires, t.ServiceAccountId, t.IsRevoked)
+		`INSERT INTO api_key (org_id, name, role, "key", created, updated, expires, service_account_id, is_revoked, hash_version, verifier_hash, allowed_cidrs, scopes, active, creation_secret_hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, t.OrgId, t.Name, t.Role, t.Key, t.Created, t.Updated, t.Expires, t.ServiceAccountId, t.IsRevoked, t.HashVersion, t.VerifierHash, t.AllowedCIDRs, t.Scopes, t.Active, t.CreationSecretHash)
 	cmd.Result = &t
 	return err
 }
@@ -137,8 +145,372 @@ func (ss *sqlxStore) GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.
 	return &key, err
 }
 
+func (ss *sqlxStore) GetAPIKeysByHashes(ctx context.Context, hashes []string) ([]*apikey.APIKey, error) {
+	result := make([]*apikey.APIKey, 0)
+	if len(hashes) == 0 {
+		return result, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(hashes)), ",")
+	args := make([]interface{}, 0, len(hashes))
+	for _, hash := range hashes {
+		args = append(args, hash)
+	}
+	err := ss.sess.Select(ctx, &result, `SELECT * FROM api_key WHERE "key" IN (`+placeholders+`) ORDER BY id ASC`, args...)
+	return result, err
+}
+
+func (ss *sqlxStore) GetAPIKeysByRole(ctx context.Context, query *apikey.GetByRoleQuery) ([]*apikey.APIKey, error) {
+	result := make([]*apikey.APIKey, 0)
+	err := ss.sess.Select(ctx, &result, "SELECT * FROM api_key WHERE org_id=? AND role=? ORDER BY name ASC", query.OrgID, query.Role)
+	return result, err
+}
+
+func (ss *sqlxStore) ListAPIKeysByStatus(ctx context.Context, orgID int64, status apikey.KeyStatus, now time.Time) ([]*apikey.APIKey, error) {
+	where, args, err := keyStatusFilter(orgID, status, now)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*apikey.APIKey, 0)
+	err = ss.sess.Select(ctx, &result, "SELECT * FROM api_key WHERE "+where+" ORDER BY name ASC", args...)
+	return result, err
+}
+
+func (ss *sqlxStore) CountAPIKeysByStatus(ctx context.Context, orgID int64, status apikey.KeyStatus, now time.Time) (int64, error) {
+	where, args, err := keyStatusFilter(orgID, status, now)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = ss.sess.Get(ctx, &count, "SELECT COUNT(*) FROM api_key WHERE "+where, args...)
+	return count, err
+}
+
 func (ss *sqlxStore) UpdateAPIKeyLastUsedDate(ctx context.Context, tokenID int64) error {
 	now := timeNow()
 	_, err := ss.sess.Exec(ctx, `UPDATE api_key SET last_used_at=? WHERE id=?`, &now, tokenID)
 	return err
 }
+
+func (ss *sqlxStore) UpdateAPIKeyGracePeriod(ctx context.Context, cmd *apikey.GraceCommand) error {
+	return ss.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		var id int64
+		err := tx.Get(ctx, &id, "SELECT id FROM api_key WHERE id=? AND org_id=?", cmd.ID, cmd.OrgID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return apikey.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE api_key SET grace_period_seconds=? WHERE id=?", cmd.GracePeriodSeconds, cmd.ID)
+		return err
+	})
+}
+
+func (ss *sqlxStore) UpdateAPIKeyAllowedCIDRs(ctx context.Context, cmd *apikey.UpdateCIDRCommand) error {
+	return ss.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		var id int64
+		err := tx.Get(ctx, &id, "SELECT id FROM api_key WHERE id=? AND org_id=?", cmd.ID, cmd.OrgID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return apikey.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE api_key SET allowed_cidrs=? WHERE id=?", apikey.CIDRList(cmd.AllowedCIDRs), cmd.ID)
+		return err
+	})
+}
+
+func (ss *sqlxStore) UpdateAPIKeyScopes(ctx context.Context, cmd *apikey.UpdateScopesCommand) error {
+	return ss.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		var id int64
+		err := tx.Get(ctx, &id, "SELECT id FROM api_key WHERE id=? AND org_id=?", cmd.ID, cmd.OrgID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return apikey.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE api_key SET scopes=? WHERE id=?", apikey.ScopeList(cmd.Scopes), cmd.ID)
+		return err
+	})
+}
+
+func (ss *sqlxStore) RenewAPIKeyExpiry(ctx context.Context, cmd *apikey.RenewCommand) error {
+	return ss.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		var key apikey.APIKey
+		err := tx.Get(ctx, &key, "SELECT * FROM api_key WHERE id=? AND org_id=?", cmd.KeyID, cmd.OrgID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return apikey.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		expires := cmd.NewExpiresAt.Unix()
+		if key.Expires == nil || expires <= *key.Expires {
+			return apikey.ErrInvalidRenewal
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE api_key SET expires=?, version=version+1, updated=? WHERE id=?", expires, timeNow(), cmd.KeyID)
+		return err
+	})
+}
+
+func (ss *sqlxStore) GetAPIKeysWithHashVersionBelow(ctx context.Context, orgID int64, version apikey.HashVersion) ([]*apikey.APIKey, error) {
+	result := make([]*apikey.APIKey, 0)
+	err := ss.sess.Select(ctx, &result, "SELECT * FROM api_key WHERE org_id=? AND hash_version<? ORDER BY id ASC", orgID, version)
+	return result, err
+}
+
+func (ss *sqlxStore) UpdateAPIKeyHash(ctx context.Context, tokenID int64, hash, verifier string, version apikey.HashVersion) error {
+	_, err := ss.sess.Exec(ctx, `UPDATE api_key SET "key"=?, verifier_hash=?, hash_version=? WHERE id=?`, hash, verifier, version, tokenID)
+	return err
+}
+
+func (ss *sqlxStore) ActivateAPIKey(ctx context.Context, keyID int64) error {
+	_, err := ss.sess.Exec(ctx, "UPDATE api_key SET active=?, updated=? WHERE id=?", true, timeNow(), keyID)
+	return err
+}
+
+func (ss *sqlxStore) AddConfirmationAttempt(ctx context.Context, keyID, max int64) (bool, error) {
+	res, err := ss.sess.Exec(ctx, "UPDATE api_key SET confirmation_attempts = confirmation_attempts + 1 WHERE id = ? AND confirmation_attempts < ?", keyID, max)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+func (ss *sqlxStore) DeleteUnconfirmedAPIKeys(ctx context.Context, createdBefore time.Time) (int64, error) {
+	res, err := ss.sess.Exec(ctx, "DELETE FROM api_key WHERE active=? AND created<?", false, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (ss *sqlxStore) AddServiceToken(ctx context.Context, token *apikey.ServiceToken) error {
+	return ss.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		var existing apikey.ServiceToken
+		err := tx.Get(ctx, &existing, "SELECT * FROM service_tokens WHERE org_id=? AND name=?", token.OrgID, token.Name)
+		if err == nil {
+			return apikey.ErrDuplicate
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		token.ID, err = tx.ExecWithReturningId(ctx,
+			`INSERT INTO service_tokens (org_id, service_account_id, name, "key", created, expires) VALUES (?, ?, ?, ?, ?, ?)`,
+			token.OrgID, token.ServiceAccountID, token.Name, token.Key, token.Created, token.Expires)
+		return err
+	})
+}
+
+func (ss *sqlxStore) GetServiceTokenByHash(ctx context.Context, hash string) (*apikey.ServiceToken, error) {
+	var token apikey.ServiceToken
+	err := ss.sess.Get(ctx, &token, `SELECT * FROM service_tokens WHERE "key"=?`, hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apikey.ErrInvalid
+	} else if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (ss *sqlxStore) ListServiceTokens(ctx context.Context, serviceAccountID int64) ([]*apikey.ServiceToken, error) {
+	result := make([]*apikey.ServiceToken, 0)
+	err := ss.sess.Select(ctx, &result, "SELECT * FROM service_tokens WHERE service_account_id=? ORDER BY name ASC", serviceAccountID)
+	return result, err
+}
+
+func (ss *sqlxStore) GetOrgQuota(ctx context.Context, orgID int64) (int64, bool, error) {
+	var limit int64
+	err := ss.sess.Get(ctx, &limit, "SELECT "+ss.dialect.Quote("limit")+" FROM quota WHERE org_id=? AND target=?", orgID, apikey.QuotaTarget)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return limit, true, nil
+}
+
+func (ss *sqlxStore) SetOrgQuota(ctx context.Context, orgID int64, limit int64) error {
+	return ss.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		var id int64
+		err := tx.Get(ctx, &id, "SELECT id FROM quota WHERE org_id=? AND target=?", orgID, apikey.QuotaTarget)
+		if errors.Is(err, sql.ErrNoRows) {
+			_, err = tx.Exec(ctx, "INSERT INTO quota (org_id, user_id, target, "+ss.dialect.Quote("limit")+", created, updated) VALUES (?, 0, ?, ?, ?, ?)",
+				orgID, apikey.QuotaTarget, limit, timeNow(), timeNow())
+			return err
+		} else if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "UPDATE quota SET "+ss.dialect.Quote("limit")+"=?, updated=? WHERE id=?", limit, timeNow(), id)
+		return err
+	})
+}
+
+func (ss *sqlxStore) CountExpiredAPIKeys(ctx context.Context, orgID *int64, expiredBefore int64) (int64, error) {
+	where, args := expiredKeysFilter(orgID, expiredBefore)
+	var count int64
+	err := ss.sess.Get(ctx, &count, "SELECT COUNT(*) FROM api_key WHERE "+where, args...)
+	return count, err
+}
+
+func (ss *sqlxStore) DeleteExpiredAPIKeys(ctx context.Context, orgID *int64, expiredBefore int64, limit int) ([]*apikey.APIKey, error) {
+	var keys []*apikey.APIKey
+	err := ss.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		where, args := expiredKeysFilter(orgID, expiredBefore)
+		if err := tx.Select(ctx, &keys, "SELECT * FROM api_key WHERE "+where+" ORDER BY id ASC LIMIT ?", append(args, limit)...); err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(keys)), ",")
+		idArgs := make([]interface{}, 0, len(keys))
+		for _, key := range keys {
+			idArgs = append(idArgs, key.Id)
+		}
+		_, err := tx.Exec(ctx, "DELETE FROM api_key WHERE id IN ("+placeholders+")", idArgs...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (ss *sqlxStore) AddPool(ctx context.Context, pool *apikey.Pool) error {
+	return ss.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		var existing apikey.Pool
+		err := tx.Get(ctx, &existing, "SELECT * FROM api_key_pool WHERE org_id=? AND name=?", pool.OrgID, pool.Name)
+		if err == nil {
+			return apikey.ErrDuplicate
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		pool.ID, err = tx.ExecWithReturningId(ctx,
+			"INSERT INTO api_key_pool (org_id, name, quota, created, updated) VALUES (?, ?, ?, ?, ?)",
+			pool.OrgID, pool.Name, pool.Quota, pool.Created, pool.Updated)
+		if err != nil {
+			return err
+		}
+		for _, keyID := range pool.KeyIDs {
+			if _, err := tx.Exec(ctx, "INSERT INTO api_key_pool_member (pool_id, key_id, usage_count) VALUES (?, ?, 0)", pool.ID, keyID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ss *sqlxStore) GetPool(ctx context.Context, poolID int64) (*apikey.Pool, error) {
+	var pool apikey.Pool
+	err := ss.sess.Get(ctx, &pool, "SELECT * FROM api_key_pool WHERE id=?", poolID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apikey.ErrPoolNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if err := ss.loadPoolMembers(ctx, &pool); err != nil {
+		return nil, err
+	}
+	return &pool, nil
+}
+
+func (ss *sqlxStore) ListPools(ctx context.Context, orgID int64) ([]*apikey.Pool, error) {
+	result := make([]*apikey.Pool, 0)
+	if err := ss.sess.Select(ctx, &result, "SELECT * FROM api_key_pool WHERE org_id=? ORDER BY name ASC", orgID); err != nil {
+		return nil, err
+	}
+	if err := ss.loadPoolMembers(ctx, result...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (ss *sqlxStore) UpdatePool(ctx context.Context, pool *apikey.Pool) error {
+	return ss.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		var existing apikey.Pool
+		err := tx.Get(ctx, &existing, "SELECT * FROM api_key_pool WHERE org_id=? AND name=? AND id<>?", pool.OrgID, pool.Name, pool.ID)
+		if err == nil {
+			return apikey.ErrDuplicate
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		res, err := tx.Exec(ctx, "UPDATE api_key_pool SET name=?, quota=?, updated=? WHERE id=? AND org_id=?",
+			pool.Name, pool.Quota, pool.Updated, pool.ID, pool.OrgID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return apikey.ErrPoolNotFound
+		}
+
+		var members []*apikey.PoolMember
+		if err := tx.Select(ctx, &members, "SELECT * FROM api_key_pool_member WHERE pool_id=?", pool.ID); err != nil {
+			return err
+		}
+		removed, added := diffPoolMembers(members, pool.KeyIDs)
+		for _, id := range removed {
+			if _, err := tx.Exec(ctx, "DELETE FROM api_key_pool_member WHERE id=?", id); err != nil {
+				return err
+			}
+		}
+		for _, keyID := range added {
+			if _, err := tx.Exec(ctx, "INSERT INTO api_key_pool_member (pool_id, key_id, usage_count) VALUES (?, ?, 0)", pool.ID, keyID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ss *sqlxStore) DeletePool(ctx context.Context, poolID int64) error {
+	return ss.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		res, err := tx.Exec(ctx, "DELETE FROM api_key_pool WHERE id=?", poolID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return apikey.ErrPoolNotFound
+		}
+		_, err = tx.Exec(ctx, "DELETE FROM api_key_pool_member WHERE pool_id=?", poolID)
+		return err
+	})
+}
+
+func (ss *sqlxStore) IncrementPoolUsage(ctx context.Context, poolID, keyID, quota int64) error {
+	return ss.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		if quota >= 0 {
+			var usage int64
+			if err := tx.Get(ctx, &usage, "SELECT COALESCE(SUM(usage_count), 0) FROM api_key_pool_member WHERE pool_id=?", poolID); err != nil {
+				return err
+			}
+			if usage >= quota {
+				return apikey.ErrPoolQuotaExceeded
+			}
+		}
+		_, err := tx.Exec(ctx, "UPDATE api_key_pool_member SET usage_count = usage_count + 1 WHERE pool_id=? AND key_id=?", poolID, keyID)
+		return err
+	})
+}
+
+func (ss *sqlxStore) loadPoolMembers(ctx context.Context, pools ...*apikey.Pool) error {
+	for _, pool := range pools {
+		var members []*apikey.PoolMember
+		if err := ss.sess.Select(ctx, &members, "SELECT * FROM api_key_pool_member WHERE pool_id=? ORDER BY key_id ASC", pool.ID); err != nil {
+			return err
+		}
+		pool.SetMembers(members)
+	}
+	return nil
+}
@@ -9,6 +9,6 @@ import (
 
 func TestIntegrationSQLxApiKeyDataAccess(t *testing.T) {
 	testIntegrationApiKeyDataAccess(t, func(ss db.DB, cfg *setting.Cfg) store {
-		return &sqlxStore{sess: ss.GetSqlxSession(), cfg: cfg}
+		return &sqlxStore{sess: ss.GetSqlxSession(), cfg: cfg, dialect: ss.GetDialect()}
 	})
 }
@@ -2,6 +2,7 @@ package apikeyimpl
 
 import (
 	"context"
+	"time"
 
 	"github.com/grafana/grafana/pkg/services/apikey"
 )
@@ -14,5 +15,54 @@ type store interface {
 	GetApiKeyById(ctx context.Context, query *apikey.GetByIDQuery) error
 	GetApiKeyByName(ctx context.Context, query *apikey.GetByNameQuery) error
 	GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.APIKey, error)
+	// GetAPIKeysByHashes returns the keys stored with any of the hashes.
+	GetAPIKeysByHashes(ctx context.Context, hashes []string) ([]*apikey.APIKey, error)
+	GetAPIKeysByRole(ctx context.Context, query *apikey.GetByRoleQuery) ([]*apikey.APIKey, error)
+	// ListAPIKeysByStatus returns the keys of the org in status at now, and
+	// apikey.ErrInvalidKeyStatus for unknown statuses.
+	ListAPIKeysByStatus(ctx context.Context, orgID int64, status apikey.KeyStatus, now time.Time) ([]*apikey.APIKey, error)
+	CountAPIKeysByStatus(ctx context.Context, orgID int64, status apikey.KeyStatus, now time.Time) (int64, error)
 	UpdateAPIKeyLastUsedDate(ctx context.Context, tokenID int64) error
+	UpdateAPIKeyGracePeriod(ctx context.Context, cmd *apikey.GraceCommand) error
+	UpdateAPIKeyAllowedCIDRs(ctx context.Context, cmd *apikey.UpdateCIDRCommand) error
+	UpdateAPIKeyScopes(ctx context.Context, cmd *apikey.UpdateScopesCommand) error
+	RenewAPIKeyExpiry(ctx context.Context, cmd *apikey.RenewCommand) error
+	GetAPIKeysWithHashVersionBelow(ctx context.Context, orgID int64, version apikey.HashVersion) ([]*apikey.APIKey, error)
+	UpdateAPIKeyHash(ctx context.Context, tokenID int64, hash, verifier string, version apikey.HashVersion) error
+	// ActivateAPIKey marks the key as active, once its creation is confirmed.
+	ActivateAPIKey(ctx context.Context, keyID int64) error
+	// AddConfirmationAttempt counts an attempt to confirm the creation of the
+	// key, and returns false without counting it once max attempts were made.
+	AddConfirmationAttempt(ctx context.Context, keyID, max int64) (bool, error)
+	// DeleteUnconfirmedAPIKeys deletes the inactive keys created before
+	// createdBefore and returns how many were deleted.
+	DeleteUnconfirmedAPIKeys(ctx context.Context, createdBefore time.Time) (int64, error)
+	AddServiceToken(ctx context.Context, token *apikey.ServiceToken) error
+	GetServiceTokenByHash(ctx context.Context, hash string) (*apikey.ServiceToken, error)
+	ListServiceTokens(ctx context.Context, serviceAccountID int64) ([]*apikey.ServiceToken, error)
+	// CountExpiredAPIKeys counts the API keys, excluding service account
+	// tokens, that expired before the given unix time, in the org or in all
+	// orgs if orgID is nil.
+	CountExpiredAPIKeys(ctx context.Context, orgID *int64, expiredBefore int64) (int64, error)
+	// DeleteExpiredAPIKeys deletes at most limit of the keys counted by
+	// CountExpiredAPIKeys and returns the deleted keys.
+	DeleteExpiredAPIKeys(ctx context.Context, orgID *int64, expiredBefore int64, limit int) ([]*apikey.APIKey, error)
+	// GetOrgQuota returns the API key quota override of the org, and false
+	// if the org has none.
+	GetOrgQuota(ctx context.Context, orgID int64) (int64, bool, error)
+	SetOrgQuota(ctx context.Context, orgID int64, limit int64) error
+	// AddPool stores the pool and its members, returning ErrDuplicate if the
+	// org already has a pool with its name.
+	AddPool(ctx context.Context, pool *apikey.Pool) error
+	// GetPool returns the pool with its member key IDs and usage.
+	GetPool(ctx context.Context, poolID int64) (*apikey.Pool, error)
+	ListPools(ctx context.Context, orgID int64) ([]*apikey.Pool, error)
+	// UpdatePool replaces the name, quota and members of the pool. Members
+	// that stay in the pool keep their usage.
+	UpdatePool(ctx context.Context, pool *apikey.Pool) error
+	DeletePool(ctx context.Context, poolID int64) error
+	// IncrementPoolUsage counts a selection of the key from the pool, unless
+	// the usage of the pool already reached quota, in which case it returns
+	// ErrPoolQuotaExceeded. A negative quota means unlimited.
+	IncrementPoolUsage(ctx context.Context, poolID, keyID, quota int64) error
 }
@@ -12,6 +12,7 @@ import (
 	"github.com/grafana/grafana/pkg/infra/db"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/apikey"
+	"github.com/grafana/grafana/pkg/services/org"
 	"github.com/grafana/grafana/pkg/services/user"
 	"github.com/grafana/grafana/pkg/setting"
 )
@@ -269,4 +270,465 @@ func testIntegrationApiKeyDataAccess(t *testing.T, fn getStore) {
 			})
 		}
 	})
+
+	t.Run("Testing Get API keys by role", func(t *testing.T) {
+		db := db.InitTestDB(t)
+		ss := fn(db, db.Cfg)
+
+		roles := []org.RoleType{org.RoleViewer, org.RoleEditor, org.RoleAdmin}
+		for _, orgID := range []int64{1, 2} {
+			for _, role := range roles {
+				for i := 0; i < 2; i++ {
+					name := fmt.Sprintf("%s-%d", role, i)
+					err := ss.AddAPIKey(context.Background(), &apikey.AddCommand{
+						OrgId: orgID,
+						Name:  name,
+						Key:   fmt.Sprintf("%d-%s", orgID, name),
+						Role:  role,
+					})
+					require.NoError(t, err)
+				}
+			}
+		}
+
+		for _, role := range roles {
+			t.Run(fmt.Sprintf("should return only %s keys in the org", role), func(t *testing.T) {
+				keys, err := ss.GetAPIKeysByRole(context.Background(), &apikey.GetByRoleQuery{OrgID: 1, Role: role})
+				require.NoError(t, err)
+				require.Len(t, keys, 2)
+				for _, k := range keys {
+					assert.Equal(t, role, k.Role)
+					assert.Equal(t, int64(1), k.OrgId)
+				}
+			})
+		}
+
+		t.Run("should return no keys for an org without keys", func(t *testing.T) {
+			keys, err := ss.GetAPIKeysByRole(context.Background(), &apikey.GetByRoleQuery{OrgID: 3, Role: org.RoleAdmin})
+			require.NoError(t, err)
+			require.Empty(t, keys)
+		})
+	})
+
+	t.Run("Testing API key hash versions", func(t *testing.T) {
+		db := db.InitTestDB(t)
+		ss := fn(db, db.Cfg)
+
+		legacy := &apikey.AddCommand{OrgId: 1, Name: "legacy", Key: "legacy"}
+		require.NoError(t, ss.AddAPIKey(context.Background(), legacy))
+		current := &apikey.AddCommand{OrgId: 1, Name: "current", Key: "current", HashVersion: apikey.HashVersionSHA256}
+		require.NoError(t, ss.AddAPIKey(context.Background(), current))
+
+		keys, err := ss.GetAPIKeysWithHashVersionBelow(context.Background(), 1, apikey.HashVersionSHA256)
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.Equal(t, legacy.Result.Id, keys[0].Id)
+		assert.Equal(t, apikey.HashVersionPBKDF2, keys[0].HashVersion)
+
+		err = ss.UpdateAPIKeyHash(context.Background(), legacy.Result.Id, "upgraded", "verifier", apikey.HashVersionArgon2id)
+		require.NoError(t, err)
+
+		key, err := ss.GetAPIKeyByHash(context.Background(), "upgraded")
+		require.NoError(t, err)
+		assert.Equal(t, legacy.Result.Id, key.Id)
+		assert.Equal(t, apikey.HashVersionArgon2id, key.HashVersion)
+		assert.Equal(t, "verifier", key.VerifierHash)
+
+		keys, err = ss.GetAPIKeysWithHashVersionBelow(context.Background(), 1, apikey.HashVersionSHA256)
+		require.NoError(t, err)
+		require.Empty(t, keys)
+	})
+
+	t.Run("Testing API key lookup by hashes", func(t *testing.T) {
+		db := db.InitTestDB(t)
+		ss := fn(db, db.Cfg)
+
+		for _, name := range []string{"first", "second", "third"} {
+			require.NoError(t, ss.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: name, Key: name + "-hash"}))
+		}
+
+		keys, err := ss.GetAPIKeysByHashes(context.Background(), []string{"third-hash", "first-hash", "unknown-hash"})
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		assert.Equal(t, "first", keys[0].Name)
+		assert.Equal(t, "third", keys[1].Name)
+
+		keys, err = ss.GetAPIKeysByHashes(context.Background(), nil)
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+
+	t.Run("Testing API key grace period", func(t *testing.T) {
+		db := db.InitTestDB(t)
+		ss := fn(db, db.Cfg)
+
+		cmd := &apikey.AddCommand{OrgId: 1, Name: "grace", Key: "grace"}
+		require.NoError(t, ss.AddAPIKey(context.Background(), cmd))
+		assert.Equal(t, int64(0), cmd.Result.GracePeriodSeconds)
+
+		err := ss.UpdateAPIKeyGracePeriod(context.Background(), &apikey.GraceCommand{ID: cmd.Result.Id, OrgID: 1, GracePeriodSeconds: 300})
+		require.NoError(t, err)
+
+		key, err := ss.GetAPIKeyByHash(context.Background(), "grace")
+		require.NoError(t, err)
+		assert.Equal(t, int64(300), key.GracePeriodSeconds)
+
+		err = ss.UpdateAPIKeyGracePeriod(context.Background(), &apikey.GraceCommand{ID: cmd.Result.Id, OrgID: 2, GracePeriodSeconds: 300})
+		assert.ErrorIs(t, err, apikey.ErrNotFound)
+	})
+
+	t.Run("Testing API key allowed CIDRs", func(t *testing.T) {
+		db := db.InitTestDB(t)
+		ss := fn(db, db.Cfg)
+
+		cmd := &apikey.AddCommand{OrgId: 1, Name: "cidrs", Key: "cidrs", AllowedCIDRs: []string{"10.0.0.0/8"}}
+		require.NoError(t, ss.AddAPIKey(context.Background(), cmd))
+
+		key, err := ss.GetAPIKeyByHash(context.Background(), "cidrs")
+		require.NoError(t, err)
+		assert.Equal(t, apikey.CIDRList{"10.0.0.0/8"}, key.AllowedCIDRs)
+
+		err = ss.UpdateAPIKeyAllowedCIDRs(context.Background(), &apikey.UpdateCIDRCommand{ID: cmd.Result.Id, OrgID: 1, AllowedCIDRs: []string{"192.168.1.0/24", "2001:db8::/32"}})
+		require.NoError(t, err)
+
+		key, err = ss.GetAPIKeyByHash(context.Background(), "cidrs")
+		require.NoError(t, err)
+		assert.Equal(t, apikey.CIDRList{"192.168.1.0/24", "2001:db8::/32"}, key.AllowedCIDRs)
+
+		err = ss.UpdateAPIKeyAllowedCIDRs(context.Background(), &apikey.UpdateCIDRCommand{ID: cmd.Result.Id, OrgID: 1})
+		require.NoError(t, err)
+
+		key, err = ss.GetAPIKeyByHash(context.Background(), "cidrs")
+		require.NoError(t, err)
+		assert.Empty(t, key.AllowedCIDRs)
+
+		err = ss.UpdateAPIKeyAllowedCIDRs(context.Background(), &apikey.UpdateCIDRCommand{ID: cmd.Result.Id, OrgID: 2})
+		assert.ErrorIs(t, err, apikey.ErrNotFound)
+	})
+
+	t.Run("Testing API key scopes", func(t *testing.T) {
+		db := db.InitTestDB(t)
+		ss := fn(db, db.Cfg)
+
+		cmd := &apikey.AddCommand{OrgId: 1, Name: "scopes", Key: "scopes", Scopes: []string{"grafana:read:dashboards"}}
+		require.NoError(t, ss.AddAPIKey(context.Background(), cmd))
+
+		key, err := ss.GetAPIKeyByHash(context.Background(), "scopes")
+		require.NoError(t, err)
+		assert.Equal(t, apikey.ScopeList{"grafana:read:dashboards"}, key.Scopes)
+
+		err = ss.UpdateAPIKeyScopes(context.Background(), &apikey.UpdateScopesCommand{ID: cmd.Result.Id, OrgID: 1, Scopes: []string{"grafana:read:dashboards", "grafana:write:dashboards"}})
+		require.NoError(t, err)
+
+		key, err = ss.GetAPIKeyByHash(context.Background(), "scopes")
+		require.NoError(t, err)
+		assert.Equal(t, apikey.ScopeList{"grafana:read:dashboards", "grafana:write:dashboards"}, key.Scopes)
+
+		err = ss.UpdateAPIKeyScopes(context.Background(), &apikey.UpdateScopesCommand{ID: cmd.Result.Id, OrgID: 2})
+		assert.ErrorIs(t, err, apikey.ErrNotFound)
+	})
+
+	t.Run("Testing service tokens", func(t *testing.T) {
+		db := db.InitTestDB(t)
+		ss := fn(db, db.Cfg)
+
+		token := &apikey.ServiceToken{OrgID: 1, ServiceAccountID: 10, Name: "token", Key: "token-hash", Created: timeNow()}
+		require.NoError(t, ss.AddServiceToken(context.Background(), token))
+		assert.NotZero(t, token.ID)
+
+		err := ss.AddServiceToken(context.Background(), &apikey.ServiceToken{OrgID: 1, ServiceAccountID: 11, Name: "token", Key: "other-hash", Created: timeNow()})
+		assert.ErrorIs(t, err, apikey.ErrDuplicate)
+
+		other := &apikey.ServiceToken{OrgID: 1, ServiceAccountID: 10, Name: "another", Key: "another-hash", Created: timeNow()}
+		require.NoError(t, ss.AddServiceToken(context.Background(), other))
+
+		found, err := ss.GetServiceTokenByHash(context.Background(), "token-hash")
+		require.NoError(t, err)
+		assert.Equal(t, token.ID, found.ID)
+		assert.Equal(t, int64(10), found.ServiceAccountID)
+
+		_, err = ss.GetServiceTokenByHash(context.Background(), "unknown")
+		assert.ErrorIs(t, err, apikey.ErrInvalid)
+
+		tokens, err := ss.ListServiceTokens(context.Background(), 10)
+		require.NoError(t, err)
+		require.Len(t, tokens, 2)
+		assert.Equal(t, "another", tokens[0].Name)
+		assert.Equal(t, "token", tokens[1].Name)
+
+		t.Run("API keys and service tokens are not interchangeable", func(t *testing.T) {
+			cmd := &apikey.AddCommand{OrgId: 1, Name: "key", Key: "key-hash", Role: org.RoleViewer}
+			require.NoError(t, ss.AddAPIKey(context.Background(), cmd))
+
+			_, err := ss.GetServiceTokenByHash(context.Background(), "key-hash")
+			assert.ErrorIs(t, err, apikey.ErrInvalid)
+
+			_, err = ss.GetAPIKeyByHash(context.Background(), "token-hash")
+			assert.ErrorIs(t, err, apikey.ErrInvalid)
+		})
+	})
+
+	t.Run("Testing API key pools", func(t *testing.T) {
+		db := db.InitTestDB(t)
+		ss := fn(db, db.Cfg)
+
+		pool := &apikey.Pool{OrgID: 1, Name: "pool", Quota: 2, KeyIDs: []int64{1, 2}, Created: timeNow(), Updated: timeNow()}
+		require.NoError(t, ss.AddPool(context.Background(), pool))
+		assert.NotZero(t, pool.ID)
+
+		err := ss.AddPool(context.Background(), &apikey.Pool{OrgID: 1, Name: "pool", Created: timeNow(), Updated: timeNow()})
+		assert.ErrorIs(t, err, apikey.ErrDuplicate)
+
+		require.NoError(t, ss.IncrementPoolUsage(context.Background(), pool.ID, 1, pool.Quota))
+		require.NoError(t, ss.IncrementPoolUsage(context.Background(), pool.ID, 2, pool.Quota))
+		err = ss.IncrementPoolUsage(context.Background(), pool.ID, 1, pool.Quota)
+		assert.ErrorIs(t, err, apikey.ErrPoolQuotaExceeded)
+
+		found, err := ss.GetPool(context.Background(), pool.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "pool", found.Name)
+		assert.Equal(t, []int64{1, 2}, found.KeyIDs)
+		assert.Equal(t, int64(2), found.Usage)
+
+		pool.KeyIDs = []int64{2, 3}
+		pool.Quota = -1
+		require.NoError(t, ss.UpdatePool(context.Background(), pool))
+		found, err = ss.GetPool(context.Background(), pool.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{2, 3}, found.KeyIDs)
+		assert.Equal(t, int64(1), found.Usage)
+		require.NoError(t, ss.IncrementPoolUsage(context.Background(), pool.ID, 3, found.Quota))
+
+		pools, err := ss.ListPools(context.Background(), 1)
+		require.NoError(t, err)
+		require.Len(t, pools, 1)
+		assert.Equal(t, int64(2), pools[0].Usage)
+
+		require.NoError(t, ss.DeletePool(context.Background(), pool.ID))
+		_, err = ss.GetPool(context.Background(), pool.ID)
+		assert.ErrorIs(t, err, apikey.ErrPoolNotFound)
+		assert.ErrorIs(t, ss.DeletePool(context.Background(), pool.ID), apikey.ErrPoolNotFound)
+	})
+
+	t.Run("Testing API key renewal", func(t *testing.T) {
+		db := db.InitTestDB(t)
+		ss := fn(db, db.Cfg)
+
+		cmd := &apikey.AddCommand{OrgId: 1, Name: "renew", Key: "renew", SecondsToLive: 3600}
+		require.NoError(t, ss.AddAPIKey(context.Background(), cmd))
+		expires := *cmd.Result.Expires
+
+		t.Run("expiry is moved forward", func(t *testing.T) {
+			newExpiry := time.Unix(expires, 0).Add(time.Hour)
+			err := ss.RenewAPIKeyExpiry(context.Background(), &apikey.RenewCommand{KeyID: cmd.Result.Id, OrgID: 1, NewExpiresAt: newExpiry})
+			require.NoError(t, err)
+
+			key, err := ss.GetAPIKeyByHash(context.Background(), "renew")
+			require.NoError(t, err)
+			require.NotNil(t, key.Expires)
+			assert.Equal(t, newExpiry.Unix(), *key.Expires)
+			assert.Equal(t, int64(1), key.Version)
+			expires = *key.Expires
+		})
+
+		t.Run("backdating is rejected", func(t *testing.T) {
+			err := ss.RenewAPIKeyExpiry(context.Background(), &apikey.RenewCommand{KeyID: cmd.Result.Id, OrgID: 1, NewExpiresAt: time.Unix(expires, 0)})
+			assert.ErrorIs(t, err, apikey.ErrInvalidRenewal)
+
+			err = ss.RenewAPIKeyExpiry(context.Background(), &apikey.RenewCommand{KeyID: cmd.Result.Id, OrgID: 1, NewExpiresAt: time.Unix(expires, 0).Add(-time.Hour)})
+			assert.ErrorIs(t, err, apikey.ErrInvalidRenewal)
+		})
+
+		t.Run("non-existent key is rejected", func(t *testing.T) {
+			err := ss.RenewAPIKeyExpiry(context.Background(), &apikey.RenewCommand{KeyID: 9999, OrgID: 1, NewExpiresAt: time.Now().Add(time.Hour)})
+			assert.ErrorIs(t, err, apikey.ErrNotFound)
+		})
+
+		t.Run("key in another org is rejected", func(t *testing.T) {
+			err := ss.RenewAPIKeyExpiry(context.Background(), &apikey.RenewCommand{KeyID: cmd.Result.Id, OrgID: 2, NewExpiresAt: time.Unix(expires, 0).Add(time.Hour)})
+			assert.ErrorIs(t, err, apikey.ErrNotFound)
+
+			key, err := ss.GetAPIKeyByHash(context.Background(), "renew")
+			require.NoError(t, err)
+			assert.Equal(t, expires, *key.Expires)
+			assert.Equal(t, int64(1), key.Version)
+		})
+	})
+
+	t.Run("Testing API keys by status", func(t *testing.T) {
+		testDB := db.InitTestDB(t)
+		ss := fn(testDB, testDB.Cfg)
+		ctx := context.Background()
+		now := time.Unix(1700000000, 0)
+
+		// keys are added expiring right away, near the mocked epoch, then
+		// renewed relative to now.
+		addKey := func(t *testing.T, name string, expires time.Time) int64 {
+			t.Helper()
+			cmd := &apikey.AddCommand{OrgId: 1, Name: name, Key: name, SecondsToLive: 1}
+			require.NoError(t, ss.AddAPIKey(ctx, cmd))
+			if !expires.IsZero() {
+				require.NoError(t, ss.RenewAPIKeyExpiry(ctx, &apikey.RenewCommand{KeyID: cmd.Result.Id, OrgID: 1, NewExpiresAt: expires}))
+			}
+			return cmd.Result.Id
+		}
+		require.NoError(t, ss.AddAPIKey(ctx, &apikey.AddCommand{OrgId: 1, Name: "never-expires", Key: "never-expires"}))
+		addKey(t, "expires-in-8-days", now.Add(8*24*time.Hour))
+		addKey(t, "expires-in-7-days", now.Add(apikey.ExpiringSoonWindow))
+		addKey(t, "expires-in-1-hour", now.Add(time.Hour))
+		addKey(t, "expired", time.Time{})
+		revokedID := addKey(t, "revoked", now.Add(time.Hour))
+		require.NoError(t, testDB.WithDbSession(ctx, func(sess *db.Session) error {
+			_, err := sess.Exec("UPDATE api_key SET is_revoked = ? WHERE id = ?", true, revokedID)
+			return err
+		}))
+		require.NoError(t, ss.AddAPIKey(ctx, &apikey.AddCommand{OrgId: 2, Name: "other-org", Key: "other-org"}))
+
+		for status, expected := range map[apikey.KeyStatus][]string{
+			apikey.KeyStatusActive:       {"expires-in-8-days", "never-expires"},
+			apikey.KeyStatusExpiringSoon: {"expires-in-1-hour", "expires-in-7-days"},
+			apikey.KeyStatusExpired:      {"expired"},
+			apikey.KeyStatusDeleted:      {"revoked"},
+		} {
+			keys, err := ss.ListAPIKeysByStatus(ctx, 1, status, now)
+			require.NoError(t, err)
+			names := make([]string, 0, len(keys))
+			for _, key := range keys {
+				names = append(names, key.Name)
+			}
+			assert.Equal(t, expected, names, status)
+
+			count, err := ss.CountAPIKeysByStatus(ctx, 1, status, now)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(expected)), count, status)
+		}
+
+		_, err := ss.ListAPIKeysByStatus(ctx, 1, "unknown", now)
+		assert.ErrorIs(t, err, apikey.ErrInvalidKeyStatus)
+	})
+
+	t.Run("Testing org quota overrides", func(t *testing.T) {
+		db := db.InitTestDB(t)
+		ss := fn(db, db.Cfg)
+
+		_, ok, err := ss.GetOrgQuota(context.Background(), 1)
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		require.NoError(t, ss.SetOrgQuota(context.Background(), 1, 5))
+		limit, ok, err := ss.GetOrgQuota(context.Background(), 1)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, int64(5), limit)
+
+		require.NoError(t, ss.SetOrgQuota(context.Background(), 1, -1))
+		limit, _, err = ss.GetOrgQuota(context.Background(), 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(-1), limit)
+
+		_, ok, err = ss.GetOrgQuota(context.Background(), 2)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Testing expired key cleanup", func(t *testing.T) {
+		db := db.InitTestDB(t)
+		ss := fn(db, db.Cfg)
+		defer resetTimeNow()
+
+		// Keys created an hour ago that lived for a minute.
+		created := time.Now().Add(-time.Hour)
+		timeNow = func() time.Time { return created }
+		for i, orgID := range []int64{1, 1, 1, 2} {
+			cmd := &apikey.AddCommand{OrgId: orgID, Name: fmt.Sprintf("expired-%d", i), Key: fmt.Sprintf("expired-%d", i), SecondsToLive: 60}
+			require.NoError(t, ss.AddAPIKey(context.Background(), cmd))
+		}
+		// A key that expired as long ago but is still in its grace period.
+		graced := &apikey.AddCommand{OrgId: 1, Name: "graced", Key: "graced", SecondsToLive: 60}
+		require.NoError(t, ss.AddAPIKey(context.Background(), graced))
+		require.NoError(t, ss.UpdateAPIKeyGracePeriod(context.Background(), &apikey.GraceCommand{ID: graced.Result.Id, OrgID: 1, GracePeriodSeconds: 7200}))
+		resetTimeNow()
+		require.NoError(t, ss.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: "valid", Key: "valid", SecondsToLive: 3600}))
+		require.NoError(t, ss.AddAPIKey(context.Background(), &apikey.AddCommand{OrgId: 1, Name: "forever", Key: "forever"}))
+
+		now := time.Now().Unix()
+		orgID := int64(1)
+		count, err := ss.CountExpiredAPIKeys(context.Background(), &orgID, now)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), count)
+		count, err = ss.CountExpiredAPIKeys(context.Background(), nil, now)
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), count)
+		count, err = ss.CountExpiredAPIKeys(context.Background(), nil, created.Unix())
+		require.NoError(t, err)
+		assert.Zero(t, count)
+
+		deleted, err := ss.DeleteExpiredAPIKeys(context.Background(), &orgID, now, 2)
+		require.NoError(t, err)
+		require.Len(t, deleted, 2)
+		assert.Equal(t, "expired-0", deleted[0].Name)
+		assert.Equal(t, orgID, deleted[0].OrgId)
+		deleted, err = ss.DeleteExpiredAPIKeys(context.Background(), &orgID, now, 2)
+		require.NoError(t, err)
+		assert.Len(t, deleted, 1)
+
+		keys, err := ss.GetAllAPIKeys(context.Background(), -1)
+		require.NoError(t, err)
+		require.Len(t, keys, 4)
+		names := make([]string, 0, len(keys))
+		for _, key := range keys {
+			names = append(names, key.Name)
+		}
+		assert.Contains(t, names, "graced")
+	})
+	t.Run("Testing unconfirmed keys", func(t *testing.T) {
+		db := db.InitTestDB(t)
+		ss := fn(db, db.Cfg)
+		defer resetTimeNow()
+
+		created := time.Now().Add(-2 * time.Hour)
+		timeNow = func() time.Time { return created }
+		stale := &apikey.AddCommand{OrgId: 1, Name: "stale", Key: "stale", CreationSecretHash: "hash"}
+		require.NoError(t, ss.AddAPIKey(context.Background(), stale))
+		assert.False(t, stale.Result.Active)
+		confirmed := &apikey.AddCommand{OrgId: 1, Name: "confirmed", Key: "confirmed", CreationSecretHash: "hash"}
+		require.NoError(t, ss.AddAPIKey(context.Background(), confirmed))
+		require.NoError(t, ss.ActivateAPIKey(context.Background(), confirmed.Result.Id))
+		resetTimeNow()
+
+		recent := &apikey.AddCommand{OrgId: 1, Name: "recent", Key: "recent", CreationSecretHash: "hash"}
+		require.NoError(t, ss.AddAPIKey(context.Background(), recent))
+		plain := &apikey.AddCommand{OrgId: 1, Name: "plain", Key: "plain"}
+		require.NoError(t, ss.AddAPIKey(context.Background(), plain))
+		assert.True(t, plain.Result.Active)
+
+		query := &apikey.GetByIDQuery{ApiKeyId: confirmed.Result.Id}
+		require.NoError(t, ss.GetApiKeyById(context.Background(), query))
+		assert.True(t, query.Result.Active)
+		assert.Equal(t, "hash", query.Result.CreationSecretHash)
+
+		for i := 0; i < 2; i++ {
+			added, err := ss.AddConfirmationAttempt(context.Background(), recent.Result.Id, 2)
+			require.NoError(t, err)
+			assert.True(t, added)
+		}
+		added, err := ss.AddConfirmationAttempt(context.Background(), recent.Result.Id, 2)
+		require.NoError(t, err)
+		assert.False(t, added)
+		query = &apikey.GetByIDQuery{ApiKeyId: recent.Result.Id}
+		require.NoError(t, ss.GetApiKeyById(context.Background(), query))
+		assert.Equal(t, int64(2), query.Result.ConfirmationAttempts)
+
+		deleted, err := ss.DeleteUnconfirmedAPIKeys(context.Background(), time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+
+		keys, err := ss.GetAllAPIKeys(context.Background(), 1)
+		require.NoError(t, err)
+		names := make([]string, 0, len(keys))
+		for _, key := range keys {
+			names = append(names, key.Name)
+		}
+		assert.ElementsMatch(t, []string{"confirmed", "recent", "plain"}, names)
+	})
 }
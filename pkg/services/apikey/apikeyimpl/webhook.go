@@ -0,0 +1,103 @@
+package apikeyimpl
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/apikey"
+)
+
+const (
+	// SignatureHeader is the header carrying the HMAC-SHA256 signature of the
+	// body of webhook requests, as "sha256=<hex digest>".
+	SignatureHeader = "X-Grafana-Signature"
+
+	defaultWebhookRetries = 3
+	defaultWebhookBackoff = 500 * time.Millisecond
+	// webhookTimeout bounds the delivery of an event, retries included.
+	webhookTimeout = 30 * time.Second
+)
+
+// WebhookNotifier is sent the lifecycle events of API keys.
+type WebhookNotifier interface {
+	Notify(ctx context.Context, event *apikey.KeyEvent) error
+}
+
+// HTTPWebhookNotifier posts events as JSON to a URL, signed with a shared
+// secret so that the receiver can check they come from Grafana.
+type HTTPWebhookNotifier struct {
+	url    string
+	secret string
+	client *http.Client
+	// maxRetries is the number of attempts made after the first one failed.
+	maxRetries int
+	// backoff is the delay before the first retry, doubled on each retry.
+	backoff time.Duration
+}
+
+func NewHTTPWebhookNotifier(url, secret string) *HTTPWebhookNotifier {
+	return &HTTPWebhookNotifier{
+		url:        url,
+		secret:     secret,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		maxRetries: defaultWebhookRetries,
+		backoff:    defaultWebhookBackoff,
+	}
+}
+
+// Notify posts the event, retrying with exponential backoff on errors and
+// non-2xx responses. It returns the error of the last attempt.
+func (n *HTTPWebhookNotifier) Notify(ctx context.Context, event *apikey.KeyEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	signature := Sign(n.secret, body)
+
+	for attempt := 0; ; attempt++ {
+		err = n.post(ctx, body, signature)
+		if err == nil || attempt >= n.maxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(n.backoff << attempt):
+		}
+	}
+}
+
+func (n *HTTPWebhookNotifier) post(ctx context.Context, body []byte, signature string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, signature)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the value of the SignatureHeader for body signed with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
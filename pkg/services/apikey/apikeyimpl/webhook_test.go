@@ -0,0 +1,101 @@
+package apikeyimpl
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/services/apikey"
+)
+
+func newTestWebhookNotifier(url string) *HTTPWebhookNotifier {
+	n := NewHTTPWebhookNotifier(url, "secret")
+	n.backoff = time.Millisecond
+	return n
+}
+
+func TestHTTPWebhookNotifier(t *testing.T) {
+	expiresAt := time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)
+	event := &apikey.KeyEvent{
+		EventType: apikey.KeyEventCreated,
+		KeyID:     3,
+		OrgID:     1,
+		Timestamp: time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC),
+		ExpiresAt: &expiresAt,
+	}
+
+	t.Run("posts the signed event", func(t *testing.T) {
+		var received *apikey.KeyEvent
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			assert.Equal(t, Sign("secret", body), r.Header.Get(SignatureHeader))
+			assert.NotEqual(t, Sign("other", body), r.Header.Get(SignatureHeader))
+
+			require.NoError(t, json.Unmarshal(body, &received))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		require.NoError(t, newTestWebhookNotifier(server.URL).Notify(context.Background(), event))
+		require.NotNil(t, received)
+		assert.Equal(t, event.EventType, received.EventType)
+		assert.Equal(t, event.KeyID, received.KeyID)
+		assert.Equal(t, event.OrgID, received.OrgID)
+		assert.True(t, event.Timestamp.Equal(received.Timestamp))
+		require.NotNil(t, received.ExpiresAt)
+		assert.True(t, expiresAt.Equal(*received.ExpiresAt))
+	})
+
+	t.Run("retries failed deliveries", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		require.NoError(t, newTestWebhookNotifier(server.URL).Notify(context.Background(), event))
+		assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("gives up after the last retry", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		err := newTestWebhookNotifier(server.URL).Notify(context.Background(), event)
+		require.Error(t, err)
+		assert.Equal(t, int32(1+defaultWebhookRetries), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("stops retrying when the context is done", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		n := newTestWebhookNotifier(server.URL)
+		n.backoff = time.Hour
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, n.Notify(ctx, event), context.DeadlineExceeded)
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"xorm.io/xorm"
 
 	"github.com/grafana/grafana/pkg/infra/db"
+	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	"github.com/grafana/grafana/pkg/services/apikey"
 	"github.com/grafana/grafana/pkg/setting"
@@ -99,15 +100,21 @@ func (ss *sqlStore) AddAPIKey(ctx context.Context, cmd *apikey.AddCommand) error
 
 		isRevoked := false
 		t := apikey.APIKey{
-			OrgId:            cmd.OrgId,
-			Name:             cmd.Name,
-			Role:             cmd.Role,
-			Key:              cmd.Key,
-			Created:          updated,
-			Updated:          updated,
-			Expires:          expires,
-			ServiceAccountId: cmd.ServiceAccountID,
-			IsRevoked:        &isRevoked,
+			OrgId:              cmd.OrgId,
+			Name:               cmd.Name,
+			Role:               cmd.Role,
+			Key:                cmd.Key,
+			Created:            updated,
+			Updated:            updated,
+			Expires:            expires,
+			ServiceAccountId:   cmd.ServiceAccountID,
+			IsRevoked:          &isRevoked,
+			HashVersion:        hashVersion(cmd),
+			VerifierHash:       cmd.VerifierHash,
+			AllowedCIDRs:       cmd.AllowedCIDRs,
+			Scopes:             cmd.Scopes,
+			Active:             cmd.CreationSecretHash == "",
+			CreationSecretHash: cmd.CreationSecretHash,
 		}
 
 		if _, err := sess.Insert(&t); err != nil {
@@ -164,6 +171,51 @@ func (ss *sqlStore) GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.A
 	return &key, err
 }
 
+func (ss *sqlStore) GetAPIKeysByHashes(ctx context.Context, hashes []string) ([]*apikey.APIKey, error) {
+	result := make([]*apikey.APIKey, 0)
+	if len(hashes) == 0 {
+		return result, nil
+	}
+	err := ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		return sess.Table("api_key").In(ss.db.GetDialect().Quote("key"), hashes).Asc("id").Find(&result)
+	})
+	return result, err
+}
+
+func (ss *sqlStore) GetAPIKeysByRole(ctx context.Context, query *apikey.GetByRoleQuery) ([]*apikey.APIKey, error) {
+	result := make([]*apikey.APIKey, 0)
+	err := ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		return sess.Where("org_id=? AND role=?", query.OrgID, query.Role).Asc("name").Find(&result)
+	})
+	return result, err
+}
+
+func (ss *sqlStore) ListAPIKeysByStatus(ctx context.Context, orgID int64, status apikey.KeyStatus, now time.Time) ([]*apikey.APIKey, error) {
+	where, args, err := keyStatusFilter(orgID, status, now)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*apikey.APIKey, 0)
+	err = ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		return sess.Where(where, args...).Asc("name").Find(&result)
+	})
+	return result, err
+}
+
+func (ss *sqlStore) CountAPIKeysByStatus(ctx context.Context, orgID int64, status apikey.KeyStatus, now time.Time) (int64, error) {
+	where, args, err := keyStatusFilter(orgID, status, now)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		var err error
+		count, err = sess.Table("api_key").Where(where, args...).Count()
+		return err
+	})
+	return count, err
+}
+
 func (ss *sqlStore) UpdateAPIKeyLastUsedDate(ctx context.Context, tokenID int64) error {
 	now := timeNow()
 	return ss.db.WithDbSession(ctx, func(sess *db.Session) error {
@@ -174,3 +226,421 @@ func (ss *sqlStore) UpdateAPIKeyLastUsedDate(ctx context.Context, tokenID int64)
 		return nil
 	})
 }
+
+func (ss *sqlStore) UpdateAPIKeyGracePeriod(ctx context.Context, cmd *apikey.GraceCommand) error {
+	return ss.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		var key apikey.APIKey
+		has, err := sess.Where("id=? AND org_id=?", cmd.ID, cmd.OrgID).Get(&key)
+		if err != nil {
+			return err
+		} else if !has {
+			return apikey.ErrNotFound
+		}
+
+		_, err = sess.Table("api_key").ID(cmd.ID).Cols("grace_period_seconds").Update(&apikey.APIKey{GracePeriodSeconds: cmd.GracePeriodSeconds})
+		return err
+	})
+}
+
+func (ss *sqlStore) UpdateAPIKeyAllowedCIDRs(ctx context.Context, cmd *apikey.UpdateCIDRCommand) error {
+	return ss.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		var key apikey.APIKey
+		has, err := sess.Where("id=? AND org_id=?", cmd.ID, cmd.OrgID).Get(&key)
+		if err != nil {
+			return err
+		} else if !has {
+			return apikey.ErrNotFound
+		}
+
+		_, err = sess.Table("api_key").ID(cmd.ID).Cols("allowed_cidrs").Update(&apikey.APIKey{AllowedCIDRs: cmd.AllowedCIDRs})
+		return err
+	})
+}
+
+func (ss *sqlStore) UpdateAPIKeyScopes(ctx context.Context, cmd *apikey.UpdateScopesCommand) error {
+	return ss.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		var key apikey.APIKey
+		has, err := sess.Where("id=? AND org_id=?", cmd.ID, cmd.OrgID).Get(&key)
+		if err != nil {
+			return err
+		} else if !has {
+			return apikey.ErrNotFound
+		}
+
+		_, err = sess.Table("api_key").ID(cmd.ID).Cols("scopes").Update(&apikey.APIKey{Scopes: cmd.Scopes})
+		return err
+	})
+}
+
+func (ss *sqlStore) RenewAPIKeyExpiry(ctx context.Context, cmd *apikey.RenewCommand) error {
+	return ss.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		var key apikey.APIKey
+		has, err := sess.Where("id=? AND org_id=?", cmd.KeyID, cmd.OrgID).Get(&key)
+		if err != nil {
+			return err
+		} else if !has {
+			return apikey.ErrNotFound
+		}
+
+		expires := cmd.NewExpiresAt.Unix()
+		if key.Expires == nil || expires <= *key.Expires {
+			return apikey.ErrInvalidRenewal
+		}
+
+		_, err = sess.Exec("UPDATE api_key SET expires=?, version=version+1, updated=? WHERE id=?", expires, timeNow(), cmd.KeyID)
+		return err
+	})
+}
+
+func (ss *sqlStore) GetAPIKeysWithHashVersionBelow(ctx context.Context, orgID int64, version apikey.HashVersion) ([]*apikey.APIKey, error) {
+	result := make([]*apikey.APIKey, 0)
+	err := ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		return sess.Where("org_id=? AND hash_version<?", orgID, version).Asc("id").Find(&result)
+	})
+	return result, err
+}
+
+func (ss *sqlStore) UpdateAPIKeyHash(ctx context.Context, tokenID int64, hash, verifier string, version apikey.HashVersion) error {
+	return ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		if _, err := sess.Table("api_key").ID(tokenID).Cols("key", "verifier_hash", "hash_version").Update(&apikey.APIKey{Key: hash, VerifierHash: verifier, HashVersion: version}); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (ss *sqlStore) ActivateAPIKey(ctx context.Context, keyID int64) error {
+	return ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		_, err := sess.Table("api_key").ID(keyID).Cols("active", "updated").Update(&apikey.APIKey{Active: true, Updated: timeNow()})
+		return err
+	})
+}
+
+func (ss *sqlStore) AddConfirmationAttempt(ctx context.Context, keyID, max int64) (bool, error) {
+	var added bool
+	err := ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		res, err := sess.Exec("UPDATE api_key SET confirmation_attempts = confirmation_attempts + 1 WHERE id = ? AND confirmation_attempts < ?", keyID, max)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		added = affected > 0
+		return err
+	})
+	return added, err
+}
+
+func (ss *sqlStore) DeleteUnconfirmedAPIKeys(ctx context.Context, createdBefore time.Time) (int64, error) {
+	var deleted int64
+	err := ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		var err error
+		deleted, err = sess.Where("active = ? AND created < ?", false, createdBefore).Delete(&apikey.APIKey{})
+		return err
+	})
+	return deleted, err
+}
+
+func (ss *sqlStore) AddServiceToken(ctx context.Context, token *apikey.ServiceToken) error {
+	return ss.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		exists, err := sess.Exist(&apikey.ServiceToken{OrgID: token.OrgID, Name: token.Name})
+		if err != nil {
+			return err
+		} else if exists {
+			return apikey.ErrDuplicate
+		}
+
+		if _, err := sess.Insert(token); err != nil {
+			return errors.Wrap(err, "failed to insert service token")
+		}
+		return nil
+	})
+}
+
+func (ss *sqlStore) GetServiceTokenByHash(ctx context.Context, hash string) (*apikey.ServiceToken, error) {
+	var token apikey.ServiceToken
+	err := ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		has, err := sess.Where(fmt.Sprintf("%s = ?", ss.db.GetDialect().Quote("key")), hash).Get(&token)
+		if err != nil {
+			return err
+		} else if !has {
+			return apikey.ErrInvalid
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (ss *sqlStore) ListServiceTokens(ctx context.Context, serviceAccountID int64) ([]*apikey.ServiceToken, error) {
+	result := make([]*apikey.ServiceToken, 0)
+	err := ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		return sess.Where("service_account_id=?", serviceAccountID).Asc("name").Find(&result)
+	})
+	return result, err
+}
+
+func (ss *sqlStore) CountExpiredAPIKeys(ctx context.Context, orgID *int64, expiredBefore int64) (int64, error) {
+	var count int64
+	err := ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		where, args := expiredKeysFilter(orgID, expiredBefore)
+		var err error
+		count, err = sess.Table("api_key").Where(where, args...).Count()
+		return err
+	})
+	return count, err
+}
+
+func (ss *sqlStore) DeleteExpiredAPIKeys(ctx context.Context, orgID *int64, expiredBefore int64, limit int) ([]*apikey.APIKey, error) {
+	var keys []*apikey.APIKey
+	err := ss.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		where, args := expiredKeysFilter(orgID, expiredBefore)
+		if err := sess.Table("api_key").Where(where, args...).Asc("id").Limit(limit).Find(&keys); err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		ids := make([]int64, 0, len(keys))
+		for _, key := range keys {
+			ids = append(ids, key.Id)
+		}
+		_, err := sess.In("id", ids).Delete(&apikey.APIKey{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Overrides are stored in the quota table, where the quota service looks them
+// up when checking the api_key target.
+func (ss *sqlStore) GetOrgQuota(ctx context.Context, orgID int64) (int64, bool, error) {
+	var (
+		q   models.Quota
+		has bool
+	)
+	err := ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		var err error
+		has, err = sess.Where("org_id=? AND target=?", orgID, apikey.QuotaTarget).Get(&q)
+		return err
+	})
+	return q.Limit, has, err
+}
+
+func (ss *sqlStore) SetOrgQuota(ctx context.Context, orgID int64, limit int64) error {
+	return ss.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		var q models.Quota
+		has, err := sess.Where("org_id=? AND target=?", orgID, apikey.QuotaTarget).Get(&q)
+		if err != nil {
+			return err
+		}
+		q.Limit = limit
+		q.Updated = timeNow()
+		if has {
+			_, err = sess.ID(q.Id).Cols("limit", "updated").Update(&q)
+			return err
+		}
+		q.OrgId = orgID
+		q.Target = apikey.QuotaTarget
+		q.Created = q.Updated
+		_, err = sess.Insert(&q)
+		return err
+	})
+}
+
+func (ss *sqlStore) AddPool(ctx context.Context, pool *apikey.Pool) error {
+	return ss.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		exists, err := sess.Exist(&apikey.Pool{OrgID: pool.OrgID, Name: pool.Name})
+		if err != nil {
+			return err
+		} else if exists {
+			return apikey.ErrDuplicate
+		}
+
+		if _, err := sess.Insert(pool); err != nil {
+			return errors.Wrap(err, "failed to insert API key pool")
+		}
+		for _, keyID := range pool.KeyIDs {
+			if _, err := sess.Insert(&apikey.PoolMember{PoolID: pool.ID, KeyID: keyID}); err != nil {
+				return errors.Wrap(err, "failed to insert API key pool member")
+			}
+		}
+		return nil
+	})
+}
+
+func (ss *sqlStore) GetPool(ctx context.Context, poolID int64) (*apikey.Pool, error) {
+	var pool apikey.Pool
+	err := ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		has, err := sess.ID(poolID).Get(&pool)
+		if err != nil {
+			return err
+		} else if !has {
+			return apikey.ErrPoolNotFound
+		}
+		return loadPoolMembers(sess, &pool)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pool, nil
+}
+
+func (ss *sqlStore) ListPools(ctx context.Context, orgID int64) ([]*apikey.Pool, error) {
+	result := make([]*apikey.Pool, 0)
+	err := ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+		if err := sess.Where("org_id=?", orgID).Asc("name").Find(&result); err != nil {
+			return err
+		}
+		return loadPoolMembers(sess, result...)
+	})
+	return result, err
+}
+
+func (ss *sqlStore) UpdatePool(ctx context.Context, pool *apikey.Pool) error {
+	return ss.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		exists, err := sess.Where("org_id=? AND name=? AND id<>?", pool.OrgID, pool.Name, pool.ID).Exist(&apikey.Pool{})
+		if err != nil {
+			return err
+		} else if exists {
+			return apikey.ErrDuplicate
+		}
+
+		affected, err := sess.ID(pool.ID).Where("org_id=?", pool.OrgID).Cols("name", "quota", "updated").Update(pool)
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return apikey.ErrPoolNotFound
+		}
+
+		var members []*apikey.PoolMember
+		if err := sess.Where("pool_id=?", pool.ID).Find(&members); err != nil {
+			return err
+		}
+		removed, added := diffPoolMembers(members, pool.KeyIDs)
+		for _, id := range removed {
+			if _, err := sess.ID(id).Delete(&apikey.PoolMember{}); err != nil {
+				return err
+			}
+		}
+		for _, keyID := range added {
+			if _, err := sess.Insert(&apikey.PoolMember{PoolID: pool.ID, KeyID: keyID}); err != nil {
+				return errors.Wrap(err, "failed to insert API key pool member")
+			}
+		}
+		return nil
+	})
+}
+
+func (ss *sqlStore) DeletePool(ctx context.Context, poolID int64) error {
+	return ss.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		affected, err := sess.ID(poolID).Delete(&apikey.Pool{})
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return apikey.ErrPoolNotFound
+		}
+		_, err = sess.Where("pool_id=?", poolID).Delete(&apikey.PoolMember{})
+		return err
+	})
+}
+
+func (ss *sqlStore) IncrementPoolUsage(ctx context.Context, poolID, keyID, quota int64) error {
+	return ss.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		if quota >= 0 {
+			usage, err := sess.Where("pool_id=?", poolID).SumInt(&apikey.PoolMember{}, "usage_count")
+			if err != nil {
+				return err
+			}
+			if usage >= quota {
+				return apikey.ErrPoolQuotaExceeded
+			}
+		}
+		_, err := sess.Exec("UPDATE api_key_pool_member SET usage_count = usage_count + 1 WHERE pool_id=? AND key_id=?", poolID, keyID)
+		return err
+	})
+}
+
+func loadPoolMembers(sess *db.Session, pools ...*apikey.Pool) error {
+	for _, pool := range pools {
+		var members []*apikey.PoolMember
+		if err := sess.Where("pool_id=?", pool.ID).Asc("key_id").Find(&members); err != nil {
+			return err
+		}
+		pool.SetMembers(members)
+	}
+	return nil
+}
+
+// diffPoolMembers returns the IDs of the members that are not in keyIDs, and
+// the keys of keyIDs that are not members yet.
+func diffPoolMembers(members []*apikey.PoolMember, keyIDs []int64) (removed []int64, added []int64) {
+	keep := make(map[int64]bool, len(keyIDs))
+	for _, keyID := range keyIDs {
+		keep[keyID] = true
+	}
+	existing := make(map[int64]bool, len(members))
+	for _, m := range members {
+		existing[m.KeyID] = true
+		if !keep[m.KeyID] {
+			removed = append(removed, m.ID)
+		}
+	}
+	for _, keyID := range keyIDs {
+		if !existing[keyID] {
+			added = append(added, keyID)
+		}
+	}
+	return removed, added
+}
+
+// expiredKeysFilter selects the API keys, excluding service account tokens,
+// whose grace period ended before expiredBefore, in the org or in all orgs if
+// orgID is nil.
+func expiredKeysFilter(orgID *int64, expiredBefore int64) (string, []interface{}) {
+	where := "service_account_id IS NULL AND expires IS NOT NULL AND expires + grace_period_seconds < ?"
+	args := []interface{}{expiredBefore}
+	if orgID != nil {
+		where += " AND org_id = ?"
+		args = append(args, *orgID)
+	}
+	return where, args
+}
+
+// keyStatusFilter selects the keys of the org that are in status at now. Keys
+// are expired from their expiry on, as in apikey.APIKey.GracePeriodRemaining.
+func keyStatusFilter(orgID int64, status apikey.KeyStatus, now time.Time) (string, []interface{}, error) {
+	const notRevoked = " AND (is_revoked IS NULL OR is_revoked = ?)"
+	soon := now.Add(apikey.ExpiringSoonWindow).Unix()
+	where := "org_id = ?"
+	args := []interface{}{orgID}
+	switch status {
+	case apikey.KeyStatusActive:
+		where += notRevoked + " AND (expires IS NULL OR expires > ?)"
+		args = append(args, false, soon)
+	case apikey.KeyStatusExpiringSoon:
+		where += notRevoked + " AND expires > ? AND expires <= ?"
+		args = append(args, false, now.Unix(), soon)
+	case apikey.KeyStatusExpired:
+		where += notRevoked + " AND expires <= ?"
+		args = append(args, false, now.Unix())
+	case apikey.KeyStatusDeleted:
+		where += " AND is_revoked = ?"
+		args = append(args, true)
+	default:
+		return "", nil, fmt.Errorf("%w: %q", apikey.ErrInvalidKeyStatus, status)
+	}
+	return where, args, nil
+}
+
+// hashVersion returns the hash version of the key in cmd, defaulting to legacy.
+func hashVersion(cmd *apikey.AddCommand) apikey.HashVersion {
+	if cmd.HashVersion == 0 {
+		return apikey.HashVersionPBKDF2
+	}
+	return cmd.HashVersion
+}
@@ -2,6 +2,7 @@ package apikeytest
 
 import (
 	"context"
+	"time"
 
 	"github.com/grafana/grafana/pkg/services/apikey"
 )
@@ -10,6 +11,16 @@ type Service struct {
 	ExpectedError   error
 	ExpectedAPIKeys []*apikey.APIKey
 	ExpectedAPIKey  *apikey.APIKey
+	ExpectedCount   int
+
+	ExpectedServiceToken  *apikey.ServiceToken
+	ExpectedServiceTokens []*apikey.ServiceToken
+	ExpectedQuota         int64
+	ExpectedCleanupResult *apikey.CleanupResult
+	ExpectedValidation    []apikey.ValidationResult
+	ExpectedPool          *apikey.Pool
+	ExpectedPools         []*apikey.Pool
+	ExpectedStatusCounts  map[apikey.KeyStatus]int64
 }
 
 func (s *Service) GetAPIKeys(ctx context.Context, query *apikey.GetApiKeysQuery) error {
@@ -30,6 +41,28 @@ func (s *Service) GetApiKeyByName(ctx context.Context, query *apikey.GetByNameQu
 func (s *Service) GetAPIKeyByHash(ctx context.Context, hash string) (*apikey.APIKey, error) {
 	return s.ExpectedAPIKey, s.ExpectedError
 }
+func (s *Service) VerifyAPIKey(ctx context.Context, orgID int64, name string, legacyHash string) (*apikey.APIKey, error) {
+	if s.ExpectedError != nil {
+		return nil, s.ExpectedError
+	}
+	valid, err := s.ExpectedAPIKey.VerifyHash(legacyHash)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, apikey.ErrHashMismatch
+	}
+	return s.ExpectedAPIKey, nil
+}
+func (s *Service) GetAPIKeysByRole(ctx context.Context, query *apikey.GetByRoleQuery) ([]*apikey.APIKey, error) {
+	return s.ExpectedAPIKeys, s.ExpectedError
+}
+func (s *Service) ListAPIKeysByStatus(ctx context.Context, orgID int64, status apikey.KeyStatus) ([]*apikey.APIKey, error) {
+	return s.ExpectedAPIKeys, s.ExpectedError
+}
+func (s *Service) CountAPIKeysByStatus(ctx context.Context, orgID int64) (map[apikey.KeyStatus]int64, error) {
+	return s.ExpectedStatusCounts, s.ExpectedError
+}
 func (s *Service) DeleteApiKey(ctx context.Context, cmd *apikey.DeleteCommand) error {
 	return s.ExpectedError
 }
@@ -40,3 +73,78 @@ func (s *Service) AddAPIKey(ctx context.Context, cmd *apikey.AddCommand) error {
 func (s *Service) UpdateAPIKeyLastUsedDate(ctx context.Context, tokenID int64) error {
 	return s.ExpectedError
 }
+func (s *Service) MigrateHashAlgorithm(ctx context.Context, orgID int64, newAlgo string) (int, error) {
+	return s.ExpectedCount, s.ExpectedError
+}
+func (s *Service) UpdateAPIKeyGracePeriod(ctx context.Context, cmd *apikey.GraceCommand) error {
+	return s.ExpectedError
+}
+func (s *Service) UpdateAPIKeyAllowedCIDRs(ctx context.Context, cmd *apikey.UpdateCIDRCommand) error {
+	return s.ExpectedError
+}
+func (s *Service) UpdateAPIKeyScopes(ctx context.Context, cmd *apikey.UpdateScopesCommand) error {
+	return s.ExpectedError
+}
+func (s *Service) ValidateAPIKeyScopes(ctx context.Context, keyID int64, requiredScopes []string) error {
+	return s.ExpectedError
+}
+func (s *Service) RenewAPIKeyExpiry(ctx context.Context, cmd *apikey.RenewCommand) error {
+	return s.ExpectedError
+}
+func (s *Service) GenerateServiceToken(ctx context.Context, cmd *apikey.ServiceTokenCommand) (*apikey.ServiceToken, error) {
+	return s.ExpectedServiceToken, s.ExpectedError
+}
+func (s *Service) GetServiceTokenByHash(ctx context.Context, hash string) (*apikey.ServiceToken, error) {
+	return s.ExpectedServiceToken, s.ExpectedError
+}
+func (s *Service) ListServiceTokens(ctx context.Context, serviceAccountID int64) ([]*apikey.ServiceToken, error) {
+	return s.ExpectedServiceTokens, s.ExpectedError
+}
+
+func (s *Service) CleanupExpiredAPIKeys(ctx context.Context, cmd *apikey.CleanupCommand) (*apikey.CleanupResult, error) {
+	return s.ExpectedCleanupResult, s.ExpectedError
+}
+
+func (s *Service) GetOrgAPIKeyQuota(ctx context.Context, orgID int64) (int64, error) {
+	return s.ExpectedQuota, s.ExpectedError
+}
+
+func (s *Service) SetOrgAPIKeyQuota(ctx context.Context, orgID int64, limit int64) error {
+	return s.ExpectedError
+}
+
+func (s *Service) ValidateAPIKeys(ctx context.Context, tokens []string) ([]apikey.ValidationResult, error) {
+	return s.ExpectedValidation, s.ExpectedError
+}
+
+func (s *Service) CreateAPIKeyPool(ctx context.Context, cmd *apikey.CreatePoolCommand) (*apikey.Pool, error) {
+	return s.ExpectedPool, s.ExpectedError
+}
+
+func (s *Service) GetAPIKeyPool(ctx context.Context, orgID, poolID int64) (*apikey.Pool, error) {
+	return s.ExpectedPool, s.ExpectedError
+}
+
+func (s *Service) ListAPIKeyPools(ctx context.Context, orgID int64) ([]*apikey.Pool, error) {
+	return s.ExpectedPools, s.ExpectedError
+}
+
+func (s *Service) UpdateAPIKeyPool(ctx context.Context, cmd *apikey.UpdatePoolCommand) (*apikey.Pool, error) {
+	return s.ExpectedPool, s.ExpectedError
+}
+
+func (s *Service) DeleteAPIKeyPool(ctx context.Context, orgID, poolID int64) error {
+	return s.ExpectedError
+}
+
+func (s *Service) SelectKeyFromPool(ctx context.Context, poolID int64) (*apikey.APIKey, error) {
+	return s.ExpectedAPIKey, s.ExpectedError
+}
+
+func (s *Service) ConfirmAPIKeyCreation(ctx context.Context, cmd *apikey.ConfirmCommand) error {
+	return s.ExpectedError
+}
+
+func (s *Service) DeleteUnconfirmedAPIKeys(ctx context.Context, olderThan time.Time) (int64, error) {
+	return int64(s.ExpectedCount), s.ExpectedError
+}
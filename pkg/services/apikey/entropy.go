@@ -0,0 +1,60 @@
+package apikey
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grafana/grafana/pkg/util"
+)
+
+const (
+	// DefaultMinTokenEntropy is the minimum Shannon entropy, in bits per byte,
+	// a token must have to be accepted unless configured otherwise.
+	DefaultMinTokenEntropy = 3.5
+	// MinTokenLength is the minimum length of a token.
+	MinTokenLength = 32
+)
+
+// ValidateTokenEntropy returns ErrTokenEntropyTooLow if token is shorter than
+// MinTokenLength or its entropy is below DefaultMinTokenEntropy.
+func ValidateTokenEntropy(token string) error {
+	return ValidateTokenEntropyMin(token, DefaultMinTokenEntropy)
+}
+
+// ValidateTokenEntropyMin is ValidateTokenEntropy with a custom minimum entropy.
+func ValidateTokenEntropyMin(token string, minEntropy float64) error {
+	if len(token) < MinTokenLength {
+		return fmt.Errorf("%w: token is shorter than %d characters", ErrTokenEntropyTooLow, MinTokenLength)
+	}
+	if e := shannonEntropy(token); e < minEntropy {
+		return fmt.Errorf("%w: %.2f bits per byte, expected at least %.2f", ErrTokenEntropyTooLow, e, minEntropy)
+	}
+	return nil
+}
+
+// GenerateSecureToken returns a random alphanumeric token of the given length
+// read from crypto/rand.
+func GenerateSecureToken(length int) (string, error) {
+	if length < MinTokenLength {
+		return "", fmt.Errorf("token length must be at least %d", MinTokenLength)
+	}
+	return util.GetRandomString(length)
+}
+
+func shannonEntropy(s string) float64 {
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+
+	var entropy float64
+	n := float64(len(s))
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
@@ -0,0 +1,41 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTokenEntropy(t *testing.T) {
+	t.Run("uniform token is rejected", func(t *testing.T) {
+		assert.ErrorIs(t, ValidateTokenEntropy(strings.Repeat("a", 64)), ErrTokenEntropyTooLow)
+	})
+
+	t.Run("token with a few repeated characters is rejected", func(t *testing.T) {
+		assert.ErrorIs(t, ValidateTokenEntropy(strings.Repeat("abcd", 16)), ErrTokenEntropyTooLow)
+	})
+
+	t.Run("short token is rejected", func(t *testing.T) {
+		assert.ErrorIs(t, ValidateTokenEntropy("0123456789abcdefghijklmnopqrstu"), ErrTokenEntropyTooLow)
+	})
+
+	t.Run("random token is accepted", func(t *testing.T) {
+		token, err := GenerateSecureToken(MinTokenLength)
+		require.NoError(t, err)
+		require.Len(t, token, MinTokenLength)
+		assert.NoError(t, ValidateTokenEntropy(token))
+	})
+
+	t.Run("custom minimum", func(t *testing.T) {
+		token := strings.Repeat("abcd", 16)
+		assert.NoError(t, ValidateTokenEntropyMin(token, 2))
+		assert.ErrorIs(t, ValidateTokenEntropyMin(token, 2.5), ErrTokenEntropyTooLow)
+	})
+
+	t.Run("generating a short token fails", func(t *testing.T) {
+		_, err := GenerateSecureToken(MinTokenLength - 1)
+		assert.Error(t, err)
+	})
+}
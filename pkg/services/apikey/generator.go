@@ -0,0 +1,79 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/components/apikeygen"
+	apikeygenprefix "github.com/grafana/grafana/pkg/components/apikeygenprefixed"
+)
+
+var ErrInvalidTokenPrefix = errors.New("invalid API key token prefix")
+
+// TokenMeta describes the key a token is generated for.
+type TokenMeta struct {
+	OrgID int64
+	Name  string
+}
+
+// TokenGenerator generates the tokens of added keys. It returns the token
+// given to the client and the legacy hash of its secret that is stored.
+type TokenGenerator interface {
+	GenerateToken(ctx context.Context, meta *TokenMeta) (token string, hash string, err error)
+}
+
+// HSMTokenGenerator is a TokenGenerator backed by a hardware security module.
+// Grafana does not ship an implementation, deployments provide their own.
+type HSMTokenGenerator interface {
+	TokenGenerator
+	// Ping returns an error if the module cannot generate tokens.
+	Ping(ctx context.Context) error
+}
+
+// DefaultTokenGenerator generates legacy tokens, which encode the org and
+// name of the key along with a random secret.
+type DefaultTokenGenerator struct{}
+
+func (DefaultTokenGenerator) GenerateToken(ctx context.Context, meta *TokenMeta) (string, string, error) {
+	key, err := apikeygen.New(meta.OrgID, meta.Name)
+	if err != nil {
+		return "", "", err
+	}
+	return key.ClientSecret, key.HashedKey, nil
+}
+
+// PrefixedTokenGenerator returns a generator of Grafana prefixed tokens,
+// "gl<prefix>_<secret>_<checksum>", whose secret is the token generated by
+// inner. The hash returned by inner is not used.
+func PrefixedTokenGenerator(prefix string, inner TokenGenerator) TokenGenerator {
+	return prefixedTokenGenerator{prefix: prefix, inner: inner}
+}
+
+type prefixedTokenGenerator struct {
+	prefix string
+	inner  TokenGenerator
+}
+
+func (g prefixedTokenGenerator) GenerateToken(ctx context.Context, meta *TokenMeta) (string, string, error) {
+	if g.prefix == "" || strings.Contains(g.prefix, "_") || g.prefix == ServiceTokenServiceID {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidTokenPrefix, g.prefix)
+	}
+
+	secret, _, err := g.inner.GenerateToken(ctx, meta)
+	if err != nil {
+		return "", "", err
+	}
+	if secret == "" || strings.Contains(secret, "_") {
+		return "", "", errors.New("prefixed tokens need a secret without underscores")
+	}
+
+	key := apikeygenprefix.PrefixedKey{ServiceID: g.prefix, Secret: secret}
+	key.Checksum = key.CalculateChecksum()
+	hash, err := key.Hash()
+	if err != nil {
+		return "", "", err
+	}
+	return key.String(), hash, nil
+}
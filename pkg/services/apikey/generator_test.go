@@ -0,0 +1,49 @@
+package apikey
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/components/apikeygen"
+	apikeygenprefix "github.com/grafana/grafana/pkg/components/apikeygenprefixed"
+)
+
+func TestTokenGenerators(t *testing.T) {
+	meta := &TokenMeta{OrgID: 1, Name: "ci"}
+
+	t.Run("default generator", func(t *testing.T) {
+		token, hash, err := DefaultTokenGenerator{}.GenerateToken(context.Background(), meta)
+		require.NoError(t, err)
+
+		decoded, err := apikeygen.Decode(token)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), decoded.OrgId)
+		assert.Equal(t, "ci", decoded.Name)
+
+		valid, err := apikeygen.IsValid(decoded, hash)
+		require.NoError(t, err)
+		assert.True(t, valid)
+	})
+
+	t.Run("prefixed generator", func(t *testing.T) {
+		token, hash, err := PrefixedTokenGenerator("hsm", DefaultTokenGenerator{}).GenerateToken(context.Background(), meta)
+		require.NoError(t, err)
+
+		decoded, err := apikeygenprefix.Decode(token)
+		require.NoError(t, err)
+		assert.Equal(t, "hsm", decoded.ServiceID)
+		decodedHash, err := decoded.Hash()
+		require.NoError(t, err)
+		assert.Equal(t, hash, decodedHash)
+	})
+
+	t.Run("prefixed generator rejects invalid prefixes", func(t *testing.T) {
+		for _, prefix := range []string{"", "h_sm", ServiceTokenServiceID} {
+			_, _, err := PrefixedTokenGenerator(prefix, DefaultTokenGenerator{}).GenerateToken(context.Background(), meta)
+			assert.ErrorIs(t, err, ErrInvalidTokenPrefix, prefix)
+		}
+	})
+}
@@ -0,0 +1,247 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/grafana/grafana/pkg/util"
+)
+
+// HashVersion identifies the algorithm an API key's stored hash was produced with.
+//
+// Keys are presented by the legacy hash of their secret, its PBKDF2 digest,
+// and every other version is derived from the SHA-256 digest of that hash
+// rather than from the secret, which is never stored. Keys stored with the
+// PBKDF2 or SHA-256 version can therefore be upgraded in bulk without access
+// to their secrets, the others only once their secret is presented.
+//
+// Keys are looked up by a lookup hash that is cheap to compute. Versions with
+// a slow hash store it alongside as the verifier hash, which is only checked
+// once a key is found, so that presenting unknown secrets costs no slow hash.
+type HashVersion int
+
+const (
+	// HashVersionPBKDF2 is the legacy hash itself, which keys were stored with
+	// before hash versions were introduced.
+	HashVersionPBKDF2 HashVersion = 1
+	// HashVersionBcrypt is the legacy version: the bcrypt hash of the SHA-256
+	// digest, looked up by a prefix of the digest.
+	HashVersionBcrypt HashVersion = 2
+	// HashVersionSHA256 is the current version: the SHA-256 digest.
+	HashVersionSHA256 HashVersion = 3
+	// HashVersionArgon2id is the future version: the Argon2id hash of the
+	// SHA-256 digest with a salt of its own, looked up by a prefix of the
+	// digest.
+	HashVersionArgon2id HashVersion = 4
+)
+
+const (
+	HashAlgorithmPBKDF2   = "pbkdf2"
+	HashAlgorithmBcrypt   = "bcrypt"
+	HashAlgorithmSHA256   = "sha256"
+	HashAlgorithmArgon2id = "argon2id"
+
+	// DefaultHashAlgorithm is used when no preferred algorithm is configured.
+	// Keys are not rehashed on use with the default, so that they can still
+	// be used after a downgrade to a version without hash versions.
+	DefaultHashAlgorithm = HashAlgorithmPBKDF2
+)
+
+// ErrHashNotUpgradable is returned by UpgradeHash for stored hashes that the
+// SHA-256 digest cannot be recovered from. Such keys can only be upgraded
+// once their secret is presented.
+var ErrHashNotUpgradable = errors.New("API key hash cannot be upgraded without its secret")
+
+// lookupLength is the length of the prefix of the SHA-256 digest that keys
+// with a slow hash are looked up by.
+const lookupLength = 16
+
+// Argon2id parameters of new verifier hashes, as recommended by OWASP.
+const (
+	argon2Time    = 2
+	argon2Memory  = 19 * 1024
+	argon2Threads = 1
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// ParseHashAlgorithm returns the hash version for the named algorithm.
+func ParseHashAlgorithm(name string) (HashVersion, error) {
+	switch name {
+	case HashAlgorithmPBKDF2:
+		return HashVersionPBKDF2, nil
+	case HashAlgorithmBcrypt:
+		return HashVersionBcrypt, nil
+	case HashAlgorithmSHA256:
+		return HashVersionSHA256, nil
+	case HashAlgorithmArgon2id:
+		return HashVersionArgon2id, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidHashAlgorithm, name)
+}
+
+// HashVersions returns all known hash versions, newest first.
+func HashVersions() []HashVersion {
+	return []HashVersion{HashVersionArgon2id, HashVersionSHA256, HashVersionBcrypt, HashVersionPBKDF2}
+}
+
+// orPBKDF2 treats keys stored before hash versions were introduced as PBKDF2.
+func (v HashVersion) orPBKDF2() HashVersion {
+	if v == 0 {
+		return HashVersionPBKDF2
+	}
+	return v
+}
+
+// UpgradeHash converts a lookup hash of version from to version to, and
+// returns the lookup hash and, for versions with a slow hash, the verifier
+// hash of version to. Hashes can only be upgraded, converting to an older
+// version returns an error, and only from the PBKDF2 and SHA-256 versions,
+// see ErrHashNotUpgradable.
+func UpgradeHash(hash string, from, to HashVersion) (lookup string, verifier string, err error) {
+	from, to = from.orPBKDF2(), to.orPBKDF2()
+	if from > to {
+		return "", "", fmt.Errorf("cannot downgrade API key hash from version %d to %d", from, to)
+	}
+	if from == to {
+		return hash, "", nil
+	}
+
+	var digest string
+	switch from {
+	case HashVersionPBKDF2:
+		digest = sha256Hex(hash)
+	case HashVersionSHA256:
+		digest = hash
+	case HashVersionBcrypt, HashVersionArgon2id:
+		return "", "", fmt.Errorf("%w: version %d", ErrHashNotUpgradable, from)
+	default:
+		return "", "", fmt.Errorf("unknown API key hash version %d", from)
+	}
+
+	switch to {
+	case HashVersionBcrypt:
+		v, err := bcrypt.GenerateFromPassword([]byte(digest), bcrypt.DefaultCost)
+		if err != nil {
+			return "", "", err
+		}
+		return digest[:lookupLength], string(v), nil
+	case HashVersionSHA256:
+		return digest, "", nil
+	case HashVersionArgon2id:
+		v, err := argon2Hash(digest)
+		if err != nil {
+			return "", "", err
+		}
+		return digest[:lookupLength], v, nil
+	}
+	return "", "", fmt.Errorf("unknown API key hash version %d", to)
+}
+
+// LookupHashes returns the lookup hashes of legacyHash, the legacy hash of a
+// secret, by hash version. They are cheap to compute, unlike verifier hashes.
+func LookupHashes(legacyHash string) map[HashVersion]string {
+	digest := sha256Hex(legacyHash)
+	return map[HashVersion]string{
+		HashVersionPBKDF2:   legacyHash,
+		HashVersionBcrypt:   digest[:lookupLength],
+		HashVersionSHA256:   digest,
+		HashVersionArgon2id: digest[:lookupLength],
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// argon2Hash returns the Argon2id hash of digest with a random salt, encoded
+// with its parameters in the PHC string format.
+func argon2Hash(digest string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(digest), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// argon2Verify reports whether encoded, as returned by argon2Hash, is the
+// Argon2id hash of digest. It is false for malformed hashes.
+func argon2Verify(encoded, digest string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return false
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false
+	}
+	computed := argon2.IDKey([]byte(digest), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1
+}
+
+// VerifyHash reports whether legacyHash, the legacy hash of a presented
+// secret, matches the stored hash of the key. The verifier hash is only
+// checked if the lookup hash matches.
+func (k *APIKey) VerifyHash(legacyHash string) (bool, error) {
+	version := k.HashVersion.orPBKDF2()
+	lookup, ok := LookupHashes(legacyHash)[version]
+	if !ok {
+		return false, fmt.Errorf("unknown API key hash version %d", version)
+	}
+	if subtle.ConstantTimeCompare([]byte(lookup), []byte(k.Key)) != 1 {
+		return false, nil
+	}
+
+	switch version {
+	case HashVersionBcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(k.VerifierHash), []byte(sha256Hex(legacyHash)))
+		if err == bcrypt.ErrMismatchedHashAndPassword || err == bcrypt.ErrHashTooShort {
+			return false, nil
+		}
+		return err == nil, err
+	case HashVersionArgon2id:
+		return argon2Verify(k.VerifierHash, sha256Hex(legacyHash)), nil
+	}
+	return true, nil
+}
+
+// HashCreationSecret hashes the creation secret of a key salted with the
+// stored hash of the key, so that a creation secret only confirms the key it
+// was added with.
+func HashCreationSecret(secret, keyHash string) (string, error) {
+	return util.EncodePassword(secret, keyHash)
+}
+
+// VerifyCreationSecret reports whether secret is the creation secret the key
+// was added with. It is false for keys added without one.
+func (k *APIKey) VerifyCreationSecret(secret string) (bool, error) {
+	if k.CreationSecretHash == "" {
+		return false, nil
+	}
+	hash, err := HashCreationSecret(secret, k.Key)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(k.CreationSecretHash)) == 1, nil
+}
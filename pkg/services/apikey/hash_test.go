@@ -0,0 +1,99 @@
+package apikey
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpgradeHash(t *testing.T) {
+	const legacyHash = "legacy-hash"
+	digest := sha256Hex(legacyHash)
+
+	for _, version := range HashVersions() {
+		version := version
+		t.Run(fmt.Sprintf("version %d round trips", version), func(t *testing.T) {
+			lookup, verifier, err := UpgradeHash(legacyHash, HashVersionPBKDF2, version)
+			require.NoError(t, err)
+			assert.Equal(t, LookupHashes(legacyHash)[version], lookup)
+
+			key := &APIKey{Key: lookup, VerifierHash: verifier, HashVersion: version}
+			valid, err := key.VerifyHash(legacyHash)
+			require.NoError(t, err)
+			assert.True(t, valid)
+
+			valid, err = key.VerifyHash("wrong-hash")
+			require.NoError(t, err)
+			assert.False(t, valid)
+		})
+	}
+
+	t.Run("unversioned keys are PBKDF2", func(t *testing.T) {
+		valid, err := (&APIKey{Key: legacyHash}).VerifyHash(legacyHash)
+		require.NoError(t, err)
+		assert.True(t, valid)
+	})
+
+	t.Run("SHA-256 hashes are upgraded to Argon2id", func(t *testing.T) {
+		lookup, verifier, err := UpgradeHash(digest, HashVersionSHA256, HashVersionArgon2id)
+		require.NoError(t, err)
+		valid, err := (&APIKey{Key: lookup, VerifierHash: verifier, HashVersion: HashVersionArgon2id}).VerifyHash(legacyHash)
+		require.NoError(t, err)
+		assert.True(t, valid)
+	})
+
+	t.Run("Argon2id hashes are salted", func(t *testing.T) {
+		_, first, err := UpgradeHash(legacyHash, HashVersionPBKDF2, HashVersionArgon2id)
+		require.NoError(t, err)
+		_, second, err := UpgradeHash(legacyHash, HashVersionPBKDF2, HashVersionArgon2id)
+		require.NoError(t, err)
+		assert.NotEqual(t, first, second)
+	})
+
+	t.Run("bcrypt hashes cannot be upgraded", func(t *testing.T) {
+		_, _, err := UpgradeHash(digest[:lookupLength], HashVersionBcrypt, HashVersionSHA256)
+		assert.ErrorIs(t, err, ErrHashNotUpgradable)
+	})
+
+	t.Run("hashes cannot be downgraded", func(t *testing.T) {
+		_, _, err := UpgradeHash(digest, HashVersionSHA256, HashVersionBcrypt)
+		assert.Error(t, err)
+	})
+
+	t.Run("malformed Argon2id verifier does not match", func(t *testing.T) {
+		key := &APIKey{Key: digest[:lookupLength], VerifierHash: "$argon2id$invalid", HashVersion: HashVersionArgon2id}
+		valid, err := key.VerifyHash(legacyHash)
+		require.NoError(t, err)
+		assert.False(t, valid)
+	})
+}
+
+func TestLookupHashes(t *testing.T) {
+	const legacyHash = "legacy-hash"
+	digest := sha256Hex(legacyHash)
+
+	assert.Equal(t, map[HashVersion]string{
+		HashVersionPBKDF2:   legacyHash,
+		HashVersionBcrypt:   digest[:lookupLength],
+		HashVersionSHA256:   digest,
+		HashVersionArgon2id: digest[:lookupLength],
+	}, LookupHashes(legacyHash))
+}
+
+func TestParseHashAlgorithm(t *testing.T) {
+	for name, version := range map[string]HashVersion{
+		HashAlgorithmPBKDF2:   HashVersionPBKDF2,
+		HashAlgorithmBcrypt:   HashVersionBcrypt,
+		HashAlgorithmSHA256:   HashVersionSHA256,
+		HashAlgorithmArgon2id: HashVersionArgon2id,
+	} {
+		v, err := ParseHashAlgorithm(name)
+		require.NoError(t, err)
+		assert.Equal(t, version, v)
+	}
+
+	_, err := ParseHashAlgorithm("md5")
+	assert.ErrorIs(t, err, ErrInvalidHashAlgorithm)
+}
@@ -0,0 +1,81 @@
+package apikey
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/grafana/pkg/infra/metrics"
+)
+
+// Reasons reported in the reason label of grafana_apikey_auth_failure_total.
+const (
+	AuthFailureNotFound     = "not_found"
+	AuthFailureExpired      = "expired"
+	AuthFailureHashMismatch = "hash_mismatch"
+)
+
+// UnknownOrgLabel is the org_id label value used when the org of a key can not
+// be determined, such as when no key matches the presented secret.
+const UnknownOrgLabel = "unknown"
+
+// Metrics holds the counters tracking the API key lifecycle.
+type Metrics struct {
+	Created     *prometheus.CounterVec
+	Deleted     *prometheus.CounterVec
+	AuthSuccess *prometheus.CounterVec
+	AuthFailure *prometheus.CounterVec
+}
+
+// NewMetrics registers the API key metrics with reg. Metrics already
+// registered with reg are reused.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		Created: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metrics.ExporterName,
+			Name:      "apikey_created_total",
+			Help:      "Number of API keys created.",
+		}, []string{"org_id"}),
+		Deleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metrics.ExporterName,
+			Name:      "apikey_deleted_total",
+			Help:      "Number of API keys deleted.",
+		}, []string{"org_id"}),
+		AuthSuccess: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metrics.ExporterName,
+			Name:      "apikey_auth_success_total",
+			Help:      "Number of successful authentications with an API key.",
+		}, []string{"org_id"}),
+		AuthFailure: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metrics.ExporterName,
+			Name:      "apikey_auth_failure_total",
+			Help:      "Number of failed authentications with an API key, by reason.",
+		}, []string{"org_id", "reason"}),
+	}
+
+	if reg != nil {
+		m.Created = registerOrGet(reg, m.Created)
+		m.Deleted = registerOrGet(reg, m.Deleted)
+		m.AuthSuccess = registerOrGet(reg, m.AuthSuccess)
+		m.AuthFailure = registerOrGet(reg, m.AuthFailure)
+	}
+
+	return m
+}
+
+// OrgLabel formats an org ID as an org_id label value.
+func OrgLabel(orgID int64) string {
+	return strconv.FormatInt(orgID, 10)
+}
+
+func registerOrGet(reg prometheus.Registerer, c *prometheus.CounterVec) *prometheus.CounterVec {
+	if err := reg.Register(c); err != nil {
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if errors.As(err, &alreadyRegistered) {
+			return alreadyRegistered.ExistingCollector.(*prometheus.CounterVec)
+		}
+		panic(err)
+	}
+	return c
+}
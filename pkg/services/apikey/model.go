@@ -1,18 +1,45 @@
 package apikey
 
 import (
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/grafana/grafana/pkg/services/org"
 	"github.com/grafana/grafana/pkg/services/user"
 )
 
 var (
-	ErrNotFound          = errors.New("API key not found")
-	ErrInvalid           = errors.New("invalid API key")
-	ErrInvalidExpiration = errors.New("negative value for SecondsToLive")
-	ErrDuplicate         = errors.New("API key, organization ID and name must be unique")
+	ErrNotFound           = errors.New("API key not found")
+	ErrInvalid            = errors.New("invalid API key")
+	ErrHashMismatch       = errors.New("API key secret does not match")
+	ErrInvalidExpiration  = errors.New("negative value for SecondsToLive")
+	ErrInvalidGracePeriod = errors.New("negative value for GracePeriodSeconds")
+	ErrInvalidRenewal     = errors.New("new expiry must be later than the current expiry")
+	ErrDuplicate          = errors.New("API key, organization ID and name must be unique")
+	ErrInvalidCIDR        = errors.New("invalid CIDR in API key allowlist")
+	ErrAPIKeyIPNotAllowed = errors.New("API key is not allowed from this IP address")
+	ErrInvalidScope       = errors.New("invalid API key scope")
+	ErrScopeNotGranted    = errors.New("API key does not carry the required scopes")
+
+	ErrInvalidHashAlgorithm = errors.New("invalid API key hash algorithm")
+	ErrTokenEntropyTooLow   = errors.New("API key token entropy is too low")
+	ErrTooManyTokens        = errors.New("too many API key tokens to validate")
+
+	ErrCreationSecretMismatch      = errors.New("API key creation secret does not match")
+	ErrCreationConfirmationExpired = errors.New("API key creation can no longer be confirmed")
+	ErrTooManyConfirmationAttempts = errors.New("too many attempts to confirm API key creation")
+	ErrInvalidKeyStatus            = errors.New("invalid API key status")
+
+	ErrPoolNotFound      = errors.New("API key pool not found")
+	ErrPoolNoActiveKeys  = errors.New("API key pool has no active keys")
+	ErrPoolQuotaExceeded = errors.New("API key pool quota exceeded")
+	ErrInvalidPoolMember = errors.New("API key pool member must be a key of the same org")
 )
 
 type APIKey struct {
@@ -27,10 +54,163 @@ type APIKey struct {
 	Expires          *int64       `db:"expires"`
 	ServiceAccountId *int64       `db:"service_account_id"`
 	IsRevoked        *bool        `xorm:"is_revoked" db:"is_revoked"`
+	HashVersion      HashVersion  `xorm:"hash_version" db:"hash_version"`
+	// VerifierHash is the slow hash checked once the key is found by Key, for
+	// hash versions that have one.
+	VerifierHash string `xorm:"verifier_hash" db:"verifier_hash"`
+	// GracePeriodSeconds is how long the key is still accepted after it expires.
+	GracePeriodSeconds int64 `xorm:"grace_period_seconds" db:"grace_period_seconds"`
+	// Version is incremented whenever the expiry of the key is renewed.
+	Version int64 `db:"version"`
+	// AllowedCIDRs restricts the source addresses the key can be used from.
+	// The key can be used from any address if it is empty.
+	AllowedCIDRs CIDRList `xorm:"allowed_cidrs" db:"allowed_cidrs"`
+	// Scopes are the OAuth2-style scopes the key carries, e.g. grafana:read:dashboards.
+	Scopes ScopeList `xorm:"scopes" db:"scopes"`
+	// Active is false for a key added with a creation secret until its
+	// creation is confirmed. Inactive keys cannot be used to authenticate.
+	Active bool `xorm:"active" db:"active"`
+	// CreationSecretHash is the hash of the creation secret the key was added
+	// with, if any, see HashCreationSecret.
+	CreationSecretHash string `xorm:"creation_secret_hash" db:"creation_secret_hash"`
+	// ConfirmationAttempts counts the attempts to confirm the creation of the
+	// key, up to MaxConfirmationAttempts.
+	ConfirmationAttempts int64 `xorm:"confirmation_attempts" db:"confirmation_attempts"`
 }
 
 func (k APIKey) TableName() string { return "api_key" }
 
+// CheckIP returns ErrAPIKeyIPNotAllowed if the key cannot be used from ip.
+func (k APIKey) CheckIP(ip net.IP) error {
+	if len(k.AllowedCIDRs) == 0 {
+		return nil
+	}
+	if ip == nil {
+		return ErrAPIKeyIPNotAllowed
+	}
+	for _, cidr := range k.AllowedCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	return ErrAPIKeyIPNotAllowed
+}
+
+// CIDRList is a list of CIDRs stored as a JSON array.
+type CIDRList []string
+
+// ValidateCIDRs returns ErrInvalidCIDR if any of cidrs cannot be parsed.
+func ValidateCIDRs(cidrs []string) error {
+	for _, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidCIDR, cidr)
+		}
+	}
+	return nil
+}
+
+func (l *CIDRList) FromDB(data []byte) error {
+	if len(data) == 0 {
+		*l = nil
+		return nil
+	}
+	return json.Unmarshal(data, l)
+}
+
+func (l CIDRList) ToDB() ([]byte, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(l)
+}
+
+func (l *CIDRList) Scan(val interface{}) error {
+	switch v := val.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return l.FromDB(v)
+	case string:
+		return l.FromDB([]byte(v))
+	default:
+		return fmt.Errorf("unsupported type: %T", v)
+	}
+}
+
+func (l CIDRList) Value() (driver.Value, error) {
+	data, err := l.ToDB()
+	if data == nil || err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// ScopeList is a list of OAuth2-style scopes stored as a JSON array.
+type ScopeList []string
+
+// ValidateScopes returns ErrInvalidScope if any of scopes is empty or contains
+// white space, which separates scopes in OAuth2.
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if scope == "" || strings.IndexFunc(scope, unicode.IsSpace) >= 0 {
+			return fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+	}
+	return nil
+}
+
+// Contains returns ErrScopeNotGranted unless all of required are in l.
+func (l ScopeList) Contains(required []string) error {
+	for _, scope := range required {
+		found := false
+		for _, s := range l {
+			if s == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: missing %q", ErrScopeNotGranted, scope)
+		}
+	}
+	return nil
+}
+
+func (l *ScopeList) FromDB(data []byte) error {
+	return (*CIDRList)(l).FromDB(data)
+}
+
+func (l ScopeList) ToDB() ([]byte, error) {
+	return CIDRList(l).ToDB()
+}
+
+func (l *ScopeList) Scan(val interface{}) error {
+	return (*CIDRList)(l).Scan(val)
+}
+
+func (l ScopeList) Value() (driver.Value, error) {
+	return CIDRList(l).Value()
+}
+
+// GracePeriodRemaining returns whether the key has expired at now, and if so,
+// how many seconds remain of its grace period. Keys without an expiry never expire.
+func (k APIKey) GracePeriodRemaining(now time.Time) (expired bool, remaining int64) {
+	if k.Expires == nil || *k.Expires > now.Unix() {
+		return false, 0
+	}
+
+	remaining = *k.Expires + k.GracePeriodSeconds - now.Unix()
+	if remaining < 0 {
+		remaining = 0
+	}
+	return true, remaining
+}
+
 // swagger:model
 type AddCommand struct {
 	Name             string       `json:"name" binding:"Required"`
@@ -39,6 +219,25 @@ type AddCommand struct {
 	Key              string       `json:"-"`
 	SecondsToLive    int64        `json:"secondsToLive"`
 	ServiceAccountID *int64       `json:"-"`
+	// RawKey is the secret Key is the hash of, when the caller generated it.
+	// It is only checked for entropy and never stored.
+	RawKey RedactedToken `json:"-"`
+	// HashVersion is the version Key was hashed with, legacy if unset.
+	HashVersion HashVersion `json:"-"`
+	// VerifierHash is set when the key is added with a hash version that has one.
+	VerifierHash string `json:"-"`
+	// AllowedCIDRs restricts the source addresses the key can be used from.
+	AllowedCIDRs []string `json:"allowedCidrs"`
+	// Scopes are the OAuth2-style scopes the key carries.
+	Scopes []string `json:"scopes"`
+	// CreationSecret, if set, must be presented to ConfirmAPIKeyCreation
+	// before the key can be used.
+	CreationSecret string `json:"creationSecret"`
+	// CreationSecretHash is set from CreationSecret when the key is added.
+	CreationSecretHash string `json:"-"`
+	// Token is set to the token given to the client when Key is left empty
+	// and the service generates it.
+	Token RedactedToken `json:"-"`
 
 	Result *APIKey `json:"-"`
 }
@@ -60,6 +259,243 @@ type GetByNameQuery struct {
 	Result  *APIKey
 }
 
+type GetByRoleQuery struct {
+	OrgID int64
+	Role  org.RoleType
+}
+
+type GraceCommand struct {
+	ID                 int64 `json:"-"`
+	OrgID              int64 `json:"-"`
+	GracePeriodSeconds int64 `json:"gracePeriodSeconds"`
+}
+
+// UpdateCIDRCommand replaces the source addresses a key can be used from. An
+// empty list lifts the restriction.
+type UpdateCIDRCommand struct {
+	ID           int64    `json:"-"`
+	OrgID        int64    `json:"-"`
+	AllowedCIDRs []string `json:"allowedCidrs"`
+}
+
+// UpdateScopesCommand replaces the OAuth2-style scopes a key carries.
+type UpdateScopesCommand struct {
+	ID     int64    `json:"-"`
+	OrgID  int64    `json:"-"`
+	Scopes []string `json:"scopes"`
+}
+
+// RenewCommand extends the expiry of a key without changing its secret.
+type RenewCommand struct {
+	KeyID        int64     `json:"-"`
+	OrgID        int64     `json:"-"`
+	NewExpiresAt time.Time `json:"newExpiresAt"`
+}
+
+// ConfirmCommand confirms the creation of a key added with a creation secret.
+type ConfirmCommand struct {
+	KeyID          int64  `json:"-"`
+	OrgID          int64  `json:"-"`
+	CreationSecret string `json:"creationSecret" binding:"Required"`
+}
+
+// UnconfirmedKeyLifetime is how long a key added with a creation secret is
+// kept without its creation being confirmed. The creation can no longer be
+// confirmed after it.
+const UnconfirmedKeyLifetime = time.Hour
+
+// MaxConfirmationAttempts is how many times the creation secret of a key can
+// be presented before its creation can no longer be confirmed.
+const MaxConfirmationAttempts = 5
+
+// ServiceTokenServiceID is the service ID of service tokens, which makes them
+// start with "glst_" and sets them apart from API keys.
+const ServiceTokenServiceID = "st"
+
+// ServiceToken authenticates a service account in service-to-service calls.
+// Service tokens are stored apart from API keys, and one can never be used in
+// place of the other.
+type ServiceToken struct {
+	ID               int64     `xorm:"pk autoincr 'id'" db:"id" json:"id"`
+	OrgID            int64     `xorm:"org_id" db:"org_id" json:"orgId"`
+	ServiceAccountID int64     `xorm:"service_account_id" db:"service_account_id" json:"serviceAccountId"`
+	Name             string    `db:"name" json:"name"`
+	Key              string    `db:"key" json:"-"`
+	Created          time.Time `db:"created" json:"created"`
+	Expires          *int64    `db:"expires" json:"expires,omitempty"`
+	// Token is the secret of the token. It is only set when the token is
+	// generated, as only its hash is stored.
+	Token string `xorm:"-" db:"-" json:"token,omitempty"`
+}
+
+func (t ServiceToken) TableName() string { return "service_tokens" }
+
+// Expired returns whether the token has expired at now. Tokens without an
+// expiry never expire.
+func (t ServiceToken) Expired(now time.Time) bool {
+	return t.Expires != nil && *t.Expires <= now.Unix()
+}
+
+type ServiceTokenCommand struct {
+	OrgID            int64  `json:"-"`
+	ServiceAccountID int64  `json:"-"`
+	Name             string `json:"name" binding:"Required"`
+	SecondsToLive    int64  `json:"secondsToLive"`
+}
+
+// DefaultCleanupBatchSize is the number of keys deleted per transaction by
+// CleanupExpiredAPIKeys unless the command sets one.
+const DefaultCleanupBatchSize = 1000
+
+// CleanupCommand selects the API keys that expired more than OlderThan ago, in
+// the org OrgID or in all orgs if it is nil. Service account tokens are not
+// included.
+type CleanupCommand struct {
+	OrgID     *int64
+	OlderThan time.Duration
+	DryRun    bool
+	BatchSize int
+}
+
+// CleanupResult reports the keys found by a cleanup and how many of them were
+// deleted. Deleted is always 0 for a dry run.
+type CleanupResult struct {
+	Found   int64
+	Deleted int64
+	Errors  []error
+}
+
+// MaxValidateTokens is the number of tokens ValidateAPIKeys accepts at once.
+const MaxValidateTokens = 100
+
+// Reasons a token is reported invalid by ValidateAPIKeys.
+const (
+	ValidationMalformed = "malformed"
+	ValidationNotFound  = "not_found"
+	ValidationRevoked   = "revoked"
+	ValidationExpired   = "expired"
+)
+
+// ValidationResult is the outcome of validating a token. Expired is set for
+// keys past their expiry, which are still valid during their grace period.
+// Reason is set when the token is not valid.
+type ValidationResult struct {
+	TokenID int64  `json:"token_id"`
+	Valid   bool   `json:"valid"`
+	Expired bool   `json:"expired"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// KeyStatus is where a key is in its lifecycle, as reported by
+// ListAPIKeysByStatus.
+type KeyStatus string
+
+const (
+	// KeyStatusActive keys do not expire within ExpiringSoonWindow.
+	KeyStatusActive KeyStatus = "active"
+	// KeyStatusExpiringSoon keys expire within ExpiringSoonWindow.
+	KeyStatusExpiringSoon KeyStatus = "expiring_soon"
+	KeyStatusExpired      KeyStatus = "expired"
+	// KeyStatusDeleted keys have been revoked. Revoked keys are kept, unlike
+	// keys deleted with DeleteApiKey, which are not reported at all.
+	KeyStatusDeleted KeyStatus = "deleted"
+)
+
+// KeyStatuses are all the statuses a key can be in.
+var KeyStatuses = []KeyStatus{KeyStatusActive, KeyStatusExpiringSoon, KeyStatusExpired, KeyStatusDeleted}
+
+// ExpiringSoonWindow is how close to its expiry a key is reported as
+// KeyStatusExpiringSoon.
+const ExpiringSoonWindow = 7 * 24 * time.Hour
+
+// KeyEventType is the lifecycle event a KeyEvent reports.
+type KeyEventType string
+
+const (
+	KeyEventCreated KeyEventType = "created"
+	KeyEventDeleted KeyEventType = "deleted"
+	// KeyEventExpired is reported when an expired key is cleaned up.
+	KeyEventExpired KeyEventType = "expired"
+)
+
+// KeyEvent is sent to webhooks on API key lifecycle events.
+type KeyEvent struct {
+	EventType KeyEventType `json:"eventType"`
+	KeyID     int64        `json:"keyId"`
+	OrgID     int64        `json:"orgId"`
+	Timestamp time.Time    `json:"timestamp"`
+	// ExpiresAt is when the key expires, if it does.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// QuotaTarget is the quota target API keys are counted against.
+const QuotaTarget = "api_key"
+
+// OrgQuotaDTO is the API key quota of an org. A negative limit means unlimited.
+type OrgQuotaDTO struct {
+	OrgID int64 `json:"orgId"`
+	Limit int64 `json:"limit"`
+}
+
+// Pool is a named set of interchangeable API keys of an org, from which
+// SelectKeyFromPool picks keys in turn.
+type Pool struct {
+	ID    int64  `xorm:"pk autoincr 'id'" db:"id" json:"id"`
+	OrgID int64  `xorm:"org_id" db:"org_id" json:"orgId"`
+	Name  string `db:"name" json:"name"`
+	// Quota is the number of times keys can be selected from the pool, shared
+	// by all its keys. A negative quota means unlimited.
+	Quota   int64     `db:"quota" json:"quota"`
+	Created time.Time `db:"created" json:"created"`
+	Updated time.Time `db:"updated" json:"updated"`
+	// KeyIDs are the IDs of the member keys, in ascending order.
+	KeyIDs []int64 `xorm:"-" db:"-" json:"keyIds"`
+	// Usage is the number of times keys were selected from the pool, the sum
+	// of the usage of its members.
+	Usage int64 `xorm:"-" db:"-" json:"usage"`
+}
+
+func (p Pool) TableName() string { return "api_key_pool" }
+
+// PoolMember is the membership of a key in a pool. Usage is counted per pool,
+// so a key in two pools counts against the quota of each separately.
+type PoolMember struct {
+	ID     int64 `xorm:"pk autoincr 'id'" db:"id"`
+	PoolID int64 `xorm:"pool_id" db:"pool_id"`
+	KeyID  int64 `xorm:"key_id" db:"key_id"`
+	// UsageCount is the number of times the key was selected from the pool.
+	UsageCount int64 `xorm:"usage_count" db:"usage_count"`
+}
+
+func (m PoolMember) TableName() string { return "api_key_pool_member" }
+
+// SetMembers sets the key IDs and usage of the pool from its members.
+func (p *Pool) SetMembers(members []*PoolMember) {
+	p.KeyIDs = make([]int64, 0, len(members))
+	p.Usage = 0
+	for _, m := range members {
+		p.KeyIDs = append(p.KeyIDs, m.KeyID)
+		p.Usage += m.UsageCount
+	}
+}
+
+type CreatePoolCommand struct {
+	OrgID  int64   `json:"-"`
+	Name   string  `json:"name" binding:"Required"`
+	KeyIDs []int64 `json:"keyIds"`
+	Quota  int64   `json:"quota"`
+}
+
+// UpdatePoolCommand replaces the name, members and quota of a pool. Members
+// that stay in the pool keep their usage.
+type UpdatePoolCommand struct {
+	ID     int64   `json:"-"`
+	OrgID  int64   `json:"-"`
+	Name   string  `json:"name" binding:"Required"`
+	KeyIDs []int64 `json:"keyIds"`
+	Quota  int64   `json:"quota"`
+}
+
 type GetByIDQuery struct {
 	ApiKeyId int64
 	Result   *APIKey
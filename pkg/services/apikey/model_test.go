@@ -0,0 +1,57 @@
+package apikey
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKey_CheckIP(t *testing.T) {
+	tests := []struct {
+		desc    string
+		cidrs   CIDRList
+		ip      string
+		allowed bool
+	}{
+		{desc: "empty allowlist allows any address", ip: "203.0.113.7", allowed: true},
+		{desc: "empty allowlist allows unknown address", allowed: true},
+		{desc: "address in allowlist", cidrs: CIDRList{"10.0.0.0/8", "192.168.1.0/24"}, ip: "192.168.1.20", allowed: true},
+		{desc: "IPv6 address in allowlist", cidrs: CIDRList{"2001:db8::/32"}, ip: "2001:db8::1", allowed: true},
+		{desc: "address not in allowlist", cidrs: CIDRList{"10.0.0.0/8"}, ip: "192.168.1.20"},
+		{desc: "unknown address", cidrs: CIDRList{"10.0.0.0/8"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			err := APIKey{AllowedCIDRs: tt.cidrs}.CheckIP(net.ParseIP(tt.ip))
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrAPIKeyIPNotAllowed)
+			}
+		})
+	}
+}
+
+func TestValidateCIDRs(t *testing.T) {
+	assert.NoError(t, ValidateCIDRs(nil))
+	assert.NoError(t, ValidateCIDRs([]string{"10.0.0.0/8", "2001:db8::/32"}))
+	assert.ErrorIs(t, ValidateCIDRs([]string{"10.0.0.0/8", "10.0.0.1"}), ErrInvalidCIDR)
+	assert.ErrorIs(t, ValidateCIDRs([]string{"300.0.0.0/8"}), ErrInvalidCIDR)
+}
+
+func TestValidateScopes(t *testing.T) {
+	assert.NoError(t, ValidateScopes(nil))
+	assert.NoError(t, ValidateScopes([]string{"grafana:read:dashboards", "openid"}))
+	assert.ErrorIs(t, ValidateScopes([]string{""}), ErrInvalidScope)
+	assert.ErrorIs(t, ValidateScopes([]string{"grafana:read:dashboards grafana:write:dashboards"}), ErrInvalidScope)
+}
+
+func TestScopeList_Contains(t *testing.T) {
+	scopes := ScopeList{"grafana:read:dashboards", "grafana:read:folders"}
+	assert.NoError(t, scopes.Contains(nil))
+	assert.NoError(t, scopes.Contains([]string{"grafana:read:folders", "grafana:read:dashboards"}))
+	assert.ErrorIs(t, scopes.Contains([]string{"grafana:write:dashboards"}), ErrScopeNotGranted)
+	assert.ErrorIs(t, ScopeList(nil).Contains([]string{"grafana:read:dashboards"}), ErrScopeNotGranted)
+}
@@ -0,0 +1,49 @@
+package apikey
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const redacted = "REDACTED"
+
+// RedactedToken holds a token, or the hash of one, that must never be written
+// to logs. It formats as REDACTED and marshals to JSON as "[REDACTED]".
+type RedactedToken string
+
+func (RedactedToken) String() string { return redacted }
+
+func (RedactedToken) GoString() string { return redacted }
+
+func (RedactedToken) MarshalJSON() ([]byte, error) {
+	return json.Marshal("[" + redacted + "]")
+}
+
+// String formats the key with its secret, the hash of it and the hash of its
+// creation secret redacted, so that printing a key never prints its secret.
+func (k APIKey) String() string {
+	// safeKey has the fields of APIKey but not its String method.
+	type safeKey APIKey
+	safe := safeKey(k)
+	for _, f := range []*string{&safe.Key, &safe.VerifierHash, &safe.CreationSecretHash} {
+		if *f != "" {
+			*f = redacted
+		}
+	}
+	return fmt.Sprintf("%+v", safe)
+}
+
+// GoString formats the key as String does.
+func (k APIKey) GoString() string { return k.String() }
+
+// String formats the token with its secret and the hash of it redacted.
+func (t ServiceToken) String() string {
+	// safeToken has the fields of ServiceToken but not its String method.
+	type safeToken ServiceToken
+	safe := safeToken(t)
+	safe.Key = redacted
+	if safe.Token != "" {
+		safe.Token = redacted
+	}
+	return fmt.Sprintf("%+v", safe)
+}
@@ -0,0 +1,41 @@
+package apikey
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedaction(t *testing.T) {
+	const secret = "eyJrIjoiT0tTcG1pUlY2RnVKZTFVaDFsNFZXdE9ZWmNrMkZYbk"
+
+	t.Run("API keys", func(t *testing.T) {
+		cmd := AddCommand{Result: &APIKey{Id: 1, Name: "ci", Key: secret, VerifierHash: secret, CreationSecretHash: secret, ConfirmationAttempts: 2}}
+
+		for _, s := range []string{
+			fmt.Sprintf("%v", cmd.Result),
+			fmt.Sprintf("%+v", cmd.Result),
+			fmt.Sprintf("%v", *cmd.Result),
+			fmt.Sprintf("%s", cmd.Result),
+			fmt.Sprintf("%#v", cmd.Result),
+		} {
+			assert.NotContains(t, s, secret)
+		}
+		assert.Contains(t, fmt.Sprintf("%v", cmd.Result), "Name:ci")
+		assert.Contains(t, fmt.Sprintf("%v", cmd.Result), "ConfirmationAttempts:2")
+		assert.Contains(t, fmt.Sprintf("%v", APIKey{Name: "ci"}), "Key: ")
+	})
+
+	t.Run("service tokens", func(t *testing.T) {
+		token := &ServiceToken{ID: 1, Name: "svc", Key: secret, Token: secret}
+		assert.NotContains(t, fmt.Sprintf("%v", token), secret)
+		assert.NotContains(t, fmt.Sprintf("%+v", *token), secret)
+
+		b, err := json.Marshal(RedactedToken(secret))
+		require.NoError(t, err)
+		assert.Equal(t, `"[REDACTED]"`, string(b))
+	})
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/grafana/grafana/pkg/infra/tracing"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/annotations"
+	"github.com/grafana/grafana/pkg/services/apikey"
 	"github.com/grafana/grafana/pkg/services/dashboardsnapshots"
 	dashver "github.com/grafana/grafana/pkg/services/dashboardversion"
 	"github.com/grafana/grafana/pkg/services/loginattempt"
@@ -31,7 +32,8 @@ import (
 func ProvideService(cfg *setting.Cfg, serverLockService *serverlock.ServerLockService,
 	shortURLService shorturls.Service, sqlstore db.DB, queryHistoryService queryhistory.Service,
 	dashboardVersionService dashver.Service, dashSnapSvc dashboardsnapshots.Service, deleteExpiredImageService *image.DeleteExpiredService,
-	loginAttemptService loginattempt.Service, tempUserService tempuser.Service, tracer tracing.Tracer, annotationCleaner annotations.Cleaner) *CleanUpService {
+	loginAttemptService loginattempt.Service, tempUserService tempuser.Service, tracer tracing.Tracer, annotationCleaner annotations.Cleaner,
+	apiKeyService apikey.Service) *CleanUpService {
 	s := &CleanUpService{
 		Cfg:                       cfg,
 		ServerLockService:         serverLockService,
@@ -46,6 +48,7 @@ func ProvideService(cfg *setting.Cfg, serverLockService *serverlock.ServerLockSe
 		tempUserService:           tempUserService,
 		tracer:                    tracer,
 		annotationCleaner:         annotationCleaner,
+		apiKeyService:             apiKeyService,
 	}
 	return s
 }
@@ -64,6 +67,7 @@ type CleanUpService struct {
 	loginAttemptService       loginattempt.Service
 	tempUserService           tempuser.Service
 	annotationCleaner         annotations.Cleaner
+	apiKeyService             apikey.Service
 }
 
 type cleanUpJob struct {
@@ -107,6 +111,7 @@ func (srv *CleanUpService) clean(ctx context.Context) {
 		{"delete stale short URLs", srv.deleteStaleShortURLs},
 		{"delete stale query history", srv.deleteStaleQueryHistory},
 		{"delete old login attempts", srv.deleteOldLoginAttempts},
+		{"delete unconfirmed API keys", srv.deleteUnconfirmedAPIKeys},
 	}
 
 	logger := srv.log.FromContext(ctx)
@@ -254,6 +259,16 @@ func (srv *CleanUpService) deleteOldLoginAttemptsWithoutLock(ctx context.Context
 	}
 }
 
+func (srv *CleanUpService) deleteUnconfirmedAPIKeys(ctx context.Context) {
+	logger := srv.log.FromContext(ctx)
+	deleted, err := srv.apiKeyService.DeleteUnconfirmedAPIKeys(ctx, time.Now().Add(-apikey.UnconfirmedKeyLifetime))
+	if err != nil {
+		logger.Error("Problem deleting unconfirmed API keys", "error", err.Error())
+	} else {
+		logger.Debug("Deleted unconfirmed API keys", "rows affected", deleted)
+	}
+}
+
 func (srv *CleanUpService) expireOldUserInvites(ctx context.Context) {
 	logger := srv.log.FromContext(ctx)
 	maxInviteLifetime := srv.Cfg.UserInviteMaxLifetime
@@ -229,22 +229,20 @@ func (h *ContextHandler) getAPIKey(ctx context.Context, keyString string) (*apik
 		return nil, err
 	}
 
-	// fetch key
-	keyQuery := apikey.GetByNameQuery{KeyName: decoded.Name, OrgId: decoded.OrgId}
-	if err := h.apiKeyService.GetApiKeyByName(ctx, &keyQuery); err != nil {
-		return nil, err
-	}
-
-	// validate api key
-	isValid, err := apikeygen.IsValid(decoded, keyQuery.Result.Key)
+	// fetch and validate key
+	hash, err := util.EncodePassword(decoded.Key, decoded.Name)
 	if err != nil {
 		return nil, err
 	}
-	if !isValid {
+	key, err := h.apiKeyService.VerifyAPIKey(ctx, decoded.OrgId, decoded.Name, hash)
+	if errors.Is(err, apikey.ErrHashMismatch) {
 		return nil, apikeygen.ErrInvalidApiKey
 	}
+	if err != nil {
+		return nil, err
+	}
 
-	return keyQuery.Result, nil
+	return key, nil
 }
 
 func (h *ContextHandler) initContextWithAPIKey(reqContext *models.ReqContext) bool {
@@ -270,6 +268,10 @@ func (h *ContextHandler) initContextWithAPIKey(reqContext *models.ReqContext) bo
 	ctx := WithAuthHTTPHeader(reqContext.Req.Context(), "Authorization")
 	*reqContext.Req = *reqContext.Req.WithContext(ctx)
 
+	if decoded, err := apikeygenprefix.Decode(keyString); err == nil && decoded.ServiceID == apikey.ServiceTokenServiceID {
+		return h.initContextWithServiceToken(reqContext, decoded)
+	}
+
 	var (
 		apikey *apikey.APIKey
 		errKey error
@@ -294,9 +296,15 @@ func (h *ContextHandler) initContextWithAPIKey(reqContext *models.ReqContext) bo
 	if getTime == nil {
 		getTime = time.Now
 	}
-	if apikey.Expires != nil && *apikey.Expires <= getTime().Unix() {
-		reqContext.JsonApiErr(http.StatusUnauthorized, "Expired API key", nil)
-		return true
+	if expired, graceRemaining := apikey.GracePeriodRemaining(getTime()); expired {
+		if graceRemaining == 0 {
+			reqContext.JsonApiErr(http.StatusUnauthorized, "Expired API key", nil)
+			return true
+		}
+
+		reqContext.Logger.Warn("Expired API key accepted within its grace period", "keyID", apikey.Id, "graceRemaining", graceRemaining)
+		reqContext.Resp.Header().Set("X-Grafana-Key-Expired", "true")
+		reqContext.Resp.Header().Set("Retry-After", strconv.FormatInt(graceRemaining, 10))
 	}
 
 	if apikey.IsRevoked != nil && *apikey.IsRevoked {
@@ -305,6 +313,15 @@ func (h *ContextHandler) initContextWithAPIKey(reqContext *models.ReqContext) bo
 		return true
 	}
 
+	// the allowlist is checked against the peer address, as the X-Forwarded-For
+	// and X-Real-IP headers are set by the client. An address that cannot be
+	// parsed is only allowed by keys without an allowlist.
+	ip, _ := network.GetIPFromAddress(reqContext.Req.RemoteAddr)
+	if err := apikey.CheckIP(ip); err != nil {
+		reqContext.JsonApiErr(http.StatusForbidden, err.Error(), err)
+		return true
+	}
+
 	// update api_key last used date
 	if err := h.apiKeyService.UpdateAPIKeyLastUsedDate(reqContext.Req.Context(), apikey.Id); err != nil {
 		reqContext.JsonApiErr(http.StatusInternalServerError, InvalidAPIKey, errKey)
@@ -313,7 +330,7 @@ func (h *ContextHandler) initContextWithAPIKey(reqContext *models.ReqContext) bo
 
 	if apikey.ServiceAccountId == nil || *apikey.ServiceAccountId < 1 { //There is no service account attached to the apikey
 		//Use the old APIkey method.  This provides backwards compatibility.
-		reqContext.SignedInUser = &user.SignedInUser{}
+		reqContext.SignedInUser = &user.SignedInUser{OAuthScopes: apikey.Scopes}
 		reqContext.OrgRole = apikey.Role
 		reqContext.ApiKeyID = apikey.Id
 		reqContext.OrgID = apikey.OrgId
@@ -324,7 +341,51 @@ func (h *ContextHandler) initContextWithAPIKey(reqContext *models.ReqContext) bo
 	//There is a service account attached to the API key
 
 	//Use service account linked to API key as the signed in user
-	querySignedInUser := user.GetSignedInUserQuery{UserID: *apikey.ServiceAccountId, OrgID: apikey.OrgId}
+	h.signInServiceAccount(reqContext, apikey.OrgId, *apikey.ServiceAccountId)
+	if reqContext.IsSignedIn && len(apikey.Scopes) > 0 {
+		// copy the user, which may be shared through the signed in user cache
+		signedInUser := *reqContext.SignedInUser
+		signedInUser.OAuthScopes = apikey.Scopes
+		reqContext.SignedInUser = &signedInUser
+	}
+	return true
+}
+
+// initContextWithServiceToken authenticates the service account owning the
+// given service token.
+func (h *ContextHandler) initContextWithServiceToken(reqContext *models.ReqContext, decoded *apikeygenprefix.PrefixedKey) bool {
+	hash, err := decoded.Hash()
+	if err != nil {
+		reqContext.JsonApiErr(http.StatusInternalServerError, InvalidAPIKey, err)
+		return true
+	}
+
+	token, err := h.apiKeyService.GetServiceTokenByHash(reqContext.Req.Context(), hash)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, apikey.ErrInvalid) {
+			status = http.StatusUnauthorized
+		}
+		reqContext.JsonApiErr(status, InvalidAPIKey, err)
+		return true
+	}
+
+	getTime := h.GetTime
+	if getTime == nil {
+		getTime = time.Now
+	}
+	if token.Expired(getTime()) {
+		reqContext.JsonApiErr(http.StatusUnauthorized, "Expired service token", nil)
+		return true
+	}
+
+	return h.signInServiceAccount(reqContext, token.OrgID, token.ServiceAccountID)
+}
+
+// signInServiceAccount signs in the service account with the given ID, used
+// when authenticating with a token owned by it.
+func (h *ContextHandler) signInServiceAccount(reqContext *models.ReqContext, orgID, serviceAccountID int64) bool {
+	querySignedInUser := user.GetSignedInUserQuery{UserID: serviceAccountID, OrgID: orgID}
 	querySignedInUserResult, err := h.userService.GetSignedInUserWithCacheCtx(reqContext.Req.Context(), &querySignedInUser)
 	if err != nil {
 		reqContext.Logger.Error(
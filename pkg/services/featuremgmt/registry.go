@@ -352,5 +352,10 @@ var (
 			State:           FeatureStateAlpha,
 			RequiresDevMode: true,
 		},
+		{
+			Name:        "abTestTheme",
+			Description: "Serve the theme of active preferences experiments to the users in their experiment arm",
+			State:       FeatureStateAlpha,
+		},
 	}
 )
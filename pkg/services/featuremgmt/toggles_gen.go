@@ -262,4 +262,8 @@ const (
 	// FlagNestedFolders
 	// Enable folder nesting
 	FlagNestedFolders = "nestedFolders"
+
+	// FlagAbTestTheme
+	// Serve the theme of active preferences experiments to the users in their experiment arm
+	FlagAbTestTheme = "abTestTheme"
 )
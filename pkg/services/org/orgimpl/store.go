@@ -235,6 +235,10 @@ func (ss *sqlStore) Delete(ctx context.Context, cmd *org.DeleteOrgCommand) error
 			}
 		}
 
+		sess.PublishAfterCommit(&events.OrgDeleted{
+			Timestamp: time.Now(),
+			Id:        cmd.ID,
+		})
 		return nil
 	})
 }
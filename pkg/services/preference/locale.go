@@ -0,0 +1,94 @@
+package pref
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// DefaultLocale is the locale whose formats are used when the locale of the
+// preferences is not registered.
+const DefaultLocale = "en-US"
+
+var (
+	ErrInvalidLocale = errors.New("locale must have a date and a time format")
+	ErrLocaleExists  = errors.New("locale already registered")
+)
+
+//go:embed locales.json
+var localesJSON []byte
+
+// LocaleConfig holds the default formats of a locale, as moment.js format
+// strings.
+type LocaleConfig struct {
+	DateFormat string `json:"dateFormat"`
+	TimeFormat string `json:"timeFormat"`
+}
+
+// LocaleRegistry holds the formats of the known locales by locale tag, e.g. fr-FR.
+type LocaleRegistry struct {
+	mu      sync.RWMutex
+	formats map[string]LocaleConfig
+}
+
+// LocaleFormats is the registry of the locales shipped with Grafana, to which
+// plugins can add theirs with RegisterLocale.
+var LocaleFormats = mustLoadLocales(localesJSON)
+
+func mustLoadLocales(data []byte) *LocaleRegistry {
+	formats := map[string]LocaleConfig{}
+	if err := json.Unmarshal(data, &formats); err != nil {
+		panic("invalid embedded locales: " + err.Error())
+	}
+	return &LocaleRegistry{formats: formats}
+}
+
+// RegisterLocale adds a locale to LocaleFormats. Locales shipped with Grafana
+// cannot be overridden.
+func RegisterLocale(locale string, cfg LocaleConfig) error {
+	return LocaleFormats.Register(locale, cfg)
+}
+
+// Register adds a locale to the registry, failing if it is already known.
+func (r *LocaleRegistry) Register(locale string, cfg LocaleConfig) error {
+	if locale == "" || cfg.DateFormat == "" || cfg.TimeFormat == "" {
+		return ErrInvalidLocale
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.formats[locale]; ok {
+		return ErrLocaleExists
+	}
+	r.formats[locale] = cfg
+	return nil
+}
+
+// Lookup returns the formats of the locale. A locale that is not registered
+// gets the formats of the first registered locale of the same language, so
+// that fr-CA gets the formats of fr-FR, and the formats of DefaultLocale
+// otherwise. The boolean reports whether the locale or its language was found.
+func (r *LocaleRegistry) Lookup(locale string) (LocaleConfig, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if cfg, ok := r.formats[locale]; ok {
+		return cfg, true
+	}
+
+	if lang, _, _ := strings.Cut(locale, "-"); lang != "" {
+		var match string
+		for tag := range r.formats {
+			if strings.HasPrefix(tag, lang+"-") && (match == "" || tag < match) {
+				match = tag
+			}
+		}
+		if match != "" {
+			return r.formats[match], true
+		}
+	}
+
+	return r.formats[DefaultLocale], false
+}
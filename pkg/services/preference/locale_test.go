@@ -0,0 +1,32 @@
+package pref
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocaleRegistry(t *testing.T) {
+	registry := mustLoadLocales(localesJSON)
+
+	cfg, ok := registry.Lookup("fr-FR")
+	require.True(t, ok)
+	assert.Equal(t, "DD/MM/YYYY", cfg.DateFormat)
+
+	cfg, ok = registry.Lookup("fr-CA")
+	require.True(t, ok, "unknown locales should get the formats of their language")
+	assert.Equal(t, "DD/MM/YYYY", cfg.DateFormat)
+
+	cfg, ok = registry.Lookup("xx-XX")
+	require.False(t, ok)
+	assert.Equal(t, "MM/DD/YYYY", cfg.DateFormat, "unknown languages should get the default formats")
+
+	require.NoError(t, registry.Register("xx-XX", LocaleConfig{DateFormat: "YYYY-MM-DD", TimeFormat: "HH:mm"}))
+	cfg, ok = registry.Lookup("xx-XX")
+	require.True(t, ok)
+	assert.Equal(t, LocaleConfig{DateFormat: "YYYY-MM-DD", TimeFormat: "HH:mm"}, cfg)
+
+	assert.ErrorIs(t, registry.Register("fr-FR", LocaleConfig{DateFormat: "YYYY-MM-DD", TimeFormat: "HH:mm"}), ErrLocaleExists)
+	assert.ErrorIs(t, registry.Register("yy-YY", LocaleConfig{DateFormat: "YYYY-MM-DD"}), ErrInvalidLocale)
+}
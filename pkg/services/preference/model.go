@@ -6,10 +6,36 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 )
 
-var ErrPrefNotFound = errors.New("preference not found")
+var (
+	ErrPrefNotFound                 = errors.New("preference not found")
+	ErrPreferenceConflict           = errors.New("preference was modified concurrently")
+	ErrPreferenceVersionNotFound    = errors.New("preference version not found")
+	ErrInvalidPluginID              = errors.New("invalid plugin id")
+	ErrInvalidPluginPreferenceValue = errors.New("plugin preference value must be valid JSON")
+	ErrPresetNotFound               = errors.New("preferences preset not found")
+	ErrPresetNameRequired           = errors.New("preferences preset name is required")
+	ErrInvalidBulkMask              = errors.New("bulk preferences mask must list at least one field and only fields that can be set in bulk")
+	ErrInvalidBulkScope             = errors.New("invalid bulk preferences scope filter")
+	ErrExperimentNotFound           = errors.New("active preferences experiment not found")
+	ErrExperimentExists             = errors.New("preferences experiment already exists")
+	ErrInvalidExperiment            = errors.New("invalid preferences experiment")
+	ErrUnsupportedImportFormat      = errors.New("unsupported preferences file format, expected .yaml, .yml or .ini")
+	ErrInvalidImportFile            = errors.New("invalid preferences file")
+	ErrDashboardUIDRequired         = errors.New("dashboard UID is required")
+	ErrOrgThemeConfigNotFound       = errors.New("org theme config not found")
+	ErrInvalidOrgThemePolicy        = errors.New("invalid org theme policy")
+)
+
+// pluginIDPattern restricts plugin IDs used as a preference namespace to a
+// conservative character set.
+var pluginIDPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+const maxPluginIDLength = 190
 
 type Preference struct {
 	ID              int64               `xorm:"pk autoincr 'id'" db:"id"`
@@ -17,7 +43,7 @@ type Preference struct {
 	UserID          int64               `xorm:"user_id" db:"user_id"`
 	TeamID          int64               `xorm:"team_id" db:"team_id"`
 	Teams           []int64             `xorm:"extends"`
-	Version         int                 `db:"version"`
+	Version         int64               `db:"version"`
 	HomeDashboardID int64               `xorm:"home_dashboard_id" db:"home_dashboard_id"`
 	Timezone        string              `db:"timezone"`
 	WeekStart       string              `db:"week_start"`
@@ -25,6 +51,11 @@ type Preference struct {
 	Created         time.Time           `db:"created"`
 	Updated         time.Time           `db:"updated"`
 	JSONData        *PreferenceJSONData `xorm:"json_data" db:"json_data"`
+
+	// DefaultDateFormat and DefaultTimeFormat are the formats of the locale,
+	// resolved by GetWithDefaults. They are not stored.
+	DefaultDateFormat string `xorm:"-" db:"-"`
+	DefaultTimeFormat string `xorm:"-" db:"-"`
 }
 
 type GetPreferenceWithDefaultsQuery struct {
@@ -52,6 +83,10 @@ type SavePreferenceCommand struct {
 	Locale           string                  `json:"locale,omitempty"`
 	Navbar           *NavbarPreference       `json:"navbar,omitempty"`
 	QueryHistory     *QueryHistoryPreference `json:"queryHistory,omitempty"`
+
+	// ExpectedVersion, when set, makes the save fail with ErrPreferenceConflict
+	// if the stored preference version differs.
+	ExpectedVersion *int64 `json:"-"`
 }
 
 type PatchPreferenceCommand struct {
@@ -84,6 +119,35 @@ type PreferenceJSONData struct {
 	Locale       string                 `json:"locale"`
 	Navbar       NavbarPreference       `json:"navbar"`
 	QueryHistory QueryHistoryPreference `json:"queryHistory"`
+	// PanelState is whether panels are collapsed, by dashboard UID then panel ID.
+	PanelState PanelState `json:"panelState,omitempty"`
+	// Encrypted holds the ciphertext of the fields encrypted in storage, by
+	// JSON field name. Preferences read from the store have their fields
+	// decrypted and no Encrypted.
+	Encrypted map[string][]byte `json:"encrypted,omitempty"`
+}
+
+// EncryptableJSONDataFields are the JSON fields of PreferenceJSONData that can
+// be encrypted in storage.
+var EncryptableJSONDataFields = []string{"locale", "navbar", "queryHistory", "panelState"}
+
+// PanelState is whether panels are collapsed, by dashboard UID then panel ID.
+// It is UI state rather than a preference set by the user, so it is kept when
+// preferences are saved or rolled back.
+type PanelState map[string]map[string]bool
+
+// Set records the collapsed state of the panels of the dashboard, keeping the
+// state of other panels.
+func (s PanelState) Set(dashboardUID string, panels map[string]bool) {
+	if len(panels) == 0 {
+		return
+	}
+	if s[dashboardUID] == nil {
+		s[dashboardUID] = make(map[string]bool, len(panels))
+	}
+	for panelID, collapsed := range panels {
+		s[dashboardUID][panelID] = collapsed
+	}
 }
 
 type QueryHistoryPreference struct {
@@ -126,3 +190,491 @@ func (j *PreferenceJSONData) ToDB() ([]byte, error) {
 }
 
 func (p Preference) TableName() string { return "preferences" }
+
+// PreferenceHistory is a copy of a preference as it was saved at a given
+// version.
+type PreferenceHistory struct {
+	ID              int64               `xorm:"pk autoincr 'id'" db:"id"`
+	OrgID           int64               `xorm:"org_id" db:"org_id"`
+	UserID          int64               `xorm:"user_id" db:"user_id"`
+	TeamID          int64               `xorm:"team_id" db:"team_id"`
+	Version         int64               `db:"version"`
+	HomeDashboardID int64               `xorm:"home_dashboard_id" db:"home_dashboard_id"`
+	Timezone        string              `db:"timezone"`
+	WeekStart       string              `db:"week_start"`
+	Theme           string              `db:"theme"`
+	JSONData        *PreferenceJSONData `xorm:"json_data" db:"json_data"`
+	Created         time.Time           `db:"created"`
+}
+
+func (p PreferenceHistory) TableName() string { return "preferences_history" }
+
+// NewPreferenceHistory returns a history entry recording p as it is now.
+func NewPreferenceHistory(p *Preference) *PreferenceHistory {
+	return &PreferenceHistory{
+		OrgID:           p.OrgID,
+		UserID:          p.UserID,
+		TeamID:          p.TeamID,
+		Version:         p.Version,
+		HomeDashboardID: p.HomeDashboardID,
+		Timezone:        p.Timezone,
+		WeekStart:       p.WeekStart,
+		Theme:           p.Theme,
+		JSONData:        p.JSONData,
+		Created:         p.Updated,
+	}
+}
+
+// Preference returns the preference as it was at the version of the history entry.
+func (p *PreferenceHistory) Preference() *Preference {
+	return &Preference{
+		OrgID:           p.OrgID,
+		UserID:          p.UserID,
+		TeamID:          p.TeamID,
+		Version:         p.Version,
+		HomeDashboardID: p.HomeDashboardID,
+		Timezone:        p.Timezone,
+		WeekStart:       p.WeekStart,
+		Theme:           p.Theme,
+		JSONData:        p.JSONData,
+		Updated:         p.Created,
+	}
+}
+
+// PreferencesHistoryQuery selects the saved versions of the preference of an
+// org, team or user.
+type PreferencesHistoryQuery struct {
+	OrgID  int64
+	UserID int64
+	TeamID int64
+}
+
+// RollbackPreferencesCommand restores the preference of an org, team or user
+// to a saved version.
+type RollbackPreferencesCommand struct {
+	OrgID  int64 `json:"-"`
+	UserID int64 `json:"-"`
+	TeamID int64 `json:"-"`
+
+	Version int64 `json:"version"`
+}
+
+// PluginPreference is a single preference value stored by a plugin. Values are
+// scoped by plugin ID so that plugins can never overwrite core preferences, or
+// each other's.
+type PluginPreference struct {
+	ID        int64  `xorm:"pk autoincr 'id'" db:"id"`
+	OrgID     int64  `xorm:"org_id" db:"org_id"`
+	UserID    int64  `xorm:"user_id" db:"user_id"`
+	PluginID  string `xorm:"plugin_id" db:"plugin_id"`
+	Key       string `xorm:"key" db:"key"`
+	ValueJSON string `xorm:"value_json" db:"value_json"`
+}
+
+func (p PluginPreference) TableName() string { return "plugin_preferences" }
+
+type GetPluginPreferencesQuery struct {
+	OrgID    int64
+	UserID   int64
+	PluginID string
+}
+
+type SavePluginPreferencesCommand struct {
+	OrgID    int64
+	UserID   int64
+	PluginID string
+
+	Preferences map[string]json.RawMessage
+}
+
+// SavePanelStateCommand records whether panels of a dashboard are collapsed
+// for a user. Panels missing from Panels keep their state.
+type SavePanelStateCommand struct {
+	OrgID  int64 `json:"-"`
+	UserID int64 `json:"-"`
+
+	DashboardUID string          `json:"dashboardUid"`
+	Panels       map[string]bool `json:"panels"`
+}
+
+type GetPanelStateQuery struct {
+	OrgID        int64
+	UserID       int64
+	DashboardUID string
+}
+
+// ValidatePluginID returns ErrInvalidPluginID if id cannot be used to scope
+// plugin preferences.
+func ValidatePluginID(id string) error {
+	if len(id) > maxPluginIDLength || !pluginIDPattern.MatchString(id) {
+		return ErrInvalidPluginID
+	}
+	return nil
+}
+
+// Preset is a named, versioned snapshot of preferences that can be applied to
+// the org preferences of any org. Publishing a preset under the name of an
+// existing one adds a new version rather than replacing it.
+type Preset struct {
+	ID          int64              `xorm:"pk autoincr 'id'" db:"id" json:"id"`
+	Name        string             `db:"name" json:"name"`
+	Version     int64              `db:"version" json:"version"`
+	Preferences *PresetPreferences `xorm:"preferences_json" db:"preferences_json" json:"preferences"`
+	Created     time.Time          `db:"created" json:"created"`
+}
+
+func (p Preset) TableName() string { return "preferences_presets" }
+
+// PresetPreferences are the preference values held by a preset. There is no
+// home dashboard, as dashboard IDs are not shared between orgs.
+type PresetPreferences struct {
+	Timezone     string                  `json:"timezone,omitempty"`
+	WeekStart    string                  `json:"weekStart,omitempty"`
+	Theme        string                  `json:"theme,omitempty"`
+	Locale       string                  `json:"locale,omitempty"`
+	Navbar       *NavbarPreference       `json:"navbar,omitempty"`
+	QueryHistory *QueryHistoryPreference `json:"queryHistory,omitempty"`
+}
+
+func (p *PresetPreferences) FromDB(data []byte) error {
+	return json.Unmarshal(data, p)
+}
+
+func (p *PresetPreferences) ToDB() ([]byte, error) {
+	if p == nil {
+		return nil, nil
+	}
+	return json.Marshal(p)
+}
+
+func (p *PresetPreferences) Scan(val interface{}) error {
+	switch v := val.(type) {
+	case []byte:
+		if len(v) == 0 {
+			return nil
+		}
+		return json.Unmarshal(v, p)
+	case string:
+		if len(v) == 0 {
+			return nil
+		}
+		return json.Unmarshal([]byte(v), p)
+	default:
+		return fmt.Errorf("unsupported type: %T", v)
+	}
+}
+
+func (p *PresetPreferences) Value() (driver.Value, error) {
+	return p.ToDB()
+}
+
+// CreatePresetCommand publishes preferences as a preset. If a preset with the
+// same name exists, the preferences are published as its next version.
+type CreatePresetCommand struct {
+	Name        string            `json:"name"`
+	Preferences PresetPreferences `json:"preferences"`
+}
+
+// ApplyPresetCommand copies the values of a preset into the org preferences
+// of an org.
+type ApplyPresetCommand struct {
+	PresetID int64 `json:"-"`
+	OrgID    int64 `json:"orgId"`
+}
+
+const (
+	// BulkScopeAllUsers selects the preferences of all users of the org.
+	BulkScopeAllUsers = "all_users"
+	// BulkScopeUsersWithoutPreference selects the preferences of the users of
+	// the org that set none of the masked fields.
+	BulkScopeUsersWithoutPreference = "users_without_preference"
+)
+
+// BulkPreferenceColumns maps the fields a BulkSetPreferencesCommand can set to
+// their preferences column. Fields stored in the JSON data cannot be set in bulk.
+var BulkPreferenceColumns = map[string]string{
+	"homeDashboardId": "home_dashboard_id",
+	"timezone":        "timezone",
+	"weekStart":       "week_start",
+	"theme":           "theme",
+}
+
+// BulkSetPreferencesCommand sets the fields listed in Mask to their values in
+// Fields, in the stored preferences of the users of an org selected by
+// ScopeFilter. Users without stored preferences are left alone, as they get
+// the org preferences.
+type BulkSetPreferencesCommand struct {
+	OrgID       int64      `json:"-"`
+	Fields      Preference `json:"fields"`
+	Mask        []string   `json:"mask"`
+	ScopeFilter string     `json:"scopeFilter"`
+}
+
+// Validate checks the mask and scope filter of the command.
+func (cmd *BulkSetPreferencesCommand) Validate() error {
+	if len(cmd.Mask) == 0 {
+		return ErrInvalidBulkMask
+	}
+	for _, field := range cmd.Mask {
+		if _, ok := BulkPreferenceColumns[field]; !ok {
+			return fmt.Errorf("%w: %q", ErrInvalidBulkMask, field)
+		}
+	}
+	if cmd.ScopeFilter != BulkScopeAllUsers && cmd.ScopeFilter != BulkScopeUsersWithoutPreference {
+		return ErrInvalidBulkScope
+	}
+	return nil
+}
+
+// FieldValue returns the value in Fields of a field of BulkPreferenceColumns.
+func (cmd *BulkSetPreferencesCommand) FieldValue(field string) interface{} {
+	switch field {
+	case "homeDashboardId":
+		return cmd.Fields.HomeDashboardID
+	case "timezone":
+		return cmd.Fields.Timezone
+	case "weekStart":
+		return cmd.Fields.WeekStart
+	case "theme":
+		return cmd.Fields.Theme
+	}
+	return nil
+}
+
+// Selects returns whether the scope filter of the command selects p, as the
+// SQL stores do in their UPDATE statement.
+func (cmd *BulkSetPreferencesCommand) Selects(p *Preference) bool {
+	if cmd.ScopeFilter != BulkScopeUsersWithoutPreference {
+		return true
+	}
+	for _, field := range cmd.Mask {
+		switch field {
+		case "homeDashboardId":
+			if p.HomeDashboardID != 0 {
+				return false
+			}
+		case "timezone":
+			if p.Timezone != "" {
+				return false
+			}
+		case "weekStart":
+			if p.WeekStart != "" {
+				return false
+			}
+		case "theme":
+			if p.Theme != "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Apply sets the masked fields of p to their values in Fields.
+func (cmd *BulkSetPreferencesCommand) Apply(p *Preference) {
+	for _, field := range cmd.Mask {
+		switch field {
+		case "homeDashboardId":
+			p.HomeDashboardID = cmd.Fields.HomeDashboardID
+		case "timezone":
+			p.Timezone = cmd.Fields.Timezone
+		case "weekStart":
+			p.WeekStart = cmd.Fields.WeekStart
+		case "theme":
+			p.Theme = cmd.Fields.Theme
+		}
+	}
+}
+
+// BulkSetResult reports the number of preferences a bulk set updated.
+type BulkSetResult struct {
+	Updated int64 `json:"updated"`
+}
+
+// Experiment serves the alternate theme Value instead of the default theme to
+// Percentage percent of the users, while it is active. Users are assigned to
+// the experiment arm by their ID, so a user always gets the same theme. A
+// theme saved in the preferences of the user, their teams or org still wins.
+type Experiment struct {
+	ID         int64     `xorm:"pk autoincr 'id'" db:"id" json:"id"`
+	Name       string    `xorm:"experiment_name" db:"experiment_name" json:"name"`
+	VariantKey string    `xorm:"variant_key" db:"variant_key" json:"variantKey"`
+	Value      string    `db:"value" json:"value"`
+	Percentage int       `db:"percentage" json:"percentage"`
+	Active     bool      `db:"active" json:"active"`
+	Created    time.Time `db:"created" json:"created"`
+	Updated    time.Time `db:"updated" json:"updated"`
+}
+
+func (e Experiment) TableName() string { return "preferences_experiments" }
+
+// InVariant returns whether the user is in the experiment arm.
+func (e Experiment) InVariant(userID int64) bool {
+	return userID > 0 && userID%100 < int64(e.Percentage)
+}
+
+// CreateExperimentCommand starts an experiment serving the theme Value to
+// Percentage percent of the users.
+type CreateExperimentCommand struct {
+	Name       string `json:"name"`
+	VariantKey string `json:"variantKey"`
+	Value      string `json:"value"`
+	Percentage int    `json:"percentage"`
+}
+
+// Validate returns ErrInvalidExperiment unless the command has a name, a
+// variant key, a value and a percentage between 0 and 100.
+func (cmd *CreateExperimentCommand) Validate() error {
+	switch {
+	case strings.TrimSpace(cmd.Name) == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidExperiment)
+	case cmd.VariantKey == "":
+		return fmt.Errorf("%w: variant key is required", ErrInvalidExperiment)
+	case cmd.Value == "":
+		return fmt.Errorf("%w: value is required", ErrInvalidExperiment)
+	case cmd.Percentage < 0 || cmd.Percentage > 100:
+		return fmt.Errorf("%w: percentage must be between 0 and 100", ErrInvalidExperiment)
+	}
+	return nil
+}
+
+// ImportFromFileCommand saves the preferences read from a YAML or INI file,
+// told apart by the extension of Filename, as the preferences of the org, team
+// or user. Keys are named like the fields of the preferences API, e.g.
+// weekStart, and nested keys are joined with dots, e.g. queryHistory.homeTab,
+// which is the homeTab key of the [queryHistory] section of an INI file.
+type ImportFromFileCommand struct {
+	OrgID  int64
+	UserID int64
+	TeamID int64
+
+	Filename string
+	Data     []byte
+	// DryRun validates the file without saving the preferences.
+	DryRun bool
+}
+
+// PreferencesScope is what stored preferences apply to: an org, a team or a
+// user of an org.
+type PreferencesScope string
+
+const (
+	PreferencesScopeOrg  PreferencesScope = "org"
+	PreferencesScopeTeam PreferencesScope = "team"
+	PreferencesScopeUser PreferencesScope = "user"
+)
+
+// PreferencesScopes are the scopes in the order preferences are exported.
+var PreferencesScopes = []PreferencesScope{PreferencesScopeOrg, PreferencesScopeTeam, PreferencesScopeUser}
+
+// Scope returns the scope of the preference.
+func (p *Preference) Scope() PreferencesScope {
+	switch {
+	case p.TeamID != 0:
+		return PreferencesScopeTeam
+	case p.UserID != 0:
+		return PreferencesScopeUser
+	}
+	return PreferencesScopeOrg
+}
+
+// ExportPreferencesCommand exports the stored preferences of an org, its
+// teams and its users.
+type ExportPreferencesCommand struct {
+	OrgID int64
+}
+
+// ExportOptions configure StreamExportPreferences.
+type ExportOptions struct {
+	// Filter, if set, exports only the preferences of the scopes it returns
+	// true for.
+	Filter func(scope PreferencesScope) bool
+}
+
+// ExportOption configures StreamExportPreferences.
+type ExportOption func(*ExportOptions)
+
+// WithExportFilter exports only the preferences of the scopes fn returns true for.
+func WithExportFilter(fn func(scope PreferencesScope) bool) ExportOption {
+	return func(o *ExportOptions) {
+		o.Filter = fn
+	}
+}
+
+// ExportedPreference is a line of a preferences export.
+type ExportedPreference struct {
+	Scope           PreferencesScope    `json:"scope"`
+	OrgID           int64               `json:"orgId"`
+	TeamID          int64               `json:"teamId,omitempty"`
+	UserID          int64               `json:"userId,omitempty"`
+	Version         int64               `json:"version"`
+	HomeDashboardID int64               `json:"homeDashboardId,omitempty"`
+	Timezone        string              `json:"timezone,omitempty"`
+	WeekStart       string              `json:"weekStart,omitempty"`
+	Theme           string              `json:"theme,omitempty"`
+	JSONData        *PreferenceJSONData `json:"jsonData,omitempty"`
+	Updated         time.Time           `json:"updated"`
+}
+
+// NewExportedPreference returns the export line of the preference.
+func NewExportedPreference(p *Preference) *ExportedPreference {
+	return &ExportedPreference{
+		Scope:           p.Scope(),
+		OrgID:           p.OrgID,
+		TeamID:          p.TeamID,
+		UserID:          p.UserID,
+		Version:         p.Version,
+		HomeDashboardID: p.HomeDashboardID,
+		Timezone:        p.Timezone,
+		WeekStart:       p.WeekStart,
+		Theme:           p.Theme,
+		JSONData:        p.JSONData,
+		Updated:         p.Updated,
+	}
+}
+
+// ImportResult lists the keys of a file that were imported and warns about
+// the keys that are not preferences.
+type ImportResult struct {
+	Imported []string `json:"imported"`
+	Warnings []string `json:"warnings,omitempty"`
+	DryRun   bool     `json:"dryRun"`
+}
+
+const (
+	// OrgThemePolicyEnforce makes the theme of the org override the theme
+	// saved in the preferences of its users and teams.
+	OrgThemePolicyEnforce = "enforce"
+	// OrgThemePolicySuggest makes the theme of the org the default theme of
+	// its users, used unless they or their teams saved a theme.
+	OrgThemePolicySuggest = "suggest"
+)
+
+// OrgThemeConfig is how the theme of an org applies to its users. It has no
+// effect when Policy is empty.
+type OrgThemeConfig struct {
+	ID      int64     `xorm:"pk autoincr 'id'" db:"id" json:"-"`
+	OrgID   int64     `xorm:"org_id" db:"org_id" json:"orgId"`
+	Policy  string    `db:"policy" json:"policy"`
+	Theme   string    `xorm:"default_theme" db:"default_theme" json:"theme"`
+	Updated time.Time `db:"updated" json:"updated"`
+}
+
+func (c OrgThemeConfig) TableName() string { return "org_theme_config" }
+
+// ValidateOrgThemePolicy returns ErrInvalidOrgThemePolicy unless policy is
+// empty, or is OrgThemePolicyEnforce or OrgThemePolicySuggest with a light
+// or dark theme.
+func ValidateOrgThemePolicy(policy, theme string) error {
+	switch policy {
+	case "":
+		return nil
+	case OrgThemePolicyEnforce, OrgThemePolicySuggest:
+		if theme != "light" && theme != "dark" {
+			return fmt.Errorf("%w: theme must be light or dark", ErrInvalidOrgThemePolicy)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: policy must be %q, %q or empty", ErrInvalidOrgThemePolicy, OrgThemePolicyEnforce, OrgThemePolicySuggest)
+	}
+}
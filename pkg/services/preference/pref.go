@@ -2,6 +2,8 @@ package pref
 
 import (
 	"context"
+	"encoding/json"
+	"io"
 )
 
 type Service interface {
@@ -11,4 +13,48 @@ type Service interface {
 	Patch(context.Context, *PatchPreferenceCommand) error
 	GetDefaults() *Preference
 	DeleteByUser(context.Context, int64) error
+	// GetPreferencesHistory returns the saved versions of a preference, newest first.
+	GetPreferencesHistory(context.Context, *PreferencesHistoryQuery) ([]*Preference, error)
+	// RollbackPreferences restores a saved version of a preference as a new version.
+	RollbackPreferences(context.Context, *RollbackPreferencesCommand) error
+	GetPluginPreferences(context.Context, *GetPluginPreferencesQuery) (map[string]json.RawMessage, error)
+	SavePluginPreferences(context.Context, *SavePluginPreferencesCommand) error
+	DeletePluginPreferences(ctx context.Context, pluginID string) error
+	// CreatePreferencesPreset publishes a preset, as a new version if the name is taken.
+	CreatePreferencesPreset(context.Context, *CreatePresetCommand) (*Preset, error)
+	GetPreferencesPreset(ctx context.Context, presetID int64) (*Preset, error)
+	// ListPreferencesPresets returns every version of every preset, ordered by name and version.
+	ListPreferencesPresets(context.Context) ([]*Preset, error)
+	DeletePreferencesPreset(ctx context.Context, presetID int64) error
+	// ApplyPreferencesPreset overwrites the org preferences of an org with the values of a preset.
+	ApplyPreferencesPreset(context.Context, *ApplyPresetCommand) error
+	// BulkSetPreferences sets fields of the preferences of many users of an org
+	// in a single transaction.
+	BulkSetPreferences(context.Context, *BulkSetPreferencesCommand) (*BulkSetResult, error)
+	// CreatePreferencesExperiment starts an experiment serving an alternate
+	// default theme to a share of the users.
+	CreatePreferencesExperiment(context.Context, *CreateExperimentCommand) (*Experiment, error)
+	// TerminateExperiment stops the active experiment with the given name.
+	TerminateExperiment(ctx context.Context, name string) error
+	// ImportPreferencesFromFile saves the preferences read from a YAML or INI
+	// file, replacing the stored ones like Save.
+	ImportPreferencesFromFile(context.Context, *ImportFromFileCommand) (*ImportResult, error)
+	// SavePanelState records whether panels of a dashboard are collapsed for
+	// a user, keeping the state of the other panels.
+	SavePanelState(context.Context, *SavePanelStateCommand) error
+	// GetPanelState returns whether the panels of a dashboard are collapsed
+	// for a user, by panel ID.
+	GetPanelState(context.Context, *GetPanelStateQuery) (map[string]bool, error)
+	// SetOrgThemePolicy sets how the theme of the org applies to its users,
+	// see OrgThemePolicyEnforce and OrgThemePolicySuggest. An empty policy
+	// turns it off.
+	SetOrgThemePolicy(ctx context.Context, orgID int64, policy, theme string) error
+	// StreamExportPreferences writes the stored preferences of the org, its
+	// teams and its users to w as newline-delimited JSON, ordered by scope,
+	// without loading them all in memory.
+	StreamExportPreferences(ctx context.Context, cmd *ExportPreferencesCommand, w io.Writer, opts ...ExportOption) error
+	// ReEncryptPreferences encrypts the encrypted fields of the stored
+	// preferences of the org again with the current data key, e.g. once data
+	// keys are rotated.
+	ReEncryptPreferences(ctx context.Context, orgID int64) error
 }
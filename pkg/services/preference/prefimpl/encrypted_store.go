@@ -0,0 +1,279 @@
+package prefimpl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	pref "github.com/grafana/grafana/pkg/services/preference"
+	prefstore "github.com/grafana/grafana/pkg/services/preference/store"
+	"github.com/grafana/grafana/pkg/services/secrets"
+)
+
+const (
+	encryptedJSONDataField = "encrypted"
+	// reEncryptPageSize is the number of preferences ReEncryptPreferences
+	// reads from the store at once.
+	reEncryptPageSize = 500
+	// reEncryptAttempts is the number of times ReEncryptPreferences writes a
+	// preference that is saved concurrently.
+	reEncryptAttempts = 3
+)
+
+// ReEncryptPreferences reads the preferences of the org, decrypting their
+// encrypted fields, and writes them back unchanged so that the fields are
+// encrypted with the current data key. The history of the preferences keeps
+// the data keys it was encrypted with.
+//
+// A preference saved between its read and its write is read again, so that the
+// save is not overwritten.
+func (s *Service) ReEncryptPreferences(ctx context.Context, orgID int64) error {
+	for _, scope := range pref.PreferencesScopes {
+		var afterID int64
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			prefs, err := s.store.ListForExport(ctx, orgID, scope, afterID, reEncryptPageSize)
+			if err != nil {
+				return err
+			}
+			for _, p := range prefs {
+				if err := s.reEncryptPreference(ctx, p); err != nil {
+					return err
+				}
+			}
+			if len(prefs) < reEncryptPageSize {
+				break
+			}
+			afterID = prefs[len(prefs)-1].ID
+		}
+	}
+	return nil
+}
+
+func (s *Service) reEncryptPreference(ctx context.Context, p *pref.Preference) error {
+	for attempt := 1; ; attempt++ {
+		err := s.store.UpdateWithVersion(ctx, p, p.Version)
+		if !errors.Is(err, pref.ErrPreferenceConflict) || attempt == reEncryptAttempts {
+			return err
+		}
+		p, err = s.store.Get(prefstore.SkipFastTier(ctx), &pref.Preference{OrgID: p.OrgID, UserID: p.UserID, TeamID: p.TeamID})
+		if errors.Is(err, pref.ErrPrefNotFound) {
+			// deleted since it was listed, there is nothing left to encrypt
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// encryptedStore encrypts the JSON data fields of preferences listed in fields
+// before they reach the wrapped store, and decrypts the encrypted fields of
+// the preferences read from it. Fields are encrypted with the secrets service,
+// whose data keys are encrypted with the secret key of Grafana.
+//
+// Fields encrypted in storage are decrypted whether or not they are still
+// listed in fields, so that a field can stop being encrypted.
+type encryptedStore struct {
+	store
+	secrets secrets.Service
+	fields  []string
+}
+
+func newEncryptedStore(s store, secretsService secrets.Service, fields []string) *encryptedStore {
+	return &encryptedStore{store: s, secrets: secretsService, fields: fields}
+}
+
+func (s *encryptedStore) Get(ctx context.Context, query *pref.Preference) (*pref.Preference, error) {
+	res, err := s.store.Get(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if res.JSONData, err = s.decrypt(ctx, res.JSONData); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *encryptedStore) List(ctx context.Context, query *pref.Preference) ([]*pref.Preference, error) {
+	res, err := s.store.List(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return res, s.decryptAll(ctx, res)
+}
+
+func (s *encryptedStore) ListForExport(ctx context.Context, orgID int64, scope pref.PreferencesScope, afterID int64, limit int) ([]*pref.Preference, error) {
+	res, err := s.store.ListForExport(ctx, orgID, scope, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return res, s.decryptAll(ctx, res)
+}
+
+func (s *encryptedStore) Insert(ctx context.Context, cmd *pref.Preference) (int64, error) {
+	var id int64
+	err := s.write(ctx, cmd, func(encrypted *pref.Preference) (err error) {
+		id, err = s.store.Insert(ctx, encrypted)
+		return err
+	})
+	return id, err
+}
+
+func (s *encryptedStore) Update(ctx context.Context, cmd *pref.Preference) error {
+	return s.write(ctx, cmd, func(encrypted *pref.Preference) error {
+		return s.store.Update(ctx, encrypted)
+	})
+}
+
+func (s *encryptedStore) UpdateWithVersion(ctx context.Context, cmd *pref.Preference, expectedVersion int64) error {
+	return s.write(ctx, cmd, func(encrypted *pref.Preference) error {
+		return s.store.UpdateWithVersion(ctx, encrypted, expectedVersion)
+	})
+}
+
+func (s *encryptedStore) InsertHistory(ctx context.Context, history *pref.PreferenceHistory, maxDepth int) error {
+	jsonData, err := s.encrypt(ctx, history.JSONData)
+	if err != nil {
+		return err
+	}
+	encrypted := *history
+	encrypted.JSONData = jsonData
+	return s.store.InsertHistory(ctx, &encrypted, maxDepth)
+}
+
+func (s *encryptedStore) ListHistory(ctx context.Context, query *pref.PreferencesHistoryQuery) ([]*pref.PreferenceHistory, error) {
+	res, err := s.store.ListHistory(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range res {
+		if h.JSONData, err = s.decrypt(ctx, h.JSONData); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// write passes fn a copy of cmd with its fields encrypted, then copies back
+// what fn set on the copy, such as the ID of inserted preferences, keeping
+// the plaintext JSON data of cmd.
+func (s *encryptedStore) write(ctx context.Context, cmd *pref.Preference, fn func(*pref.Preference) error) error {
+	jsonData, err := s.encrypt(ctx, cmd.JSONData)
+	if err != nil {
+		return err
+	}
+	encrypted := *cmd
+	encrypted.JSONData = jsonData
+	if err := fn(&encrypted); err != nil {
+		return err
+	}
+	encrypted.JSONData = cmd.JSONData
+	*cmd = encrypted
+	return nil
+}
+
+func (s *encryptedStore) decryptAll(ctx context.Context, prefs []*pref.Preference) error {
+	for _, p := range prefs {
+		var err error
+		if p.JSONData, err = s.decrypt(ctx, p.JSONData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encrypt returns a copy of data with the fields to encrypt moved, encrypted,
+// to Encrypted.
+func (s *encryptedStore) encrypt(ctx context.Context, data *pref.PreferenceJSONData) (*pref.PreferenceJSONData, error) {
+	if data == nil || len(s.fields) == 0 {
+		return data, nil
+	}
+
+	fields, err := jsonDataFields(data)
+	if err != nil {
+		return nil, err
+	}
+	delete(fields, encryptedJSONDataField)
+
+	encrypted := make(map[string][]byte, len(s.fields))
+	for _, name := range s.fields {
+		value, ok := fields[name]
+		if !ok {
+			continue
+		}
+		ciphertext, err := s.secrets.Encrypt(ctx, value, secrets.WithoutScope())
+		if err != nil {
+			return nil, err
+		}
+		encrypted[name] = ciphertext
+		delete(fields, name)
+	}
+
+	res, err := jsonDataFromFields(fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted) > 0 {
+		res.Encrypted = encrypted
+	}
+	return res, nil
+}
+
+// decrypt returns a copy of data with the fields in Encrypted decrypted.
+func (s *encryptedStore) decrypt(ctx context.Context, data *pref.PreferenceJSONData) (*pref.PreferenceJSONData, error) {
+	if data == nil || len(data.Encrypted) == 0 {
+		return data, nil
+	}
+
+	fields, err := jsonDataFields(data)
+	if err != nil {
+		return nil, err
+	}
+	delete(fields, encryptedJSONDataField)
+
+	for name, ciphertext := range data.Encrypted {
+		value, err := s.secrets.Decrypt(ctx, ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = value
+	}
+	return jsonDataFromFields(fields)
+}
+
+// jsonDataFields returns the JSON encoding of the fields of data, by name.
+func jsonDataFields(data *pref.PreferenceJSONData) (map[string]json.RawMessage, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func jsonDataFromFields(fields map[string]json.RawMessage) (*pref.PreferenceJSONData, error) {
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	res := &pref.PreferenceJSONData{}
+	if err := json.Unmarshal(encoded, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func isEncryptableField(name string) bool {
+	for _, field := range pref.EncryptableJSONDataFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
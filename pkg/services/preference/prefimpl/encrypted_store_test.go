@@ -0,0 +1,174 @@
+package prefimpl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/infra/db"
+	"github.com/grafana/grafana/pkg/infra/tracing"
+	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	pref "github.com/grafana/grafana/pkg/services/preference"
+	"github.com/grafana/grafana/pkg/services/secrets/database"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	"github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+func TestIntegrationEncryptedPreferencesDataAccess(t *testing.T) {
+	testIntegrationPreferencesDataAccess(t, func(ss db.DB) store {
+		secretsService := manager.SetupTestService(t, database.ProvideSecretsStore(ss))
+		return newEncryptedStore(&sqlStore{db: ss}, secretsService, pref.EncryptableJSONDataFields)
+	})
+}
+
+func TestIntegrationEncryptedStore(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	ctx := context.Background()
+	ss := db.InitTestDB(t)
+	secretsService := manager.SetupTestService(t, database.ProvideSecretsStore(ss))
+
+	cfg := setting.NewCfg()
+	cfg.PreferencesEncryptedFields = []string{"navbar", "locale"}
+	prefService, err := ProvideService(ss, cfg, featuremgmt.WithFeatures(), bus.ProvideBus(tracing.InitializeTracerForTest()), secretsService)
+	require.NoError(t, err)
+
+	navbar := &pref.NavbarPreference{SavedItems: []pref.NavLink{{ID: "vault", Text: "Vault", Url: "/a/vault?token=s3cr3t"}}}
+	require.NoError(t, prefService.Save(ctx, &pref.SavePreferenceCommand{
+		OrgID: 1, UserID: 2, Theme: "dark", Locale: "fr-FR", Navbar: navbar,
+		QueryHistory: &pref.QueryHistoryPreference{HomeTab: "starred"},
+	}))
+
+	rawJSONData := func(t *testing.T, table string) string {
+		t.Helper()
+		var raw string
+		err := ss.WithDbSession(ctx, func(sess *db.Session) error {
+			_, err := sess.SQL("SELECT json_data FROM "+table+" WHERE org_id = ? AND user_id = ?", 1, 2).Get(&raw)
+			return err
+		})
+		require.NoError(t, err)
+		return raw
+	}
+
+	t.Run("the encrypted fields are stored as ciphertext", func(t *testing.T) {
+		for _, table := range []string{"preferences", "preferences_history"} {
+			raw := rawJSONData(t, table)
+			assert.Contains(t, raw, `"encrypted"`)
+			assert.NotContains(t, raw, "s3cr3t")
+			assert.NotContains(t, raw, "fr-FR")
+			// fields not listed are stored in plaintext
+			assert.Contains(t, raw, "starred")
+		}
+	})
+
+	t.Run("preferences are read in plaintext", func(t *testing.T) {
+		res, err := prefService.Get(ctx, &pref.GetPreferenceQuery{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, "fr-FR", res.JSONData.Locale)
+		assert.Equal(t, *navbar, res.JSONData.Navbar)
+		assert.Equal(t, "starred", res.JSONData.QueryHistory.HomeTab)
+		assert.Nil(t, res.JSONData.Encrypted)
+
+		res, err = prefService.GetWithDefaults(ctx, &pref.GetPreferenceWithDefaultsQuery{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, "fr-FR", res.JSONData.Locale)
+		assert.Equal(t, *navbar, res.JSONData.Navbar)
+
+		history, err := prefService.GetPreferencesHistory(ctx, &pref.PreferencesHistoryQuery{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		assert.Equal(t, "fr-FR", history[0].JSONData.Locale)
+	})
+
+	t.Run("preferences are encrypted again with the current data key", func(t *testing.T) {
+		before := rawJSONData(t, "preferences")
+		stored, err := prefService.Get(ctx, &pref.GetPreferenceQuery{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		require.NoError(t, secretsService.RotateDataKeys(ctx))
+
+		require.NoError(t, prefService.ReEncryptPreferences(ctx, 1))
+
+		after := rawJSONData(t, "preferences")
+		assert.NotEqual(t, before, after)
+		assert.NotContains(t, after, "s3cr3t")
+
+		res, err := prefService.Get(ctx, &pref.GetPreferenceQuery{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, *navbar, res.JSONData.Navbar)
+		assert.Equal(t, stored.Version, res.Version)
+	})
+
+	t.Run("fields stop being encrypted once removed from the config", func(t *testing.T) {
+		plain, err := ProvideService(ss, setting.NewCfg(), featuremgmt.WithFeatures(), bus.ProvideBus(tracing.InitializeTracerForTest()), secretsService)
+		require.NoError(t, err)
+		require.NoError(t, plain.ReEncryptPreferences(ctx, 1))
+
+		assert.Contains(t, rawJSONData(t, "preferences"), "s3cr3t")
+	})
+}
+
+// concurrentSaveStore saves the theme of a preference right before the first
+// versioned update, as a user saving it concurrently would.
+type concurrentSaveStore struct {
+	store
+	saved bool
+}
+
+func (s *concurrentSaveStore) UpdateWithVersion(ctx context.Context, cmd *pref.Preference, expectedVersion int64) error {
+	if !s.saved {
+		s.saved = true
+		saved := *cmd
+		saved.Theme = "light"
+		saved.Version = cmd.Version + 1
+		if err := s.store.UpdateWithVersion(ctx, &saved, cmd.Version); err != nil {
+			return err
+		}
+	}
+	return s.store.UpdateWithVersion(ctx, cmd, expectedVersion)
+}
+
+func TestReEncryptPreferences_ConcurrentSave(t *testing.T) {
+	ctx := context.Background()
+	fake := newFake()
+	_, err := fake.Insert(ctx, &pref.Preference{OrgID: 1, UserID: 2, Theme: "dark", Created: time.Now()})
+	require.NoError(t, err)
+
+	prefService := &Service{store: &concurrentSaveStore{store: fake}}
+	require.NoError(t, prefService.ReEncryptPreferences(ctx, 1))
+
+	stored, err := fake.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+	require.NoError(t, err)
+	assert.Equal(t, "light", stored.Theme)
+	assert.Equal(t, int64(1), stored.Version)
+}
+
+func TestEncryptedStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("keeps the plaintext of written preferences", func(t *testing.T) {
+		s := newEncryptedStore(newFake(), fakes.NewFakeSecretsService(), []string{"locale"})
+		cmd := &pref.Preference{OrgID: 1, UserID: 2, JSONData: &pref.PreferenceJSONData{Locale: "de-DE"}, Created: time.Now()}
+		_, err := s.Insert(ctx, cmd)
+		require.NoError(t, err)
+		assert.Equal(t, "de-DE", cmd.JSONData.Locale)
+		assert.Nil(t, cmd.JSONData.Encrypted)
+
+		stored, err := s.store.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Empty(t, stored.JSONData.Locale)
+		assert.Contains(t, stored.JSONData.Encrypted, "locale")
+	})
+
+	t.Run("rejects fields that cannot be encrypted", func(t *testing.T) {
+		cfg := setting.NewCfg()
+		cfg.PreferencesEncryptedFields = []string{"theme"}
+		_, err := ProvideService(nil, cfg, featuremgmt.WithFeatures(), bus.ProvideBus(tracing.InitializeTracerForTest()), fakes.NewFakeSecretsService())
+		require.ErrorContains(t, err, `"theme" cannot be encrypted`)
+	})
+}
@@ -0,0 +1,77 @@
+package prefimpl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	pref "github.com/grafana/grafana/pkg/services/preference"
+)
+
+const (
+	// exportPageSize is the number of preferences read from the store at
+	// once, which bounds the memory used by an export.
+	exportPageSize = 500
+	// exportFlushInterval is the number of records written between flushes
+	// of the output.
+	exportFlushInterval = 100
+)
+
+// StreamExportPreferences writes the preferences of the org, then those of
+// its teams, then those of its users, each as a pref.ExportedPreference on its
+// own line. Preferences are read from the store a page at a time, and w is
+// flushed every exportFlushInterval records if it is an http.Flusher.
+func (s *Service) StreamExportPreferences(ctx context.Context, cmd *pref.ExportPreferencesCommand, w io.Writer, opts ...pref.ExportOption) error {
+	options := &pref.ExportOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	flush := func() error {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return nil
+	}
+
+	written := 0
+	for _, scope := range pref.PreferencesScopes {
+		if options.Filter != nil && !options.Filter(scope) {
+			continue
+		}
+
+		var afterID int64
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			page, err := s.store.ListForExport(ctx, cmd.OrgID, scope, afterID, exportPageSize)
+			if err != nil {
+				return err
+			}
+			for _, p := range page {
+				if err := enc.Encode(pref.NewExportedPreference(p)); err != nil {
+					return err
+				}
+				written++
+				if written%exportFlushInterval == 0 {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			}
+			if len(page) < exportPageSize {
+				break
+			}
+			afterID = page[len(page)-1].ID
+		}
+	}
+	return flush()
+}
@@ -0,0 +1,100 @@
+package prefimpl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	pref "github.com/grafana/grafana/pkg/services/preference"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// flushRecorder is a bytes.Buffer that counts the flushes of an http.Flusher.
+type flushRecorder struct {
+	bytes.Buffer
+	flushes int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushes++
+}
+
+func TestStreamExportPreferences(t *testing.T) {
+	ctx := context.Background()
+	fake := newFake()
+	prefService := &Service{store: fake, cfg: setting.NewCfg(), features: featuremgmt.WithFeatures()}
+
+	// users are inserted first, so that IDs are not ordered by scope
+	const users, teams = exportPageSize*2 + 37, 3
+	for i := int64(1); i <= users; i++ {
+		_, err := fake.Insert(ctx, &pref.Preference{OrgID: 1, UserID: i, Theme: "dark", Updated: time.Now()})
+		require.NoError(t, err)
+	}
+	for i := int64(1); i <= teams; i++ {
+		_, err := fake.Insert(ctx, &pref.Preference{OrgID: 1, TeamID: i, Timezone: "UTC", Updated: time.Now()})
+		require.NoError(t, err)
+	}
+	for _, p := range []*pref.Preference{{OrgID: 1, WeekStart: "monday"}, {OrgID: 2, UserID: 1}} {
+		_, err := fake.Insert(ctx, p)
+		require.NoError(t, err)
+	}
+
+	decode := func(t *testing.T, data []byte) []pref.ExportedPreference {
+		t.Helper()
+		var records []pref.ExportedPreference
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var p pref.ExportedPreference
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &p), scanner.Text())
+			records = append(records, p)
+		}
+		require.NoError(t, scanner.Err())
+		return records
+	}
+
+	t.Run("exports every preference of the org ordered by scope", func(t *testing.T) {
+		var out flushRecorder
+		require.NoError(t, prefService.StreamExportPreferences(ctx, &pref.ExportPreferencesCommand{OrgID: 1}, &out))
+
+		records := decode(t, out.Bytes())
+		require.Len(t, records, 1+teams+users)
+		assert.Equal(t, pref.ExportedPreference{Scope: pref.PreferencesScopeOrg, OrgID: 1, WeekStart: "monday"}, records[0])
+		for i, p := range records[1 : 1+teams] {
+			assert.Equal(t, pref.PreferencesScopeTeam, p.Scope)
+			assert.Equal(t, int64(i+1), p.TeamID)
+		}
+		for i, p := range records[1+teams:] {
+			assert.Equal(t, pref.PreferencesScopeUser, p.Scope)
+			assert.Equal(t, int64(i+1), p.UserID)
+			assert.Equal(t, int64(1), p.OrgID)
+		}
+
+		// every exportFlushInterval records, and once at the end
+		assert.Equal(t, len(records)/exportFlushInterval+1, out.flushes)
+	})
+
+	t.Run("filter selects the exported scopes", func(t *testing.T) {
+		var out bytes.Buffer
+		err := prefService.StreamExportPreferences(ctx, &pref.ExportPreferencesCommand{OrgID: 1}, &out,
+			pref.WithExportFilter(func(scope pref.PreferencesScope) bool { return scope != pref.PreferencesScopeUser }))
+		require.NoError(t, err)
+
+		records := decode(t, out.Bytes())
+		require.Len(t, records, 1+teams)
+		assert.Equal(t, pref.PreferencesScopeOrg, records[0].Scope)
+		assert.Equal(t, pref.PreferencesScopeTeam, records[teams].Scope)
+	})
+
+	t.Run("org without preferences exports nothing", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, prefService.StreamExportPreferences(ctx, &pref.ExportPreferencesCommand{OrgID: 3}, &out))
+		assert.Empty(t, out.Bytes())
+	})
+}
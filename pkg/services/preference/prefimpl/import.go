@@ -0,0 +1,139 @@
+package prefimpl
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+
+	pref "github.com/grafana/grafana/pkg/services/preference"
+)
+
+// importKeys maps the keys a preferences file can set to the setter of the
+// matching field of the save command.
+var importKeys = map[string]func(cmd *pref.SavePreferenceCommand, value string) error{
+	"homeDashboardId": func(cmd *pref.SavePreferenceCommand, value string) error {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("homeDashboardId must be a dashboard ID, got %q", value)
+		}
+		cmd.HomeDashboardID = id
+		return nil
+	},
+	"timezone": func(cmd *pref.SavePreferenceCommand, value string) error {
+		cmd.Timezone = value
+		return nil
+	},
+	"weekStart": func(cmd *pref.SavePreferenceCommand, value string) error {
+		cmd.WeekStart = value
+		return nil
+	},
+	"theme": func(cmd *pref.SavePreferenceCommand, value string) error {
+		if value != "" && value != "light" && value != "dark" {
+			return fmt.Errorf("theme must be light or dark, got %q", value)
+		}
+		cmd.Theme = value
+		return nil
+	},
+	"locale": func(cmd *pref.SavePreferenceCommand, value string) error {
+		cmd.Locale = value
+		return nil
+	},
+	"queryHistory.homeTab": func(cmd *pref.SavePreferenceCommand, value string) error {
+		cmd.QueryHistory = &pref.QueryHistoryPreference{HomeTab: value}
+		return nil
+	},
+}
+
+// ImportPreferencesFromFile parses the file of cmd and, unless it is a dry
+// run, saves the preferences it sets. Keys that are not preferences are
+// reported as warnings rather than failing the import, while invalid values of
+// preferences fail it with ErrInvalidImportFile.
+func (s *Service) ImportPreferencesFromFile(ctx context.Context, cmd *pref.ImportFromFileCommand) (*pref.ImportResult, error) {
+	values, err := parsePreferencesFile(cmd.Filename, cmd.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	save := &pref.SavePreferenceCommand{OrgID: cmd.OrgID, UserID: cmd.UserID, TeamID: cmd.TeamID}
+	result := &pref.ImportResult{Imported: []string{}, DryRun: cmd.DryRun}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		set, ok := importKeys[key]
+		if !ok {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("unknown key %q was ignored", key))
+			continue
+		}
+		value, ok := values[key].(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s must be a single value", pref.ErrInvalidImportFile, key)
+		}
+		if err := set(save, value); err != nil {
+			return nil, fmt.Errorf("%w: %s", pref.ErrInvalidImportFile, err)
+		}
+		result.Imported = append(result.Imported, key)
+	}
+
+	if cmd.DryRun {
+		return result, nil
+	}
+	if err := s.Save(ctx, save); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// parsePreferencesFile returns the values of a YAML or INI file by key, nested
+// keys being joined with dots. Values are strings, except for YAML lists.
+func parsePreferencesFile(filename string, data []byte) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("%w: %s", pref.ErrInvalidImportFile, err)
+		}
+		flattenYAML("", doc, values)
+	case ".ini":
+		file, err := ini.Load(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", pref.ErrInvalidImportFile, err)
+		}
+		for _, section := range file.Sections() {
+			prefix := ""
+			if section.Name() != ini.DefaultSection {
+				prefix = section.Name() + "."
+			}
+			for _, key := range section.Keys() {
+				values[prefix+key.Name()] = key.String()
+			}
+		}
+	default:
+		return nil, pref.ErrUnsupportedImportFormat
+	}
+	return values, nil
+}
+
+func flattenYAML(prefix string, doc map[string]interface{}, values map[string]interface{}) {
+	for key, value := range doc {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flattenYAML(prefix+key+".", v, values)
+		case []interface{}:
+			values[prefix+key] = v
+		case nil:
+			values[prefix+key] = ""
+		default:
+			values[prefix+key] = fmt.Sprint(v)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package prefimpl
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	pref "github.com/grafana/grafana/pkg/services/preference"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+func TestImportPreferencesFromFile(t *testing.T) {
+	newService := func() *Service {
+		return &Service{
+			store:    newFake(),
+			cfg:      setting.NewCfg(),
+			features: featuremgmt.WithFeatures(),
+		}
+	}
+	readFile := func(t *testing.T, name string) []byte {
+		t.Helper()
+		data, err := os.ReadFile("testdata/" + name)
+		require.NoError(t, err)
+		return data
+	}
+
+	t.Run("YAML file sets the org preferences", func(t *testing.T) {
+		prefService := newService()
+		result, err := prefService.ImportPreferencesFromFile(context.Background(), &pref.ImportFromFileCommand{
+			OrgID:    1,
+			Filename: "preferences.yaml",
+			Data:     readFile(t, "preferences.yaml"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"homeDashboardId", "queryHistory.homeTab", "theme", "timezone", "weekStart"}, result.Imported)
+		assert.Equal(t, []string{`unknown key "colour" was ignored`, `unknown key "navbar.savedItems" was ignored`}, result.Warnings)
+		assert.False(t, result.DryRun)
+
+		stored, err := prefService.Get(context.Background(), &pref.GetPreferenceQuery{OrgID: 1})
+		require.NoError(t, err)
+		assert.Equal(t, "dark", stored.Theme)
+		assert.Equal(t, "Europe/Rome", stored.Timezone)
+		assert.Equal(t, "monday", stored.WeekStart)
+		assert.Equal(t, int64(12), stored.HomeDashboardID)
+		assert.Equal(t, "starred", stored.JSONData.QueryHistory.HomeTab)
+	})
+
+	t.Run("INI file sets the team preferences", func(t *testing.T) {
+		prefService := newService()
+		result, err := prefService.ImportPreferencesFromFile(context.Background(), &pref.ImportFromFileCommand{
+			OrgID:    1,
+			TeamID:   2,
+			Filename: "preferences.ini",
+			Data:     readFile(t, "preferences.ini"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"locale", "queryHistory.homeTab", "theme", "timezone"}, result.Imported)
+		assert.Equal(t, []string{`unknown key "unknown.key" was ignored`}, result.Warnings)
+
+		stored, err := prefService.Get(context.Background(), &pref.GetPreferenceQuery{OrgID: 1, TeamID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, "light", stored.Theme)
+		assert.Equal(t, "fr-FR", stored.JSONData.Locale)
+		assert.Equal(t, "query", stored.JSONData.QueryHistory.HomeTab)
+	})
+
+	t.Run("dry run does not save", func(t *testing.T) {
+		prefService := newService()
+		result, err := prefService.ImportPreferencesFromFile(context.Background(), &pref.ImportFromFileCommand{
+			OrgID:    1,
+			Filename: "preferences.yml",
+			Data:     readFile(t, "preferences.yaml"),
+			DryRun:   true,
+		})
+		require.NoError(t, err)
+		assert.True(t, result.DryRun)
+		assert.Len(t, result.Warnings, 2)
+
+		_, err = prefService.store.Get(context.Background(), &pref.Preference{OrgID: 1})
+		assert.ErrorIs(t, err, pref.ErrPrefNotFound)
+	})
+
+	for _, tc := range []struct {
+		desc     string
+		filename string
+		data     string
+		err      error
+	}{
+		{desc: "unsupported extension", filename: "preferences.json", data: `{"theme": "dark"}`, err: pref.ErrUnsupportedImportFormat},
+		{desc: "invalid YAML", filename: "preferences.yaml", data: "theme: [dark", err: pref.ErrInvalidImportFile},
+		{desc: "invalid theme", filename: "preferences.yaml", data: "theme: purple", err: pref.ErrInvalidImportFile},
+		{desc: "invalid home dashboard", filename: "preferences.ini", data: "homeDashboardId = home", err: pref.ErrInvalidImportFile},
+		{desc: "list for a preference", filename: "preferences.yaml", data: "timezone: [utc]", err: pref.ErrInvalidImportFile},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := newService().ImportPreferencesFromFile(context.Background(), &pref.ImportFromFileCommand{
+				OrgID:    1,
+				Filename: tc.filename,
+				Data:     []byte(tc.data),
+			})
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
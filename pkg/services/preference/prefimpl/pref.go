@@ -2,12 +2,23 @@ package prefimpl
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/go-redis/redis/v8"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/infra/db"
+	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/services/featuremgmt"
 	pref "github.com/grafana/grafana/pkg/services/preference"
+	prefstore "github.com/grafana/grafana/pkg/services/preference/store"
+	prefredis "github.com/grafana/grafana/pkg/services/preference/store/redis"
+	"github.com/grafana/grafana/pkg/services/secrets"
 	"github.com/grafana/grafana/pkg/setting"
 )
 
@@ -15,14 +26,22 @@ type Service struct {
 	store    store
 	cfg      *setting.Cfg
 	features *featuremgmt.FeatureManager
+	log      log.Logger
 }
 
-func ProvideService(db db.DB, cfg *setting.Cfg, features *featuremgmt.FeatureManager) pref.Service {
+func ProvideService(db db.DB, cfg *setting.Cfg, features *featuremgmt.FeatureManager, bus bus.Bus, secretsService secrets.Service) (pref.Service, error) {
 	service := &Service{
 		cfg:      cfg,
 		features: features,
+		log:      log.New("preferences"),
 	}
-	if features.IsEnabled(featuremgmt.FlagNewDBLibrary) {
+	if cfg.PreferencesBackend == "redis" {
+		opts, err := redis.ParseURL(cfg.PreferencesRedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid preferences_redis_url: %w", err)
+		}
+		service.store = prefredis.NewRedisPreferencesStore(redis.NewClient(opts))
+	} else if features.IsEnabled(featuremgmt.FlagNewDBLibrary) {
 		service.store = &sqlxStore{
 			sess: db.GetSqlxSession(),
 		}
@@ -31,7 +50,24 @@ func ProvideService(db db.DB, cfg *setting.Cfg, features *featuremgmt.FeatureMan
 			db: db,
 		}
 	}
-	return service
+
+	for _, field := range cfg.PreferencesEncryptedFields {
+		if !isEncryptableField(field) {
+			return nil, fmt.Errorf("invalid preferences_encrypted_fields: %q cannot be encrypted, expected one of %s", field, strings.Join(pref.EncryptableJSONDataFields, ", "))
+		}
+	}
+	// preferences can have encrypted fields even if none are configured to be
+	service.store = newEncryptedStore(service.store, secretsService, cfg.PreferencesEncryptedFields)
+
+	if cfg.PreferencesCacheTTL > 0 {
+		service.store = prefstore.NewTieredPreferencesStore(prefstore.NewInMemStore(cfg.PreferencesCacheTTL), service.store)
+	}
+
+	bus.AddEventListener(service.handleUserDeleted)
+	bus.AddEventListener(service.handleOrgDeleted)
+	bus.AddEventListener(service.handleTeamDeleted)
+
+	return service, nil
 }
 
 func (s *Service) GetWithDefaults(ctx context.Context, query *pref.GetPreferenceWithDefaultsQuery) (*pref.Preference, error) {
@@ -46,7 +82,22 @@ func (s *Service) GetWithDefaults(ctx context.Context, query *pref.GetPreference
 		return nil, err
 	}
 
+	orgTheme, err := s.store.GetOrgThemeConfig(ctx, query.OrgID)
+	if errors.Is(err, pref.ErrOrgThemeConfigNotFound) {
+		orgTheme = &pref.OrgThemeConfig{}
+	} else if err != nil {
+		return nil, err
+	}
+
 	res := s.GetDefaults()
+	if s.features.IsEnabled(featuremgmt.FlagAbTestTheme) {
+		if err := s.applyExperiments(ctx, res, query.UserID); err != nil {
+			return nil, err
+		}
+	}
+	if orgTheme.Policy == pref.OrgThemePolicySuggest {
+		res.Theme = orgTheme.Theme
+	}
 	for _, p := range prefs {
 		if p.Theme != "" {
 			res.Theme = p.Theme
@@ -72,10 +123,41 @@ func (s *Service) GetWithDefaults(ctx context.Context, query *pref.GetPreference
 			if p.JSONData.QueryHistory.HomeTab != "" {
 				res.JSONData.QueryHistory.HomeTab = p.JSONData.QueryHistory.HomeTab
 			}
+
+			for dashboardUID, panels := range p.JSONData.PanelState {
+				if res.JSONData.PanelState == nil {
+					res.JSONData.PanelState = pref.PanelState{}
+				}
+				res.JSONData.PanelState.Set(dashboardUID, panels)
+			}
 		}
 	}
 
-	return res, err
+	if orgTheme.Policy == pref.OrgThemePolicyEnforce {
+		res.Theme = orgTheme.Theme
+	}
+
+	formats, _ := pref.LocaleFormats.Lookup(res.JSONData.Locale)
+	res.DefaultDateFormat = formats.DateFormat
+	res.DefaultTimeFormat = formats.TimeFormat
+
+	return res, nil
+}
+
+// applyExperiments replaces the default theme in defaults with the value of
+// the first active experiment having the user in its experiment arm.
+func (s *Service) applyExperiments(ctx context.Context, defaults *pref.Preference, userID int64) error {
+	experiments, err := s.store.ListActiveExperiments(ctx)
+	if err != nil {
+		return err
+	}
+	for _, e := range experiments {
+		if e.InVariant(userID) {
+			defaults.Theme = e.Value
+			return nil
+		}
+	}
+	return nil
 }
 
 func (s *Service) Get(ctx context.Context, query *pref.GetPreferenceQuery) (*pref.Preference, error) {
@@ -95,13 +177,17 @@ func (s *Service) Get(ctx context.Context, query *pref.GetPreferenceQuery) (*pre
 }
 
 func (s *Service) Save(ctx context.Context, cmd *pref.SavePreferenceCommand) error {
-	preference, err := s.store.Get(ctx, &pref.Preference{
+	// the update is based on the stored preference, not on a cached copy
+	preference, err := s.store.Get(prefstore.SkipFastTier(ctx), &pref.Preference{
 		OrgID:  cmd.OrgID,
 		UserID: cmd.UserID,
 		TeamID: cmd.TeamID,
 	})
 	if err != nil {
 		if errors.Is(err, pref.ErrPrefNotFound) {
+			if cmd.ExpectedVersion != nil && *cmd.ExpectedVersion != 0 {
+				return pref.ErrPreferenceConflict
+			}
 			preference := &pref.Preference{
 				UserID:          cmd.UserID,
 				OrgID:           cmd.OrgID,
@@ -116,10 +202,17 @@ func (s *Service) Save(ctx context.Context, cmd *pref.SavePreferenceCommand) err
 					Locale: cmd.Locale,
 				},
 			}
+			if cmd.Navbar != nil {
+				preference.JSONData.Navbar = *cmd.Navbar
+			}
+			if cmd.QueryHistory != nil {
+				preference.JSONData.QueryHistory = *cmd.QueryHistory
+			}
 			_, err = s.store.Insert(ctx, preference)
 			if err != nil {
 				return err
 			}
+			return s.recordHistory(ctx, preference)
 		}
 		return err
 	}
@@ -130,8 +223,13 @@ func (s *Service) Save(ctx context.Context, cmd *pref.SavePreferenceCommand) err
 	preference.Updated = time.Now()
 	preference.Version += 1
 	preference.HomeDashboardID = cmd.HomeDashboardID
+	var panelState pref.PanelState
+	if preference.JSONData != nil {
+		panelState = preference.JSONData.PanelState
+	}
 	preference.JSONData = &pref.PreferenceJSONData{
-		Locale: cmd.Locale,
+		Locale:     cmd.Locale,
+		PanelState: panelState,
 	}
 
 	if cmd.Navbar != nil {
@@ -140,12 +238,99 @@ func (s *Service) Save(ctx context.Context, cmd *pref.SavePreferenceCommand) err
 	if cmd.QueryHistory != nil {
 		preference.JSONData.QueryHistory = *cmd.QueryHistory
 	}
-	return s.store.Update(ctx, preference)
+
+	// a save based on a version only applies to that version, others apply
+	// to whatever version is stored
+	if cmd.ExpectedVersion != nil {
+		preference.Version = *cmd.ExpectedVersion + 1
+		err = s.store.UpdateWithVersion(ctx, preference, *cmd.ExpectedVersion)
+	} else {
+		err = s.store.Update(ctx, preference)
+	}
+	if err != nil {
+		return err
+	}
+	return s.recordHistory(ctx, preference)
+}
+
+func (s *Service) GetPreferencesHistory(ctx context.Context, query *pref.PreferencesHistoryQuery) ([]*pref.Preference, error) {
+	history, err := s.store.ListHistory(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	prefs := make([]*pref.Preference, 0, len(history))
+	for _, h := range history {
+		prefs = append(prefs, h.Preference())
+	}
+	return prefs, nil
+}
+
+// RollbackPreferences copies the saved version cmd.Version back to the
+// preference. The result is saved as a new version, so that the rollback can
+// itself be rolled back.
+func (s *Service) RollbackPreferences(ctx context.Context, cmd *pref.RollbackPreferencesCommand) error {
+	preference, err := s.store.Get(prefstore.SkipFastTier(ctx), &pref.Preference{
+		OrgID:  cmd.OrgID,
+		UserID: cmd.UserID,
+		TeamID: cmd.TeamID,
+	})
+	if err != nil {
+		return err
+	}
+
+	history, err := s.store.ListHistory(ctx, &pref.PreferencesHistoryQuery{
+		OrgID:  cmd.OrgID,
+		UserID: cmd.UserID,
+		TeamID: cmd.TeamID,
+	})
+	if err != nil {
+		return err
+	}
+
+	var target *pref.PreferenceHistory
+	for _, h := range history {
+		if h.Version == cmd.Version {
+			target = h
+			break
+		}
+	}
+	if target == nil {
+		return pref.ErrPreferenceVersionNotFound
+	}
+
+	currentVersion := preference.Version
+	preference.HomeDashboardID = target.HomeDashboardID
+	preference.Timezone = target.Timezone
+	preference.WeekStart = target.WeekStart
+	preference.Theme = target.Theme
+	var panelState pref.PanelState
+	if preference.JSONData != nil {
+		panelState = preference.JSONData.PanelState
+	}
+	preference.JSONData = target.JSONData
+	if panelState != nil {
+		if preference.JSONData == nil {
+			preference.JSONData = &pref.PreferenceJSONData{}
+		}
+		preference.JSONData.PanelState = panelState
+	}
+	preference.Updated = time.Now()
+	preference.Version = currentVersion + 1
+
+	if err := s.store.UpdateWithVersion(ctx, preference, currentVersion); err != nil {
+		return err
+	}
+	return s.recordHistory(ctx, preference)
+}
+
+func (s *Service) recordHistory(ctx context.Context, preference *pref.Preference) error {
+	return s.store.InsertHistory(ctx, pref.NewPreferenceHistory(preference), s.cfg.PreferencesHistoryDepth)
 }
 
 func (s *Service) Patch(ctx context.Context, cmd *pref.PatchPreferenceCommand) error {
 	var exists bool
-	preference, err := s.store.Get(ctx, &pref.Preference{
+	preference, err := s.store.Get(prefstore.SkipFastTier(ctx), &pref.Preference{
 		OrgID:  cmd.OrgID,
 		UserID: cmd.UserID,
 		TeamID: cmd.TeamID,
@@ -228,6 +413,84 @@ func (s *Service) Patch(ctx context.Context, cmd *pref.PatchPreferenceCommand) e
 	return err
 }
 
+// SavePanelState merges the collapsed state of the panels in cmd into the
+// user's preference. It does not record a history version, as panel state is
+// not a preference set by the user.
+func (s *Service) SavePanelState(ctx context.Context, cmd *pref.SavePanelStateCommand) error {
+	if cmd.DashboardUID == "" {
+		return pref.ErrDashboardUIDRequired
+	}
+
+	exists := true
+	preference, err := s.store.Get(prefstore.SkipFastTier(ctx), &pref.Preference{
+		OrgID:  cmd.OrgID,
+		UserID: cmd.UserID,
+	})
+	if errors.Is(err, pref.ErrPrefNotFound) {
+		exists = false
+		preference = &pref.Preference{
+			UserID:  cmd.UserID,
+			OrgID:   cmd.OrgID,
+			Created: time.Now(),
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if preference.JSONData == nil {
+		preference.JSONData = &pref.PreferenceJSONData{}
+	}
+	if preference.JSONData.PanelState == nil {
+		preference.JSONData.PanelState = pref.PanelState{}
+	}
+	preference.JSONData.PanelState.Set(cmd.DashboardUID, cmd.Panels)
+	preference.Updated = time.Now()
+	preference.Version += 1
+
+	if exists {
+		return s.store.Update(ctx, preference)
+	}
+	_, err = s.store.Insert(ctx, preference)
+	return err
+}
+
+func (s *Service) GetPanelState(ctx context.Context, query *pref.GetPanelStateQuery) (map[string]bool, error) {
+	if query.DashboardUID == "" {
+		return nil, pref.ErrDashboardUIDRequired
+	}
+
+	preference, err := s.store.Get(ctx, &pref.Preference{
+		OrgID:  query.OrgID,
+		UserID: query.UserID,
+	})
+	if errors.Is(err, pref.ErrPrefNotFound) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]bool{}
+	if preference.JSONData != nil {
+		for panelID, collapsed := range preference.JSONData.PanelState[query.DashboardUID] {
+			res[panelID] = collapsed
+		}
+	}
+	return res, nil
+}
+
+func (s *Service) SetOrgThemePolicy(ctx context.Context, orgID int64, policy, theme string) error {
+	if err := pref.ValidateOrgThemePolicy(policy, theme); err != nil {
+		return err
+	}
+	return s.store.SaveOrgThemeConfig(ctx, &pref.OrgThemeConfig{
+		OrgID:   orgID,
+		Policy:  policy,
+		Theme:   theme,
+		Updated: time.Now(),
+	})
+}
+
 func (s *Service) GetDefaults() *pref.Preference {
 	defaults := &pref.Preference{
 		Theme:           s.cfg.DefaultTheme,
@@ -245,5 +508,183 @@ func (s *Service) GetDefaults() *pref.Preference {
 }
 
 func (s *Service) DeleteByUser(ctx context.Context, userID int64) error {
-	return s.store.DeleteByUser(ctx, userID)
+	return s.store.DeletePreferencesForUser(ctx, userID)
+}
+
+func (s *Service) handleUserDeleted(ctx context.Context, e *events.UserDeleted) error {
+	return s.store.DeletePreferencesForUser(ctx, e.Id)
+}
+
+// handleOrgDeleted deletes the preferences of the org and of its users and
+// teams. A failure is logged rather than returned so that the other listeners
+// of the event still run.
+func (s *Service) handleOrgDeleted(ctx context.Context, e *events.OrgDeleted) error {
+	deleted, err := s.store.DeletePreferencesForOrg(ctx, e.Id)
+	if err != nil {
+		s.log.Error("Failed to delete preferences of deleted org", "orgId", e.Id, "error", err)
+		return nil
+	}
+	e.PreferencesDeletedForOrg = deleted
+	return nil
+}
+
+func (s *Service) handleTeamDeleted(ctx context.Context, e *events.TeamDeleted) error {
+	return s.store.DeletePreferencesForTeam(ctx, e.Id)
+}
+
+func (s *Service) GetPluginPreferences(ctx context.Context, query *pref.GetPluginPreferencesQuery) (map[string]json.RawMessage, error) {
+	if err := pref.ValidatePluginID(query.PluginID); err != nil {
+		return nil, err
+	}
+
+	prefs, err := s.store.GetPluginPreferences(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]json.RawMessage, len(prefs))
+	for _, p := range prefs {
+		res[p.Key] = json.RawMessage(p.ValueJSON)
+	}
+	return res, nil
+}
+
+func (s *Service) SavePluginPreferences(ctx context.Context, cmd *pref.SavePluginPreferencesCommand) error {
+	if err := pref.ValidatePluginID(cmd.PluginID); err != nil {
+		return err
+	}
+
+	prefs := make([]*pref.PluginPreference, 0, len(cmd.Preferences))
+	for key, value := range cmd.Preferences {
+		if !json.Valid(value) {
+			return pref.ErrInvalidPluginPreferenceValue
+		}
+		prefs = append(prefs, &pref.PluginPreference{
+			OrgID:     cmd.OrgID,
+			UserID:    cmd.UserID,
+			PluginID:  cmd.PluginID,
+			Key:       key,
+			ValueJSON: string(value),
+		})
+	}
+	return s.store.SavePluginPreferences(ctx, prefs)
+}
+
+func (s *Service) DeletePluginPreferences(ctx context.Context, pluginID string) error {
+	if err := pref.ValidatePluginID(pluginID); err != nil {
+		return err
+	}
+	return s.store.DeletePluginPreferences(ctx, pluginID)
+}
+
+func (s *Service) CreatePreferencesPreset(ctx context.Context, cmd *pref.CreatePresetCommand) (*pref.Preset, error) {
+	name := strings.TrimSpace(cmd.Name)
+	if name == "" {
+		return nil, pref.ErrPresetNameRequired
+	}
+
+	values := cmd.Preferences
+	preset := &pref.Preset{
+		Name:        name,
+		Preferences: &values,
+		Created:     time.Now(),
+	}
+	if err := s.store.InsertPreset(ctx, preset); err != nil {
+		return nil, err
+	}
+	return preset, nil
+}
+
+func (s *Service) GetPreferencesPreset(ctx context.Context, presetID int64) (*pref.Preset, error) {
+	return s.store.GetPreset(ctx, presetID)
+}
+
+func (s *Service) ListPreferencesPresets(ctx context.Context) ([]*pref.Preset, error) {
+	return s.store.ListPresets(ctx)
+}
+
+func (s *Service) DeletePreferencesPreset(ctx context.Context, presetID int64) error {
+	return s.store.DeletePreset(ctx, presetID)
+}
+
+// ApplyPreferencesPreset saves the values of the preset as the org preferences
+// of cmd.OrgID. Values the preset leaves empty are cleared, so that the org
+// ends up with exactly the preferences of the preset, except for the home
+// dashboard of the org which is kept. The previous org preferences are kept in
+// the preferences history.
+func (s *Service) ApplyPreferencesPreset(ctx context.Context, cmd *pref.ApplyPresetCommand) error {
+	preset, err := s.store.GetPreset(ctx, cmd.PresetID)
+	if err != nil {
+		return err
+	}
+	current, err := s.Get(ctx, &pref.GetPreferenceQuery{OrgID: cmd.OrgID})
+	if err != nil {
+		return err
+	}
+
+	values := preset.Preferences
+	if values == nil {
+		values = &pref.PresetPreferences{}
+	}
+	return s.Save(ctx, &pref.SavePreferenceCommand{
+		OrgID:           cmd.OrgID,
+		HomeDashboardID: current.HomeDashboardID,
+		Timezone:        values.Timezone,
+		WeekStart:       values.WeekStart,
+		Theme:           values.Theme,
+		Locale:          values.Locale,
+		Navbar:          values.Navbar,
+		QueryHistory:    values.QueryHistory,
+	})
+}
+
+// defaultBulkBatchSize is the number of preferences updated per statement by
+// BulkSetPreferences unless configured otherwise.
+const defaultBulkBatchSize = 1000
+
+// BulkSetPreferences validates cmd and applies it in a single transaction.
+// The changes are not recorded in the preferences history.
+func (s *Service) BulkSetPreferences(ctx context.Context, cmd *pref.BulkSetPreferencesCommand) (*pref.BulkSetResult, error) {
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+
+	batchSize := s.cfg.PreferencesBulkBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBulkBatchSize
+	}
+	updated, err := s.store.BulkUpdate(ctx, cmd, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	s.log.Info("Bulk set preferences", "orgId", cmd.OrgID, "fields", cmd.Mask, "scope", cmd.ScopeFilter, "updated", updated)
+	return &pref.BulkSetResult{Updated: updated}, nil
+}
+
+func (s *Service) CreatePreferencesExperiment(ctx context.Context, cmd *pref.CreateExperimentCommand) (*pref.Experiment, error) {
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	experiment := &pref.Experiment{
+		Name:       strings.TrimSpace(cmd.Name),
+		VariantKey: cmd.VariantKey,
+		Value:      cmd.Value,
+		Percentage: cmd.Percentage,
+		Active:     true,
+		Created:    now,
+		Updated:    now,
+	}
+	if err := s.store.InsertExperiment(ctx, experiment); err != nil {
+		return nil, err
+	}
+	return experiment, nil
+}
+
+// TerminateExperiment deactivates the experiment, after which all users get
+// the default theme again. The experiment is kept, so its name cannot be
+// reused.
+func (s *Service) TerminateExperiment(ctx context.Context, name string) error {
+	return s.store.DeactivateExperiment(ctx, name, time.Now())
 }
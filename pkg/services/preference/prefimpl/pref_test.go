@@ -2,14 +2,26 @@ package prefimpl
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-
+	"golang.org/x/sync/errgroup"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/infra/db"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/tracing"
+	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/featuremgmt"
 	pref "github.com/grafana/grafana/pkg/services/preference"
+	prefstore "github.com/grafana/grafana/pkg/services/preference/store"
+	"github.com/grafana/grafana/pkg/services/secrets/fakes"
+	"github.com/grafana/grafana/pkg/services/user"
 	"github.com/grafana/grafana/pkg/setting"
 )
 
@@ -55,10 +67,12 @@ func TestGetDefaults(t *testing.T) {
 		preference, err := prefService.GetWithDefaults(context.Background(), query)
 		require.NoError(t, err)
 		expected := &pref.Preference{
-			Theme:           "light",
-			Timezone:        "UTC",
-			HomeDashboardID: 0,
-			JSONData:        &pref.PreferenceJSONData{},
+			Theme:             "light",
+			Timezone:          "UTC",
+			HomeDashboardID:   0,
+			JSONData:          &pref.PreferenceJSONData{},
+			DefaultDateFormat: "MM/DD/YYYY",
+			DefaultTimeFormat: "h:mm:ss A",
 		}
 		if diff := cmp.Diff(expected, preference); diff != "" {
 			t.Fatalf("Result mismatch (-want +got):\n%s", diff)
@@ -136,6 +150,8 @@ func TestGetWithDefaults_withUserAndOrgPrefs(t *testing.T) {
 			JSONData: &pref.PreferenceJSONData{
 				Locale: "en-AU",
 			},
+			DefaultDateFormat: "DD/MM/YYYY",
+			DefaultTimeFormat: "HH:mm:ss",
 		}
 		if diff := cmp.Diff(expected, preference); diff != "" {
 			t.Fatalf("Result mismatch (-want +got):\n%s", diff)
@@ -155,6 +171,8 @@ func TestGetWithDefaults_withUserAndOrgPrefs(t *testing.T) {
 			JSONData: &pref.PreferenceJSONData{
 				Locale: "en-GB",
 			},
+			DefaultDateFormat: "DD/MM/YYYY",
+			DefaultTimeFormat: "HH:mm:ss",
 		}
 		if diff := cmp.Diff(expected, preference); diff != "" {
 			t.Fatalf("Result mismatch (-want +got):\n%s", diff)
@@ -235,7 +253,9 @@ func TestGetDefaults_JSONData(t *testing.T) {
 		preference, err := prefService.GetWithDefaults(context.Background(), query)
 		require.NoError(t, err)
 		require.Equal(t, &pref.Preference{
-			JSONData: &userPreferencesJsonData,
+			JSONData:          &userPreferencesJsonData,
+			DefaultDateFormat: "MM/DD/YYYY",
+			DefaultTimeFormat: "h:mm:ss A",
 		}, preference)
 	})
 
@@ -267,6 +287,8 @@ func TestGetDefaults_JSONData(t *testing.T) {
 				Navbar:       userNavbarPreferences,
 				QueryHistory: queryPreference,
 			},
+			DefaultDateFormat: "DD/MM/YYYY",
+			DefaultTimeFormat: "HH:mm:ss",
 		}, preference)
 	})
 
@@ -300,7 +322,9 @@ func TestGetDefaults_JSONData(t *testing.T) {
 		preference, err := prefService.GetWithDefaults(context.Background(), query)
 		require.NoError(t, err)
 		require.Equal(t, &pref.Preference{
-			JSONData: &team2PreferencesJsonData,
+			JSONData:          &team2PreferencesJsonData,
+			DefaultDateFormat: "MM/DD/YYYY",
+			DefaultTimeFormat: "h:mm:ss A",
 		}, preference)
 	})
 }
@@ -346,12 +370,37 @@ func TestGetWithDefaults_teams(t *testing.T) {
 		WeekStart:       "2",
 		HomeDashboardID: 4,
 		JSONData:        &pref.PreferenceJSONData{},
+
+		DefaultDateFormat: "MM/DD/YYYY",
+		DefaultTimeFormat: "h:mm:ss A",
 	}
 	if diff := cmp.Diff(expected, preferences); diff != "" {
 		t.Fatalf("Result mismatch (-want +got):\n%s", diff)
 	}
 }
 
+func TestGetWithDefaults_localeFormats(t *testing.T) {
+	prefService := &Service{
+		store:    newFake(),
+		cfg:      setting.NewCfg(),
+		features: featuremgmt.WithFeatures(),
+	}
+	insertPrefs(t, prefService.store,
+		pref.Preference{OrgID: 1, UserID: 1, JSONData: &pref.PreferenceJSONData{Locale: "fr-FR"}},
+		pref.Preference{OrgID: 1, UserID: 2, JSONData: &pref.PreferenceJSONData{Locale: "en-US"}},
+	)
+
+	fr, err := prefService.GetWithDefaults(context.Background(), &pref.GetPreferenceWithDefaultsQuery{OrgID: 1, UserID: 1})
+	require.NoError(t, err)
+	us, err := prefService.GetWithDefaults(context.Background(), &pref.GetPreferenceWithDefaultsQuery{OrgID: 1, UserID: 2})
+	require.NoError(t, err)
+
+	assert.Equal(t, "DD/MM/YYYY", fr.DefaultDateFormat)
+	assert.Equal(t, "HH:mm:ss", fr.DefaultTimeFormat)
+	assert.Equal(t, "MM/DD/YYYY", us.DefaultDateFormat)
+	assert.NotEqual(t, fr.DefaultDateFormat, us.DefaultDateFormat)
+}
+
 func TestPatch_toCreate(t *testing.T) {
 	prefService := &Service{
 		store:    newFake(),
@@ -367,7 +416,8 @@ func TestPatch_toCreate(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	stored := prefService.store.(*inmemStore).preference[preferenceKey{OrgID: 1, UserID: 2}]
+	stored, err := prefService.store.Get(context.Background(), &pref.Preference{OrgID: 1, UserID: 2})
+	require.NoError(t, err)
 	assert.EqualValues(t, 1, stored.OrgID)
 	assert.EqualValues(t, 2, stored.UserID)
 	assert.Equal(t, "light", stored.Theme)
@@ -392,7 +442,8 @@ func TestSave(t *testing.T) {
 		)
 		require.NoError(t, err)
 
-		stored := prefService.store.(*inmemStore).preference[preferenceKey{OrgID: 1}]
+		stored, err := prefService.store.Get(context.Background(), &pref.Preference{OrgID: 1})
+		require.NoError(t, err)
 		assert.EqualValues(t, 1, stored.OrgID)
 		assert.Zero(t, stored.UserID)
 		assert.Zero(t, stored.TeamID)
@@ -414,7 +465,8 @@ func TestSave(t *testing.T) {
 		)
 		require.NoError(t, err)
 
-		stored := prefService.store.(*inmemStore).preference[preferenceKey{OrgID: 1}]
+		stored, err := prefService.store.Get(context.Background(), &pref.Preference{OrgID: 1})
+		require.NoError(t, err)
 		assert.EqualValues(t, 1, stored.OrgID)
 		assert.Zero(t, stored.UserID)
 		assert.Zero(t, stored.TeamID)
@@ -433,7 +485,8 @@ func TestSave(t *testing.T) {
 		})
 		require.NoError(t, err)
 
-		stored := prefService.store.(*inmemStore).preference[preferenceKey{OrgID: 1}]
+		stored, err := prefService.store.Get(context.Background(), &pref.Preference{OrgID: 1})
+		require.NoError(t, err)
 		assert.EqualValues(t, 1, stored.OrgID)
 		assert.Zero(t, stored.UserID)
 		assert.Zero(t, stored.TeamID)
@@ -443,6 +496,165 @@ func TestSave(t *testing.T) {
 		assert.Equal(t, "1", stored.WeekStart)
 		assert.EqualValues(t, 2, stored.Version)
 	})
+
+	t.Run("update with the expected version", func(t *testing.T) {
+		version := int64(2)
+		err := prefService.Save(context.Background(), &pref.SavePreferenceCommand{OrgID: 1, Theme: "dark", ExpectedVersion: &version})
+		require.NoError(t, err)
+
+		stored, err := prefService.store.Get(context.Background(), &pref.Preference{OrgID: 1})
+		require.NoError(t, err)
+		assert.Equal(t, "dark", stored.Theme)
+		assert.EqualValues(t, 3, stored.Version)
+	})
+
+	t.Run("update with a stale expected version", func(t *testing.T) {
+		version := int64(1)
+		err := prefService.Save(context.Background(), &pref.SavePreferenceCommand{OrgID: 1, Theme: "light", ExpectedVersion: &version})
+		require.ErrorIs(t, err, pref.ErrPreferenceConflict)
+
+		stored, err := prefService.store.Get(context.Background(), &pref.Preference{OrgID: 1})
+		require.NoError(t, err)
+		assert.Equal(t, "dark", stored.Theme)
+		assert.EqualValues(t, 3, stored.Version)
+	})
+
+	t.Run("concurrent updates without a version all apply", func(t *testing.T) {
+		var g errgroup.Group
+		for _, theme := range []string{"light", "dark", "light", "dark", "light", "dark"} {
+			theme := theme
+			g.Go(func() error {
+				return prefService.Save(context.Background(), &pref.SavePreferenceCommand{OrgID: 1, Theme: theme})
+			})
+		}
+		require.NoError(t, g.Wait())
+
+		stored, err := prefService.store.Get(context.Background(), &pref.Preference{OrgID: 1})
+		require.NoError(t, err)
+		assert.Greater(t, stored.Version, int64(3))
+	})
+}
+
+func TestPreferencesHistory(t *testing.T) {
+	prefService := &Service{
+		store:    newFake(),
+		cfg:      setting.NewCfg(),
+		features: featuremgmt.WithFeatures(),
+	}
+	prefService.cfg.PreferencesHistoryDepth = 3
+
+	for _, dashboardID := range []int64{1, 2, 3, 4} {
+		err := prefService.Save(context.Background(), &pref.SavePreferenceCommand{OrgID: 1, UserID: 2, HomeDashboardID: dashboardID})
+		require.NoError(t, err)
+	}
+
+	query := &pref.PreferencesHistoryQuery{OrgID: 1, UserID: 2}
+	history, err := prefService.GetPreferencesHistory(context.Background(), query)
+	require.NoError(t, err)
+	require.Len(t, history, 3, "oldest versions are pruned")
+	assert.EqualValues(t, 3, history[0].Version)
+	assert.EqualValues(t, 4, history[0].HomeDashboardID)
+	assert.EqualValues(t, 1, history[2].Version)
+
+	t.Run("rollback restores a saved version as a new version", func(t *testing.T) {
+		err := prefService.RollbackPreferences(context.Background(), &pref.RollbackPreferencesCommand{OrgID: 1, UserID: 2, Version: 1})
+		require.NoError(t, err)
+
+		stored, err := prefService.Get(context.Background(), &pref.GetPreferenceQuery{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, stored.HomeDashboardID)
+		assert.EqualValues(t, 4, stored.Version)
+
+		history, err := prefService.GetPreferencesHistory(context.Background(), query)
+		require.NoError(t, err)
+		require.Len(t, history, 3)
+		assert.EqualValues(t, 4, history[0].Version)
+	})
+
+	t.Run("rollback to a pruned version", func(t *testing.T) {
+		err := prefService.RollbackPreferences(context.Background(), &pref.RollbackPreferencesCommand{OrgID: 1, UserID: 2, Version: 0})
+		require.ErrorIs(t, err, pref.ErrPreferenceVersionNotFound)
+	})
+
+	t.Run("rollback without preferences", func(t *testing.T) {
+		err := prefService.RollbackPreferences(context.Background(), &pref.RollbackPreferencesCommand{OrgID: 1, UserID: 3, Version: 0})
+		require.ErrorIs(t, err, pref.ErrPrefNotFound)
+	})
+}
+
+func TestPreferencesPresets(t *testing.T) {
+	prefService := &Service{
+		store:    newFake(),
+		cfg:      setting.NewCfg(),
+		features: featuremgmt.WithFeatures(),
+	}
+	ctx := context.Background()
+
+	t.Run("presets need a name", func(t *testing.T) {
+		_, err := prefService.CreatePreferencesPreset(ctx, &pref.CreatePresetCommand{Name: " "})
+		require.ErrorIs(t, err, pref.ErrPresetNameRequired)
+	})
+
+	first, err := prefService.CreatePreferencesPreset(ctx, &pref.CreatePresetCommand{
+		Name:        "corporate",
+		Preferences: pref.PresetPreferences{Theme: "light", Timezone: "UTC"},
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, first.Version)
+
+	second, err := prefService.CreatePreferencesPreset(ctx, &pref.CreatePresetCommand{
+		Name: "corporate",
+		Preferences: pref.PresetPreferences{
+			Theme:     "dark",
+			WeekStart: "monday",
+			Navbar:    &pref.NavbarPreference{SavedItems: []pref.NavLink{{ID: "explore", Text: "Explore", Url: "/explore"}}},
+		},
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, second.Version, "publishing under an existing name adds a version")
+
+	presets, err := prefService.ListPreferencesPresets(ctx)
+	require.NoError(t, err)
+	require.Len(t, presets, 2)
+
+	t.Run("applying a preset overwrites the org preferences", func(t *testing.T) {
+		err := prefService.Save(ctx, &pref.SavePreferenceCommand{OrgID: 2, Theme: "light", Timezone: "browser", HomeDashboardID: 5})
+		require.NoError(t, err)
+
+		err = prefService.ApplyPreferencesPreset(ctx, &pref.ApplyPresetCommand{PresetID: second.ID, OrgID: 2})
+		require.NoError(t, err)
+
+		stored, err := prefService.Get(ctx, &pref.GetPreferenceQuery{OrgID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, "dark", stored.Theme)
+		assert.Equal(t, "monday", stored.WeekStart)
+		assert.Empty(t, stored.Timezone)
+		assert.EqualValues(t, 5, stored.HomeDashboardID, "the home dashboard of the org is kept")
+		assert.Equal(t, *second.Preferences.Navbar, stored.JSONData.Navbar)
+
+		// the overwritten preferences can be rolled back
+		history, err := prefService.GetPreferencesHistory(ctx, &pref.PreferencesHistoryQuery{OrgID: 2})
+		require.NoError(t, err)
+		assert.Len(t, history, 2)
+	})
+
+	t.Run("applying a preset to an org without preferences", func(t *testing.T) {
+		err := prefService.ApplyPreferencesPreset(ctx, &pref.ApplyPresetCommand{PresetID: second.ID, OrgID: 3})
+		require.NoError(t, err)
+
+		stored, err := prefService.Get(ctx, &pref.GetPreferenceQuery{OrgID: 3})
+		require.NoError(t, err)
+		assert.Equal(t, "dark", stored.Theme)
+		assert.Equal(t, *second.Preferences.Navbar, stored.JSONData.Navbar)
+	})
+
+	t.Run("deleted presets cannot be applied", func(t *testing.T) {
+		require.NoError(t, prefService.DeletePreferencesPreset(ctx, first.ID))
+		require.ErrorIs(t, prefService.DeletePreferencesPreset(ctx, first.ID), pref.ErrPresetNotFound)
+
+		err := prefService.ApplyPreferencesPreset(ctx, &pref.ApplyPresetCommand{PresetID: first.ID, OrgID: 2})
+		require.ErrorIs(t, err, pref.ErrPresetNotFound)
+	})
 }
 
 func insertPrefs(t testing.TB, store store, preferences ...pref.Preference) {
@@ -454,10 +666,344 @@ func insertPrefs(t testing.TB, store store, preferences ...pref.Preference) {
 	}
 }
 
+func TestBulkSetPreferences_validation(t *testing.T) {
+	prefService := &Service{
+		store:    newFake(),
+		cfg:      setting.NewCfg(),
+		features: featuremgmt.WithFeatures(),
+	}
+
+	for _, tc := range []struct {
+		desc string
+		cmd  pref.BulkSetPreferencesCommand
+		err  error
+	}{
+		{desc: "empty mask", cmd: pref.BulkSetPreferencesCommand{ScopeFilter: pref.BulkScopeAllUsers}, err: pref.ErrInvalidBulkMask},
+		{desc: "unknown field", cmd: pref.BulkSetPreferencesCommand{Mask: []string{"navbar"}, ScopeFilter: pref.BulkScopeAllUsers}, err: pref.ErrInvalidBulkMask},
+		{desc: "unknown scope", cmd: pref.BulkSetPreferencesCommand{Mask: []string{"theme"}, ScopeFilter: "admins"}, err: pref.ErrInvalidBulkScope},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := prefService.BulkSetPreferences(context.Background(), &tc.cmd)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestPreferencesExperiments(t *testing.T) {
+	cfg := setting.NewCfg()
+	cfg.DefaultTheme = "dark"
+	prefService := &Service{
+		store:    newFake(),
+		cfg:      cfg,
+		features: featuremgmt.WithFeatures(featuremgmt.FlagAbTestTheme),
+	}
+	ctx := context.Background()
+
+	t.Run("experiments are validated", func(t *testing.T) {
+		_, err := prefService.CreatePreferencesExperiment(ctx, &pref.CreateExperimentCommand{Name: "light", VariantKey: "v2", Value: "light", Percentage: 101})
+		require.ErrorIs(t, err, pref.ErrInvalidExperiment)
+		_, err = prefService.CreatePreferencesExperiment(ctx, &pref.CreateExperimentCommand{Name: " ", VariantKey: "v2", Value: "light", Percentage: 25})
+		require.ErrorIs(t, err, pref.ErrInvalidExperiment)
+	})
+
+	experiment, err := prefService.CreatePreferencesExperiment(ctx, &pref.CreateExperimentCommand{Name: "light", VariantKey: "v2", Value: "light", Percentage: 25})
+	require.NoError(t, err)
+	assert.True(t, experiment.Active)
+
+	themeOf := func(t *testing.T, service *Service, userID int64) string {
+		t.Helper()
+		preference, err := service.GetWithDefaults(ctx, &pref.GetPreferenceWithDefaultsQuery{OrgID: 1, UserID: userID})
+		require.NoError(t, err)
+		return preference.Theme
+	}
+
+	t.Run("the variant is served to the configured percentage of users", func(t *testing.T) {
+		inVariant := 0
+		for userID := int64(1); userID <= 1000; userID++ {
+			if themeOf(t, prefService, userID) == "light" {
+				inVariant++
+			}
+		}
+		assert.Equal(t, 250, inVariant)
+		assert.Equal(t, "light", themeOf(t, prefService, 110), "the same users stay in the experiment arm")
+	})
+
+	t.Run("saved preferences override the variant", func(t *testing.T) {
+		theme := "dark"
+		require.NoError(t, prefService.Patch(ctx, &pref.PatchPreferenceCommand{OrgID: 1, UserID: 12, Theme: &theme}))
+		assert.Equal(t, "dark", themeOf(t, prefService, 12))
+		assert.Equal(t, "light", themeOf(t, prefService, 13))
+	})
+
+	t.Run("the variant is not served without the feature flag", func(t *testing.T) {
+		withoutFlag := &Service{store: prefService.store, cfg: cfg, features: featuremgmt.WithFeatures()}
+		assert.Equal(t, "dark", themeOf(t, withoutFlag, 13))
+	})
+
+	t.Run("terminated experiments are not served", func(t *testing.T) {
+		require.NoError(t, prefService.TerminateExperiment(ctx, "light"))
+		assert.Equal(t, "dark", themeOf(t, prefService, 13))
+		require.ErrorIs(t, prefService.TerminateExperiment(ctx, "light"), pref.ErrExperimentNotFound)
+
+		_, err := prefService.CreatePreferencesExperiment(ctx, &pref.CreateExperimentCommand{Name: "light", VariantKey: "v3", Value: "light", Percentage: 50})
+		require.ErrorIs(t, err, pref.ErrExperimentExists)
+	})
+}
+
+func TestOrgThemePolicy(t *testing.T) {
+	cfg := setting.NewCfg()
+	cfg.DefaultTheme = "dark"
+	prefService := &Service{
+		store:    newFake(),
+		cfg:      cfg,
+		features: featuremgmt.WithFeatures(),
+	}
+	ctx := context.Background()
+
+	theme := "dark"
+	require.NoError(t, prefService.Patch(ctx, &pref.PatchPreferenceCommand{OrgID: 1, UserID: 1, Theme: &theme}))
+
+	themeOf := func(t *testing.T, userID int64) string {
+		t.Helper()
+		preference, err := prefService.GetWithDefaults(ctx, &pref.GetPreferenceWithDefaultsQuery{OrgID: 1, UserID: userID})
+		require.NoError(t, err)
+		return preference.Theme
+	}
+
+	t.Run("invalid policies are rejected", func(t *testing.T) {
+		require.ErrorIs(t, prefService.SetOrgThemePolicy(ctx, 1, "always", "light"), pref.ErrInvalidOrgThemePolicy)
+		require.ErrorIs(t, prefService.SetOrgThemePolicy(ctx, 1, pref.OrgThemePolicyEnforce, "blue"), pref.ErrInvalidOrgThemePolicy)
+	})
+
+	t.Run("enforce overrides the user preference", func(t *testing.T) {
+		require.NoError(t, prefService.SetOrgThemePolicy(ctx, 1, pref.OrgThemePolicyEnforce, "light"))
+		assert.Equal(t, "light", themeOf(t, 1))
+		assert.Equal(t, "light", themeOf(t, 2))
+	})
+
+	t.Run("suggest only applies without a preference", func(t *testing.T) {
+		require.NoError(t, prefService.SetOrgThemePolicy(ctx, 1, pref.OrgThemePolicySuggest, "light"))
+		assert.Equal(t, "dark", themeOf(t, 1))
+		assert.Equal(t, "light", themeOf(t, 2))
+	})
+
+	t.Run("an empty policy has no effect", func(t *testing.T) {
+		require.NoError(t, prefService.SetOrgThemePolicy(ctx, 1, "", ""))
+		assert.Equal(t, "dark", themeOf(t, 1))
+		assert.Equal(t, "dark", themeOf(t, 2))
+	})
+
+	t.Run("the policy only applies to its org", func(t *testing.T) {
+		require.NoError(t, prefService.SetOrgThemePolicy(ctx, 2, pref.OrgThemePolicyEnforce, "light"))
+		assert.Equal(t, "dark", themeOf(t, 2))
+	})
+}
+
+func TestPluginPreferences(t *testing.T) {
+	prefService := &Service{
+		store:    newFake(),
+		cfg:      setting.NewCfg(),
+		features: featuremgmt.WithFeatures(),
+	}
+
+	t.Run("invalid plugin ids are rejected", func(t *testing.T) {
+		for _, id := range []string{"", "../core", "my plugin", "-leading-dash"} {
+			err := prefService.SavePluginPreferences(context.Background(), &pref.SavePluginPreferencesCommand{PluginID: id})
+			require.ErrorIs(t, err, pref.ErrInvalidPluginID, id)
+
+			_, err = prefService.GetPluginPreferences(context.Background(), &pref.GetPluginPreferencesQuery{PluginID: id})
+			require.ErrorIs(t, err, pref.ErrInvalidPluginID, id)
+		}
+	})
+
+	t.Run("values must be valid JSON", func(t *testing.T) {
+		err := prefService.SavePluginPreferences(context.Background(), &pref.SavePluginPreferencesCommand{
+			PluginID:    "grafana-clock-panel",
+			Preferences: map[string]json.RawMessage{"theme": json.RawMessage("{")},
+		})
+		require.ErrorIs(t, err, pref.ErrInvalidPluginPreferenceValue)
+	})
+
+	t.Run("plugins using the same key do not interfere", func(t *testing.T) {
+		err := prefService.SavePluginPreferences(context.Background(), &pref.SavePluginPreferencesCommand{
+			OrgID: 1, UserID: 1, PluginID: "plugin-a",
+			Preferences: map[string]json.RawMessage{"theme": json.RawMessage(`"dark"`)},
+		})
+		require.NoError(t, err)
+		err = prefService.SavePluginPreferences(context.Background(), &pref.SavePluginPreferencesCommand{
+			OrgID: 1, UserID: 1, PluginID: "plugin-b",
+			Preferences: map[string]json.RawMessage{"theme": json.RawMessage(`"light"`)},
+		})
+		require.NoError(t, err)
+
+		a, err := prefService.GetPluginPreferences(context.Background(), &pref.GetPluginPreferencesQuery{OrgID: 1, UserID: 1, PluginID: "plugin-a"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]json.RawMessage{"theme": json.RawMessage(`"dark"`)}, a)
+
+		b, err := prefService.GetPluginPreferences(context.Background(), &pref.GetPluginPreferencesQuery{OrgID: 1, UserID: 1, PluginID: "plugin-b"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]json.RawMessage{"theme": json.RawMessage(`"light"`)}, b)
+
+		// core preferences are untouched
+		_, err = prefService.store.Get(context.Background(), &pref.Preference{OrgID: 1, UserID: 1})
+		require.ErrorIs(t, err, pref.ErrPrefNotFound)
+	})
+}
+
+func TestPanelState(t *testing.T) {
+	prefService := &Service{
+		store:    newFake(),
+		cfg:      setting.NewCfg(),
+		features: featuremgmt.WithFeatures(),
+	}
+	ctx := context.Background()
+
+	t.Run("a dashboard UID is required", func(t *testing.T) {
+		err := prefService.SavePanelState(ctx, &pref.SavePanelStateCommand{OrgID: 1, UserID: 1, Panels: map[string]bool{"1": true}})
+		require.ErrorIs(t, err, pref.ErrDashboardUIDRequired)
+
+		_, err = prefService.GetPanelState(ctx, &pref.GetPanelStateQuery{OrgID: 1, UserID: 1})
+		require.ErrorIs(t, err, pref.ErrDashboardUIDRequired)
+	})
+
+	t.Run("without preferences no panel is collapsed", func(t *testing.T) {
+		state, err := prefService.GetPanelState(ctx, &pref.GetPanelStateQuery{OrgID: 1, UserID: 1, DashboardUID: "dash"})
+		require.NoError(t, err)
+		assert.Empty(t, state)
+	})
+
+	t.Run("saving a panel keeps the state of other panels", func(t *testing.T) {
+		err := prefService.SavePanelState(ctx, &pref.SavePanelStateCommand{OrgID: 1, UserID: 1, DashboardUID: "dash", Panels: map[string]bool{"a": true}})
+		require.NoError(t, err)
+		err = prefService.SavePanelState(ctx, &pref.SavePanelStateCommand{OrgID: 1, UserID: 1, DashboardUID: "dash", Panels: map[string]bool{"b": false}})
+		require.NoError(t, err)
+		err = prefService.SavePanelState(ctx, &pref.SavePanelStateCommand{OrgID: 1, UserID: 1, DashboardUID: "other", Panels: map[string]bool{"a": false}})
+		require.NoError(t, err)
+
+		state, err := prefService.GetPanelState(ctx, &pref.GetPanelStateQuery{OrgID: 1, UserID: 1, DashboardUID: "dash"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]bool{"a": true, "b": false}, state)
+	})
+
+	t.Run("saving preferences keeps the panel state", func(t *testing.T) {
+		err := prefService.Save(ctx, &pref.SavePreferenceCommand{OrgID: 1, UserID: 1, Theme: "dark"})
+		require.NoError(t, err)
+
+		state, err := prefService.GetPanelState(ctx, &pref.GetPanelStateQuery{OrgID: 1, UserID: 1, DashboardUID: "dash"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]bool{"a": true, "b": false}, state)
+	})
+
+	t.Run("panel state survives GetWithDefaults", func(t *testing.T) {
+		err := prefService.SavePanelState(ctx, &pref.SavePanelStateCommand{OrgID: 1, DashboardUID: "dash", Panels: map[string]bool{"a": false, "c": true}})
+		require.NoError(t, err)
+
+		res, err := prefService.GetWithDefaults(ctx, &pref.GetPreferenceWithDefaultsQuery{OrgID: 1, UserID: 1})
+		require.NoError(t, err)
+		assert.Equal(t, "dark", res.Theme)
+		assert.Equal(t, pref.PanelState{
+			"dash":  {"a": true, "b": false, "c": true},
+			"other": {"a": false},
+		}, res.JSONData.PanelState)
+	})
+}
+
 func newFake() store {
-	return &inmemStore{
-		preference: map[preferenceKey]pref.Preference{},
-		idMap:      map[int64]preferenceKey{},
-		nextID:     1,
+	return prefstore.NewInMemStore(0)
+}
+
+func TestIntegrationDeletePreferencesOnUserDeleted(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	sqlStore := db.InitTestDB(t)
+	ctx := context.Background()
+	prefService, err := ProvideService(sqlStore, setting.NewCfg(), featuremgmt.WithFeatures(), sqlStore.Bus(), fakes.NewFakeSecretsService())
+	require.NoError(t, err)
+
+	usr, err := sqlStore.CreateUser(ctx, user.CreateUserCommand{Login: "prefs", Email: "prefs@example.org"})
+	require.NoError(t, err)
+	require.NoError(t, prefService.Save(ctx, &pref.SavePreferenceCommand{OrgID: usr.OrgID, UserID: usr.ID, Theme: "dark"}))
+	require.NoError(t, prefService.SavePluginPreferences(ctx, &pref.SavePluginPreferencesCommand{
+		OrgID: usr.OrgID, UserID: usr.ID, PluginID: "grafana-clock-panel",
+		Preferences: map[string]json.RawMessage{"theme": json.RawMessage(`"dark"`)},
+	}))
+
+	require.NoError(t, sqlStore.DeleteUser(ctx, &models.DeleteUserCommand{UserId: usr.ID}))
+
+	for _, table := range []string{"preferences", "preferences_history", "plugin_preferences"} {
+		var count int64
+		err := sqlStore.WithDbSession(ctx, func(sess *db.Session) error {
+			var err error
+			count, err = sess.Table(table).Where("user_id = ?", usr.ID).Count()
+			return err
+		})
+		require.NoError(t, err)
+		require.Zero(t, count, table)
 	}
 }
+
+func TestIntegrationDeletePreferencesOnOrgDeleted(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	sqlStore := db.InitTestDB(t)
+	ctx := context.Background()
+	b := bus.ProvideBus(tracing.InitializeTracerForTest())
+	prefService, err := ProvideService(sqlStore, setting.NewCfg(), featuremgmt.WithFeatures(), b, fakes.NewFakeSecretsService())
+	require.NoError(t, err)
+
+	for _, orgID := range []int64{1, 2} {
+		require.NoError(t, prefService.Save(ctx, &pref.SavePreferenceCommand{OrgID: orgID, Theme: "dark"}))
+		require.NoError(t, prefService.Save(ctx, &pref.SavePreferenceCommand{OrgID: orgID, TeamID: 10 + orgID, Theme: "dark"}))
+		require.NoError(t, prefService.Save(ctx, &pref.SavePreferenceCommand{OrgID: orgID, UserID: 20 + orgID, Theme: "dark"}))
+		require.NoError(t, prefService.SavePluginPreferences(ctx, &pref.SavePluginPreferencesCommand{
+			OrgID: orgID, UserID: 20 + orgID, PluginID: "grafana-clock-panel",
+			Preferences: map[string]json.RawMessage{"theme": json.RawMessage(`"dark"`)},
+		}))
+	}
+
+	e := &events.OrgDeleted{Id: 1}
+	require.NoError(t, b.Publish(ctx, e))
+	assert.Equal(t, 3, e.PreferencesDeletedForOrg)
+
+	count := func(table string, orgID int64) int64 {
+		var count int64
+		err := sqlStore.WithDbSession(ctx, func(sess *db.Session) error {
+			var err error
+			count, err = sess.Table(table).Where("org_id = ?", orgID).Count()
+			return err
+		})
+		require.NoError(t, err)
+		return count
+	}
+	for _, table := range []string{"preferences", "preferences_history", "plugin_preferences"} {
+		assert.Zero(t, count(table, 1), table)
+		assert.NotZero(t, count(table, 2), table)
+	}
+}
+
+type failingOrgDeleteStore struct {
+	store
+}
+
+func (s failingOrgDeleteStore) DeletePreferencesForOrg(ctx context.Context, orgID int64) (int, error) {
+	return 0, errors.New("database is down")
+}
+
+func TestDeletePreferencesOnOrgDeletedFailure(t *testing.T) {
+	b := bus.ProvideBus(tracing.InitializeTracerForTest())
+	prefService := &Service{store: failingOrgDeleteStore{store: newFake()}, log: log.NewNopLogger()}
+	b.AddEventListener(prefService.handleOrgDeleted)
+
+	called := false
+	b.AddEventListener(func(ctx context.Context, e *events.OrgDeleted) error {
+		called = true
+		return nil
+	})
+
+	e := &events.OrgDeleted{Id: 1}
+	require.NoError(t, b.Publish(context.Background(), e))
+	assert.True(t, called)
+	assert.Zero(t, e.PreferencesDeletedForOrg)
+}
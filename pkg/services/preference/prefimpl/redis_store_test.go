@@ -0,0 +1,21 @@
+package prefimpl
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/db"
+	prefredis "github.com/grafana/grafana/pkg/services/preference/store/redis"
+)
+
+func TestIntegrationRedisPreferencesDataAccess(t *testing.T) {
+	testIntegrationPreferencesDataAccess(t, func(db.DB) store {
+		m, err := miniredis.Run()
+		require.NoError(t, err)
+		t.Cleanup(m.Close)
+		return prefredis.NewRedisPreferencesStore(redis.NewClient(&redis.Options{Addr: m.Addr()}))
+	})
+}
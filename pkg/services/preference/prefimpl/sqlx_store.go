@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	pref "github.com/grafana/grafana/pkg/services/preference"
 	"github.com/grafana/grafana/pkg/services/sqlstore/session"
@@ -52,6 +53,24 @@ func (s *sqlxStore) Update(ctx context.Context, cmd *pref.Preference) error {
 	return err
 }
 
+func (s *sqlxStore) UpdateWithVersion(ctx context.Context, cmd *pref.Preference, expectedVersion int64) error {
+	query := "UPDATE preferences SET org_id=?, user_id=?, team_id=?, version=?, home_dashboard_id=?, " +
+		"timezone=?, week_start=?, theme=?, created=?, updated=?, json_data=? WHERE id=? AND version=?"
+	res, err := s.sess.Exec(ctx, query, cmd.OrgID, cmd.UserID, cmd.TeamID, cmd.Version, cmd.HomeDashboardID,
+		cmd.Timezone, cmd.WeekStart, cmd.Theme, cmd.Created, cmd.Updated, cmd.JSONData, cmd.ID, expectedVersion)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return pref.ErrPreferenceConflict
+	}
+	return nil
+}
+
 func (s *sqlxStore) Insert(ctx context.Context, cmd *pref.Preference) (int64, error) {
 	var ID int64
 	query := "INSERT INTO preferences (org_id, user_id, team_id, version, home_dashboard_id, timezone, week_start, theme, created, updated, json_data) VALUES " +
@@ -62,7 +81,266 @@ func (s *sqlxStore) Insert(ctx context.Context, cmd *pref.Preference) (int64, er
 	return ID, err
 }
 
-func (s *sqlxStore) DeleteByUser(ctx context.Context, userID int64) error {
-	_, err := s.sess.Exec(ctx, "DELETE FROM preferences WHERE user_id=?", userID)
+func (s *sqlxStore) DeletePreferencesForUser(ctx context.Context, userID int64) error {
+	_, err := s.deleteWhere(ctx, "user_id=?", userID, "plugin_preferences")
+	return err
+}
+
+func (s *sqlxStore) DeletePreferencesForOrg(ctx context.Context, orgID int64) (int, error) {
+	return s.deleteWhere(ctx, "org_id=?", orgID, "plugin_preferences", "org_theme_config")
+}
+
+func (s *sqlxStore) DeletePreferencesForTeam(ctx context.Context, teamID int64) error {
+	// Plugin preferences are not stored per team.
+	_, err := s.deleteWhere(ctx, "team_id=?", teamID)
+	return err
+}
+
+// deleteWhere deletes the preferences, history and rows of extraTables
+// matching filter in a single transaction, and returns the number of
+// preferences deleted.
+func (s *sqlxStore) deleteWhere(ctx context.Context, filter string, id int64, extraTables ...string) (int, error) {
+	tables := append([]string{"preferences", "preferences_history"}, extraTables...)
+	var deleted int64
+	err := s.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		for i, table := range tables {
+			res, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE "+filter, id)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				if deleted, err = res.RowsAffected(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return int(deleted), err
+}
+
+func (s *sqlxStore) InsertHistory(ctx context.Context, history *pref.PreferenceHistory, maxDepth int) error {
+	return s.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		query := "INSERT INTO preferences_history (org_id, user_id, team_id, version, home_dashboard_id, timezone, week_start, theme, json_data, created) VALUES " +
+			"(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		if _, err := tx.Exec(ctx, query, history.OrgID, history.UserID, history.TeamID, history.Version, history.HomeDashboardID,
+			history.Timezone, history.WeekStart, history.Theme, history.JSONData, history.Created); err != nil {
+			return err
+		}
+		if maxDepth <= 0 {
+			return nil
+		}
+
+		var ids []int64
+		if err := tx.Select(ctx, &ids, "SELECT id FROM preferences_history WHERE org_id=? AND user_id=? AND team_id=? ORDER BY id DESC",
+			history.OrgID, history.UserID, history.TeamID); err != nil {
+			return err
+		}
+		if len(ids) <= maxDepth {
+			return nil
+		}
+
+		stale := ids[maxDepth:]
+		args := make([]interface{}, 0, len(stale))
+		for _, id := range stale {
+			args = append(args, id)
+		}
+		_, err := tx.Exec(ctx, "DELETE FROM preferences_history WHERE id IN (?"+strings.Repeat(",?", len(stale)-1)+")", args...)
+		return err
+	})
+}
+
+func (s *sqlxStore) ListHistory(ctx context.Context, query *pref.PreferencesHistoryQuery) ([]*pref.PreferenceHistory, error) {
+	history := make([]*pref.PreferenceHistory, 0)
+	err := s.sess.Select(ctx, &history, "SELECT * FROM preferences_history WHERE org_id=? AND user_id=? AND team_id=? ORDER BY id DESC",
+		query.OrgID, query.UserID, query.TeamID)
+	return history, err
+}
+
+func (s *sqlxStore) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.sess.Get(ctx, &count, "SELECT COUNT(*) FROM preferences")
+	return count, err
+}
+
+func (s *sqlxStore) ListForExport(ctx context.Context, orgID int64, scope pref.PreferencesScope, afterID int64, limit int) ([]*pref.Preference, error) {
+	prefs := make([]*pref.Preference, 0, limit)
+	err := s.sess.Select(ctx, &prefs, "SELECT * FROM preferences WHERE org_id = ? AND id > ? AND "+exportScopeFilters[scope]+" ORDER BY id ASC LIMIT ?", orgID, afterID, limit)
+	return prefs, err
+}
+
+func (s *sqlxStore) GetPluginPreferences(ctx context.Context, query *pref.GetPluginPreferencesQuery) ([]*pref.PluginPreference, error) {
+	prefs := make([]*pref.PluginPreference, 0)
+	err := s.sess.Select(ctx, &prefs, "SELECT * FROM plugin_preferences WHERE org_id=? AND user_id=? AND plugin_id=?", query.OrgID, query.UserID, query.PluginID)
+	return prefs, err
+}
+
+func (s *sqlxStore) SavePluginPreferences(ctx context.Context, prefs []*pref.PluginPreference) error {
+	return s.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		for _, p := range prefs {
+			if _, err := tx.Exec(ctx, `DELETE FROM plugin_preferences WHERE org_id=? AND user_id=? AND plugin_id=? AND "key"=?`, p.OrgID, p.UserID, p.PluginID, p.Key); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO plugin_preferences (org_id, user_id, plugin_id, "key", value_json) VALUES (?, ?, ?, ?, ?)`, p.OrgID, p.UserID, p.PluginID, p.Key, p.ValueJSON); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlxStore) DeletePluginPreferences(ctx context.Context, pluginID string) error {
+	_, err := s.sess.Exec(ctx, "DELETE FROM plugin_preferences WHERE plugin_id=?", pluginID)
 	return err
 }
+
+func (s *sqlxStore) InsertPreset(ctx context.Context, preset *pref.Preset) error {
+	return s.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		var latest int64
+		if err := tx.Get(ctx, &latest, "SELECT COALESCE(MAX(version), 0) FROM preferences_presets WHERE name=?", preset.Name); err != nil {
+			return err
+		}
+		preset.Version = latest + 1
+		id, err := tx.ExecWithReturningId(ctx, "INSERT INTO preferences_presets (name, version, preferences_json, created) VALUES (?, ?, ?, ?)",
+			preset.Name, preset.Version, preset.Preferences, preset.Created)
+		if err != nil {
+			return err
+		}
+		preset.ID = id
+		return nil
+	})
+}
+
+func (s *sqlxStore) GetPreset(ctx context.Context, presetID int64) (*pref.Preset, error) {
+	var preset pref.Preset
+	err := s.sess.Get(ctx, &preset, "SELECT * FROM preferences_presets WHERE id=?", presetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, pref.ErrPresetNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &preset, nil
+}
+
+func (s *sqlxStore) ListPresets(ctx context.Context) ([]*pref.Preset, error) {
+	presets := make([]*pref.Preset, 0)
+	err := s.sess.Select(ctx, &presets, "SELECT * FROM preferences_presets ORDER BY name ASC, version ASC")
+	return presets, err
+}
+
+func (s *sqlxStore) DeletePreset(ctx context.Context, presetID int64) error {
+	res, err := s.sess.Exec(ctx, "DELETE FROM preferences_presets WHERE id=?", presetID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return pref.ErrPresetNotFound
+	}
+	return nil
+}
+
+func (s *sqlxStore) BulkUpdate(ctx context.Context, cmd *pref.BulkSetPreferencesCommand, batchSize int) (int64, error) {
+	var updated int64
+	err := s.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		var ids idRange
+		if err := tx.Get(ctx, &ids, orgIDRangeQuery, cmd.OrgID); err != nil {
+			return err
+		}
+		if ids.MaxID == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		for from := ids.MinID; from <= ids.MaxID; from += int64(batchSize) {
+			query, args := bulkUpdateQuery(cmd, now, from, from+int64(batchSize))
+			res, err := tx.Exec(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			updated += n
+		}
+		return nil
+	})
+	return updated, err
+}
+
+func (s *sqlxStore) InsertExperiment(ctx context.Context, experiment *pref.Experiment) error {
+	return s.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		var count int64
+		if err := tx.Get(ctx, &count, "SELECT COUNT(*) FROM preferences_experiments WHERE experiment_name=?", experiment.Name); err != nil {
+			return err
+		}
+		if count > 0 {
+			return pref.ErrExperimentExists
+		}
+		id, err := tx.ExecWithReturningId(ctx, "INSERT INTO preferences_experiments (experiment_name, variant_key, value, percentage, active, created, updated) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			experiment.Name, experiment.VariantKey, experiment.Value, experiment.Percentage, experiment.Active, experiment.Created, experiment.Updated)
+		if err != nil {
+			return err
+		}
+		experiment.ID = id
+		return nil
+	})
+}
+
+func (s *sqlxStore) ListActiveExperiments(ctx context.Context) ([]*pref.Experiment, error) {
+	experiments := make([]*pref.Experiment, 0)
+	err := s.sess.Select(ctx, &experiments, "SELECT * FROM preferences_experiments WHERE active=? ORDER BY id ASC", true)
+	return experiments, err
+}
+
+func (s *sqlxStore) DeactivateExperiment(ctx context.Context, name string, updated time.Time) error {
+	res, err := s.sess.Exec(ctx, "UPDATE preferences_experiments SET active=?, updated=? WHERE experiment_name=? AND active=?", false, updated, name, true)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return pref.ErrExperimentNotFound
+	}
+	return nil
+}
+
+func (s *sqlxStore) GetOrgThemeConfig(ctx context.Context, orgID int64) (*pref.OrgThemeConfig, error) {
+	var config pref.OrgThemeConfig
+	err := s.sess.Get(ctx, &config, "SELECT * FROM org_theme_config WHERE org_id=?", orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, pref.ErrOrgThemeConfigNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (s *sqlxStore) SaveOrgThemeConfig(ctx context.Context, config *pref.OrgThemeConfig) error {
+	return s.sess.WithTransaction(ctx, func(tx *session.SessionTx) error {
+		res, err := tx.Exec(ctx, "UPDATE org_theme_config SET policy=?, default_theme=?, updated=? WHERE org_id=?",
+			config.Policy, config.Theme, config.Updated, config.OrgID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO org_theme_config (org_id, policy, default_theme, updated) VALUES (?, ?, ?, ?)",
+			config.OrgID, config.Policy, config.Theme, config.Updated)
+		return err
+	})
+}
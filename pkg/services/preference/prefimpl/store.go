@@ -1,15 +1,65 @@
 package prefimpl
 
 import (
-	"context"
+	"sort"
+	"strings"
+	"time"
 
 	pref "github.com/grafana/grafana/pkg/services/preference"
+	prefstore "github.com/grafana/grafana/pkg/services/preference/store"
 )
 
-type store interface {
-	Get(context.Context, *pref.Preference) (*pref.Preference, error)
-	List(context.Context, *pref.Preference) ([]*pref.Preference, error)
-	Insert(context.Context, *pref.Preference) (int64, error)
-	Update(context.Context, *pref.Preference) error
-	DeleteByUser(context.Context, int64) error
+type store = prefstore.Store
+
+// exportScopeFilters are the conditions selecting the preferences of each scope.
+var exportScopeFilters = map[pref.PreferencesScope]string{
+	pref.PreferencesScopeOrg:  "team_id = 0 AND user_id = 0",
+	pref.PreferencesScopeTeam: "team_id <> 0",
+	pref.PreferencesScopeUser: "team_id = 0 AND user_id <> 0",
+}
+
+// idRange is the range of the IDs of the preferences of an org.
+type idRange struct {
+	MinID int64 `xorm:"min_id" db:"min_id"`
+	MaxID int64 `xorm:"max_id" db:"max_id"`
+}
+
+const orgIDRangeQuery = "SELECT COALESCE(MIN(id), 0) AS min_id, COALESCE(MAX(id), 0) AS max_id FROM preferences WHERE org_id = ?"
+
+// bulkUpdateQuery returns the statement applying cmd to the preferences with
+// an ID in [fromID, toID), and its arguments. Only the preferences of current
+// members of the org are updated.
+func bulkUpdateQuery(cmd *pref.BulkSetPreferencesCommand, updated time.Time, fromID, toID int64) (string, []interface{}) {
+	fields := make([]string, 0, len(cmd.Mask))
+	seen := map[string]bool{}
+	for _, field := range cmd.Mask {
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	sets := make([]string, 0, len(fields)+2)
+	args := make([]interface{}, 0, len(fields)+5)
+	for _, field := range fields {
+		sets = append(sets, pref.BulkPreferenceColumns[field]+" = ?")
+		args = append(args, cmd.FieldValue(field))
+	}
+	sets = append(sets, "version = version + 1", "updated = ?")
+	args = append(args, updated)
+
+	filter := "org_id = ? AND team_id = 0 AND user_id IN (SELECT user_id FROM org_user WHERE org_id = ?) AND id >= ? AND id < ?"
+	args = append(args, cmd.OrgID, cmd.OrgID, fromID, toID)
+	if cmd.ScopeFilter == pref.BulkScopeUsersWithoutPreference {
+		for _, field := range fields {
+			if column := pref.BulkPreferenceColumns[field]; column == "home_dashboard_id" {
+				filter += " AND home_dashboard_id = 0"
+			} else {
+				filter += " AND " + column + " = ''"
+			}
+		}
+	}
+
+	return "UPDATE preferences SET " + strings.Join(sets, ", ") + " WHERE " + filter, args
 }
@@ -2,6 +2,9 @@ package prefimpl
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -9,8 +12,11 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/grafana/grafana/pkg/infra/db"
+	"github.com/grafana/grafana/pkg/services/featuremgmt"
+	"github.com/grafana/grafana/pkg/services/org"
 	pref "github.com/grafana/grafana/pkg/services/preference"
 	"github.com/grafana/grafana/pkg/services/user"
+	"github.com/grafana/grafana/pkg/setting"
 )
 
 type getStore func(db.DB) store
@@ -170,11 +176,386 @@ func testIntegrationPreferencesDataAccess(t *testing.T, fn getStore) {
 			})
 		require.NoError(t, err)
 	})
+	t.Run("plugin preferences with the same key do not interfere", func(t *testing.T) {
+		err := prefStore.SavePluginPreferences(context.Background(), []*pref.PluginPreference{
+			{OrgID: 1, UserID: 1, PluginID: "plugin-a", Key: "theme", ValueJSON: `"dark"`},
+			{OrgID: 1, UserID: 1, PluginID: "plugin-a", Key: "interval", ValueJSON: `10`},
+		})
+		require.NoError(t, err)
+		err = prefStore.SavePluginPreferences(context.Background(), []*pref.PluginPreference{
+			{OrgID: 1, UserID: 1, PluginID: "plugin-b", Key: "theme", ValueJSON: `"light"`},
+		})
+		require.NoError(t, err)
+
+		// overwriting a key only replaces that key
+		err = prefStore.SavePluginPreferences(context.Background(), []*pref.PluginPreference{
+			{OrgID: 1, UserID: 1, PluginID: "plugin-a", Key: "theme", ValueJSON: `"system"`},
+		})
+		require.NoError(t, err)
+
+		prefsA, err := prefStore.GetPluginPreferences(context.Background(), &pref.GetPluginPreferencesQuery{OrgID: 1, UserID: 1, PluginID: "plugin-a"})
+		require.NoError(t, err)
+		require.Len(t, prefsA, 2)
+		valuesA := map[string]string{}
+		for _, p := range prefsA {
+			valuesA[p.Key] = p.ValueJSON
+		}
+		require.Equal(t, map[string]string{"theme": `"system"`, "interval": `10`}, valuesA)
+
+		prefsB, err := prefStore.GetPluginPreferences(context.Background(), &pref.GetPluginPreferencesQuery{OrgID: 1, UserID: 1, PluginID: "plugin-b"})
+		require.NoError(t, err)
+		require.Len(t, prefsB, 1)
+		require.Equal(t, `"light"`, prefsB[0].ValueJSON)
+
+		err = prefStore.DeletePluginPreferences(context.Background(), "plugin-a")
+		require.NoError(t, err)
+
+		prefsA, err = prefStore.GetPluginPreferences(context.Background(), &pref.GetPluginPreferencesQuery{OrgID: 1, UserID: 1, PluginID: "plugin-a"})
+		require.NoError(t, err)
+		require.Empty(t, prefsA)
+		prefsB, err = prefStore.GetPluginPreferences(context.Background(), &pref.GetPluginPreferencesQuery{OrgID: 1, UserID: 1, PluginID: "plugin-b"})
+		require.NoError(t, err)
+		require.Len(t, prefsB, 1)
+	})
+	t.Run("concurrent saves with the same expected version conflict", func(t *testing.T) {
+		prefService := &Service{store: prefStore, cfg: setting.NewCfg(), features: featuremgmt.WithFeatures()}
+		query := &pref.GetPreferenceQuery{OrgID: 1, UserID: 42}
+
+		err := prefService.Save(context.Background(), &pref.SavePreferenceCommand{OrgID: 1, UserID: 42, Theme: "dark"})
+		require.NoError(t, err)
+		stored, err := prefService.Get(context.Background(), query)
+		require.NoError(t, err)
+		version := stored.Version
+
+		start := make(chan struct{})
+		errs := make(chan error, 2)
+		var wg sync.WaitGroup
+		for _, theme := range []string{"light", "dark"} {
+			wg.Add(1)
+			go func(theme string) {
+				defer wg.Done()
+				<-start
+				errs <- prefService.Save(context.Background(), &pref.SavePreferenceCommand{
+					OrgID:           1,
+					UserID:          42,
+					Theme:           theme,
+					ExpectedVersion: &version,
+				})
+			}(theme)
+		}
+		close(start)
+		wg.Wait()
+		close(errs)
+
+		var succeeded, conflicted int
+		for err := range errs {
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, pref.ErrPreferenceConflict):
+				conflicted++
+			default:
+				require.NoError(t, err)
+			}
+		}
+		require.Equal(t, 1, succeeded)
+		require.Equal(t, 1, conflicted)
+
+		stored, err = prefService.Get(context.Background(), query)
+		require.NoError(t, err)
+		require.Equal(t, version+1, stored.Version)
+	})
+	t.Run("count preferences", func(t *testing.T) {
+		ss := db.InitTestDB(t)
+		prefStore := fn(ss)
+		count, err := prefStore.Count(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int64(0), count)
+
+		for _, p := range []*pref.Preference{{OrgID: 1}, {OrgID: 1, TeamID: 2}, {OrgID: 1, UserID: 3}} {
+			p.Created, p.Updated = time.Now(), time.Now()
+			_, err := prefStore.Insert(context.Background(), p)
+			require.NoError(t, err)
+		}
+
+		count, err = prefStore.Count(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int64(3), count)
+
+		err = prefStore.DeletePreferencesForUser(context.Background(), 3)
+		require.NoError(t, err)
+		count, err = prefStore.Count(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int64(2), count)
+	})
+	t.Run("preferences history keeps the newest versions", func(t *testing.T) {
+		ss := db.InitTestDB(t)
+		prefStore := fn(ss)
+		for version := int64(0); version < 4; version++ {
+			p := &pref.Preference{OrgID: 1, UserID: 2, Version: version, Theme: "dark", Updated: time.Now(),
+				JSONData: &pref.PreferenceJSONData{Locale: "en-US"}}
+			require.NoError(t, prefStore.InsertHistory(context.Background(), pref.NewPreferenceHistory(p), 3))
+		}
+		other := &pref.Preference{OrgID: 1, UserID: 3, Updated: time.Now()}
+		require.NoError(t, prefStore.InsertHistory(context.Background(), pref.NewPreferenceHistory(other), 3))
+
+		history, err := prefStore.ListHistory(context.Background(), &pref.PreferencesHistoryQuery{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		require.Len(t, history, 3)
+		for i, h := range history {
+			require.EqualValues(t, 3-i, h.Version)
+			require.Equal(t, "dark", h.Theme)
+			require.Equal(t, "en-US", h.JSONData.Locale)
+		}
+
+		require.NoError(t, prefStore.DeletePreferencesForUser(context.Background(), 2))
+		history, err = prefStore.ListHistory(context.Background(), &pref.PreferencesHistoryQuery{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		require.Empty(t, history)
+	})
+	t.Run("delete preferences by org, team and user", func(t *testing.T) {
+		ss := db.InitTestDB(t)
+		prefStore := fn(ss)
+		ctx := context.Background()
+		for _, p := range []*pref.Preference{
+			{OrgID: 1}, {OrgID: 1, TeamID: 2}, {OrgID: 1, UserID: 3},
+			{OrgID: 4}, {OrgID: 4, TeamID: 5}, {OrgID: 4, UserID: 6},
+		} {
+			p.Created, p.Updated = time.Now(), time.Now()
+			_, err := prefStore.Insert(ctx, p)
+			require.NoError(t, err)
+			require.NoError(t, prefStore.InsertHistory(ctx, pref.NewPreferenceHistory(p), 0))
+		}
+		require.NoError(t, prefStore.SavePluginPreferences(ctx, []*pref.PluginPreference{
+			{OrgID: 1, UserID: 3, PluginID: "test-app", Key: "a", ValueJSON: "1"},
+			{OrgID: 4, UserID: 6, PluginID: "test-app", Key: "a", ValueJSON: "1"},
+		}))
+
+		require.NoError(t, prefStore.DeletePreferencesForTeam(ctx, 5))
+		_, err := prefStore.Get(ctx, &pref.Preference{OrgID: 4, TeamID: 5})
+		require.ErrorIs(t, err, pref.ErrPrefNotFound)
+		history, err := prefStore.ListHistory(ctx, &pref.PreferencesHistoryQuery{OrgID: 4, TeamID: 5})
+		require.NoError(t, err)
+		require.Empty(t, history)
+
+		require.NoError(t, prefStore.DeletePreferencesForUser(ctx, 6))
+		plugins, err := prefStore.GetPluginPreferences(ctx, &pref.GetPluginPreferencesQuery{OrgID: 4, UserID: 6, PluginID: "test-app"})
+		require.NoError(t, err)
+		require.Empty(t, plugins)
+
+		deleted, err := prefStore.DeletePreferencesForOrg(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, 3, deleted)
+		for _, q := range []*pref.Preference{{OrgID: 1}, {OrgID: 1, TeamID: 2}, {OrgID: 1, UserID: 3}} {
+			_, err := prefStore.Get(ctx, q)
+			require.ErrorIs(t, err, pref.ErrPrefNotFound)
+			history, err := prefStore.ListHistory(ctx, &pref.PreferencesHistoryQuery{OrgID: q.OrgID, TeamID: q.TeamID, UserID: q.UserID})
+			require.NoError(t, err)
+			require.Empty(t, history)
+		}
+		plugins, err = prefStore.GetPluginPreferences(ctx, &pref.GetPluginPreferencesQuery{OrgID: 1, UserID: 3, PluginID: "test-app"})
+		require.NoError(t, err)
+		require.Empty(t, plugins)
+
+		count, err := prefStore.Count(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), count)
+		_, err = prefStore.Get(ctx, &pref.Preference{OrgID: 4})
+		require.NoError(t, err)
+	})
 	t.Run("delete preference by user", func(t *testing.T) {
-		err := prefStore.DeleteByUser(context.Background(), user.SignedInUser{}.UserID)
+		err := prefStore.DeletePreferencesForUser(context.Background(), user.SignedInUser{}.UserID)
 		require.NoError(t, err)
 		query := &pref.Preference{OrgID: 0, UserID: user.SignedInUser{}.UserID, TeamID: 0}
 		_, err = prefStore.Get(context.Background(), query)
 		require.EqualError(t, err, pref.ErrPrefNotFound.Error())
 	})
+	t.Run("bulk update sets masked fields of the org users", func(t *testing.T) {
+		ss := db.InitTestDB(t)
+		prefStore := fn(ss)
+		ctx := context.Background()
+		err := ss.WithDbSession(ctx, func(sess *db.Session) error {
+			for _, userID := range []int64{1, 2, 3} {
+				if _, err := sess.Insert(&org.OrgUser{OrgID: 1, UserID: userID, Role: org.RoleViewer, Created: time.Now(), Updated: time.Now()}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		for _, p := range []*pref.Preference{
+			{OrgID: 1, Theme: "dark"},
+			{OrgID: 1, TeamID: 2, Theme: "dark"},
+			{OrgID: 1, UserID: 1, Theme: "dark"},
+			{OrgID: 1, UserID: 2},
+			{OrgID: 1, UserID: 3, Timezone: "UTC"},
+			{OrgID: 2, UserID: 1, Theme: "dark"},
+		} {
+			p.Created, p.Updated = time.Now(), time.Now()
+			_, err := prefStore.Insert(ctx, p)
+			require.NoError(t, err)
+		}
+
+		updated, err := prefStore.BulkUpdate(ctx, &pref.BulkSetPreferencesCommand{
+			OrgID:       1,
+			Fields:      pref.Preference{Theme: "light", Timezone: "browser"},
+			Mask:        []string{"theme"},
+			ScopeFilter: pref.BulkScopeUsersWithoutPreference,
+		}, 1)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), updated)
+
+		updated, err = prefStore.BulkUpdate(ctx, &pref.BulkSetPreferencesCommand{
+			OrgID:       1,
+			Fields:      pref.Preference{WeekStart: "monday"},
+			Mask:        []string{"weekStart"},
+			ScopeFilter: pref.BulkScopeAllUsers,
+		}, 1000)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), updated)
+
+		for _, expected := range []pref.Preference{
+			{UserID: 1, Theme: "dark", Version: 1},
+			{UserID: 2, Theme: "light", Version: 2},
+			{UserID: 3, Theme: "light", Version: 2},
+		} {
+			stored, err := prefStore.Get(ctx, &pref.Preference{OrgID: 1, UserID: expected.UserID})
+			require.NoError(t, err)
+			require.Equal(t, expected.Theme, stored.Theme)
+			require.Equal(t, "monday", stored.WeekStart)
+			require.Equal(t, expected.Version, stored.Version)
+		}
+		stored, err := prefStore.Get(ctx, &pref.Preference{OrgID: 1, UserID: 3})
+		require.NoError(t, err)
+		require.Equal(t, "UTC", stored.Timezone)
+
+		for _, query := range []*pref.Preference{{OrgID: 1}, {OrgID: 1, TeamID: 2}, {OrgID: 2, UserID: 1}} {
+			stored, err := prefStore.Get(ctx, query)
+			require.NoError(t, err)
+			require.Equal(t, "dark", stored.Theme)
+			require.Empty(t, stored.WeekStart)
+			require.Equal(t, int64(0), stored.Version)
+		}
+	})
+	t.Run("list for export pages the preferences of a scope", func(t *testing.T) {
+		ss := db.InitTestDB(t)
+		prefStore := fn(ss)
+		ctx := context.Background()
+		for _, p := range []*pref.Preference{
+			{OrgID: 1, UserID: 1},
+			{OrgID: 1, TeamID: 1},
+			{OrgID: 1},
+			{OrgID: 1, UserID: 2},
+			{OrgID: 2, UserID: 3},
+			{OrgID: 1, UserID: 4},
+		} {
+			p.Created, p.Updated = time.Now(), time.Now()
+			_, err := prefStore.Insert(ctx, p)
+			require.NoError(t, err)
+		}
+
+		userIDs := func(prefs []*pref.Preference) []int64 {
+			ids := make([]int64, 0, len(prefs))
+			for _, p := range prefs {
+				ids = append(ids, p.UserID)
+			}
+			return ids
+		}
+
+		page, err := prefStore.ListForExport(ctx, 1, pref.PreferencesScopeUser, 0, 2)
+		require.NoError(t, err)
+		require.Equal(t, []int64{1, 2}, userIDs(page))
+
+		page, err = prefStore.ListForExport(ctx, 1, pref.PreferencesScopeUser, page[1].ID, 2)
+		require.NoError(t, err)
+		require.Equal(t, []int64{4}, userIDs(page))
+
+		page, err = prefStore.ListForExport(ctx, 1, pref.PreferencesScopeTeam, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		require.Equal(t, int64(1), page[0].TeamID)
+
+		page, err = prefStore.ListForExport(ctx, 1, pref.PreferencesScopeOrg, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		require.Equal(t, pref.PreferencesScopeOrg, page[0].Scope())
+	})
+	t.Run("experiments", func(t *testing.T) {
+		ss := db.InitTestDB(t)
+		prefStore := fn(ss)
+		ctx := context.Background()
+		for _, name := range []string{"new-dark", "new-light"} {
+			err := prefStore.InsertExperiment(ctx, &pref.Experiment{
+				Name: name, VariantKey: "v2", Value: "dark", Percentage: 10, Active: true, Created: time.Now(), Updated: time.Now(),
+			})
+			require.NoError(t, err)
+		}
+		err := prefStore.InsertExperiment(ctx, &pref.Experiment{Name: "new-dark", VariantKey: "v3", Value: "light", Active: true, Created: time.Now(), Updated: time.Now()})
+		require.ErrorIs(t, err, pref.ErrExperimentExists)
+
+		experiments, err := prefStore.ListActiveExperiments(ctx)
+		require.NoError(t, err)
+		require.Len(t, experiments, 2)
+		require.Equal(t, "new-dark", experiments[0].Name)
+		require.Equal(t, "v2", experiments[0].VariantKey)
+		require.Equal(t, 10, experiments[0].Percentage)
+
+		require.NoError(t, prefStore.DeactivateExperiment(ctx, "new-dark", time.Now()))
+		require.ErrorIs(t, prefStore.DeactivateExperiment(ctx, "new-dark", time.Now()), pref.ErrExperimentNotFound)
+		require.ErrorIs(t, prefStore.DeactivateExperiment(ctx, "unknown", time.Now()), pref.ErrExperimentNotFound)
+
+		experiments, err = prefStore.ListActiveExperiments(ctx)
+		require.NoError(t, err)
+		require.Len(t, experiments, 1)
+		require.Equal(t, "new-light", experiments[0].Name)
+	})
+	t.Run("org theme config is saved per org", func(t *testing.T) {
+		ss := db.InitTestDB(t)
+		prefStore := fn(ss)
+		ctx := context.Background()
+		_, err := prefStore.GetOrgThemeConfig(ctx, 1)
+		require.ErrorIs(t, err, pref.ErrOrgThemeConfigNotFound)
+
+		require.NoError(t, prefStore.SaveOrgThemeConfig(ctx, &pref.OrgThemeConfig{OrgID: 1, Policy: pref.OrgThemePolicySuggest, Theme: "dark", Updated: time.Now()}))
+		require.NoError(t, prefStore.SaveOrgThemeConfig(ctx, &pref.OrgThemeConfig{OrgID: 1, Policy: pref.OrgThemePolicyEnforce, Theme: "light", Updated: time.Now()}))
+		config, err := prefStore.GetOrgThemeConfig(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, pref.OrgThemePolicyEnforce, config.Policy)
+		require.Equal(t, "light", config.Theme)
+
+		_, err = prefStore.DeletePreferencesForOrg(ctx, 1)
+		require.NoError(t, err)
+		_, err = prefStore.GetOrgThemeConfig(ctx, 1)
+		require.ErrorIs(t, err, pref.ErrOrgThemeConfigNotFound)
+	})
+	t.Run("presets are versioned by name", func(t *testing.T) {
+		ctx := context.Background()
+		for _, name := range []string{"b", "a", "b"} {
+			err := prefStore.InsertPreset(ctx, &pref.Preset{
+				Name:        name,
+				Preferences: &pref.PresetPreferences{Theme: "dark", Navbar: &orgNavbarPreferences},
+				Created:     time.Now(),
+			})
+			require.NoError(t, err)
+		}
+
+		presets, err := prefStore.ListPresets(ctx)
+		require.NoError(t, err)
+		require.Len(t, presets, 3)
+		versions := make([]string, 0, len(presets))
+		for _, p := range presets {
+			versions = append(versions, fmt.Sprintf("%s@%d", p.Name, p.Version))
+		}
+		require.Equal(t, []string{"a@1", "b@1", "b@2"}, versions)
+
+		stored, err := prefStore.GetPreset(ctx, presets[2].ID)
+		require.NoError(t, err)
+		require.Equal(t, "dark", stored.Preferences.Theme)
+		require.Equal(t, orgNavbarPreferences, *stored.Preferences.Navbar)
+
+		require.NoError(t, prefStore.DeletePreset(ctx, stored.ID))
+		_, err = prefStore.GetPreset(ctx, stored.ID)
+		require.ErrorIs(t, err, pref.ErrPresetNotFound)
+		require.ErrorIs(t, prefStore.DeletePreset(ctx, stored.ID), pref.ErrPresetNotFound)
+	})
 }
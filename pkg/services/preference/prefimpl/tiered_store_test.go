@@ -0,0 +1,89 @@
+package prefimpl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/infra/db"
+	"github.com/grafana/grafana/pkg/infra/log"
+	pref "github.com/grafana/grafana/pkg/services/preference"
+	prefstore "github.com/grafana/grafana/pkg/services/preference/store"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+func TestIntegrationTieredPreferencesDataAccess(t *testing.T) {
+	testIntegrationPreferencesDataAccess(t, func(ss db.DB) store {
+		return prefstore.NewTieredPreferencesStore(prefstore.NewInMemStore(time.Minute), &sqlStore{db: ss})
+	})
+}
+
+func TestIntegrationInMemPreferencesDataAccess(t *testing.T) {
+	testIntegrationPreferencesDataAccess(t, func(db.DB) store {
+		return prefstore.NewInMemStore(0)
+	})
+}
+
+func TestTieredStoreSave(t *testing.T) {
+	ctx := context.Background()
+	slow := prefstore.NewInMemStore(0)
+	prefService := &Service{
+		store: prefstore.NewTieredPreferencesStore(prefstore.NewInMemStore(time.Minute), slow),
+		cfg:   setting.NewCfg(),
+		log:   log.NewNopLogger(),
+	}
+	require.NoError(t, prefService.Save(ctx, &pref.SavePreferenceCommand{OrgID: 1, UserID: 2, Theme: "dark"}))
+	_, err := prefService.Get(ctx, &pref.GetPreferenceQuery{OrgID: 1, UserID: 2})
+	require.NoError(t, err)
+
+	// another instance sharing the slow tier saves the preference
+	stored, err := slow.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+	require.NoError(t, err)
+	stored.Version++
+	stored.JSONData.PanelState = pref.PanelState{"dash": {"a": true}}
+	require.NoError(t, slow.Update(ctx, stored))
+
+	t.Run("saves are based on the stored preference", func(t *testing.T) {
+		version := stored.Version
+		require.NoError(t, prefService.Save(ctx, &pref.SavePreferenceCommand{OrgID: 1, UserID: 2, Theme: "light", ExpectedVersion: &version}))
+
+		res, err := slow.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, "light", res.Theme)
+		assert.Equal(t, version+1, res.Version)
+		assert.Equal(t, pref.PanelState{"dash": {"a": true}}, res.JSONData.PanelState)
+
+		cached, err := prefService.Get(ctx, &pref.GetPreferenceQuery{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, "light", cached.Theme)
+		assert.Equal(t, version+1, cached.Version)
+	})
+}
+
+func BenchmarkTieredStoreGet(b *testing.B) {
+	ctx := context.Background()
+	sqlStore := &sqlStore{db: db.InitTestDB(b)}
+	_, err := sqlStore.Insert(ctx, &pref.Preference{OrgID: 1, UserID: 2, Theme: "dark", JSONData: &pref.PreferenceJSONData{}, Created: time.Now(), Updated: time.Now()})
+	require.NoError(b, err)
+	query := &pref.Preference{OrgID: 1, UserID: 2}
+
+	b.Run("slow", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := sqlStore.Get(ctx, query); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("tiered cache hit", func(b *testing.B) {
+		tiered := prefstore.NewTieredPreferencesStore(prefstore.NewInMemStore(time.Hour), sqlStore)
+		for i := 0; i < b.N; i++ {
+			if _, err := tiered.Get(ctx, query); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
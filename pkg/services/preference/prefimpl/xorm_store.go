@@ -2,7 +2,9 @@ package prefimpl
 
 import (
 	"context"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/grafana/grafana/pkg/infra/db"
 	pref "github.com/grafana/grafana/pkg/services/preference"
@@ -69,6 +71,19 @@ func (s *sqlStore) Update(ctx context.Context, cmd *pref.Preference) error {
 	})
 }
 
+func (s *sqlStore) UpdateWithVersion(ctx context.Context, cmd *pref.Preference, expectedVersion int64) error {
+	return s.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		n, err := sess.ID(cmd.ID).Where("version = ?", expectedVersion).AllCols().Update(cmd)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return pref.ErrPreferenceConflict
+		}
+		return nil
+	})
+}
+
 func (s *sqlStore) Insert(ctx context.Context, cmd *pref.Preference) (int64, error) {
 	var ID int64
 	var err error
@@ -79,10 +94,273 @@ func (s *sqlStore) Insert(ctx context.Context, cmd *pref.Preference) (int64, err
 	return ID, err
 }
 
-func (s *sqlStore) DeleteByUser(ctx context.Context, userID int64) error {
+func (s *sqlStore) DeletePreferencesForUser(ctx context.Context, userID int64) error {
+	_, err := s.deleteWhere(ctx, "user_id = ?", userID, "plugin_preferences")
+	return err
+}
+
+func (s *sqlStore) DeletePreferencesForOrg(ctx context.Context, orgID int64) (int, error) {
+	return s.deleteWhere(ctx, "org_id = ?", orgID, "plugin_preferences", "org_theme_config")
+}
+
+func (s *sqlStore) DeletePreferencesForTeam(ctx context.Context, teamID int64) error {
+	// Plugin preferences are not stored per team.
+	_, err := s.deleteWhere(ctx, "team_id = ?", teamID)
+	return err
+}
+
+// deleteWhere deletes the preferences, history and rows of extraTables
+// matching filter in a single transaction, and returns the number of
+// preferences deleted.
+func (s *sqlStore) deleteWhere(ctx context.Context, filter string, id int64, extraTables ...string) (int, error) {
+	tables := append([]string{"preferences", "preferences_history"}, extraTables...)
+	var deleted int64
+	err := s.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		for i, table := range tables {
+			res, err := sess.Exec("DELETE FROM "+table+" WHERE "+filter, id)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				if deleted, err = res.RowsAffected(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return int(deleted), err
+}
+
+func (s *sqlStore) InsertHistory(ctx context.Context, history *pref.PreferenceHistory, maxDepth int) error {
+	return s.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		if _, err := sess.Insert(history); err != nil {
+			return err
+		}
+		if maxDepth <= 0 {
+			return nil
+		}
+
+		var ids []int64
+		err := sess.Table("preferences_history").
+			Where("org_id=? AND user_id=? AND team_id=?", history.OrgID, history.UserID, history.TeamID).
+			Desc("id").Cols("id").Find(&ids)
+		if err != nil {
+			return err
+		}
+		if len(ids) <= maxDepth {
+			return nil
+		}
+		_, err = sess.In("id", ids[maxDepth:]).Delete(&pref.PreferenceHistory{})
+		return err
+	})
+}
+
+func (s *sqlStore) ListHistory(ctx context.Context, query *pref.PreferencesHistoryQuery) ([]*pref.PreferenceHistory, error) {
+	history := make([]*pref.PreferenceHistory, 0)
+	err := s.db.WithDbSession(ctx, func(sess *db.Session) error {
+		return sess.Where("org_id=? AND user_id=? AND team_id=?", query.OrgID, query.UserID, query.TeamID).
+			Desc("id").Find(&history)
+	})
+	return history, err
+}
+
+func (s *sqlStore) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.WithDbSession(ctx, func(sess *db.Session) error {
+		var err error
+		count, err = sess.Count(&pref.Preference{})
+		return err
+	})
+	return count, err
+}
+
+func (s *sqlStore) GetPluginPreferences(ctx context.Context, query *pref.GetPluginPreferencesQuery) ([]*pref.PluginPreference, error) {
+	prefs := make([]*pref.PluginPreference, 0)
+	err := s.db.WithDbSession(ctx, func(sess *db.Session) error {
+		return sess.Where("org_id=? AND user_id=? AND plugin_id=?", query.OrgID, query.UserID, query.PluginID).Find(&prefs)
+	})
+	return prefs, err
+}
+
+func (s *sqlStore) SavePluginPreferences(ctx context.Context, prefs []*pref.PluginPreference) error {
+	keyCol := s.db.GetDialect().Quote("key")
+	return s.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		for _, p := range prefs {
+			rawSQL := fmt.Sprintf("DELETE FROM plugin_preferences WHERE org_id=? AND user_id=? AND plugin_id=? AND %s=?", keyCol)
+			if _, err := sess.Exec(rawSQL, p.OrgID, p.UserID, p.PluginID, p.Key); err != nil {
+				return err
+			}
+			if _, err := sess.Insert(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) DeletePluginPreferences(ctx context.Context, pluginID string) error {
 	return s.db.WithDbSession(ctx, func(dbSession *db.Session) error {
-		var rawSQL = "DELETE FROM preferences WHERE user_id = ?"
-		_, err := dbSession.Exec(rawSQL, userID)
+		_, err := dbSession.Exec("DELETE FROM plugin_preferences WHERE plugin_id = ?", pluginID)
+		return err
+	})
+}
+
+func (s *sqlStore) InsertPreset(ctx context.Context, preset *pref.Preset) error {
+	return s.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		var latest int64
+		if _, err := sess.SQL("SELECT COALESCE(MAX(version), 0) FROM preferences_presets WHERE name=?", preset.Name).Get(&latest); err != nil {
+			return err
+		}
+		preset.Version = latest + 1
+		_, err := sess.Insert(preset)
+		return err
+	})
+}
+
+func (s *sqlStore) GetPreset(ctx context.Context, presetID int64) (*pref.Preset, error) {
+	var preset pref.Preset
+	err := s.db.WithDbSession(ctx, func(sess *db.Session) error {
+		exist, err := sess.ID(presetID).Get(&preset)
+		if err != nil {
+			return err
+		}
+		if !exist {
+			return pref.ErrPresetNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &preset, nil
+}
+
+func (s *sqlStore) ListPresets(ctx context.Context) ([]*pref.Preset, error) {
+	presets := make([]*pref.Preset, 0)
+	err := s.db.WithDbSession(ctx, func(sess *db.Session) error {
+		return sess.Asc("name", "version").Find(&presets)
+	})
+	return presets, err
+}
+
+func (s *sqlStore) DeletePreset(ctx context.Context, presetID int64) error {
+	return s.db.WithDbSession(ctx, func(sess *db.Session) error {
+		deleted, err := sess.ID(presetID).Delete(&pref.Preset{})
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return pref.ErrPresetNotFound
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) ListForExport(ctx context.Context, orgID int64, scope pref.PreferencesScope, afterID int64, limit int) ([]*pref.Preference, error) {
+	prefs := make([]*pref.Preference, 0, limit)
+	err := s.db.WithDbSession(ctx, func(sess *db.Session) error {
+		return sess.Where("org_id = ? AND id > ? AND "+exportScopeFilters[scope], orgID, afterID).
+			Asc("id").
+			Limit(limit).
+			Find(&prefs)
+	})
+	return prefs, err
+}
+
+func (s *sqlStore) BulkUpdate(ctx context.Context, cmd *pref.BulkSetPreferencesCommand, batchSize int) (int64, error) {
+	var updated int64
+	err := s.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		var ids idRange
+		if _, err := sess.SQL(orgIDRangeQuery, cmd.OrgID).Get(&ids); err != nil {
+			return err
+		}
+		if ids.MaxID == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		for from := ids.MinID; from <= ids.MaxID; from += int64(batchSize) {
+			query, args := bulkUpdateQuery(cmd, now, from, from+int64(batchSize))
+			res, err := sess.Exec(append([]interface{}{query}, args...)...)
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			updated += n
+		}
+		return nil
+	})
+	return updated, err
+}
+
+func (s *sqlStore) InsertExperiment(ctx context.Context, experiment *pref.Experiment) error {
+	return s.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		exists, err := sess.Where("experiment_name=?", experiment.Name).Exist(&pref.Experiment{})
+		if err != nil {
+			return err
+		}
+		if exists {
+			return pref.ErrExperimentExists
+		}
+		_, err = sess.Insert(experiment)
+		return err
+	})
+}
+
+func (s *sqlStore) ListActiveExperiments(ctx context.Context) ([]*pref.Experiment, error) {
+	experiments := make([]*pref.Experiment, 0)
+	err := s.db.WithDbSession(ctx, func(sess *db.Session) error {
+		return sess.Where("active=?", true).Asc("id").Find(&experiments)
+	})
+	return experiments, err
+}
+
+func (s *sqlStore) DeactivateExperiment(ctx context.Context, name string, updated time.Time) error {
+	return s.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		n, err := sess.Where("experiment_name=? AND active=?", name, true).
+			Cols("active", "updated").Update(&pref.Experiment{Active: false, Updated: updated})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return pref.ErrExperimentNotFound
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) GetOrgThemeConfig(ctx context.Context, orgID int64) (*pref.OrgThemeConfig, error) {
+	var config pref.OrgThemeConfig
+	err := s.db.WithDbSession(ctx, func(sess *db.Session) error {
+		exists, err := sess.Where("org_id=?", orgID).Get(&config)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return pref.ErrOrgThemeConfigNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (s *sqlStore) SaveOrgThemeConfig(ctx context.Context, config *pref.OrgThemeConfig) error {
+	return s.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
+		n, err := sess.Where("org_id=?", config.OrgID).Cols("policy", "default_theme", "updated").Update(config)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+		_, err = sess.Insert(config)
 		return err
 	})
 }
@@ -2,13 +2,24 @@ package preftest
 
 import (
 	"context"
+	"encoding/json"
+	"io"
 
 	pref "github.com/grafana/grafana/pkg/services/preference"
 )
 
 type FakePreferenceService struct {
-	ExpectedPreference *pref.Preference
-	ExpectedError      error
+	ExpectedPreference         *pref.Preference
+	ExpectedPluginPreferences  map[string]json.RawMessage
+	ExpectedPreferencesHistory []*pref.Preference
+	ExpectedPreset             *pref.Preset
+	ExpectedPresets            []*pref.Preset
+	ExpectedBulkSetResult      *pref.BulkSetResult
+	ExpectedImportResult       *pref.ImportResult
+	ExpectedExperiment         *pref.Experiment
+	ExpectedPanelState         map[string]bool
+	ExpectedExport             []*pref.ExportedPreference
+	ExpectedError              error
 }
 
 func NewPreferenceServiceFake() *FakePreferenceService {
@@ -38,3 +49,88 @@ func (f *FakePreferenceService) Patch(ctx context.Context, cmd *pref.PatchPrefer
 func (f *FakePreferenceService) DeleteByUser(context.Context, int64) error {
 	return f.ExpectedError
 }
+
+func (f *FakePreferenceService) GetPreferencesHistory(context.Context, *pref.PreferencesHistoryQuery) ([]*pref.Preference, error) {
+	return f.ExpectedPreferencesHistory, f.ExpectedError
+}
+
+func (f *FakePreferenceService) RollbackPreferences(context.Context, *pref.RollbackPreferencesCommand) error {
+	return f.ExpectedError
+}
+
+func (f *FakePreferenceService) GetPluginPreferences(context.Context, *pref.GetPluginPreferencesQuery) (map[string]json.RawMessage, error) {
+	return f.ExpectedPluginPreferences, f.ExpectedError
+}
+
+func (f *FakePreferenceService) SavePluginPreferences(context.Context, *pref.SavePluginPreferencesCommand) error {
+	return f.ExpectedError
+}
+
+func (f *FakePreferenceService) DeletePluginPreferences(context.Context, string) error {
+	return f.ExpectedError
+}
+
+func (f *FakePreferenceService) CreatePreferencesPreset(context.Context, *pref.CreatePresetCommand) (*pref.Preset, error) {
+	return f.ExpectedPreset, f.ExpectedError
+}
+
+func (f *FakePreferenceService) GetPreferencesPreset(context.Context, int64) (*pref.Preset, error) {
+	return f.ExpectedPreset, f.ExpectedError
+}
+
+func (f *FakePreferenceService) ListPreferencesPresets(context.Context) ([]*pref.Preset, error) {
+	return f.ExpectedPresets, f.ExpectedError
+}
+
+func (f *FakePreferenceService) DeletePreferencesPreset(context.Context, int64) error {
+	return f.ExpectedError
+}
+
+func (f *FakePreferenceService) ApplyPreferencesPreset(context.Context, *pref.ApplyPresetCommand) error {
+	return f.ExpectedError
+}
+
+func (f *FakePreferenceService) BulkSetPreferences(context.Context, *pref.BulkSetPreferencesCommand) (*pref.BulkSetResult, error) {
+	return f.ExpectedBulkSetResult, f.ExpectedError
+}
+
+func (f *FakePreferenceService) CreatePreferencesExperiment(context.Context, *pref.CreateExperimentCommand) (*pref.Experiment, error) {
+	return f.ExpectedExperiment, f.ExpectedError
+}
+
+func (f *FakePreferenceService) TerminateExperiment(ctx context.Context, name string) error {
+	return f.ExpectedError
+}
+
+func (f *FakePreferenceService) ImportPreferencesFromFile(context.Context, *pref.ImportFromFileCommand) (*pref.ImportResult, error) {
+	return f.ExpectedImportResult, f.ExpectedError
+}
+
+func (f *FakePreferenceService) SavePanelState(context.Context, *pref.SavePanelStateCommand) error {
+	return f.ExpectedError
+}
+
+func (f *FakePreferenceService) GetPanelState(context.Context, *pref.GetPanelStateQuery) (map[string]bool, error) {
+	return f.ExpectedPanelState, f.ExpectedError
+}
+
+func (f *FakePreferenceService) SetOrgThemePolicy(ctx context.Context, orgID int64, policy, theme string) error {
+	return f.ExpectedError
+}
+
+func (f *FakePreferenceService) StreamExportPreferences(ctx context.Context, cmd *pref.ExportPreferencesCommand, w io.Writer, opts ...pref.ExportOption) error {
+	if f.ExpectedError != nil {
+		return f.ExpectedError
+	}
+	enc := json.NewEncoder(w)
+	for _, p := range f.ExpectedExport {
+		if err := enc.Encode(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FakePreferenceService) ReEncryptPreferences(ctx context.Context, orgID int64) error {
+	return f.ExpectedError
+}
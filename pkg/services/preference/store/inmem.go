@@ -0,0 +1,681 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	pref "github.com/grafana/grafana/pkg/services/preference"
+)
+
+// preferenceKey is how preferences are addressed: by OrgID for the org
+// preferences, and by OrgID and TeamID or UserID for those of a team or user.
+// Zero stands for "not relevant", as in the equivalent database index.
+type preferenceKey struct {
+	OrgID  int64
+	TeamID int64
+	UserID int64
+}
+
+func keyOf(p *pref.Preference) preferenceKey {
+	return preferenceKey{OrgID: p.OrgID, TeamID: p.TeamID, UserID: p.UserID}
+}
+
+type pluginPreferenceKey struct {
+	OrgID    int64
+	UserID   int64
+	PluginID string
+	Key      string
+}
+
+// entry is a stored preference, or the record that there is none when used
+// as the fast tier of a tiered store.
+type entry struct {
+	preference *pref.Preference
+	expires    time.Time
+}
+
+// listEntry is the result of a List of the slow tier of a tiered store, by
+// the keys of the preferences listed. It is valid while the preferences of
+// its org are unchanged since it was stored.
+type listEntry struct {
+	keys       []preferenceKey
+	generation uint64
+	expires    time.Time
+}
+
+// InMemStore keeps preferences in memory. It is safe for concurrent use, and
+// used as the fast tier of NewTieredPreferencesStore and as a store in tests.
+//
+// Preferences expire ttl after they are written, unless ttl is 0. Expired
+// preferences are not returned, and are dropped by the first write once per
+// ttl. The other data of the store never expires.
+type InMemStore struct {
+	mu    sync.RWMutex
+	ttl   time.Duration
+	now   func() time.Time
+	swept time.Time
+
+	preferences      map[preferenceKey]entry
+	ids              map[int64]preferenceKey
+	lists            map[string]listEntry
+	generations      map[int64]uint64
+	nextID           int64
+	pluginPreference map[pluginPreferenceKey]pref.PluginPreference
+	history          map[preferenceKey][]pref.PreferenceHistory
+	presets          map[int64]pref.Preset
+	experiments      []pref.Experiment
+	orgThemes        map[int64]pref.OrgThemeConfig
+}
+
+func NewInMemStore(ttl time.Duration) *InMemStore {
+	return &InMemStore{
+		ttl:              ttl,
+		now:              time.Now,
+		preferences:      map[preferenceKey]entry{},
+		ids:              map[int64]preferenceKey{},
+		lists:            map[string]listEntry{},
+		generations:      map[int64]uint64{},
+		nextID:           1,
+		pluginPreference: map[pluginPreferenceKey]pref.PluginPreference{},
+		history:          map[preferenceKey][]pref.PreferenceHistory{},
+		presets:          map[int64]pref.Preset{},
+		orgThemes:        map[int64]pref.OrgThemeConfig{},
+	}
+}
+
+func (s *InMemStore) Get(ctx context.Context, query *pref.Preference) (*pref.Preference, error) {
+	p, _ := s.lookup(keyOf(query))
+	if p == nil {
+		return nil, pref.ErrPrefNotFound
+	}
+	return p, nil
+}
+
+// List returns the preferences of the org, of the teams of the query and of
+// the user, ordered by user then team as the SQL stores do. The order is
+// important, since later elements override earlier ones in GetWithDefaults.
+func (s *InMemStore) List(ctx context.Context, query *pref.Preference) ([]*pref.Preference, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	teams := make(map[int64]bool, len(query.Teams))
+	for _, teamID := range query.Teams {
+		teams[teamID] = true
+	}
+
+	res := []*pref.Preference{}
+	for key := range s.preferences {
+		if key.OrgID != query.OrgID || !(teams[key.TeamID] || (key.TeamID == 0 && (key.UserID == 0 || key.UserID == query.UserID))) {
+			continue
+		}
+		if e, ok := s.live(key); ok && e.preference != nil {
+			res = append(res, clone(e.preference))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].UserID == res[j].UserID {
+			return res[i].TeamID < res[j].TeamID
+		}
+		return res[i].UserID < res[j].UserID
+	})
+	return res, nil
+}
+
+// Insert stores a copy of the preference with its ID, or the next free ID if
+// it has none, and returns that ID.
+func (s *InMemStore) Insert(ctx context.Context, preference *pref.Preference) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	key := keyOf(preference)
+	if e, ok := s.live(key); ok && e.preference != nil {
+		return 0, fmt.Errorf("preference for [orgid=%d, userid=%d, teamid=%d] already exists", preference.OrgID, preference.UserID, preference.TeamID)
+	}
+
+	p := clone(preference)
+	if p.ID == 0 {
+		p.ID = s.nextID
+	}
+	if p.ID >= s.nextID {
+		s.nextID = p.ID + 1
+	}
+	s.set(p)
+	return p.ID, nil
+}
+
+func (s *InMemStore) Update(ctx context.Context, preference *pref.Preference) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	if _, ok := s.byID(preference.ID); !ok {
+		return pref.ErrPrefNotFound
+	}
+	s.set(clone(preference))
+	return nil
+}
+
+func (s *InMemStore) UpdateWithVersion(ctx context.Context, preference *pref.Preference, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	stored, ok := s.byID(preference.ID)
+	if !ok {
+		return pref.ErrPrefNotFound
+	}
+	if stored.Version != expectedVersion {
+		return pref.ErrPreferenceConflict
+	}
+	s.set(clone(preference))
+	return nil
+}
+
+func (s *InMemStore) DeletePreferencesForUser(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deletePreferences(func(key preferenceKey) bool { return key.UserID == userID })
+	for key := range s.pluginPreference {
+		if key.UserID == userID {
+			delete(s.pluginPreference, key)
+		}
+	}
+	return nil
+}
+
+func (s *InMemStore) DeletePreferencesForOrg(ctx context.Context, orgID int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := s.deletePreferences(func(key preferenceKey) bool { return key.OrgID == orgID })
+	for key := range s.pluginPreference {
+		if key.OrgID == orgID {
+			delete(s.pluginPreference, key)
+		}
+	}
+	delete(s.orgThemes, orgID)
+	return deleted, nil
+}
+
+func (s *InMemStore) DeletePreferencesForTeam(ctx context.Context, teamID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deletePreferences(func(key preferenceKey) bool { return key.TeamID == teamID })
+	return nil
+}
+
+func (s *InMemStore) InsertHistory(ctx context.Context, history *pref.PreferenceHistory, maxDepth int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := preferenceKey{OrgID: history.OrgID, TeamID: history.TeamID, UserID: history.UserID}
+	versions := append(s.history[key], *history)
+	if maxDepth > 0 && len(versions) > maxDepth {
+		versions = versions[len(versions)-maxDepth:]
+	}
+	s.history[key] = versions
+	return nil
+}
+
+func (s *InMemStore) ListHistory(ctx context.Context, query *pref.PreferencesHistoryQuery) ([]*pref.PreferenceHistory, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	versions := s.history[preferenceKey{OrgID: query.OrgID, TeamID: query.TeamID, UserID: query.UserID}]
+	res := make([]*pref.PreferenceHistory, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		h := versions[i]
+		res = append(res, &h)
+	}
+	return res, nil
+}
+
+func (s *InMemStore) Count(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int64
+	for key := range s.preferences {
+		if e, ok := s.live(key); ok && e.preference != nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *InMemStore) GetPluginPreferences(ctx context.Context, query *pref.GetPluginPreferencesQuery) ([]*pref.PluginPreference, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := []*pref.PluginPreference{}
+	for key, p := range s.pluginPreference {
+		if key.OrgID != query.OrgID || key.UserID != query.UserID || key.PluginID != query.PluginID {
+			continue
+		}
+		p := p
+		res = append(res, &p)
+	}
+	return res, nil
+}
+
+func (s *InMemStore) SavePluginPreferences(ctx context.Context, prefs []*pref.PluginPreference) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range prefs {
+		s.pluginPreference[pluginPreferenceKey{
+			OrgID:    p.OrgID,
+			UserID:   p.UserID,
+			PluginID: p.PluginID,
+			Key:      p.Key,
+		}] = *p
+	}
+	return nil
+}
+
+func (s *InMemStore) DeletePluginPreferences(ctx context.Context, pluginID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.pluginPreference {
+		if key.PluginID == pluginID {
+			delete(s.pluginPreference, key)
+		}
+	}
+	return nil
+}
+
+func (s *InMemStore) InsertPreset(ctx context.Context, preset *pref.Preset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var latest int64
+	for _, p := range s.presets {
+		if p.Name == preset.Name && p.Version > latest {
+			latest = p.Version
+		}
+	}
+	preset.ID = s.nextID
+	s.nextID++
+	preset.Version = latest + 1
+	s.presets[preset.ID] = *preset
+	return nil
+}
+
+func (s *InMemStore) GetPreset(ctx context.Context, presetID int64) (*pref.Preset, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.presets[presetID]
+	if !ok {
+		return nil, pref.ErrPresetNotFound
+	}
+	return &p, nil
+}
+
+func (s *InMemStore) ListPresets(ctx context.Context) ([]*pref.Preset, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]*pref.Preset, 0, len(s.presets))
+	for _, p := range s.presets {
+		p := p
+		res = append(res, &p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Name == res[j].Name {
+			return res[i].Version < res[j].Version
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res, nil
+}
+
+func (s *InMemStore) DeletePreset(ctx context.Context, presetID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.presets[presetID]; !ok {
+		return pref.ErrPresetNotFound
+	}
+	delete(s.presets, presetID)
+	return nil
+}
+
+// BulkUpdate updates the selected user preferences of the org. The users are
+// not checked to be members of the org, and batchSize is ignored.
+func (s *InMemStore) BulkUpdate(ctx context.Context, cmd *pref.BulkSetPreferencesCommand, batchSize int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	now := s.now()
+	var updated int64
+	for key := range s.preferences {
+		e, ok := s.live(key)
+		if !ok || e.preference == nil || key.OrgID != cmd.OrgID || key.UserID == 0 || key.TeamID != 0 || !cmd.Selects(e.preference) {
+			continue
+		}
+		p := clone(e.preference)
+		cmd.Apply(p)
+		p.Version++
+		p.Updated = now
+		s.set(p)
+		updated++
+	}
+	return updated, nil
+}
+
+func (s *InMemStore) InsertExperiment(ctx context.Context, experiment *pref.Experiment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.experiments {
+		if e.Name == experiment.Name {
+			return pref.ErrExperimentExists
+		}
+	}
+	experiment.ID = s.nextID
+	s.nextID++
+	s.experiments = append(s.experiments, *experiment)
+	return nil
+}
+
+func (s *InMemStore) ListActiveExperiments(ctx context.Context) ([]*pref.Experiment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]*pref.Experiment, 0, len(s.experiments))
+	for _, e := range s.experiments {
+		if e.Active {
+			e := e
+			res = append(res, &e)
+		}
+	}
+	return res, nil
+}
+
+func (s *InMemStore) DeactivateExperiment(ctx context.Context, name string, updated time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, e := range s.experiments {
+		if e.Name == name && e.Active {
+			s.experiments[i].Active = false
+			s.experiments[i].Updated = updated
+			return nil
+		}
+	}
+	return pref.ErrExperimentNotFound
+}
+
+func (s *InMemStore) GetOrgThemeConfig(ctx context.Context, orgID int64) (*pref.OrgThemeConfig, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	config, ok := s.orgThemes[orgID]
+	if !ok {
+		return nil, pref.ErrOrgThemeConfigNotFound
+	}
+	return &config, nil
+}
+
+func (s *InMemStore) SaveOrgThemeConfig(ctx context.Context, config *pref.OrgThemeConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.orgThemes[config.OrgID] = *config
+	return nil
+}
+
+func (s *InMemStore) ListForExport(ctx context.Context, orgID int64, scope pref.PreferencesScope, afterID int64, limit int) ([]*pref.Preference, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]int64, 0)
+	for id, key := range s.ids {
+		if key.OrgID == orgID && id > afterID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	res := make([]*pref.Preference, 0, limit)
+	for _, id := range ids {
+		if len(res) == limit {
+			break
+		}
+		e, ok := s.live(s.ids[id])
+		if ok && e.preference != nil && e.preference.Scope() == scope {
+			res = append(res, clone(e.preference))
+		}
+	}
+	return res, nil
+}
+
+// lookup returns a copy of the preference stored with the key, and whether
+// the store knows about the key. It knows about keys without a preference
+// that were recorded by setAbsent.
+func (s *InMemStore) lookup(key preferenceKey) (*pref.Preference, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.live(key)
+	if !ok || e.preference == nil {
+		return nil, ok
+	}
+	return clone(e.preference), true
+}
+
+// put stores a copy of the preference read from the slow tier, replacing
+// whatever is stored with its key or ID unless that is a later version of it.
+func (s *InMemStore) put(preference *pref.Preference) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	s.putLocked(preference)
+}
+
+func (s *InMemStore) putLocked(preference *pref.Preference) {
+	if stored, ok := s.byID(preference.ID); ok && stored.Version > preference.Version {
+		return
+	}
+	if preference.ID >= s.nextID {
+		s.nextID = preference.ID + 1
+	}
+	s.set(clone(preference))
+}
+
+// generation returns the generation of the preferences of the org, which
+// changes whenever they do.
+func (s *InMemStore) generation(orgID int64) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.generations[orgID]
+}
+
+// lookupList returns copies of the preferences listed for the query, if the
+// list is stored and the preferences of its org did not change since.
+func (s *InMemStore) lookupList(query *pref.Preference) ([]*pref.Preference, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l, ok := s.lists[listKey(query)]
+	if !ok || l.generation != s.generations[query.OrgID] || s.expired(l.expires) {
+		return nil, false
+	}
+	res := make([]*pref.Preference, 0, len(l.keys))
+	for _, key := range l.keys {
+		e, ok := s.live(key)
+		if !ok || e.preference == nil {
+			return nil, false
+		}
+		res = append(res, clone(e.preference))
+	}
+	return res, true
+}
+
+// putList stores the preferences listed for the query by the slow tier, and
+// the list itself if the preferences of the org are still at the generation
+// they were listed at.
+func (s *InMemStore) putList(query *pref.Preference, prefs []*pref.Preference, generation uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	current := s.generations[query.OrgID] == generation
+	keys := make([]preferenceKey, 0, len(prefs))
+	for _, p := range prefs {
+		s.putLocked(p)
+		keys = append(keys, keyOf(p))
+	}
+	if current {
+		s.lists[listKey(query)] = listEntry{keys: keys, generation: s.generations[query.OrgID], expires: s.expiry()}
+	}
+}
+
+// setAbsent records that there is no preference with the key.
+func (s *InMemStore) setAbsent(key preferenceKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	s.unset(key)
+	s.preferences[key] = entry{expires: s.expiry()}
+}
+
+// drop forgets about the key.
+func (s *InMemStore) drop(key preferenceKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unset(key)
+}
+
+// set stores p, which must not be shared with callers, and drops whatever
+// was stored with its key or under its ID. The generation of the org changes
+// if a key gains or loses a preference, not if a preference is replaced.
+func (s *InMemStore) set(p *pref.Preference) {
+	key := keyOf(p)
+	if old, ok := s.ids[p.ID]; ok && old != key {
+		s.unset(old)
+	}
+	if e, ok := s.preferences[key]; !ok || e.preference == nil || e.preference.ID != p.ID {
+		s.unset(key)
+		s.generations[key.OrgID]++
+	}
+	s.preferences[key] = entry{preference: p, expires: s.expiry()}
+	s.ids[p.ID] = key
+}
+
+func (s *InMemStore) unset(key preferenceKey) {
+	e, ok := s.preferences[key]
+	if !ok {
+		return
+	}
+	if e.preference != nil {
+		delete(s.ids, e.preference.ID)
+		s.generations[key.OrgID]++
+	}
+	delete(s.preferences, key)
+}
+
+// deletePreferences deletes the preferences and history matching the key
+// filter and returns the number of live preferences deleted.
+func (s *InMemStore) deletePreferences(matches func(preferenceKey) bool) int {
+	deleted := 0
+	for key := range s.preferences {
+		if !matches(key) {
+			continue
+		}
+		if e, ok := s.live(key); ok && e.preference != nil {
+			deleted++
+		}
+		s.unset(key)
+	}
+	for key := range s.history {
+		if matches(key) {
+			delete(s.history, key)
+		}
+	}
+	return deleted
+}
+
+func (s *InMemStore) byID(id int64) (*pref.Preference, bool) {
+	e, ok := s.live(s.ids[id])
+	if !ok || e.preference == nil || e.preference.ID != id {
+		return nil, false
+	}
+	return e.preference, true
+}
+
+func (s *InMemStore) live(key preferenceKey) (entry, bool) {
+	e, ok := s.preferences[key]
+	if !ok || s.expired(e.expires) {
+		return entry{}, false
+	}
+	return e, true
+}
+
+func (s *InMemStore) expired(expires time.Time) bool {
+	return !expires.IsZero() && !s.now().Before(expires)
+}
+
+func (s *InMemStore) expiry() time.Time {
+	if s.ttl == 0 {
+		return time.Time{}
+	}
+	return s.now().Add(s.ttl)
+}
+
+// sweep drops the expired preferences and lists, at most once per ttl so that writes
+// do not walk the store each time.
+func (s *InMemStore) sweep() {
+	if s.ttl == 0 {
+		return
+	}
+	now := s.now()
+	if now.Sub(s.swept) < s.ttl {
+		return
+	}
+	s.swept = now
+	for key := range s.preferences {
+		if _, ok := s.live(key); !ok {
+			s.unset(key)
+		}
+	}
+	for key, l := range s.lists {
+		if s.expired(l.expires) {
+			delete(s.lists, key)
+		}
+	}
+}
+
+func listKey(query *pref.Preference) string {
+	teams := append([]int64(nil), query.Teams...)
+	sort.Slice(teams, func(i, j int) bool { return teams[i] < teams[j] })
+	return fmt.Sprintf("%d:%d:%v", query.OrgID, query.UserID, teams)
+}
+
+// clone copies p, so that callers modifying what they read or wrote do not
+// modify the store.
+func clone(p *pref.Preference) *pref.Preference {
+	c := *p
+	c.Teams = nil
+	if p.JSONData != nil {
+		var jsonData pref.PreferenceJSONData
+		if encoded, err := json.Marshal(p.JSONData); err == nil && json.Unmarshal(encoded, &jsonData) == nil {
+			c.JSONData = &jsonData
+		}
+	}
+	return &c
+}
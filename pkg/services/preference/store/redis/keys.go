@@ -0,0 +1,95 @@
+package redis
+
+import (
+	"fmt"
+)
+
+func preferenceKey(id int64) string {
+	return fmt.Sprintf("%s:id:%d", keyPrefix, id)
+}
+
+// preferenceKeyFromRaw is preferenceKey for an ID read from an index set.
+func preferenceKeyFromRaw(id string) string {
+	return keyPrefix + ":id:" + id
+}
+
+func lookupKey(orgID, teamID, userID int64) string {
+	return fmt.Sprintf("%s:lookup:%d:%d:%d", keyPrefix, orgID, teamID, userID)
+}
+
+func historyKey(orgID, teamID, userID int64) string {
+	return fmt.Sprintf("%s:history:%d:%d:%d", keyPrefix, orgID, teamID, userID)
+}
+
+func userHistoryIndexKey(userID int64) string {
+	return fmt.Sprintf("%s:history_index:%d", keyPrefix, userID)
+}
+
+func orgHistoryIndexKey(orgID int64) string {
+	return fmt.Sprintf("%s:org_history_index:%d", keyPrefix, orgID)
+}
+
+func teamHistoryIndexKey(teamID int64) string {
+	return fmt.Sprintf("%s:team_history_index:%d", keyPrefix, teamID)
+}
+
+func allIndexKey() string {
+	return keyPrefix + ":all"
+}
+
+func orgIndexKey(orgID int64) string {
+	return fmt.Sprintf("%s:org:%d", keyPrefix, orgID)
+}
+
+func teamIndexKey(teamID int64) string {
+	return fmt.Sprintf("%s:team:%d", keyPrefix, teamID)
+}
+
+func userIndexKey(userID int64) string {
+	return fmt.Sprintf("%s:user:%d", keyPrefix, userID)
+}
+
+func pluginPreferencesKey(orgID, userID int64, pluginID string) string {
+	return fmt.Sprintf("%s:plugin:%d:%d:%s", keyPrefix, orgID, userID, pluginID)
+}
+
+func pluginIndexKey(pluginID string) string {
+	return fmt.Sprintf("%s:plugin_index:%s", keyPrefix, pluginID)
+}
+
+func pluginOrgIndexKey(orgID int64) string {
+	return fmt.Sprintf("%s:plugin_org_index:%d", keyPrefix, orgID)
+}
+
+func pluginUserIndexKey(userID int64) string {
+	return fmt.Sprintf("%s:plugin_user_index:%d", keyPrefix, userID)
+}
+
+func experimentKey(name string) string {
+	return fmt.Sprintf("%s:experiment:%s", keyPrefix, name)
+}
+
+func experimentIndexKey() string {
+	return keyPrefix + ":experiments"
+}
+
+func presetKey(id int64) string {
+	return fmt.Sprintf("%s:preset:%d", keyPrefix, id)
+}
+
+// presetKeyFromRaw is presetKey for an ID read from the index set.
+func presetKeyFromRaw(id string) string {
+	return keyPrefix + ":preset:" + id
+}
+
+func presetIndexKey() string {
+	return keyPrefix + ":presets"
+}
+
+func presetVersionKey(name string) string {
+	return fmt.Sprintf("%s:preset_version:%s", keyPrefix, name)
+}
+
+func orgThemeKey(orgID int64) string {
+	return fmt.Sprintf("%s:org_theme:%d", keyPrefix, orgID)
+}
@@ -0,0 +1,722 @@
+// Package redis provides a Redis backend for the preference service, as an
+// alternative to the SQL stores for deployments that need lower latency.
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+
+	pref "github.com/grafana/grafana/pkg/services/preference"
+)
+
+const (
+	keyPrefix = "preferences"
+
+	// maxTxAttempts is how many times a transaction is run when another client
+	// changes one of its watched keys before it commits.
+	maxTxAttempts = 5
+)
+
+// Client is the subset of the go-redis clients used by the store. It is
+// satisfied by *redis.Client and *redis.ClusterClient.
+type Client interface {
+	goredis.Cmdable
+	Watch(ctx context.Context, fn func(*goredis.Tx) error, keys ...string) error
+}
+
+// RedisPreferencesStore keeps preferences as JSON documents addressed by ID,
+// with sets indexing them per org, team and user so that List and the deletes
+// do not need to scan the keyspace. Plugin preferences are kept in one hash per
+// org, user and plugin, and the history of each preference in a list, newest
+// first.
+//
+// Writes to a preference and its indexes are done in a single MULTI/EXEC, and
+// those depending on the stored preference WATCH it. The deletes of an org,
+// team or user are not isolated from concurrent saves, so a preference saved
+// while its org, team or user is being deleted may survive.
+type RedisPreferencesStore struct {
+	client Client
+}
+
+func NewRedisPreferencesStore(client Client) *RedisPreferencesStore {
+	return &RedisPreferencesStore{client: client}
+}
+
+func (s *RedisPreferencesStore) Get(ctx context.Context, query *pref.Preference) (*pref.Preference, error) {
+	id, err := s.client.Get(ctx, lookupKey(query.OrgID, query.TeamID, query.UserID)).Int64()
+	if errors.Is(err, goredis.Nil) {
+		return nil, pref.ErrPrefNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return getByID(ctx, s.client, id)
+}
+
+// List returns the same preferences as the SQL stores: the org preference,
+// the preferences of the given teams and the user preference of the org,
+// ordered by user and team.
+func (s *RedisPreferencesStore) List(ctx context.Context, query *pref.Preference) ([]*pref.Preference, error) {
+	ids, err := s.client.SMembers(ctx, orgIndexKey(query.OrgID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	all, err := getByIDs(ctx, s.client, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	teams := make(map[int64]bool, len(query.Teams))
+	for _, t := range query.Teams {
+		teams[t] = true
+	}
+
+	prefs := make([]*pref.Preference, 0, len(all))
+	for _, p := range all {
+		if teams[p.TeamID] || (p.TeamID == 0 && (p.UserID == 0 || p.UserID == query.UserID)) {
+			prefs = append(prefs, p)
+		}
+	}
+
+	sort.SliceStable(prefs, func(i, j int) bool {
+		if prefs[i].UserID == prefs[j].UserID {
+			return prefs[i].TeamID < prefs[j].TeamID
+		}
+		return prefs[i].UserID < prefs[j].UserID
+	})
+	return prefs, nil
+}
+
+func (s *RedisPreferencesStore) Insert(ctx context.Context, cmd *pref.Preference) (int64, error) {
+	id, err := s.client.Incr(ctx, keyPrefix+":next_id").Result()
+	if err != nil {
+		return 0, err
+	}
+	cmd.ID = id
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return 0, err
+	}
+
+	key := lookupKey(cmd.OrgID, cmd.TeamID, cmd.UserID)
+	err = s.watch(ctx, func(tx *goredis.Tx) error {
+		exists, err := tx.Exists(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return fmt.Errorf("preference for [orgid=%d, userid=%d, teamid=%d] already exists", cmd.OrgID, cmd.UserID, cmd.TeamID)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.Set(ctx, key, id, 0)
+			pipe.Set(ctx, preferenceKey(id), data, 0)
+			index(ctx, pipe, cmd)
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *RedisPreferencesStore) Update(ctx context.Context, cmd *pref.Preference) error {
+	return s.watch(ctx, func(tx *goredis.Tx) error {
+		existing, err := getByID(ctx, tx, cmd.ID)
+		if err != nil {
+			return err
+		}
+		return replace(ctx, tx, existing, cmd)
+	}, preferenceKey(cmd.ID))
+}
+
+// UpdateWithVersion watches the preference while checking its version, so that
+// only one of several concurrent saves based on the same version succeeds, also
+// across Grafana instances sharing the Redis server.
+func (s *RedisPreferencesStore) UpdateWithVersion(ctx context.Context, cmd *pref.Preference, expectedVersion int64) error {
+	err := s.client.Watch(ctx, func(tx *goredis.Tx) error {
+		existing, err := getByID(ctx, tx, cmd.ID)
+		if errors.Is(err, pref.ErrPrefNotFound) {
+			return pref.ErrPreferenceConflict
+		}
+		if err != nil {
+			return err
+		}
+		if existing.Version != expectedVersion {
+			return pref.ErrPreferenceConflict
+		}
+		return replace(ctx, tx, existing, cmd)
+	}, preferenceKey(cmd.ID))
+	if errors.Is(err, goredis.TxFailedErr) {
+		return pref.ErrPreferenceConflict
+	}
+	return err
+}
+
+func (s *RedisPreferencesStore) DeletePreferencesForUser(ctx context.Context, userID int64) error {
+	_, err := s.deleteIndexed(ctx, userIndexKey(userID), userHistoryIndexKey(userID), pluginUserIndexKey(userID))
+	return err
+}
+
+func (s *RedisPreferencesStore) DeletePreferencesForOrg(ctx context.Context, orgID int64) (int, error) {
+	deleted, err := s.deleteIndexed(ctx, orgIndexKey(orgID), orgHistoryIndexKey(orgID), pluginOrgIndexKey(orgID))
+	if err != nil {
+		return deleted, err
+	}
+	return deleted, s.client.Del(ctx, orgThemeKey(orgID)).Err()
+}
+
+func (s *RedisPreferencesStore) DeletePreferencesForTeam(ctx context.Context, teamID int64) error {
+	// Plugin preferences are not stored per team.
+	_, err := s.deleteIndexed(ctx, teamIndexKey(teamID), teamHistoryIndexKey(teamID), "")
+	return err
+}
+
+// deleteIndexed deletes the preferences whose IDs are in the prefIndex set,
+// the history lists in the historyIndex set and the plugin preference hashes
+// in the pluginIndex set, then the sets themselves. An empty pluginIndex
+// leaves plugin preferences alone. It returns the number of preferences deleted.
+func (s *RedisPreferencesStore) deleteIndexed(ctx context.Context, prefIndex, historyIndex, pluginIndex string) (int, error) {
+	ids, err := s.client.SMembers(ctx, prefIndex).Result()
+	if err != nil {
+		return 0, err
+	}
+	prefs, err := getByIDs(ctx, s.client, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	keys := []string{prefIndex, historyIndex}
+	for _, index := range []string{historyIndex, pluginIndex} {
+		if index == "" {
+			continue
+		}
+		members, err := s.client.SMembers(ctx, index).Result()
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, members...)
+	}
+	if pluginIndex != "" {
+		keys = append(keys, pluginIndex)
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for _, p := range prefs {
+			unindex(ctx, pipe, p)
+			pipe.Del(ctx, lookupKey(p.OrgID, p.TeamID, p.UserID), preferenceKey(p.ID))
+		}
+		pipe.Del(ctx, keys...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(prefs), nil
+}
+
+func (s *RedisPreferencesStore) Count(ctx context.Context) (int64, error) {
+	return s.client.SCard(ctx, allIndexKey()).Result()
+}
+
+func (s *RedisPreferencesStore) InsertHistory(ctx context.Context, history *pref.PreferenceHistory, maxDepth int) error {
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+
+	key := historyKey(history.OrgID, history.TeamID, history.UserID)
+	_, err = s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.LPush(ctx, key, data)
+		for _, index := range []string{userHistoryIndexKey(history.UserID), orgHistoryIndexKey(history.OrgID), teamHistoryIndexKey(history.TeamID)} {
+			pipe.SAdd(ctx, index, key)
+		}
+		if maxDepth > 0 {
+			pipe.LTrim(ctx, key, 0, int64(maxDepth-1))
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *RedisPreferencesStore) ListHistory(ctx context.Context, query *pref.PreferencesHistoryQuery) ([]*pref.PreferenceHistory, error) {
+	values, err := s.client.LRange(ctx, historyKey(query.OrgID, query.TeamID, query.UserID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]*pref.PreferenceHistory, 0, len(values))
+	for _, v := range values {
+		var h pref.PreferenceHistory
+		if err := json.Unmarshal([]byte(v), &h); err != nil {
+			return nil, err
+		}
+		history = append(history, &h)
+	}
+	return history, nil
+}
+
+func (s *RedisPreferencesStore) GetPluginPreferences(ctx context.Context, query *pref.GetPluginPreferencesQuery) ([]*pref.PluginPreference, error) {
+	values, err := s.client.HGetAll(ctx, pluginPreferencesKey(query.OrgID, query.UserID, query.PluginID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	prefs := make([]*pref.PluginPreference, 0, len(values))
+	for key, value := range values {
+		prefs = append(prefs, &pref.PluginPreference{
+			OrgID:     query.OrgID,
+			UserID:    query.UserID,
+			PluginID:  query.PluginID,
+			Key:       key,
+			ValueJSON: value,
+		})
+	}
+	return prefs, nil
+}
+
+func (s *RedisPreferencesStore) SavePluginPreferences(ctx context.Context, prefs []*pref.PluginPreference) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for _, p := range prefs {
+			key := pluginPreferencesKey(p.OrgID, p.UserID, p.PluginID)
+			pipe.HSet(ctx, key, p.Key, p.ValueJSON)
+			for _, index := range []string{pluginIndexKey(p.PluginID), pluginOrgIndexKey(p.OrgID), pluginUserIndexKey(p.UserID)} {
+				pipe.SAdd(ctx, index, key)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *RedisPreferencesStore) DeletePluginPreferences(ctx context.Context, pluginID string) error {
+	keys, err := s.client.SMembers(ctx, pluginIndexKey(pluginID)).Result()
+	if err != nil {
+		return err
+	}
+	return s.client.Del(ctx, append(keys, pluginIndexKey(pluginID))...).Err()
+}
+
+// InsertPreset takes the next version of the preset name from a per name
+// counter, so that concurrent inserts never share a version.
+func (s *RedisPreferencesStore) InsertPreset(ctx context.Context, preset *pref.Preset) error {
+	id, err := s.client.Incr(ctx, keyPrefix+":preset_next_id").Result()
+	if err != nil {
+		return err
+	}
+	version, err := s.client.Incr(ctx, presetVersionKey(preset.Name)).Result()
+	if err != nil {
+		return err
+	}
+
+	preset.ID = id
+	preset.Version = version
+	data, err := json.Marshal(preset)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.Set(ctx, presetKey(id), data, 0)
+		pipe.SAdd(ctx, presetIndexKey(), id)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisPreferencesStore) GetPreset(ctx context.Context, presetID int64) (*pref.Preset, error) {
+	data, err := s.client.Get(ctx, presetKey(presetID)).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, pref.ErrPresetNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var preset pref.Preset
+	if err := json.Unmarshal(data, &preset); err != nil {
+		return nil, err
+	}
+	return &preset, nil
+}
+
+func (s *RedisPreferencesStore) ListPresets(ctx context.Context) ([]*pref.Preset, error) {
+	ids, err := s.client.SMembers(ctx, presetIndexKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = presetKeyFromRaw(id)
+	}
+	values, err := mget(ctx, s.client, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	presets := make([]*pref.Preset, 0, len(values))
+	for _, v := range values {
+		var preset pref.Preset
+		if err := json.Unmarshal([]byte(v), &preset); err != nil {
+			return nil, err
+		}
+		presets = append(presets, &preset)
+	}
+
+	sort.Slice(presets, func(i, j int) bool {
+		if presets[i].Name == presets[j].Name {
+			return presets[i].Version < presets[j].Version
+		}
+		return presets[i].Name < presets[j].Name
+	})
+	return presets, nil
+}
+
+func (s *RedisPreferencesStore) DeletePreset(ctx context.Context, presetID int64) error {
+	var deleted *goredis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		deleted = pipe.Del(ctx, presetKey(presetID))
+		pipe.SRem(ctx, presetIndexKey(), presetID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if deleted.Val() == 0 {
+		return pref.ErrPresetNotFound
+	}
+	return nil
+}
+
+// InsertExperiment claims the name of the experiment with SETNX, so that
+// concurrent inserts of the same name cannot both succeed.
+func (s *RedisPreferencesStore) InsertExperiment(ctx context.Context, experiment *pref.Experiment) error {
+	id, err := s.client.Incr(ctx, keyPrefix+":experiment_next_id").Result()
+	if err != nil {
+		return err
+	}
+	experiment.ID = id
+	data, err := json.Marshal(experiment)
+	if err != nil {
+		return err
+	}
+
+	// Adding the name to the index is harmless when the name is taken, as it
+	// is then indexed already.
+	var inserted *goredis.BoolCmd
+	_, err = s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		inserted = pipe.SetNX(ctx, experimentKey(experiment.Name), data, 0)
+		pipe.SAdd(ctx, experimentIndexKey(), experiment.Name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !inserted.Val() {
+		return pref.ErrExperimentExists
+	}
+	return nil
+}
+
+func (s *RedisPreferencesStore) ListActiveExperiments(ctx context.Context) ([]*pref.Experiment, error) {
+	names, err := s.client.SMembers(ctx, experimentIndexKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = experimentKey(name)
+	}
+	values, err := mget(ctx, s.client, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	experiments := make([]*pref.Experiment, 0, len(values))
+	for _, v := range values {
+		var experiment pref.Experiment
+		if err := json.Unmarshal([]byte(v), &experiment); err != nil {
+			return nil, err
+		}
+		if experiment.Active {
+			experiments = append(experiments, &experiment)
+		}
+	}
+
+	sort.Slice(experiments, func(i, j int) bool { return experiments[i].ID < experiments[j].ID })
+	return experiments, nil
+}
+
+func (s *RedisPreferencesStore) DeactivateExperiment(ctx context.Context, name string, updated time.Time) error {
+	key := experimentKey(name)
+	return s.watch(ctx, func(tx *goredis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if errors.Is(err, goredis.Nil) {
+			return pref.ErrExperimentNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var experiment pref.Experiment
+		if err := json.Unmarshal(data, &experiment); err != nil {
+			return err
+		}
+		if !experiment.Active {
+			return pref.ErrExperimentNotFound
+		}
+
+		experiment.Active = false
+		experiment.Updated = updated
+		data, err = json.Marshal(experiment)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.Set(ctx, key, data, 0)
+			return nil
+		})
+		return err
+	}, key)
+}
+
+func (s *RedisPreferencesStore) GetOrgThemeConfig(ctx context.Context, orgID int64) (*pref.OrgThemeConfig, error) {
+	data, err := s.client.Get(ctx, orgThemeKey(orgID)).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, pref.ErrOrgThemeConfigNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config pref.OrgThemeConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (s *RedisPreferencesStore) SaveOrgThemeConfig(ctx context.Context, config *pref.OrgThemeConfig) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, orgThemeKey(config.OrgID), data, 0).Err()
+}
+
+// BulkUpdate updates the user preferences of the org in a single transaction.
+// Redis has no org membership data, so the preferences of all users with
+// preferences in the org are candidates, and batchSize is ignored.
+func (s *RedisPreferencesStore) BulkUpdate(ctx context.Context, cmd *pref.BulkSetPreferencesCommand, batchSize int) (int64, error) {
+	ids, err := s.client.SMembers(ctx, orgIndexKey(cmd.OrgID)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	// Preferences added to the org meanwhile make the transaction run again.
+	keys := []string{orgIndexKey(cmd.OrgID)}
+	for _, id := range ids {
+		keys = append(keys, preferenceKeyFromRaw(id))
+	}
+
+	var updated int64
+	err = s.watch(ctx, func(tx *goredis.Tx) error {
+		prefs, err := getByIDs(ctx, tx, ids)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		var selected []*pref.Preference
+		for _, p := range prefs {
+			if p.UserID == 0 || p.TeamID != 0 || !cmd.Selects(p) {
+				continue
+			}
+			cmd.Apply(p)
+			p.Version++
+			p.Updated = now
+			selected = append(selected, p)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			for _, p := range selected {
+				if err := write(ctx, pipe, p); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		updated = int64(len(selected))
+		return err
+	}, keys...)
+	if err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+func (s *RedisPreferencesStore) ListForExport(ctx context.Context, orgID int64, scope pref.PreferencesScope, afterID int64, limit int) ([]*pref.Preference, error) {
+	rawIDs, err := s.client.SMembers(ctx, orgIndexKey(orgID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(rawIDs))
+	for _, rawID := range rawIDs {
+		id, err := strconv.ParseInt(rawID, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if id > afterID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	// Preferences are read a page at a time, until enough of them have the
+	// scope.
+	prefs := make([]*pref.Preference, 0, limit)
+	for len(ids) > 0 && len(prefs) < limit {
+		page := ids
+		if len(page) > limit {
+			page = page[:limit]
+		}
+		ids = ids[len(page):]
+
+		rawPage := make([]string, len(page))
+		for i, id := range page {
+			rawPage[i] = strconv.FormatInt(id, 10)
+		}
+		found, err := getByIDs(ctx, s.client, rawPage)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range found {
+			if len(prefs) == limit {
+				break
+			}
+			if p.Scope() == scope {
+				prefs = append(prefs, p)
+			}
+		}
+	}
+	return prefs, nil
+}
+
+// watch runs fn in a transaction watching keys, and runs it again if another
+// client changed one of them before it committed.
+func (s *RedisPreferencesStore) watch(ctx context.Context, fn func(*goredis.Tx) error, keys ...string) error {
+	var err error
+	for attempt := 0; attempt < maxTxAttempts; attempt++ {
+		err = s.client.Watch(ctx, fn, keys...)
+		if !errors.Is(err, goredis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
+
+func getByID(ctx context.Context, c goredis.Cmdable, id int64) (*pref.Preference, error) {
+	data, err := c.Get(ctx, preferenceKey(id)).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, pref.ErrPrefNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var p pref.Preference
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// getByIDs returns the preferences with the given IDs in a single MGET,
+// skipping those deleted since their IDs were read.
+func getByIDs(ctx context.Context, c goredis.Cmdable, ids []string) ([]*pref.Preference, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = preferenceKeyFromRaw(id)
+	}
+	values, err := mget(ctx, c, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	prefs := make([]*pref.Preference, 0, len(values))
+	for _, v := range values {
+		var p pref.Preference
+		if err := json.Unmarshal([]byte(v), &p); err != nil {
+			return nil, err
+		}
+		prefs = append(prefs, &p)
+	}
+	return prefs, nil
+}
+
+// mget returns the values of the keys that exist, in the order of keys.
+func mget(ctx context.Context, c goredis.Cmdable, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	values, err := c.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			found = append(found, s)
+		}
+	}
+	return found, nil
+}
+
+// replace queues writing cmd over existing in a transaction, moving the
+// indexes if the org, team or user of the preference changed.
+func replace(ctx context.Context, tx *goredis.Tx, existing, cmd *pref.Preference) error {
+	oldKey := lookupKey(existing.OrgID, existing.TeamID, existing.UserID)
+	newKey := lookupKey(cmd.OrgID, cmd.TeamID, cmd.UserID)
+	_, err := tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		if oldKey != newKey {
+			unindex(ctx, pipe, existing)
+			pipe.Del(ctx, oldKey)
+			pipe.Set(ctx, newKey, cmd.ID, 0)
+			index(ctx, pipe, cmd)
+		}
+		return write(ctx, pipe, cmd)
+	})
+	return err
+}
+
+func write(ctx context.Context, pipe goredis.Pipeliner, p *pref.Preference) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	pipe.Set(ctx, preferenceKey(p.ID), data, 0)
+	return nil
+}
+
+func index(ctx context.Context, pipe goredis.Pipeliner, p *pref.Preference) {
+	for _, key := range []string{orgIndexKey(p.OrgID), teamIndexKey(p.TeamID), userIndexKey(p.UserID), allIndexKey()} {
+		pipe.SAdd(ctx, key, p.ID)
+	}
+}
+
+func unindex(ctx context.Context, pipe goredis.Pipeliner, p *pref.Preference) {
+	for _, key := range []string{orgIndexKey(p.OrgID), teamIndexKey(p.TeamID), userIndexKey(p.UserID), allIndexKey()} {
+		pipe.SRem(ctx, key, p.ID)
+	}
+}
@@ -0,0 +1,105 @@
+package redis
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pref "github.com/grafana/grafana/pkg/services/preference"
+)
+
+func setupTestStore(t *testing.T) (*RedisPreferencesStore, *miniredis.Miniredis) {
+	t.Helper()
+	m, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(m.Close)
+	return NewRedisPreferencesStore(goredis.NewClient(&goredis.Options{Addr: m.Addr()})), m
+}
+
+func TestRedisPreferencesStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("list reads the preferences of the org at once", func(t *testing.T) {
+		s, m := setupTestStore(t)
+		for userID := int64(1); userID <= 10; userID++ {
+			_, err := s.Insert(ctx, &pref.Preference{OrgID: 1, UserID: userID, Theme: "dark"})
+			require.NoError(t, err)
+		}
+
+		before := m.CommandCount()
+		prefs, err := s.List(ctx, &pref.Preference{OrgID: 1, UserID: 3})
+		require.NoError(t, err)
+		require.Len(t, prefs, 1)
+		assert.Equal(t, int64(3), prefs[0].UserID)
+		// SMEMBERS and MGET
+		assert.Equal(t, 2, m.CommandCount()-before)
+	})
+
+	t.Run("a duplicate insert writes nothing", func(t *testing.T) {
+		s, m := setupTestStore(t)
+		id, err := s.Insert(ctx, &pref.Preference{OrgID: 1, UserID: 1, Theme: "dark"})
+		require.NoError(t, err)
+
+		_, err = s.Insert(ctx, &pref.Preference{OrgID: 1, UserID: 1, Theme: "light"})
+		require.Error(t, err)
+		assert.False(t, m.Exists(preferenceKey(id+1)))
+		count, err := s.Count(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		stored, err := s.Get(ctx, &pref.Preference{OrgID: 1, UserID: 1})
+		require.NoError(t, err)
+		assert.Equal(t, "dark", stored.Theme)
+	})
+
+	t.Run("only one of concurrent saves of a version succeeds", func(t *testing.T) {
+		s, _ := setupTestStore(t)
+		id, err := s.Insert(ctx, &pref.Preference{OrgID: 1, UserID: 1, Theme: "dark"})
+		require.NoError(t, err)
+
+		const writers = 10
+		errs := make([]error, writers)
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = s.UpdateWithVersion(ctx, &pref.Preference{ID: id, OrgID: 1, UserID: 1, Theme: "light", Version: 1}, 0)
+			}(i)
+		}
+		wg.Wait()
+
+		succeeded := 0
+		for _, err := range errs {
+			if err == nil {
+				succeeded++
+				continue
+			}
+			require.ErrorIs(t, err, pref.ErrPreferenceConflict)
+		}
+		assert.Equal(t, 1, succeeded)
+	})
+
+	t.Run("a conflict does not block saves of the current version", func(t *testing.T) {
+		s, _ := setupTestStore(t)
+		id, err := s.Insert(ctx, &pref.Preference{OrgID: 1, UserID: 1, Theme: "dark"})
+		require.NoError(t, err)
+
+		err = s.UpdateWithVersion(ctx, &pref.Preference{ID: id, OrgID: 1, UserID: 1, Theme: "light", Version: 3}, 2)
+		require.ErrorIs(t, err, pref.ErrPreferenceConflict)
+		err = s.UpdateWithVersion(ctx, &pref.Preference{ID: id, OrgID: 1, UserID: 1, Theme: "light", Version: 1}, 0)
+		require.NoError(t, err)
+		err = s.UpdateWithVersion(ctx, &pref.Preference{ID: id, OrgID: 1, UserID: 1, Theme: "dark", Version: 2}, 1)
+		require.NoError(t, err)
+
+		stored, err := s.Get(ctx, &pref.Preference{OrgID: 1, UserID: 1})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), stored.Version)
+		assert.Equal(t, "dark", stored.Theme)
+	})
+}
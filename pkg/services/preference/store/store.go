@@ -0,0 +1,67 @@
+// Package store provides the in-memory and tiered stores of the preference
+// service, and the Store interface all its stores implement.
+package store
+
+import (
+	"context"
+	"time"
+
+	pref "github.com/grafana/grafana/pkg/services/preference"
+)
+
+// Store keeps preferences, their history and the other data of the preference
+// service.
+type Store interface {
+	Get(context.Context, *pref.Preference) (*pref.Preference, error)
+	List(context.Context, *pref.Preference) ([]*pref.Preference, error)
+	Insert(context.Context, *pref.Preference) (int64, error)
+	Update(context.Context, *pref.Preference) error
+	// UpdateWithVersion updates the preference only if its stored version is
+	// expectedVersion, otherwise it returns pref.ErrPreferenceConflict.
+	UpdateWithVersion(ctx context.Context, cmd *pref.Preference, expectedVersion int64) error
+	// DeletePreferencesForUser deletes the preferences of the user, their
+	// history and the plugin preferences of the user.
+	DeletePreferencesForUser(ctx context.Context, userID int64) error
+	// DeletePreferencesForOrg deletes all preferences, history and plugin
+	// preferences of the org, including those of its users and teams.
+	// It returns the number of preferences deleted.
+	DeletePreferencesForOrg(ctx context.Context, orgID int64) (int, error)
+	// DeletePreferencesForTeam deletes the preferences of the team and their history.
+	DeletePreferencesForTeam(ctx context.Context, teamID int64) error
+	// InsertHistory records a version of a preference, then removes the oldest
+	// versions of that preference beyond maxDepth. A maxDepth of 0 keeps all versions.
+	InsertHistory(ctx context.Context, history *pref.PreferenceHistory, maxDepth int) error
+	// ListHistory returns the recorded versions of a preference, newest first.
+	ListHistory(context.Context, *pref.PreferencesHistoryQuery) ([]*pref.PreferenceHistory, error)
+	// Count returns the number of stored preferences.
+	Count(context.Context) (int64, error)
+	GetPluginPreferences(context.Context, *pref.GetPluginPreferencesQuery) ([]*pref.PluginPreference, error)
+	SavePluginPreferences(context.Context, []*pref.PluginPreference) error
+	DeletePluginPreferences(ctx context.Context, pluginID string) error
+	// InsertPreset stores the preset as the next version of the presets with
+	// its name, and sets its ID and version.
+	InsertPreset(context.Context, *pref.Preset) error
+	GetPreset(ctx context.Context, presetID int64) (*pref.Preset, error)
+	ListPresets(context.Context) ([]*pref.Preset, error)
+	DeletePreset(ctx context.Context, presetID int64) error
+	// BulkUpdate applies cmd in a single transaction, updating at most
+	// batchSize preferences per statement, and returns the number of
+	// preferences updated.
+	BulkUpdate(ctx context.Context, cmd *pref.BulkSetPreferencesCommand, batchSize int) (int64, error)
+	// InsertExperiment stores the experiment and sets its ID. It returns
+	// pref.ErrExperimentExists if an experiment with the same name exists.
+	InsertExperiment(context.Context, *pref.Experiment) error
+	// ListActiveExperiments returns the active experiments, oldest first.
+	ListActiveExperiments(context.Context) ([]*pref.Experiment, error)
+	// DeactivateExperiment marks the active experiment with the given name as
+	// inactive, or returns pref.ErrExperimentNotFound if there is none.
+	DeactivateExperiment(ctx context.Context, name string, updated time.Time) error
+	// GetOrgThemeConfig returns pref.ErrOrgThemeConfigNotFound if the theme
+	// of the org has never been configured.
+	GetOrgThemeConfig(ctx context.Context, orgID int64) (*pref.OrgThemeConfig, error)
+	// SaveOrgThemeConfig inserts or replaces the theme config of the org.
+	SaveOrgThemeConfig(context.Context, *pref.OrgThemeConfig) error
+	// ListForExport returns at most limit preferences of the org with the
+	// given scope and an ID greater than afterID, ordered by ID.
+	ListForExport(ctx context.Context, orgID int64, scope pref.PreferencesScope, afterID int64, limit int) ([]*pref.Preference, error)
+}
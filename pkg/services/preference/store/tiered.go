@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	pref "github.com/grafana/grafana/pkg/services/preference"
+)
+
+type skipFastTierKey struct{}
+
+// SkipFastTier returns a context whose reads of a tiered store go to the slow
+// tier, for reads that a write is based on.
+func SkipFastTier(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipFastTierKey{}, true)
+}
+
+func skipsFastTier(ctx context.Context) bool {
+	skip, _ := ctx.Value(skipFastTierKey{}).(bool)
+	return skip
+}
+
+// tieredStore answers Get and List from fast, an in-memory copy of the
+// preferences and lists read from slow, and passes everything else to slow.
+// fast also records the preferences Get did not find in slow. The lists of an
+// org are dropped whenever one of its preferences changes in fast.
+//
+// Writes go to slow and, once they succeed, the written preference is read
+// back from slow into fast. The deletes and bulk updates drop the preferences
+// they apply to from fast. Other Grafana instances sharing slow do not see the
+// writes of this one until the entries of fast expire.
+type tieredStore struct {
+	Store
+	fast *InMemStore
+}
+
+func NewTieredPreferencesStore(fast *InMemStore, slow Store) Store {
+	return &tieredStore{Store: slow, fast: fast}
+}
+
+func (s *tieredStore) Get(ctx context.Context, query *pref.Preference) (*pref.Preference, error) {
+	key := keyOf(query)
+	if !skipsFastTier(ctx) {
+		if p, ok := s.fast.lookup(key); ok {
+			if p == nil {
+				return nil, pref.ErrPrefNotFound
+			}
+			return p, nil
+		}
+	}
+	return s.load(ctx, key)
+}
+
+func (s *tieredStore) List(ctx context.Context, query *pref.Preference) ([]*pref.Preference, error) {
+	if !skipsFastTier(ctx) {
+		if res, ok := s.fast.lookupList(query); ok {
+			return res, nil
+		}
+	}
+
+	generation := s.fast.generation(query.OrgID)
+	res, err := s.Store.List(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	s.fast.putList(query, res, generation)
+	return res, nil
+}
+
+func (s *tieredStore) Insert(ctx context.Context, cmd *pref.Preference) (int64, error) {
+	id, err := s.Store.Insert(ctx, cmd)
+	if err != nil {
+		return 0, err
+	}
+	s.reload(ctx, keyOf(cmd))
+	return id, nil
+}
+
+func (s *tieredStore) Update(ctx context.Context, cmd *pref.Preference) error {
+	if err := s.Store.Update(ctx, cmd); err != nil {
+		return err
+	}
+	s.reload(ctx, keyOf(cmd))
+	return nil
+}
+
+func (s *tieredStore) UpdateWithVersion(ctx context.Context, cmd *pref.Preference, expectedVersion int64) error {
+	if err := s.Store.UpdateWithVersion(ctx, cmd, expectedVersion); err != nil {
+		return err
+	}
+	s.reload(ctx, keyOf(cmd))
+	return nil
+}
+
+func (s *tieredStore) DeletePreferencesForUser(ctx context.Context, userID int64) error {
+	defer func() { _ = s.fast.DeletePreferencesForUser(ctx, userID) }()
+	return s.Store.DeletePreferencesForUser(ctx, userID)
+}
+
+func (s *tieredStore) DeletePreferencesForOrg(ctx context.Context, orgID int64) (int, error) {
+	defer func() { _, _ = s.fast.DeletePreferencesForOrg(ctx, orgID) }()
+	return s.Store.DeletePreferencesForOrg(ctx, orgID)
+}
+
+func (s *tieredStore) DeletePreferencesForTeam(ctx context.Context, teamID int64) error {
+	defer func() { _ = s.fast.DeletePreferencesForTeam(ctx, teamID) }()
+	return s.Store.DeletePreferencesForTeam(ctx, teamID)
+}
+
+func (s *tieredStore) BulkUpdate(ctx context.Context, cmd *pref.BulkSetPreferencesCommand, batchSize int) (int64, error) {
+	defer func() { _, _ = s.fast.DeletePreferencesForOrg(ctx, cmd.OrgID) }()
+	return s.Store.BulkUpdate(ctx, cmd, batchSize)
+}
+
+// load reads the preference with the key from slow into fast.
+func (s *tieredStore) load(ctx context.Context, key preferenceKey) (*pref.Preference, error) {
+	p, err := s.Store.Get(ctx, &pref.Preference{OrgID: key.OrgID, TeamID: key.TeamID, UserID: key.UserID})
+	if errors.Is(err, pref.ErrPrefNotFound) {
+		s.fast.setAbsent(key)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.fast.put(p)
+	return p, nil
+}
+
+// reload reads a written preference back from slow, as stored, into fast. If
+// it cannot be read, it is dropped from fast.
+func (s *tieredStore) reload(ctx context.Context, key preferenceKey) {
+	if _, err := s.load(ctx, key); err != nil && !errors.Is(err, pref.ErrPrefNotFound) {
+		s.fast.drop(key)
+	}
+}
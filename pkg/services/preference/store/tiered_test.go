@@ -0,0 +1,223 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pref "github.com/grafana/grafana/pkg/services/preference"
+)
+
+// countingStore counts the reads reaching the wrapped store.
+type countingStore struct {
+	Store
+	gets, lists int
+}
+
+func (s *countingStore) Get(ctx context.Context, query *pref.Preference) (*pref.Preference, error) {
+	s.gets++
+	return s.Store.Get(ctx, query)
+}
+
+func (s *countingStore) List(ctx context.Context, query *pref.Preference) ([]*pref.Preference, error) {
+	s.lists++
+	return s.Store.List(ctx, query)
+}
+
+// versioningStore bumps the version of the preferences it writes, as the
+// stored preference differs from the written one in the SQL stores.
+type versioningStore struct {
+	Store
+}
+
+func (s versioningStore) Update(ctx context.Context, cmd *pref.Preference) error {
+	stored := *cmd
+	stored.Version++
+	return s.Store.Update(ctx, &stored)
+}
+
+func TestTieredStore(t *testing.T) {
+	ctx := context.Background()
+	setup := func(t *testing.T, ttl time.Duration) (Store, *countingStore) {
+		t.Helper()
+		slow := &countingStore{Store: NewInMemStore(0)}
+		_, err := slow.Insert(ctx, &pref.Preference{OrgID: 1, UserID: 2, Theme: "dark", JSONData: &pref.PreferenceJSONData{}})
+		require.NoError(t, err)
+		return NewTieredPreferencesStore(NewInMemStore(ttl), slow), slow
+	}
+
+	t.Run("populates the fast tier on a miss and answers hits from it", func(t *testing.T) {
+		tiered, slow := setup(t, time.Minute)
+
+		for i := 0; i < 3; i++ {
+			prefs, err := tiered.List(ctx, &pref.Preference{OrgID: 1, UserID: 2, Teams: []int64{3}})
+			require.NoError(t, err)
+			require.Len(t, prefs, 1)
+
+			res, err := tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+			require.NoError(t, err)
+			assert.Equal(t, "dark", res.Theme)
+
+			_, err = tiered.Get(ctx, &pref.Preference{OrgID: 1, TeamID: 3})
+			require.ErrorIs(t, err, pref.ErrPrefNotFound)
+		}
+		assert.Equal(t, 1, slow.gets, "preferences not found are cached")
+		assert.Equal(t, 1, slow.lists)
+	})
+
+	t.Run("returns copies of the cached preferences", func(t *testing.T) {
+		tiered, _ := setup(t, time.Minute)
+
+		res, err := tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		res.Theme = "light"
+		res.JSONData.PanelState = pref.PanelState{"dash": {"a": true}}
+
+		res, err = tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, "dark", res.Theme)
+		assert.Nil(t, res.JSONData.PanelState)
+	})
+
+	t.Run("caches inserted preferences once found missing", func(t *testing.T) {
+		tiered, slow := setup(t, time.Minute)
+
+		_, err := tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 3})
+		require.ErrorIs(t, err, pref.ErrPrefNotFound)
+		_, err = tiered.Insert(ctx, &pref.Preference{OrgID: 1, UserID: 3, Theme: "light"})
+		require.NoError(t, err)
+
+		gets := slow.gets
+		res, err := tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 3})
+		require.NoError(t, err)
+		assert.Equal(t, "light", res.Theme)
+		assert.Equal(t, gets, slow.gets)
+
+		prefs, err := tiered.List(ctx, &pref.Preference{OrgID: 1, UserID: 3})
+		require.NoError(t, err)
+		require.Len(t, prefs, 1)
+		assert.Equal(t, "light", prefs[0].Theme)
+	})
+
+	t.Run("caches written preferences as read back from the slow tier", func(t *testing.T) {
+		slow := &countingStore{Store: versioningStore{NewInMemStore(0)}}
+		tiered := NewTieredPreferencesStore(NewInMemStore(time.Minute), slow)
+		_, err := tiered.Insert(ctx, &pref.Preference{OrgID: 1, UserID: 2, Theme: "dark"})
+		require.NoError(t, err)
+
+		res, err := tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		res.Theme = "light"
+		require.NoError(t, tiered.Update(ctx, res))
+
+		stored, err := slow.Store.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, "light", stored.Theme)
+
+		res, err = tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, *stored, *res)
+
+		for i := 0; i < 2; i++ {
+			prefs, err := tiered.List(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+			require.NoError(t, err)
+			assert.Equal(t, []*pref.Preference{stored}, prefs)
+		}
+		assert.Equal(t, 1, slow.lists)
+	})
+
+	t.Run("drops the cached lists of the org when a preference is added", func(t *testing.T) {
+		tiered, slow := setup(t, time.Minute)
+
+		query := &pref.Preference{OrgID: 1, UserID: 2, Teams: []int64{3}}
+		_, err := tiered.List(ctx, query)
+		require.NoError(t, err)
+		_, err = tiered.Insert(ctx, &pref.Preference{OrgID: 1, TeamID: 3, UserID: 4, Theme: "light"})
+		require.NoError(t, err)
+
+		prefs, err := tiered.List(ctx, query)
+		require.NoError(t, err)
+		assert.Len(t, prefs, 2)
+		assert.Equal(t, 2, slow.lists)
+
+		_, err = tiered.List(ctx, query)
+		require.NoError(t, err)
+		assert.Equal(t, 2, slow.lists)
+	})
+
+	t.Run("keeps the fast tier when the write fails", func(t *testing.T) {
+		tiered, _ := setup(t, time.Minute)
+
+		res, err := tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+
+		res.Theme = "light"
+		require.ErrorIs(t, tiered.UpdateWithVersion(ctx, res, res.Version+1), pref.ErrPreferenceConflict)
+
+		res, err = tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, "dark", res.Theme)
+	})
+
+	t.Run("skips the fast tier on request", func(t *testing.T) {
+		tiered, slow := setup(t, time.Minute)
+
+		_, err := tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		stored, err := slow.Store.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		stored.Theme = "light"
+		require.NoError(t, slow.Store.Update(ctx, stored))
+
+		res, err := tiered.Get(SkipFastTier(ctx), &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, "light", res.Theme)
+		res, err = tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, "light", res.Theme, "the preference read is cached")
+	})
+
+	t.Run("drops deleted preferences from the fast tier", func(t *testing.T) {
+		tiered, _ := setup(t, time.Minute)
+
+		_, err := tiered.List(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		require.NoError(t, tiered.DeletePreferencesForUser(ctx, 2))
+
+		_, err = tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.ErrorIs(t, err, pref.ErrPrefNotFound)
+		prefs, err := tiered.List(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Empty(t, prefs)
+	})
+
+	t.Run("drops the preferences of the org on bulk updates", func(t *testing.T) {
+		tiered, slow := setup(t, time.Minute)
+
+		_, err := tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		_, err = tiered.BulkUpdate(ctx, &pref.BulkSetPreferencesCommand{
+			OrgID: 1, Fields: pref.Preference{Theme: "light"}, Mask: []string{"theme"}, ScopeFilter: pref.BulkScopeAllUsers,
+		}, 100)
+		require.NoError(t, err)
+
+		res, err := tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, "light", res.Theme)
+		assert.Equal(t, 2, slow.gets)
+	})
+
+	t.Run("reads from the slow tier once entries expire", func(t *testing.T) {
+		tiered, slow := setup(t, 10*time.Millisecond)
+
+		_, err := tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+		_, err = tiered.Get(ctx, &pref.Preference{OrgID: 1, UserID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, 2, slow.gets)
+	})
+}
@@ -57,7 +57,7 @@ func setupTestServer(
 	}
 
 	acService := actest.FakeService{ExpectedPermissions: permissions, ExpectedDisabled: !cfg.RBACEnabled}
-	ac := acimpl.ProvideAccessControl(cfg)
+	ac := acimpl.ProvideAccessControl(cfg, nil)
 
 	// build mux
 	m := web.New()
@@ -11,14 +11,17 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/grafana/grafana/pkg/api/routing"
+	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/components/apikeygen"
 	apikeygenprefix "github.com/grafana/grafana/pkg/components/apikeygenprefixed"
 	"github.com/grafana/grafana/pkg/infra/db"
 	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/tracing"
 	"github.com/grafana/grafana/pkg/services/accesscontrol"
 	accesscontrolmock "github.com/grafana/grafana/pkg/services/accesscontrol/mock"
 	"github.com/grafana/grafana/pkg/services/apikey"
@@ -54,7 +57,7 @@ func createTokenforSA(t *testing.T, store serviceaccounts.Store, keyName string,
 
 func TestServiceAccountsAPI_CreateToken(t *testing.T) {
 	store := db.InitTestDB(t)
-	apiKeyService := apikeyimpl.ProvideService(store, store.Cfg)
+	apiKeyService := apikeyimpl.ProvideService(store, store.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), nil)
 	kvStore := kvstore.ProvideService(store)
 	saStore := database.ProvideServiceAccountsStore(store, apiKeyService, kvStore, nil)
 	svcmock := tests.ServiceAccountMock{}
@@ -163,7 +166,9 @@ func TestServiceAccountsAPI_CreateToken(t *testing.T) {
 
 				hash, err := keyInfo.Hash()
 				require.NoError(t, err)
-				require.Equal(t, query.Result.Key, hash)
+				valid, err := query.Result.VerifyHash(hash)
+				require.NoError(t, err)
+				require.True(t, valid)
 			}
 		})
 	}
@@ -171,7 +176,7 @@ func TestServiceAccountsAPI_CreateToken(t *testing.T) {
 
 func TestServiceAccountsAPI_DeleteToken(t *testing.T) {
 	store := db.InitTestDB(t)
-	apiKeyService := apikeyimpl.ProvideService(store, store.Cfg)
+	apiKeyService := apikeyimpl.ProvideService(store, store.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), nil)
 	kvStore := kvstore.ProvideService(store)
 	svcMock := &tests.ServiceAccountMock{}
 	saStore := database.ProvideServiceAccountsStore(store, apiKeyService, kvStore, nil)
@@ -159,6 +159,7 @@ func (s *ServiceAccountsStoreImpl) UpdateServiceAccount(ctx context.Context,
 func ServiceAccountDeletions() []string {
 	deletes := []string{
 		"DELETE FROM api_key WHERE service_account_id = ?",
+		"DELETE FROM service_tokens WHERE service_account_id = ?",
 	}
 	deletes = append(deletes, sqlstore.UserDeletions()...)
 	return deletes
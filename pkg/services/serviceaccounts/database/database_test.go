@@ -5,11 +5,14 @@ import (
 	"math/rand"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/infra/db"
 	"github.com/grafana/grafana/pkg/infra/kvstore"
+	"github.com/grafana/grafana/pkg/infra/tracing"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/apikey/apikeyimpl"
 	"github.com/grafana/grafana/pkg/services/org"
@@ -112,7 +115,7 @@ func TestStore_DeleteServiceAccount(t *testing.T) {
 func setupTestDatabase(t *testing.T) (*sqlstore.SQLStore, *ServiceAccountsStoreImpl) {
 	t.Helper()
 	db := db.InitTestDB(t)
-	apiKeyService := apikeyimpl.ProvideService(db, db.Cfg)
+	apiKeyService := apikeyimpl.ProvideService(db, db.Cfg, prometheus.NewRegistry(), bus.ProvideBus(tracing.InitializeTracerForTest()), nil)
 	kvStore := kvstore.ProvideService(db)
 	orgService := orgimpl.ProvideService(db, setting.NewCfg())
 	return db, ProvideServiceAccountsStore(db, apiKeyService, kvStore, orgService)
@@ -59,7 +59,9 @@ func TestStore_AddServiceAccountToken(t *testing.T) {
 			for _, k := range keys {
 				if k.Name == keyName {
 					found = true
-					require.Equal(t, key.HashedKey, newKey.Key)
+					valid, err := newKey.VerifyHash(key.HashedKey)
+					require.NoError(t, err)
+					require.True(t, valid)
 					require.False(t, *k.IsRevoked)
 
 					if tc.secondsToLive == 0 {
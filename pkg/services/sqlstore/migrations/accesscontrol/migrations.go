@@ -170,4 +170,127 @@ func AddMigration(mg *migrator.Migrator) {
 	mg.AddMigration("add column hidden to role table", migrator.NewAddColumnMigration(roleV1, &migrator.Column{
 		Name: "hidden", Type: migrator.DB_Bool, Nullable: false, Default: "0",
 	}))
+
+	permissionSnapshotsV1 := migrator.Table{
+		Name: "permission_snapshots",
+		Columns: []*migrator.Column{
+			{Name: "id", Type: migrator.DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "org_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "created", Type: migrator.DB_DateTime, Nullable: false},
+			{Name: "data", Type: migrator.DB_MediumBlob, Nullable: false},
+		},
+		Indices: []*migrator.Index{
+			{Cols: []string{"org_id", "created"}},
+		},
+	}
+
+	mg.AddMigration("create permission snapshots table", migrator.NewAddTableMigration(permissionSnapshotsV1))
+
+	//-------  indexes ------------------
+	mg.AddMigration("add index permission_snapshots.org_id_created", migrator.NewAddIndexMigration(permissionSnapshotsV1, permissionSnapshotsV1.Indices[0]))
+
+	roleAssignmentAuditV1 := migrator.Table{
+		Name: "role_assignment_audit",
+		Columns: []*migrator.Column{
+			{Name: "id", Type: migrator.DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "org_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "user_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "role_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "role_uid", Type: migrator.DB_NVarchar, Length: 40, Nullable: false},
+			{Name: "action", Type: migrator.DB_NVarchar, Length: 40, Nullable: false},
+			{Name: "created", Type: migrator.DB_DateTime, Nullable: false},
+		},
+		Indices: []*migrator.Index{
+			{Cols: []string{"org_id", "user_id"}},
+		},
+	}
+
+	mg.AddMigration("create role assignment audit table", migrator.NewAddTableMigration(roleAssignmentAuditV1))
+
+	//-------  indexes ------------------
+	mg.AddMigration("add index role_assignment_audit.org_id_user_id", migrator.NewAddIndexMigration(roleAssignmentAuditV1, roleAssignmentAuditV1.Indices[0]))
+
+	mg.AddMigration("add column conditions to permission table", migrator.NewAddColumnMigration(permissionV1, &migrator.Column{
+		Name: "conditions", Type: migrator.DB_Text, Nullable: true,
+	}))
+
+	mg.AddMigration("add column impersonated_by to role_assignment_audit table", migrator.NewAddColumnMigration(roleAssignmentAuditV1, &migrator.Column{
+		Name: "impersonated_by", Type: migrator.DB_BigInt, Nullable: false, Default: "0",
+	}))
+
+	mg.AddMigration("add column actor_id to role_assignment_audit table", migrator.NewAddColumnMigration(roleAssignmentAuditV1, &migrator.Column{
+		Name: "actor_id", Type: migrator.DB_BigInt, Nullable: false, Default: "0",
+	}))
+
+	denyV1 := migrator.Table{
+		Name: "access_control_deny",
+		Columns: []*migrator.Column{
+			{Name: "id", Type: migrator.DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "org_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "user_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "action", Type: migrator.DB_Varchar, Length: 190, Nullable: false},
+			{Name: "scope", Type: migrator.DB_Varchar, Length: 190, Nullable: false},
+			{Name: "created", Type: migrator.DB_DateTime, Nullable: false},
+		},
+		Indices: []*migrator.Index{
+			{Cols: []string{"org_id", "user_id"}},
+		},
+	}
+
+	mg.AddMigration("create access control deny table", migrator.NewAddTableMigration(denyV1))
+
+	//-------  indexes ------------------
+	mg.AddMigration("add index access_control_deny.org_id_user_id", migrator.NewAddIndexMigration(denyV1, denyV1.Indices[0]))
+
+	temporaryPermissionV1 := migrator.Table{
+		Name: "access_control_temporary_permissions",
+		Columns: []*migrator.Column{
+			{Name: "id", Type: migrator.DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "org_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "user_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "action", Type: migrator.DB_Varchar, Length: 190, Nullable: false},
+			{Name: "scope", Type: migrator.DB_Varchar, Length: 190, Nullable: false},
+			{Name: "expires", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "created", Type: migrator.DB_DateTime, Nullable: false},
+		},
+		Indices: []*migrator.Index{
+			{Cols: []string{"org_id", "user_id"}},
+			{Cols: []string{"expires"}},
+		},
+	}
+
+	mg.AddMigration("create access control temporary permissions table", migrator.NewAddTableMigration(temporaryPermissionV1))
+
+	//-------  indexes ------------------
+	mg.AddMigration("add index access_control_temporary_permissions.org_id_user_id", migrator.NewAddIndexMigration(temporaryPermissionV1, temporaryPermissionV1.Indices[0]))
+	mg.AddMigration("add index access_control_temporary_permissions.expires", migrator.NewAddIndexMigration(temporaryPermissionV1, temporaryPermissionV1.Indices[1]))
+
+	mg.AddMigration("add column updated_by to role", migrator.NewAddColumnMigration(roleV1, &migrator.Column{
+		Name: "updated_by", Type: migrator.DB_BigInt, Nullable: false, Default: "0",
+	}))
+
+	roleVersionV1 := migrator.Table{
+		Name: "role_version",
+		Columns: []*migrator.Column{
+			{Name: "id", Type: migrator.DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "org_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "role_id", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "version", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "permissions", Type: migrator.DB_Text, Nullable: false},
+			{Name: "updated_by", Type: migrator.DB_BigInt, Nullable: false},
+			{Name: "updated", Type: migrator.DB_DateTime, Nullable: false},
+		},
+		Indices: []*migrator.Index{
+			{Cols: []string{"role_id", "version"}, Type: migrator.UniqueIndex},
+		},
+	}
+
+	mg.AddMigration("create role version table", migrator.NewAddTableMigration(roleVersionV1))
+
+	//-------  indexes ------------------
+	mg.AddMigration("add unique index role_version.role_id_version", migrator.NewAddIndexMigration(roleVersionV1, roleVersionV1.Indices[0]))
+
+	mg.AddMigration("add column is_service_account to user_role table", migrator.NewAddColumnMigration(userRoleV1, &migrator.Column{
+		Name: "is_service_account", Type: migrator.DB_Bool, Nullable: false, Default: "0",
+	}))
 }
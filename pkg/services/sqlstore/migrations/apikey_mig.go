@@ -100,4 +100,105 @@ func addApiKeyMigrations(mg *Migrator) {
 	mg.AddMigration("Add is_revoked column to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
 		Name: "is_revoked", Type: DB_Bool, Nullable: true, Default: "0",
 	}))
+
+	mg.AddMigration("add index api_key.org_id_role", NewAddIndexMigration(apiKeyV2, &Index{
+		Cols: []string{"org_id", "role"},
+	}))
+
+	// hash_version records the algorithm the key was hashed with, existing keys use the legacy algorithm.
+	mg.AddMigration("Add hash_version column to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "hash_version", Type: DB_Int, Nullable: false, Default: "1",
+	}))
+
+	mg.AddMigration("Add grace_period_seconds column to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "grace_period_seconds", Type: DB_BigInt, Nullable: false, Default: "0",
+	}))
+
+	mg.AddMigration("Add version column to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "version", Type: DB_BigInt, Nullable: false, Default: "0",
+	}))
+
+	// allowed_cidrs is a JSON array of the CIDRs a key can be used from, NULL for any address.
+	mg.AddMigration("Add allowed_cidrs column to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "allowed_cidrs", Type: DB_Text, Nullable: true,
+	}))
+
+	// scopes is a JSON array of the OAuth2-style scopes a key carries.
+	mg.AddMigration("Add scopes column to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "scopes", Type: DB_Text, Nullable: true,
+	}))
+
+	// active is false for keys added with a creation secret until their creation is confirmed.
+	mg.AddMigration("Add active column to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "active", Type: DB_Bool, Nullable: false, Default: "1",
+	}))
+
+	mg.AddMigration("Add creation_secret_hash column to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "creation_secret_hash", Type: DB_Varchar, Length: 255, Nullable: false, Default: "''",
+	}))
+
+	// confirmation_attempts bounds the guesses of the creation secret of a key.
+	mg.AddMigration("Add confirmation_attempts column to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "confirmation_attempts", Type: DB_BigInt, Nullable: false, Default: "0",
+	}))
+
+	// verifier_hash is the slow hash of keys whose hash version has one, "key" holds the hash they are looked up by.
+	mg.AddMigration("Add verifier_hash column to api_key table", NewAddColumnMigration(apiKeyV2, &Column{
+		Name: "verifier_hash", Type: DB_Varchar, Length: 255, Nullable: false, Default: "''",
+	}))
+
+	serviceTokenV1 := Table{
+		Name: "service_tokens",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "org_id", Type: DB_BigInt, Nullable: false},
+			{Name: "service_account_id", Type: DB_BigInt, Nullable: false},
+			{Name: "name", Type: DB_NVarchar, Length: 190, Nullable: false},
+			{Name: "key", Type: DB_Varchar, Length: 190, Nullable: false},
+			{Name: "created", Type: DB_DateTime, Nullable: false},
+			{Name: "expires", Type: DB_BigInt, Nullable: true},
+		},
+		Indices: []*Index{
+			{Cols: []string{"key"}, Type: UniqueIndex},
+			{Cols: []string{"org_id", "name"}, Type: UniqueIndex},
+			{Cols: []string{"service_account_id"}},
+		},
+	}
+
+	mg.AddMigration("create service_tokens table", NewAddTableMigration(serviceTokenV1))
+	addTableIndicesMigrations(mg, "v1", serviceTokenV1)
+
+	apiKeyPoolV1 := Table{
+		Name: "api_key_pool",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "org_id", Type: DB_BigInt, Nullable: false},
+			{Name: "name", Type: DB_NVarchar, Length: 190, Nullable: false},
+			{Name: "quota", Type: DB_BigInt, Nullable: false},
+			{Name: "created", Type: DB_DateTime, Nullable: false},
+			{Name: "updated", Type: DB_DateTime, Nullable: false},
+		},
+		Indices: []*Index{
+			{Cols: []string{"org_id", "name"}, Type: UniqueIndex},
+		},
+	}
+
+	mg.AddMigration("create api_key_pool table", NewAddTableMigration(apiKeyPoolV1))
+	addTableIndicesMigrations(mg, "v1", apiKeyPoolV1)
+
+	apiKeyPoolMemberV1 := Table{
+		Name: "api_key_pool_member",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "pool_id", Type: DB_BigInt, Nullable: false},
+			{Name: "key_id", Type: DB_BigInt, Nullable: false},
+			{Name: "usage_count", Type: DB_BigInt, Nullable: false, Default: "0"},
+		},
+		Indices: []*Index{
+			{Cols: []string{"pool_id", "key_id"}, Type: UniqueIndex},
+		},
+	}
+
+	mg.AddMigration("create api_key_pool_member table", NewAddTableMigration(apiKeyPoolMemberV1))
+	addTableIndicesMigrations(mg, "v1", apiKeyPoolMemberV1)
 }
@@ -55,4 +55,101 @@ func addPreferencesMigrations(mg *Migrator) {
 	// change column type of preferences.json_data
 	mg.AddMigration("alter preferences.json_data to mediumtext v1", NewRawSQLMigration("").
 		Mysql("ALTER TABLE preferences MODIFY json_data MEDIUMTEXT;"))
+
+	pluginPreferencesV1 := Table{
+		Name: "plugin_preferences",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "org_id", Type: DB_BigInt, Nullable: false},
+			{Name: "user_id", Type: DB_BigInt, Nullable: false},
+			{Name: "plugin_id", Type: DB_NVarchar, Length: 190, Nullable: false},
+			{Name: "key", Type: DB_NVarchar, Length: 190, Nullable: false},
+			{Name: "value_json", Type: DB_MediumText, Nullable: false},
+		},
+		Indices: []*Index{
+			{Cols: []string{"org_id", "user_id", "plugin_id", "key"}, Type: UniqueIndex},
+			{Cols: []string{"plugin_id"}},
+		},
+	}
+
+	mg.AddMigration("create plugin_preferences table", NewAddTableMigration(pluginPreferencesV1))
+	addTableIndicesMigrations(mg, "v1", pluginPreferencesV1)
+
+	preferencesHistoryV1 := Table{
+		Name: "preferences_history",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "org_id", Type: DB_BigInt, Nullable: false},
+			{Name: "user_id", Type: DB_BigInt, Nullable: false},
+			{Name: "team_id", Type: DB_BigInt, Nullable: false},
+			{Name: "version", Type: DB_Int, Nullable: false},
+			{Name: "home_dashboard_id", Type: DB_BigInt, Nullable: false},
+			{Name: "timezone", Type: DB_NVarchar, Length: 50, Nullable: false},
+			{Name: "week_start", Type: DB_NVarchar, Length: 10, Nullable: true},
+			{Name: "theme", Type: DB_NVarchar, Length: 20, Nullable: false},
+			{Name: "json_data", Type: DB_MediumText, Nullable: true},
+			{Name: "created", Type: DB_DateTime, Nullable: false},
+		},
+		Indices: []*Index{
+			{Cols: []string{"org_id", "user_id", "team_id"}},
+			{Cols: []string{"user_id"}},
+		},
+	}
+
+	mg.AddMigration("create preferences_history table", NewAddTableMigration(preferencesHistoryV1))
+	addTableIndicesMigrations(mg, "v1", preferencesHistoryV1)
+
+	preferencesPresetsV1 := Table{
+		Name: "preferences_presets",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "name", Type: DB_NVarchar, Length: 190, Nullable: false},
+			{Name: "version", Type: DB_BigInt, Nullable: false},
+			{Name: "preferences_json", Type: DB_MediumText, Nullable: false},
+			{Name: "created", Type: DB_DateTime, Nullable: false},
+		},
+		Indices: []*Index{
+			{Cols: []string{"name", "version"}, Type: UniqueIndex},
+		},
+	}
+
+	mg.AddMigration("create preferences_presets table", NewAddTableMigration(preferencesPresetsV1))
+	addTableIndicesMigrations(mg, "v1", preferencesPresetsV1)
+
+	preferencesExperimentsV1 := Table{
+		Name: "preferences_experiments",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "experiment_name", Type: DB_NVarchar, Length: 190, Nullable: false},
+			{Name: "variant_key", Type: DB_NVarchar, Length: 190, Nullable: false},
+			{Name: "value", Type: DB_NVarchar, Length: 255, Nullable: false},
+			{Name: "percentage", Type: DB_Int, Nullable: false},
+			{Name: "active", Type: DB_Bool, Nullable: false},
+			{Name: "created", Type: DB_DateTime, Nullable: false},
+			{Name: "updated", Type: DB_DateTime, Nullable: false},
+		},
+		Indices: []*Index{
+			{Cols: []string{"experiment_name"}, Type: UniqueIndex},
+		},
+	}
+
+	mg.AddMigration("create preferences_experiments table", NewAddTableMigration(preferencesExperimentsV1))
+	addTableIndicesMigrations(mg, "v1", preferencesExperimentsV1)
+
+	orgThemeConfigV1 := Table{
+		Name: "org_theme_config",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "org_id", Type: DB_BigInt, Nullable: false},
+			{Name: "policy", Type: DB_NVarchar, Length: 20, Nullable: false},
+			{Name: "default_theme", Type: DB_NVarchar, Length: 20, Nullable: false},
+			{Name: "updated", Type: DB_DateTime, Nullable: false},
+		},
+		Indices: []*Index{
+			{Cols: []string{"org_id"}, Type: UniqueIndex},
+		},
+	}
+
+	mg.AddMigration("create org_theme_config table", NewAddTableMigration(orgThemeConfigV1))
+	addTableIndicesMigrations(mg, "v1", orgThemeConfigV1)
 }
@@ -126,6 +126,9 @@ func (ss *SQLStore) DeleteOrg(ctx context.Context, cmd *models.DeleteOrgCommand)
 			"DELETE FROM dashboard_tag WHERE EXISTS (SELECT 1 FROM dashboard WHERE org_id = ? AND dashboard_tag.dashboard_id = dashboard.id)",
 			"DELETE FROM dashboard WHERE org_id = ?",
 			"DELETE FROM api_key WHERE org_id = ?",
+			"DELETE FROM service_tokens WHERE org_id = ?",
+			"DELETE FROM api_key_pool_member WHERE pool_id IN (SELECT id FROM api_key_pool WHERE org_id = ?)",
+			"DELETE FROM api_key_pool WHERE org_id = ?",
 			"DELETE FROM data_source WHERE org_id = ?",
 			"DELETE FROM org_user WHERE org_id = ?",
 			"DELETE FROM org WHERE id = ?",
@@ -150,6 +153,10 @@ func (ss *SQLStore) DeleteOrg(ctx context.Context, cmd *models.DeleteOrgCommand)
 			}
 		}
 
+		sess.publishAfterCommit(&events.OrgDeleted{
+			Timestamp: time.Now(),
+			Id:        cmd.Id,
+		})
 		return nil
 	})
 }
@@ -11,6 +11,7 @@ import (
 
 const (
 	alertRuleTarget = "alert_rule"
+	apiKeyTarget    = "api_key"
 	dashboardTarget = "dashboard"
 	filesTarget     = "file"
 )
@@ -56,6 +57,14 @@ func (ss *SQLStore) GetOrgQuotaByTarget(ctx context.Context, query *models.GetOr
 			used = resp[0].Count
 		}
 
+		if query.Target == apiKeyTarget {
+			tokens, err := countServiceTokens(sess, query.OrgId)
+			if err != nil {
+				return err
+			}
+			used += tokens
+		}
+
 		query.Result = &models.OrgQuotaDTO{
 			Target: query.Target,
 			Limit:  quota.Limit,
@@ -113,6 +122,13 @@ func (ss *SQLStore) GetOrgQuotas(ctx context.Context, query *models.GetOrgQuotas
 				}
 				used = resp[0].Count
 			}
+			if q.Target == apiKeyTarget {
+				tokens, err := countServiceTokens(sess, q.OrgId)
+				if err != nil {
+					return err
+				}
+				used += tokens
+			}
 			result[i] = &models.OrgQuotaDTO{
 				Target: q.Target,
 				Limit:  q.Limit,
@@ -304,6 +320,14 @@ func (ss *SQLStore) GetGlobalQuotaByTarget(ctx context.Context, query *models.Ge
 			used = resp[0].Count
 		}
 
+		if query.Target == apiKeyTarget {
+			tokens, err := countServiceTokens(sess, 0)
+			if err != nil {
+				return err
+			}
+			used += tokens
+		}
+
 		query.Result = &models.GlobalQuotaDTO{
 			Target: query.Target,
 			Limit:  query.Default,
@@ -313,3 +337,20 @@ func (ss *SQLStore) GetGlobalQuotaByTarget(ctx context.Context, query *models.Ge
 		return nil
 	})
 }
+
+// countServiceTokens returns the number of service tokens in the org, or in all
+// orgs if orgID is 0. Service tokens count towards the api_key quota.
+func countServiceTokens(sess *DBSession, orgID int64) (int64, error) {
+	rawSQL := "SELECT COUNT(*) AS count FROM service_tokens"
+	var args []interface{}
+	if orgID != 0 {
+		rawSQL += " WHERE org_id=?"
+		args = append(args, orgID)
+	}
+
+	resp := make([]*targetCount, 0)
+	if err := sess.SQL(rawSQL, args...).Find(&resp); err != nil {
+		return 0, err
+	}
+	return resp[0].Count, nil
+}
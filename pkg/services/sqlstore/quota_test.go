@@ -235,6 +235,25 @@ func TestIntegrationQuotaCommandsAndQueries(t *testing.T) {
 		require.Equal(t, int64(0), query.Result.Used)
 	})
 
+	t.Run("Should count service tokens towards api key quota", func(t *testing.T) {
+		err := sqlStore.WithDbSession(context.Background(), func(sess *DBSession) error {
+			_, err := sess.Exec(`INSERT INTO service_tokens (org_id, service_account_id, name, "key", created) VALUES (?, ?, ?, ?, ?)`,
+				orgId, 10, "token", "hash", time.Now())
+			return err
+		})
+		require.NoError(t, err)
+
+		orgQuery := models.GetOrgQuotaByTargetQuery{OrgId: orgId, Target: apiKeyTarget, Default: 5}
+		err = sqlStore.GetOrgQuotaByTarget(context.Background(), &orgQuery)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), orgQuery.Result.Used)
+
+		globalQuery := models.GetGlobalQuotaByTargetQuery{Target: apiKeyTarget, Default: 5}
+		err = sqlStore.GetGlobalQuotaByTarget(context.Background(), &globalQuery)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), globalQuery.Result.Used)
+	})
+
 	// related: https://github.com/grafana/grafana/issues/14342
 	t.Run("Should org quota updating is successful even if it called multiple time", func(t *testing.T) {
 		orgCmd := models.UpdateOrgQuotaCmd{
@@ -92,6 +92,10 @@ func (gtx *SessionTx) Get(ctx context.Context, dest interface{}, query string, a
 	return gtx.sqlxtx.GetContext(ctx, dest, gtx.sqlxtx.Rebind(query), args...)
 }
 
+func (gtx *SessionTx) Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return gtx.sqlxtx.SelectContext(ctx, dest, gtx.sqlxtx.Rebind(query), args...)
+}
+
 func (gtx *SessionTx) driverName() string {
 	return gtx.sqlxtx.DriverName()
 }
@@ -490,7 +490,15 @@ func deleteUserInTransaction(ss *SQLStore, sess *DBSession, cmd *models.DeleteUs
 		}
 	}
 
-	return deleteUserAccessControl(sess, cmd.UserId)
+	if err := deleteUserAccessControl(sess, cmd.UserId); err != nil {
+		return err
+	}
+
+	sess.publishAfterCommit(&events.UserDeleted{
+		Timestamp: time.Now(),
+		Id:        cmd.UserId,
+	})
+	return nil
 }
 
 func deleteUserAccessControl(sess *DBSession, userID int64) error {
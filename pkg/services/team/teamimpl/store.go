@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/infra/db"
 	"github.com/grafana/grafana/pkg/models"
 	ac "github.com/grafana/grafana/pkg/services/accesscontrol"
@@ -157,9 +158,16 @@ func (ss *xormStore) Delete(ctx context.Context, cmd *models.DeleteTeamCommand)
 			}
 		}
 
-		_, err := sess.Exec("DELETE FROM permission WHERE scope=?", ac.Scope("teams", "id", fmt.Sprint(cmd.Id)))
+		if _, err := sess.Exec("DELETE FROM permission WHERE scope=?", ac.Scope("teams", "id", fmt.Sprint(cmd.Id))); err != nil {
+			return err
+		}
 
-		return err
+		sess.PublishAfterCommit(&events.TeamDeleted{
+			Timestamp: time.Now(),
+			Id:        cmd.Id,
+			OrgId:     cmd.OrgId,
+		})
+		return nil
 	})
 }
 
@@ -210,8 +210,23 @@ type SignedInUser struct {
 	HelpFlags1         HelpFlags1
 	LastSeenAt         time.Time
 	Teams              []int64
+	// IsImpersonated is set when the user was signed in on behalf of the
+	// admin with ID ImpersonatedBy.
+	IsImpersonated bool
+	ImpersonatedBy int64
+	// OAuthScopes are the OAuth2-style scopes of the API key the user signed
+	// in with, if any.
+	OAuthScopes []string `json:"-"`
 	// Permissions grouped by orgID and actions
 	Permissions map[int64]map[string][]string `json:"-"`
+	// Scopes only granted while their conditions hold, grouped by orgID and actions
+	PermissionConditions map[int64]map[string][]ConditionalScope `json:"-"`
+}
+
+// ConditionalScope is a scope that is only granted while its conditions hold.
+type ConditionalScope struct {
+	Scope      string
+	Conditions map[string]string
 }
 
 func (u *User) NameOrFallback() string {
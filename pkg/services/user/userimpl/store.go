@@ -97,10 +97,16 @@ func (ss *sqlStore) Get(ctx context.Context, usr *user.User) (*user.User, error)
 }
 
 func (ss *sqlStore) Delete(ctx context.Context, userID int64) error {
-	err := ss.db.WithDbSession(ctx, func(sess *db.Session) error {
+	err := ss.db.WithTransactionalDbSession(ctx, func(sess *db.Session) error {
 		var rawSQL = "DELETE FROM " + ss.dialect.Quote("user") + " WHERE id = ?"
-		_, err := sess.Exec(rawSQL, userID)
-		return err
+		if _, err := sess.Exec(rawSQL, userID); err != nil {
+			return err
+		}
+		sess.PublishAfterCommit(&events.UserDeleted{
+			Timestamp: time.Now(),
+			Id:        userID,
+		})
+		return nil
 	})
 	if err != nil {
 		return err
@@ -363,6 +363,15 @@ type Cfg struct {
 	EditorsCanAdmin bool
 
 	ApiKeyMaxSecondsToLive int64
+	ApiKeyHashAlgorithm    string
+	ApiKeyMinEntropy       float64
+	ApiKeyWebhookURL       string
+	ApiKeyWebhookSecret    string
+	// ApiKeyIntrospectionClientID and ApiKeyIntrospectionClientSecret are the
+	// basic auth credentials of the API key introspection endpoint, which is
+	// disabled unless both are set.
+	ApiKeyIntrospectionClientID     string
+	ApiKeyIntrospectionClientSecret string
 
 	// Check if a feature toggle is enabled
 	// @deprecated
@@ -425,6 +434,18 @@ type Cfg struct {
 	DefaultLocale string
 	HomePage      string
 
+	// PreferencesBackend is where user, team and org preferences are stored, "sql" or "redis".
+	PreferencesBackend  string
+	PreferencesRedisURL string
+	// PreferencesHistoryDepth is how many saved versions of each preference are kept.
+	PreferencesHistoryDepth int
+	// PreferencesBulkBatchSize is how many preferences a bulk set updates per statement.
+	PreferencesBulkBatchSize int
+	// PreferencesCacheTTL is how long preferences read from the store are cached in memory, 0 disables the cache.
+	PreferencesCacheTTL time.Duration
+	// PreferencesEncryptedFields are the JSON data fields of preferences encrypted in storage.
+	PreferencesEncryptedFields []string
+
 	AutoAssignOrg              bool
 	AutoAssignOrgId            int
 	AutoAssignOrgRole          string
@@ -472,6 +493,17 @@ type Cfg struct {
 	RBACPermissionCache bool
 	// Enable Permission validation during role creation and provisioning
 	RBACPermissionValidationEnabled bool
+	// Number of consecutive failures loading permissions before the circuit
+	// breaker opens. 0 disables the circuit breaker.
+	ACCircuitBreakerMaxFailures uint32
+	// Duration the circuit breaker stays open before letting a request through.
+	ACCircuitBreakerTimeout time.Duration
+	// Age after which loaded permissions are no longer served while the
+	// circuit breaker is open.
+	ACCircuitBreakerFallbackMaxAge time.Duration
+	// Window during which concurrent permission requests for the same user are
+	// coalesced into one. 0 disables coalescing.
+	ACPermissionsCoalescingWindow time.Duration
 	// GRPC Server.
 	GRPCServerNetwork   string
 	GRPCServerAddress   string
@@ -1322,6 +1354,12 @@ func readAuthSettings(iniFile *ini.File, cfg *Cfg) (err error) {
 	}
 
 	cfg.ApiKeyMaxSecondsToLive = auth.Key("api_key_max_seconds_to_live").MustInt64(-1)
+	cfg.ApiKeyHashAlgorithm = valueAsString(auth, "api_key_hash_algorithm", "pbkdf2")
+	cfg.ApiKeyMinEntropy = auth.Key("api_key_min_entropy").MustFloat64(3.5)
+	cfg.ApiKeyWebhookURL = valueAsString(auth, "api_key_webhook_url", "")
+	cfg.ApiKeyWebhookSecret = valueAsString(auth, "api_key_webhook_secret", "")
+	cfg.ApiKeyIntrospectionClientID = valueAsString(auth, "introspection_client_id", "")
+	cfg.ApiKeyIntrospectionClientSecret = valueAsString(auth, "introspection_client_secret", "")
 
 	cfg.TokenRotationIntervalMinutes = auth.Key("token_rotation_interval_minutes").MustInt(10)
 	if cfg.TokenRotationIntervalMinutes < 2 {
@@ -1412,6 +1450,10 @@ func readAccessControlSettings(iniFile *ini.File, cfg *Cfg) {
 	cfg.RBACEnabled = rbac.Key("enabled").MustBool(true)
 	cfg.RBACPermissionCache = rbac.Key("permission_cache").MustBool(true)
 	cfg.RBACPermissionValidationEnabled = rbac.Key("permission_validation_enabled").MustBool(false)
+	cfg.ACCircuitBreakerMaxFailures = uint32(rbac.Key("circuit_breaker_max_failures").MustUint(5))
+	cfg.ACCircuitBreakerTimeout = rbac.Key("circuit_breaker_timeout").MustDuration(30 * time.Second)
+	cfg.ACCircuitBreakerFallbackMaxAge = rbac.Key("circuit_breaker_fallback_max_age").MustDuration(time.Minute)
+	cfg.ACPermissionsCoalescingWindow = rbac.Key("permissions_coalescing_window").MustDuration(0)
 }
 
 func readUserSettings(iniFile *ini.File, cfg *Cfg) error {
@@ -1433,6 +1475,15 @@ func readUserSettings(iniFile *ini.File, cfg *Cfg) error {
 	cfg.DefaultTheme = valueAsString(users, "default_theme", "")
 	cfg.DefaultLocale = valueAsString(users, "default_locale", "")
 	cfg.HomePage = valueAsString(users, "home_page", "")
+	cfg.PreferencesBackend = users.Key("preferences_backend").In("sql", []string{"sql", "redis"})
+	cfg.PreferencesRedisURL = valueAsString(users, "preferences_redis_url", "")
+	cfg.PreferencesHistoryDepth = users.Key("preferences_history_depth").MustInt(10)
+	cfg.PreferencesBulkBatchSize = users.Key("preferences_bulk_batch_size").MustInt(1000)
+	cfg.PreferencesCacheTTL = users.Key("preferences_cache_ttl").MustDuration(0)
+	cfg.PreferencesEncryptedFields = util.SplitString(valueAsString(users, "preferences_encrypted_fields", ""))
+	if cfg.PreferencesBackend == "redis" && cfg.PreferencesRedisURL == "" {
+		return errors.New("preferences_redis_url must be set when preferences_backend is redis")
+	}
 	ExternalUserMngLinkUrl = valueAsString(users, "external_manage_link_url", "")
 	ExternalUserMngLinkName = valueAsString(users, "external_manage_link_name", "")
 	ExternalUserMngInfo = valueAsString(users, "external_manage_info", "")